@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// aclField is the metadata key listing the roles allowed to read a document.
+// Documents without it are readable by everyone.
+const aclField = "acl"
+
+// opReadableBy is the Condition operator matching documents whose field is
+// absent or shares an element with the condition's []string value. It backs
+// role-based access control and cannot be written in ParseFilter syntax.
+const opReadableBy = "readable_by"
+
+// ReadableBy is a condition matching documents that a caller with roles may
+// read: those without an ACL and those whose ACL lists one of the roles.
+func ReadableBy(roles []string) Condition {
+	return Condition{Field: aclField, Op: opReadableBy, Value: append([]string{}, roles...)}
+}
+
+// parseACL normalizes an ACL from metadata, which is a list of roles or, as
+// CSV and JSONL records give it, a comma-separated string. It returns nil for
+// a missing or empty ACL.
+func parseACL(value any) []string {
+	var roles []string
+	switch v := value.(type) {
+	case string:
+		roles = strings.Split(v, ",")
+	case []string:
+		roles = v
+	case []any:
+		for _, role := range v {
+			roles = append(roles, fmt.Sprint(role))
+		}
+	}
+	var acl []string
+	for _, role := range roles {
+		if role = strings.TrimSpace(role); role != "" && !slices.Contains(acl, role) {
+			acl = append(acl, role)
+		}
+	}
+	return acl
+}
+
+// readableBy reports whether a caller with roles may read a document with
+// the given metadata.
+func readableBy(metadata map[string]any, roles []string) bool {
+	return aclAllows(metadata[aclField], roles)
+}
+
+// aclAllows reports whether an ACL value, nil when absent, admits one of
+// roles. An absent or empty ACL admits everyone.
+func aclAllows(value any, roles []string) bool {
+	acl := parseACL(value)
+	if len(acl) == 0 {
+		return true
+	}
+	for _, role := range roles {
+		if slices.Contains(acl, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// queryReadableBy reports whether every context document of a recorded query
+// is readable with roles, so its answer cannot reveal restricted content.
+func queryReadableBy(record QueryRecord, roles []string) bool {
+	for _, doc := range record.Documents {
+		if !readableBy(doc.Metadata, roles) {
+			return false
+		}
+	}
+	return true
+}
+
+// aclStore restricts searches of a VectorStore to documents readable by a
+// set of roles. Writes pass through unchanged.
+type aclStore struct {
+	VectorStore
+	roles []string
+}
+
+// aclDedupStore is an aclStore over a store that supports incremental
+// re-ingestion.
+type aclDedupStore struct {
+	*aclStore
+	DedupStore
+}
+
+// NewACLStore returns a view of store whose searches only return documents
+// readable by a caller with roles.
+func NewACLStore(store VectorStore, roles []string) VectorStore {
+	a := &aclStore{VectorStore: store, roles: slices.Clone(roles)}
+	if dedup, ok := store.(DedupStore); ok {
+		return &aclDedupStore{aclStore: a, DedupStore: dedup}
+	}
+	return a
+}
+
+func (a *aclStore) SearchSimilar(ctx context.Context, query string, limit int, filter Filter) []Document {
+	scoped := append(slices.Clip(filter), ReadableBy(a.roles))
+	docs := a.VectorStore.SearchSimilar(ctx, query, limit, scoped)
+	kept := docs[:0]
+	for _, doc := range docs {
+		// Stores enforce the filter; checking again guards against a backend
+		// that ignored it.
+		if readableBy(doc.Metadata, a.roles) {
+			kept = append(kept, doc)
+		}
+	}
+	return kept
+}
+
+// ForRoles returns a copy of the engine that only retrieves documents whose
+// ACL lists one of roles, or that have no ACL, and only shows recorded
+// queries whose context it could have retrieved. A caller without roles only
+// sees unrestricted documents.
+func (r *RAGEngine) ForRoles(roles []string) *RAGEngine {
+	scoped := *r
+	scoped.store = NewACLStore(r.store, roles)
+	scoped.roles = append([]string{}, roles...)
+	return &scoped
+}
+
+// canRead reports whether the engine's caller may see a recorded query.
+func (r *RAGEngine) canRead(record QueryRecord) bool {
+	return r.roles == nil || queryReadableBy(record, r.roles)
+}
+
+// WithIngestACL returns a copy of the engine that restricts the chunks it
+// ingests to roles, unless a page sets its own ACL in its metadata.
+func (r *RAGEngine) WithIngestACL(roles []string) *RAGEngine {
+	scoped := *r
+	scoped.ingestACL = parseACL(roles)
+	return &scoped
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseACL(t *testing.T) {
+	cases := []struct {
+		value any
+		want  []string
+	}{
+		{nil, nil},
+		{"", nil},
+		{" hr, finance ,hr", []string{"hr", "finance"}},
+		{[]string{"hr"}, []string{"hr"}},
+		{[]any{"hr", "legal"}, []string{"hr", "legal"}},
+	}
+	for _, c := range cases {
+		if got := parseACL(c.value); !slices.Equal(got, c.want) {
+			t.Errorf("parseACL(%#v) = %v, want %v", c.value, got, c.want)
+		}
+	}
+}
+
+func TestForRolesRestrictsRetrieval(t *testing.T) {
+	ctx := context.Background()
+	engine := NewRAGEngine(&dummyOpenAI{}, NewMemoryStore(NewHashingEmbedder(256)))
+	pages := []Page{
+		{Text: "Salaries are reviewed every March.", Source: "salaries.md", Metadata: map[string]any{"acl": "hr,finance"}},
+		{Text: "The office opens every morning at nine.", Source: "office.md"},
+	}
+	if _, ok := ingestPages(ctx, engine, pages, 1000, 0); !ok {
+		t.Fatal("ingestion failed")
+	}
+	if _, ok := ingestPages(ctx, engine.WithIngestACL([]string{"legal"}), []Page{{Text: "Contracts are reviewed every quarter.", Source: "contracts.md"}}, 1000, 0); !ok {
+		t.Fatal("ingestion failed")
+	}
+
+	sources := func(e *RAGEngine) []string {
+		var found []string
+		for _, doc := range e.Retrieve(ctx, "when are things reviewed", 10) {
+			found = append(found, doc.Source)
+		}
+		slices.Sort(found)
+		return found
+	}
+	cases := []struct {
+		engine *RAGEngine
+		want   []string
+	}{
+		{engine, []string{"contracts.md", "office.md", "salaries.md"}},
+		{engine.ForRoles([]string{"finance"}), []string{"office.md", "salaries.md"}},
+		{engine.ForRoles([]string{"legal", "hr"}), []string{"contracts.md", "office.md", "salaries.md"}},
+		{engine.ForRoles(nil), []string{"office.md"}},
+	}
+	for i, c := range cases {
+		if got := sources(c.engine); !slices.Equal(got, c.want) {
+			t.Errorf("case %d: retrieved %v, want %v", i, got, c.want)
+		}
+	}
+
+	// Re-ingesting with a changed ACL replaces the chunk rather than skipping it.
+	pages[0].Metadata = map[string]any{"acl": []string{"hr"}}
+	report, _ := ingestPages(ctx, engine, pages[:1], 1000, 0)
+	if report.Updated != 1 {
+		t.Fatalf("expected the chunk to be updated, got %+v", report)
+	}
+	if got := sources(engine.ForRoles([]string{"finance"})); !slices.Equal(got, []string{"office.md"}) {
+		t.Fatalf("expected finance to lose access, got %v", got)
+	}
+}
+
+func TestReadableByTranslations(t *testing.T) {
+	filter := Filter{ReadableBy([]string{"hr", "finance"})}
+	expected := `(not exists metadata["acl"] || json_contains_any(metadata["acl"], ["hr", "finance"]))`
+	if got := filter.milvusExpr(false); got != expected {
+		t.Errorf("milvusExpr = %s", got)
+	}
+
+	where, args := filter.sqlWhere([]any{"[0.1]"})
+	expected = "WHERE (NOT jsonb_exists(metadata, $2) OR jsonb_exists_any(metadata -> $2, ARRAY(SELECT jsonb_array_elements_text($3::jsonb))))"
+	if where != expected || args[1] != "acl" || args[2] != `["hr","finance"]` {
+		t.Errorf("sqlWhere = %s %v", where, args)
+	}
+
+	native, _ := filter.qdrantFilter()
+	data, _ := json.Marshal(native)
+	expected = `{"must":[{"should":[{"is_empty":{"key":"metadata.acl"}},{"key":"metadata.acl","match":{"any":["hr","finance"]}}]}]}`
+	if string(data) != expected {
+		t.Errorf("qdrantFilter = %s", data)
+	}
+
+	// A caller without roles still sends an empty list, never null.
+	if _, args := (Filter{ReadableBy(nil)}).sqlWhere(nil); args[1] != "[]" {
+		t.Errorf("expected an empty role list, got %v", args)
+	}
+}
+
+func TestServerAppliesKeyRoles(t *testing.T) {
+	server, _ := newTestServer()
+	server.engine.history = newTestHistory(t)
+	err := server.RequireAPIKeys([]APIKey{
+		{Key: "hr-key", Tenant: "acme", Roles: []string{"hr"}},
+		{Key: "staff-key", Tenant: "acme"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-API-Key", key)
+		req.Header.Set("X-Request-ID", "req-"+key)
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec
+	}
+
+	body := `{"documents":[{"text":"Salaries are reviewed every March.","source":"salaries","metadata":{"acl":["hr"]}}]}`
+	if rec := do(http.MethodPost, "/documents", "hr-key", body); rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	for _, key := range []string{"hr-key", "staff-key"} {
+		if rec := do(http.MethodPost, "/query", key, `{"question":"When are salaries reviewed?"}`); rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+		}
+	}
+
+	var entry historyEntryJSON
+	json.NewDecoder(do(http.MethodGet, "/history/req-staff-key", "staff-key", "").Body).Decode(&entry)
+	if len(entry.Documents) != 0 {
+		t.Fatalf("expected staff to get no restricted context, got %+v", entry.Documents)
+	}
+	if rec := do(http.MethodGet, "/history/req-hr-key", "staff-key", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected staff not to see an answer built from restricted documents, got %d", rec.Code)
+	}
+	var list struct {
+		Queries []historyEntryJSON `json:"queries"`
+	}
+	json.NewDecoder(do(http.MethodGet, "/history", "staff-key", "").Body).Decode(&list)
+	if len(list.Queries) != 1 || list.Queries[0].ID != "req-staff-key" {
+		t.Fatalf("expected staff to list only its own query, got %+v", list.Queries)
+	}
+	if rec := do(http.MethodGet, "/history/req-hr-key", "hr-key", ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected hr to see its query, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxAgentRounds caps the search rounds of AnswerIterative.
+const maxAgentRounds = 5
+
+// Verdicts of a retrieval round.
+const (
+	VerdictSufficient   = "sufficient"
+	VerdictInsufficient = "insufficient"
+)
+
+// RetrievalRound is one search of AnswerIterative and the model's verdict on
+// the context gathered up to it.
+type RetrievalRound struct {
+	Query     string
+	Documents []Document // retrieved by Query
+	New       int        // how many of Documents earlier rounds had not found
+	// Verdict is VerdictSufficient or VerdictInsufficient, or empty for the
+	// last allowed round, which is not assessed.
+	Verdict string
+	Reason  string // the model's explanation of the verdict
+}
+
+// AnswerIterative answers question in an agentic retrieval loop: after each
+// search, the model judges whether the documents found so far answer the
+// question, and if not writes a refined query for what is missing, for at
+// most rounds searches. The answer is generated from the documents of every
+// round, and Answer.Rounds traces the searches and verdicts. If an
+// assessment fails, the loop stops and answers with what it has.
+func (r *RAGEngine) AnswerIterative(ctx context.Context, question string, limit int, model string, rounds int, opts ...RetrieveOption) (Answer, error) {
+	ctx, span := tracer.Start(ctx, "rag.agentic", trace.WithAttributes(attribute.Int("rag.max_rounds", rounds)))
+	defer span.End()
+	if refusal, ok := r.OutOfScope(ctx, question); ok {
+		return refusal, nil
+	}
+
+	var done []RetrievalRound
+	var docs []Document
+	seen := make(map[string]bool)
+	searched := make(map[string]bool)
+	query := question
+	for i := 1; ; i++ {
+		searched[strings.ToLower(query)] = true
+		round := RetrievalRound{Query: query, Documents: r.Retrieve(ctx, query, limit, opts...)}
+		for _, doc := range round.Documents {
+			if key := documentKey(doc); !seen[key] {
+				seen[key] = true
+				docs = append(docs, doc)
+				round.New++
+			}
+		}
+		if i >= rounds {
+			done = append(done, round)
+			break
+		}
+		sufficient, next, reason, err := r.assessContext(ctx, question, docs, model)
+		round.Reason = reason
+		if err != nil {
+			slog.WarnContext(ctx, "Could not assess the retrieved context, answering with it", "error", err)
+			done = append(done, round)
+			break
+		}
+		round.Verdict = VerdictInsufficient
+		if sufficient {
+			round.Verdict = VerdictSufficient
+		}
+		done = append(done, round)
+		slog.InfoContext(ctx, "Retrieval round", "round", i, "query", query, "documents", len(round.Documents), "new", round.New, "verdict", round.Verdict)
+		if sufficient {
+			break
+		}
+		if searched[strings.ToLower(next)] {
+			slog.InfoContext(ctx, "The refined query repeats an earlier search, answering with the context found", "query", next)
+			break
+		}
+		query = next
+	}
+	span.SetAttributes(attribute.Int("rag.rounds", len(done)), attribute.Int("rag.documents", len(docs)))
+
+	answer, err := r.generate(ctx, question, docs, model, nil, nil)
+	if err != nil {
+		return answer, err
+	}
+	answer.Rounds = done
+	return answer, nil
+}
+
+// assessContext asks model whether docs answer question. If not, it returns
+// a search query for the missing information. The reason is the model's
+// explanation either way.
+func (r *RAGEngine) assessContext(ctx context.Context, question string, docs []Document, model string) (sufficient bool, query, reason string, err error) {
+	ctx, span := tracer.Start(ctx, "rag.assess_context", trace.WithAttributes(attribute.Int("rag.documents", len(docs))))
+	defer func() { endSpan(span, err) }()
+
+	contextText := formatContext(docs)
+	if contextText == "" {
+		contextText = "(no documents found)"
+	}
+	prompt := "Decide whether the context below holds enough information to answer the question fully. " +
+		"Reply with exactly two lines. If it does, write\nANSWER\n<why the context is enough>\n" +
+		"If it does not, write\nSEARCH: <a search query for the missing information, different from the question>\n" +
+		"<what is missing>\n\n" +
+		"Context:\n" + contextText + "\n\nQuestion: " + question
+	messages := []Message{
+		{Role: "system", Content: "You check whether retrieved documents answer a question, and write search queries for what they lack."},
+		{Role: "user", Content: prompt},
+	}
+	response, err := r.llm.ChatCompletion(ctx, model, messages)
+	if err != nil {
+		return false, "", "", err
+	}
+	verdict, reason, _ := strings.Cut(strings.TrimSpace(response), "\n")
+	verdict = strings.TrimSpace(verdict)
+	reason = strings.TrimSpace(reason)
+	if strings.EqualFold(strings.Trim(verdict, ".* "), "ANSWER") {
+		return true, "", reason, nil
+	}
+	if len(verdict) >= len("SEARCH:") && strings.EqualFold(verdict[:len("SEARCH:")], "SEARCH:") {
+		if query = strings.Trim(strings.TrimSpace(verdict[len("SEARCH:"):]), "\""); query != "" {
+			return false, query, reason, nil
+		}
+	}
+	return false, "", "", fmt.Errorf("unexpected assessment %q", truncateText(verdict, 80))
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAnswerIterativeSearchesUntilSufficient(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(NewHashingEmbedder(256))
+	oa := &sequenceOpenAI{replies: []string{
+		"SEARCH: Pro plan price per month\nThe context gives the Pro plan's rate limit but not its price.",
+		"ANSWER\nBoth the limit and the price are in the context.",
+		"The Pro plan allows 100 requests per second [1] and costs $49 a month [2].",
+	}}
+	engine := NewRAGEngine(oa, store)
+	ingestPages(ctx, engine, []Page{
+		{Text: "The Pro plan rate limit is 100 requests per second.", Source: "limits.md"},
+		{Text: "Pricing: the Pro plan price is $49 per month.", Source: "pricing.md"},
+	}, 1000, 0)
+
+	answer, err := engine.AnswerIterative(ctx, "What rate limit does the Pro plan have?", 1, "gpt-test", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(answer.Rounds) != 2 || len(answer.Citations) != 2 {
+		t.Fatalf("unexpected answer %+v", answer)
+	}
+	first, second := answer.Rounds[0], answer.Rounds[1]
+	if first.Verdict != VerdictInsufficient || !strings.Contains(first.Reason, "not its price") || first.Documents[0].Source != "limits.md" {
+		t.Errorf("unexpected first round %+v", first)
+	}
+	if second.Query != "Pro plan price per month" || second.Verdict != VerdictSufficient || second.New != 1 || second.Documents[0].Source != "pricing.md" {
+		t.Errorf("unexpected second round %+v", second)
+	}
+	if prompt := oa.calls[1][1].Content; !strings.Contains(prompt, "[2] Source: pricing.md") {
+		t.Errorf("expected the second assessment to see both rounds' documents, got %q", prompt)
+	}
+}
+
+func TestAnswerIterativeStopsAtTheRoundLimit(t *testing.T) {
+	oa := &sequenceOpenAI{replies: []string{
+		"SEARCH: second query\nmissing",
+		"SEARCH: third query\nstill missing",
+		"I don't know.",
+	}}
+	mv := &dummyMilvus{}
+	answer, err := NewRAGEngine(oa, mv).AnswerIterative(context.Background(), "q", 3, "gpt-test", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(answer.Rounds) != 2 || answer.Rounds[1].Query != "second query" || answer.Rounds[1].Verdict != "" || len(oa.calls) != 2 {
+		t.Fatalf("expected two searches and one assessment, got %+v after %d calls", answer.Rounds, len(oa.calls))
+	}
+
+	// A malformed assessment ends the loop.
+	oa = &sequenceOpenAI{replies: []string{"Maybe?", "An answer."}}
+	answer, _ = NewRAGEngine(oa, mv).AnswerIterative(context.Background(), "q", 3, "gpt-test", 4)
+	if len(answer.Rounds) != 1 || answer.Text != "An answer." {
+		t.Fatalf("unexpected answer %+v", answer)
+	}
+}
+
+func TestServerTracesAgenticRounds(t *testing.T) {
+	server, _ := newTestServer()
+	server.engine.llm = &sequenceOpenAI{replies: []string{"ANSWER\nenough", "Go was made at Google."}}
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"question":"Who made Go?","max_rounds":3}`)))
+	var resp queryResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("unexpected response %d: %v", rec.Code, err)
+	}
+	if len(resp.Rounds) != 1 || resp.Rounds[0].Verdict != VerdictSufficient || resp.Rounds[0].Reason != "enough" {
+		t.Fatalf("unexpected rounds %+v", resp.Rounds)
+	}
+
+	for _, body := range []string{`{"question":"q","max_rounds":9}`, `{"question":"q","max_rounds":2,"decompose":true}`} {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, rec.Code)
+		}
+	}
+}
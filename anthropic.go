@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	anthropicBaseURL    = "https://api.anthropic.com/v1"
+	anthropicAPIVersion = "2023-06-01"
+	anthropicMaxTokens  = 1024
+)
+
+// AnthropicClient implements the LLMClient interface using the Anthropic
+// Messages API.
+type AnthropicClient struct {
+	apiKey     string
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewAnthropicClient creates a client authenticated with the given API key.
+func NewAnthropicClient(apiKey string) *AnthropicClient {
+	return &AnthropicClient{
+		apiKey:     apiKey,
+		baseURL:    anthropicBaseURL,
+		httpClient: http.DefaultClient,
+	}
+}
+
+// Ping lists one model, which fails unless the API key is valid.
+func (a *AnthropicClient) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.baseURL+"/models?limit=1", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("x-api-key", a.apiKey)
+	req.Header.Set("anthropic-version", anthropicAPIVersion)
+	return pingHTTP(a.httpClient, req, "Anthropic")
+}
+
+type anthropicMessage struct {
+	Role    string `json:"role"`
+	Content any    `json:"content"` // text, or a list of content blocks
+}
+
+type anthropicRequest struct {
+	Model     string             `json:"model"`
+	MaxTokens int                `json:"max_tokens"`
+	System    string             `json:"system,omitempty"`
+	Messages  []anthropicMessage `json:"messages"`
+	// Temperature is set to 0 in deterministic mode; the API has no seed.
+	Temperature *float64        `json:"temperature,omitempty"`
+	Tools       []anthropicTool `json:"tools,omitempty"`
+}
+
+type anthropicTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	InputSchema map[string]any `json:"input_schema"`
+}
+
+// anthropicBlock is a content block of a request or reply: text, a tool
+// call (tool_use), or its result (tool_result).
+type anthropicBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+type anthropicResponse struct {
+	Content []anthropicBlock `json:"content"`
+	Usage   struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (a *AnthropicClient) ChatCompletion(ctx context.Context, model string, messages []Message) (string, error) {
+	return a.send(ctx, anthropicChatRequest(model, messages))
+}
+
+// ChatCompletionTools offers tools to Claude. Tool calls come back as
+// tool_use blocks, and their results go in as tool_result blocks of a user
+// message.
+func (a *AnthropicClient) ChatCompletionTools(ctx context.Context, model string, messages []Message, tools []Tool) (Message, error) {
+	req := anthropicChatRequest(model, messages)
+	for _, tool := range tools {
+		req.Tools = append(req.Tools, anthropicTool{Name: tool.Name, Description: tool.Description, InputSchema: toolParameters(tool)})
+	}
+	resp, err := a.post(ctx, req)
+	if err != nil {
+		return Message{}, err
+	}
+	reply := Message{Role: "assistant"}
+	for _, block := range resp.Content {
+		switch block.Type {
+		case "text":
+			reply.Content += block.Text
+		case "tool_use":
+			reply.ToolCalls = append(reply.ToolCalls, ToolCall{ID: block.ID, Name: block.Name, Arguments: block.Input})
+		}
+	}
+	return reply, nil
+}
+
+// anthropicChatRequest converts messages to a request. Anthropic takes the
+// system prompt as a top-level field rather than a message, and consecutive
+// tool results as the blocks of one user message.
+func anthropicChatRequest(model string, messages []Message) anthropicRequest {
+	req := anthropicRequest{Model: model, MaxTokens: anthropicMaxTokens}
+	var system []string
+	for _, msg := range messages {
+		switch {
+		case msg.Role == "system":
+			system = append(system, msg.Content)
+		case msg.Role == "tool":
+			result := anthropicBlock{Type: "tool_result", ToolUseID: msg.ToolCallID, Content: msg.Content}
+			if last := len(req.Messages) - 1; last >= 0 && req.Messages[last].Role == "user" {
+				if blocks, ok := req.Messages[last].Content.([]anthropicBlock); ok && blocks[0].Type == "tool_result" {
+					req.Messages[last].Content = append(blocks, result)
+					continue
+				}
+			}
+			req.Messages = append(req.Messages, anthropicMessage{Role: "user", Content: []anthropicBlock{result}})
+		case len(msg.ToolCalls) > 0:
+			var blocks []anthropicBlock
+			if msg.Content != "" {
+				blocks = append(blocks, anthropicBlock{Type: "text", Text: msg.Content})
+			}
+			for _, call := range msg.ToolCalls {
+				blocks = append(blocks, anthropicBlock{Type: "tool_use", ID: call.ID, Name: call.Name, Input: toolArguments(call.Arguments)})
+			}
+			req.Messages = append(req.Messages, anthropicMessage{Role: msg.Role, Content: blocks})
+		default:
+			req.Messages = append(req.Messages, anthropicMessage{Role: msg.Role, Content: msg.Content})
+		}
+	}
+	req.System = strings.Join(system, "\n\n")
+	return req
+}
+
+// DescribeImage sends the image as a base64 content block ahead of prompt.
+func (a *AnthropicClient) DescribeImage(ctx context.Context, model string, image Image, prompt string) (string, error) {
+	content := []map[string]any{
+		{"type": "image", "source": map[string]any{"type": "base64", "media_type": image.MIMEType, "data": base64.StdEncoding.EncodeToString(image.Data)}},
+		{"type": "text", "text": prompt},
+	}
+	return a.send(ctx, anthropicRequest{Model: model, MaxTokens: anthropicMaxTokens, Messages: []anthropicMessage{{Role: "user", Content: content}}})
+}
+
+// send posts a request to the Messages API and returns the text of the reply.
+func (a *AnthropicClient) send(ctx context.Context, req anthropicRequest) (string, error) {
+	parsed, err := a.post(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	var text strings.Builder
+	for _, block := range parsed.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	if text.Len() == 0 {
+		return "", fmt.Errorf("no response from Anthropic")
+	}
+	return text.String(), nil
+}
+
+// post sends a request to the Messages API and decodes the reply.
+func (a *AnthropicClient) post(ctx context.Context, req anthropicRequest) (*anthropicResponse, error) {
+	if _, ok := deterministicSeed(ctx); ok {
+		req.Temperature = new(float64)
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, a.baseURL+"/messages", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-api-key", a.apiKey)
+	httpReq.Header.Set("anthropic-version", anthropicAPIVersion)
+
+	resp, err := a.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed anthropicResponse
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, fmt.Errorf("decoding Anthropic response (status %d): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if parsed.Error != nil {
+			return nil, fmt.Errorf("Anthropic API error (status %d): %s", resp.StatusCode, parsed.Error.Message)
+		}
+		return nil, fmt.Errorf("Anthropic API error (status %d)", resp.StatusCode)
+	}
+	recordTokenUsage(ctx, parsed.Usage.InputTokens, parsed.Usage.OutputTokens)
+	return &parsed, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAnthropicClientSendsSystemPromptSeparately(t *testing.T) {
+	var got anthropicRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/messages" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if r.Header.Get("x-api-key") != "test-key" {
+			t.Errorf("api key header not set")
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		w.Write([]byte(`{"content":[{"type":"text","text":"hello"}]}`))
+	}))
+	defer server.Close()
+
+	client := NewAnthropicClient("test-key")
+	client.baseURL = server.URL
+	resp, err := client.ChatCompletion(context.Background(), "claude-test", []Message{
+		{Role: "system", Content: "be nice"},
+		{Role: "user", Content: "hi"},
+	})
+	if err != nil {
+		t.Fatalf("ChatCompletion returned error: %v", err)
+	}
+	if resp != "hello" {
+		t.Fatalf("unexpected response: %s", resp)
+	}
+	if got.System != "be nice" {
+		t.Fatalf("expected system prompt to be top-level, got %q", got.System)
+	}
+	if len(got.Messages) != 1 || got.Messages[0].Role != "user" {
+		t.Fatalf("expected only the user message, got %+v", got.Messages)
+	}
+}
+
+func TestAnthropicClientSurfacesAPIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`))
+	}))
+	defer server.Close()
+
+	client := NewAnthropicClient("bad")
+	client.baseURL = server.URL
+	if _, err := client.ChatCompletion(context.Background(), "claude-test", []Message{{Role: "user", Content: "hi"}}); err == nil {
+		t.Fatalf("expected error for unauthorized response")
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// defaultAzureAPIVersion is the Azure OpenAI REST API version used when
+// AZURE_OPENAI_API_VERSION is not set.
+const defaultAzureAPIVersion = "2024-10-21"
+
+// azureOpenAIConfig configures the OpenAI client for an Azure OpenAI resource
+// at endpoint, e.g. https://my-resource.openai.azure.com. Azure routes
+// requests by deployment rather than by model: every request is sent to
+// deployment when it is set, and otherwise to a deployment named after the
+// model with dots and colons removed. The model name is still used for token
+// budgets and pricing.
+func azureOpenAIConfig(apiKey, endpoint, apiVersion, deployment string) openai.ClientConfig {
+	config := openai.DefaultAzureConfig(apiKey, strings.TrimRight(endpoint, "/"))
+	if apiVersion == "" {
+		apiVersion = defaultAzureAPIVersion
+	}
+	config.APIVersion = apiVersion
+	if deployment != "" {
+		config.AzureModelMapperFunc = func(string) string { return deployment }
+	}
+	return config
+}
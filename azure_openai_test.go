@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestAzureOpenAIConfigRoutesToDeployment(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/openai/deployments/chat-prod/chat/completions" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if got := r.URL.Query().Get("api-version"); got != "2024-06-01" {
+			t.Errorf("expected api-version 2024-06-01, got %q", got)
+		}
+		if r.Header.Get("api-key") != "test-key" {
+			t.Errorf("api-key header not set")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"hello"}}],"usage":{"prompt_tokens":3,"completion_tokens":1}}`))
+	}))
+	defer server.Close()
+
+	client := &OpenAIClientImpl{client: openai.NewClientWithConfig(azureOpenAIConfig("test-key", server.URL+"/", "2024-06-01", "chat-prod"))}
+	resp, err := client.ChatCompletion(context.Background(), "gpt-4o-mini", []Message{{Role: "user", Content: "hi"}})
+	if err != nil {
+		t.Fatalf("ChatCompletion returned error: %v", err)
+	}
+	if resp != "hello" {
+		t.Fatalf("unexpected response: %s", resp)
+	}
+}
+
+func TestAzureOpenAIConfigDefaults(t *testing.T) {
+	config := azureOpenAIConfig("key", "https://example.openai.azure.com", "", "")
+	if config.APIVersion != defaultAzureAPIVersion {
+		t.Fatalf("expected default API version, got %q", config.APIVersion)
+	}
+	if got := config.GetAzureDeploymentByModel("gpt-3.5-turbo"); got != "gpt-35-turbo" {
+		t.Fatalf("expected deployment named after the model, got %q", got)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LatencyStats summarizes the latencies of a run of timed operations.
+type LatencyStats struct {
+	Ops    int
+	Errors int
+	// Elapsed is the wall time of the whole run, which concurrent
+	// operations share.
+	Elapsed                       time.Duration
+	Mean, P50, P90, P95, P99, Max time.Duration
+}
+
+// PerSecond returns the rate at which the run completed n items.
+func (s LatencyStats) PerSecond(n int) float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(n) / s.Elapsed.Seconds()
+}
+
+// latencyStats returns the statistics of latencies, measured over elapsed.
+func latencyStats(latencies []time.Duration, errors int, elapsed time.Duration) LatencyStats {
+	stats := LatencyStats{Ops: len(latencies), Errors: errors, Elapsed: elapsed}
+	if len(latencies) == 0 {
+		return stats
+	}
+	sorted := slices.Clone(latencies)
+	slices.Sort(sorted)
+	var total time.Duration
+	for _, latency := range sorted {
+		total += latency
+	}
+	stats.Mean = total / time.Duration(len(sorted))
+	stats.P50 = percentile(sorted, 50)
+	stats.P90 = percentile(sorted, 90)
+	stats.P95 = percentile(sorted, 95)
+	stats.P99 = percentile(sorted, 99)
+	stats.Max = sorted[len(sorted)-1]
+	return stats
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted, which must
+// not be empty.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(float64(len(sorted))*p/100+0.5) - 1
+	return sorted[max(0, min(rank, len(sorted)-1))]
+}
+
+// measureConcurrently runs op for 0 through ops-1 on concurrency goroutines
+// and times each call. It stops handing out operations once ctx is
+// canceled.
+func measureConcurrently(ctx context.Context, ops, concurrency int, op func(ctx context.Context, i int) error) LatencyStats {
+	concurrency = max(1, min(concurrency, ops))
+	latencies := make([]time.Duration, ops)
+	done := make([]bool, ops)
+	var next, errors atomic.Int64
+	var wg sync.WaitGroup
+	start := time.Now()
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				i := int(next.Add(1)) - 1
+				if i >= ops {
+					return
+				}
+				began := time.Now()
+				if err := op(ctx, i); err != nil {
+					errors.Add(1)
+				}
+				latencies[i] = time.Since(began)
+				done[i] = true
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	measured := latencies[:0]
+	for i, latency := range latencies {
+		if done[i] {
+			measured = append(measured, latency)
+		}
+	}
+	return latencyStats(measured, int(errors.Load()), elapsed)
+}
+
+// BenchStage is the outcome of one stage of `rag bench`.
+type BenchStage struct {
+	Name string
+	// Items counts what the stage processed, in Unit: chunks written,
+	// texts embedded, or queries answered.
+	Items int
+	Unit  string
+	Stats LatencyStats
+}
+
+// loadBenchCorpus loads the text pages of the supported files under dir,
+// leaving out images and audio, whose text comes from a model.
+func loadBenchCorpus(dir string) ([]Page, error) {
+	var pages []Page
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && p != dir {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !d.Type().IsRegular() || !supportedFile(p) {
+			return nil
+		}
+		loaded, err := LoadFile(p)
+		if err != nil {
+			slog.Warn("File skipped", "file", p, "error", err)
+			return nil
+		}
+		for _, page := range loaded {
+			if page.Image == nil && page.Audio == nil && page.Text != "" {
+				pages = append(pages, page)
+			}
+		}
+		return nil
+	})
+	return pages, err
+}
+
+// benchChunking chunks every page, one operation per page, as ingestion
+// does.
+func benchChunking(ctx context.Context, pages []Page, chunkSize, overlap, concurrency int) BenchStage {
+	var chunks atomic.Int64
+	stats := measureConcurrently(ctx, len(pages), concurrency, func(ctx context.Context, i int) error {
+		chunks.Add(int64(len(pageChunker(pages[i])(pages[i].Text, chunkSize, overlap))))
+		return nil
+	})
+	return BenchStage{Name: "chunk", Items: int(chunks.Load()), Unit: "chunks", Stats: stats}
+}
+
+// benchEmbedding embeds texts in batches of batch, one operation per batch.
+func benchEmbedding(ctx context.Context, embedder Embedder, texts []string, batch, concurrency int) BenchStage {
+	batches := (len(texts) + batch - 1) / batch
+	stats := measureConcurrently(ctx, batches, concurrency, func(ctx context.Context, i int) error {
+		_, err := embedder.Embed(ctx, texts[i*batch:min((i+1)*batch, len(texts))])
+		return err
+	})
+	return BenchStage{Name: "embed", Items: len(texts), Unit: "texts", Stats: stats}
+}
+
+// benchSearch runs requests vector store searches, cycling through
+// questions. Each search embeds its question, as queries do.
+func benchSearch(ctx context.Context, store VectorStore, questions []string, requests, limit, concurrency int) BenchStage {
+	stats := measureConcurrently(ctx, requests, concurrency, func(ctx context.Context, i int) error {
+		failure := &searchFailure{}
+		store.SearchSimilar(withSearchFailure(ctx, failure), questions[i%len(questions)], limit, nil)
+		return failure.err
+	})
+	return BenchStage{Name: "search", Items: stats.Ops, Unit: "queries", Stats: stats}
+}
+
+// benchQuery answers requests questions end to end, cycling through
+// questions: retrieval with opts, then generation with model.
+func benchQuery(ctx context.Context, engine *RAGEngine, questions []string, requests, limit int, model string, concurrency int, opts ...RetrieveOption) BenchStage {
+	stats := measureConcurrently(ctx, requests, concurrency, func(ctx context.Context, i int) error {
+		question := questions[i%len(questions)]
+		docs := engine.Retrieve(ctx, question, limit, opts...)
+		_, err := engine.GenerateResponse(ctx, question, docs, model)
+		return err
+	})
+	return BenchStage{Name: "query", Items: stats.Ops, Unit: "queries", Stats: stats}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLatencyStats(t *testing.T) {
+	var latencies []time.Duration
+	for i := 100; i >= 1; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	stats := latencyStats(latencies, 2, 2*time.Second)
+	if stats.P50 != 50*time.Millisecond || stats.P95 != 95*time.Millisecond || stats.P99 != 99*time.Millisecond || stats.Max != 100*time.Millisecond {
+		t.Fatalf("unexpected percentiles %+v", stats)
+	}
+	if stats.Mean != 50500*time.Microsecond || stats.Errors != 2 || stats.PerSecond(stats.Ops) != 50 {
+		t.Fatalf("unexpected mean, errors, or rate %+v", stats)
+	}
+	if single := latencyStats([]time.Duration{time.Second}, 0, time.Second); single.P50 != time.Second || single.P99 != time.Second {
+		t.Fatalf("expected every percentile of one latency to be it, got %+v", single)
+	}
+	if empty := latencyStats(nil, 0, 0); empty.Ops != 0 || empty.PerSecond(10) != 0 {
+		t.Fatalf("unexpected stats of no operations %+v", empty)
+	}
+}
+
+func TestMeasureConcurrently(t *testing.T) {
+	var inFlight, peak atomic.Int64
+	stats := measureConcurrently(context.Background(), 20, 4, func(ctx context.Context, i int) error {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		time.Sleep(time.Millisecond)
+		if i%5 == 0 {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	if stats.Ops != 20 || stats.Errors != 4 || peak.Load() > 4 || stats.P50 < time.Millisecond {
+		t.Fatalf("unexpected run %+v with %d in flight at most", stats, peak.Load())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if stats := measureConcurrently(ctx, 20, 4, func(ctx context.Context, i int) error { return nil }); stats.Ops != 0 {
+		t.Fatalf("expected a canceled run to stop, got %+v", stats)
+	}
+}
+
+func TestBenchStages(t *testing.T) {
+	ctx := context.Background()
+	pages := []Page{{Text: benchText(20), Source: "a.md"}, {Text: benchText(5), Source: "b.md"}}
+	chunk := benchChunking(ctx, pages, 200, 0, 2)
+	if chunk.Stats.Ops != 2 || chunk.Items < 3 {
+		t.Fatalf("expected both pages chunked, got %+v", chunk)
+	}
+	texts, _, _ := chunkPages(pages, 200, 0)
+	if embed := benchEmbedding(ctx, NewHashingEmbedder(64), texts, 2, 2); embed.Stats.Ops != (len(texts)+1)/2 || embed.Items != len(texts) {
+		t.Fatalf("expected one operation per batch, got %+v", embed)
+	}
+
+	store := NewMemoryStore(NewHashingEmbedder(64))
+	store.InsertDocuments(ctx, texts, repeatSource("a.md", len(texts)), nil)
+	if search := benchSearch(ctx, store, []string{"expense approval"}, 10, 3, 4); search.Stats.Ops != 10 || search.Stats.Errors != 0 {
+		t.Fatalf("unexpected search stage %+v", search)
+	}
+	engine := NewRAGEngine(&scriptedOpenAI{err: errors.New("rate limited")}, store)
+	if query := benchQuery(ctx, engine, []string{"expense approval"}, 6, 3, "gpt-mini", 2); query.Stats.Ops != 6 || query.Stats.Errors != 6 {
+		t.Fatalf("expected every failed answer counted, got %+v", query)
+	}
+}
+
+// benchText returns n sentences of prose.
+func benchText(n int) string {
+	var b strings.Builder
+	for i := range n {
+		fmt.Fprintf(&b, "Expense report %d is approved by the team manager within five working days. ", i)
+	}
+	return b.String()
+}
+
+// benchEngine returns an engine over a memory store of n chunks that
+// answers every question with a fixed reply.
+func benchEngine(b *testing.B, n int) *RAGEngine {
+	store := NewMemoryStore(NewHashingEmbedder(256))
+	texts, _, _ := chunkPages([]Page{{Text: benchText(n * 10), Source: "handbook.md"}}, 1000, 200)
+	if !store.InsertDocuments(context.Background(), texts, repeatSource("handbook.md", len(texts)), nil) {
+		b.Fatal("insert failed")
+	}
+	return NewRAGEngine(&scriptedOpenAI{reply: "Managers approve expense reports [1]."}, store)
+}
+
+func BenchmarkChunkText(b *testing.B) {
+	text := benchText(1000)
+	b.SetBytes(int64(len(text)))
+	for b.Loop() {
+		ChunkTextWithOffsets(text, 1000, 200)
+	}
+}
+
+func BenchmarkHashingEmbedder(b *testing.B) {
+	texts := ChunkText(benchText(400), 1000, 200)[:32]
+	ctx := context.Background()
+	for b.Loop() {
+		NewHashingEmbedder(1536).Embed(ctx, texts)
+	}
+}
+
+func BenchmarkMemoryStoreSearch(b *testing.B) {
+	engine := benchEngine(b, 1000)
+	ctx := context.Background()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			engine.store.SearchSimilar(ctx, "who approves expense reports", 5, nil)
+		}
+	})
+}
+
+func BenchmarkQuery(b *testing.B) {
+	engine := benchEngine(b, 1000)
+	ctx := context.Background()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			docs := engine.Retrieve(ctx, "who approves expense reports", 5)
+			if _, err := engine.GenerateResponse(ctx, "who approves expense reports", docs, "gpt-mini"); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxObjectBytes bounds how much of a single bucket object is downloaded.
+const maxObjectBytes = 100 << 20
+
+// BucketObject is an object listed in a bucket.
+type BucketObject struct {
+	Key  string
+	Size int64
+}
+
+// Bucket lists and reads the objects of an S3 or GCS bucket.
+type Bucket interface {
+	// URI returns the bucket's URI, e.g. s3://docs, which prefixes sources.
+	URI() string
+	// List calls fn for every object whose key starts with prefix, following
+	// the listing's pagination.
+	List(ctx context.Context, prefix string, fn func(BucketObject) error) error
+	// Open streams the object stored under key.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// ParseBucketURI splits an s3://bucket/prefix or gs://bucket/prefix URI into
+// its scheme, bucket, and key prefix.
+func ParseBucketURI(uri string) (scheme, bucket, prefix string, err error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", "", "", fmt.Errorf("parsing bucket URI: %w", err)
+	}
+	if (u.Scheme != "s3" && u.Scheme != "gs") || u.Host == "" {
+		return "", "", "", fmt.Errorf("invalid bucket URI %q (expected s3://bucket/prefix or gs://bucket/prefix)", uri)
+	}
+	return u.Scheme, u.Host, strings.TrimPrefix(u.Path, "/"), nil
+}
+
+// IngestBucket ingests every supported object under prefix in parallel, like
+// IngestDirectory. Include and Exclude patterns are matched against each key
+// and its last element. Each object's source is its URI (e.g.
+// s3://docs/guides/intro.md) and its metadata records the bucket and key.
+func IngestBucket(ctx context.Context, engine *RAGEngine, bucket Bucket, prefix string, opts DirectoryOptions) (DirectoryReport, error) {
+	start := time.Now()
+	var report DirectoryReport
+	var keys []string
+	err := bucket.List(ctx, prefix, func(obj BucketObject) error {
+		if strings.HasSuffix(obj.Key, "/") || !supportedFile(obj.Key) || !selected(obj.Key, opts.Include, opts.Exclude) {
+			report.Skipped++
+			return nil
+		}
+		if obj.Size > maxObjectBytes {
+			report.Errors = append(report.Errors, FileError{Path: obj.Key, Err: fmt.Errorf("object is larger than %d bytes", maxObjectBytes)})
+			return nil
+		}
+		keys = append(keys, obj.Key)
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("listing %s/%s: %w", bucket.URI(), prefix, err)
+	}
+
+	ingestAll(ctx, keys, opts.Workers, &report, func(key string) (IngestReport, error) {
+		return ingestObject(ctx, engine, bucket, key, opts.ChunkSize, opts.Overlap)
+	})
+	report.Duration = time.Since(start)
+	return report, ctx.Err()
+}
+
+// ingestObject downloads the object and ingests it.
+func ingestObject(ctx context.Context, engine *RAGEngine, bucket Bucket, key string, chunkSize, overlap int) (IngestReport, error) {
+	body, err := bucket.Open(ctx, key)
+	if err != nil {
+		return IngestReport{}, err
+	}
+	defer body.Close()
+	pages, err := loadDownload(body, key)
+	if err != nil {
+		return IngestReport{}, err
+	}
+	source := bucket.URI() + "/" + key
+	for i := range pages {
+		pages[i].Source = source
+		meta := map[string]any{"bucket": bucket.URI(), "key": key}
+		for k, v := range pages[i].Metadata {
+			meta[k] = v
+		}
+		pages[i].Metadata = meta
+	}
+	report, ok := ingestPages(ctx, engine, pages, chunkSize, overlap)
+	if !ok {
+		return report, fmt.Errorf("storing chunks failed (%s)", report)
+	}
+	return report, nil
+}
+
+// loadDownload copies a downloaded file to a temporary file with the
+// extension of name, so it is loaded like a local file by LoadFile.
+func loadDownload(body io.Reader, name string) ([]Page, error) {
+	tmp, err := os.CreateTemp("", "rag-download-*"+path.Ext(name))
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, io.LimitReader(body, maxObjectBytes))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("downloading %s: %w", name, err)
+	}
+	return LoadFile(tmp.Name())
+}
+
+// S3Bucket reads an Amazon S3 bucket, or one on an S3-compatible server such
+// as MinIO, over the REST API. Requests are signed with AWS Signature
+// Version 4 when credentials are set, and sent anonymously otherwise.
+type S3Bucket struct {
+	name         string
+	baseURL      string // URL of the bucket itself, without a trailing slash
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	httpClient   *http.Client
+}
+
+// NewS3Bucket creates a client for bucket in region. An empty endpoint uses
+// AWS (https://<bucket>.s3.<region>.amazonaws.com); otherwise the bucket is
+// addressed path-style under endpoint, as S3-compatible servers expect.
+func NewS3Bucket(bucket, region, endpoint, accessKey, secretKey, sessionToken string) *S3Bucket {
+	if region == "" {
+		region = "us-east-1"
+	}
+	baseURL := fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, region)
+	if endpoint != "" {
+		baseURL = strings.TrimRight(endpoint, "/") + "/" + bucket
+	}
+	return &S3Bucket{
+		name:         bucket,
+		baseURL:      baseURL,
+		region:       region,
+		accessKey:    accessKey,
+		secretKey:    secretKey,
+		sessionToken: sessionToken,
+		httpClient:   http.DefaultClient,
+	}
+}
+
+func (s *S3Bucket) URI() string {
+	return "s3://" + s.name
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key  string `xml:"Key"`
+		Size int64  `xml:"Size"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3Bucket) List(ctx context.Context, prefix string, fn func(BucketObject) error) error {
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.get(ctx, "", query)
+		if err != nil {
+			return err
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("decoding S3 listing: %w", err)
+		}
+		for _, obj := range result.Contents {
+			if err := fn(BucketObject{Key: obj.Key, Size: obj.Size}); err != nil {
+				return err
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *S3Bucket) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.get(ctx, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// get sends a signed GET request for key (or the bucket itself when key is
+// empty) and returns the response if it succeeded.
+func (s *S3Bucket) get(ctx context.Context, key string, query url.Values) (*http.Response, error) {
+	u, err := url.Parse(s.baseURL)
+	if err != nil {
+		return nil, err
+	}
+	u.Path += "/" + key
+	u.RawPath = awsURIEncode(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.accessKey != "" {
+		s.sign(req, time.Now().UTC())
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var apiErr struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+		if xml.Unmarshal(data, &apiErr) == nil && apiErr.Code != "" {
+			return nil, fmt.Errorf("S3 API error (status %d): %s: %s", resp.StatusCode, apiErr.Code, apiErr.Message)
+		}
+		return nil, fmt.Errorf("S3 API error (status %d)", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// emptyPayloadHash is the SHA-256 of an empty request body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// sign adds AWS Signature Version 4 headers to a bodiless request.
+func (s *S3Bucket) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", emptyPayloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("x-amz-security-token", s.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsURIEncode percent-encodes every byte except the unreserved characters,
+// and slashes unless encodeSlash is set, as Signature Version 4 requires.
+func awsURIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// canonicalQuery encodes query sorted by name, as Signature Version 4
+// requires.
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, awsURIEncode(name, true)+"="+awsURIEncode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// GCSBucket reads a Google Cloud Storage bucket over the JSON API,
+// authenticated with an OAuth access token (e.g. from `gcloud auth
+// print-access-token`), or anonymously for public buckets.
+type GCSBucket struct {
+	name        string
+	baseURL     string
+	accessToken string
+	httpClient  *http.Client
+}
+
+// NewGCSBucket creates a client for bucket. An empty endpoint uses
+// https://storage.googleapis.com.
+func NewGCSBucket(bucket, endpoint, accessToken string) *GCSBucket {
+	if endpoint == "" {
+		endpoint = "https://storage.googleapis.com"
+	}
+	return &GCSBucket{name: bucket, baseURL: strings.TrimRight(endpoint, "/"), accessToken: accessToken, httpClient: http.DefaultClient}
+}
+
+func (g *GCSBucket) URI() string {
+	return "gs://" + g.name
+}
+
+type gcsListResult struct {
+	Items []struct {
+		Name string `json:"name"`
+		Size string `json:"size"` // a decimal string in the JSON API
+	} `json:"items"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+func (g *GCSBucket) List(ctx context.Context, prefix string, fn func(BucketObject) error) error {
+	token := ""
+	for {
+		query := url.Values{"prefix": {prefix}, "fields": {"items(name,size),nextPageToken"}}
+		if token != "" {
+			query.Set("pageToken", token)
+		}
+		resp, err := g.get(ctx, "/storage/v1/b/"+url.PathEscape(g.name)+"/o?"+query.Encode())
+		if err != nil {
+			return err
+		}
+		var result gcsListResult
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("decoding GCS listing: %w", err)
+		}
+		for _, item := range result.Items {
+			size, _ := strconv.ParseInt(item.Size, 10, 64)
+			if err := fn(BucketObject{Key: item.Name, Size: size}); err != nil {
+				return err
+			}
+		}
+		if result.NextPageToken == "" {
+			return nil
+		}
+		token = result.NextPageToken
+	}
+}
+
+func (g *GCSBucket) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := g.get(ctx, "/storage/v1/b/"+url.PathEscape(g.name)+"/o/"+url.PathEscape(key)+"?alt=media")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// get sends a GET request for path and returns the response if it succeeded.
+func (g *GCSBucket) get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if g.accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+g.accessToken)
+	}
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var apiErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error.Message != "" {
+			return nil, fmt.Errorf("GCS API error (status %d): %s", resp.StatusCode, apiErr.Error.Message)
+		}
+		return nil, fmt.Errorf("GCS API error (status %d)", resp.StatusCode)
+	}
+	return resp, nil
+}
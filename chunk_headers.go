@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// titleField is the metadata key loaders and connectors store a document's
+// title in.
+const titleField = "title"
+
+// chunkHeaderField is the metadata key holding the header prepended to a
+// chunk's text, so that it can be told apart from the document's own text.
+const chunkHeaderField = "chunk_header"
+
+// DefaultChunkHeaderTemplate frames a chunk with its document's title and
+// the section it sits in.
+const DefaultChunkHeaderTemplate = "Document: {title}\nSection: {section}"
+
+// headerPlaceholder matches the {field} placeholders of a header template.
+var headerPlaceholder = regexp.MustCompile(`\{(\w+)\}`)
+
+// WithChunkHeaders prepends a header rendered from template to the text of
+// every ingested chunk, so that it is embedded, and later read by the model,
+// with the document and section it came from. A placeholder such as {team}
+// is replaced by that metadata field of the chunk; {title} falls back to the
+// source's file name, {section} is the chunk's heading_path or, in code, its
+// symbols, and {source} is its source. Template lines whose placeholders are
+// all empty are left out. An empty template means
+// DefaultChunkHeaderTemplate.
+func WithChunkHeaders(template string) EngineOption {
+	if template == "" {
+		template = DefaultChunkHeaderTemplate
+	}
+	return func(r *RAGEngine) {
+		r.chunkHeader = template
+	}
+}
+
+// checkHeaderTemplate reports an error unless template has a placeholder.
+func checkHeaderTemplate(template string) error {
+	if !headerPlaceholder.MatchString(template) {
+		return fmt.Errorf("chunk header template %q has no {field} placeholder", template)
+	}
+	return nil
+}
+
+// renderChunkHeader fills in template for a chunk of source with metadata.
+func renderChunkHeader(template, source string, metadata map[string]any) string {
+	value := func(field string) string {
+		var v any
+		switch field {
+		case "source":
+			v = source
+		case "title":
+			if v = metadata[titleField]; v == nil || v == "" {
+				v = path.Base(source)
+			}
+		case "section":
+			if v = metadata["heading_path"]; v == nil || v == "" {
+				v = metadata["symbols"]
+			}
+		default:
+			v = metadata[field]
+		}
+		if v == nil {
+			return ""
+		}
+		return strings.TrimSpace(fmt.Sprint(v))
+	}
+	var lines []string
+	for _, line := range strings.Split(template, "\n") {
+		filled := !headerPlaceholder.MatchString(line)
+		line = headerPlaceholder.ReplaceAllStringFunc(line, func(placeholder string) string {
+			v := value(placeholder[1 : len(placeholder)-1])
+			filled = filled || v != ""
+			return v
+		})
+		if filled {
+			lines = append(lines, line)
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// addChunkHeaders prepends the rendered header to every chunk text and
+// records it in the chunk's metadata as chunk_header.
+func addChunkHeaders(template string, texts, sources []string, metadata []map[string]any) {
+	for i := range texts {
+		header := renderChunkHeader(template, sources[i], metadata[i])
+		if header == "" {
+			continue
+		}
+		texts[i] = header + "\n\n" + texts[i]
+		metadata[i][chunkHeaderField] = header
+	}
+}
+
+// chunkBody returns the text of doc without its chunk header.
+func chunkBody(doc Document) string {
+	header, _ := doc.Metadata[chunkHeaderField].(string)
+	if header == "" {
+		return doc.Text
+	}
+	return strings.TrimPrefix(doc.Text, header+"\n\n")
+}
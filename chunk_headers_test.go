@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRenderChunkHeader(t *testing.T) {
+	for _, tc := range []struct {
+		template, source string
+		metadata         map[string]any
+		want             string
+	}{
+		{DefaultChunkHeaderTemplate, "guide.md", map[string]any{"title": "Install Guide", "heading_path": "Install > Linux"}, "Document: Install Guide\nSection: Install > Linux"},
+		{DefaultChunkHeaderTemplate, "docs/notes.txt", nil, "Document: notes.txt"},
+		{DefaultChunkHeaderTemplate, "main.go", map[string]any{"symbols": "func main"}, "Document: main.go\nSection: func main"},
+		{"[{team}] {title}, page {page}\nInternal", "handbook.pdf", map[string]any{"team": "hr", "page": 3}, "[hr] handbook.pdf, page 3\nInternal"},
+	} {
+		if got := renderChunkHeader(tc.template, tc.source, tc.metadata); got != tc.want {
+			t.Errorf("renderChunkHeader(%q, %q) = %q, want %q", tc.template, tc.source, got, tc.want)
+		}
+	}
+}
+
+func TestIngestPagesPrependsChunkHeaders(t *testing.T) {
+	store := NewMemoryStore(NewHashingEmbedder(256))
+	engine := NewRAGEngine(&dummyOpenAI{}, store, WithChunkHeaders(""))
+	pages := []Page{{Text: "# Install\n\n## Linux\n\nRun the installer with sudo.", Source: "guide.md", Format: "markdown", Metadata: map[string]any{"title": "Install Guide"}}}
+	if _, ok := ingestPages(context.Background(), engine, pages, 1000, 0); !ok {
+		t.Fatal("ingestion failed")
+	}
+	docs := store.SearchSimilar(context.Background(), "installer", 5, nil)
+	want := "Document: Install Guide\nSection: Install > Linux\n\n## Linux\n\nRun the installer with sudo."
+	if len(docs) != 1 || docs[0].Text != want {
+		t.Fatalf("expected the chunk with its header, got %+v", docs)
+	}
+	if body := chunkBody(docs[0]); body != "## Linux\n\nRun the installer with sudo." {
+		t.Fatalf("expected the header stripped from the body, got %q", body)
+	}
+	if err := checkHeaderTemplate("Document"); err == nil || !strings.Contains(err.Error(), "placeholder") {
+		t.Fatalf("expected an error for a template without placeholders, got %v", err)
+	}
+}
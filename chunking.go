@@ -0,0 +1,189 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Chunk is a piece of a larger text. Start and End are byte offsets of the
+// chunk within the original text.
+type Chunk struct {
+	Text     string
+	Start    int
+	End      int
+	Metadata map[string]any // added to the chunk's stored metadata, e.g. heading_path
+}
+
+// ChunkFunc splits text into chunks of about chunkSize characters, with
+// overlap characters shared between consecutive chunks where it applies.
+type ChunkFunc func(text string, chunkSize, overlap int) []Chunk
+
+// pageChunker picks the chunker for the page's format and, for source code,
+// its language metadata.
+func pageChunker(page Page) ChunkFunc {
+	switch page.Format {
+	case "markdown":
+		return ChunkMarkdown
+	case "transcript":
+		return ChunkTranscript
+	case "code":
+		if page.Metadata["language"] == "go" {
+			return ChunkGo
+		}
+		return ChunkCode
+	default:
+		return ChunkTextWithTables
+	}
+}
+
+// ChunkText splits text into overlapping chunks.
+func ChunkText(text string, chunkSize, overlap int) []string {
+	chunks := ChunkTextWithOffsets(text, chunkSize, overlap)
+	texts := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		texts[i] = chunk.Text
+	}
+	return texts
+}
+
+// ChunkTextWithOffsets splits text like ChunkText and also reports where each
+// chunk sits in the original text.
+//
+// chunkSize and overlap count characters (runes), and chunks are only cut
+// between runes. A chunk ends at its last sentence boundary, so sentences are
+// split only when a single one is longer than a chunk; such a sentence is cut
+// at the last space in the second half of the chunk. The overlap likewise
+// starts at a sentence or word boundary when it contains one. Invalid UTF-8 in text is
+// replaced with U+FFFD in the chunk text.
+func ChunkTextWithOffsets(text string, chunkSize, overlap int) []Chunk {
+	runes := []rune(text)
+	offsets := make([]int, 0, len(runes)+1)
+	for i := range text {
+		offsets = append(offsets, i)
+	}
+	offsets = append(offsets, len(text))
+
+	var chunks []Chunk
+	n := len(runes)
+	start := 0
+	for start < n {
+		end := min(start+chunkSize, n)
+		if end < n {
+			end = lastBreak(runes, start, start+chunkSize/2, end)
+		}
+
+		if chunk, ok := trimmedChunk(text, offsets[start], offsets[end]); ok {
+			chunks = append(chunks, chunk)
+		}
+		if end == n {
+			break
+		}
+
+		next := end
+		if overlap > 0 {
+			next = firstBreak(runes, max(end-overlap, 0), end)
+		}
+		if next <= start {
+			next = end
+		}
+		start = next
+	}
+	return chunks
+}
+
+// lastBreak returns the last sentence boundary in (start, hi], else the last
+// word boundary in (wordLo, hi], else hi.
+func lastBreak(runes []rune, start, wordLo, hi int) int {
+	for p := hi; p > start; p-- {
+		if sentenceBoundary(runes, p) {
+			return p
+		}
+	}
+	for p := hi; p > wordLo; p-- {
+		if wordBoundary(runes, p) {
+			return p
+		}
+	}
+	return hi
+}
+
+// firstBreak returns the first sentence boundary in [lo, hi), else the first
+// word boundary, else lo.
+func firstBreak(runes []rune, lo, hi int) int {
+	for p := lo; p < hi; p++ {
+		if sentenceBoundary(runes, p) {
+			return p
+		}
+	}
+	for p := lo; p < hi; p++ {
+		if wordBoundary(runes, p) {
+			return p
+		}
+	}
+	return lo
+}
+
+func wordBoundary(runes []rune, p int) bool {
+	return p > 0 && p < len(runes) && (unicode.IsSpace(runes[p-1]) || unicode.IsSpace(runes[p]))
+}
+
+// sentenceBoundary reports whether a sentence ends right before position p:
+// after a line break, after CJK terminal punctuation, or after ".", "!", "?",
+// or "…" (optionally followed by closing quotes or brackets) and before
+// whitespace, unless the period ends a common abbreviation or an initial.
+func sentenceBoundary(runes []rune, p int) bool {
+	if p <= 0 || p >= len(runes) {
+		return false
+	}
+	if runes[p-1] == '\n' {
+		return true
+	}
+	switch runes[p-1] {
+	case '。', '！', '？':
+		return true
+	}
+	if !unicode.IsSpace(runes[p]) {
+		return false
+	}
+	end := p - 1
+	for end > 0 && strings.ContainsRune("\"')]”’»", runes[end]) {
+		end--
+	}
+	switch runes[end] {
+	case '!', '?', '…':
+		return true
+	case '.':
+		return !abbreviation(runes, end)
+	}
+	return false
+}
+
+// abbreviations are lower-cased words whose trailing period rarely ends a
+// sentence.
+var abbreviations = map[string]bool{
+	"mr": true, "mrs": true, "ms": true, "dr": true, "prof": true, "sr": true, "jr": true,
+	"st": true, "vs": true, "e.g": true, "i.e": true, "cf": true, "fig": true,
+}
+
+// abbreviation reports whether the period at runes[dot] ends an abbreviation
+// or a single-letter initial.
+func abbreviation(runes []rune, dot int) bool {
+	start := dot
+	for start > 0 && (unicode.IsLetter(runes[start-1]) || runes[start-1] == '.') {
+		start--
+	}
+	word := strings.ToLower(string(runes[start:dot]))
+	return abbreviations[word] || (utf8.RuneCountInString(word) == 1 && unicode.IsUpper(runes[start]))
+}
+
+// trimmedChunk returns text[start:end] without surrounding whitespace.
+func trimmedChunk(text string, start, end int) (Chunk, bool) {
+	raw := text[start:end]
+	trimmed := strings.TrimSpace(raw)
+	if trimmed == "" {
+		return Chunk{}, false
+	}
+	offset := start + strings.Index(raw, trimmed)
+	return Chunk{Text: strings.ToValidUTF8(trimmed, "�"), Start: offset, End: offset + len(trimmed)}, true
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestChunkTextKeepsSentencesWhole(t *testing.T) {
+	text := "Go was designed at Google. Dr. Pike worked on it. It has goroutines! Does it have generics? Yes."
+	for _, chunk := range ChunkText(text, 40, 0) {
+		if !strings.ContainsAny(chunk[len(chunk)-1:], ".!?") {
+			t.Errorf("chunk %q ends mid-sentence", chunk)
+		}
+		if strings.HasPrefix(chunk, "Pike") {
+			t.Errorf("chunk %q was split after an abbreviation", chunk)
+		}
+	}
+}
+
+func TestChunkTextIsUnicodeSafe(t *testing.T) {
+	text := strings.Repeat("日本語のテキスト。", 10) + strings.Repeat("ü", 25)
+	chunks := ChunkTextWithOffsets(text, 12, 3)
+	if len(chunks) < 2 {
+		t.Fatalf("expected several chunks, got %d", len(chunks))
+	}
+	for _, chunk := range chunks {
+		if !utf8.ValidString(chunk.Text) {
+			t.Fatalf("chunk %q is not valid UTF-8", chunk.Text)
+		}
+		if text[chunk.Start:chunk.End] != chunk.Text {
+			t.Fatalf("offsets %d-%d do not match chunk %q", chunk.Start, chunk.End, chunk.Text)
+		}
+		if n := utf8.RuneCountInString(chunk.Text); n > 12 {
+			t.Fatalf("chunk %q has %d characters, over the chunk size", chunk.Text, n)
+		}
+	}
+	if !strings.HasSuffix(chunks[0].Text, "。") {
+		t.Errorf("expected the first chunk to end at a full stop, got %q", chunks[0].Text)
+	}
+}
+
+func TestChunkTextOverlapStartsAtWord(t *testing.T) {
+	chunks := ChunkText("alpha beta gamma delta epsilon zeta", 18, 8)
+	if len(chunks) < 2 || !strings.HasPrefix(chunks[1], "gamma") {
+		t.Fatalf("expected the overlap to start at a word, got %q", chunks)
+	}
+}
+
+func TestTruncateTextIsUnicodeSafe(t *testing.T) {
+	got := truncateText("héllo wörld", 8)
+	if got != "héllo..." || !utf8.ValidString(got) {
+		t.Fatalf("truncateText = %q", got)
+	}
+}
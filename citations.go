@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Answer is a generated response together with the sources it cites.
+type Answer struct {
+	Text      string
+	Citations []Citation
+	NoContext bool // true when no retrieved document cleared the similarity threshold
+	// OutOfScope is set when the topic guard refused the question, which
+	// Text then answers (see WithTopicGuard).
+	OutOfScope bool
+	// Fallback is set when the vector store was unavailable: the
+	// StoreFallback the answer was produced with.
+	Fallback StoreFallback
+	// Parts lists the sub-questions of a decomposed question with their
+	// citations (see AnswerDecomposed).
+	Parts []AnswerPart
+	// Rounds traces the searches of an agentic answer (see
+	// AnswerIterative).
+	Rounds []RetrievalRound
+	// Confidence estimates how well the context supports the answer; nil
+	// for answers not generated from retrieved context.
+	Confidence *Confidence
+	// Verification lists the claims of the answer with whether the context
+	// supports them, with WithVerification; nil if the check did not run.
+	Verification *VerificationReport
+	// Fingerprint identifies the prompt and context chunks the answer was
+	// generated from, in deterministic mode (see WithDeterministic).
+	Fingerprint string
+}
+
+// Citation maps a numbered marker such as [2] in the answer text back to the
+// context document it refers to.
+type Citation struct {
+	Marker     int    // number used in the answer, e.g. 2 for [2]
+	Source     string // source of the cited document
+	ChunkStart int    // byte offset of the chunk within its source, -1 if unknown
+	ChunkEnd   int    // byte offset just past the chunk, -1 if unknown
+	URL        string // link to the cited lines, from the document's url metadata, if any
+	Web        bool   // the document is a web search result, not from the knowledge base
+	ImageURL   string // the image the document describes, from its image_url metadata, if any
+	Timecode   string // the time range of a transcript chunk, e.g. "12:05-13:40", if any
+	Document   Document
+}
+
+// citationPattern matches markers like [1], [2, 3], and [1][4].
+var citationPattern = regexp.MustCompile(`\[(\d+(?:\s*,\s*\d+)*)\]`)
+
+// extractCitations finds the citation markers in text and resolves them
+// against the numbered context documents, in order of first appearance.
+// Markers that do not correspond to a document are ignored.
+func extractCitations(text string, docs []Document) []Citation {
+	var citations []Citation
+	seen := make(map[int]bool)
+	for _, match := range citationPattern.FindAllStringSubmatch(text, -1) {
+		for _, part := range strings.Split(match[1], ",") {
+			marker, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || marker < 1 || marker > len(docs) || seen[marker] {
+				continue
+			}
+			seen[marker] = true
+
+			doc := docs[marker-1]
+			imageURL, _ := doc.Metadata[imageURLField].(string)
+			timecode, _ := doc.Metadata[timecodeField].(string)
+			citations = append(citations, Citation{
+				Marker:     marker,
+				Source:     doc.Source,
+				ChunkStart: metadataInt(doc.Metadata, "chunk_start"),
+				ChunkEnd:   metadataInt(doc.Metadata, "chunk_end"),
+				URL:        citationURL(doc.Metadata),
+				Web:        isWebDocument(doc),
+				ImageURL:   imageURL,
+				Timecode:   timecode,
+				Document:   doc,
+			})
+		}
+	}
+	return citations
+}
+
+// citationURL returns the document's url metadata with a #L10-L24 line
+// anchor when the chunk records its line range, as code chunks do, or a
+// #t=725 media fragment when it records its start time, as transcript
+// chunks do.
+func citationURL(metadata map[string]any) string {
+	link, _ := metadata["url"].(string)
+	if link == "" {
+		return ""
+	}
+	if start := metadataInt(metadata, startTimeField); start >= 0 {
+		return fmt.Sprintf("%s#t=%d", link, start)
+	}
+	start, end := metadataInt(metadata, "line_start"), metadataInt(metadata, "line_end")
+	if start <= 0 || end < start {
+		return link
+	}
+	return fmt.Sprintf("%s#L%d-L%d", link, start, end)
+}
+
+// metadataInt reads an integer metadata value, returning -1 if it is missing.
+// Values read back from JSON are float64, so any numeric type is accepted.
+func metadataInt(metadata map[string]any, key string) int {
+	if value, ok := toFloat(metadata[key]); ok {
+		return int(value)
+	}
+	return -1
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestExtractCitations(t *testing.T) {
+	docs := []Document{
+		{Source: "a", Metadata: map[string]any{"chunk_start": float64(10), "chunk_end": float64(20)}},
+		{Source: "b"},
+		{Source: "c"},
+	}
+	citations := extractCitations("Go is fast [2]. It has goroutines [1, 3][2] and more [9].", docs)
+	expected := []int{2, 1, 3}
+	if len(citations) != len(expected) {
+		t.Fatalf("expected %d citations, got %d", len(expected), len(citations))
+	}
+	for i, marker := range expected {
+		if citations[i].Marker != marker || citations[i].Source != docs[marker-1].Source {
+			t.Fatalf("citation %d expected marker %d got %+v", i, marker, citations[i])
+		}
+	}
+	if citations[1].ChunkStart != 10 || citations[1].ChunkEnd != 20 {
+		t.Fatalf("expected offsets from metadata, got %d-%d", citations[1].ChunkStart, citations[1].ChunkEnd)
+	}
+	if citations[0].ChunkStart != -1 {
+		t.Fatalf("expected unknown offset to be -1, got %d", citations[0].ChunkStart)
+	}
+}
+
+func TestCitationURLLinksToLines(t *testing.T) {
+	docs := []Document{
+		{Source: "github.com/acme/api/main.go", Metadata: map[string]any{"url": "https://github.com/acme/api/blob/abc/main.go", "line_start": float64(10), "line_end": float64(24)}},
+		{Source: "github.com/acme/api/README.md", Metadata: map[string]any{"url": "https://github.com/acme/api/blob/abc/README.md"}},
+		{Source: "notes.txt"},
+	}
+	citations := extractCitations("See [1], [2], and [3].", docs)
+	want := []string{"https://github.com/acme/api/blob/abc/main.go#L10-L24", "https://github.com/acme/api/blob/abc/README.md", ""}
+	for i, c := range citations {
+		if c.URL != want[i] {
+			t.Fatalf("citation %d: expected URL %q, got %q", i, want[i], c.URL)
+		}
+	}
+}
+
+func TestGenerateResponseReturnsCitations(t *testing.T) {
+	oa := &scriptedOpenAI{reply: "Cats purr [1]."}
+	engine := NewRAGEngine(oa, &dummyMilvus{})
+	answer, err := engine.GenerateResponse(context.Background(), "do cats purr?", []Document{{Text: "cats purr", Source: "cat facts"}}, "gpt-test")
+	if err != nil {
+		t.Fatalf("GenerateResponse returned error: %v", err)
+	}
+	if len(answer.Citations) != 1 || answer.Citations[0].Source != "cat facts" {
+		t.Fatalf("expected citation to cat facts, got %+v", answer.Citations)
+	}
+}
+
+func TestChunkTextWithOffsetsPointIntoSource(t *testing.T) {
+	text := "  First sentence here. Second sentence follows.\nThird line is last."
+	for _, chunk := range ChunkTextWithOffsets(text, 30, 5) {
+		if text[chunk.Start:chunk.End] != chunk.Text {
+			t.Fatalf("offsets %d-%d do not match chunk %q", chunk.Start, chunk.End, chunk.Text)
+		}
+		if strings.TrimSpace(chunk.Text) != chunk.Text {
+			t.Fatalf("chunk %q should be trimmed", chunk.Text)
+		}
+	}
+}
@@ -0,0 +1,1863 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// command is a `rag` subcommand.
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, args []string)
+}
+
+// commands lists the subcommands in the order they are shown in the usage.
+var commands = []command{
+	{"ingest", "load files, a directory, or web pages into the knowledge base", runIngest},
+	{"watch", "keep the knowledge base in sync with a directory", runWatch},
+	{"sync", "incrementally sync pages from Notion, Confluence, or a sitemap", runSync},
+	{"schedule", "re-sync configured sources on cron schedules", runSchedule},
+	{"delete", "remove every chunk of a source from the knowledge base", runDelete},
+	{"expire", "remove the chunks whose expiry has passed", runExpire},
+	{"export", "dump every chunk with its metadata and embedding to a portable directory", runExport},
+	{"import", "restore an export into the configured vector store", runImport},
+	{"query", "answer a single question from the knowledge base", runQuery},
+	{"summarize", "summarize a whole source, however long, chunk by chunk", runSummarize},
+	{"chat", "start an interactive multi-turn chat", runChat},
+	{"serve", "serve the HTTP query API", runServe},
+	{"collections", "list, inspect, or drop Milvus collections", runCollections},
+	{"index", "show or rebuild the vector index of a Milvus collection", runIndex},
+	{"versions", "build, validate, promote, and roll back versions of a Milvus collection", runVersions},
+	{"eval", "score retrieval and answers against a question dataset", runEval},
+	{"regress", "replay questions and diff answers and documents against a snapshot", runRegress},
+	{"bench", "measure chunking, embedding, search, and end-to-end query latency and throughput", runBench},
+	{"loadtest", "send questions to a running server at a fixed rate and report latency and errors per stage", runLoadtest},
+	{"quantization-bench", "compare the recall and memory of float16, int8, and binary vectors against float32", runQuantizationBench},
+	{"history", "list past queries or show one with its context and prompt", runHistory},
+	{"feedback", "rate a past answer, or report answers that need attention", runFeedback},
+	{"usage", "show token usage and estimated cost across runs", runUsage},
+	{"openapi", "print the OpenAPI spec of the serve API, or generate its Go client", runOpenAPI},
+	{"demo", "run the sample ingestion and query flow", runDemo},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(2)
+	}
+	name := os.Args[1]
+	if name == "help" || name == "-h" || name == "--help" {
+		printUsage()
+		return
+	}
+	for _, cmd := range commands {
+		if cmd.name == name {
+			if err := setupLogging(os.Stderr); err != nil {
+				fmt.Fprintf(os.Stderr, "rag: %v\n", err)
+				os.Exit(2)
+			}
+			ctx := context.Background()
+			shutdown, err := setupTracing(ctx)
+			if err != nil {
+				fatal("Setting up tracing failed", "error", err)
+			}
+			usageLedger = NewUsageLedger(usageFile())
+			if usageLedger.alertUSD, err = usageAlertFromEnv(); err != nil {
+				fatal("Configuration error", "error", err)
+			}
+			if webhooks, err = webhooksFromEnv(); err != nil {
+				fatal("Configuration error", "error", err)
+			}
+			cmd.run(ctx, os.Args[2:])
+			if err := usageLedger.Flush(); err != nil {
+				slog.Warn("Saving token usage failed", "error", err)
+			}
+			webhooks.Wait(webhookWait)
+			if err := shutdown(ctx); err != nil {
+				slog.Warn("Flushing traces failed", "error", err)
+			}
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "rag: unknown command %q\n\n", name)
+	printUsage()
+	os.Exit(2)
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "usage: rag <command> [flags]")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun `rag <command> -h` for the flags of a command. Backends are configured")
+	fmt.Fprintln(os.Stderr, "through environment variables; see env_example.txt.")
+}
+
+// webhookWait is how long a finishing command waits for its webhook
+// deliveries.
+const webhookWait = 30 * time.Second
+
+// fatal logs msg and its attributes at error level and exits, keeping the
+// token usage recorded so far and delivering pending webhooks.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	usageLedger.Flush()
+	webhooks.Wait(webhookWait)
+	os.Exit(1)
+}
+
+// mustApp builds the application from the environment or exits.
+func mustApp() *app {
+	a, err := newAppFromEnv()
+	if err != nil {
+		fatal("Configuration error", "error", err)
+	}
+	return a
+}
+
+// retrievalFlags registers the flags shared by the query-style commands.
+type retrievalFlags struct {
+	limit       *int
+	filter      *string
+	partitions  *string
+	languages   *string
+	collections *string
+	model       *string
+	mmr         *float64
+	expand      *int
+	hyde        *bool
+	graphHops   *int
+}
+
+func addRetrievalFlags(fs *flag.FlagSet) retrievalFlags {
+	return retrievalFlags{
+		limit:       fs.Int("limit", 3, "number of documents to retrieve"),
+		filter:      fs.String("filter", "", `metadata filter, e.g. 'source == "Go Docs" and page > 2'`),
+		partitions:  fs.String("partitions", "", `comma-separated partitions to search, e.g. "handbook,2024-q3" (default: all)`),
+		languages:   fs.String("languages", "", `comma-separated languages to search, as ISO 639-1 codes, e.g. "en,de" (default: all)`),
+		collections: fs.String("collections", "", `comma-separated collections to search, with optional weights, e.g. "docs:1,tickets:0.5" (default: SEARCH_COLLECTIONS)`),
+		model:       fs.String("model", "", "chat model (defaults to CHAT_MODEL or the provider default)"),
+		mmr:         fs.Float64("mmr", 0, "select diverse documents by MMR with this relevance weight (0-1, e.g. 0.5); 0 disables"),
+		expand:      fs.Int("multi-query", 0, "also search this many LLM-written rewordings of the question (e.g. 3)"),
+		hyde:        fs.Bool("hyde", false, "search with an LLM-drafted hypothetical answer instead of the question"),
+		graphHops:   fs.Int("graph-hops", 0, fmt.Sprintf("also retrieve the chunks relating the question's entities, up to this many knowledge graph steps away (at most %d; needs GRAPH_DB)", maxGraphHops)),
+	}
+}
+
+// options resolves the flags against the app's defaults.
+func (f retrievalFlags) options(a *app) (string, []RetrieveOption) {
+	model := a.chatModel
+	if *f.model != "" {
+		model = *f.model
+	}
+	var opts []RetrieveOption
+	if *f.filter != "" {
+		filter, err := ParseFilter(*f.filter)
+		if err != nil {
+			fatal("Invalid --filter", "error", err)
+		}
+		opts = append(opts, WithFilter(filter))
+	}
+	partitions, err := parsePartitions(*f.partitions)
+	if err != nil {
+		fatal("Invalid --partitions", "error", err)
+	}
+	if len(partitions) > 0 {
+		opts = append(opts, WithPartitions(partitions...))
+	}
+	languages, err := parseLanguages(*f.languages)
+	if err != nil {
+		fatal("Invalid --languages", "error", err)
+	}
+	if len(languages) > 0 {
+		opts = append(opts, WithLanguages(languages...))
+	}
+	collections, err := parseCollectionWeights(*f.collections)
+	if err != nil {
+		fatal("Invalid --collections", "error", err)
+	}
+	if collections != nil {
+		if err := a.engine.checkCollectionWeights(collections); err != nil {
+			fatal("Invalid --collections", "error", err)
+		}
+		opts = append(opts, WithCollectionWeights(collections))
+	}
+	if *f.mmr < 0 || *f.mmr > 1 {
+		fatal("Invalid --mmr, expected a weight between 0 and 1", "mmr", *f.mmr)
+	}
+	if *f.mmr > 0 {
+		opts = append(opts, WithMMR(*f.mmr))
+	}
+	if *f.expand > 0 {
+		opts = append(opts, WithMultiQuery(model, *f.expand))
+	}
+	if *f.hyde {
+		opts = append(opts, WithHyDE(model))
+	}
+	if *f.graphHops < 0 || *f.graphHops > maxGraphHops {
+		fatal("Invalid --graph-hops", "graph_hops", *f.graphHops, "max", maxGraphHops)
+	}
+	if *f.graphHops > 0 && a.engine.graph == nil {
+		fatal("--graph-hops needs the knowledge graph; set GRAPH_DB")
+	}
+	if *f.graphHops > 0 {
+		opts = append(opts, WithGraphExpansion(*f.graphHops))
+	}
+	return model, opts
+}
+
+// runQuery implements `rag query "question"`: it retrieves context, generates
+// an answer, and prints it with its citations.
+func runQuery(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	rf := addRetrievalFlags(fs)
+	schemaPath := fs.String("schema", "", "JSON Schema file; answer with JSON matching it")
+	decompose := fs.Bool("decompose", false, "split a compound question into parts and retrieve for each")
+	rounds := fs.Int("rounds", 1, fmt.Sprintf("let the model search again with refined queries until the context suffices, up to this many searches (at most %d)", maxAgentRounds))
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: rag query [flags] <question>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	question := strings.Join(fs.Args(), " ")
+	if question == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	a := mustApp()
+	defer a.close()
+	model, opts := rf.options(a)
+
+	grading := &RelevanceReport{}
+	ctx = withRelevanceReport(withQueryID(ctx, newQueryID()), grading)
+	if *rounds < 1 || *rounds > maxAgentRounds {
+		fatal("Invalid --rounds", "rounds", *rounds, "max", maxAgentRounds)
+	}
+	if *rounds > 1 {
+		if *decompose || *schemaPath != "" {
+			fatal("Use --rounds without --decompose or --schema")
+		}
+		answer, err := a.engine.AnswerIterative(ctx, question, *rf.limit, model, *rounds, opts...)
+		if err != nil {
+			fatal("Generating answer failed", "error", err)
+		}
+		for i, round := range answer.Rounds {
+			fmt.Printf("Round %d: %s (%d documents, %d new) %s\n", i+1, round.Query, len(round.Documents), round.New, round.Verdict)
+		}
+		fmt.Println()
+		fmt.Println(answer.Text)
+		printCitations(answer)
+		printGrading(grading)
+		return
+	}
+	if *decompose {
+		if *schemaPath != "" {
+			fatal("Use only one of --decompose or --schema")
+		}
+		answer, err := a.engine.AnswerDecomposed(ctx, question, *rf.limit, model, opts...)
+		if err != nil {
+			fatal("Generating answer failed", "error", err)
+		}
+		fmt.Println(answer.Text)
+		printCitations(answer)
+		for i, part := range answer.Parts {
+			fmt.Printf("\nPart %d: %s\n", i+1, part.Question)
+			printCitations(Answer{Citations: part.Citations})
+		}
+		printGrading(grading)
+		return
+	}
+	if refusal, ok := a.engine.OutOfScope(ctx, question); ok {
+		fmt.Println(refusal.Text)
+		return
+	}
+	docs := a.engine.Retrieve(ctx, question, *rf.limit, opts...)
+	if *schemaPath != "" {
+		data, err := os.ReadFile(*schemaPath)
+		if err != nil {
+			fatal("Reading schema failed", "error", err)
+		}
+		var schema map[string]any
+		if err := json.Unmarshal(data, &schema); err != nil {
+			fatal("Invalid schema", "path", *schemaPath, "error", err)
+		}
+		answer, err := a.engine.GenerateStructuredResponse(ctx, question, docs, model, schema)
+		if err != nil {
+			fatal("Generating answer failed", "error", err)
+		}
+		if answer.NoContext {
+			fatal(InsufficientContextResponse)
+		}
+		fmt.Println(string(answer.Data))
+		return
+	}
+	answer, err := a.engine.GenerateResponse(ctx, question, docs, model)
+	if err != nil {
+		fatal("Generating answer failed", "error", err)
+	}
+	fmt.Println(answer.Text)
+	printCitations(answer)
+	printGrading(grading)
+}
+
+// runSummarize implements `rag summarize <source>`: it summarizes every
+// chunk of the source and prints the summary.
+func runSummarize(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("summarize", flag.ExitOnError)
+	mode := fs.String("mode", string(SummaryMapReduce), "map_reduce summarizes parts independently and combines them; refine revises one summary part by part")
+	model := fs.String("model", "", "chat model (defaults to CHAT_MODEL or the provider default)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: rag summarize [flags] <source>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	summaryMode, err := ParseSummaryMode(*mode)
+	if err != nil {
+		fatal("Invalid --mode", "error", err)
+	}
+
+	a := mustApp()
+	defer a.close()
+	summary, err := a.engine.Summarize(ctx, fs.Arg(0), cmp.Or(*model, a.chatModel), summaryMode)
+	if err != nil {
+		fatal("Summarizing failed", "source", fs.Arg(0), "error", err)
+	}
+	slog.Info("Summarized source", "source", summary.Source, "chunks", summary.Chunks, "calls", summary.Calls)
+	fmt.Println(summary.Text)
+}
+
+// runChat implements `rag chat`: an interactive loop reading questions from
+// stdin and answering them in a single conversation.
+func runChat(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("chat", flag.ExitOnError)
+	rf := addRetrievalFlags(fs)
+	fs.Parse(args)
+
+	a := mustApp()
+	defer a.close()
+	model, opts := rf.options(a)
+
+	conv := a.engine.NewConversation()
+	fmt.Println("Ask a question (type /exit or press Ctrl-D to quit).")
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Print("> ")
+		if !scanner.Scan() {
+			fmt.Println()
+			break
+		}
+		question := strings.TrimSpace(scanner.Text())
+		if question == "" {
+			continue
+		}
+		if question == "/exit" || question == "/quit" {
+			break
+		}
+		answer, err := a.engine.Chat(withQueryID(ctx, newQueryID()), conv, question, *rf.limit, model, opts...)
+		if err != nil {
+			slog.Error("Answering failed", "error", err)
+			continue
+		}
+		fmt.Println(answer.Text)
+		printCitations(answer)
+	}
+	if err := scanner.Err(); err != nil {
+		fatal("Reading input failed", "error", err)
+	}
+}
+
+// runServe implements `rag serve`: it serves the JSON query API until the
+// process is stopped.
+func runServe(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", ":8080", "address to listen on")
+	check := fs.Bool("check", false, "run the readiness checks once and exit with status 1 if one fails, e.g. in an init container")
+	fs.Parse(args)
+
+	a := mustApp()
+	defer a.close()
+
+	checks := DependencyChecks(a.store, a.engine.llm)
+	report := RunReadinessChecks(ctx, checks)
+	for _, result := range report.Checks {
+		if result.OK {
+			slog.Info("Readiness check passed", "check", result.Name, "duration_ms", result.DurationMS)
+		} else {
+			slog.Warn("Readiness check failed", "check", result.Name, "error", result.Error)
+		}
+	}
+	if *check {
+		if report.Status != "ready" {
+			fatal("Not ready to serve")
+		}
+		return
+	}
+
+	server := NewServer(a.engine, a.chatModel)
+	server.SetReadinessChecks(checks)
+	if a.experiment != nil {
+		server.SetExperiment(a.experiment)
+	}
+	if origins := os.Getenv("CHAT_ALLOWED_ORIGINS"); origins != "" {
+		server.AllowChatOrigins(strings.Split(origins, ","))
+	}
+	if path := os.Getenv("API_KEYS_FILE"); path != "" {
+		keys, err := LoadAPIKeys(path)
+		if err == nil {
+			err = server.RequireAPIKeys(keys)
+		}
+		if err != nil {
+			fatal("Loading API keys failed", "error", err)
+		}
+		slog.Info("Requiring API keys", "keys", len(keys), "tenants", len(server.tenants))
+	}
+
+	if path := jobsDB(); path != "" {
+		workers, err := jobWorkersFromEnv()
+		if err != nil {
+			fatal("Configuration error", "error", err)
+		}
+		queue, err := NewJobQueue(ctx, path, a.engine, workers)
+		if err != nil {
+			fatal("Opening the job queue failed", "error", err)
+		}
+		defer queue.Close()
+		server.SetJobQueue(queue)
+		go queue.Run(ctx)
+	}
+
+	go usageLedger.flushEvery(ctx, time.Minute)
+	if path := os.Getenv("SYNC_SCHEDULE_FILE"); path != "" {
+		go loadScheduler(a, path, os.Getenv("SYNC_REPORT_FILE"), "").Run(ctx)
+	}
+	if expiring, ok := a.store.(ExpiringStore); ok {
+		interval, err := expiryIntervalFromEnv()
+		if err != nil {
+			fatal("Configuration error", "error", err)
+		}
+		if interval > 0 {
+			go runExpiryJanitor(ctx, expiring, interval)
+		}
+	}
+	slog.Info("Serving the RAG API", "addr", *addr)
+	if err := http.ListenAndServe(*addr, server); err != nil {
+		fatal("Server stopped", "error", err)
+	}
+}
+
+// runEval implements `rag eval --dataset qa.jsonl`: it answers every question
+// in the dataset and reports whether the expected sources were retrieved and
+// cited.
+func runEval(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("eval", flag.ExitOnError)
+	dataset := fs.String("dataset", "", "JSONL file of {\"question\", \"expected_sources\"} records")
+	judge := fs.Bool("judge", true, "score each answer's faithfulness and relevance with an LLM judge")
+	judgeModel := fs.String("judge-model", "", "chat model of the judge (defaults to --model)")
+	output := fs.String("output", "", "also write the per-question results and summary to this JSON file")
+	retrievalOnly := fs.Bool("retrieval-only", false, "benchmark retrieval alone with recall@k, MRR, and nDCG@k, without generating answers")
+	cutoffs := fs.String("k", "1,3,5,10", "comma-separated cutoffs k for --retrieval-only")
+	experiment := fs.Bool("experiment", false, "answer the dataset with every pipeline of EXPERIMENT_FILE and compare them")
+	split := fs.Bool("split", false, "with --experiment, answer each question with one pipeline, split as the server splits traffic")
+	rf := addRetrievalFlags(fs)
+	fs.Parse(args)
+	if *dataset == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *experiment && *retrievalOnly {
+		fatal("Use only one of --experiment or --retrieval-only")
+	}
+	if *split && !*experiment {
+		fatal("--split needs --experiment")
+	}
+
+	cases, err := LoadEvalDataset(*dataset)
+	if err != nil {
+		fatal("Loading dataset failed", "error", err)
+	}
+	a := mustApp()
+	defer a.close()
+	model, opts := rf.options(a)
+	if *retrievalOnly {
+		ks, err := parseCutoffs(*cutoffs)
+		if err != nil {
+			fatal("Invalid --k", "error", err)
+		}
+		printRetrievalBenchmark(a.engine.BenchmarkRetrieval(ctx, cases, ks, opts...))
+		return
+	}
+	var judgeWith *AnswerJudge
+	if *judge {
+		if *judgeModel == "" {
+			*judgeModel = model
+		}
+		judgeWith = NewAnswerJudge(a.engine.llm, *judgeModel)
+	}
+	if *experiment {
+		if a.experiment == nil {
+			fatal("--experiment needs EXPERIMENT_FILE")
+		}
+		runExperimentEval(ctx, a.experiment, a.engine, cases, *rf.limit, model, judgeWith, *split, *output, opts)
+		return
+	}
+
+	results, summary, err := a.engine.Evaluate(ctx, cases, *rf.limit, model, judgeWith, opts...)
+	if err != nil {
+		fatal("Evaluation failed", "error", err)
+	}
+	webhooks.Notify(EventEvalFinished, a.engine.tenant, evalFinishedEvent{Dataset: *dataset, Model: model, Summary: newEvalSummaryJSON(summary)})
+	for i, result := range results {
+		scores := ""
+		if *judge {
+			faithfulness, relevance := "-", "-"
+			if result.Scores != nil {
+				faithfulness = fmt.Sprintf("%.2f", result.Scores.Faithfulness)
+				relevance = fmt.Sprintf("%.2f", result.Scores.Relevance)
+			}
+			scores = fmt.Sprintf("faithfulness=%-4s relevance=%-4s ", faithfulness, relevance)
+		}
+		fmt.Printf("%3d. retrieved=%-5t cited=%-5t %s%s\n", i+1, result.Retrieved, result.Cited, scores, truncateText(result.Case.Question, 60))
+	}
+	if *output != "" {
+		if err := writeEvalReport(*output, results, summary); err != nil {
+			fatal("Writing results failed", "error", err)
+		}
+	}
+	if summary.Questions == 0 {
+		return
+	}
+	fmt.Printf("\nQuestions:      %d\n", summary.Questions)
+	fmt.Printf("Retrieval hits: %d (%.1f%%)\n", summary.RetrievalHits, 100*float64(summary.RetrievalHits)/float64(summary.Questions))
+	fmt.Printf("Citation hits:  %d (%.1f%%)\n", summary.CitationHits, 100*float64(summary.CitationHits)/float64(summary.Questions))
+	fmt.Printf("No context:     %d\n", summary.NoContext)
+	if summary.Judged > 0 {
+		fmt.Printf("Judged:         %d\n", summary.Judged)
+		fmt.Printf("Faithfulness:   %.2f\n", summary.Faithfulness)
+		fmt.Printf("Relevance:      %.2f\n", summary.Relevance)
+	}
+}
+
+// runExperimentEval implements `rag eval --experiment`: it evaluates every
+// pipeline of the experiment on the cases and prints their summaries side by
+// side.
+func runExperimentEval(ctx context.Context, experiment *Experiment, engine *RAGEngine, cases []EvalCase, limit int, model string, judge *AnswerJudge, split bool, output string, opts []RetrieveOption) {
+	results, err := experiment.Evaluate(ctx, engine, cases, limit, model, judge, split, opts...)
+	if err != nil {
+		fatal("Evaluation failed", "experiment", experiment.Name, "error", err)
+	}
+	if output != "" {
+		if err := writeExperimentReport(output, experiment.Name, results); err != nil {
+			fatal("Writing results failed", "error", err)
+		}
+	}
+	percent := func(n, of int) string {
+		if of == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(of))
+	}
+	fmt.Printf("Experiment: %s\n\n", experiment.Name)
+	fmt.Printf("%-20s %9s %9s %9s %10s %12s %9s %9s\n", "PIPELINE", "QUESTIONS", "RETRIEVED", "CITED", "NO CONTEXT", "FAITHFULNESS", "RELEVANCE", "LATENCY")
+	for _, result := range results {
+		summary := result.Summary
+		faithfulness, relevance := "-", "-"
+		if summary.Judged > 0 {
+			faithfulness = fmt.Sprintf("%.2f", summary.Faithfulness)
+			relevance = fmt.Sprintf("%.2f", summary.Relevance)
+		}
+		fmt.Printf("%-20s %9d %9s %9s %10d %12s %9s %9s\n", truncateText(result.Pipeline, 20), summary.Questions,
+			percent(summary.RetrievalHits, summary.Questions), percent(summary.CitationHits, summary.Questions),
+			summary.NoContext, faithfulness, relevance, result.Latency.Round(time.Millisecond))
+	}
+}
+
+// runRegress implements `rag regress`: it answers the questions of a dataset
+// and diffs the answers and retrieved documents against a stored snapshot,
+// exiting with status 1 if any question regressed. Without a snapshot, or
+// with --update, it records one instead.
+func runRegress(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("regress", flag.ExitOnError)
+	queries := fs.String("queries", "", "JSONL file of {\"question\"} records, as used by eval")
+	snapshotPath := fs.String("snapshot", "snapshot.json", "snapshot file to compare against, or to record")
+	update := fs.Bool("update", false, "record a new snapshot instead of comparing against the existing one")
+	minSimilarity := fs.Float64("min-answer-similarity", 0.8, "lowest similarity (0-1) between the old and new answer that is not a regression")
+	minOverlap := fs.Float64("min-overlap", 0.5, "lowest share (0-1) of the snapshot's documents that must still be retrieved")
+	rf := addRetrievalFlags(fs)
+	fs.Parse(args)
+	if *queries == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cases, err := LoadEvalDataset(*queries)
+	if err != nil {
+		fatal("Loading queries failed", "error", err)
+	}
+	baseline, err := LoadSnapshot(*snapshotPath)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		*update = true
+	case err != nil:
+		fatal("Loading snapshot failed", "error", err)
+	}
+	a := mustApp()
+	defer a.close()
+	model, opts := rf.options(a)
+
+	current, err := a.engine.TakeSnapshot(ctx, cases, *rf.limit, model, opts...)
+	if err != nil {
+		fatal("Answering questions failed", "error", err)
+	}
+	if *update {
+		if err := current.Save(*snapshotPath); err != nil {
+			fatal("Saving snapshot failed", "error", err)
+		}
+		fmt.Printf("Recorded %d answers in %s\n", len(current.Entries), *snapshotPath)
+		return
+	}
+	if baseline.Model != current.Model || baseline.Limit != current.Limit {
+		slog.Warn("Snapshot was recorded with different settings", "model", baseline.Model, "limit", baseline.Limit)
+	}
+
+	diffs := CompareSnapshots(ctx, baseline, current, RegressionThresholds{MinAnswerSimilarity: *minSimilarity, MinDocumentOverlap: *minOverlap})
+	var regressed, changed, added int
+	for i, diff := range diffs {
+		status := "ok"
+		switch {
+		case diff.New:
+			status, added = "new", added+1
+		case diff.Regressed():
+			status, regressed = "REGRESSED", regressed+1
+		case diff.AnswerChanged || len(diff.AddedDocuments) > 0 || len(diff.MissingDocuments) > 0 || diff.Reordered:
+			status, changed = "changed", changed+1
+		}
+		fmt.Printf("%3d. %-9s %s\n", i+1, status, truncateText(diff.Question, 70))
+		if diff.AnswerChanged {
+			fmt.Printf("       answer changed (similarity %.2f)\n", diff.AnswerSimilarity)
+		}
+		for _, id := range diff.MissingDocuments {
+			fmt.Printf("       - %s\n", id)
+		}
+		for _, id := range diff.AddedDocuments {
+			fmt.Printf("       + %s\n", id)
+		}
+		if diff.Reordered {
+			fmt.Printf("       documents reordered\n")
+		}
+		for _, reason := range diff.Regressions {
+			fmt.Printf("       ! %s\n", reason)
+		}
+	}
+	fmt.Printf("\nQuestions: %d\n", len(diffs))
+	fmt.Printf("Unchanged: %d\n", len(diffs)-regressed-changed-added)
+	fmt.Printf("Changed:   %d\n", changed)
+	fmt.Printf("New:       %d\n", added)
+	fmt.Printf("Regressed: %d\n", regressed)
+	if regressed > 0 {
+		a.close()
+		os.Exit(1)
+	}
+}
+
+// printRetrievalBenchmark prints the rank of the first expected source for
+// each question, then the aggregate metrics per cutoff.
+func printRetrievalBenchmark(results []RetrievalCaseResult, bench RetrievalBenchmark) {
+	for i, result := range results {
+		rank := "-"
+		if result.FirstHit > 0 {
+			rank = strconv.Itoa(result.FirstHit)
+		}
+		fmt.Printf("%3d. first hit=%-3s %s\n", i+1, rank, truncateText(result.Case.Question, 60))
+	}
+	if bench.Questions == 0 {
+		return
+	}
+	fmt.Printf("\nQuestions: %d\n", bench.Questions)
+	fmt.Printf("MRR:       %.3f\n\n", bench.MRR)
+	fmt.Printf("%6s  %8s  %8s\n", "k", "recall@k", "nDCG@k")
+	for _, m := range bench.AtK {
+		fmt.Printf("%6d  %8.3f  %8.3f\n", m.K, m.Recall, m.NDCG)
+	}
+}
+
+// runQuantizationBench implements `rag quantization-bench`: it embeds the
+// chunks of the files under --dir and the questions of --dataset with the
+// configured embedder, and reports how much of each question's exact top k
+// float16, int8, and binary vectors still find, next to the memory they take.
+func runQuantizationBench(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("quantization-bench", flag.ExitOnError)
+	dir := fs.String("dir", "", "directory whose supported files form the corpus")
+	dataset := fs.String("dataset", "", "JSONL file of {\"question\"} records to search with")
+	k := fs.Int("k", 10, "results compared per question")
+	chunkSize := fs.Int("chunk-size", 1000, "maximum characters per chunk")
+	overlap := fs.Int("overlap", 200, "characters shared between consecutive chunks")
+	fs.Parse(args)
+	if *dir == "" || *dataset == "" || *k <= 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cases, err := LoadEvalDataset(*dataset)
+	if err != nil {
+		fatal("Loading dataset failed", "error", err)
+	}
+	embedder, _, err := newEmbedder(cmp.Or(os.Getenv("LLM_PROVIDER"), "openai"), "")
+	if err != nil {
+		fatal("Configuration error", "error", err)
+	}
+	store := NewMemoryStore(embedder)
+	report, err := IngestDirectory(ctx, NewRAGEngine(nil, store), *dir, DirectoryOptions{ChunkSize: *chunkSize, Overlap: *overlap})
+	if err != nil {
+		fatal("Embedding corpus failed", "dir", *dir, "error", err)
+	}
+	for _, fe := range report.Errors {
+		slog.Warn("File skipped", "file", fe.Path, "error", fe.Err)
+	}
+	questions := make([]string, len(cases))
+	for i, c := range cases {
+		questions[i] = c.Question
+	}
+	queries, err := embedder.Embed(withQueryEmbedding(ctx), questions)
+	if err != nil {
+		fatal("Embedding questions failed", "error", err)
+	}
+
+	corpus := store.vectors()
+	fmt.Printf("Chunks: %d  Questions: %d  k: %d\n\n", len(corpus), len(queries), *k)
+	fmt.Printf("%-8s  %12s  %12s  %8s\n", "vectors", "bytes/vector", "corpus MiB", "recall@k")
+	for _, result := range BenchmarkQuantization(corpus, queries, *k) {
+		mib := float64(result.BytesPerVector) * float64(len(corpus)) / (1 << 20)
+		fmt.Printf("%-8s  %12d  %12.1f  %8.3f\n", result.Quantization, result.BytesPerVector, mib, result.Recall)
+	}
+}
+
+// runBench implements `rag bench`: it chunks the files under --dir and
+// embeds their chunks, then searches and answers the questions of --dataset,
+// each stage with --concurrency operations in flight, and prints the
+// throughput and latency percentiles of every stage.
+func runBench(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	dir := fs.String("dir", "", "directory whose supported files are chunked and embedded")
+	dataset := fs.String("dataset", "", "JSONL file of {\"question\"} records to search and answer")
+	stages := fs.String("stages", "", "comma-separated stages to run: chunk, embed, search, query (default: chunk and embed with --dir, search and query with --dataset)")
+	concurrency := fs.Int("concurrency", 4, "operations in flight at once")
+	requests := fs.Int("requests", 50, "searches and queries to run, cycling through the questions")
+	batch := fs.Int("batch", 32, "texts per embedding request")
+	chunkSize := fs.Int("chunk-size", 1000, "maximum characters per chunk")
+	overlap := fs.Int("overlap", 200, "characters shared between consecutive chunks")
+	rf := addRetrievalFlags(fs)
+	fs.Parse(args)
+	run := make(map[string]bool)
+	for _, stage := range splitPatterns(*stages) {
+		if !slices.Contains([]string{"chunk", "embed", "search", "query"}, stage) {
+			fatal("Unknown stage", "stage", stage)
+		}
+		run[stage] = true
+	}
+	if len(run) == 0 {
+		run = map[string]bool{"chunk": *dir != "", "embed": *dir != "", "search": *dataset != "", "query": *dataset != ""}
+	}
+	if (run["chunk"] || run["embed"]) && *dir == "" || (run["search"] || run["query"]) && *dataset == "" ||
+		*concurrency <= 0 || *requests <= 0 || *batch <= 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var texts []string
+	var report []BenchStage
+	if *dir != "" {
+		pages, err := loadBenchCorpus(*dir)
+		if err != nil {
+			fatal("Loading corpus failed", "dir", *dir, "error", err)
+		}
+		if run["chunk"] {
+			report = append(report, benchChunking(ctx, pages, *chunkSize, *overlap, *concurrency))
+		}
+		texts, _, _ = chunkPages(pages, *chunkSize, *overlap)
+	}
+	if !run["embed"] && !run["search"] && !run["query"] {
+		printBench(report)
+		return
+	}
+
+	a := mustApp()
+	defer a.close()
+	if run["embed"] {
+		report = append(report, benchEmbedding(ctx, a.embedder, texts, *batch, *concurrency))
+	}
+	if *dataset != "" {
+		cases, err := LoadEvalDataset(*dataset)
+		if err != nil {
+			fatal("Loading dataset failed", "error", err)
+		}
+		questions := make([]string, len(cases))
+		for i, c := range cases {
+			questions[i] = c.Question
+		}
+		if len(questions) == 0 {
+			fatal("The dataset has no questions", "dataset", *dataset)
+		}
+		if run["search"] {
+			report = append(report, benchSearch(ctx, a.store, questions, *requests, *rf.limit, *concurrency))
+		}
+		if run["query"] {
+			model, opts := rf.options(a)
+			report = append(report, benchQuery(ctx, a.engine, questions, *requests, *rf.limit, model, *concurrency, opts...))
+		}
+	}
+	printBench(report)
+}
+
+// printBench prints a row of throughput and latency percentiles per stage.
+func printBench(stages []BenchStage) {
+	fmt.Printf("%-7s %6s %6s %16s %9s %9s %9s %9s %9s %9s\n", "stage", "ops", "errors", "throughput", "mean", "p50", "p90", "p95", "p99", "max")
+	for _, stage := range stages {
+		s := stage.Stats
+		throughput := fmt.Sprintf("%.1f %s/s", s.PerSecond(stage.Items), stage.Unit)
+		fmt.Printf("%-7s %6d %6d %16s %9s %9s %9s %9s %9s %9s\n", stage.Name, s.Ops, s.Errors, throughput,
+			benchDuration(s.Mean), benchDuration(s.P50), benchDuration(s.P90), benchDuration(s.P95), benchDuration(s.P99), benchDuration(s.Max))
+	}
+}
+
+// benchDuration rounds d to a readable precision for its magnitude.
+func benchDuration(d time.Duration) string {
+	switch {
+	case d >= time.Second:
+		return d.Round(10 * time.Millisecond).String()
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond).String()
+	}
+	return d.Round(10 * time.Nanosecond).String()
+}
+
+// runLoadtest implements `rag loadtest`: it sends the questions of
+// --queries to the server at --url at --qps requests per second for
+// --duration, then prints the latency percentiles and error rate of each
+// stage of the requests. Interrupting it stops the test early.
+func runLoadtest(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("loadtest", flag.ExitOnError)
+	serverURL := fs.String("url", "http://localhost:8080", "base URL of the running `rag serve`")
+	queries := fs.String("queries", "", "file of questions to send, one per line or as JSONL {\"question\"} records")
+	qps := fs.Float64("qps", 10, "requests sent per second")
+	duration := fs.Duration("duration", time.Minute, "how long to send requests")
+	maxInFlight := fs.Int("max-in-flight", 1000, "requests awaiting an answer at most; requests due beyond it are dropped")
+	timeout := fs.Duration("timeout", 2*time.Minute, "time a request may take before it fails")
+	apiKey := fs.String("api-key", "", "API key of the server, if it requires one")
+	fs.Parse(args)
+	if *queries == "" || *qps <= 0 || *duration <= 0 || *maxInFlight <= 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	questions, err := loadQuestions(*queries)
+	if err != nil {
+		fatal("Loading queries failed", "error", err)
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	test := &LoadTest{
+		URL:         *serverURL,
+		APIKey:      *apiKey,
+		Questions:   questions,
+		QPS:         *qps,
+		Duration:    *duration,
+		MaxInFlight: *maxInFlight,
+		Client:      &http.Client{Timeout: *timeout},
+	}
+	slog.Info("Starting load test", "url", *serverURL, "qps", *qps, "duration", *duration, "questions", len(questions))
+	report := test.Run(ctx)
+
+	fmt.Printf("Sent: %d  Dropped: %d  Canceled: %d  Elapsed: %s  Achieved: %.1f req/s\n\n",
+		report.Sent, report.Dropped, report.Canceled, benchDuration(report.Elapsed), float64(report.Sent)/report.Elapsed.Seconds())
+	fmt.Printf("%-12s %7s %7s %7s %9s %9s %9s %9s\n", "stage", "ok", "errors", "error%", "p50", "p95", "p99", "max")
+	for _, stage := range report.Stages {
+		s := stage.Stats
+		fmt.Printf("%-12s %7d %7d %6.2f%% %9s %9s %9s %9s\n", stage.Name, s.Ops, s.Errors, 100*stage.ErrorRate(),
+			benchDuration(s.P50), benchDuration(s.P95), benchDuration(s.P99), benchDuration(s.Max))
+	}
+	if len(report.Failures) > 0 {
+		fmt.Println("\nFailures:")
+		for _, failure := range slices.Sorted(maps.Keys(report.Failures)) {
+			fmt.Printf("  %-20s %d\n", failure, report.Failures[failure])
+		}
+	}
+}
+
+// runIngest implements `rag ingest`: it loads a file (--file), every
+// matching file under a directory (--dir), or web pages (--url, optionally
+// crawling --depth links deep), chunks the text, and stores it in the
+// configured collection.
+func runIngest(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("ingest", flag.ExitOnError)
+	file := fs.String("file", "", "path of the document to ingest (PDF, Word, PowerPoint, CSV, JSONL, Markdown, plain text, or source code)")
+	textFields := fs.String("text-fields", "", "comma-separated CSV columns or JSONL fields that form each record's text (default: all not in --metadata-fields)")
+	metadataFields := fs.String("metadata-fields", "", `comma-separated CSV columns or JSONL fields stored as metadata, optionally renamed, e.g. "category,sku=product_id"`)
+	dir := fs.String("dir", "", "directory whose supported files are ingested recursively")
+	bucket := fs.String("bucket", "", "S3 or GCS location whose supported objects are ingested, e.g. s3://docs/handbook/")
+	include := fs.String("include", "", `comma-separated glob patterns of files to ingest from --dir, --bucket, or --github, e.g. "*.md,*.txt"`)
+	exclude := fs.String("exclude", "", "comma-separated glob patterns of files to skip in --dir, --bucket, or --github")
+	workers := fs.Int("workers", 4, "files ingested in parallel with --dir, --bucket, or --github")
+	github := fs.String("github", "", "GitHub repository whose supported files are ingested, as owner/repo[@ref]")
+	pageURL := fs.String("url", "", "URL of a web page to ingest")
+	depth := fs.Int("depth", 0, "how many links deep to crawl from --url (same host only)")
+	maxPages := fs.Int("max-pages", 100, "maximum number of pages to crawl")
+	chunkSize := fs.Int("chunk-size", 1000, "maximum characters per chunk")
+	overlap := fs.Int("overlap", 200, "characters shared between consecutive chunks")
+	acl := fs.String("acl", "", `comma-separated roles allowed to read the ingested documents, e.g. "hr,finance" (default: everyone)`)
+	partition := fs.String("partition", "", `partition the ingested documents are grouped in, e.g. a dataset or "2024-q3" (default: none)`)
+	ttl := fs.Duration("ttl", 0, `time after which the ingested documents expire, e.g. "720h" (default: never)`)
+	expiresAt := fs.String("expires", "", `time at which the ingested documents expire, as an RFC 3339 timestamp or a date such as 2025-01-31`)
+	fs.Parse(args)
+	checkPartition(*partition)
+	expires := ingestExpiry(*ttl, *expiresAt)
+
+	sources := 0
+	for _, set := range []bool{*file != "", *dir != "", *bucket != "", *github != "", *pageURL != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		fatal("Use only one of --file, --dir, --bucket, --github, or --url")
+	}
+	opts := DirectoryOptions{
+		Include:   splitPatterns(*include),
+		Exclude:   splitPatterns(*exclude),
+		Workers:   *workers,
+		ChunkSize: *chunkSize,
+		Overlap:   *overlap,
+	}
+	if *dir != "" {
+		runIngestFiles(ctx, *dir, parseACL(*acl), *partition, expires, func(engine *RAGEngine) (DirectoryReport, error) {
+			return IngestDirectory(ctx, engine, *dir, opts)
+		})
+		return
+	}
+	if *bucket != "" {
+		b, prefix, err := newBucket(*bucket)
+		if err != nil {
+			fatal("Invalid --bucket", "error", err)
+		}
+		runIngestFiles(ctx, *bucket, parseACL(*acl), *partition, expires, func(engine *RAGEngine) (DirectoryReport, error) {
+			return IngestBucket(ctx, engine, b, prefix, opts)
+		})
+		return
+	}
+	if *github != "" {
+		owner, name, ref, err := ParseGitHubRepo(*github)
+		if err != nil {
+			fatal("Invalid --github", "error", err)
+		}
+		repo := NewGitHubRepo(owner, name, ref, os.Getenv("GITHUB_TOKEN"))
+		runIngestFiles(ctx, *github, parseACL(*acl), *partition, expires, func(engine *RAGEngine) (DirectoryReport, error) {
+			return IngestGitHubRepo(ctx, engine, repo, opts)
+		})
+		return
+	}
+
+	var pages []Page
+	var err error
+	var origin string
+	switch {
+	case *file != "":
+		origin = *file
+		if recordFile(*file) {
+			pages, err = LoadRecords(*file, RecordOptions{
+				TextFields: splitPatterns(*textFields),
+				Metadata:   ParseFieldMapping(*metadataFields),
+			})
+		} else {
+			pages, err = LoadFile(*file)
+		}
+	case *pageURL != "":
+		origin = *pageURL
+		pages, err = NewHTMLLoader().Crawl(*pageURL, *depth, *maxPages)
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fatal("Loading documents failed", "error", err)
+	}
+	slog.Info("Extracted text", "pages", len(pages), "origin", origin)
+
+	a := mustApp()
+	defer a.close()
+
+	engine := a.engine.WithIngestACL(parseACL(*acl)).WithIngestPartition(*partition).WithIngestExpiry(expires)
+	report, ok := ingestPages(ctx, engine, pages, *chunkSize, *overlap)
+	if !ok {
+		fatal("Ingestion failed", "stored", report.Stored(), "report", report.String())
+	}
+	slog.Info("Ingested documents", "origin", origin, "inserted", report.Inserted, "updated", report.Updated, "skipped", report.Skipped, "removed", report.Removed, "dropped", report.Dropped)
+	logCacheStats(a.embedder)
+}
+
+// checkPartition exits if the --partition flag is not a valid partition name.
+func checkPartition(partition string) {
+	if partitions, err := parsePartitions(partition); err != nil || len(partitions) > 1 {
+		fatal("Invalid --partition, expected a single partition name", "partition", partition)
+	}
+}
+
+// ingestExpiry returns the expiry set by the --ttl or --expires flag, or the
+// zero time if neither is set, and exits if both are or one is invalid.
+func ingestExpiry(ttl time.Duration, expires string) time.Time {
+	switch {
+	case ttl != 0 && expires != "":
+		fatal("Use only one of --ttl or --expires")
+	case ttl < 0:
+		fatal("Invalid --ttl, expected a positive duration", "ttl", ttl)
+	case ttl > 0:
+		return time.Now().Add(ttl)
+	case expires != "":
+		t, err := parseExpiryTime(expires)
+		if err != nil {
+			fatal("Invalid --expires", "error", err)
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// runIngestFiles implements `rag ingest --dir` and `--bucket`: it runs ingest
+// against the configured engine, restricting the chunks to acl, storing them
+// in partition, and making them expire at expires unless it is zero, and
+// prints a summary of the files and chunks ingested and the files that
+// failed.
+func runIngestFiles(ctx context.Context, origin string, acl []string, partition string, expires time.Time, ingest func(*RAGEngine) (DirectoryReport, error)) {
+	a := mustApp()
+	defer a.close()
+
+	report, err := ingest(a.engine.WithIngestACL(acl).WithIngestPartition(partition).WithIngestExpiry(expires))
+	if err != nil {
+		fatal("Ingestion failed", "origin", origin, "error", err)
+	}
+	fmt.Printf("Files ingested: %d\n", report.Files)
+	fmt.Printf("Files skipped:  %d\n", report.Skipped)
+	if report.Removed > 0 {
+		fmt.Printf("Files removed:  %d\n", report.Removed)
+	}
+	fmt.Printf("Files failed:   %d\n", len(report.Errors))
+	fmt.Printf("Chunks:         %s\n", report.Chunks)
+	fmt.Printf("Duration:       %s\n", report.Duration.Round(time.Millisecond))
+	for _, fe := range report.Errors {
+		fmt.Printf("  %s: %v\n", fe.Path, fe.Err)
+	}
+	logCacheStats(a.embedder)
+	if len(report.Errors) > 0 {
+		fatal("Some files could not be ingested", "failed", len(report.Errors))
+	}
+}
+
+// runSync implements `rag sync notion|confluence|sitemap`: it ingests the pages edited
+// since the last sync and removes deleted ones, remembering what was synced in
+// SYNC_STATE_FILE.
+func runSync(ctx context.Context, args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: rag sync <notion|confluence|sitemap> [--database id] [--space key] [--url sitemap] [flags]")
+		os.Exit(2)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+	fs := flag.NewFlagSet("sync "+args[0], flag.ExitOnError)
+	database := fs.String("database", "", "Notion database to sync (default: every page shared with the integration)")
+	space := fs.String("space", "", "key of the Confluence space to sync")
+	sitemapURL := fs.String("url", "", "URL of the sitemap.xml whose pages are synced")
+	include := fs.String("include", "", "comma-separated glob patterns of page titles (URL paths for sitemaps) to sync")
+	exclude := fs.String("exclude", "", "comma-separated glob patterns of page titles (URL paths for sitemaps) to skip")
+	workers := fs.Int("workers", 4, "pages fetched and ingested in parallel")
+	chunkSize := fs.Int("chunk-size", 1000, "maximum characters per chunk")
+	overlap := fs.Int("overlap", 200, "characters shared between consecutive chunks")
+	acl := fs.String("acl", "", "comma-separated roles allowed to read the synced pages (default: everyone)")
+	partition := fs.String("partition", "", "partition the synced pages are grouped in (default: none)")
+	fs.Parse(args[1:])
+	checkPartition(*partition)
+
+	if !slices.Contains(connectorKinds, args[0]) {
+		usage()
+	}
+	connector, err := newConnector(args[0], *database, *space, *sitemapURL)
+	if err != nil {
+		fatal("Configuration error", "error", err)
+	}
+
+	state, err := LoadSyncState(syncStateFile())
+	if err != nil {
+		fatal("Loading sync state failed", "error", err)
+	}
+	runIngestFiles(ctx, connector.Name(), parseACL(*acl), *partition, time.Time{}, func(engine *RAGEngine) (DirectoryReport, error) {
+		report, err := SyncConnector(ctx, engine, connector, state, DirectoryOptions{
+			Include:   splitPatterns(*include),
+			Exclude:   splitPatterns(*exclude),
+			Workers:   *workers,
+			ChunkSize: *chunkSize,
+			Overlap:   *overlap,
+		})
+		if saveErr := state.Save(); saveErr != nil {
+			slog.Error("Saving sync state failed", "error", saveErr)
+		}
+		return report, err
+	})
+}
+
+// runSchedule implements `rag schedule`: it re-ingests the sources of a sync
+// jobs file on their cron schedules until interrupted, or once with --once.
+func runSchedule(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("schedule", flag.ExitOnError)
+	config := fs.String("config", os.Getenv("SYNC_SCHEDULE_FILE"), "JSON file of sync jobs (default $SYNC_SCHEDULE_FILE)")
+	report := fs.String("report", os.Getenv("SYNC_REPORT_FILE"), "JSONL file every run's sync report is appended to (default $SYNC_REPORT_FILE)")
+	once := fs.Bool("once", false, "run the jobs once now and exit, with status 1 if one fails")
+	job := fs.String("job", "", "only run the job of this name")
+	fs.Parse(args)
+	if *config == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	a := mustApp()
+	defer a.close()
+	scheduler := loadScheduler(a, *config, *report, *job)
+	if *once {
+		failed := 0
+		for _, job := range scheduler.jobs {
+			run := scheduler.RunJob(ctx, job)
+			fmt.Printf("%-20s files %d, chunks %d inserted, %d updated, %d skipped, %d removed",
+				run.Job, run.Files, run.ChunksInserted, run.ChunksUpdated, run.ChunksSkipped, run.ChunksRemoved)
+			if !run.OK() {
+				failed++
+				fmt.Printf(", FAILED: %s", cmp.Or(run.Error, strings.Join(run.FilesFailed, "; ")))
+			}
+			fmt.Println()
+		}
+		logCacheStats(a.embedder)
+		if failed > 0 {
+			fatal("Some sync jobs failed", "failed", failed)
+		}
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	scheduler.Run(ctx)
+	slog.Info("Stopped the scheduler")
+}
+
+// loadScheduler builds a scheduler for the sync jobs in config, or only the
+// one named only if it is set, or exits.
+func loadScheduler(a *app, config, report, only string) *Scheduler {
+	jobs, err := LoadSyncJobs(config)
+	if err != nil {
+		fatal("Loading sync jobs failed", "error", err)
+	}
+	if only != "" {
+		jobs = slices.DeleteFunc(jobs, func(job SyncJob) bool { return job.Name != only })
+		if len(jobs) == 0 {
+			fatal("No such sync job", "job", only, "config", config)
+		}
+	}
+	state, err := LoadSyncState(syncStateFile())
+	if err != nil {
+		fatal("Loading sync state failed", "error", err)
+	}
+	return NewScheduler(a.engine, jobs, state, report)
+}
+
+// runWatch implements `rag watch --dir ./docs`: it ingests the directory and
+// then re-ingests or removes files as they change, until interrupted.
+func runWatch(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	dir := fs.String("dir", "", "directory to keep in sync")
+	include := fs.String("include", "", `comma-separated glob patterns of files to ingest, e.g. "*.md,*.txt"`)
+	exclude := fs.String("exclude", "", "comma-separated glob patterns of files to skip")
+	workers := fs.Int("workers", 4, "files ingested in parallel during the initial sync")
+	debounce := fs.Duration("debounce", time.Second, "how long a file must be unchanged before it is re-ingested")
+	chunkSize := fs.Int("chunk-size", 1000, "maximum characters per chunk")
+	overlap := fs.Int("overlap", 200, "characters shared between consecutive chunks")
+	fs.Parse(args)
+	if *dir == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	a := mustApp()
+	defer a.close()
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	watcher := NewWatcher(a.engine, *dir, DirectoryOptions{
+		Include:   splitPatterns(*include),
+		Exclude:   splitPatterns(*exclude),
+		Workers:   *workers,
+		ChunkSize: *chunkSize,
+		Overlap:   *overlap,
+	}, *debounce)
+	slog.Info("Watching for changes", "dir", *dir)
+	if err := watcher.Run(ctx); err != nil {
+		fatal("Watching directory failed", "dir", *dir, "error", err)
+	}
+	slog.Info("Stopped watching", "dir", *dir)
+}
+
+// runExport implements `rag export`: it writes every chunk of the configured
+// collection, with its metadata and embedding, to a new export directory.
+func runExport(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	output := fs.String("output", "", "directory to write the export to; must not exist or be empty")
+	batch := fs.Int("batch", 1000, "chunks read from the store per request")
+	fs.Parse(args)
+	if *output == "" || *batch <= 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	a := mustApp()
+	defer a.close()
+	store, ok := a.store.(ExportableStore)
+	if !ok {
+		fatal("The configured vector store cannot export documents")
+	}
+	start := time.Now()
+	manifest, err := ExportCollection(ctx, store, *output, ExportManifest{
+		Backend:        cmp.Or(os.Getenv("VECTOR_STORE"), "milvus"),
+		Collection:     cmp.Or(os.Getenv("COLLECTION_NAME"), "rag_documents"),
+		EmbeddingModel: a.embeddingModel,
+		Dimension:      a.embedder.Dimension(),
+	}, *batch)
+	if err != nil {
+		fatal("Export failed", "output", *output, "error", err)
+	}
+	slog.Info("Exported collection", "output", *output, "documents", manifest.Documents, "duration", time.Since(start).Round(time.Millisecond))
+}
+
+// runImport implements `rag import`: it inserts the chunks of an export into
+// the configured vector store, keeping their embeddings unless --reembed is
+// given.
+func runImport(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	input := fs.String("input", "", "export directory written by `rag export`")
+	batch := fs.Int("batch", 500, "chunks inserted per request")
+	reembed := fs.Bool("reembed", false, "embed the texts again with the configured model instead of restoring the exported embeddings")
+	fs.Parse(args)
+	if *input == "" || *batch <= 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	manifest, err := ReadExportManifest(*input)
+	if err != nil {
+		fatal("Reading export failed", "input", *input, "error", err)
+	}
+	a := mustApp()
+	defer a.close()
+	if !*reembed {
+		if manifest.Dimension != a.embedder.Dimension() {
+			fatal("The export's embeddings do not fit the configured embedder; import with --reembed",
+				"export_dimension", manifest.Dimension, "dimension", a.embedder.Dimension())
+		}
+		if manifest.EmbeddingModel != a.embeddingModel {
+			fatal("The export was embedded with another model, whose vectors cannot be searched with the configured one; import with --reembed",
+				"export_model", manifest.EmbeddingModel, "model", a.embeddingModel)
+		}
+	}
+	start := time.Now()
+	imported, err := ImportCollection(ctx, a.store, *input, *batch, *reembed)
+	if err != nil {
+		fatal("Import failed", "input", *input, "imported", imported, "error", err)
+	}
+	slog.Info("Imported export", "input", *input, "documents", imported, "from", manifest.Backend+"/"+manifest.Collection, "duration", time.Since(start).Round(time.Millisecond))
+}
+
+// runDelete implements `rag delete --source <source>`.
+func runDelete(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("delete", flag.ExitOnError)
+	source := fs.String("source", "", "source to delete, e.g. a file name or URL as shown in citations")
+	fs.Parse(args)
+	if *source == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	a := mustApp()
+	defer a.close()
+	if err := a.engine.DeleteBySource(ctx, *source); err != nil {
+		fatal("Deleting documents failed", "source", *source, "error", err)
+	}
+	slog.Info("Deleted documents", "source", *source)
+}
+
+// runExpire implements `rag expire`: it deletes the chunks whose expiry has
+// passed once, e.g. from cron when `rag serve` is not running.
+func runExpire(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("expire", flag.ExitOnError)
+	fs.Parse(args)
+
+	a := mustApp()
+	defer a.close()
+	expiring, ok := a.store.(ExpiringStore)
+	if !ok {
+		fatal("The configured vector store does not support expiry")
+	}
+	removed, err := sweepExpired(ctx, expiring, time.Now())
+	if err != nil {
+		fatal("Deleting expired documents failed", "error", err)
+	}
+	fmt.Printf("Expired chunks deleted: %d\n", removed)
+}
+
+// runCollections implements `rag collections <list|describe|count|drop>` for
+// inspecting and resetting the knowledge base.
+func runCollections(ctx context.Context, args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: rag collections <list|describe|count|drop> [--name collection] [--yes]")
+		os.Exit(2)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+
+	store, err := connectMilvus()
+	if err != nil {
+		fatal("Connecting to Milvus failed", "error", err)
+	}
+	defer store.client.Close()
+
+	fs := flag.NewFlagSet("collections "+args[0], flag.ExitOnError)
+	name := fs.String("name", store.collectionName, "collection to operate on")
+	yes := fs.Bool("yes", false, "skip the confirmation prompt for drop")
+	fs.Parse(args[1:])
+
+	switch args[0] {
+	case "list":
+		names, err := store.ListCollections()
+		if err != nil {
+			fatal("Listing collections failed", "error", err)
+		}
+		for _, n := range names {
+			fmt.Println(n)
+		}
+	case "describe":
+		info, err := store.DescribeCollection(*name)
+		if err != nil {
+			fatal("Describing collection failed", "collection", *name, "error", err)
+		}
+		fmt.Printf("Name:        %s\n", info.Name)
+		fmt.Printf("Description: %s\n", info.Description)
+		fmt.Printf("Loaded:      %t\n", info.Loaded)
+		fmt.Printf("Shards:      %d\n", info.ShardNum)
+		fmt.Printf("Documents:   %d\n", info.RowCount)
+		fmt.Println("Fields:")
+		for _, f := range info.Fields {
+			line := fmt.Sprintf("  - %s (%s)", f.Name, f.Type)
+			if f.PrimaryKey {
+				line += " primary key"
+			}
+			keys := make([]string, 0, len(f.Params))
+			for k := range f.Params {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				line += fmt.Sprintf(" %s=%s", k, f.Params[k])
+			}
+			fmt.Println(line)
+		}
+	case "count":
+		count, err := store.CountDocuments(*name)
+		if err != nil {
+			fatal("Counting documents failed", "collection", *name, "error", err)
+		}
+		fmt.Println(count)
+	case "drop":
+		if !*yes {
+			fmt.Printf("Drop collection %q and all of its documents? [y/N] ", *name)
+			var answer string
+			fmt.Scanln(&answer)
+			if !strings.EqualFold(answer, "y") && !strings.EqualFold(answer, "yes") {
+				fmt.Println("Aborted.")
+				return
+			}
+		}
+		if err := store.DropCollection(*name); err != nil {
+			fatal("Dropping collection failed", "collection", *name, "error", err)
+		}
+		slog.Info("Dropped collection", "collection", *name)
+	default:
+		usage()
+	}
+}
+
+// runIndex implements `rag index <show|rebuild>`: show prints the vector
+// index of a collection, and rebuild replaces it with the one configured by
+// MILVUS_INDEX and its parameters (see milvusIndexFromEnv).
+func runIndex(ctx context.Context, args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: rag index <show|rebuild> [--name collection] [--yes]")
+		os.Exit(2)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+
+	store, err := connectMilvus()
+	if err != nil {
+		fatal("Connecting to Milvus failed", "error", err)
+	}
+	defer store.client.Close()
+
+	fs := flag.NewFlagSet("index "+args[0], flag.ExitOnError)
+	name := fs.String("name", store.collectionName, "collection to operate on")
+	yes := fs.Bool("yes", false, "skip the confirmation prompt for rebuild")
+	fs.Parse(args[1:])
+	store.collectionName = *name
+
+	switch args[0] {
+	case "show":
+		info, err := store.DescribeIndex(ctx)
+		if err != nil {
+			fatal("Describing index failed", "collection", *name, "error", err)
+		}
+		fmt.Printf("Type:   %s\n", info.Type)
+		keys := make([]string, 0, len(info.Params))
+		for k := range info.Params {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("  %s=%s\n", k, info.Params[k])
+		}
+	case "rebuild":
+		if !*yes {
+			fmt.Printf("Rebuild the index of %q as %s? Searches fail until it is done. [y/N] ", *name, store.index.describe())
+			var answer string
+			fmt.Scanln(&answer)
+			if !strings.EqualFold(answer, "y") && !strings.EqualFold(answer, "yes") {
+				fmt.Println("Aborted.")
+				return
+			}
+		}
+		start := time.Now()
+		if err := store.RebuildIndex(ctx); err != nil {
+			fatal("Rebuilding index failed", "collection", *name, "error", err)
+		}
+		slog.Info("Rebuilt index", "collection", *name, "index", store.index.describe(), "duration", time.Since(start).Round(time.Millisecond))
+	default:
+		usage()
+	}
+}
+
+// runVersions implements `rag versions`, which manages COLLECTION_NAME as an
+// alias over numbered collection versions: `list` shows them, `init` turns
+// an existing collection into version 1, `next` prints the name to ingest a
+// rebuild into, `promote` switches the alias to a version after optionally
+// checking its retrieval against the live one, and `rollback` switches back.
+func runVersions(ctx context.Context, args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: rag versions <list|init|next|promote|rollback> [--version N] [--dataset qa.jsonl] [--force]")
+		os.Exit(2)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+
+	switch args[0] {
+	case "list", "init", "next", "rollback":
+		fs := flag.NewFlagSet("versions "+args[0], flag.ExitOnError)
+		fs.Parse(args[1:])
+		store, err := connectMilvus()
+		if err != nil {
+			fatal("Connecting to Milvus failed", "error", err)
+		}
+		defer store.client.Close()
+		manageVersions(ctx, store, args[0])
+	case "promote":
+		promoteVersion(ctx, args[1:])
+	default:
+		usage()
+	}
+}
+
+// manageVersions runs the versions subcommands that need no embedder.
+func manageVersions(ctx context.Context, store *MilvusClientImpl, subcommand string) {
+	switch subcommand {
+	case "list":
+		versions, err := store.ListVersions(ctx)
+		if err != nil {
+			fatal("Listing versions failed", "error", err)
+		}
+		if len(versions) == 0 {
+			fmt.Printf("%s has no versions; run `rag versions init` to version it.\n", store.collectionName)
+		}
+		for _, version := range versions {
+			live := ""
+			if version.Live {
+				live = "  (live)"
+			}
+			fmt.Printf("%4d  %-32s %10d chunks%s\n", version.Number, version.Name, version.RowCount, live)
+		}
+	case "init":
+		first, err := store.InitVersions(ctx)
+		if err != nil {
+			fatal("Versioning collection failed", "collection", store.collectionName, "error", err)
+		}
+		slog.Info("Collection is versioned", "alias", store.collectionName, "live", first)
+	case "next":
+		next, err := store.NextVersion(ctx)
+		if err != nil {
+			fatal("Finding the next version failed", "error", err)
+		}
+		fmt.Println(next)
+	case "rollback":
+		previous, err := store.RollbackVersion(ctx)
+		if err != nil {
+			fatal("Rollback failed", "collection", store.collectionName, "error", err)
+		}
+		slog.Info("Rolled back", "alias", store.collectionName, "live", previous)
+	}
+}
+
+// promoteVersion implements `rag versions promote`. With --dataset, the
+// candidate's retrieval is benchmarked against the live version's first, and
+// the switch is refused if its recall@k is lower, unless --force is given.
+func promoteVersion(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("versions promote", flag.ExitOnError)
+	version := fs.String("version", "", "version to promote: its number or collection name")
+	dataset := fs.String("dataset", "", "JSONL eval dataset to compare the candidate's retrieval with the live version's")
+	k := fs.Int("k", 5, "cutoff of the recall@k comparison")
+	force := fs.Bool("force", false, "promote even if the candidate's recall@k is lower")
+	rf := addRetrievalFlags(fs)
+	fs.Parse(args)
+	if *version == "" || *k <= 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	a := mustApp()
+	defer a.close()
+	live, ok := a.store.(*MilvusClientImpl)
+	if !ok {
+		fatal("Collection versions need VECTOR_STORE=milvus")
+	}
+	name := *version
+	if n, err := strconv.Atoi(name); err == nil {
+		name = versionName(live.collectionName, n)
+	}
+
+	if *dataset != "" {
+		cases, err := LoadEvalDataset(*dataset)
+		if err != nil {
+			fatal("Loading dataset failed", "error", err)
+		}
+		candidate := *live
+		candidate.collectionName = name
+		for _, check := range []func(context.Context) error{candidate.CheckDimension, candidate.CheckMetric, candidate.CheckIndex, candidate.CheckPartitionKey, candidate.CheckSparse} {
+			if err := check(ctx); err != nil {
+				fatal("Checking candidate failed", "version", name, "error", err)
+			}
+		}
+		_, opts := rf.options(a)
+		_, candidateBench := a.engine.WithStore(&candidate).BenchmarkRetrieval(ctx, cases, []int{*k}, opts...)
+		candidateRecall := candidateBench.AtK[0].Recall
+		fmt.Printf("%-32s recall@%d=%.3f  MRR=%.3f\n", name, *k, candidateRecall, candidateBench.MRR)
+		if current, _, err := live.liveCollection(ctx); err == nil && current != "" && current != name {
+			_, liveBench := a.engine.BenchmarkRetrieval(ctx, cases, []int{*k}, opts...)
+			liveRecall := liveBench.AtK[0].Recall
+			fmt.Printf("%-32s recall@%d=%.3f  MRR=%.3f  (live)\n", current, *k, liveRecall, liveBench.MRR)
+			if candidateRecall < liveRecall && !*force {
+				fatal("The candidate retrieves worse than the live version; not promoting (use --force to override)",
+					"version", name, "recall", candidateRecall, "live_recall", liveRecall)
+			}
+		}
+	}
+	if err := live.PromoteVersion(ctx, name); err != nil {
+		fatal("Promotion failed", "version", name, "error", err)
+	}
+	fmt.Printf("%s now serves %s\n", live.collectionName, name)
+}
+
+// runUsage implements `rag usage`: it prints the token usage and estimated
+// cost recorded in USAGE_FILE by earlier runs, per model.
+// runHistory implements `rag history`: `list` prints recent queries and `show`
+// one query with the documents and prompt its answer was generated from.
+func runHistory(ctx context.Context, args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: rag history list [--limit n] [--search text] | rag history show <query-id>")
+		os.Exit(2)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+	fs := flag.NewFlagSet("history "+args[0], flag.ExitOnError)
+	limit := fs.Int("limit", 20, "number of queries to list, newest first")
+	search := fs.String("search", "", "only list questions containing this text")
+	asJSON := fs.Bool("json", false, "print JSON")
+	fs.Parse(args[1:])
+
+	path := historyDB()
+	if path == "" {
+		fatal("Query history is disabled (HISTORY_DB=off)")
+	}
+	history, err := NewSQLiteHistory(path)
+	if err != nil {
+		fatal("Opening history failed", "error", err)
+	}
+	defer history.Close()
+
+	switch args[0] {
+	case "list":
+		records, err := history.ListQueries(ctx, HistoryFilter{Limit: *limit, Search: *search, Tenant: os.Getenv("TENANT")})
+		if err != nil {
+			fatal("Reading history failed", "error", err)
+		}
+		if roles := envRoles(); roles != nil {
+			records = slices.DeleteFunc(records, func(record QueryRecord) bool { return !queryReadableBy(record, roles) })
+		}
+		if *asJSON {
+			entries := make([]historyEntryJSON, len(records))
+			for i, record := range records {
+				entries[i] = newHistoryEntry(record, false)
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(entries)
+			return
+		}
+		for _, record := range records {
+			status := ""
+			switch {
+			case record.Error != "":
+				status = " [error]"
+			case record.NoContext:
+				status = " [no context]"
+			}
+			fmt.Printf("%s  %s  %s%s\n", record.Time.Local().Format("2006-01-02 15:04:05"), record.ID, truncateText(record.Question, 60), status)
+		}
+	case "show":
+		if fs.NArg() != 1 {
+			usage()
+		}
+		record, err := history.GetQuery(ctx, fs.Arg(0))
+		if roles := envRoles(); err == nil && roles != nil && !queryReadableBy(record, roles) {
+			err = ErrQueryNotFound
+		}
+		if err != nil {
+			fatal("Reading history failed", "id", fs.Arg(0), "error", err)
+		}
+		if *asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(newHistoryEntry(record, true))
+			return
+		}
+		fmt.Printf("Query:    %s\n", record.ID)
+		fmt.Printf("Time:     %s (%s)\n", record.Time.Local().Format("2006-01-02 15:04:05"), record.Duration)
+		fmt.Printf("Model:    %s\n", record.Model)
+		fmt.Printf("Question: %s\n", record.Question)
+		if record.Error != "" {
+			fmt.Printf("Error:    %s\n", record.Error)
+		}
+		if f := record.Feedback; f != nil {
+			fmt.Printf("Feedback: %s", f.Rating)
+			if f.Comment != "" {
+				fmt.Printf(" (%s)", f.Comment)
+			}
+			fmt.Println()
+			if f.Correction != "" {
+				fmt.Printf("Correction:\n%s\n", f.Correction)
+			}
+		}
+		fmt.Printf("\nAnswer:\n%s\n", record.Answer)
+		fmt.Printf("\nContext (%d documents):\n", len(record.Documents))
+		for i, doc := range record.Documents {
+			fmt.Printf("  [%d] %s (%.1f%% relevant)\n      %s\n", i+1, doc.Source, doc.Similarity*100, truncateText(doc.Text, 100))
+		}
+		if len(record.Prompt) > 0 {
+			fmt.Println("\nPrompt:")
+			for _, m := range record.Prompt {
+				fmt.Printf("--- %s ---\n%s\n", m.Role, m.Content)
+			}
+		}
+	default:
+		usage()
+	}
+}
+
+// runFeedback implements `rag feedback`: `up` and `down` rate the answer of a
+// recorded query, and `report` lists the queries with negative feedback or
+// weak context, and the sources most often behind them.
+func runFeedback(ctx context.Context, args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: rag feedback <up|down> <query-id> [--correction text] [--comment text] | rag feedback report [--min-similarity 0.5] [--since 168h]")
+		os.Exit(2)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+	fs := flag.NewFlagSet("feedback "+args[0], flag.ExitOnError)
+	correction := fs.String("correction", "", "the answer you expected")
+	comment := fs.String("comment", "", "a note on what was wrong or right")
+	minSimilarity := fs.Float64("min-similarity", 0.5, "flag answers whose best context document scored below this (0-1)")
+	since := fs.Duration("since", 0, "only review queries from this long ago, e.g. 168h; 0 reviews all")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	// Allow the query ID before the flags, as in `rag feedback down <id> --comment ...`.
+	var queryID string
+	rest := args[1:]
+	if len(rest) > 0 && !strings.HasPrefix(rest[0], "-") {
+		queryID, rest = rest[0], rest[1:]
+	}
+	fs.Parse(rest)
+	if queryID == "" && fs.NArg() == 1 {
+		queryID = fs.Arg(0)
+	}
+
+	path := historyDB()
+	if path == "" {
+		fatal("Query history is disabled (HISTORY_DB=off)")
+	}
+	history, err := NewSQLiteHistory(path)
+	if err != nil {
+		fatal("Opening history failed", "error", err)
+	}
+	defer history.Close()
+
+	switch args[0] {
+	case "up", "down":
+		feedback := Feedback{QueryID: queryID, Rating: Rating(args[0]), Correction: *correction, Comment: *comment}
+		if err := feedback.Validate(); err != nil {
+			usage()
+		}
+		if err := history.SaveFeedback(ctx, feedback); err != nil {
+			fatal("Saving feedback failed", "id", queryID, "error", err)
+		}
+		slog.Info("Recorded feedback", "id", queryID, "rating", feedback.Rating)
+	case "report":
+		if *minSimilarity < 0 || *minSimilarity > 1 {
+			fatal("Invalid --min-similarity, expected a value between 0 and 1", "min_similarity", *minSimilarity)
+		}
+		opts := ReviewOptions{MinSimilarity: float32(*minSimilarity), Tenant: os.Getenv("TENANT"), Roles: envRoles()}
+		if *since > 0 {
+			opts.Since = time.Now().Add(-*since)
+		}
+		report, err := ReviewFeedback(ctx, history, opts)
+		if err != nil {
+			fatal("Reviewing feedback failed", "error", err)
+		}
+		if *asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(newFeedbackReportJSON(report))
+			return
+		}
+		fmt.Printf("Queries:   %d\n", report.Queries)
+		fmt.Printf("Upvotes:   %d\n", report.Upvotes)
+		fmt.Printf("Downvotes: %d\n", report.Downvotes)
+		fmt.Printf("Flagged:   %d\n", len(report.Items))
+		if len(report.Items) > 0 {
+			fmt.Println("\nFlagged queries:")
+			for _, item := range report.Items {
+				fmt.Printf("  %s  %-60s %s\n", item.Query.ID, truncateText(item.Query.Question, 60), strings.Join(item.Reasons, ", "))
+				if f := item.Query.Feedback; f != nil && f.Correction != "" {
+					fmt.Printf("      expected: %s\n", truncateText(f.Correction, 100))
+				}
+			}
+		}
+		if len(report.Sources) > 0 {
+			fmt.Println("\nSources in the context of flagged answers:")
+			for _, source := range report.Sources {
+				fmt.Printf("  %4d  %s\n", source.Count, source.Source)
+			}
+		}
+	default:
+		usage()
+	}
+}
+
+func runUsage(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("usage", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the usage as JSON")
+	reset := fs.Bool("reset", false, "delete the recorded usage")
+	fs.Parse(args)
+
+	if *reset {
+		if err := os.Remove(usageLedger.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fatal("Resetting usage failed", "error", err)
+		}
+		slog.Info("Reset token usage", "file", usageLedger.path)
+		return
+	}
+	report, err := usageLedger.Report()
+	if err != nil {
+		fatal("Reading usage failed", "error", err)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+		return
+	}
+
+	fmt.Printf("Usage since %s\n\n", report.Since.Local().Format("2006-01-02 15:04"))
+	// The tenant column only appears once API keys have confined requests
+	// to tenants.
+	tenants := slices.ContainsFunc(report.Models, func(m ModelUsage) bool { return m.Tenant != "" })
+	if tenants {
+		fmt.Printf("%-16s ", "TENANT")
+	}
+	fmt.Printf("%-10s %-28s %9s %14s %14s %12s\n", "KIND", "MODEL", "REQUESTS", "INPUT TOKENS", "OUTPUT TOKENS", "COST (USD)")
+	for _, m := range report.Models {
+		cost := fmt.Sprintf("%.4f", m.CostUSD)
+		if !m.Priced {
+			cost = "n/a"
+		}
+		if tenants {
+			fmt.Printf("%-16s ", cmp.Or(m.Tenant, "-"))
+		}
+		fmt.Printf("%-10s %-28s %9d %14d %14d %12s\n", m.Kind, m.Model, m.Requests, m.InputTokens, m.OutputTokens, cost)
+	}
+	fmt.Printf("\nEstimated total: $%.4f\n", report.TotalCostUSD)
+}
+
+func runOpenAPI(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("openapi", flag.ExitOnError)
+	clientPath := fs.String("client", "", "write the generated Go client package source to this file instead")
+	fs.Parse(args)
+
+	if *clientPath == "" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(OpenAPISpec())
+		return
+	}
+	source, err := generateGoClient()
+	if err != nil {
+		fatal("Generating the client failed", "error", err)
+	}
+	if err := os.WriteFile(*clientPath, source, 0o644); err != nil {
+		fatal("Writing the client failed", "error", err)
+	}
+	slog.Info("Generated the API client", "file", *clientPath)
+}
@@ -0,0 +1,520 @@
+// Code generated by "rag openapi --client"; DO NOT EDIT.
+
+// Package client is a typed Go client for the HTTP API of `rag serve`. It
+// is generated from the same description of the API as the OpenAPI
+// specification the server publishes at /openapi.json.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client calls the API of a rag server.
+type Client struct {
+	// BaseURL is the server's URL, e.g. "http://localhost:8080".
+	BaseURL string
+	// APIKey is sent as a bearer token if set.
+	APIKey string
+	// HTTPClient sends the requests; nil means http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// New returns a client for the server at baseURL, authenticating with apiKey
+// unless it is empty.
+func New(baseURL, apiKey string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), APIKey: apiKey}
+}
+
+// Error is the error returned for a response with an error status.
+type Error struct {
+	StatusCode int
+	Message    string
+	// Stage and Categories are set when moderation flagged the question
+	// ("query") or the answer ("answer").
+	Stage      string
+	Categories []string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rag API: %d: %s", e.StatusCode, e.Message)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	target := c.BaseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		apiErr := &Error{StatusCode: resp.StatusCode, Message: resp.Status}
+		var payload struct {
+			Error      string   `json:"error"`
+			Stage      string   `json:"stage"`
+			Categories []string `json:"categories"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&payload) == nil && payload.Error != "" {
+			apiErr.Message, apiErr.Stage, apiErr.Categories = payload.Error, payload.Stage, payload.Categories
+		}
+		return apiErr
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Query calls POST /query: answer a question from the knowledge base.
+func (c *Client) Query(ctx context.Context, req QueryRequest) (*QueryResponse, error) {
+	query := url.Values{}
+	var resp QueryResponse
+	if err := c.do(ctx, "POST", "/query", query, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Summarize calls POST /summarize: summarize every chunk of a source, by map-reduce or refinement.
+func (c *Client) Summarize(ctx context.Context, req SummarizeRequest) (*SummarizeResponse, error) {
+	query := url.Values{}
+	var resp SummarizeResponse
+	if err := c.do(ctx, "POST", "/summarize", query, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// AddDocuments calls POST /documents: chunk and ingest documents.
+func (c *Client) AddDocuments(ctx context.Context, req DocumentsRequest) (*DocumentsResponse, error) {
+	query := url.Values{}
+	var resp DocumentsResponse
+	if err := c.do(ctx, "POST", "/documents", query, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteDocuments calls DELETE /documents: remove every chunk of a source.
+func (c *Client) DeleteDocuments(ctx context.Context, source string) error {
+	query := url.Values{}
+	if source != "" {
+		query.Set("source", source)
+	}
+	return c.do(ctx, "DELETE", "/documents", query, nil, nil)
+}
+
+// SubmitJob calls POST /jobs: queue documents for ingestion in the background.
+func (c *Client) SubmitJob(ctx context.Context, req DocumentsRequest) (*IngestJob, error) {
+	query := url.Values{}
+	var resp IngestJob
+	if err := c.do(ctx, "POST", "/jobs", query, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// JobsOptions are the optional parameters of Jobs.
+type JobsOptions struct {
+	// Limit is the maximum number of jobs (1-100), default 20.
+	Limit int
+	// Status is queued, running, succeeded, failed, or canceled.
+	Status string
+}
+
+// Jobs calls GET /jobs: recent ingestion jobs, newest first.
+func (c *Client) Jobs(ctx context.Context, opts JobsOptions) (*JobList, error) {
+	query := url.Values{}
+	if opts.Limit != 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Status != "" {
+		query.Set("status", opts.Status)
+	}
+	var resp JobList
+	if err := c.do(ctx, "GET", "/jobs", query, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Job calls GET /jobs/{id}: an ingestion job's status and progress.
+func (c *Client) Job(ctx context.Context, id string) (*IngestJob, error) {
+	query := url.Values{}
+	var resp IngestJob
+	if err := c.do(ctx, "GET", "/jobs/"+url.PathEscape(id), query, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CancelJob calls POST /jobs/{id}/cancel: cancel a queued or running ingestion job; 409 Conflict if it already finished.
+func (c *Client) CancelJob(ctx context.Context, id string) (*IngestJob, error) {
+	query := url.Values{}
+	var resp IngestJob
+	if err := c.do(ctx, "POST", "/jobs/"+url.PathEscape(id)+"/cancel", query, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Usage calls GET /usage: cumulative token usage and estimated cost.
+func (c *Client) Usage(ctx context.Context) (*UsageReport, error) {
+	query := url.Values{}
+	var resp UsageReport
+	if err := c.do(ctx, "GET", "/usage", query, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// HistoryOptions are the optional parameters of History.
+type HistoryOptions struct {
+	// Limit is the maximum number of queries (1-100), default 20.
+	Limit int
+	// Search is text the questions must contain.
+	Search string
+	// Before is the time the queries must be older than, for paging.
+	Before time.Time
+}
+
+// History calls GET /history: recent queries, newest first.
+func (c *Client) History(ctx context.Context, opts HistoryOptions) (*HistoryList, error) {
+	query := url.Values{}
+	if opts.Limit != 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Search != "" {
+		query.Set("search", opts.Search)
+	}
+	if !opts.Before.IsZero() {
+		query.Set("before", opts.Before.Format(time.RFC3339Nano))
+	}
+	var resp HistoryList
+	if err := c.do(ctx, "GET", "/history", query, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// HistoryQuery calls GET /history/{id}: a query with its context, prompt, and answer.
+func (c *Client) HistoryQuery(ctx context.Context, id string) (*HistoryEntry, error) {
+	query := url.Values{}
+	var resp HistoryEntry
+	if err := c.do(ctx, "GET", "/history/"+url.PathEscape(id), query, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Feedback calls POST /feedback: rate a query's answer or correct it.
+func (c *Client) Feedback(ctx context.Context, req Feedback) error {
+	query := url.Values{}
+	return c.do(ctx, "POST", "/feedback", query, req, nil)
+}
+
+// FeedbackReportOptions are the optional parameters of FeedbackReport.
+type FeedbackReportOptions struct {
+	// MinSimilarity is the similarity (0-1) below which an answer's best context is flagged, default 0.5.
+	MinSimilarity float64
+	// Since is the time of the oldest query to review.
+	Since time.Time
+}
+
+// FeedbackReport calls GET /feedback/report: queries with negative feedback or weak context.
+func (c *Client) FeedbackReport(ctx context.Context, opts FeedbackReportOptions) (*FeedbackReport, error) {
+	query := url.Values{}
+	if opts.MinSimilarity != 0 {
+		query.Set("min_similarity", strconv.FormatFloat(opts.MinSimilarity, 'g', -1, 64))
+	}
+	if !opts.Since.IsZero() {
+		query.Set("since", opts.Since.Format(time.RFC3339Nano))
+	}
+	var resp FeedbackReport
+	if err := c.do(ctx, "GET", "/feedback/report", query, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+type Citation struct {
+	Marker     int            `json:"marker"`
+	Source     string         `json:"source"`
+	ChunkStart int            `json:"chunk_start"`
+	ChunkEnd   int            `json:"chunk_end"`
+	URL        string         `json:"url,omitempty"`
+	Web        bool           `json:"web,omitempty"`
+	ImageURL   string         `json:"image_url,omitempty"`
+	Timecode   string         `json:"timecode,omitempty"`
+	Text       string         `json:"text"`
+	Similarity float32        `json:"similarity"`
+	Metadata   map[string]any `json:"metadata,omitempty"`
+}
+
+type Claim struct {
+	Text      string `json:"text"`
+	Markers   []int  `json:"markers"`
+	Supported bool   `json:"supported"`
+}
+
+type Confidence struct {
+	Score      float64  `json:"score"`
+	Level      string   `json:"level"`
+	Similarity float64  `json:"similarity"`
+	Grading    *float64 `json:"grading,omitempty"`
+	SelfRating *float64 `json:"self_rating,omitempty"`
+}
+
+type Document struct {
+	Text     string         `json:"text"`
+	Source   string         `json:"source"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+	Format   string         `json:"format,omitempty"`
+	Image    string         `json:"image,omitempty"`
+	MIMEType string         `json:"mime_type,omitempty"`
+}
+
+type DocumentsRequest struct {
+	Documents []Document `json:"documents"`
+	ChunkSize int        `json:"chunk_size,omitempty"`
+	Overlap   int        `json:"overlap,omitempty"`
+	Partition string     `json:"partition,omitempty"`
+	ExpiresAt string     `json:"expires_at,omitempty"`
+}
+
+type DocumentsResponse struct {
+	Inserted int `json:"inserted"`
+	Updated  int `json:"updated"`
+	Skipped  int `json:"skipped"`
+	Removed  int `json:"removed"`
+	Dropped  int `json:"dropped"`
+}
+
+type Feedback struct {
+	QueryID    string    `json:"query_id"`
+	Rating     string    `json:"rating"`
+	Correction string    `json:"correction,omitempty"`
+	Comment    string    `json:"comment,omitempty"`
+	Time       time.Time `json:"time,omitzero"`
+}
+
+type FeedbackReport struct {
+	Queries   int           `json:"queries"`
+	Upvotes   int           `json:"upvotes"`
+	Downvotes int           `json:"downvotes"`
+	Flagged   []ReviewItem  `json:"flagged"`
+	Sources   []SourceCount `json:"sources"`
+}
+
+type Grading struct {
+	Graded    int             `json:"graded"`
+	Discarded []RoundDocument `json:"discarded"`
+	Actions   []string        `json:"actions,omitempty"`
+}
+
+type HistoryDocument struct {
+	Source     string         `json:"source"`
+	Text       string         `json:"text"`
+	Similarity float32        `json:"similarity"`
+	Metadata   map[string]any `json:"metadata,omitempty"`
+}
+
+type HistoryEntry struct {
+	ID         string            `json:"id"`
+	Time       time.Time         `json:"time"`
+	Question   string            `json:"question"`
+	Model      string            `json:"model"`
+	Answer     string            `json:"answer"`
+	NoContext  bool              `json:"no_context"`
+	Error      string            `json:"error,omitempty"`
+	DurationMS int64             `json:"duration_ms"`
+	Documents  []HistoryDocument `json:"documents,omitempty"`
+	Prompt     []HistoryMessage  `json:"prompt,omitempty"`
+	Feedback   *Feedback         `json:"feedback,omitempty"`
+}
+
+type HistoryList struct {
+	Queries []HistoryEntry `json:"queries"`
+}
+
+type HistoryMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type IngestJob struct {
+	ID       string      `json:"id"`
+	Tenant   string      `json:"tenant,omitempty"`
+	Status   string      `json:"status"`
+	Created  time.Time   `json:"created"`
+	Started  *time.Time  `json:"started,omitempty"`
+	Finished *time.Time  `json:"finished,omitempty"`
+	Progress JobProgress `json:"progress"`
+	Error    string      `json:"error,omitempty"`
+}
+
+type JobList struct {
+	Jobs []IngestJob `json:"jobs"`
+}
+
+type JobProgress struct {
+	Documents          int `json:"documents"`
+	DocumentsProcessed int `json:"documents_processed"`
+	ChunksEmbedded     int `json:"chunks_embedded"`
+	ChunksSkipped      int `json:"chunks_skipped"`
+	ChunksRemoved      int `json:"chunks_removed"`
+	ChunksDropped      int `json:"chunks_dropped"`
+}
+
+type ModelUsage struct {
+	Model        string  `json:"model"`
+	Kind         string  `json:"kind"`
+	Tenant       string  `json:"tenant,omitempty"`
+	Requests     int64   `json:"requests"`
+	InputTokens  int64   `json:"input_tokens"`
+	OutputTokens int64   `json:"output_tokens"`
+	CostUSD      float64 `json:"cost_usd"`
+	Priced       bool    `json:"priced"`
+}
+
+type Part struct {
+	Question  string     `json:"question"`
+	Citations []Citation `json:"citations"`
+}
+
+type QueryRequest struct {
+	Question    string             `json:"question"`
+	Limit       int                `json:"limit,omitempty"`
+	Filter      string             `json:"filter,omitempty"`
+	Model       string             `json:"model,omitempty"`
+	MMR         float64            `json:"mmr,omitempty"`
+	Partitions  []string           `json:"partitions,omitempty"`
+	Languages   []string           `json:"languages,omitempty"`
+	Collections map[string]float64 `json:"collections,omitempty"`
+	MultiQuery  int                `json:"multi_query,omitempty"`
+	HyDE        bool               `json:"hyde,omitempty"`
+	GraphHops   int                `json:"graph_hops,omitempty"`
+	Decompose   bool               `json:"decompose,omitempty"`
+	MaxRounds   int                `json:"max_rounds,omitempty"`
+	Pipeline    string             `json:"pipeline,omitempty"`
+}
+
+type QueryResponse struct {
+	QueryID      string        `json:"query_id"`
+	Answer       string        `json:"answer"`
+	Citations    []Citation    `json:"citations"`
+	NoContext    bool          `json:"no_context"`
+	OutOfScope   bool          `json:"out_of_scope,omitempty"`
+	Fallback     string        `json:"fallback,omitempty"`
+	Parts        []Part        `json:"parts,omitempty"`
+	Rounds       []Round       `json:"rounds,omitempty"`
+	Grading      *Grading      `json:"grading,omitempty"`
+	Usage        *RequestUsage `json:"usage"`
+	Confidence   *Confidence   `json:"confidence,omitempty"`
+	Verification *Verification `json:"verification,omitempty"`
+	Fingerprint  string        `json:"fingerprint,omitempty"`
+	Pipeline     string        `json:"pipeline,omitempty"`
+	TimedOut     []string      `json:"timed_out,omitempty"`
+}
+
+type RequestUsage struct {
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	EmbeddingTokens  int64   `json:"embedding_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+}
+
+type ReviewItem struct {
+	ID            string            `json:"id"`
+	Time          time.Time         `json:"time"`
+	Question      string            `json:"question"`
+	Model         string            `json:"model"`
+	Answer        string            `json:"answer"`
+	NoContext     bool              `json:"no_context"`
+	Error         string            `json:"error,omitempty"`
+	DurationMS    int64             `json:"duration_ms"`
+	Documents     []HistoryDocument `json:"documents,omitempty"`
+	Prompt        []HistoryMessage  `json:"prompt,omitempty"`
+	Feedback      *Feedback         `json:"feedback,omitempty"`
+	TopSimilarity float32           `json:"top_similarity"`
+	Reasons       []string          `json:"reasons"`
+}
+
+type Round struct {
+	Query     string          `json:"query"`
+	Documents []RoundDocument `json:"documents"`
+	New       int             `json:"new"`
+	Verdict   string          `json:"verdict,omitempty"`
+	Reason    string          `json:"reason,omitempty"`
+}
+
+type RoundDocument struct {
+	Source     string  `json:"source"`
+	Text       string  `json:"text"`
+	Similarity float32 `json:"similarity"`
+}
+
+type SourceCount struct {
+	Source string `json:"source"`
+	Count  int    `json:"count"`
+}
+
+type SummarizeRequest struct {
+	Source string `json:"source"`
+	Mode   string `json:"mode,omitempty"`
+	Model  string `json:"model,omitempty"`
+}
+
+type SummarizeResponse struct {
+	Source  string        `json:"source"`
+	Mode    string        `json:"mode"`
+	Summary string        `json:"summary"`
+	Chunks  int           `json:"chunks"`
+	Calls   int           `json:"calls"`
+	Usage   *RequestUsage `json:"usage"`
+}
+
+type UsageReport struct {
+	Since        time.Time    `json:"since"`
+	Models       []ModelUsage `json:"models"`
+	TotalCostUSD float64      `json:"total_cost_usd"`
+}
+
+type Verification struct {
+	Claims      []Claim `json:"claims"`
+	Unsupported int     `json:"unsupported"`
+	Regenerated bool    `json:"regenerated"`
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+	"unicode/utf8"
+)
+
+// codeLanguages maps source file extensions to the language recorded in the
+// language metadata of their chunks.
+var codeLanguages = map[string]string{
+	".go": "go", ".py": "python", ".js": "javascript", ".jsx": "javascript",
+	".ts": "typescript", ".tsx": "typescript", ".java": "java", ".kt": "kotlin",
+	".rs": "rust", ".rb": "ruby", ".php": "php", ".c": "c", ".h": "c",
+	".cc": "cpp", ".cpp": "cpp", ".hpp": "cpp", ".cs": "csharp", ".swift": "swift",
+	".scala": "scala", ".sh": "shell", ".sql": "sql",
+}
+
+// codeUnit is a top-level declaration (or the file header) as a byte range.
+type codeUnit struct {
+	start, end int
+	symbol     string
+}
+
+// ChunkGo splits Go source along its top-level declarations, parsed with
+// go/parser: the package clause and imports form one unit, and every
+// function, method, type, and var or const group another, together with its
+// doc comment. Consecutive units are packed into chunks of up to chunkSize
+// characters; a single declaration longer than that is split between lines.
+// Chunk metadata lists the declared symbols (symbols, e.g. "RAGEngine.Retrieve")
+// and the 1-based line_start and line_end. Source that does not parse is
+// chunked by ChunkCode. overlap is not used.
+func ChunkGo(text string, chunkSize, overlap int) []Chunk {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", text, parser.ParseComments)
+	if err != nil {
+		return ChunkCode(text, chunkSize, overlap)
+	}
+	tf := fset.File(file.Pos())
+
+	header := codeUnit{end: tf.Offset(file.Name.End())}
+	var units []codeUnit
+	for _, decl := range file.Decls {
+		if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+			header.end = tf.Offset(gen.End())
+			continue
+		}
+		units = append(units, codeUnit{end: tf.Offset(decl.End()), symbol: declSymbol(decl)})
+	}
+	units = append([]codeUnit{header}, units...)
+	// Each unit starts where the previous one ended, so comments between
+	// declarations stay with the declaration that follows them.
+	for i := 1; i < len(units); i++ {
+		units[i].start = units[i-1].end
+	}
+	units[len(units)-1].end = len(text)
+	return packCodeUnits(text, units, chunkSize)
+}
+
+// declSymbol names a declaration: "Name" for functions, "Type.Name" for
+// methods, and the comma-separated names of a type, var, or const group.
+func declSymbol(decl ast.Decl) string {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		if d.Recv != nil && len(d.Recv.List) > 0 {
+			return receiverType(d.Recv.List[0].Type) + "." + d.Name.Name
+		}
+		return d.Name.Name
+	case *ast.GenDecl:
+		var names []string
+		for _, spec := range d.Specs {
+			switch s := spec.(type) {
+			case *ast.TypeSpec:
+				names = append(names, s.Name.Name)
+			case *ast.ValueSpec:
+				for _, name := range s.Names {
+					names = append(names, name.Name)
+				}
+			}
+		}
+		return strings.Join(names, ", ")
+	}
+	return ""
+}
+
+func receiverType(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return receiverType(t.X)
+	case *ast.IndexExpr:
+		return receiverType(t.X)
+	case *ast.IndexListExpr:
+		return receiverType(t.X)
+	case *ast.Ident:
+		return t.Name
+	}
+	return ""
+}
+
+// ChunkCode splits source code in any language with a heuristic: a new
+// top-level unit starts at an unindented line following a blank line, unless
+// it closes a block ("}", ")", "]", or "end"). That keeps indented function
+// and method bodies, blank lines included, together with their signatures
+// in Python, JavaScript, Java, Rust, Ruby, C, and most other languages.
+// Units are packed like ChunkGo's, with line_start and line_end metadata.
+// overlap is not used.
+func ChunkCode(text string, chunkSize, overlap int) []Chunk {
+	var units []codeUnit
+	unit := codeUnit{}
+	previousBlank := true
+	for offset := 0; offset < len(text); {
+		lineEnd := strings.IndexByte(text[offset:], '\n')
+		if lineEnd < 0 {
+			lineEnd = len(text)
+		} else {
+			lineEnd += offset + 1
+		}
+		line := strings.TrimRight(text[offset:lineEnd], "\r\n")
+		blank := strings.TrimSpace(line) == ""
+		if previousBlank && !blank && startsTopLevelUnit(line) && offset > unit.start {
+			unit.end = offset
+			units = append(units, unit)
+			unit = codeUnit{start: offset}
+		}
+		previousBlank = blank
+		offset = lineEnd
+	}
+	unit.end = len(text)
+	units = append(units, unit)
+	return packCodeUnits(text, units, chunkSize)
+}
+
+func startsTopLevelUnit(line string) bool {
+	if line[0] == ' ' || line[0] == '\t' {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(line, "}"), strings.HasPrefix(line, ")"), strings.HasPrefix(line, "]"):
+		return false
+	case line == "end" || strings.HasPrefix(line, "end "):
+		return false
+	}
+	return true
+}
+
+// packCodeUnits joins consecutive units into chunks of up to chunkSize
+// characters and splits units longer than that between lines.
+func packCodeUnits(text string, units []codeUnit, chunkSize int) []Chunk {
+	var chunks []Chunk
+	for i := 0; i < len(units); {
+		unit := units[i]
+		if utf8.RuneCountInString(text[unit.start:unit.end]) > chunkSize {
+			for _, lines := range splitCodeLines(text, unit.start, unit.end, chunkSize) {
+				chunks = appendCodeChunk(chunks, text, lines.start, lines.end, []string{unit.symbol})
+			}
+			i++
+			continue
+		}
+
+		symbols := []string{unit.symbol}
+		j := i + 1
+		for j < len(units) && utf8.RuneCountInString(text[unit.start:units[j].end]) <= chunkSize {
+			symbols = append(symbols, units[j].symbol)
+			j++
+		}
+		chunks = appendCodeChunk(chunks, text, unit.start, units[j-1].end, symbols)
+		i = j
+	}
+	return chunks
+}
+
+// splitCodeLines packs the lines of text[start:end] into ranges of up to
+// chunkSize characters. A single longer line becomes a range of its own.
+func splitCodeLines(text string, start, end, chunkSize int) []codeUnit {
+	var ranges []codeUnit
+	current := codeUnit{start: start, end: start}
+	for offset := start; offset < end; {
+		lineEnd := strings.IndexByte(text[offset:end], '\n')
+		if lineEnd < 0 {
+			lineEnd = end
+		} else {
+			lineEnd += offset + 1
+		}
+		if current.end > current.start && utf8.RuneCountInString(text[current.start:lineEnd]) > chunkSize {
+			ranges = append(ranges, current)
+			current = codeUnit{start: offset}
+		}
+		current.end = lineEnd
+		offset = lineEnd
+	}
+	if current.end > current.start {
+		ranges = append(ranges, current)
+	}
+	return ranges
+}
+
+// appendCodeChunk adds text[start:end], trimmed, with its symbols and line
+// range as metadata.
+func appendCodeChunk(chunks []Chunk, text string, start, end int, symbols []string) []Chunk {
+	chunk, ok := trimmedChunk(text, start, end)
+	if !ok {
+		return chunks
+	}
+	meta := map[string]any{
+		"line_start": strings.Count(text[:chunk.Start], "\n") + 1,
+		"line_end":   strings.Count(text[:chunk.End], "\n") + 1,
+	}
+	var named []string
+	for _, symbol := range symbols {
+		if symbol != "" {
+			named = append(named, symbol)
+		}
+	}
+	if len(named) > 0 {
+		meta["symbols"] = strings.Join(named, ", ")
+	}
+	chunk.Metadata = meta
+	return append(chunks, chunk)
+}
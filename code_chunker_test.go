@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const goSource = `// Package demo is an example.
+package demo
+
+import "fmt"
+
+// Greet says hello.
+func Greet(name string) {
+	fmt.Println("hello", name)
+
+	fmt.Println("bye", name)
+}
+
+type Counter struct{ n int }
+
+// Inc increments the counter.
+func (c *Counter) Inc() { c.n++ }
+`
+
+func TestChunkGoSplitsOnDeclarations(t *testing.T) {
+	chunks := ChunkGo(goSource, 110, 0)
+	var symbols []string
+	for _, chunk := range chunks {
+		if goSource[chunk.Start:chunk.End] != chunk.Text {
+			t.Fatalf("offsets %d-%d do not match chunk %q", chunk.Start, chunk.End, chunk.Text)
+		}
+		if s, ok := chunk.Metadata["symbols"].(string); ok {
+			symbols = append(symbols, s)
+		}
+	}
+	if strings.Join(symbols, "|") != "Greet|Counter, Counter.Inc" {
+		t.Fatalf("symbols = %q", symbols)
+	}
+	greet := chunks[1]
+	if !strings.HasPrefix(greet.Text, "// Greet says hello.") || !strings.HasSuffix(greet.Text, "}") {
+		t.Fatalf("expected the whole function with its doc comment, got %q", greet.Text)
+	}
+	if greet.Metadata["line_start"] != 6 || greet.Metadata["line_end"] != 11 {
+		t.Fatalf("unexpected line range %v-%v", greet.Metadata["line_start"], greet.Metadata["line_end"])
+	}
+}
+
+func TestChunkGoFallsBackOnSyntaxErrors(t *testing.T) {
+	chunks := ChunkGo("func broken( {\n\treturn\n}\n", 100, 0)
+	if len(chunks) != 1 || chunks[0].Text != "func broken( {\n\treturn\n}" {
+		t.Fatalf("unexpected chunks %q", chunks)
+	}
+}
+
+func TestChunkCodeKeepsIndentedBodiesTogether(t *testing.T) {
+	source := "import os\n\ndef first():\n    value = 1\n\n    return value\n\n\nclass Second:\n    def method(self):\n        pass\n"
+	chunks := ChunkCode(source, 50, 0)
+	if len(chunks) != 3 {
+		t.Fatalf("expected 3 chunks, got %q", chunks)
+	}
+	if chunks[1].Text != "def first():\n    value = 1\n\n    return value" {
+		t.Fatalf("expected the whole function, got %q", chunks[1].Text)
+	}
+	if !strings.HasPrefix(chunks[2].Text, "class Second:") || chunks[2].Metadata["line_start"] != 9 {
+		t.Fatalf("unexpected class chunk %q %v", chunks[2].Text, chunks[2].Metadata)
+	}
+}
+
+func TestChunkCodeSplitsLongUnitsBetweenLines(t *testing.T) {
+	source := "def long():\n" + strings.Repeat("    x = 1\n", 20)
+	for _, chunk := range ChunkCode(source, 50, 0) {
+		if len(chunk.Text) > 50 {
+			t.Fatalf("chunk of %d characters exceeds the chunk size", len(chunk.Text))
+		}
+		if strings.Contains(chunk.Text, "x =\n") {
+			t.Fatalf("chunk %q splits a line", chunk.Text)
+		}
+	}
+}
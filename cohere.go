@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// cohereBatchSize is the most texts Cohere embeds per request.
+const cohereBatchSize = 96
+
+// cohereEmbeddingDimensions lists the default dimensions of Cohere models.
+var cohereEmbeddingDimensions = map[string]int{
+	"embed-v4.0":                    1536,
+	"embed-english-v3.0":            1024,
+	"embed-multilingual-v3.0":       1024,
+	"embed-english-light-v3.0":      384,
+	"embed-multilingual-light-v3.0": 384,
+}
+
+// CohereEmbedder implements Embedder with Cohere's v2 embed API. Documents
+// and queries are embedded with the matching input_type.
+type CohereEmbedder struct {
+	baseURL    string
+	apiKey     string
+	model      string
+	dimension  int
+	shorten    bool // request dimension explicitly (embed-v4.0)
+	httpClient *http.Client
+}
+
+// NewCohereEmbedder embeds with model. A dimension of 0 uses the model's
+// default; embed-v4.0 also returns 256, 512, or 1024 dimensions.
+func NewCohereEmbedder(apiKey, model string, dimension int) (*CohereEmbedder, error) {
+	size, err := embeddingDimension("Cohere", model, cohereEmbeddingDimensions, dimension)
+	if err != nil {
+		return nil, err
+	}
+	return &CohereEmbedder{
+		baseURL:    "https://api.cohere.com",
+		apiKey:     apiKey,
+		model:      model,
+		dimension:  size,
+		shorten:    size != cohereEmbeddingDimensions[model],
+		httpClient: http.DefaultClient,
+	}, nil
+}
+
+func (c *CohereEmbedder) Dimension() int {
+	return c.dimension
+}
+
+type cohereEmbedRequest struct {
+	Model           string   `json:"model"`
+	Texts           []string `json:"texts"`
+	InputType       string   `json:"input_type"`
+	EmbeddingTypes  []string `json:"embedding_types"`
+	OutputDimension int      `json:"output_dimension,omitempty"`
+}
+
+type cohereEmbedResponse struct {
+	Embeddings struct {
+		Float [][]float32 `json:"float"`
+	} `json:"embeddings"`
+	Meta struct {
+		BilledUnits struct {
+			InputTokens int `json:"input_tokens"`
+		} `json:"billed_units"`
+	} `json:"meta"`
+}
+
+func (c *CohereEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	req := cohereEmbedRequest{Model: c.model, InputType: "search_document", EmbeddingTypes: []string{"float"}}
+	if isQueryEmbedding(ctx) {
+		req.InputType = "search_query"
+	}
+	if c.shorten {
+		req.OutputDimension = c.dimension
+	}
+
+	var tokens int
+	embeddings, err := embedInBatches(texts, cohereBatchSize, func(batch []string) ([][]float32, error) {
+		req.Texts = batch
+		var resp cohereEmbedResponse
+		if err := postJSON(ctx, c.httpClient, "Cohere", strings.TrimRight(c.baseURL, "/")+"/v2/embed", c.apiKey, req, &resp); err != nil {
+			return nil, err
+		}
+		if len(resp.Embeddings.Float) != len(batch) {
+			return nil, fmt.Errorf("expected %d embeddings from Cohere, got %d", len(batch), len(resp.Embeddings.Float))
+		}
+		tokens += resp.Meta.BilledUnits.InputTokens
+		return resp.Embeddings.Float, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	recordTokenUsage(ctx, tokens, 0)
+	return embeddings, nil
+}
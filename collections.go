@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/milvus-io/milvus-sdk-go/v2/entity"
+)
+
+// CollectionInfo summarizes a Milvus collection for display.
+type CollectionInfo struct {
+	Name        string
+	Description string
+	Fields      []FieldInfo
+	Loaded      bool
+	ShardNum    int32
+	RowCount    int64
+}
+
+// FieldInfo describes one field of a collection schema.
+type FieldInfo struct {
+	Name       string
+	Type       string
+	PrimaryKey bool
+	Params     map[string]string
+}
+
+// ListCollections returns the names of all collections, sorted.
+func (m *MilvusClientImpl) ListCollections() ([]string, error) {
+	collections, err := m.client.ListCollections(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("listing collections: %w", err)
+	}
+	names := make([]string, 0, len(collections))
+	for _, coll := range collections {
+		names = append(names, coll.Name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// DescribeCollection returns the schema, load state, and row count of a collection.
+func (m *MilvusClientImpl) DescribeCollection(name string) (*CollectionInfo, error) {
+	ctx := context.Background()
+	coll, err := m.client.DescribeCollection(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("describing collection %s: %w", name, err)
+	}
+
+	info := &CollectionInfo{Name: coll.Name, ShardNum: coll.ShardNum}
+	if coll.Schema != nil {
+		info.Description = coll.Schema.Description
+		for _, field := range coll.Schema.Fields {
+			info.Fields = append(info.Fields, FieldInfo{
+				Name:       field.Name,
+				Type:       field.DataType.Name(),
+				PrimaryKey: field.PrimaryKey,
+				Params:     field.TypeParams,
+			})
+		}
+	}
+
+	state, err := m.client.GetLoadState(ctx, name, nil)
+	if err != nil {
+		return nil, fmt.Errorf("getting load state of %s: %w", name, err)
+	}
+	info.Loaded = state == entity.LoadStateLoaded
+
+	info.RowCount, err = m.CountDocuments(name)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// CountDocuments returns the number of stored chunks in a collection as
+// reported by Milvus statistics. Rows inserted since the last flush may not
+// be counted yet.
+func (m *MilvusClientImpl) CountDocuments(name string) (int64, error) {
+	stats, err := m.client.GetCollectionStatistics(context.Background(), name)
+	if err != nil {
+		return 0, fmt.Errorf("getting statistics of %s: %w", name, err)
+	}
+	count, err := strconv.ParseInt(stats["row_count"], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parsing row count of %s: %w", name, err)
+	}
+	return count, nil
+}
+
+// DropCollection permanently deletes a collection and all of its documents.
+func (m *MilvusClientImpl) DropCollection(name string) error {
+	if err := m.client.DropCollection(context.Background(), name); err != nil {
+		return fmt.Errorf("dropping collection %s: %w", name, err)
+	}
+	return nil
+}
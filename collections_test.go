@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/milvus-io/milvus-sdk-go/v2/client"
+	"github.com/milvus-io/milvus-sdk-go/v2/entity"
+)
+
+// fakeMilvusSDK stubs the handful of SDK calls the store uses; any other
+// method panics through the nil embedded interface.
+type fakeMilvusSDK struct {
+	client.Client
+	collections []*entity.Collection
+	stats       map[string]string
+	dropped     []string
+	metric      string // metric_type of every collection's index
+}
+
+func (f *fakeMilvusSDK) ListCollections(ctx context.Context, opts ...client.ListCollectionOption) ([]*entity.Collection, error) {
+	return f.collections, nil
+}
+
+func (f *fakeMilvusSDK) HasCollection(ctx context.Context, name string) (bool, error) {
+	for _, coll := range f.collections {
+		if coll.Name == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f *fakeMilvusSDK) DescribeCollection(ctx context.Context, name string) (*entity.Collection, error) {
+	for _, coll := range f.collections {
+		if coll.Name == name {
+			return coll, nil
+		}
+	}
+	return nil, errors.New("collection not found")
+}
+
+func (f *fakeMilvusSDK) DescribeIndex(ctx context.Context, name, field string, opts ...client.IndexOption) ([]entity.Index, error) {
+	return []entity.Index{entity.NewGenericIndex("embedding", entity.HNSW, map[string]string{"metric_type": f.metric})}, nil
+}
+
+func (f *fakeMilvusSDK) GetLoadState(ctx context.Context, name string, partitions []string) (entity.LoadState, error) {
+	return entity.LoadStateLoaded, nil
+}
+
+func (f *fakeMilvusSDK) GetCollectionStatistics(ctx context.Context, name string) (map[string]string, error) {
+	return f.stats, nil
+}
+
+func (f *fakeMilvusSDK) DropCollection(ctx context.Context, name string, opts ...client.DropCollectionOption) error {
+	f.dropped = append(f.dropped, name)
+	return nil
+}
+
+func TestCollectionManagement(t *testing.T) {
+	sdk := &fakeMilvusSDK{
+		collections: []*entity.Collection{
+			{Name: "zeta"},
+			{Name: "rag_documents", Schema: &entity.Schema{
+				Description: "RAG documents collection",
+				Fields:      []*entity.Field{{Name: "id", DataType: entity.FieldTypeInt64, PrimaryKey: true}},
+			}},
+		},
+		stats: map[string]string{"row_count": "42"},
+	}
+	store := &MilvusClientImpl{client: sdk, collectionName: "rag_documents"}
+
+	names, err := store.ListCollections()
+	if err != nil || len(names) != 2 || names[0] != "rag_documents" {
+		t.Fatalf("unexpected collection list %v (err=%v)", names, err)
+	}
+
+	info, err := store.DescribeCollection("rag_documents")
+	if err != nil {
+		t.Fatalf("DescribeCollection returned error: %v", err)
+	}
+	if info.RowCount != 42 || !info.Loaded || len(info.Fields) != 1 || !info.Fields[0].PrimaryKey {
+		t.Fatalf("unexpected collection info %+v", info)
+	}
+
+	if err := store.DropCollection("zeta"); err != nil || len(sdk.dropped) != 1 {
+		t.Fatalf("expected zeta to be dropped (err=%v)", err)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ContextCompressor shortens retrieved chunks to the parts that matter for
+// a query, so that more of them fit in the token budget.
+type ContextCompressor interface {
+	// Compress returns docs, in the same order, with their text reduced to
+	// what helps answer query. Documents with nothing relevant may be left
+	// out.
+	Compress(ctx context.Context, query string, docs []Document) ([]Document, error)
+}
+
+// compressionGap joins the sentences an extractive compressor keeps, to
+// show the model that text was left out between them.
+const compressionGap = " … "
+
+// ExtractiveCompressor is a local, dependency-free compressor: it keeps the
+// sentences of each chunk that contain the most query terms, in their
+// original order. Chunks without any are cut to their first sentences, which
+// usually say what the chunk is about. Table chunks are left whole.
+type ExtractiveCompressor struct {
+	// MaxSentences is the most sentences kept per chunk; 0 means 3.
+	MaxSentences int
+}
+
+// Compress keeps the best-matching sentences of every document.
+func (e *ExtractiveCompressor) Compress(ctx context.Context, query string, docs []Document) ([]Document, error) {
+	limit := e.MaxSentences
+	if limit <= 0 {
+		limit = 3
+	}
+	terms := make(map[string]bool)
+	for _, term := range tokenize(query) {
+		if len(term) >= 3 {
+			terms[term] = true
+		}
+	}
+	compressed := make([]Document, len(docs))
+	for i, doc := range docs {
+		if _, _, _, table := documentTable(doc); !table {
+			doc.Text = extractSentences(doc.Text, terms, limit)
+		}
+		compressed[i] = doc
+	}
+	return compressed, nil
+}
+
+// extractSentences returns the limit sentences of text with the most terms,
+// in text order, or text itself if it has no more sentences than that.
+func extractSentences(text string, terms map[string]bool, limit int) string {
+	sentences := splitClaims(text)
+	if len(sentences) <= limit {
+		return text
+	}
+	scores := make([]int, len(sentences))
+	for i, s := range sentences {
+		found := make(map[string]bool)
+		for _, term := range tokenize(s.text) {
+			if terms[term] {
+				found[term] = true
+			}
+		}
+		scores[i] = len(found)
+	}
+	order := make([]int, len(sentences))
+	for i := range order {
+		order[i] = i
+	}
+	// Ties, including sentences without any term, keep their text order.
+	sort.SliceStable(order, func(a, b int) bool { return scores[order[a]] > scores[order[b]] })
+	keep := order[:limit]
+	sort.Ints(keep)
+	parts := make([]string, len(keep))
+	for i, j := range keep {
+		parts[i] = sentences[j].text
+	}
+	return strings.Join(parts, compressionGap)
+}
+
+// LLMCompressor asks a chat model, ideally a small, fast one, to summarize
+// each chunk with respect to the query, one concurrent call per chunk.
+// Chunks the model finds irrelevant are left out. If a call fails, the
+// chunk is compressed by the fallback instead.
+type LLMCompressor struct {
+	client   LLMClient
+	model    string
+	fallback ContextCompressor
+}
+
+// NewLLMCompressor builds an LLM-based compressor with a local extractive
+// fallback.
+func NewLLMCompressor(client LLMClient, model string) *LLMCompressor {
+	return &LLMCompressor{client: client, model: model, fallback: &ExtractiveCompressor{}}
+}
+
+// noRelevantText is the reply the compression prompt asks for when a chunk
+// has nothing relevant.
+const noRelevantText = "NONE"
+
+// Compress summarizes every document with respect to the question.
+func (l *LLMCompressor) Compress(ctx context.Context, query string, docs []Document) ([]Document, error) {
+	summaries := make([]string, len(docs))
+	failed := make([]bool, len(docs))
+	var wg sync.WaitGroup
+	for i, doc := range docs {
+		if _, _, _, table := documentTable(doc); table {
+			summaries[i] = doc.Text
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			summary, err := l.summarize(ctx, query, doc.Text)
+			if err != nil {
+				slog.WarnContext(ctx, "LLM compression failed, compressing the chunk locally", "source", doc.Source, "error", err)
+				failed[i] = true
+				return
+			}
+			summaries[i] = summary
+		}()
+	}
+	wg.Wait()
+
+	compressed := make([]Document, 0, len(docs))
+	for i, doc := range docs {
+		switch {
+		case failed[i]:
+			fallback, err := l.fallback.Compress(ctx, query, docs[i:i+1])
+			if err != nil {
+				return nil, err
+			}
+			compressed = append(compressed, fallback...)
+		case summaries[i] == noRelevantText:
+			slog.DebugContext(ctx, "Left out a chunk with nothing relevant to the question", "source", doc.Source)
+		default:
+			// A summary longer than the chunk saves nothing.
+			if len(summaries[i]) < len(doc.Text) {
+				doc.Text = summaries[i]
+			}
+			compressed = append(compressed, doc)
+		}
+	}
+	return compressed, nil
+}
+
+// summarize asks the model for the parts of text relevant to query.
+func (l *LLMCompressor) summarize(ctx context.Context, query, text string) (string, error) {
+	messages := []Message{
+		{Role: "system", Content: "You condense documents for a question answering system."},
+		{Role: "user", Content: "Summarize the passage below, keeping only the information that helps answer the question. " +
+			"Keep facts, figures, names, and terms exactly as written, and add nothing the passage does not say. " +
+			"If nothing in the passage helps, reply with " + noRelevantText + " only. Reply with the summary only.\n\n" +
+			"Question: " + query + "\n\nPassage:\n" + text},
+	}
+	reply, err := l.client.ChatCompletion(ctx, l.model, messages)
+	if err != nil {
+		return "", err
+	}
+	reply = strings.TrimSpace(reply)
+	if reply == "" {
+		return "", errors.New("empty summary")
+	}
+	if strings.EqualFold(strings.Trim(reply, ".*"), noRelevantText) {
+		return noRelevantText, nil
+	}
+	return reply, nil
+}
+
+// WithContextCompression compresses the retrieved documents before they are
+// fitted to the token budget, so that more of them fit and the prompt holds
+// less text that does not bear on the question. Citations still point at the
+// whole chunks.
+func WithContextCompression(compressor ContextCompressor) EngineOption {
+	return func(r *RAGEngine) {
+		r.compressor = compressor
+	}
+}
+
+// compressContext applies the compressor to the documents about to be
+// fitted into a prompt. If it fails, or leaves nothing, the documents are
+// used as they are.
+func (r *RAGEngine) compressContext(ctx context.Context, query string, docs []Document) []Document {
+	if r.compressor == nil || len(docs) == 0 {
+		return docs
+	}
+	ctx, span := tracer.Start(ctx, "rag.compress")
+	defer span.End()
+
+	compressed, err := r.compressor.Compress(ctx, query, docs)
+	if err != nil {
+		errorsTotal.WithLabelValues("compress").Inc()
+		slog.WarnContext(ctx, "Compressing the context failed, using it uncompressed", "error", err)
+		return docs
+	}
+	if len(compressed) == 0 {
+		slog.WarnContext(ctx, "Compression left no context, using it uncompressed")
+		return docs
+	}
+	before, after := r.tokens(formatContext(docs)), r.tokens(formatContext(compressed))
+	if before > 0 {
+		compressionRatio.Observe(float64(after) / float64(before))
+	}
+	span.SetAttributes(attribute.Int("rag.tokens_before", before), attribute.Int("rag.tokens_after", after),
+		attribute.Int("rag.dropped_documents", len(docs)-len(compressed)))
+	slog.InfoContext(ctx, "Compressed the context", "tokens_before", before, "tokens_after", after,
+		"documents", len(compressed), "dropped", len(docs)-len(compressed))
+	return compressed
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const billingChunk = "Acme was founded in 2009. The office has a rooftop garden. " +
+	"Invoices are stored in Postgres. Invoices are kept for ten years. The team plays chess on Fridays."
+
+func TestExtractiveCompressorKeepsMatchingSentences(t *testing.T) {
+	docs := []Document{
+		{Text: billingChunk, Source: "billing.md"},
+		{Text: "Short chunk. Two sentences.", Source: "short.md"},
+	}
+	compressed, err := (&ExtractiveCompressor{MaxSentences: 2}).Compress(context.Background(), "How long are invoices kept?", docs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Invoices are stored in Postgres. … Invoices are kept for ten years."; compressed[0].Text != want {
+		t.Fatalf("got %q, want %q", compressed[0].Text, want)
+	}
+	if compressed[1].Text != docs[1].Text || compressed[0].Source != "billing.md" {
+		t.Fatalf("expected short chunks unchanged, got %+v", compressed)
+	}
+}
+
+// summarizingOpenAI answers compression prompts by passage, safely for
+// concurrent calls.
+type summarizingOpenAI struct {
+	mu        sync.Mutex
+	summaries map[string]string // passage prefix to summary; "" fails
+	answers   []Message
+}
+
+func (s *summarizingOpenAI) ChatCompletion(ctx context.Context, model string, messages []Message) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, passage, ok := strings.Cut(messages[len(messages)-1].Content, "Passage:\n")
+	if !ok {
+		s.answers = messages
+		return "Invoices are kept for ten years [1].", nil
+	}
+	for prefix, summary := range s.summaries {
+		if strings.HasPrefix(passage, prefix) {
+			if summary == "" {
+				return "", errors.New("rate limited")
+			}
+			return summary, nil
+		}
+	}
+	return "NONE", nil
+}
+
+func TestLLMCompressorSummarizesDropsAndFallsBack(t *testing.T) {
+	oa := &summarizingOpenAI{summaries: map[string]string{
+		"Acme":    "Invoices are kept for ten years.",
+		"Backups": "",
+	}}
+	docs := []Document{
+		{Text: billingChunk, Source: "billing.md"},
+		{Text: "The cafeteria serves lunch at noon.", Source: "office.md"},
+		{Text: "Backups run nightly. Backups of invoices are kept for a year. Restores take an hour. Nobody likes restores.", Source: "ops.md"},
+	}
+	compressed, err := NewLLMCompressor(oa, "gpt-mini").Compress(context.Background(), "How long are invoices kept?", docs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compressed) != 2 || compressed[0].Text != "Invoices are kept for ten years." || compressed[1].Source != "ops.md" {
+		t.Fatalf("unexpected compression %+v", compressed)
+	}
+	if !strings.Contains(compressed[1].Text, compressionGap) {
+		t.Fatalf("expected the failed chunk to be compressed locally, got %q", compressed[1].Text)
+	}
+}
+
+func TestCompressionFitsMoreDocumentsInTheBudget(t *testing.T) {
+	filler := strings.Repeat("The office has a rooftop garden with many plants. ", 20)
+	docs := []Document{
+		{Text: filler + "Invoices are kept for ten years.", Source: "billing.md", Similarity: 0.9},
+		{Text: filler + "Receipts are kept for five years.", Source: "receipts.md", Similarity: 0.8},
+	}
+	generate := func(opts ...EngineOption) string {
+		oa := &summarizingOpenAI{}
+		engine := NewRAGEngine(oa, NewMemoryStore(NewHashingEmbedder(256)), append(opts, WithContextBudget(400))...)
+		if _, err := engine.GenerateResponse(context.Background(), "How long are invoices and receipts kept?", docs, "gpt-test"); err != nil {
+			t.Fatal(err)
+		}
+		return oa.answers[len(oa.answers)-1].Content
+	}
+	if prompt := generate(); strings.Contains(prompt, "receipts.md") {
+		t.Fatalf("expected the budget to leave out the second document without compression")
+	}
+	prompt := generate(WithContextCompression(&ExtractiveCompressor{MaxSentences: 1}))
+	if !strings.Contains(prompt, "Invoices are kept for ten years.") || !strings.Contains(prompt, "Receipts are kept for five years.") {
+		t.Fatalf("expected both compressed documents in the prompt, got %q", prompt)
+	}
+}
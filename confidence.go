@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ConfidenceLevel buckets a confidence score for display.
+type ConfidenceLevel string
+
+const (
+	ConfidenceHigh   ConfidenceLevel = "high"   // score of 0.7 or more
+	ConfidenceMedium ConfidenceLevel = "medium" // score of 0.4 or more
+	ConfidenceLow    ConfidenceLevel = "low"
+)
+
+// Weights of the confidence signals. Missing signals are left out and the
+// others reweighted, so the score stays between 0 and 1.
+const (
+	similarityWeight = 0.4
+	gradingWeight    = 0.3
+	selfRatingWeight = 0.3
+)
+
+// Confidence estimates how well an answer is supported by its context, so
+// that UIs can warn about answers that should be double-checked.
+type Confidence struct {
+	Score float64 // 0 to 1, the weighted mean of the signals below
+	Level ConfidenceLevel
+	// Similarity is the similarity of the best context chunk to the question.
+	Similarity float64
+	// Grading is the share of graded chunks that were relevant, with
+	// corrective retrieval, or nil.
+	Grading *float64
+	// SelfRating is the model's own 0 to 1 rating of how well the context
+	// supports the answer, with WithSelfEvaluation, or nil.
+	SelfRating *float64
+}
+
+// WithSelfEvaluation makes model rate how well the context supports every
+// generated answer, at the cost of one call per answer, and weighs the
+// rating into Answer.Confidence.
+func WithSelfEvaluation(model string) EngineOption {
+	return func(r *RAGEngine) {
+		r.selfEvalModel = model
+	}
+}
+
+// answerConfidence estimates the confidence in text, answered to query from
+// docs: the top similarity, the relevance grading of the request, if the
+// context holds a RelevanceReport, and the model's self-evaluation.
+func (r *RAGEngine) answerConfidence(ctx context.Context, query, text string, docs []Document) *Confidence {
+	confidence := &Confidence{}
+	for _, doc := range docs {
+		confidence.Similarity = max(confidence.Similarity, float64(doc.Similarity))
+	}
+	total, weights := similarityWeight*confidence.Similarity, similarityWeight
+	if report, ok := ctx.Value(relevanceReportKey{}).(*RelevanceReport); ok {
+		report.mu.Lock()
+		if report.Graded > 0 {
+			share := 1 - float64(len(report.Discarded))/float64(report.Graded)
+			confidence.Grading = &share
+			total, weights = total+gradingWeight*share, weights+gradingWeight
+		}
+		report.mu.Unlock()
+	}
+	if r.selfEvalModel != "" && len(docs) > 0 {
+		if rating, err := r.selfEvaluate(ctx, query, text, docs); err != nil {
+			slog.WarnContext(ctx, "Self-evaluation failed, leaving it out of the confidence", "error", err)
+		} else {
+			confidence.SelfRating = &rating
+			total, weights = total+selfRatingWeight*rating, weights+selfRatingWeight
+		}
+	}
+	confidence.Score = math.Round(total/weights*100) / 100
+	switch {
+	case confidence.Score >= 0.7:
+		confidence.Level = ConfidenceHigh
+	case confidence.Score >= 0.4:
+		confidence.Level = ConfidenceMedium
+	default:
+		confidence.Level = ConfidenceLow
+	}
+	answerConfidence.Observe(confidence.Score)
+	slog.InfoContext(ctx, "Answer confidence", "score", confidence.Score, "level", confidence.Level)
+	return confidence
+}
+
+// ratingPattern finds the rating in a self-evaluation reply.
+var ratingPattern = regexp.MustCompile(`\d+(\.\d+)?`)
+
+// selfEvaluate asks the self-evaluation model to rate, from 0 to 10, how
+// well docs support the answer, and returns the rating scaled to 0-1.
+func (r *RAGEngine) selfEvaluate(ctx context.Context, query, text string, docs []Document) (float64, error) {
+	ctx, span := tracer.Start(ctx, "rag.self_evaluate")
+	defer span.End()
+	messages := []Message{
+		{Role: "system", Content: "You are a strict fact checker for a question answering system."},
+		{Role: "user", Content: "Rate from 0 to 10 how well the context supports the answer to the question: " +
+			"10 if every statement is backed by the context, 0 if none is or the answer does not address the question. " +
+			"Reply with the number only.\n\n" +
+			"Context:\n" + formatContext(docs) + "\n\nQuestion: " + query + "\n\nAnswer: " + text},
+	}
+	reply, err := r.llm.ChatCompletion(ctx, r.selfEvalModel, messages)
+	if err != nil {
+		return 0, err
+	}
+	match := ratingPattern.FindString(reply)
+	if match == "" {
+		return 0, fmt.Errorf("no rating in %q", strings.TrimSpace(reply))
+	}
+	rating, err := strconv.ParseFloat(match, 64)
+	if err != nil || rating > 10 {
+		return 0, fmt.Errorf("invalid rating %q", match)
+	}
+	return rating / 10, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAnswerConfidenceCombinesTheSignals(t *testing.T) {
+	oa := &sequenceOpenAI{replies: []string{"Postgres [1].", "Rating: 9"}}
+	engine := NewRAGEngine(oa, NewMemoryStore(NewHashingEmbedder(256)), WithSelfEvaluation("gpt-mini"))
+	report := &RelevanceReport{Graded: 4, Discarded: make([]Document, 1)}
+	ctx := withRelevanceReport(context.Background(), report)
+	docs := []Document{{Text: "Invoices are stored in Postgres.", Source: "billing.md", Similarity: 0.8}, {Text: "Other", Similarity: 0.5}}
+
+	answer, err := engine.GenerateResponse(ctx, "Where are invoices stored?", docs, "gpt-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := answer.Confidence
+	// (0.4*0.8 + 0.3*0.75 + 0.3*0.9) / 1.0
+	if c == nil || c.Score != 0.82 || c.Level != ConfidenceHigh || c.Similarity < 0.79 || *c.Grading != 0.75 || *c.SelfRating != 0.9 {
+		t.Fatalf("unexpected confidence %+v", c)
+	}
+	if len(oa.calls) != 2 || oa.calls[1][0].Content != "You are a strict fact checker for a question answering system." {
+		t.Fatalf("expected a self-evaluation call, got %+v", oa.calls)
+	}
+}
+
+func TestAnswerConfidenceWithoutOptionalSignals(t *testing.T) {
+	oa := &sequenceOpenAI{replies: []string{"Maybe [1].", "no idea"}}
+	engine := NewRAGEngine(oa, NewMemoryStore(NewHashingEmbedder(256)), WithSelfEvaluation("gpt-mini"))
+	docs := []Document{{Text: "Something loosely related.", Source: "misc.md", Similarity: 0.3}}
+	answer, err := engine.GenerateResponse(context.Background(), "q", docs, "gpt-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An unparsable self-evaluation is left out, like the missing grading.
+	if c := answer.Confidence; c == nil || c.Score != 0.3 || c.Level != ConfidenceLow || c.Grading != nil || c.SelfRating != nil {
+		t.Fatalf("unexpected confidence %+v", c)
+	}
+}
+
+func TestServerReturnsTheConfidence(t *testing.T) {
+	server, store := newTestServer()
+	store.InsertDocuments(context.Background(), []string{"Go is a language from Google."}, []string{"Go Docs"}, nil)
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"question":"Who made Go?"}`)))
+	var resp struct {
+		Confidence *struct {
+			Score float64 `json:"score"`
+			Level string  `json:"level"`
+		} `json:"confidence"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Confidence == nil || resp.Confidence.Score <= 0 || resp.Confidence.Level == "" {
+		t.Fatalf("expected a confidence in the response, got %s", rec.Body)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// confluencePageSize is how many pages are listed per request.
+const confluencePageSize = 100
+
+// cdataSection matches the CDATA sections Confluence keeps code macro bodies
+// in, which an HTML parser would drop as comments.
+var cdataSection = regexp.MustCompile(`(?s)<!\[CDATA\[(.*?)\]\]>`)
+
+// ConfluenceConnector syncs the current pages of a Confluence space through
+// the REST API.
+type ConfluenceConnector struct {
+	baseURL    string // site URL including the context path, e.g. https://acme.atlassian.net/wiki
+	space      string
+	email      string
+	token      string
+	httpClient *http.Client
+}
+
+// NewConfluenceConnector creates a connector for the space with the given key.
+// Confluence Cloud authenticates with an account email and API token;
+// Confluence Server and Data Center with a personal access token and no email.
+func NewConfluenceConnector(baseURL, space, email, token string) *ConfluenceConnector {
+	return &ConfluenceConnector{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		space:      space,
+		email:      email,
+		token:      token,
+		httpClient: http.DefaultClient,
+	}
+}
+
+func (c *ConfluenceConnector) Name() string {
+	return "confluence:" + c.space
+}
+
+type confluenceContent struct {
+	ID      string `json:"id"`
+	Title   string `json:"title"`
+	Version struct {
+		When time.Time `json:"when"`
+	} `json:"version"`
+	Body struct {
+		Storage struct {
+			Value string `json:"value"`
+		} `json:"storage"`
+	} `json:"body"`
+}
+
+// List returns the space's current pages, paging through the results.
+func (c *ConfluenceConnector) List(ctx context.Context) ([]ConnectorItem, error) {
+	var items []ConnectorItem
+	for start := 0; ; start += confluencePageSize {
+		query := url.Values{
+			"spaceKey": {c.space},
+			"type":     {"page"},
+			"status":   {"current"},
+			"expand":   {"version"},
+			"limit":    {strconv.Itoa(confluencePageSize)},
+			"start":    {strconv.Itoa(start)},
+		}
+		var resp struct {
+			Results []confluenceContent `json:"results"`
+		}
+		if err := c.get(ctx, "/rest/api/content?"+query.Encode(), &resp); err != nil {
+			return nil, err
+		}
+		for _, page := range resp.Results {
+			items = append(items, ConnectorItem{
+				ID:      page.ID,
+				Title:   page.Title,
+				URL:     c.baseURL + "/pages/viewpage.action?pageId=" + url.QueryEscape(page.ID),
+				Updated: page.Version.When,
+			})
+		}
+		if len(resp.Results) < confluencePageSize {
+			return items, nil
+		}
+	}
+}
+
+// Load fetches the page's storage format and extracts its text, keeping the
+// bodies of code macros.
+func (c *ConfluenceConnector) Load(ctx context.Context, item ConnectorItem) (Page, error) {
+	var page confluenceContent
+	if err := c.get(ctx, "/rest/api/content/"+url.PathEscape(item.ID)+"?expand=body.storage", &page); err != nil {
+		return Page{}, err
+	}
+	storage := cdataSection.ReplaceAllStringFunc(page.Body.Storage.Value, func(section string) string {
+		return html.EscapeString(cdataSection.FindStringSubmatch(section)[1])
+	})
+	doc, err := html.Parse(strings.NewReader(storage))
+	if err != nil {
+		return Page{}, fmt.Errorf("parsing Confluence page %s: %w", item.ID, err)
+	}
+	_, text := extractHTMLText(doc)
+	if item.Title != "" {
+		text = strings.TrimSpace(item.Title + "\n\n" + text)
+	}
+	return Page{Text: text}, nil
+}
+
+// get sends a GET request to the Confluence REST API and decodes the JSON reply.
+func (c *ConfluenceConnector) get(ctx context.Context, path string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.email != "" {
+		req.SetBasicAuth(c.email, c.token)
+	} else if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Message != "" {
+			return fmt.Errorf("Confluence API error (status %d): %s", resp.StatusCode, apiErr.Message)
+		}
+		return fmt.Errorf("Confluence API error (status %d)", resp.StatusCode)
+	}
+	return json.Unmarshal(data, out)
+}
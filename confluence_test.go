@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestConfluenceConnector(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "me@acme.com" || pass != "token" {
+			t.Errorf("expected basic auth with the email and token")
+		}
+		switch r.URL.Path {
+		case "/wiki/rest/api/content":
+			if r.URL.Query().Get("spaceKey") != "ENG" {
+				t.Errorf("unexpected space %q", r.URL.Query().Get("spaceKey"))
+			}
+			// A full first page forces a second request.
+			if r.URL.Query().Get("start") == "0" {
+				var results []string
+				for i := range confluencePageSize {
+					results = append(results, fmt.Sprintf(`{"id":"%d","title":"Page %d","version":{"when":"2025-02-01T09:30:00.000Z"}}`, i, i))
+				}
+				w.Write([]byte(`{"results":[` + strings.Join(results, ",") + `]}`))
+				return
+			}
+			w.Write([]byte(`{"results":[{"id":"900","title":"Deploys","version":{"when":"2025-02-02T09:30:00.000Z"}}]}`))
+		case "/wiki/rest/api/content/900":
+			w.Write([]byte(`{"id":"900","title":"Deploys","body":{"storage":{"value":"<h2>Steps</h2><p>Run the <strong>pipeline</strong>.</p><ac:structured-macro ac:name=\"code\"><ac:plain-text-body><![CDATA[make deploy <env>]]></ac:plain-text-body></ac:structured-macro>"}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"statusCode":404,"message":"No content found with id: 1"}`))
+		}
+	}))
+	defer server.Close()
+
+	connector := NewConfluenceConnector(server.URL+"/wiki/", "ENG", "me@acme.com", "token")
+	items, err := connector.List(context.Background())
+	if err != nil {
+		t.Fatalf("List returned error: %v", err)
+	}
+	if len(items) != confluencePageSize+1 {
+		t.Fatalf("expected both result pages, got %d items", len(items))
+	}
+	last := items[len(items)-1]
+	if last.Title != "Deploys" || last.URL != server.URL+"/wiki/pages/viewpage.action?pageId=900" {
+		t.Fatalf("unexpected item %+v", last)
+	}
+
+	page, err := connector.Load(context.Background(), last)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if page.Text != "Deploys\n\nSteps\nRun the pipeline.\nmake deploy <env>" {
+		t.Fatalf("unexpected page text %q", page.Text)
+	}
+
+	_, err = connector.Load(context.Background(), ConnectorItem{ID: "1"})
+	if err == nil || !strings.Contains(err.Error(), "No content found") {
+		t.Fatalf("expected the API error message, got %v", err)
+	}
+}
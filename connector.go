@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ConnectorItem describes a page of a connected workspace such as a Notion
+// database or a Confluence space.
+type ConnectorItem struct {
+	ID      string
+	Title   string
+	URL     string // stable link to the page, used as its source
+	Updated time.Time
+}
+
+// Connector pulls pages from a document workspace over its API.
+type Connector interface {
+	// Name identifies what is synced, e.g. "notion:<database-id>", and keys
+	// the connector's entries in the sync state.
+	Name() string
+	// List returns every page, with its last edit time if known, without
+	// content.
+	List(ctx context.Context) ([]ConnectorItem, error)
+	// Load fetches a page's content as text.
+	Load(ctx context.Context, item ConnectorItem) (Page, error)
+}
+
+// SyncedPage records a page as of its last sync.
+type SyncedPage struct {
+	Source  string    `json:"source"`
+	Title   string    `json:"title,omitempty"`
+	Updated time.Time `json:"updated"`
+}
+
+// SyncState remembers which pages each connector has ingested and when they
+// were last edited, so a re-sync only fetches pages edited since and removes
+// pages that disappeared. It is kept in a JSON file.
+type SyncState struct {
+	path string
+	mu   sync.Mutex
+	// Connectors maps a connector name to its pages by page ID.
+	Connectors map[string]map[string]SyncedPage `json:"connectors"`
+}
+
+// LoadSyncState reads the sync state at path; a missing file is an empty state.
+func LoadSyncState(path string) (*SyncState, error) {
+	state := &SyncState{path: path, Connectors: make(map[string]map[string]SyncedPage)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("reading sync state %s: %w", path, err)
+	}
+	if state.Connectors == nil {
+		state.Connectors = make(map[string]map[string]SyncedPage)
+	}
+	return state, nil
+}
+
+// Save writes the state back to its file.
+func (s *SyncState) Save() error {
+	s.mu.Lock()
+	data, err := json.MarshalIndent(s, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// pages returns a copy of the connector's synced pages.
+func (s *SyncState) pages(connector string) map[string]SyncedPage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pages := make(map[string]SyncedPage, len(s.Connectors[connector]))
+	for id, page := range s.Connectors[connector] {
+		pages[id] = page
+	}
+	return pages
+}
+
+func (s *SyncState) set(connector, id string, page SyncedPage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Connectors[connector] == nil {
+		s.Connectors[connector] = make(map[string]SyncedPage)
+	}
+	s.Connectors[connector][id] = page
+}
+
+func (s *SyncState) remove(connector, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.Connectors[connector], id)
+}
+
+// SyncConnector brings the knowledge base up to date with a connector's
+// pages. Pages whose edit time matches the state are skipped without being
+// fetched, pages without an edit time are fetched every time, new and edited
+// pages are loaded and ingested in parallel, and pages that were deleted, or
+// no longer match the options' patterns, have their chunks removed. Include
+// and Exclude are matched against page titles. Each page's source is its URL,
+// and its metadata records page_id, title, and updated. The state is updated
+// as pages succeed; the caller saves it.
+func SyncConnector(ctx context.Context, engine *RAGEngine, c Connector, state *SyncState, opts DirectoryOptions) (DirectoryReport, error) {
+	start := time.Now()
+	var report DirectoryReport
+	items, err := c.List(ctx)
+	if err != nil {
+		return report, fmt.Errorf("listing %s: %w", c.Name(), err)
+	}
+
+	known := state.pages(c.Name())
+	listed := make(map[string]ConnectorItem, len(items))
+	byURL := make(map[string]ConnectorItem)
+	var changed []string
+	for _, item := range items {
+		if !selected(item.Title, opts.Include, opts.Exclude) {
+			continue
+		}
+		listed[item.ID] = item
+		if page, ok := known[item.ID]; ok && !item.Updated.IsZero() && page.Updated.Equal(item.Updated) && page.Source == item.URL {
+			report.Skipped++
+			continue
+		}
+		byURL[item.URL] = item
+		changed = append(changed, item.URL)
+	}
+	slog.InfoContext(ctx, "Found pages to sync", "connector", c.Name(), "pages", len(listed), "changed", len(changed))
+
+	ingestAll(ctx, changed, opts.Workers, &report, func(url string) (IngestReport, error) {
+		item := byURL[url]
+		page, err := c.Load(ctx, item)
+		if err != nil {
+			return IngestReport{}, err
+		}
+		page.Source = item.URL
+		if page.Metadata == nil {
+			page.Metadata = make(map[string]any)
+		}
+		page.Metadata["page_id"] = item.ID
+		if _, ok := page.Metadata[titleField]; !ok {
+			page.Metadata[titleField] = item.Title
+		}
+		if !item.Updated.IsZero() {
+			page.Metadata[updatedField] = item.Updated.UTC().Format(time.RFC3339)
+		}
+		chunks, ok := ingestPages(ctx, engine, []Page{page}, opts.ChunkSize, opts.Overlap)
+		if !ok {
+			return chunks, fmt.Errorf("storing chunks failed (%s)", chunks)
+		}
+		if old, ok := known[item.ID]; ok && old.Source != item.URL {
+			if err := engine.DeleteBySource(ctx, old.Source); err != nil {
+				return chunks, fmt.Errorf("removing the page's previous source %s: %w", old.Source, err)
+			}
+		}
+		state.set(c.Name(), item.ID, SyncedPage{Source: item.URL, Title: item.Title, Updated: item.Updated})
+		return chunks, nil
+	})
+
+	for id, page := range known {
+		if _, ok := listed[id]; ok || ctx.Err() != nil {
+			continue
+		}
+		if err := engine.DeleteBySource(ctx, page.Source); err != nil {
+			slog.ErrorContext(ctx, "Removing deleted page failed", "page", page.Title, "source", page.Source, "error", err)
+			report.Errors = append(report.Errors, FileError{Path: page.Source, Err: err})
+			continue
+		}
+		state.remove(c.Name(), id)
+		report.Removed++
+		slog.InfoContext(ctx, "Removed deleted page", "page", page.Title, "source", page.Source)
+	}
+	report.Duration = time.Since(start)
+	return report, ctx.Err()
+}
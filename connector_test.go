@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeConnector serves pages from memory and counts the pages it loads.
+type fakeConnector struct {
+	items  []ConnectorItem
+	texts  map[string]string
+	loaded []string
+}
+
+func (f *fakeConnector) Name() string { return "fake" }
+
+func (f *fakeConnector) List(ctx context.Context) ([]ConnectorItem, error) {
+	return f.items, nil
+}
+
+func (f *fakeConnector) Load(ctx context.Context, item ConnectorItem) (Page, error) {
+	f.loaded = append(f.loaded, item.ID)
+	text, ok := f.texts[item.ID]
+	if !ok {
+		return Page{}, errors.New("page not found")
+	}
+	return Page{Text: text}, nil
+}
+
+func TestSyncConnectorIsIncremental(t *testing.T) {
+	day := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	connector := &fakeConnector{
+		items: []ConnectorItem{
+			{ID: "1", Title: "Onboarding", URL: "https://wiki/1", Updated: day},
+			{ID: "2", Title: "Vacation policy", URL: "https://wiki/2", Updated: day},
+			{ID: "3", Title: "Archive: old", URL: "https://wiki/3", Updated: day},
+		},
+		texts: map[string]string{"1": "Welcome aboard.", "2": "25 days per year.", "3": "Outdated."},
+	}
+	store := NewMemoryStore(NewHashingEmbedder(64))
+	engine := NewRAGEngine(&dummyOpenAI{}, store)
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	state, err := LoadSyncState(statePath)
+	if err != nil {
+		t.Fatalf("LoadSyncState returned error: %v", err)
+	}
+	opts := DirectoryOptions{Exclude: []string{"Archive*"}, Workers: 1, ChunkSize: 1000}
+
+	report, err := SyncConnector(context.Background(), engine, connector, state, opts)
+	if err != nil {
+		t.Fatalf("SyncConnector returned error: %v", err)
+	}
+	if report.Files != 2 || report.Chunks.Inserted != 2 {
+		t.Fatalf("unexpected first sync report %+v", report)
+	}
+	docs := store.SearchSimilar(context.Background(), "days", 10, Filter{Eq("source", "https://wiki/2")})
+	if len(docs) != 1 || docs[0].Metadata["page_id"] != "2" || docs[0].Metadata["title"] != "Vacation policy" {
+		t.Fatalf("expected the page's ID and title in the metadata, got %+v", docs)
+	}
+	if err := state.Save(); err != nil {
+		t.Fatalf("Save returned error: %v", err)
+	}
+
+	// Page 1 is edited, page 2 is unchanged, and page 3 stays excluded; a page
+	// deleted from the workspace disappears from the listing.
+	state, err = LoadSyncState(statePath)
+	if err != nil {
+		t.Fatalf("LoadSyncState returned error: %v", err)
+	}
+	connector.loaded = nil
+	connector.items[0].Updated = day.Add(time.Hour)
+	connector.texts["1"] = "Welcome aboard, again."
+	report, err = SyncConnector(context.Background(), engine, connector, state, opts)
+	if err != nil {
+		t.Fatalf("SyncConnector returned error: %v", err)
+	}
+	if len(connector.loaded) != 1 || connector.loaded[0] != "1" || report.Skipped != 1 {
+		t.Fatalf("expected only the edited page to be fetched, loaded %v, report %+v", connector.loaded, report)
+	}
+
+	connector.items = connector.items[:1]
+	report, err = SyncConnector(context.Background(), engine, connector, state, opts)
+	if err != nil {
+		t.Fatalf("SyncConnector returned error: %v", err)
+	}
+	if report.Removed != 1 {
+		t.Fatalf("expected the deleted page to be removed, got %+v", report)
+	}
+	if got := storedSources(store); len(got) != 1 || got["https://wiki/1"] != 1 {
+		t.Fatalf("unexpected sources after deletion: %v", got)
+	}
+	if pages := state.pages("fake"); len(pages) != 1 {
+		t.Fatalf("expected the deleted page to be forgotten, got %v", pages)
+	}
+}
+
+func TestSyncConnectorRetriesFailedPages(t *testing.T) {
+	connector := &fakeConnector{
+		items: []ConnectorItem{{ID: "1", Title: "Missing", URL: "https://wiki/1", Updated: time.Now()}},
+		texts: map[string]string{},
+	}
+	engine := NewRAGEngine(&dummyOpenAI{}, NewMemoryStore(NewHashingEmbedder(64)))
+	state, _ := LoadSyncState(filepath.Join(t.TempDir(), "state.json"))
+
+	report, err := SyncConnector(context.Background(), engine, connector, state, DirectoryOptions{})
+	if err != nil {
+		t.Fatalf("SyncConnector returned error: %v", err)
+	}
+	if len(report.Errors) != 1 || report.Errors[0].Path != "https://wiki/1" {
+		t.Fatalf("expected the page to fail, got %+v", report)
+	}
+	if len(state.pages("fake")) != 0 {
+		t.Fatalf("a failed page must not be recorded as synced")
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// historyTurns is how many recent turns are used for condensing follow-ups
+// and included in the generation prompt.
+const historyTurns = 4
+
+// Turn is one question and answer exchange in a conversation.
+type Turn struct {
+	Question string
+	Answer   string
+}
+
+// Conversation stores the turns of a multi-turn chat. It is safe for
+// concurrent use, though turns are expected to arrive one at a time.
+type Conversation struct {
+	mu    sync.Mutex
+	turns []Turn
+}
+
+// NewConversation starts an empty conversation.
+func (r *RAGEngine) NewConversation() *Conversation {
+	return &Conversation{}
+}
+
+// Turns returns a copy of all turns so far.
+func (c *Conversation) Turns() []Turn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Turn(nil), c.turns...)
+}
+
+// recent returns up to n of the latest turns.
+func (c *Conversation) recent(n int) []Turn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.turns) > n {
+		return append([]Turn(nil), c.turns[len(c.turns)-n:]...)
+	}
+	return append([]Turn(nil), c.turns...)
+}
+
+func (c *Conversation) add(turn Turn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.turns = append(c.turns, turn)
+}
+
+// Chat answers a question within a conversation. Follow-up questions are first
+// condensed into a standalone query so retrieval works without the history,
+// then the answer is generated with recent turns in the prompt and recorded.
+func (r *RAGEngine) Chat(ctx context.Context, conv *Conversation, question string, limit int, model string, opts ...RetrieveOption) (Answer, error) {
+	return r.chat(ctx, conv, question, limit, model, nil, opts...)
+}
+
+// ChatStream is Chat with the answer text passed to onDelta as the model
+// generates it, as with GenerateResponseStream.
+func (r *RAGEngine) ChatStream(ctx context.Context, conv *Conversation, question string, limit int, model string, onDelta func(string) error, opts ...RetrieveOption) (Answer, error) {
+	return r.chat(ctx, conv, question, limit, model, onDelta, opts...)
+}
+
+func (r *RAGEngine) chat(ctx context.Context, conv *Conversation, question string, limit int, model string, onDelta func(string) error, opts ...RetrieveOption) (Answer, error) {
+	history := conv.recent(historyTurns)
+	ctx, span := tracer.Start(ctx, "rag.chat", trace.WithAttributes(attribute.Int("rag.history_turns", len(history))))
+	defer span.End()
+
+	query := question
+	switch {
+	case r.rewriter != nil:
+		opts = append(opts, withConversation(history, model))
+	case len(history) > 0:
+		query = r.condenseQuestion(ctx, history, question, model)
+	}
+
+	answer, refused := r.OutOfScope(ctx, query)
+	if refused && onDelta != nil {
+		if err := onDelta(answer.Text); err != nil {
+			return Answer{}, err
+		}
+	}
+	if !refused {
+		docs := r.Retrieve(ctx, query, limit, opts...)
+		var err error
+		if answer, err = r.generate(ctx, question, docs, model, history, onDelta); err != nil {
+			return Answer{}, err
+		}
+	}
+
+	conv.add(Turn{Question: question, Answer: answer.Text})
+	return answer, nil
+}
+
+// condenseQuestion rewrites a follow-up question into a standalone one using
+// the conversation history. On failure it falls back to the original question.
+func (r *RAGEngine) condenseQuestion(ctx context.Context, history []Turn, question, model string) string {
+	var transcript strings.Builder
+	for _, turn := range history {
+		transcript.WriteString("User: " + turn.Question + "\n")
+		transcript.WriteString("Assistant: " + turn.Answer + "\n")
+	}
+
+	prompt := "Given the conversation below and a follow-up question, rewrite the follow-up as a standalone question " +
+		"that can be understood without the conversation. Replace pronouns and vague references with what they refer to. " +
+		"If it is already standalone, return it unchanged. Reply with the question only.\n\n" +
+		"Conversation:\n" + transcript.String() + "\nFollow-up question: " + question + "\n\nStandalone question:"
+
+	messages := []Message{
+		{Role: "system", Content: "You rewrite follow-up questions into standalone search queries."},
+		{Role: "user", Content: prompt},
+	}
+	condensed, err := r.llm.ChatCompletion(ctx, model, messages)
+	if err != nil {
+		slog.WarnContext(ctx, "Could not condense follow-up question, using it as-is", "error", err)
+		return question
+	}
+
+	condensed = strings.Trim(strings.TrimSpace(condensed), "\"")
+	if condensed == "" {
+		return question
+	}
+	slog.InfoContext(ctx, "Condensed follow-up question", "question", question, "condensed", condensed)
+	return condensed
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// sequenceOpenAI returns scripted replies in order and records every request.
+type sequenceOpenAI struct {
+	replies []string
+	calls   [][]Message
+}
+
+func (s *sequenceOpenAI) ChatCompletion(ctx context.Context, model string, messages []Message) (string, error) {
+	s.calls = append(s.calls, messages)
+	if len(s.replies) == 0 {
+		return "", errors.New("no scripted reply")
+	}
+	reply := s.replies[0]
+	s.replies = s.replies[1:]
+	return reply, nil
+}
+
+func TestChatCondensesFollowUpsAndKeepsHistory(t *testing.T) {
+	oa := &sequenceOpenAI{replies: []string{
+		"Go is a language from Google.",
+		"What is the concurrency model of Go?",
+		"Go uses goroutines and channels.",
+	}}
+	mv := &dummyMilvus{}
+	engine := NewRAGEngine(oa, mv)
+	conv := engine.NewConversation()
+
+	if _, err := engine.Chat(context.Background(), conv, "What is Go?", 3, "gpt-test"); err != nil {
+		t.Fatalf("first Chat returned error: %v", err)
+	}
+	if mv.lastQuery != "What is Go?" {
+		t.Fatalf("first question should be retrieved as-is, got %q", mv.lastQuery)
+	}
+
+	answer, err := engine.Chat(context.Background(), conv, "what about its concurrency model?", 3, "gpt-test")
+	if err != nil {
+		t.Fatalf("follow-up Chat returned error: %v", err)
+	}
+	if answer.Text != "Go uses goroutines and channels." {
+		t.Fatalf("unexpected answer %q", answer.Text)
+	}
+	if mv.lastQuery != "What is the concurrency model of Go?" {
+		t.Fatalf("follow-up should be condensed before retrieval, got %q", mv.lastQuery)
+	}
+
+	generation := oa.calls[2]
+	if len(generation) != 4 || generation[1].Content != "What is Go?" || generation[2].Role != "assistant" {
+		t.Fatalf("expected prior turn in generation messages, got %+v", generation)
+	}
+	if turns := conv.Turns(); len(turns) != 2 {
+		t.Fatalf("expected 2 recorded turns, got %d", len(turns))
+	}
+}
+
+func TestChatFallsBackWhenCondensingFails(t *testing.T) {
+	oa := &sequenceOpenAI{replies: []string{"first answer"}}
+	mv := &dummyMilvus{}
+	engine := NewRAGEngine(oa, mv)
+	conv := engine.NewConversation()
+	engine.Chat(context.Background(), conv, "What is Go?", 3, "gpt-test")
+
+	// No replies are left, so condensing and generation both fail.
+	if _, err := engine.Chat(context.Background(), conv, "and its history?", 3, "gpt-test"); err == nil {
+		t.Fatalf("expected generation error to be returned")
+	}
+	if mv.lastQuery != "and its history?" {
+		t.Fatalf("expected raw question to be used for retrieval, got %q", mv.lastQuery)
+	}
+	if turns := conv.Turns(); len(turns) != 1 {
+		t.Fatalf("failed turn should not be recorded, got %d turns", len(turns))
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ChunkGrader judges whether retrieved chunks are relevant to a query.
+type ChunkGrader interface {
+	// Grade reports, for each of docs, whether it helps answer query.
+	Grade(ctx context.Context, query string, docs []Document) ([]bool, error)
+}
+
+// LLMGrader asks a chat model, ideally a small, fast one, for a yes or no
+// verdict on every chunk in a single call. If the call fails or returns
+// unusable output, the fallback grader is used instead.
+type LLMGrader struct {
+	client   LLMClient
+	model    string
+	fallback ChunkGrader
+}
+
+// NewLLMGrader builds an LLM-based grader with a local keyword fallback.
+func NewLLMGrader(client LLMClient, model string) *LLMGrader {
+	return &LLMGrader{client: client, model: model, fallback: &KeywordGrader{}}
+}
+
+// Grade asks the model whether each passage is relevant to the question.
+func (l *LLMGrader) Grade(ctx context.Context, query string, docs []Document) ([]bool, error) {
+	if len(docs) == 0 {
+		return nil, nil
+	}
+
+	var passages strings.Builder
+	for i, doc := range docs {
+		passages.WriteString(fmt.Sprintf("[%d] %s\n\n", i+1, doc.Text))
+	}
+	prompt := "Decide for each passage whether it contains information that helps answer the question. " +
+		"A passage that is only on the same topic is not relevant.\n" +
+		"Reply with one line per passage in the form \"<number>: yes\" or \"<number>: no\" and nothing else.\n\n" +
+		"Question: " + query + "\n\nPassages:\n" + passages.String()
+
+	messages := []Message{
+		{Role: "system", Content: "You are a strict relevance grader for a search engine."},
+		{Role: "user", Content: prompt},
+	}
+	reply, err := l.client.ChatCompletion(ctx, l.model, messages)
+	if err != nil {
+		slog.WarnContext(ctx, "LLM grading failed, using keyword grading", "error", err)
+		return l.fallback.Grade(ctx, query, docs)
+	}
+
+	verdicts, ok := parseGrades(reply, len(docs))
+	if !ok {
+		slog.WarnContext(ctx, "Could not parse grader output, using keyword grading")
+		return l.fallback.Grade(ctx, query, docs)
+	}
+	return verdicts, nil
+}
+
+// parseGrades reads "<number>: yes|no" lines into a slice indexed by passage
+// position. It reports false unless every passage received a verdict.
+func parseGrades(reply string, n int) ([]bool, bool) {
+	verdicts := make([]bool, n)
+	seen := make([]bool, n)
+	found := 0
+	for _, line := range strings.Split(reply, "\n") {
+		idxPart, verdictPart, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		idxPart = strings.Trim(strings.TrimSpace(idxPart), "[]")
+		idx, err := strconv.Atoi(idxPart)
+		if err != nil || idx < 1 || idx > n || seen[idx-1] {
+			continue
+		}
+		switch strings.ToLower(strings.Trim(strings.TrimSpace(verdictPart), ".*")) {
+		case "yes":
+			verdicts[idx-1] = true
+		case "no":
+		default:
+			continue
+		}
+		seen[idx-1] = true
+		found++
+	}
+	return verdicts, found == n
+}
+
+// KeywordGrader is a local, dependency-free grader: a chunk is relevant if
+// it contains enough of the query's terms.
+type KeywordGrader struct {
+	// MinOverlap is the share of the query's distinct terms of three or
+	// more characters a relevant chunk must contain; 0 means 0.5.
+	MinOverlap float64
+}
+
+// Grade marks the chunks that contain at least MinOverlap of the query terms.
+func (k *KeywordGrader) Grade(ctx context.Context, query string, docs []Document) ([]bool, error) {
+	minOverlap := k.MinOverlap
+	if minOverlap <= 0 {
+		minOverlap = 0.5
+	}
+	terms := make(map[string]bool)
+	for _, term := range tokenize(query) {
+		if len(term) >= 3 {
+			terms[term] = true
+		}
+	}
+	verdicts := make([]bool, len(docs))
+	for i, doc := range docs {
+		if len(terms) == 0 {
+			verdicts[i] = true
+			continue
+		}
+		found := make(map[string]bool)
+		for _, term := range tokenize(doc.Text) {
+			if terms[term] {
+				found[term] = true
+			}
+		}
+		verdicts[i] = float64(len(found)) >= minOverlap*float64(len(terms))
+	}
+	return verdicts, nil
+}
+
+// CorrectiveAction is a way to find more relevant chunks when too few
+// retrieved ones pass grading.
+type CorrectiveAction string
+
+const (
+	// CorrectRewrite has the model reword the query and searches again.
+	CorrectRewrite CorrectiveAction = "rewrite"
+	// CorrectWebSearch searches the web (see WithWebSearch).
+	CorrectWebSearch CorrectiveAction = "web"
+)
+
+// ParseCorrectiveActions parses a CORRECTIVE_FALLBACKS value: a
+// comma-separated list of actions to try in order, such as "rewrite,web".
+// Empty or "off" means none.
+func ParseCorrectiveActions(value string) ([]CorrectiveAction, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" || value == "off" {
+		return nil, nil
+	}
+	var actions []CorrectiveAction
+	for _, part := range strings.Split(value, ",") {
+		switch action := CorrectiveAction(strings.TrimSpace(part)); action {
+		case CorrectRewrite, CorrectWebSearch:
+			actions = append(actions, action)
+		default:
+			return nil, fmt.Errorf("unknown corrective fallback %q (expected off or a list of rewrite and web)", part)
+		}
+	}
+	return actions, nil
+}
+
+// CorrectiveRetrieval configures the grading of retrieved chunks.
+type CorrectiveRetrieval struct {
+	Grader ChunkGrader
+	// MinRelevant is how many chunks must pass grading; with fewer, the
+	// Actions run in order until enough do. 0 means 1.
+	MinRelevant int
+	Actions     []CorrectiveAction
+	Model       string // chat model of CorrectRewrite
+}
+
+// WithCorrectiveRetrieval grades every retrieved chunk for relevance to the
+// query and discards the ones that fail, before the answer is generated. If
+// too few pass, the configured fallbacks look for more. The discarded chunks
+// are reported to the request's RelevanceReport, if any.
+func WithCorrectiveRetrieval(c *CorrectiveRetrieval) EngineOption {
+	return func(r *RAGEngine) {
+		r.corrective = c
+	}
+}
+
+// RelevanceReport collects the grading of one request's retrievals, which
+// may be several, for example for a decomposed question.
+type RelevanceReport struct {
+	Graded    int                // chunks graded, including those the fallbacks found
+	Discarded []Document         // chunks that failed grading
+	Actions   []CorrectiveAction // fallbacks that ran, in order
+
+	mu sync.Mutex
+}
+
+type relevanceReportKey struct{}
+
+// withRelevanceReport returns a context in which corrective retrieval adds
+// its grading to report.
+func withRelevanceReport(ctx context.Context, report *RelevanceReport) context.Context {
+	return context.WithValue(ctx, relevanceReportKey{}, report)
+}
+
+// recordGrading adds one retrieval's grading to the report in ctx, if any.
+func recordGrading(ctx context.Context, graded int, discarded []Document, actions []CorrectiveAction) {
+	report, ok := ctx.Value(relevanceReportKey{}).(*RelevanceReport)
+	if !ok {
+		return
+	}
+	report.mu.Lock()
+	defer report.mu.Unlock()
+	report.Graded += graded
+	report.Discarded = append(report.Discarded, discarded...)
+	report.Actions = append(report.Actions, actions...)
+}
+
+// correctRetrieval grades docs, retrieved for query, and returns the
+// relevant ones, after running the corrective fallbacks if too few are.
+func (r *RAGEngine) correctRetrieval(ctx context.Context, query string, limit int, cfg retrieveConfig, docs []Document) []Document {
+	ctx, span := tracer.Start(ctx, "rag.corrective", trace.WithAttributes(attribute.Int("rag.candidates", len(docs))))
+	defer span.End()
+
+	c := r.corrective
+	minRelevant := max(c.MinRelevant, 1)
+	relevant, discarded := r.gradeChunks(ctx, query, docs)
+	graded := len(docs)
+	seen := make(map[string]bool)
+	for _, doc := range docs {
+		seen[documentKey(doc)] = true
+	}
+	var ran []CorrectiveAction
+	for _, action := range c.Actions {
+		if len(relevant) >= minRelevant {
+			break
+		}
+		var more []Document
+		switch action {
+		case CorrectRewrite:
+			rewritten := r.correctiveQuery(ctx, query, discarded)
+			if rewritten == "" || strings.EqualFold(rewritten, query) {
+				continue
+			}
+			slog.InfoContext(ctx, "Searching again with a reworded query", "query", query, "rewritten", rewritten)
+			more = r.retrieve(ctx, rewritten, limit, cfg)
+			if documentsFallback(more) != "" {
+				continue
+			}
+		case CorrectWebSearch:
+			if r.webSearch == nil {
+				continue
+			}
+			if more = r.searchWeb(ctx, query, limit); more == nil {
+				continue
+			}
+		}
+		ran = append(ran, action)
+		correctiveActions.WithLabelValues(string(action)).Inc()
+		var fresh []Document
+		for _, doc := range more {
+			if key := documentKey(doc); !seen[key] {
+				seen[key] = true
+				fresh = append(fresh, doc)
+			}
+		}
+		ok, bad := r.gradeChunks(ctx, query, fresh)
+		relevant = append(relevant, ok...)
+		discarded = append(discarded, bad...)
+		graded += len(fresh)
+	}
+	if len(relevant) > limit {
+		relevant = relevant[:limit]
+	}
+
+	gradedChunks.WithLabelValues("relevant").Add(float64(len(relevant)))
+	gradedChunks.WithLabelValues("discarded").Add(float64(len(discarded)))
+	span.SetAttributes(attribute.Int("rag.relevant", len(relevant)), attribute.Int("rag.discarded", len(discarded)), attribute.Int("rag.corrective_actions", len(ran)))
+	if len(discarded) > 0 || len(ran) > 0 {
+		slog.InfoContext(ctx, "Graded retrieved chunks", "graded", graded, "relevant", len(relevant), "discarded", len(discarded), "actions", ran)
+	}
+	recordGrading(ctx, graded, discarded, ran)
+	return relevant
+}
+
+// gradeChunks splits docs into the relevant and irrelevant ones. If grading
+// fails, every chunk counts as relevant.
+func (r *RAGEngine) gradeChunks(ctx context.Context, query string, docs []Document) (relevant, discarded []Document) {
+	if len(docs) == 0 {
+		return nil, nil
+	}
+	verdicts, err := r.corrective.Grader.Grade(ctx, query, docs)
+	if err != nil || len(verdicts) != len(docs) {
+		errorsTotal.WithLabelValues("grade").Inc()
+		slog.WarnContext(ctx, "Grading failed, keeping every chunk", "error", err)
+		return docs, nil
+	}
+	for i, doc := range docs {
+		if verdicts[i] {
+			relevant = append(relevant, doc)
+		} else {
+			discarded = append(discarded, doc)
+		}
+	}
+	return relevant, discarded
+}
+
+// correctiveQuery asks the model for a different search query for question,
+// given the irrelevant chunks the original one found. It returns "" if the
+// call fails.
+func (r *RAGEngine) correctiveQuery(ctx context.Context, question string, discarded []Document) string {
+	ctx, span := tracer.Start(ctx, "rag.corrective_query")
+	defer span.End()
+
+	var prompt strings.Builder
+	prompt.WriteString("A search of the knowledge base for the question below found nothing that answers it. " +
+		"Write a different search query that is more likely to find the answer: use other words, synonyms, " +
+		"or the more general concept the question is about. Reply with the query only.\n\n")
+	if len(discarded) > 0 {
+		prompt.WriteString("Irrelevant passages the search found:\n")
+		for _, doc := range discarded {
+			prompt.WriteString("- " + truncateText(doc.Text, 200) + "\n")
+		}
+		prompt.WriteString("\n")
+	}
+	prompt.WriteString("Question: " + question + "\n\nSearch query:")
+	messages := []Message{
+		{Role: "system", Content: "You write search queries for a knowledge base."},
+		{Role: "user", Content: prompt.String()},
+	}
+	response, err := r.llm.ChatCompletion(ctx, r.corrective.Model, messages)
+	if err != nil {
+		endSpan(span, err)
+		slog.WarnContext(ctx, "Could not reword the query", "error", err)
+		return ""
+	}
+	return strings.Trim(strings.TrimSpace(response), "\"")
+}
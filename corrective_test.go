@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLLMGraderParsesVerdicts(t *testing.T) {
+	docs := []Document{{Text: "a"}, {Text: "b"}, {Text: "c"}}
+	grader := NewLLMGrader(&scriptedOpenAI{reply: "1: yes\n[2]: No.\n3: yes"}, "gpt-mini")
+	verdicts, err := grader.Grade(context.Background(), "q", docs)
+	if err != nil || len(verdicts) != 3 || !verdicts[0] || verdicts[1] || !verdicts[2] {
+		t.Fatalf("Grade = %v, %v", verdicts, err)
+	}
+
+	// Incomplete output falls back to keyword grading.
+	grader = NewLLMGrader(&scriptedOpenAI{reply: "1: yes"}, "gpt-mini")
+	docs = []Document{{Text: "The Pro plan price is $49."}, {Text: "Unrelated text."}}
+	verdicts, _ = grader.Grade(context.Background(), "Pro plan price", docs)
+	if !verdicts[0] || verdicts[1] {
+		t.Fatalf("expected keyword grading, got %v", verdicts)
+	}
+}
+
+// stubWebSearch returns fixed results and records its queries.
+type stubWebSearch struct {
+	results []Document
+	queries []string
+}
+
+func (s *stubWebSearch) Search(ctx context.Context, query string, limit int) ([]Document, error) {
+	s.queries = append(s.queries, query)
+	if s.results == nil {
+		return nil, errors.New("offline")
+	}
+	return s.results, nil
+}
+
+func TestCorrectiveRetrievalDiscardsAndRewrites(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(NewHashingEmbedder(256))
+	oa := &sequenceOpenAI{replies: []string{"1: no", "Billing monthly", "1: yes"}}
+	web := &stubWebSearch{}
+	engine := NewRAGEngine(oa, store, WithWebSearch(web), WithCorrectiveRetrieval(&CorrectiveRetrieval{
+		Grader:  NewLLMGrader(oa, "gpt-mini"),
+		Actions: []CorrectiveAction{CorrectRewrite, CorrectWebSearch},
+		Model:   "gpt-mini",
+	}))
+	ingestPages(ctx, engine, []Page{
+		{Text: "The Pro plan rate limit is 100 requests per second.", Source: "limits.md"},
+		{Text: "Billing: $49 monthly.", Source: "pricing.md"},
+	}, 1000, 0)
+
+	report := &RelevanceReport{}
+	docs := engine.Retrieve(withRelevanceReport(ctx, report), "How much does the Pro plan cost?", 1)
+	if len(docs) != 1 || docs[0].Source != "pricing.md" {
+		t.Fatalf("unexpected documents %+v", docs)
+	}
+	if len(report.Discarded) != 1 || report.Discarded[0].Source != "limits.md" || report.Graded != 2 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if len(report.Actions) != 1 || report.Actions[0] != CorrectRewrite || len(web.queries) != 0 {
+		t.Fatalf("expected only the rewrite to run, got %v and web searches %v", report.Actions, web.queries)
+	}
+	if prompt := oa.calls[1][1].Content; !strings.Contains(prompt, "- The Pro plan rate limit") {
+		t.Fatalf("expected the rewrite prompt to show the discarded chunk, got %q", prompt)
+	}
+}
+
+func TestCorrectiveRetrievalFallsBackToTheWeb(t *testing.T) {
+	web := &stubWebSearch{results: []Document{{Text: "Go was designed at Google.", Source: "https://go.dev"}}}
+	engine := NewRAGEngine(&dummyOpenAI{}, &dummyMilvus{}, WithWebSearch(web), WithCorrectiveRetrieval(&CorrectiveRetrieval{
+		Grader:  &KeywordGrader{},
+		Actions: []CorrectiveAction{CorrectWebSearch},
+	}))
+	report := &RelevanceReport{}
+	docs := engine.Retrieve(withRelevanceReport(context.Background(), report), "Where was Go designed?", 3)
+	if len(docs) != 1 || docs[0].Source != "https://go.dev" || len(web.queries) != 1 {
+		t.Fatalf("unexpected documents %+v", docs)
+	}
+	if report.Graded == 0 || len(report.Actions) != 1 || report.Actions[0] != CorrectWebSearch {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	// A failed web search leaves the relevant chunks found so far.
+	web.results = nil
+	if docs := engine.Retrieve(context.Background(), "Where was Go designed?", 3); len(docs) != 0 {
+		t.Fatalf("expected no documents, got %+v", docs)
+	}
+}
+
+func TestServerReportsDiscardedChunks(t *testing.T) {
+	server, _ := newTestServer()
+	ingestPages(context.Background(), server.engine, []Page{
+		{Text: "Milvus stores vectors.", Source: "milvus.md"},
+		{Text: "Go is a language from Google.", Source: "go.md"},
+		{Text: "Qdrant is written in Rust.", Source: "qdrant.md"},
+	}, 1000, 0)
+	server.engine.corrective = &CorrectiveRetrieval{Grader: NewLLMGrader(&scriptedOpenAI{reply: "1: no\n2: yes\n3: no"}, "gpt-mini")}
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"question":"What is Go?"}`)))
+	var resp queryResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("unexpected response %d: %v", rec.Code, err)
+	}
+	if resp.Grading == nil || resp.Grading.Graded != 3 || len(resp.Grading.Discarded) != 2 || resp.Grading.Actions != nil {
+		t.Fatalf("unexpected grading %+v", resp.Grading)
+	}
+}
+
+func TestCorrectiveFromEnv(t *testing.T) {
+	t.Setenv("CORRECTIVE_RAG", "keyword")
+	t.Setenv("CORRECTIVE_MODEL", "")
+	t.Setenv("CORRECTIVE_MIN_RELEVANT", "2")
+	corrective, err := correctiveFromEnv(&dummyOpenAI{}, "gpt-test", false)
+	if err != nil || corrective.MinRelevant != 2 || corrective.Model != "gpt-test" || len(corrective.Actions) != 1 || corrective.Actions[0] != CorrectRewrite {
+		t.Fatalf("correctiveFromEnv = %+v, %v", corrective, err)
+	}
+	t.Setenv("CORRECTIVE_FALLBACKS", "off")
+	if corrective, err := correctiveFromEnv(&dummyOpenAI{}, "gpt-test", false); err != nil || corrective.Actions != nil {
+		t.Fatalf("correctiveFromEnv = %+v, %v", corrective, err)
+	}
+	t.Setenv("CORRECTIVE_FALLBACKS", "rewrite,web")
+	if _, err := correctiveFromEnv(&dummyOpenAI{}, "gpt-test", false); err == nil {
+		t.Fatal("expected the web fallback to need a web search provider")
+	}
+	if corrective, err := correctiveFromEnv(&dummyOpenAI{}, "gpt-test", true); err != nil || len(corrective.Actions) != 2 {
+		t.Fatalf("correctiveFromEnv = %+v, %v", corrective, err)
+	}
+	for key, value := range map[string]string{"CORRECTIVE_RAG": "smart", "CORRECTIVE_MIN_RELEVANT": "0", "CORRECTIVE_FALLBACKS": "rewrite,ask"} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, value)
+			if _, err := correctiveFromEnv(&dummyOpenAI{}, "gpt-test", false); err == nil {
+				t.Fatalf("expected %s=%s to fail", key, value)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	start := time.Date(2024, time.January, 31, 10, 7, 30, 0, time.UTC) // a Wednesday
+	cases := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 1, 31, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 1, 31, 10, 15, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 1, 31, 11, 0, 0, 0, time.UTC)},
+		{"30 9 * * *", time.Date(2024, 2, 1, 9, 30, 0, 0, time.UTC)},
+		{"0 9-17/4 * * 1-5", time.Date(2024, 1, 31, 13, 0, 0, 0, time.UTC)},
+		{"0 0 * * 6,7", time.Date(2024, 2, 3, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		// With both day fields restricted, either one matching is enough.
+		{"0 0 15 * 5", time.Date(2024, 2, 2, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		schedule, err := ParseCron(c.expr)
+		if err != nil {
+			t.Errorf("ParseCron(%q) returned error: %v", c.expr, err)
+			continue
+		}
+		if got := schedule.Next(start); !got.Equal(c.want) {
+			t.Errorf("%q: next run %s, want %s", c.expr, got, c.want)
+		}
+	}
+
+	never, _ := ParseCron("0 0 30 2 *")
+	if got := never.Next(start); !got.IsZero() {
+		t.Errorf("expected February 30 never to match, got %s", got)
+	}
+}
+
+func TestParseCronRejectsInvalidExpressions(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "@often", "a * * * *"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("expected ParseCron(%q) to fail", expr)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxSubQuestions caps the parts a question is decomposed into.
+const maxSubQuestions = 4
+
+// AnswerPart is one sub-question of a decomposed question with the citations
+// of the answer that come from the documents retrieved for it.
+type AnswerPart struct {
+	Question  string
+	Citations []Citation
+}
+
+// compoundHint matches wording typical of questions that ask several things
+// at once, which are worth an LLM call to decompose.
+var compoundHint = regexp.MustCompile(`(?i)\b(and|or|versus|vs\.?|compare[ds]?|comparison|differ(?:s|ence|ences)?|both|as well as|respectively)\b|\?.*\?|;`)
+
+// AnswerDecomposed answers a compound question, such as "compare X and Y" or
+// "what is X and how do I configure Y", by splitting it into sub-questions,
+// retrieving limit documents for each, and generating one answer from all of
+// them, with each part's citations in Answer.Parts. A question that is not
+// compound is answered like Retrieve followed by GenerateResponse.
+func (r *RAGEngine) AnswerDecomposed(ctx context.Context, question string, limit int, model string, opts ...RetrieveOption) (Answer, error) {
+	ctx, span := tracer.Start(ctx, "rag.decompose")
+	defer span.End()
+	if refusal, ok := r.OutOfScope(ctx, question); ok {
+		return refusal, nil
+	}
+
+	var parts []string
+	if compoundHint.MatchString(question) {
+		parts = r.decomposeQuestion(ctx, question, model)
+	}
+	span.SetAttributes(attribute.Int("rag.sub_questions", len(parts)))
+	if len(parts) < 2 {
+		return r.generate(ctx, question, r.Retrieve(ctx, question, limit, opts...), model, nil, nil)
+	}
+
+	retrieved := make([][]Document, len(parts))
+	var docs []Document
+	seen := make(map[string]bool)
+	for i, part := range parts {
+		retrieved[i] = r.Retrieve(ctx, part, limit, opts...)
+		for _, doc := range retrieved[i] {
+			if key := documentKey(doc); !seen[key] {
+				seen[key] = true
+				docs = append(docs, doc)
+			}
+		}
+	}
+	slog.InfoContext(ctx, "Decomposed question", "parts", len(parts), "documents", len(docs))
+
+	answer, err := r.generate(context.WithValue(ctx, subQuestionsKey{}, parts), question, docs, model, nil, nil)
+	if err != nil || answer.NoContext {
+		return answer, err
+	}
+	for i, part := range parts {
+		answerPart := AnswerPart{Question: part}
+		for _, c := range answer.Citations {
+			for _, doc := range retrieved[i] {
+				if documentKey(doc) == documentKey(c.Document) {
+					answerPart.Citations = append(answerPart.Citations, c)
+					break
+				}
+			}
+		}
+		answer.Parts = append(answer.Parts, answerPart)
+	}
+	return answer, nil
+}
+
+// documentKey identifies a retrieved chunk across searches.
+func documentKey(doc Document) string {
+	return doc.Source + "\x00" + doc.Text
+}
+
+// decomposeQuestion asks the LLM to split question into self-contained
+// sub-questions. It returns fewer than two when the question asks one thing
+// or the call fails.
+func (r *RAGEngine) decomposeQuestion(ctx context.Context, question, model string) []string {
+	ctx, span := tracer.Start(ctx, "rag.decompose_question", trace.WithAttributes(attribute.Int("rag.max_sub_questions", maxSubQuestions)))
+	defer span.End()
+
+	prompt := fmt.Sprintf("If the question below asks several things, or compares or relates several things, split it into "+
+		"at most %d self-contained sub-questions that can each be searched for on their own; name the subject in every "+
+		"sub-question instead of using pronouns. If it asks a single thing, reply with the question unchanged. "+
+		"Reply with one question per line and nothing else.\n\nQuestion: %s", maxSubQuestions, question)
+	messages := []Message{
+		{Role: "system", Content: "You split compound questions into simple search queries."},
+		{Role: "user", Content: prompt},
+	}
+	response, err := r.llm.ChatCompletion(ctx, model, messages)
+	if err != nil {
+		endSpan(span, err)
+		slog.WarnContext(ctx, "Could not decompose question, answering it whole", "error", err)
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var parts []string
+	for _, line := range strings.Split(response, "\n") {
+		part := strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "0123456789.)-*• "))
+		part = strings.Trim(part, "\"")
+		if part == "" || seen[strings.ToLower(part)] {
+			continue
+		}
+		seen[strings.ToLower(part)] = true
+		parts = append(parts, part)
+		if len(parts) == maxSubQuestions {
+			break
+		}
+	}
+	return parts
+}
+
+type subQuestionsKey struct{}
+
+// questionPrompt returns the question as the answer prompt states it: with
+// the sub-questions of AnswerDecomposed, if ctx carries them, for the model
+// to answer in turn.
+func questionPrompt(ctx context.Context, query string) string {
+	parts, _ := ctx.Value(subQuestionsKey{}).([]string)
+	if len(parts) == 0 {
+		return query
+	}
+	var b strings.Builder
+	b.WriteString(query + "\n\nThe question has these parts. Answer each part in turn, citing the context documents for it, " +
+		"then bring the parts together, e.g. to compare them:\n")
+	for i, part := range parts {
+		fmt.Fprintf(&b, "%d. %s\n", i+1, part)
+	}
+	return strings.TrimSpace(b.String())
+}
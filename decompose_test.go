@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAnswerDecomposedRetrievesPerPart(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(NewHashingEmbedder(256))
+	oa := &sequenceOpenAI{replies: []string{
+		"1. What is the rate limit of the Basic plan?\n2. What is the rate limit of the Pro plan?",
+		"Basic allows 10 requests per second [1], while Pro allows 100 [2].",
+	}}
+	engine := NewRAGEngine(oa, store)
+	ingestPages(ctx, engine, []Page{
+		{Text: "The Basic plan rate limit is 10 requests per second.", Source: "basic.md"},
+		{Text: "The Pro plan rate limit is 100 requests per second.", Source: "pro.md"},
+	}, 1000, 0)
+
+	answer, err := engine.AnswerDecomposed(ctx, "Compare the rate limits of the Basic and Pro plans", 1, "gpt-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(answer.Citations) != 2 || len(answer.Parts) != 2 {
+		t.Fatalf("unexpected answer %+v", answer)
+	}
+	for i, want := range []string{"basic.md", "pro.md"} {
+		part := answer.Parts[i]
+		if len(part.Citations) != 1 || part.Citations[0].Source != want {
+			t.Errorf("part %d (%s) cites %+v, want %s", i+1, part.Question, part.Citations, want)
+		}
+	}
+	prompt := oa.calls[1][len(oa.calls[1])-1].Content
+	if !strings.Contains(prompt, "Question: Compare the rate limits of the Basic and Pro plans\n\nThe question has these parts.") ||
+		!strings.Contains(prompt, "2. What is the rate limit of the Pro plan?") {
+		t.Fatalf("unexpected answer prompt %q", prompt)
+	}
+}
+
+func TestAnswerDecomposedAnswersSimpleQuestionsWhole(t *testing.T) {
+	oa := &sequenceOpenAI{replies: []string{"Milvus is a vector database."}}
+	mv := &dummyMilvus{}
+	engine := NewRAGEngine(oa, mv)
+	answer, err := engine.AnswerDecomposed(context.Background(), "What is Milvus?", 3, "gpt-test")
+	if err != nil || answer.Parts != nil || len(oa.calls) != 1 || mv.lastQuery != "What is Milvus?" {
+		t.Fatalf("expected a plain answer without a decomposition call, got %+v, %v after %d calls", answer, err, len(oa.calls))
+	}
+
+	// A compound-looking question the model keeps whole is answered whole too.
+	oa = &sequenceOpenAI{replies: []string{"Who founded Zilliz and Milvus?", "Zilliz."}}
+	engine = NewRAGEngine(oa, mv)
+	if answer, _ := engine.AnswerDecomposed(context.Background(), "Who founded Zilliz and Milvus?", 3, "gpt-test"); answer.Parts != nil || answer.Text != "Zilliz." {
+		t.Fatalf("unexpected answer %+v", answer)
+	}
+}
+
+func TestServerDecomposesOnRequest(t *testing.T) {
+	server, _ := newTestServer()
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"question":"What is Go?","decompose":true}`)))
+	var resp queryResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || rec.Code != http.StatusOK || resp.Parts != nil {
+		t.Fatalf("expected a plain answer, got %d %+v", rec.Code, resp)
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/query/stream", strings.NewReader(`{"question":"q","decompose":true}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected streaming decomposition to be rejected, got %d", rec.Code)
+	}
+}
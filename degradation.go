@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// StoreFallback is a way to answer when searching the vector store fails,
+// for example because Milvus is unreachable.
+type StoreFallback string
+
+const (
+	// FallbackCache answers with the most recent recorded answer to the same
+	// question, from the history store, without calling the model.
+	FallbackCache StoreFallback = "cache"
+	// FallbackKeyword searches the context documents recorded in the history
+	// store by keyword and answers from the best matches.
+	FallbackKeyword StoreFallback = "keyword"
+	// FallbackLLM answers from the model's general knowledge, starting with
+	// a note that the knowledge base is unavailable.
+	FallbackLLM StoreFallback = "llm"
+)
+
+// ParseStoreFallbacks parses a STORE_FALLBACK value: a comma-separated list
+// of fallbacks to try in order, such as "cache,keyword,llm". Empty or "off"
+// means none.
+func ParseStoreFallbacks(value string) ([]StoreFallback, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" || value == "off" {
+		return nil, nil
+	}
+	var fallbacks []StoreFallback
+	for _, part := range strings.Split(value, ",") {
+		switch fallback := StoreFallback(strings.TrimSpace(part)); fallback {
+		case FallbackCache, FallbackKeyword, FallbackLLM:
+			fallbacks = append(fallbacks, fallback)
+		default:
+			return nil, fmt.Errorf("unknown vector store fallback %q (expected off or a list of cache, keyword, and llm)", part)
+		}
+	}
+	return fallbacks, nil
+}
+
+// WithStoreFallback makes retrieval degrade gracefully when the vector store
+// search fails: the fallbacks are tried in order until one produces
+// something to answer from. Without fallbacks, or if none of them does,
+// retrieval returns no documents as before, but the failure is still logged
+// and counted. The cache and keyword fallbacks need WithHistory.
+func WithStoreFallback(fallbacks ...StoreFallback) EngineOption {
+	return func(r *RAGEngine) {
+		r.storeFallbacks = fallbacks
+	}
+}
+
+const (
+	// fallbackField is the metadata key set on documents produced by a
+	// StoreFallback, holding the fallback's name.
+	fallbackField = "store_fallback"
+	// cachedAnswerField holds the cached answer on the first document of a
+	// FallbackCache result.
+	cachedAnswerField = "cached_answer"
+	// unavailableSource is the source of the placeholder document that
+	// FallbackLLM retrieves.
+	unavailableSource = "(knowledge base unavailable)"
+	// fallbackHistoryLimit is how many recorded queries the cache and
+	// keyword fallbacks look through.
+	fallbackHistoryLimit = 500
+)
+
+// searchFailure collects the error of a failed vector store search. Stores
+// report to it through the context because SearchSimilar has no error
+// result.
+type searchFailure struct {
+	mu  sync.Mutex
+	err error
+}
+
+type searchFailureKey struct{}
+
+// withSearchFailure returns a context in which reportSearchFailure records
+// the first failure in failure.
+func withSearchFailure(ctx context.Context, failure *searchFailure) context.Context {
+	return context.WithValue(ctx, searchFailureKey{}, failure)
+}
+
+// reportSearchFailure records that a SearchSimilar call failed with err, so
+// that the engine can tell an unavailable store from one with no matches.
+func reportSearchFailure(ctx context.Context, err error) {
+	failure, ok := ctx.Value(searchFailureKey{}).(*searchFailure)
+	if !ok {
+		return
+	}
+	failure.mu.Lock()
+	defer failure.mu.Unlock()
+	if failure.err == nil {
+		failure.err = err
+	}
+}
+
+// fallbackDocuments returns the documents to answer from after the vector
+// store search for query failed with cause.
+func (r *RAGEngine) fallbackDocuments(ctx context.Context, query string, limit int, filter Filter, cause error) []Document {
+	for _, fallback := range r.storeFallbacks {
+		var docs []Document
+		switch fallback {
+		case FallbackCache:
+			docs = r.cachedAnswer(ctx, query)
+		case FallbackKeyword:
+			docs = r.keywordSearch(ctx, query, limit, filter)
+		case FallbackLLM:
+			docs = []Document{{Source: unavailableSource, Metadata: map[string]any{fallbackField: string(FallbackLLM)}}}
+		}
+		if len(docs) > 0 {
+			storeFallbacks.WithLabelValues(string(fallback)).Inc()
+			slog.WarnContext(ctx, "Vector store unavailable, using fallback", "fallback", fallback, "documents", len(docs), "error", cause)
+			return docs
+		}
+	}
+	storeFallbacks.WithLabelValues("none").Inc()
+	slog.ErrorContext(ctx, "Vector store unavailable, retrieving no documents", "fallbacks", len(r.storeFallbacks), "error", cause)
+	return nil
+}
+
+// fallbackRecords returns the recorded queries the engine's caller may see
+// whose answers were grounded in the vector store rather than a fallback.
+func (r *RAGEngine) fallbackRecords(ctx context.Context, search string) []QueryRecord {
+	if r.history == nil {
+		return nil
+	}
+	records, err := r.history.ListQueries(ctx, HistoryFilter{Limit: fallbackHistoryLimit, Search: search, Tenant: r.tenant})
+	if err != nil {
+		slog.WarnContext(ctx, "Reading query history for the fallback failed", "error", err)
+		return nil
+	}
+	var usable []QueryRecord
+	for _, record := range records {
+		if record.Error != "" || record.NoContext || len(record.Documents) == 0 || !r.canRead(record) {
+			continue
+		}
+		if _, ok := record.Documents[0].Metadata[fallbackField]; ok {
+			continue
+		}
+		usable = append(usable, record)
+	}
+	return usable
+}
+
+// cachedAnswer returns the context documents of the most recent recorded
+// answer to query, marked for FallbackCache and carrying the answer.
+// Questions match if they have the same words, ignoring case and punctuation.
+func (r *RAGEngine) cachedAnswer(ctx context.Context, query string) []Document {
+	want := strings.Join(tokenize(query), " ")
+	for _, record := range r.fallbackRecords(ctx, strings.TrimSpace(query)) {
+		if strings.Join(tokenize(record.Question), " ") != want {
+			continue
+		}
+		docs := markFallback(record.Documents, FallbackCache)
+		docs[0].Metadata[cachedAnswerField] = record.Answer
+		return docs
+	}
+	return nil
+}
+
+// keywordSearch ranks the distinct context documents of recent recorded
+// queries against query by BM25 and returns up to limit that share a term
+// with it, marked for FallbackKeyword. Their similarity is the BM25 score
+// relative to the best match.
+func (r *RAGEngine) keywordSearch(ctx context.Context, query string, limit int, filter Filter) []Document {
+	var docs []Document
+	seen := map[string]bool{}
+	for _, record := range r.fallbackRecords(ctx, "") {
+		for _, doc := range record.Documents {
+			key := doc.Source + "\x00" + doc.Text
+			if seen[key] || !filter.Match(doc) || (r.roles != nil && !readableBy(doc.Metadata, r.roles)) {
+				continue
+			}
+			seen[key] = true
+			docs = append(docs, doc)
+		}
+	}
+	scores, best := bm25Scores(query, docs)
+	if best == 0 {
+		return nil
+	}
+	var matches []Document
+	var matchScores []float64
+	for i, doc := range docs {
+		if scores[i] > 0 {
+			doc.Similarity = float32(scores[i] / best)
+			matches = append(matches, doc)
+			matchScores = append(matchScores, scores[i])
+		}
+	}
+	matches = sortByScores(matches, matchScores)
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return markFallback(matches, FallbackKeyword)
+}
+
+// markFallback returns copies of docs with fallbackField set to fallback.
+func markFallback(docs []Document, fallback StoreFallback) []Document {
+	marked := make([]Document, len(docs))
+	for i, doc := range docs {
+		doc.Metadata = maps.Clone(doc.Metadata)
+		if doc.Metadata == nil {
+			doc.Metadata = map[string]any{}
+		}
+		doc.Metadata[fallbackField] = string(fallback)
+		marked[i] = doc
+	}
+	return marked
+}
+
+// documentsFallback returns the fallback that produced docs, or "" for
+// documents retrieved from the vector store.
+func documentsFallback(docs []Document) StoreFallback {
+	if len(docs) == 0 {
+		return ""
+	}
+	fallback, _ := docs[0].Metadata[fallbackField].(string)
+	return StoreFallback(fallback)
+}
+
+// answerFromCache returns the cached answer carried by FallbackCache
+// documents, with its citations resolved against them.
+func answerFromCache(ctx context.Context, docs []Document) Answer {
+	text, _ := docs[0].Metadata[cachedAnswerField].(string)
+	docs = slices.Clone(docs)
+	docs[0].Metadata = maps.Clone(docs[0].Metadata)
+	delete(docs[0].Metadata, cachedAnswerField)
+	slog.InfoContext(ctx, "Answering from the query history", "documents", len(docs))
+	return Answer{Text: text, Citations: extractCitations(text, docs)}
+}
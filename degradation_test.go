@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// outageEmbedder fails while down, like an embedding API or vector store
+// that cannot be reached.
+type outageEmbedder struct {
+	Embedder
+	down bool
+}
+
+func (o *outageEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if o.down {
+		return nil, errors.New("connection refused")
+	}
+	return o.Embedder.Embed(ctx, texts)
+}
+
+func TestParseStoreFallbacks(t *testing.T) {
+	for _, value := range []string{"", "off", " OFF "} {
+		if fallbacks, err := ParseStoreFallbacks(value); err != nil || fallbacks != nil {
+			t.Fatalf("ParseStoreFallbacks(%q) = %v, %v; want none", value, fallbacks, err)
+		}
+	}
+	fallbacks, err := ParseStoreFallbacks("cache, keyword,LLM")
+	if err != nil || len(fallbacks) != 3 || fallbacks[0] != FallbackCache || fallbacks[2] != FallbackLLM {
+		t.Fatalf("unexpected fallbacks %v, %v", fallbacks, err)
+	}
+	if _, err := ParseStoreFallbacks("cache,disk"); err == nil {
+		t.Fatal("expected an error for an unknown fallback")
+	}
+}
+
+func TestStoreFallbacks(t *testing.T) {
+	ctx := context.Background()
+	embedder := &outageEmbedder{Embedder: NewHashingEmbedder(256)}
+	store := NewMemoryStore(embedder)
+	history := newTestHistory(t)
+	store.InsertDocuments(ctx,
+		[]string{"Go was created at Google.", "Milvus stores vectors."},
+		[]string{"Go Docs", "Milvus Docs"}, nil)
+
+	// Answer once while the store is up, so the history has something.
+	live := NewRAGEngine(&scriptedOpenAI{reply: "Go was created at Google [1]."}, store,
+		WithHistory(history), WithStoreFallback(FallbackCache))
+	docs := live.Retrieve(withQueryID(ctx, "q1"), "Who created Go?", 1)
+	if len(docs) != 1 || documentsFallback(docs) != "" {
+		t.Fatalf("expected a live search result, got %+v", docs)
+	}
+	if answer, err := live.GenerateResponse(withQueryID(ctx, "q1"), "Who created Go?", docs, "gpt-test"); err != nil || answer.Fallback != "" {
+		t.Fatalf("unexpected live answer %+v, %v", answer, err)
+	}
+
+	embedder.down = true
+	ask := func(question string, fallbacks ...StoreFallback) (Answer, *dummyOpenAI) {
+		t.Helper()
+		oa := &dummyOpenAI{}
+		engine := NewRAGEngine(oa, store, WithHistory(history), WithStoreFallback(fallbacks...))
+		docs := engine.Retrieve(ctx, question, 3)
+		answer, err := engine.GenerateResponse(ctx, question, docs, "gpt-test")
+		if err != nil {
+			t.Fatalf("GenerateResponse: %v", err)
+		}
+		return answer, oa
+	}
+
+	answer, oa := ask("who created go", FallbackCache)
+	if answer.Fallback != FallbackCache || answer.Text != "Go was created at Google [1]." || oa.lastMessages != nil {
+		t.Fatalf("expected the cached answer without a model call, got %+v", answer)
+	}
+	if len(answer.Citations) != 1 || answer.Citations[0].Source != "Go Docs" || answer.Citations[0].Document.Metadata[cachedAnswerField] != nil {
+		t.Fatalf("expected the cached citation, got %+v", answer.Citations)
+	}
+
+	answer, oa = ask("Where was Go created?", FallbackCache, FallbackKeyword)
+	if answer.Fallback != FallbackKeyword || oa.lastMessages == nil {
+		t.Fatalf("expected a keyword answer after a cache miss, got %+v", answer)
+	}
+	if prompt := oa.lastMessages[len(oa.lastMessages)-1].Content; !strings.Contains(prompt, "Go was created at Google.") {
+		t.Fatalf("expected the recorded document in the prompt, got %q", prompt)
+	}
+
+	answer, oa = ask("What does Milvus store?", FallbackKeyword, FallbackLLM)
+	if answer.Fallback != FallbackLLM || !answer.NoContext || !strings.Contains(oa.lastMessages[0].Content, "currently unavailable") {
+		t.Fatalf("expected a general-knowledge answer, got %+v (%+v)", answer, oa.lastMessages)
+	}
+
+	engine := NewRAGEngine(&dummyOpenAI{}, store, WithHistory(history))
+	if docs := engine.Retrieve(ctx, "Who created Go?", 3); len(docs) != 0 {
+		t.Fatalf("expected no documents without fallbacks, got %+v", docs)
+	}
+
+	// Answers produced by a fallback are never reused by one.
+	records, _ := history.ListQueries(ctx, HistoryFilter{})
+	if got := len(engine.fallbackRecords(ctx, "")); got != 1 || len(records) != 4 {
+		t.Fatalf("expected 1 of %d recorded queries to be usable, got %d", len(records), got)
+	}
+}
+
+func TestServerReportsFallback(t *testing.T) {
+	store := NewMemoryStore(&outageEmbedder{Embedder: NewHashingEmbedder(256), down: true})
+	engine := NewRAGEngine(&scriptedOpenAI{reply: "Not from the knowledge base: Go is from Google."}, store, WithStoreFallback(FallbackLLM))
+	server := NewServer(engine, "gpt-test")
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"question":"Who made Go?"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var resp queryResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Fallback != "llm" || !resp.NoContext {
+		t.Fatalf("expected an llm fallback answer, got %+v", resp)
+	}
+}
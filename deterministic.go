@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+type deterministicKey struct{}
+
+// withDeterministic asks the LLM clients for reproducible completions: zero
+// temperature and, where the provider supports one, the given sampling seed.
+func withDeterministic(ctx context.Context, seed int) context.Context {
+	return context.WithValue(ctx, deterministicKey{}, seed)
+}
+
+// deterministicSeed returns the seed set by withDeterministic, if any.
+func deterministicSeed(ctx context.Context) (int, bool) {
+	seed, ok := ctx.Value(deterministicKey{}).(int)
+	return seed, ok
+}
+
+// openAIZeroTemperature is sent for a temperature of 0, which go-openai would
+// otherwise omit, leaving the API's default of 1.
+const openAIZeroTemperature = math.SmallestNonzeroFloat32
+
+// WithDeterministic makes answers reproducible, for evaluation runs and CI:
+// every model call made while retrieving and answering uses zero
+// temperature and seed (OpenAI, Gemini, and Ollama honour seeds), and each
+// answer's prompt is fingerprinted together with the IDs of its context
+// chunks (see Answer.Fingerprint). With a cache, answers are stored under
+// their fingerprint and replayed without calling the model, which makes
+// reruns stable even where seeded sampling is best-effort. cache may be nil.
+func WithDeterministic(seed int, cache ResponseCache) EngineOption {
+	return func(r *RAGEngine) {
+		r.seed = &seed
+		r.responses = cache
+	}
+}
+
+// deterministic returns ctx with the engine's seed, when it has one.
+func (r *RAGEngine) deterministic(ctx context.Context) context.Context {
+	if r.seed == nil {
+		return ctx
+	}
+	return withDeterministic(ctx, *r.seed)
+}
+
+// cachedResponse returns the cached response for fingerprint, if any.
+func (r *RAGEngine) cachedResponse(ctx context.Context, fingerprint string) (string, bool) {
+	if r.responses == nil {
+		return "", false
+	}
+	response, ok, err := r.responses.Response(ctx, fingerprint)
+	if err != nil {
+		slog.WarnContext(ctx, "Reading the response cache failed", "error", err)
+		return "", false
+	}
+	if ok {
+		slog.InfoContext(ctx, "Replaying cached response", "fingerprint", fingerprint)
+	}
+	return response, ok
+}
+
+// answerFingerprint identifies an answer's inputs: the model, the prompt
+// messages, and the context chunks, by source and content hash. Equal
+// fingerprints mean the model was asked exactly the same thing.
+func answerFingerprint(model string, messages []Message, docs []Document) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "model %q\n", model)
+	for _, msg := range messages {
+		fmt.Fprintf(h, "message %q %q\n", msg.Role, msg.Content)
+	}
+	for _, doc := range docs {
+		hash, _ := doc.Metadata["content_hash"].(string)
+		if hash == "" {
+			hash = contentHash(doc.Text)
+		}
+		fmt.Fprintf(h, "chunk %q %s\n", doc.Source, hash)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ResponseCache stores model responses under the fingerprint of their
+// inputs (see WithDeterministic).
+type ResponseCache interface {
+	// Response returns the stored response, or ok false if there is none.
+	Response(ctx context.Context, fingerprint string) (response string, ok bool, err error)
+	PutResponse(ctx context.Context, fingerprint, response string) error
+}
+
+// fingerprintPattern matches the fingerprints answerFingerprint produces.
+var fingerprintPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// FileResponseCache keeps each response in a file under dir, so that cached
+// answers can be checked into a repository for CI.
+type FileResponseCache struct {
+	dir string
+}
+
+// NewFileResponseCache creates dir if needed and caches responses in it.
+func NewFileResponseCache(dir string) (*FileResponseCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileResponseCache{dir: dir}, nil
+}
+
+// path shards responses into subdirectories by fingerprint prefix.
+func (f *FileResponseCache) path(fingerprint string) (string, error) {
+	if !fingerprintPattern.MatchString(fingerprint) {
+		return "", fmt.Errorf("invalid fingerprint %q", fingerprint)
+	}
+	return filepath.Join(f.dir, fingerprint[:2], fingerprint+".txt"), nil
+}
+
+func (f *FileResponseCache) Response(ctx context.Context, fingerprint string) (string, bool, error) {
+	path, err := f.path(fingerprint)
+	if err != nil {
+		return "", false, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return string(data), true, nil
+}
+
+func (f *FileResponseCache) PutResponse(ctx context.Context, fingerprint, response string) error {
+	path, err := f.path(fingerprint)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(response), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// deterministicFromEnv configures deterministic mode from DETERMINISTIC_SEED,
+// caching answers in RESPONSE_CACHE_DIR if set. ok is false when
+// DETERMINISTIC_SEED is unset.
+func deterministicFromEnv() (seed int, cache ResponseCache, ok bool, err error) {
+	raw := os.Getenv("DETERMINISTIC_SEED")
+	if raw == "" {
+		return 0, nil, false, nil
+	}
+	if seed, err = strconv.Atoi(raw); err != nil {
+		return 0, nil, false, fmt.Errorf("invalid DETERMINISTIC_SEED %q (expected an integer)", raw)
+	}
+	if dir := os.Getenv("RESPONSE_CACHE_DIR"); dir != "" {
+		if cache, err = NewFileResponseCache(dir); err != nil {
+			return 0, nil, false, fmt.Errorf("opening response cache: %w", err)
+		}
+	}
+	return seed, cache, true, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestDeterministicAnswersAreFingerprintedAndReplayed(t *testing.T) {
+	ctx := context.Background()
+	cache, err := NewFileResponseCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	oa := &sequenceOpenAI{replies: []string{"Postgres [1].", "Something else."}}
+	engine := NewRAGEngine(oa, NewMemoryStore(NewHashingEmbedder(256)), WithDeterministic(7, cache))
+	ingestPages(ctx, engine, []Page{{Text: "The invoices are stored in Postgres.", Source: "billing.md"}}, 1000, 0)
+
+	docs := engine.Retrieve(ctx, "Where are the invoices?", 1)
+	first, err := engine.GenerateResponse(ctx, "Where are the invoices?", docs, "gpt-test")
+	if err != nil || len(first.Fingerprint) != 64 {
+		t.Fatalf("expected a fingerprinted answer, got %+v, %v", first, err)
+	}
+	second, err := engine.GenerateResponse(ctx, "Where are the invoices?", docs, "gpt-test")
+	if err != nil || second.Text != "Postgres [1]." || second.Fingerprint != first.Fingerprint || len(second.Citations) != 1 {
+		t.Fatalf("expected the cached answer, got %+v, %v", second, err)
+	}
+	if len(oa.calls) != 1 {
+		t.Fatalf("expected one model call, got %d", len(oa.calls))
+	}
+
+	other, _ := engine.GenerateResponse(ctx, "Where are the invoices?", docs, "gpt-other")
+	if other.Fingerprint == first.Fingerprint || other.Text != "Something else." {
+		t.Fatalf("expected another model to get another fingerprint, got %+v", other)
+	}
+}
+
+func TestAnswerFingerprintCoversTheContextChunks(t *testing.T) {
+	messages := []Message{{Role: "user", Content: "q"}}
+	a := answerFingerprint("m", messages, []Document{{Source: "a.md", Text: "one"}})
+	if a != answerFingerprint("m", messages, []Document{{Source: "a.md", Text: "one"}}) {
+		t.Fatal("expected equal inputs to give equal fingerprints")
+	}
+	if a == answerFingerprint("m", messages, []Document{{Source: "a.md", Text: "two"}}) {
+		t.Fatal("expected changed chunk content to change the fingerprint")
+	}
+	if a == answerFingerprint("m", messages, []Document{{Source: "b.md", Text: "one"}}) {
+		t.Fatal("expected another source to change the fingerprint")
+	}
+}
+
+func TestOpenAIClientSendsSeedInDeterministicMode(t *testing.T) {
+	var request map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request = nil
+		json.NewDecoder(r.Body).Decode(&request)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[{"index":0,"message":{"role":"assistant","content":"ok"}}]}`))
+	}))
+	defer server.Close()
+	config := openai.DefaultConfig("key")
+	config.BaseURL = server.URL
+	client := &OpenAIClientImpl{client: openai.NewClientWithConfig(config)}
+	messages := []Message{{Role: "user", Content: "hi"}}
+
+	if _, err := client.ChatCompletion(withDeterministic(context.Background(), 42), "gpt-test", messages); err != nil {
+		t.Fatal(err)
+	}
+	if request["seed"] != 42.0 || request["temperature"] == nil || request["temperature"].(float64) > 1e-6 {
+		t.Fatalf("expected seed 42 at zero temperature, got %v", request)
+	}
+	if _, err := client.ChatCompletion(context.Background(), "gpt-test", messages); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := request["seed"]; ok {
+		t.Fatalf("expected no seed outside deterministic mode, got %v", request)
+	}
+}
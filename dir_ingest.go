@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnsupportedFile is returned by LoadFile for file types it has no loader for.
+var ErrUnsupportedFile = errors.New("unsupported file type (supported: .pdf, .docx, .pptx, .csv, .jsonl, .md, .markdown, .txt, source code, images, and audio)")
+
+// LoadFile loads a file with the loader for its extension: PDFs page by page,
+// presentations slide by slide, CSV and JSONL files record by record (with
+// every field as text), and Word documents, Markdown, plain text, and source
+// code files whole. Images are loaded for the vision model (see WithVision)
+// and audio files for transcription (see WithTranscriber).
+func LoadFile(path string) ([]Page, error) {
+	switch ext := strings.ToLower(filepath.Ext(path)); {
+	case ext == ".pdf":
+		return LoadPDF(path)
+	case ext == ".docx":
+		return LoadDOCX(path)
+	case ext == ".pptx":
+		return LoadPPTX(path)
+	case recordFile(path):
+		return LoadRecords(path, RecordOptions{})
+	case ext == ".md" || ext == ".markdown" || ext == ".txt" || codeLanguages[ext] != "":
+		return LoadTextFile(path)
+	case imageTypes[ext] != "":
+		page, err := LoadImage(path)
+		if err != nil {
+			return nil, err
+		}
+		return []Page{page}, nil
+	case audioExtensions[ext]:
+		page, err := LoadAudio(path)
+		if err != nil {
+			return nil, err
+		}
+		return []Page{page}, nil
+	default:
+		return nil, fmt.Errorf("%s: %w", path, ErrUnsupportedFile)
+	}
+}
+
+// supportedFile reports whether LoadFile can load path.
+func supportedFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".pdf" || ext == ".docx" || ext == ".pptx" || recordFile(path) || ext == ".md" || ext == ".markdown" || ext == ".txt" || codeLanguages[ext] != "" || imageTypes[ext] != "" || audioExtensions[ext]
+}
+
+// DirectoryOptions selects the files of a directory ingestion and how they
+// are chunked.
+type DirectoryOptions struct {
+	// Include and Exclude are glob patterns (see path.Match) matched against
+	// each file's name and its slash-separated path relative to the
+	// directory. With no Include patterns every supported file is included.
+	Include, Exclude []string
+	Workers          int // files loaded and ingested concurrently, default 4
+	ChunkSize        int
+	Overlap          int
+}
+
+// FileError records a file that failed to load or ingest.
+type FileError struct {
+	Path string
+	Err  error
+}
+
+// DirectoryReport summarizes a directory ingestion.
+type DirectoryReport struct {
+	Files    int          // files ingested
+	Skipped  int          // files excluded by the patterns or of an unsupported type
+	Removed  int          // files whose chunks were deleted because they are gone
+	Chunks   IngestReport // chunk counts across all ingested files
+	Errors   []FileError
+	Duration time.Duration
+}
+
+// IngestDirectory walks dir, loads every supported file selected by the
+// options with the loader for its type, and ingests the files in parallel.
+// Each file's source is its slash-separated path relative to dir, so files
+// with the same name in different folders are kept apart. Files that fail are
+// recorded in the report and do not stop the others; an error is only
+// returned when dir cannot be walked or ctx is canceled.
+func IngestDirectory(ctx context.Context, engine *RAGEngine, dir string, opts DirectoryOptions) (DirectoryReport, error) {
+	start := time.Now()
+	var report DirectoryReport
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if p != dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || strings.HasPrefix(d.Name(), ".") || !supportedFile(p) || !selected(rel, opts.Include, opts.Exclude) {
+			report.Skipped++
+			return nil
+		}
+		files = append(files, rel)
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("walking %s: %w", dir, err)
+	}
+	slog.InfoContext(ctx, "Found files to ingest", "dir", dir, "files", len(files), "skipped", report.Skipped)
+
+	ingestAll(ctx, files, opts.Workers, &report, func(rel string) (IngestReport, error) {
+		return ingestFile(ctx, engine, dir, rel, opts.ChunkSize, opts.Overlap)
+	})
+	report.Duration = time.Since(start)
+	return report, ctx.Err()
+}
+
+// ingestAll runs ingest for every name on up to workers goroutines (default
+// 4) and adds the outcomes to report. It stops handing out names once ctx is
+// canceled.
+func ingestAll(ctx context.Context, names []string, workers int, report *DirectoryReport, ingest func(name string) (IngestReport, error)) {
+	if workers <= 0 {
+		workers = 4
+	}
+	jobs := make(chan string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for range min(workers, max(len(names), 1)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range jobs {
+				chunks, err := ingest(name)
+				mu.Lock()
+				report.Chunks.Inserted += chunks.Inserted
+				report.Chunks.Updated += chunks.Updated
+				report.Chunks.Skipped += chunks.Skipped
+				report.Chunks.Removed += chunks.Removed
+				report.Chunks.Dropped += chunks.Dropped
+				if err != nil {
+					slog.ErrorContext(ctx, "Ingesting file failed", "file", name, "error", err)
+					report.Errors = append(report.Errors, FileError{Path: name, Err: err})
+				} else {
+					report.Files++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, name := range names {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- name
+	}
+	close(jobs)
+	wg.Wait()
+	sort.Slice(report.Errors, func(i, j int) bool { return report.Errors[i].Path < report.Errors[j].Path })
+}
+
+// ingestFile loads and ingests the file at rel within dir under the source rel.
+func ingestFile(ctx context.Context, engine *RAGEngine, dir, rel string, chunkSize, overlap int) (IngestReport, error) {
+	pages, err := LoadFile(filepath.Join(dir, filepath.FromSlash(rel)))
+	if err != nil {
+		return IngestReport{}, err
+	}
+	for i := range pages {
+		pages[i].Source = rel
+	}
+	report, ok := ingestPages(ctx, engine, pages, chunkSize, overlap)
+	if !ok {
+		return report, fmt.Errorf("storing chunks failed (%s)", report)
+	}
+	return report, nil
+}
+
+// selected reports whether the file at the slash-separated relative path rel
+// matches one of the include patterns, or there are none, and none of the
+// exclude patterns.
+func selected(rel string, include, exclude []string) bool {
+	matches := func(patterns []string) bool {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, rel); ok {
+				return true
+			}
+			if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+				return true
+			}
+		}
+		return false
+	}
+	return (len(include) == 0 || matches(include)) && !matches(exclude)
+}
+
+// splitPatterns splits a comma-separated list of glob patterns.
+func splitPatterns(list string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(list, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
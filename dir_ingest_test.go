@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestIngestDirectoryFiltersAndUsesRelativeSources(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"README.md":          "# Intro\n\nGo is a programming language.",
+		"guides/README.md":   "# Guide\n\nMilvus is a vector database.",
+		"guides/notes.txt":   "Plain text notes.",
+		"main.go":            "package main\n\nfunc main() {}\n",
+		"image.png":          "not text",
+		".git/config":        "[core]",
+		"drafts/wip.md":      "# Draft\n\nNot ready.",
+		"guides/invalid.txt": "\xff\xfe",
+	})
+	store := NewMemoryStore(NewHashingEmbedder(64))
+	engine := NewRAGEngine(&dummyOpenAI{}, store)
+
+	report, err := IngestDirectory(context.Background(), engine, dir, DirectoryOptions{
+		Include:   []string{"*.md", "*.txt"},
+		Exclude:   []string{"drafts/*"},
+		Workers:   2,
+		ChunkSize: 1000,
+	})
+	if err != nil {
+		t.Fatalf("IngestDirectory returned error: %v", err)
+	}
+	if report.Files != 3 || report.Skipped != 3 || len(report.Errors) != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if report.Errors[0].Path != "guides/invalid.txt" {
+		t.Fatalf("expected the invalid file to fail, got %+v", report.Errors)
+	}
+	if report.Chunks.Inserted != 3 {
+		t.Fatalf("expected 3 chunks, got %s", report.Chunks)
+	}
+
+	docs := store.SearchSimilar(context.Background(), "vector database", 1, nil)
+	if len(docs) != 1 || docs[0].Source != "guides/README.md" {
+		t.Fatalf("expected the guide's relative path as source, got %+v", docs)
+	}
+}
+
+func TestLoadFileRejectsUnsupportedTypes(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{"video.mp4": "x"})
+	if _, err := LoadFile(filepath.Join(dir, "video.mp4")); !errors.Is(err, ErrUnsupportedFile) {
+		t.Fatalf("expected ErrUnsupportedFile, got %v", err)
+	}
+}
+
+func TestSelectedMatchesNameOrRelativePath(t *testing.T) {
+	cases := []struct {
+		rel              string
+		include, exclude []string
+		want             bool
+	}{
+		{"docs/a.md", nil, nil, true},
+		{"docs/a.md", []string{"*.md"}, nil, true},
+		{"docs/a.md", []string{"docs/*"}, nil, true},
+		{"docs/a.md", []string{"*.txt"}, nil, false},
+		{"docs/a.md", []string{"*.md"}, []string{"docs/*"}, false},
+	}
+	for _, c := range cases {
+		if got := selected(c.rel, c.include, c.exclude); got != c.want {
+			t.Errorf("selected(%q, %v, %v) = %t, want %t", c.rel, c.include, c.exclude, got, c.want)
+		}
+	}
+}
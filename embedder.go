@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Embedder turns text into dense vectors for storage and similarity search.
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	// Dimension is the length of the vectors Embed returns. Stores size
+	// their schema from it.
+	Dimension() int
+}
+
+type queryEmbeddingKey struct{}
+
+// withQueryEmbedding marks texts embedded under ctx as search queries rather
+// than documents. Cohere and Voyage embed the two differently.
+func withQueryEmbedding(ctx context.Context) context.Context {
+	return context.WithValue(ctx, queryEmbeddingKey{}, true)
+}
+
+func isQueryEmbedding(ctx context.Context) bool {
+	query, _ := ctx.Value(queryEmbeddingKey{}).(bool)
+	return query
+}
+
+// embedQuery embeds a search query with embedder, within the embed timeout
+// the engine set on ctx, if any (see StageTimeouts).
+func embedQuery(ctx context.Context, embedder Embedder, query string) ([]float32, error) {
+	timeout, _ := ctx.Value(embedTimeoutKey{}).(time.Duration)
+	ctx, cancel := withStageTimeout(withQueryEmbedding(ctx), stageEmbed, timeout)
+	defer cancel()
+	embeddings, err := embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, stageTimedOut(ctx, stageEmbed, err)
+	}
+	return embeddings[0], nil
+}
+
+type precomputedEmbeddingsKey struct{}
+
+// withPrecomputedEmbeddings makes stores insert embeddings, one per text,
+// instead of embedding the texts themselves, as `rag import` does to restore
+// exported chunks.
+func withPrecomputedEmbeddings(ctx context.Context, embeddings [][]float32) context.Context {
+	return context.WithValue(ctx, precomputedEmbeddingsKey{}, embeddings)
+}
+
+// embedDocuments embeds texts for storage with embedder, unless ctx carries
+// precomputed embeddings.
+func embedDocuments(ctx context.Context, embedder Embedder, texts []string) ([][]float32, error) {
+	embeddings, ok := ctx.Value(precomputedEmbeddingsKey{}).([][]float32)
+	if !ok {
+		return embedder.Embed(ctx, texts)
+	}
+	if len(embeddings) != len(texts) {
+		return nil, fmt.Errorf("expected %d precomputed embeddings, got %d", len(texts), len(embeddings))
+	}
+	return slices.Clone(embeddings), nil
+}
+
+// embeddingDimension returns override if set, else the known dimension of
+// model.
+func embeddingDimension(provider, model string, known map[string]int, override int) (int, error) {
+	if override > 0 {
+		return override, nil
+	}
+	if dimension, ok := known[model]; ok {
+		return dimension, nil
+	}
+	return 0, fmt.Errorf("unknown dimension for %s embedding model %q; set EMBEDDING_DIM", provider, model)
+}
+
+// openAIEmbeddingDimensions lists the default dimensions of OpenAI models.
+var openAIEmbeddingDimensions = map[string]int{
+	"text-embedding-ada-002": 1536,
+	"text-embedding-3-small": 1536,
+	"text-embedding-3-large": 3072,
+}
+
+// OpenAIEmbedder implements Embedder with OpenAI's embeddings API.
+type OpenAIEmbedder struct {
+	client    *openai.Client
+	model     string
+	dimension int
+	shorten   bool // request dimension explicitly (text-embedding-3 models)
+}
+
+// NewOpenAIEmbedder embeds with model. A dimension of 0 uses the model's
+// default; text-embedding-3 models can also return shortened vectors, such as
+// 512 or 768 dimensions, which ada-002 rejects.
+func NewOpenAIEmbedder(client *openai.Client, model string, dimension int) (*OpenAIEmbedder, error) {
+	size, err := embeddingDimension("OpenAI", model, openAIEmbeddingDimensions, dimension)
+	if err != nil {
+		return nil, err
+	}
+	if native, ok := openAIEmbeddingDimensions[model]; ok && size != native {
+		if !strings.HasPrefix(model, "text-embedding-3-") {
+			return nil, fmt.Errorf("%s cannot return %d-dimensional embeddings; use a text-embedding-3 model to shorten them", model, size)
+		}
+		if size > native {
+			return nil, fmt.Errorf("EMBEDDING_DIM %d exceeds the %d dimensions of %s", size, native, model)
+		}
+	}
+	return &OpenAIEmbedder{client: client, model: model, dimension: size, shorten: size != openAIEmbeddingDimensions[model]}, nil
+}
+
+func (o *OpenAIEmbedder) Dimension() int {
+	return o.dimension
+}
+
+func (o *OpenAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	req := openai.EmbeddingRequestStrings{
+		Input: texts,
+		Model: openai.EmbeddingModel(o.model),
+	}
+	if o.shorten {
+		req.Dimensions = o.dimension
+	}
+	resp, err := o.client.CreateEmbeddings(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	recordTokenUsage(ctx, resp.Usage.PromptTokens, 0)
+	if len(resp.Data) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings from OpenAI, got %d", len(texts), len(resp.Data))
+	}
+
+	embeddings := make([][]float32, len(texts))
+	for _, item := range resp.Data {
+		if o.shorten && len(item.Embedding) != o.dimension {
+			return nil, fmt.Errorf("requested %d-dimensional embeddings from OpenAI, got %d", o.dimension, len(item.Embedding))
+		}
+		embeddings[item.Index] = item.Embedding
+	}
+	return embeddings, nil
+}
+
+// HashingEmbedder produces bag-of-words vectors by hashing each token into
+// one of dimension buckets. It needs no model or network access, so demo mode
+// and tests get lexical similarity ranking, and it stands in when the selected
+// provider has no embeddings API.
+type HashingEmbedder struct {
+	dimension int
+}
+
+// NewHashingEmbedder creates an embedder producing vectors of the given size.
+func NewHashingEmbedder(dimension int) *HashingEmbedder {
+	return &HashingEmbedder{dimension: dimension}
+}
+
+func (h *HashingEmbedder) Dimension() int {
+	return h.dimension
+}
+
+func (h *HashingEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	embeddings := make([][]float32, len(texts))
+	for i, text := range texts {
+		embedding := make([]float32, h.dimension)
+		for _, token := range tokenize(text) {
+			hash := fnv.New32a()
+			hash.Write([]byte(token))
+			embedding[hash.Sum32()%uint32(h.dimension)]++
+		}
+		embeddings[i] = embedding
+	}
+	return embeddings, nil
+}
+
+// normalized returns v scaled to unit length, or v itself if it is all zeros.
+func normalized(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return v
+	}
+	scale := float32(1 / math.Sqrt(sum))
+	unit := make([]float32, len(v))
+	for i, x := range v {
+		unit[i] = x * scale
+	}
+	return unit
+}
+
+// embedInBatches calls embed for consecutive batches of at most size texts
+// and concatenates the results.
+func embedInBatches(texts []string, size int, embed func(batch []string) ([][]float32, error)) ([][]float32, error) {
+	embeddings := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += size {
+		batch, err := embed(texts[start:min(start+size, len(texts))])
+		if err != nil {
+			return nil, err
+		}
+		embeddings = append(embeddings, batch...)
+	}
+	return embeddings, nil
+}
+
+// postJSON sends payload to url with a bearer token and decodes the JSON
+// reply into out. provider names the API in errors.
+func postJSON(ctx context.Context, client *http.Client, provider, url, apiKey string, payload, out any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Message string `json:"message"`
+			Detail  string `json:"detail"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Message+apiErr.Detail != "" {
+			return fmt.Errorf("%s API error (status %d): %s", provider, resp.StatusCode, apiErr.Message+apiErr.Detail)
+		}
+		return fmt.Errorf("%s API error (status %d)", provider, resp.StatusCode)
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestEmbeddingDimension(t *testing.T) {
+	embedder, err := NewOpenAIEmbedder(nil, "text-embedding-3-large", 0)
+	if err != nil {
+		t.Fatalf("NewOpenAIEmbedder returned error: %v", err)
+	}
+	if embedder.Dimension() != 3072 || embedder.shorten {
+		t.Fatalf("expected the default 3072 dimensions, got %d (shorten %v)", embedder.Dimension(), embedder.shorten)
+	}
+	embedder, _ = NewOpenAIEmbedder(nil, "text-embedding-3-large", 256)
+	if embedder.Dimension() != 256 || !embedder.shorten {
+		t.Fatalf("expected shortened 256 dimensions, got %d (shorten %v)", embedder.Dimension(), embedder.shorten)
+	}
+	if _, err := NewOpenAIEmbedder(nil, "text-embedding-ada-002", 512); err == nil {
+		t.Fatal("expected ada-002 to reject shortened embeddings")
+	}
+	if _, err := NewOpenAIEmbedder(nil, "text-embedding-3-small", 2048); err == nil {
+		t.Fatal("expected a dimension above the model's to be rejected")
+	}
+	if _, err := NewVoyageEmbedder("key", "voyage-unknown", 0); err == nil || !strings.Contains(err.Error(), "EMBEDDING_DIM") {
+		t.Fatalf("expected an error asking for EMBEDDING_DIM, got %v", err)
+	}
+	if dim := NewOllamaClient("", "mxbai-embed-large:latest").Dimension(); dim != 1024 {
+		t.Fatalf("expected 1024 dimensions for mxbai-embed-large, got %d", dim)
+	}
+}
+
+func TestOpenAIEmbedderRequestsDimensions(t *testing.T) {
+	returned := 512
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.EmbeddingRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Dimensions != 512 || req.Model != "text-embedding-3-small" {
+			t.Errorf("unexpected embeddings request %+v", req)
+		}
+		data, _ := json.Marshal(make([]float32, returned))
+		w.Write([]byte(`{"data":[{"index":0,"embedding":` + string(data) + `}],"usage":{"prompt_tokens":1}}`))
+	}))
+	defer server.Close()
+
+	config := openai.DefaultConfig("key")
+	config.BaseURL = server.URL
+	embedder, err := NewOpenAIEmbedder(openai.NewClientWithConfig(config), "text-embedding-3-small", 512)
+	if err != nil {
+		t.Fatalf("NewOpenAIEmbedder returned error: %v", err)
+	}
+	if embeddings, err := embedder.Embed(context.Background(), []string{"a"}); err != nil || len(embeddings[0]) != 512 {
+		t.Fatalf("expected a 512-dimensional embedding, got %v", err)
+	}
+	returned = 1536
+	if _, err := embedder.Embed(context.Background(), []string{"a"}); err == nil {
+		t.Fatal("expected an embedding of the wrong size to be rejected")
+	}
+}
+
+func TestCohereEmbedderInputTypes(t *testing.T) {
+	var inputTypes []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/embed" || r.Header.Get("Authorization") != "Bearer key" {
+			t.Errorf("unexpected request %s with authorization %q", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		var req cohereEmbedRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decoding embed request: %v", err)
+		}
+		if req.OutputDimension != 0 {
+			t.Errorf("expected the default dimension, got output_dimension %d", req.OutputDimension)
+		}
+		inputTypes = append(inputTypes, req.InputType)
+		var vectors []string
+		for range req.Texts {
+			vectors = append(vectors, "[1,0]")
+		}
+		w.Write([]byte(`{"embeddings":{"float":[` + strings.Join(vectors, ",") + `]},"meta":{"billed_units":{"input_tokens":3}}}`))
+	}))
+	defer server.Close()
+
+	embedder, err := NewCohereEmbedder("key", "embed-english-v3.0", 0)
+	if err != nil {
+		t.Fatalf("NewCohereEmbedder returned error: %v", err)
+	}
+	embedder.baseURL = server.URL
+
+	texts := make([]string, cohereBatchSize+1)
+	embeddings, err := embedder.Embed(context.Background(), texts)
+	if err != nil {
+		t.Fatalf("Embed returned error: %v", err)
+	}
+	if len(embeddings) != len(texts) {
+		t.Fatalf("expected %d embeddings, got %d", len(texts), len(embeddings))
+	}
+	if _, err := embedder.Embed(withQueryEmbedding(context.Background()), []string{"query"}); err != nil {
+		t.Fatalf("Embed returned error: %v", err)
+	}
+	want := []string{"search_document", "search_document", "search_query"}
+	if strings.Join(inputTypes, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected input types %v, got %v", want, inputTypes)
+	}
+}
+
+func TestVoyageEmbedder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req voyageEmbedRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decoding embed request: %v", err)
+		}
+		if req.InputType != "query" || req.OutputDimension != 512 {
+			t.Errorf("unexpected input type %q and output dimension %d", req.InputType, req.OutputDimension)
+		}
+		// Results may arrive out of order; index maps them back.
+		w.Write([]byte(`{"data":[{"embedding":[2],"index":1},{"embedding":[1],"index":0}],"usage":{"total_tokens":4}}`))
+	}))
+	defer server.Close()
+
+	embedder, err := NewVoyageEmbedder("key", "voyage-3.5", 512)
+	if err != nil {
+		t.Fatalf("NewVoyageEmbedder returned error: %v", err)
+	}
+	embedder.baseURL = server.URL
+	embeddings, err := embedder.Embed(withQueryEmbedding(context.Background()), []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("Embed returned error: %v", err)
+	}
+	if embeddings[0][0] != 1 || embeddings[1][0] != 2 {
+		t.Fatalf("unexpected embeddings: %v", embeddings)
+	}
+}
+
+func TestPostJSONReportsAPIErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"detail":"invalid API key"}`))
+	}))
+	defer server.Close()
+
+	embedder, _ := NewVoyageEmbedder("bad", "voyage-3.5", 0)
+	embedder.baseURL = server.URL
+	_, err := embedder.Embed(context.Background(), []string{"a"})
+	if err == nil || err.Error() != "Voyage API error (status 401): invalid API key" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
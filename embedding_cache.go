@@ -0,0 +1,219 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// CacheStats reports how often cached embeddings were reused.
+type CacheStats struct {
+	Hits     int64 // served from memory
+	DiskHits int64 // served from the on-disk store
+	Misses   int64 // computed by the underlying embedder
+}
+
+// HitRate is the fraction of lookups served without calling the embedder.
+func (s CacheStats) HitRate() float64 {
+	total := s.Hits + s.DiskHits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits+s.DiskHits) / float64(total)
+}
+
+// CachedEmbedder wraps an Embedder with an in-memory LRU cache and an optional
+// on-disk store, both keyed by a hash of the namespace and text. The namespace
+// should identify the embedding model so vectors from different models never mix.
+type CachedEmbedder struct {
+	inner     Embedder
+	namespace string
+	capacity  int
+	dir       string
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // front is most recently used
+	stats   CacheStats
+}
+
+type cacheEntry struct {
+	key    string
+	vector []float32
+}
+
+// NewCachedEmbedder caches up to capacity vectors in memory. If dir is not
+// empty, vectors are also persisted there and reused across runs.
+func NewCachedEmbedder(inner Embedder, namespace string, capacity int, dir string) (*CachedEmbedder, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("creating embedding cache directory: %w", err)
+		}
+	}
+	return &CachedEmbedder{
+		inner:     inner,
+		namespace: namespace,
+		capacity:  capacity,
+		dir:       dir,
+		entries:   make(map[string]*list.Element),
+		order:     list.New(),
+	}, nil
+}
+
+// Embed returns cached vectors where available and embeds only the misses,
+// in a single call to the underlying embedder.
+func (c *CachedEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	embeddings := make([][]float32, len(texts))
+	keys := make([]string, len(texts))
+	var missTexts []string
+	var missIdx []int
+
+	for i, text := range texts {
+		keys[i] = c.key(ctx, text)
+		if vector, ok := c.lookup(keys[i]); ok {
+			embeddings[i] = vector
+			continue
+		}
+		missTexts = append(missTexts, text)
+		missIdx = append(missIdx, i)
+	}
+
+	if len(missTexts) == 0 {
+		return embeddings, nil
+	}
+
+	computed, err := c.inner.Embed(ctx, missTexts)
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range missIdx {
+		embeddings[i] = computed[j]
+		c.store(keys[i], computed[j])
+	}
+	return embeddings, nil
+}
+
+func (c *CachedEmbedder) Dimension() int {
+	return c.inner.Dimension()
+}
+
+// Stats returns a snapshot of the cache counters.
+func (c *CachedEmbedder) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// key hashes the namespace and text. Query embeddings get keys of their own,
+// since some providers embed queries and documents differently.
+func (c *CachedEmbedder) key(ctx context.Context, text string) string {
+	namespace := c.namespace
+	if isQueryEmbedding(ctx) {
+		namespace += ":query"
+	}
+	sum := sha256.Sum256([]byte(namespace + "\x00" + text))
+	return hex.EncodeToString(sum[:])
+}
+
+// lookup checks memory, then disk, promoting disk hits into memory.
+func (c *CachedEmbedder) lookup(key string) ([]float32, bool) {
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+		c.stats.Hits++
+		c.mu.Unlock()
+		return elem.Value.(*cacheEntry).vector, true
+	}
+	c.mu.Unlock()
+
+	if c.dir != "" {
+		if vector, err := c.readDisk(key); err == nil {
+			c.mu.Lock()
+			c.stats.DiskHits++
+			c.remember(key, vector)
+			c.mu.Unlock()
+			return vector, true
+		}
+	}
+
+	c.mu.Lock()
+	c.stats.Misses++
+	c.mu.Unlock()
+	return nil, false
+}
+
+func (c *CachedEmbedder) store(key string, vector []float32) {
+	c.mu.Lock()
+	c.remember(key, vector)
+	c.mu.Unlock()
+
+	if c.dir != "" {
+		if err := c.writeDisk(key, vector); err != nil {
+			slog.Warn("Could not persist cached embedding", "error", err)
+		}
+	}
+}
+
+// remember adds a vector to the LRU, evicting the least recently used entry
+// when full. The caller must hold c.mu.
+func (c *CachedEmbedder) remember(key string, vector []float32) {
+	if c.capacity <= 0 {
+		return
+	}
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, vector: vector})
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// diskPath shards entries into subdirectories by hash prefix.
+func (c *CachedEmbedder) diskPath(key string) string {
+	return filepath.Join(c.dir, key[:2], key+".bin")
+}
+
+func (c *CachedEmbedder) readDisk(key string) ([]float32, error) {
+	data, err := os.ReadFile(c.diskPath(key))
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%4 != 0 {
+		return nil, fmt.Errorf("corrupt cache entry %s", key)
+	}
+	vector := make([]float32, len(data)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:]))
+	}
+	return vector, nil
+}
+
+// writeDisk stores the vector as little-endian float32s, writing to a
+// temporary file first so readers never see a partial entry.
+func (c *CachedEmbedder) writeDisk(key string, vector []float32) error {
+	path := c.diskPath(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data := make([]byte, len(vector)*4)
+	for i, v := range vector {
+		binary.LittleEndian.PutUint32(data[i*4:], math.Float32bits(v))
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
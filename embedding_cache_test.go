@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// countingEmbedder returns one-dimensional vectors equal to the text length
+// and records every text it was asked to embed.
+type countingEmbedder struct {
+	embedded []string
+}
+
+func (c *countingEmbedder) Dimension() int {
+	return 1
+}
+
+func (c *countingEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	c.embedded = append(c.embedded, texts...)
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = []float32{float32(len(text))}
+	}
+	return vectors, nil
+}
+
+func TestCachedEmbedderReusesVectors(t *testing.T) {
+	inner := &countingEmbedder{}
+	cache, err := NewCachedEmbedder(inner, "test", 10, "")
+	if err != nil {
+		t.Fatalf("NewCachedEmbedder returned error: %v", err)
+	}
+
+	cache.Embed(context.Background(), []string{"a", "bb"})
+	vectors, err := cache.Embed(context.Background(), []string{"bb", "ccc"})
+	if err != nil {
+		t.Fatalf("Embed returned error: %v", err)
+	}
+	if vectors[0][0] != 2 || vectors[1][0] != 3 {
+		t.Fatalf("unexpected vectors %v", vectors)
+	}
+	if len(inner.embedded) != 3 {
+		t.Fatalf("expected 3 texts to reach the embedder, got %v", inner.embedded)
+	}
+	stats := cache.Stats()
+	if stats.Hits != 1 || stats.Misses != 3 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestCachedEmbedderEvictsLeastRecentlyUsed(t *testing.T) {
+	inner := &countingEmbedder{}
+	cache, _ := NewCachedEmbedder(inner, "test", 2, "")
+
+	cache.Embed(context.Background(), []string{"a", "bb"})
+	cache.Embed(context.Background(), []string{"a"})   // a becomes most recently used
+	cache.Embed(context.Background(), []string{"ccc"}) // evicts bb
+	cache.Embed(context.Background(), []string{"bb"})
+
+	if got := inner.embedded[len(inner.embedded)-1]; got != "bb" || len(inner.embedded) != 4 {
+		t.Fatalf("expected bb to be re-embedded after eviction, embedded %v", inner.embedded)
+	}
+}
+
+func TestCachedEmbedderPersistsToDisk(t *testing.T) {
+	dir := t.TempDir()
+	first, _ := NewCachedEmbedder(&countingEmbedder{}, "test", 10, dir)
+	first.Embed(context.Background(), []string{"hello"})
+
+	inner := &countingEmbedder{}
+	second, _ := NewCachedEmbedder(inner, "test", 10, dir)
+	vectors, err := second.Embed(context.Background(), []string{"hello"})
+	if err != nil {
+		t.Fatalf("Embed returned error: %v", err)
+	}
+	if len(inner.embedded) != 0 || vectors[0][0] != 5 {
+		t.Fatalf("expected disk hit, embedder saw %v and returned %v", inner.embedded, vectors)
+	}
+	if second.Stats().DiskHits != 1 {
+		t.Fatalf("expected 1 disk hit, got %+v", second.Stats())
+	}
+
+	other, _ := NewCachedEmbedder(inner, "other-model", 10, dir)
+	other.Embed(context.Background(), []string{"hello"})
+	if len(inner.embedded) != 1 {
+		t.Fatalf("a different namespace must not reuse cached vectors")
+	}
+}
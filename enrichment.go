@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// enrichmentField is the metadata key marking a stored representation of a
+// chunk, such as a question it answers, with the kind of representation
+// ("summary" or "question"). Retrieval replaces a matched representation
+// with its chunk, keeping the field to show how the chunk was found.
+const enrichmentField = "enrichment"
+
+// chunkTextField holds the text of the chunk a representation stands for.
+const chunkTextField = "chunk_text"
+
+// enrichmentOverfetch widens the search when chunks are stored with
+// representations, since a chunk and several of its representations often
+// match the same query.
+const enrichmentOverfetch = 3
+
+// maxEnrichmentQuestions caps the questions written per chunk.
+const maxEnrichmentQuestions = 5
+
+// Enrichment is what a ChunkEnricher writes about a chunk.
+type Enrichment struct {
+	Title     string
+	Summary   string
+	Questions []string
+}
+
+// ChunkEnricher writes a short title, a summary, and the questions a chunk
+// answers, one LLM call per chunk. Stored as representations of the chunk,
+// they match question-style queries that the chunk's own wording does not.
+type ChunkEnricher struct {
+	llm   LLMClient
+	model string
+	// Questions is how many questions to write per chunk; 0 means 3.
+	Questions int
+}
+
+// NewChunkEnricher returns an enricher that writes with model.
+func NewChunkEnricher(client LLMClient, model string) *ChunkEnricher {
+	return &ChunkEnricher{llm: client, model: model}
+}
+
+// WithChunkEnrichment stores, next to every ingested chunk, a summary (its
+// title and summary) and its questions written by enricher, each embedded
+// on its own and carrying the chunk's metadata. A search that matches one
+// of them returns the chunk instead, each chunk once. Chunks whose
+// enrichment fails are stored without it.
+func WithChunkEnrichment(enricher *ChunkEnricher) EngineOption {
+	return func(r *RAGEngine) {
+		r.enricher = enricher
+	}
+}
+
+// Enrich asks the LLM for the title, summary, and questions of the chunk
+// text of source.
+func (e *ChunkEnricher) Enrich(ctx context.Context, source, text string) (Enrichment, error) {
+	questions := e.Questions
+	if questions <= 0 {
+		questions = 3
+	}
+	prompt := fmt.Sprintf("Below is a passage of %s. Reply with three parts, each on its own lines:\n"+
+		"Title: a short title for the passage\n"+
+		"Summary: a one- or two-sentence summary of what it says\n"+
+		"Q: a question the passage answers, as a user would ask it (write %d, each on a line starting with Q:)\n\n"+
+		"Write in the passage's language. Name the subject in every question instead of referring to \"the passage\".\n\nPassage:\n%s",
+		source, questions, text)
+	messages := []Message{
+		{Role: "system", Content: "You describe document passages for a search index."},
+		{Role: "user", Content: prompt},
+	}
+	response, err := e.llm.ChatCompletion(ctx, e.model, messages)
+	if err != nil {
+		return Enrichment{}, err
+	}
+	var enrichment Enrichment
+	for _, line := range strings.Split(response, "\n") {
+		line = strings.TrimLeft(strings.TrimSpace(line), "*-• ")
+		label, value, ok := strings.Cut(line, ":")
+		value = strings.Trim(value, " *\"")
+		if !ok || value == "" {
+			continue
+		}
+		switch strings.ToLower(strings.Trim(label, "* ")) {
+		case "title":
+			enrichment.Title = value
+		case "summary":
+			enrichment.Summary = value
+		case "q", "question":
+			if len(enrichment.Questions) < questions {
+				enrichment.Questions = append(enrichment.Questions, value)
+			}
+		}
+	}
+	if enrichment.Summary == "" && len(enrichment.Questions) == 0 {
+		return enrichment, fmt.Errorf("unexpected enrichment reply %q", response)
+	}
+	return enrichment, nil
+}
+
+// enrichChunks returns the representations of the chunks to store next to
+// them: for every chunk, its title and summary, and each of its questions.
+func (r *RAGEngine) enrichChunks(ctx context.Context, texts, sources []string, metadata []map[string]any) (repTexts, repSources []string, repMetadata []map[string]any) {
+	ctx, span := tracer.Start(ctx, "rag.enrich", trace.WithAttributes(attribute.Int("rag.chunks", len(texts))))
+	defer span.End()
+
+	add := func(i int, kind, text string) {
+		meta := maps.Clone(metadata[i])
+		if meta == nil {
+			meta = make(map[string]any)
+		}
+		meta[enrichmentField] = kind
+		meta[chunkTextField] = texts[i]
+		repTexts = append(repTexts, text)
+		repSources = append(repSources, sources[i])
+		repMetadata = append(repMetadata, meta)
+	}
+	for i, text := range texts {
+		enrichment, err := r.enricher.Enrich(ctx, sources[i], text)
+		if err != nil {
+			errorsTotal.WithLabelValues("enrich").Inc()
+			slog.WarnContext(ctx, "Enriching chunk failed", "source", sources[i], "error", err)
+			continue
+		}
+		if summary := strings.TrimSpace(enrichment.Title + "\n" + enrichment.Summary); summary != "" {
+			add(i, "summary", summary)
+		}
+		for _, question := range enrichment.Questions {
+			add(i, "question", question)
+		}
+	}
+	span.SetAttributes(attribute.Int("rag.representations", len(repTexts)))
+	return repTexts, repSources, repMetadata
+}
+
+// resolveEnrichments replaces every matched representation in docs with
+// its chunk and keeps each chunk once, with its highest similarity.
+func resolveEnrichments(docs []Document) []Document {
+	found := false
+	resolved := make([]Document, len(docs))
+	for i, doc := range docs {
+		if text, ok := doc.Metadata[chunkTextField].(string); ok {
+			doc.Text = text
+			doc.Metadata = maps.Clone(doc.Metadata)
+			delete(doc.Metadata, chunkTextField)
+			found = true
+		}
+		resolved[i] = doc
+	}
+	if !found {
+		return docs
+	}
+	return mergeResults(resolved)
+}
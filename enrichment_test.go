@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+const enrichmentReply = `**Title:** Travel expense approval
+Summary: Managers approve travel costs within a week.
+Q: Who signs off on my trip costs?
+- Q: How long does travel approval take?
+Q: Can finance approve travel?
+Q: Is this one too many?`
+
+func TestChunkEnricherParsesTheReply(t *testing.T) {
+	enricher := NewChunkEnricher(&scriptedOpenAI{reply: enrichmentReply}, "gpt-mini")
+	enrichment, err := enricher.Enrich(context.Background(), "travel.md", "Travel expenses are approved by your manager within five working days.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enrichment.Title != "Travel expense approval" || enrichment.Summary != "Managers approve travel costs within a week." {
+		t.Fatalf("unexpected title and summary %+v", enrichment)
+	}
+	if !slices.Equal(enrichment.Questions, []string{"Who signs off on my trip costs?", "How long does travel approval take?", "Can finance approve travel?"}) {
+		t.Fatalf("expected three questions, got %q", enrichment.Questions)
+	}
+	if _, err := NewChunkEnricher(&scriptedOpenAI{reply: "I can't help with that."}, "gpt-mini").Enrich(context.Background(), "travel.md", "text"); err == nil {
+		t.Fatal("expected an error for a reply without a summary or questions")
+	}
+}
+
+func TestRetrieveMatchesEnrichedQuestions(t *testing.T) {
+	store := NewMemoryStore(NewHashingEmbedder(256))
+	engine := NewRAGEngine(&scriptedOpenAI{reply: enrichmentReply}, store, WithChunkEnrichment(NewChunkEnricher(&scriptedOpenAI{reply: enrichmentReply}, "gpt-mini")))
+	pages := []Page{{Text: "Travel expenses are approved by your manager within five working days.", Source: "travel.md", Metadata: map[string]any{"team": "finance"}}}
+	report, ok := ingestPages(context.Background(), engine, pages, 1000, 0)
+	if !ok || report != (IngestReport{Inserted: 1}) {
+		t.Fatalf("expected the chunk counted once, got %s (ok=%t)", report, ok)
+	}
+
+	docs := engine.Retrieve(context.Background(), "Who signs off on my trip costs?", 5, WithFilter(Filter{Eq("team", "finance")}))
+	if len(docs) != 1 || docs[0].Text != pages[0].Text || docs[0].Metadata[enrichmentField] != "question" || docs[0].Metadata[chunkTextField] != nil {
+		t.Fatalf("expected the chunk once, found through its question, got %+v", docs)
+	}
+
+	// Changing the page removes the old chunk's representations with it.
+	pages[0].Text = "Travel expenses are approved by finance."
+	engine.enricher = NewChunkEnricher(&scriptedOpenAI{err: errors.New("timeout")}, "gpt-mini")
+	if report, ok := ingestPages(context.Background(), engine, pages, 1000, 0); !ok || report != (IngestReport{Updated: 1, Removed: 1}) {
+		t.Fatalf("expected the chunk stored without enrichment, got %s (ok=%t)", report, ok)
+	}
+	if stored := store.SearchSimilar(context.Background(), "travel", 10, nil); len(stored) != 1 {
+		t.Fatalf("expected only the new chunk stored, got %+v", stored)
+	}
+}
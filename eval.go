@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// EvalCase is one line of an evaluation dataset: a question and the sources
+// that a good answer should draw on.
+type EvalCase struct {
+	Question        string   `json:"question"`
+	ExpectedSources []string `json:"expected_sources"`
+}
+
+// EvalResult records how the engine handled one EvalCase.
+type EvalResult struct {
+	Case             EvalCase
+	Answer           Answer
+	RetrievedSources []string
+	Retrieved        bool // an expected source was among the retrieved documents
+	Cited            bool // an expected source was cited in the answer
+	// Scores are the judge's grades; nil when no judge was given, the answer
+	// had no context, or judging failed (see JudgeError).
+	Scores     *AnswerScores
+	JudgeError error
+}
+
+// EvalSummary aggregates results over a dataset.
+type EvalSummary struct {
+	Questions     int
+	RetrievalHits int
+	CitationHits  int
+	NoContext     int
+	// Judged counts the answers the judge scored; Faithfulness and Relevance
+	// are their mean scores.
+	Judged       int
+	Faithfulness float64
+	Relevance    float64
+}
+
+// LoadEvalDataset reads a JSONL file of EvalCase records. Blank lines are
+// skipped.
+func LoadEvalDataset(path string) ([]EvalCase, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var cases []EvalCase
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var c EvalCase
+		if err := json.Unmarshal([]byte(text), &c); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if c.Question == "" {
+			return nil, fmt.Errorf("%s:%d: question is required", path, line)
+		}
+		cases = append(cases, c)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return cases, nil
+}
+
+// Evaluate runs every case through retrieval and generation and checks the
+// retrieved and cited sources against the expected ones. With a judge, each
+// answer that had context is also scored for faithfulness and relevance; a
+// failed judgment is recorded on the result rather than stopping the run.
+func (r *RAGEngine) Evaluate(ctx context.Context, cases []EvalCase, limit int, model string, judge *AnswerJudge, opts ...RetrieveOption) ([]EvalResult, EvalSummary, error) {
+	results := make([]EvalResult, 0, len(cases))
+	summary := EvalSummary{Questions: len(cases)}
+	for _, c := range cases {
+		caseCtx := r.deterministic(withQueryID(ctx, newQueryID()))
+		docs := r.Retrieve(caseCtx, c.Question, limit, opts...)
+		answer, err := r.GenerateResponse(caseCtx, c.Question, docs, model)
+		if err != nil {
+			return results, summary, fmt.Errorf("answering %q: %w", c.Question, err)
+		}
+
+		expected := make(map[string]bool, len(c.ExpectedSources))
+		for _, source := range c.ExpectedSources {
+			expected[source] = true
+		}
+		result := EvalResult{Case: c, Answer: answer}
+		for _, doc := range docs {
+			result.RetrievedSources = append(result.RetrievedSources, doc.Source)
+			result.Retrieved = result.Retrieved || expected[doc.Source]
+		}
+		for _, citation := range answer.Citations {
+			result.Cited = result.Cited || expected[citation.Source]
+		}
+
+		if judge != nil && !answer.NoContext {
+			// Judge against the documents the answer was generated from.
+			var used []Document
+			for _, doc := range docs {
+				if doc.Similarity >= r.minSimilarity {
+					used = append(used, doc)
+				}
+			}
+			scores, err := judge.Judge(caseCtx, c.Question, answer.Text, used)
+			if err != nil {
+				if ctx.Err() != nil {
+					return results, summary, ctx.Err()
+				}
+				slog.WarnContext(caseCtx, "Judging answer failed", "question", c.Question, "error", err)
+				result.JudgeError = err
+			} else {
+				result.Scores = &scores
+				summary.Judged++
+				summary.Faithfulness += scores.Faithfulness
+				summary.Relevance += scores.Relevance
+			}
+		}
+
+		if result.Retrieved {
+			summary.RetrievalHits++
+		}
+		if result.Cited {
+			summary.CitationHits++
+		}
+		if answer.NoContext {
+			summary.NoContext++
+		}
+		results = append(results, result)
+	}
+	if summary.Judged > 0 {
+		summary.Faithfulness /= float64(summary.Judged)
+		summary.Relevance /= float64(summary.Judged)
+	}
+	return results, summary, nil
+}
+
+type evalResultJSON struct {
+	Question         string        `json:"question"`
+	ExpectedSources  []string      `json:"expected_sources"`
+	Answer           string        `json:"answer"`
+	RetrievedSources []string      `json:"retrieved_sources"`
+	Retrieved        bool          `json:"retrieved"`
+	Cited            bool          `json:"cited"`
+	NoContext        bool          `json:"no_context"`
+	Fingerprint      string        `json:"fingerprint,omitempty"` // set in deterministic mode
+	Scores           *AnswerScores `json:"scores,omitempty"`
+	JudgeError       string        `json:"judge_error,omitempty"`
+}
+
+type evalSummaryJSON struct {
+	Questions     int      `json:"questions"`
+	RetrievalHits int      `json:"retrieval_hits"`
+	CitationHits  int      `json:"citation_hits"`
+	NoContext     int      `json:"no_context"`
+	Judged        int      `json:"judged"`
+	Faithfulness  *float64 `json:"faithfulness,omitempty"`
+	Relevance     *float64 `json:"relevance,omitempty"`
+}
+
+func newEvalSummaryJSON(summary EvalSummary) evalSummaryJSON {
+	out := evalSummaryJSON{
+		Questions:     summary.Questions,
+		RetrievalHits: summary.RetrievalHits,
+		CitationHits:  summary.CitationHits,
+		NoContext:     summary.NoContext,
+		Judged:        summary.Judged,
+	}
+	if summary.Judged > 0 {
+		out.Faithfulness = &summary.Faithfulness
+		out.Relevance = &summary.Relevance
+	}
+	return out
+}
+
+type evalReportJSON struct {
+	Results []evalResultJSON `json:"results"`
+	Summary evalSummaryJSON  `json:"summary"`
+}
+
+// evalFinishedEvent is the data of an eval.finished webhook.
+type evalFinishedEvent struct {
+	Dataset string          `json:"dataset"`
+	Model   string          `json:"model"`
+	Summary evalSummaryJSON `json:"summary"`
+}
+
+// newEvalResultsJSON converts evaluation results for a JSON report.
+func newEvalResultsJSON(results []EvalResult) []evalResultJSON {
+	out := make([]evalResultJSON, 0, len(results))
+	for _, result := range results {
+		entry := evalResultJSON{
+			Question:         result.Case.Question,
+			ExpectedSources:  result.Case.ExpectedSources,
+			Answer:           result.Answer.Text,
+			RetrievedSources: result.RetrievedSources,
+			Retrieved:        result.Retrieved,
+			Cited:            result.Cited,
+			NoContext:        result.Answer.NoContext,
+			Fingerprint:      result.Answer.Fingerprint,
+			Scores:           result.Scores,
+		}
+		if result.JudgeError != nil {
+			entry.JudgeError = result.JudgeError.Error()
+		}
+		out = append(out, entry)
+	}
+	return out
+}
+
+// writeEvalReport saves the results and summary of an evaluation as JSON.
+func writeEvalReport(path string, results []EvalResult, summary EvalSummary) error {
+	report := evalReportJSON{Results: newEvalResultsJSON(results), Summary: newEvalSummaryJSON(summary)}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// AnswerScores are an LLM judge's grades for one generated answer, each from
+// 0 (worst) to 1 (best).
+type AnswerScores struct {
+	// Faithfulness is the share of the answer's claims that the retrieved
+	// context supports.
+	Faithfulness float64 `json:"faithfulness"`
+	// Relevance is how directly and completely the answer addresses the
+	// question.
+	Relevance float64 `json:"relevance"`
+	// UnsupportedClaims lists the claims the context does not back up.
+	UnsupportedClaims []string `json:"unsupported_claims,omitempty"`
+	Reason            string   `json:"reason"`
+}
+
+var judgeSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"faithfulness":       map[string]any{"type": "number"},
+		"relevance":          map[string]any{"type": "number"},
+		"unsupported_claims": map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+		"reason":             map[string]any{"type": "string"},
+	},
+	"required": []any{"faithfulness", "relevance", "reason"},
+}
+
+// AnswerJudge grades generated answers with a chat model. It can use a
+// different, usually stronger, model than the one that wrote the answers.
+type AnswerJudge struct {
+	client LLMClient
+	model  string
+}
+
+// NewAnswerJudge creates a judge that asks model through client.
+func NewAnswerJudge(client LLMClient, model string) *AnswerJudge {
+	return &AnswerJudge{client: client, model: model}
+}
+
+// Judge scores answer for faithfulness to the documents it was generated from
+// and for relevance to the question.
+func (j *AnswerJudge) Judge(ctx context.Context, question, answer string, docs []Document) (AnswerScores, error) {
+	prompt := "Grade an answer produced by a question-answering system from its retrieved context.\n" +
+		"- faithfulness: the fraction of the answer's factual claims that the context supports (1 when every claim is supported, 0 when none is). Do not use outside knowledge.\n" +
+		"- relevance: how directly and completely the answer addresses the question (1 fully, 0 not at all), regardless of correctness.\n" +
+		"List any claims the context does not support in unsupported_claims, and explain the grades in one or two sentences in reason.\n" +
+		"Reply with a JSON object with the keys faithfulness, relevance, unsupported_claims, and reason, and nothing else.\n\n" +
+		"Question: " + question + "\n\nContext:\n" + formatContext(docs) + "\n\nAnswer:\n" + answer
+
+	messages := []Message{
+		{Role: "system", Content: "You are a strict, impartial grader of answers for a retrieval-augmented generation system."},
+		{Role: "user", Content: prompt},
+	}
+	var reply string
+	var err error
+	if client, ok := j.client.(JSONChatClient); ok {
+		reply, err = client.ChatCompletionJSON(ctx, j.model, messages, judgeSchema)
+	} else {
+		reply, err = j.client.ChatCompletion(ctx, j.model, messages)
+	}
+	if err != nil {
+		return AnswerScores{}, err
+	}
+
+	data, err := parseStructuredAnswer(reply, judgeSchema)
+	if err != nil {
+		return AnswerScores{}, fmt.Errorf("%w: %v", ErrMalformedStructuredAnswer, err)
+	}
+	var scores AnswerScores
+	if err := json.Unmarshal(data, &scores); err != nil {
+		return AnswerScores{}, fmt.Errorf("%w: %v", ErrMalformedStructuredAnswer, err)
+	}
+	scores.Faithfulness = min(max(scores.Faithfulness, 0), 1)
+	scores.Relevance = min(max(scores.Relevance, 0), 1)
+	scores.Reason = strings.TrimSpace(scores.Reason)
+	return scores, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadEvalDataset(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "qa.jsonl")
+	data := `{"question":"What is Go?","expected_sources":["Go Docs"]}
+
+{"question":"What is Milvus?","expected_sources":["Milvus Docs"]}
+`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cases, err := LoadEvalDataset(path)
+	if err != nil {
+		t.Fatalf("LoadEvalDataset: %v", err)
+	}
+	if len(cases) != 2 || cases[1].ExpectedSources[0] != "Milvus Docs" {
+		t.Fatalf("unexpected cases %+v", cases)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"expected_sources":["x"]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadEvalDataset(path); err == nil {
+		t.Fatalf("expected an error for a record without a question")
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	store := NewMemoryStore(NewHashingEmbedder(256))
+	store.InsertDocuments(context.Background(),
+		[]string{"Go is a programming language", "Milvus is a vector database"},
+		[]string{"Go Docs", "Milvus Docs"},
+		nil,
+	)
+	engine := NewRAGEngine(&scriptedOpenAI{reply: "See [1]."}, store)
+
+	cases := []EvalCase{
+		{Question: "What is the Go programming language?", ExpectedSources: []string{"Go Docs"}},
+		{Question: "What is a vector database?", ExpectedSources: []string{"Docker Docs"}},
+	}
+	results, summary, err := engine.Evaluate(context.Background(), cases, 1, "gpt-test", nil)
+	if err != nil {
+		t.Fatalf("Evaluate: %v", err)
+	}
+	if !results[0].Retrieved || !results[0].Cited {
+		t.Fatalf("expected first case to hit, got %+v", results[0])
+	}
+	if results[1].Retrieved || results[1].Cited {
+		t.Fatalf("expected second case to miss, got %+v", results[1])
+	}
+	if summary.Questions != 2 || summary.RetrievalHits != 1 || summary.CitationHits != 1 {
+		t.Fatalf("unexpected summary %+v", summary)
+	}
+}
+
+func TestEvaluateWithJudge(t *testing.T) {
+	store := NewMemoryStore(NewHashingEmbedder(256))
+	store.InsertDocuments(context.Background(),
+		[]string{"Go is a programming language"},
+		[]string{"Go Docs"},
+		nil,
+	)
+	engine := NewRAGEngine(&scriptedOpenAI{reply: "Go is a language [1]."}, store)
+	judge := NewAnswerJudge(&scriptedOpenAI{reply: "```json\n" +
+		`{"faithfulness": 0.5, "relevance": 1.4, "unsupported_claims": ["made by Google"], "reason": " Partly supported. "}` +
+		"\n```"}, "judge-test")
+
+	cases := []EvalCase{
+		{Question: "What is Go?", ExpectedSources: []string{"Go Docs"}},
+		{Question: "Is Go a programming language?", ExpectedSources: []string{"Go Docs"}},
+	}
+	results, summary, err := engine.Evaluate(context.Background(), cases, 1, "gpt-test", judge)
+	if err != nil {
+		t.Fatalf("Evaluate: %v", err)
+	}
+	scores := results[0].Scores
+	if scores == nil || scores.Faithfulness != 0.5 || scores.Relevance != 1 || scores.Reason != "Partly supported." || len(scores.UnsupportedClaims) != 1 {
+		t.Fatalf("unexpected scores %+v", scores)
+	}
+	if summary.Judged != 2 || summary.Faithfulness != 0.5 || summary.Relevance != 1 {
+		t.Fatalf("unexpected summary %+v", summary)
+	}
+
+	path := filepath.Join(t.TempDir(), "results.json")
+	if err := writeEvalReport(path, results, summary); err != nil {
+		t.Fatalf("writeEvalReport: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), `"faithfulness": 0.5`) || !strings.Contains(string(data), `"judged": 2`) {
+		t.Fatalf("unexpected report %s", data)
+	}
+
+	// A judge reply that is not a grade is recorded without failing the run.
+	judge = NewAnswerJudge(&scriptedOpenAI{reply: "Looks good to me."}, "judge-test")
+	results, summary, err = engine.Evaluate(context.Background(), cases[:1], 1, "gpt-test", judge)
+	if err != nil {
+		t.Fatalf("Evaluate: %v", err)
+	}
+	if results[0].Scores != nil || !errors.Is(results[0].JudgeError, ErrMalformedStructuredAnswer) || summary.Judged != 0 {
+		t.Fatalf("expected a judge error, got %+v, summary %+v", results[0], summary)
+	}
+}
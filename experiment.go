@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Pipeline is a named configuration of the RAG pipeline, one arm of an
+// experiment. Its zero fields keep the engine's settings.
+type Pipeline struct {
+	Name string `json:"name"`
+	// Weight is the pipeline's share of the traffic, relative to the other
+	// pipelines of the experiment; 0 means 1.
+	Weight float64 `json:"weight,omitempty"`
+
+	// Collection is the collection the pipeline searches, ingested with the
+	// chunk size and overlap under test, and EmbeddingModel the model its
+	// chunks were embedded with; a different model needs a collection of
+	// its own.
+	Collection     string `json:"collection,omitempty"`
+	EmbeddingModel string `json:"embedding_model,omitempty"`
+
+	Limit         int      `json:"limit,omitempty"`        // documents to retrieve
+	Reranker      string   `json:"reranker,omitempty"`     // off, llm, window, or local
+	RerankModel   string   `json:"rerank_model,omitempty"` // default the pipeline's chat model
+	MinSimilarity *float32 `json:"min_similarity,omitempty"`
+	MMR           float64  `json:"mmr,omitempty"`
+	MultiQuery    int      `json:"multi_query,omitempty"`
+	HyDE          bool     `json:"hyde,omitempty"`
+
+	Model string `json:"model,omitempty"` // chat model
+	// Prompt replaces the instructions that open the answer prompt; the
+	// context and question follow it. It should still ask for [n] citations.
+	Prompt string `json:"prompt,omitempty"`
+}
+
+// ExperimentConfig names an experiment and the pipelines it compares.
+type ExperimentConfig struct {
+	Name      string     `json:"name"`
+	Pipelines []Pipeline `json:"pipelines"`
+}
+
+// LoadExperimentConfig reads an experiment from a JSON file, e.g.
+//
+//	{"name": "small-chunks", "pipelines": [{"name": "baseline"}, {"name": "chunks-500", "collection": "docs_500", "weight": 0.2}]}
+func LoadExperimentConfig(path string) (ExperimentConfig, error) {
+	var config ExperimentConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("parsing experiment %s: %w", path, err)
+	}
+	if err := config.validate(); err != nil {
+		return config, fmt.Errorf("experiment %s: %w", path, err)
+	}
+	return config, nil
+}
+
+func (c ExperimentConfig) validate() error {
+	if c.Name == "" {
+		return errors.New("the experiment has no name")
+	}
+	if len(c.Pipelines) == 0 {
+		return errors.New("the experiment has no pipelines")
+	}
+	seen := make(map[string]bool)
+	for i, p := range c.Pipelines {
+		if p.Name == "" || seen[p.Name] {
+			return fmt.Errorf("pipeline %d has an empty or duplicate name %q", i+1, p.Name)
+		}
+		seen[p.Name] = true
+		if _, err := newReranker(p.Reranker, nil, ""); err != nil {
+			return fmt.Errorf("pipeline %s: %w", p.Name, err)
+		}
+		switch {
+		case p.Weight < 0:
+			return fmt.Errorf("pipeline %s: invalid weight %g (expected a positive number)", p.Name, p.Weight)
+		case p.EmbeddingModel != "" && p.Collection == "":
+			return fmt.Errorf("pipeline %s: embedding_model needs a collection embedded with it", p.Name)
+		case p.Limit < 0:
+			return fmt.Errorf("pipeline %s: invalid limit %d", p.Name, p.Limit)
+		case p.MinSimilarity != nil && (*p.MinSimilarity < 0 || *p.MinSimilarity > 1):
+			return fmt.Errorf("pipeline %s: invalid min_similarity %g (expected 0.0-1.0)", p.Name, *p.MinSimilarity)
+		case p.MMR < 0 || p.MMR > 1:
+			return fmt.Errorf("pipeline %s: invalid mmr %g (expected 0.0-1.0)", p.Name, p.MMR)
+		case p.MultiQuery < 0 || p.MultiQuery > maxQueryVariants:
+			return fmt.Errorf("pipeline %s: invalid multi_query %d (expected at most %d)", p.Name, p.MultiQuery, maxQueryVariants)
+		}
+	}
+	return nil
+}
+
+// Experiment splits queries between pipelines, so that a change to the
+// pipeline can be tried on part of the traffic, or on an evaluation
+// dataset, and compared with the current one before it is rolled out.
+type Experiment struct {
+	Name string
+	Arms []ExperimentArm
+}
+
+// ExperimentArm is a pipeline of an experiment with the store it searches.
+type ExperimentArm struct {
+	Pipeline Pipeline
+	// Store holds the pipeline's collection, opened with its embedding
+	// model; nil searches the engine's store.
+	Store VectorStore
+}
+
+// Assign picks the arm for key, such as a query ID, at random in proportion
+// to the weights of the pipelines, but always the same arm for the same key.
+func (e *Experiment) Assign(key string) int {
+	total := 0.0
+	for _, arm := range e.Arms {
+		total += pipelineWeight(arm.Pipeline)
+	}
+	sum := sha256.Sum256([]byte(e.Name + "\x00" + key))
+	point := float64(binary.BigEndian.Uint64(sum[:])>>11) / (1 << 53) * total
+	for i, arm := range e.Arms {
+		if point -= pipelineWeight(arm.Pipeline); point < 0 {
+			return i
+		}
+	}
+	return len(e.Arms) - 1
+}
+
+func pipelineWeight(p Pipeline) float64 {
+	if p.Weight == 0 {
+		return 1
+	}
+	return p.Weight
+}
+
+// arm returns the index of the named pipeline, or -1 if there is none.
+func (e *Experiment) arm(pipeline string) int {
+	return slices.IndexFunc(e.Arms, func(arm ExperimentArm) bool { return arm.Pipeline.Name == pipeline })
+}
+
+// pipelines returns the names of the pipelines, in order.
+func (e *Experiment) pipelines() []string {
+	names := make([]string, len(e.Arms))
+	for i, arm := range e.Arms {
+		names[i] = arm.Pipeline.Name
+	}
+	return names
+}
+
+// Variant is how a pipeline answers a query: the engine, chat model, and
+// retrieval settings to answer it with.
+type Variant struct {
+	Pipeline string // empty outside an experiment
+	Engine   *RAGEngine
+	Model    string
+	Limit    int
+	Options  []RetrieveOption
+}
+
+// Variant returns how arm i answers a query that engine would answer with
+// model from limit documents retrieved with opts: the settings the pipeline
+// sets replace theirs. The engine's tenant and roles also confine the
+// pipeline's store.
+func (e *Experiment) Variant(engine *RAGEngine, i int, model string, limit int, opts []RetrieveOption) (Variant, error) {
+	arm := e.Arms[i]
+	p := arm.Pipeline
+	v := Variant{Pipeline: p.Name, Model: model, Limit: limit, Options: slices.Clip(opts)}
+	if p.Model != "" {
+		v.Model = p.Model
+	}
+	if p.Limit > 0 {
+		v.Limit = p.Limit
+	}
+
+	scoped := *engine
+	if arm.Store != nil {
+		store := arm.Store
+		if engine.tenant != "" {
+			var err error
+			if store, err = NewTenantStore(store, engine.tenant); err != nil {
+				return v, err
+			}
+		}
+		if engine.roles != nil {
+			store = NewACLStore(store, engine.roles)
+		}
+		scoped.store = store
+	}
+	if p.Reranker != "" {
+		rerankModel := p.RerankModel
+		if rerankModel == "" {
+			rerankModel = v.Model
+		}
+		reranker, err := newReranker(p.Reranker, engine.llm, rerankModel)
+		if err != nil {
+			return v, err
+		}
+		scoped.reranker = reranker
+	}
+	if p.MinSimilarity != nil {
+		scoped.minSimilarity = *p.MinSimilarity
+	}
+	if p.Prompt != "" {
+		scoped.answerPrompt = p.Prompt
+	}
+	v.Engine = &scoped
+
+	if p.MMR > 0 {
+		v.Options = append(v.Options, WithMMR(p.MMR))
+	}
+	if p.MultiQuery > 0 {
+		v.Options = append(v.Options, WithMultiQuery(v.Model, p.MultiQuery))
+	}
+	if p.HyDE {
+		v.Options = append(v.Options, WithHyDE(v.Model))
+	}
+	return v, nil
+}
+
+// observe records the outcome of a query answered by a pipeline of the
+// experiment, for comparing the pipelines in the metrics.
+func (e *Experiment) observe(pipeline string, answer Answer, err error, elapsed time.Duration) {
+	status := queryStatus(answer.NoContext, err)
+	if err == nil && answer.OutOfScope {
+		status = "out_of_scope"
+	}
+	experimentQueries.WithLabelValues(e.Name, pipeline, status).Inc()
+	experimentDuration.WithLabelValues(e.Name, pipeline).Observe(elapsed.Seconds())
+	if answer.Confidence != nil {
+		experimentConfidence.WithLabelValues(e.Name, pipeline).Observe(answer.Confidence.Score)
+	}
+}
+
+// ExperimentResult is how a pipeline did on an evaluation dataset.
+type ExperimentResult struct {
+	Pipeline string
+	Results  []EvalResult
+	Summary  EvalSummary
+	Latency  time.Duration // mean time to answer a question, including judging
+}
+
+// Evaluate runs the cases through every pipeline of the experiment, or,
+// with split, each case through the one pipeline Assign picks for its
+// question, and returns the results of each pipeline, in order. The other
+// arguments are as for RAGEngine.Evaluate.
+func (e *Experiment) Evaluate(ctx context.Context, engine *RAGEngine, cases []EvalCase, limit int, model string, judge *AnswerJudge, split bool, opts ...RetrieveOption) ([]ExperimentResult, error) {
+	results := make([]ExperimentResult, 0, len(e.Arms))
+	for i := range e.Arms {
+		armCases := cases
+		if split {
+			armCases = nil
+			for _, c := range cases {
+				if e.Assign(c.Question) == i {
+					armCases = append(armCases, c)
+				}
+			}
+		}
+		v, err := e.Variant(engine, i, model, limit, opts)
+		if err != nil {
+			return results, fmt.Errorf("pipeline %s: %w", v.Pipeline, err)
+		}
+		start := time.Now()
+		armResults, summary, err := v.Engine.Evaluate(ctx, armCases, v.Limit, v.Model, judge, v.Options...)
+		if err != nil {
+			return results, fmt.Errorf("pipeline %s: %w", v.Pipeline, err)
+		}
+		result := ExperimentResult{Pipeline: v.Pipeline, Results: armResults, Summary: summary}
+		if len(armCases) > 0 {
+			result.Latency = time.Since(start) / time.Duration(len(armCases))
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+type experimentPipelineJSON struct {
+	Pipeline  string           `json:"pipeline"`
+	LatencyMS int64            `json:"latency_ms"`
+	Summary   evalSummaryJSON  `json:"summary"`
+	Results   []evalResultJSON `json:"results"`
+}
+
+type experimentReportJSON struct {
+	Experiment string                   `json:"experiment"`
+	Pipelines  []experimentPipelineJSON `json:"pipelines"`
+}
+
+// writeExperimentReport saves the results of each pipeline of an experiment
+// as JSON.
+func writeExperimentReport(path, experiment string, results []ExperimentResult) error {
+	report := experimentReportJSON{Experiment: experiment, Pipelines: make([]experimentPipelineJSON, 0, len(results))}
+	for _, result := range results {
+		report.Pipelines = append(report.Pipelines, experimentPipelineJSON{
+			Pipeline:  result.Pipeline,
+			LatencyMS: result.Latency.Milliseconds(),
+			Summary:   newEvalSummaryJSON(result.Summary),
+			Results:   newEvalResultsJSON(result.Results),
+		})
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// checkPipeline reports an error unless the experiment has the named
+// pipeline.
+func (e *Experiment) checkPipeline(pipeline string) error {
+	if e.arm(pipeline) < 0 {
+		return fmt.Errorf("unknown pipeline %q (expected one of %s)", pipeline, strings.Join(e.pipelines(), ", "))
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// newExperiment returns an experiment comparing the engine's pipeline with
+// one that searches the returned store, with a prompt of its own.
+func newExperiment(t *testing.T) (*Experiment, *MemoryStore) {
+	t.Helper()
+	store := NewMemoryStore(NewHashingEmbedder(256))
+	store.InsertDocuments(context.Background(), []string{"Invoices are stored in Postgres."}, []string{"billing-v2.md"}, nil)
+	return &Experiment{Name: "billing-docs", Arms: []ExperimentArm{
+		{Pipeline: Pipeline{Name: "baseline"}},
+		{Pipeline: Pipeline{Name: "v2", Model: "gpt-small", Prompt: "Answer in one sentence, citing [n]."}, Store: store},
+	}}, store
+}
+
+func TestLoadExperimentConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "experiment.json")
+	for config, want := range map[string]string{
+		`{"name": "x", "pipelines": [{"name": "a"}, {"name": "b", "reranker": "llm", "weight": 0.2}]}`: "",
+		`{"name": "x", "pipelines": [{"name": "a"}, {"name": "a"}]}`:                                   "duplicate name",
+		`{"name": "x", "pipelines": [{"name": "a", "reranker": "cross"}]}`:                             "invalid reranker",
+		`{"name": "x", "pipelines": [{"name": "a", "embedding_model": "text-embedding-3-large"}]}`:     "needs a collection",
+		`{"pipelines": [{"name": "a"}]}`:                                                               "no name",
+	} {
+		os.WriteFile(path, []byte(config), 0o644)
+		_, err := LoadExperimentConfig(path)
+		if want == "" && err != nil || want != "" && (err == nil || !strings.Contains(err.Error(), want)) {
+			t.Errorf("%s: got %v, want %q", config, err, want)
+		}
+	}
+}
+
+func TestExperimentAssignFollowsTheWeights(t *testing.T) {
+	experiment := &Experiment{Name: "weights", Arms: []ExperimentArm{
+		{Pipeline: Pipeline{Name: "a", Weight: 3}},
+		{Pipeline: Pipeline{Name: "b"}},
+	}}
+	counts := make([]int, 2)
+	for i := range 1000 {
+		key := fmt.Sprintf("query-%d", i)
+		arm := experiment.Assign(key)
+		if experiment.Assign(key) != arm {
+			t.Fatalf("expected the same arm for %s", key)
+		}
+		counts[arm]++
+	}
+	if counts[0] < 700 || counts[0] > 800 {
+		t.Fatalf("expected about 750 of 1000 queries in a, got %v", counts)
+	}
+}
+
+func TestExperimentVariantAppliesThePipeline(t *testing.T) {
+	experiment, _ := newExperiment(t)
+	base := NewMemoryStore(NewHashingEmbedder(256))
+	base.InsertDocuments(context.Background(), []string{"Invoices are stored in MySQL."}, []string{"billing.md"}, nil)
+	llm := &dummyOpenAI{}
+	engine := NewRAGEngine(llm, base)
+
+	v, err := experiment.Variant(engine, 1, "gpt-large", 3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := v.Engine.Retrieve(context.Background(), "Where are invoices stored?", v.Limit, v.Options...)
+	if len(docs) != 1 || docs[0].Source != "billing-v2.md" {
+		t.Fatalf("expected the pipeline's collection, got %+v", docs)
+	}
+	v.Engine.GenerateResponse(context.Background(), "Where are invoices stored?", docs, v.Model)
+	if llm.lastModel != "gpt-small" || !strings.HasPrefix(llm.lastMessages[len(llm.lastMessages)-1].Content, "Answer in one sentence") {
+		t.Fatalf("expected the pipeline's model and prompt, got %s and %q", llm.lastModel, llm.lastMessages)
+	}
+
+	// The base pipeline keeps the engine's settings, and the engine is
+	// left as it was.
+	v, _ = experiment.Variant(engine, 0, "gpt-large", 3, nil)
+	if v.Engine.store != base || v.Model != "gpt-large" || engine.answerPrompt != "" {
+		t.Fatalf("expected the engine's settings, got %+v", v)
+	}
+}
+
+func TestExperimentVariantKeepsTheTenant(t *testing.T) {
+	experiment, store := newExperiment(t)
+	engine, err := NewRAGEngine(&dummyOpenAI{}, NewMemoryStore(NewHashingEmbedder(256))).ForTenant("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, _ := experiment.Variant(engine, 1, "gpt-large", 3, nil)
+	if docs := v.Engine.Retrieve(context.Background(), "Where are invoices stored?", 3); len(docs) != 0 {
+		t.Fatalf("expected the other tenant's documents to stay hidden, got %+v", docs)
+	}
+	v.Engine.AddDocuments(context.Background(), []string{"Refunds take five days."}, []string{"refunds.md"})
+	docs := store.SearchSimilar(context.Background(), "Refunds", 5, nil)
+	if !slices.ContainsFunc(docs, func(doc Document) bool { return doc.Source == "acme::refunds.md" }) {
+		t.Fatalf("expected the tenant's document in the pipeline's store, got %+v", docs)
+	}
+}
+
+func TestServerAnswersWithExperimentPipelines(t *testing.T) {
+	experiment, _ := newExperiment(t)
+	server := NewServer(NewRAGEngine(&scriptedOpenAI{reply: "Postgres [1]."}, NewMemoryStore(NewHashingEmbedder(256))), "gpt-test")
+	query := func(body string) (int, queryResponse) {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(body)))
+		var resp queryResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+
+	if code, _ := query(`{"question":"Where are invoices stored?","pipeline":"v2"}`); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a pipeline without an experiment, got %d", code)
+	}
+	server.SetExperiment(experiment)
+	code, resp := query(`{"question":"Where are invoices stored?","pipeline":"v2"}`)
+	if code != http.StatusOK || resp.Pipeline != "v2" || len(resp.Citations) != 1 || resp.Citations[0].Source != "billing-v2.md" {
+		t.Fatalf("expected the v2 pipeline's answer, got %d %+v", code, resp)
+	}
+	if code, resp := query(`{"question":"Where are invoices stored?"}`); code != http.StatusOK || experiment.arm(resp.Pipeline) < 0 {
+		t.Fatalf("expected an assigned pipeline, got %d %+v", code, resp)
+	}
+	if code, _ := query(`{"question":"Where are invoices stored?","pipeline":"v3"}`); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown pipeline, got %d", code)
+	}
+}
+
+func TestExperimentEvaluateComparesPipelines(t *testing.T) {
+	experiment, _ := newExperiment(t)
+	engine := NewRAGEngine(&scriptedOpenAI{reply: "See [1]."}, NewMemoryStore(NewHashingEmbedder(256)))
+	cases := []EvalCase{
+		{Question: "Where are invoices stored?", ExpectedSources: []string{"billing-v2.md"}},
+		{Question: "Which database holds invoices?", ExpectedSources: []string{"billing-v2.md"}},
+	}
+	results, err := experiment.Evaluate(context.Background(), engine, cases, 3, "gpt-test", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Summary.RetrievalHits != 0 || results[1].Summary.RetrievalHits != 2 {
+		t.Fatalf("expected only v2 to retrieve the expected source, got %+v", results)
+	}
+
+	results, _ = experiment.Evaluate(context.Background(), engine, cases, 3, "gpt-test", nil, true)
+	if results[0].Summary.Questions+results[1].Summary.Questions != len(cases) {
+		t.Fatalf("expected the cases split between the pipelines, got %+v", results)
+	}
+}
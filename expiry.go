@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// expiresField is the metadata key holding the time a document expires, in
+// Unix seconds. Documents without it never expire.
+const expiresField = "expires_at"
+
+// opNotExpired is the Condition operator matching documents whose field is
+// absent or holds a time after the condition's Unix seconds value. It backs
+// document expiry and cannot be written in ParseFilter syntax.
+const opNotExpired = "not_expired"
+
+// NotExpired is a condition matching documents that have not expired at now:
+// those without an expiry and those expiring after now.
+func NotExpired(now time.Time) Condition {
+	return Condition{Field: expiresField, Op: opNotExpired, Value: float64(now.Unix())}
+}
+
+// expiryAllows reports whether an expiry value, nil when absent, lies after
+// now, given in Unix seconds.
+func expiryAllows(value any, now float64) bool {
+	if value == nil {
+		return true
+	}
+	expires, ok := toFloat(value)
+	return ok && expires > now
+}
+
+// parseExpiry converts an expiry from metadata to Unix seconds. Besides
+// numbers, it accepts the strings CSV and JSONL records give: Unix seconds,
+// RFC 3339 timestamps, and dates, which expire at the start of the day (UTC).
+func parseExpiry(value any) (float64, error) {
+	if seconds, ok := toFloat(value); ok {
+		return seconds, nil
+	}
+	s, ok := value.(string)
+	if !ok {
+		return 0, fmt.Errorf("invalid expiry %v", value)
+	}
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		return seconds, nil
+	}
+	t, err := parseExpiryTime(s)
+	if err != nil {
+		return 0, err
+	}
+	return float64(t.Unix()), nil
+}
+
+// parseExpiryTime parses an RFC 3339 timestamp or a date.
+func parseExpiryTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid expiry %q (expected an RFC 3339 timestamp, a date, or Unix seconds)", s)
+}
+
+// chunkExpiry returns a chunk's expiry in Unix seconds: value, the expiry its
+// page's metadata sets, or else fallback. An invalid value is logged and
+// ignored. It reports false if the chunk does not expire.
+func chunkExpiry(ctx context.Context, value any, fallback time.Time, source string) (float64, bool) {
+	if value != nil {
+		expires, err := parseExpiry(value)
+		if err == nil {
+			return expires, true
+		}
+		slog.WarnContext(ctx, "Ignoring invalid expiry", "source", source, "error", err)
+	}
+	if fallback.IsZero() {
+		return 0, false
+	}
+	return float64(fallback.Unix()), true
+}
+
+// WithIngestExpiry returns a copy of the engine that makes the chunks it
+// ingests expire at expires, unless a page sets its own expiry in its
+// metadata. A zero time leaves chunks without an expiry.
+func (r *RAGEngine) WithIngestExpiry(expires time.Time) *RAGEngine {
+	scoped := *r
+	scoped.ingestExpiry = expires
+	return &scoped
+}
+
+// ExpiringStore is implemented by vector stores that can remove expired
+// chunks, which `rag serve` does periodically and `rag expire` on demand.
+type ExpiringStore interface {
+	// DeleteExpired removes the chunks that expired at or before now and
+	// returns how many it removed.
+	DeleteExpired(ctx context.Context, now time.Time) (int, error)
+}
+
+// runExpiryJanitor deletes the expired chunks of store every interval until
+// ctx is done. Retrieval already ignores expired chunks; the janitor reclaims
+// the space they take.
+func runExpiryJanitor(ctx context.Context, store ExpiringStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			sweepExpired(ctx, store, now)
+		}
+	}
+}
+
+// sweepExpired deletes the chunks of store that expired at or before now,
+// logging the outcome.
+func sweepExpired(ctx context.Context, store ExpiringStore, now time.Time) (int, error) {
+	removed, err := store.DeleteExpired(ctx, now)
+	if err != nil {
+		slog.WarnContext(ctx, "Deleting expired documents failed", "error", err)
+		return removed, err
+	}
+	if removed > 0 {
+		slog.InfoContext(ctx, "Deleted expired documents", "chunks", removed)
+	}
+	return removed, nil
+}
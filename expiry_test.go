@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpiredDocumentsAreIgnoredAndSwept(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(NewHashingEmbedder(256))
+	engine := NewRAGEngine(&dummyOpenAI{}, store)
+	past := time.Now().Add(-time.Hour)
+	pages := []Page{
+		{Text: "The spring sale ends on Friday.", Source: "sale.md"},
+		{Text: "The summer sale starts in June.", Source: "summer.md", Metadata: map[string]any{"expires_at": time.Now().Add(time.Hour).Format(time.RFC3339)}},
+	}
+	if _, ok := ingestPages(ctx, engine.WithIngestExpiry(past), pages, 1000, 0); !ok {
+		t.Fatal("ingestion failed")
+	}
+	if _, ok := ingestPages(ctx, engine, []Page{{Text: "The sale applies to every store.", Source: "stores.md"}}, 1000, 0); !ok {
+		t.Fatal("ingestion failed")
+	}
+
+	sources := func() []string {
+		var found []string
+		for _, doc := range engine.Retrieve(ctx, "sale", 10) {
+			found = append(found, doc.Source)
+		}
+		slices.Sort(found)
+		return found
+	}
+	if got := sources(); !slices.Equal(got, []string{"stores.md", "summer.md"}) {
+		t.Fatalf("retrieved %v, expected the expired chunk to be ignored", got)
+	}
+
+	removed, err := store.DeleteExpired(ctx, time.Now())
+	if err != nil || removed != 1 || store.Len() != 2 {
+		t.Fatalf("DeleteExpired = %d, %v; %d chunks left", removed, err, store.Len())
+	}
+	if removed, _ := store.DeleteExpired(ctx, time.Now().Add(2*time.Hour)); removed != 1 {
+		t.Fatalf("expected the summer sale to expire later, removed %d", removed)
+	}
+
+	// Extending a chunk's lifetime replaces it on re-ingestion.
+	report, _ := ingestPages(ctx, engine.WithIngestExpiry(time.Now().Add(time.Hour)), []Page{{Text: "The sale applies to every store.", Source: "stores.md"}}, 1000, 0)
+	if report.Updated != 1 || report.Removed != 1 {
+		t.Fatalf("expected the chunk to be replaced, got %+v", report)
+	}
+}
+
+func TestParseExpiry(t *testing.T) {
+	cases := map[any]float64{
+		float64(1700000000):    1700000000,
+		"1700000000":           1700000000,
+		"2024-01-31T12:00:00Z": 1706702400,
+		"2024-01-31":           1706659200,
+	}
+	for value, want := range cases {
+		if got, err := parseExpiry(value); err != nil || got != want {
+			t.Errorf("parseExpiry(%v) = %v, %v", value, got, err)
+		}
+	}
+	for _, value := range []any{"next week", true} {
+		if _, err := parseExpiry(value); err == nil {
+			t.Errorf("expected parseExpiry(%v) to fail", value)
+		}
+	}
+}
+
+func TestNotExpiredTranslations(t *testing.T) {
+	filter := Filter{NotExpired(time.Unix(1700000000, 0))}
+	expected := `(not exists metadata["expires_at"] || metadata["expires_at"] > 1700000000)`
+	if got := filter.milvusExpr(false); got != expected {
+		t.Errorf("milvusExpr = %s", got)
+	}
+
+	where, args := filter.sqlWhere([]any{"[0.1]"})
+	expected = "WHERE (NOT jsonb_exists(metadata, $2) OR (jsonb_typeof(metadata -> $2) = 'number' AND metadata -> $2 > $3::jsonb))"
+	if where != expected || args[1] != "expires_at" || args[2] != "1700000000" {
+		t.Errorf("sqlWhere = %s %v", where, args)
+	}
+
+	native, _ := filter.qdrantFilter()
+	data, _ := json.Marshal(native)
+	expected = `{"must":[{"should":[{"is_empty":{"key":"metadata.expires_at"}},{"key":"metadata.expires_at","range":{"gt":1700000000}}]}]}`
+	if string(data) != expected {
+		t.Errorf("qdrantFilter = %s", data)
+	}
+
+	for expires, want := range map[any]bool{nil: true, float64(1700000001): true, float64(1700000000): false} {
+		doc := Document{Metadata: map[string]any{}}
+		if expires != nil {
+			doc.Metadata["expires_at"] = expires
+		}
+		if got := filter.Match(doc); got != want {
+			t.Errorf("Match with expiry %v = %t", expires, got)
+		}
+	}
+}
+
+func TestQdrantDeleteExpired(t *testing.T) {
+	var requests []string
+	var deleted string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/points/count"):
+			w.Write([]byte(`{"result":{"count":3}}`))
+		case strings.HasSuffix(r.URL.Path, "/points/delete"):
+			var body map[string]any
+			json.NewDecoder(r.Body).Decode(&body)
+			data, _ := json.Marshal(body)
+			deleted = string(data)
+			w.Write([]byte(`{"result":{},"status":"ok"}`))
+		default:
+			w.Write([]byte(`{"result":{},"status":"ok"}`))
+		}
+	}))
+	defer server.Close()
+
+	store := NewQdrantStore(server.URL, "", "docs", NewHashingEmbedder(2), 2)
+	removed, err := store.DeleteExpired(context.Background(), time.Unix(1700000000, 0))
+	if err != nil || removed != 3 {
+		t.Fatalf("DeleteExpired = %d, %v", removed, err)
+	}
+	if want := `{"filter":{"must":[{"key":"metadata.expires_at","range":{"lte":1700000000}}]}}`; deleted != want {
+		t.Fatalf("deleted with %s after requests %v", deleted, requests)
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// An export is a directory holding three files: manifest.json describing the
+// export, documents.jsonl with one ExportRecord (without its embedding) per
+// line, and embeddings.npy, a NumPy array of float32 embeddings whose rows
+// line up with the lines of documents.jsonl. NumPy, pandas, and most vector
+// tooling read the pair directly.
+const (
+	exportManifestFile   = "manifest.json"
+	exportDocumentsFile  = "documents.jsonl"
+	exportEmbeddingsFile = "embeddings.npy"
+)
+
+// exportFormatVersion is written to every manifest; imports reject newer
+// versions.
+const exportFormatVersion = 1
+
+// ExportRecord is one stored chunk with its embedding.
+type ExportRecord struct {
+	Text      string         `json:"text"`
+	Source    string         `json:"source"`
+	Metadata  map[string]any `json:"metadata,omitempty"`
+	Embedding []float32      `json:"-"`
+}
+
+// ExportableStore is implemented by stores that can list every stored chunk
+// with its embedding, for `rag export`.
+type ExportableStore interface {
+	// ExportDocuments calls fn with consecutive batches of at most batch
+	// records until every chunk has been passed, or fn fails.
+	ExportDocuments(ctx context.Context, batch int, fn func([]ExportRecord) error) error
+}
+
+// ExportManifest describes an export.
+type ExportManifest struct {
+	Version        int       `json:"version"`
+	Created        time.Time `json:"created"`
+	Backend        string    `json:"backend"`
+	Collection     string    `json:"collection"`
+	EmbeddingModel string    `json:"embedding_model"`
+	Dimension      int       `json:"dimension"`
+	Documents      int       `json:"documents"`
+}
+
+// ExportCollection writes every chunk of store to a new export in dir, which
+// must not exist or be empty. manifest describes the store; its version,
+// creation time, and document count are filled in.
+func ExportCollection(ctx context.Context, store ExportableStore, dir string, manifest ExportManifest, batch int) (ExportManifest, error) {
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return manifest, fmt.Errorf("%s is not empty", dir)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return manifest, err
+	}
+	documents, err := os.Create(filepath.Join(dir, exportDocumentsFile))
+	if err != nil {
+		return manifest, err
+	}
+	defer documents.Close()
+	embeddings, err := os.Create(filepath.Join(dir, exportEmbeddingsFile))
+	if err != nil {
+		return manifest, err
+	}
+	defer embeddings.Close()
+
+	lines := bufio.NewWriter(documents)
+	npy, err := newNpyWriter(embeddings, manifest.Dimension)
+	if err != nil {
+		return manifest, err
+	}
+	encoder := json.NewEncoder(lines)
+	encoder.SetEscapeHTML(false)
+	err = store.ExportDocuments(ctx, batch, func(records []ExportRecord) error {
+		for _, record := range records {
+			if err := npy.WriteRow(record.Embedding); err != nil {
+				return fmt.Errorf("chunk of %s: %w", record.Source, err)
+			}
+			if err := encoder.Encode(record); err != nil {
+				return err
+			}
+		}
+		manifest.Documents += len(records)
+		slog.InfoContext(ctx, "Exported documents", "documents", manifest.Documents)
+		return nil
+	})
+	if err != nil {
+		return manifest, fmt.Errorf("exporting documents: %w", err)
+	}
+	if err := lines.Flush(); err != nil {
+		return manifest, err
+	}
+	if err := npy.Close(); err != nil {
+		return manifest, err
+	}
+
+	manifest.Version = exportFormatVersion
+	manifest.Created = time.Now().UTC()
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+	return manifest, os.WriteFile(filepath.Join(dir, exportManifestFile), append(data, '\n'), 0o644)
+}
+
+// ReadExportManifest reads the manifest of the export in dir.
+func ReadExportManifest(dir string) (ExportManifest, error) {
+	var manifest ExportManifest
+	data, err := os.ReadFile(filepath.Join(dir, exportManifestFile))
+	if err != nil {
+		return manifest, err
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("parsing %s: %w", exportManifestFile, err)
+	}
+	if manifest.Version > exportFormatVersion {
+		return manifest, fmt.Errorf("export format version %d is newer than this build supports (%d)", manifest.Version, exportFormatVersion)
+	}
+	return manifest, nil
+}
+
+// ImportCollection inserts the chunks of the export in dir into store in
+// batches. Unless reembed is set, the exported embeddings are stored as they
+// are, so the store's embedder must be the model they were made with; with
+// reembed, the texts are embedded again by the store. It returns the number
+// of chunks imported.
+func ImportCollection(ctx context.Context, store VectorStore, dir string, batch int, reembed bool) (int, error) {
+	documents, err := os.Open(filepath.Join(dir, exportDocumentsFile))
+	if err != nil {
+		return 0, err
+	}
+	defer documents.Close()
+	embeddings, err := os.Open(filepath.Join(dir, exportEmbeddingsFile))
+	if err != nil {
+		return 0, err
+	}
+	defer embeddings.Close()
+	npy, err := newNpyReader(embeddings)
+	if err != nil {
+		return 0, fmt.Errorf("reading %s: %w", exportEmbeddingsFile, err)
+	}
+
+	imported := 0
+	var texts, sources []string
+	var metadata []map[string]any
+	var vectors [][]float32
+	flush := func() error {
+		if len(texts) == 0 {
+			return nil
+		}
+		insertCtx := ctx
+		if !reembed {
+			insertCtx = withPrecomputedEmbeddings(ctx, vectors)
+		}
+		if !store.InsertDocuments(insertCtx, texts, sources, metadata) {
+			return fmt.Errorf("inserting documents %d-%d failed", imported+1, imported+len(texts))
+		}
+		imported += len(texts)
+		slog.InfoContext(ctx, "Imported documents", "documents", imported)
+		texts, sources, metadata, vectors = nil, nil, nil, nil
+		return nil
+	}
+
+	scanner := bufio.NewScanner(documents)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var record ExportRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return imported, fmt.Errorf("%s line %d: %w", exportDocumentsFile, line, err)
+		}
+		vector, err := npy.ReadRow()
+		if err != nil {
+			return imported, fmt.Errorf("%s row %d: %w", exportEmbeddingsFile, line, err)
+		}
+		texts = append(texts, record.Text)
+		sources = append(sources, record.Source)
+		metadata = append(metadata, record.Metadata)
+		vectors = append(vectors, vector)
+		if len(texts) >= batch {
+			if err := flush(); err != nil {
+				return imported, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return imported, fmt.Errorf("reading %s: %w", exportDocumentsFile, err)
+	}
+	if err := flush(); err != nil {
+		return imported, err
+	}
+	if imported != npy.rows {
+		return imported, fmt.Errorf("%s holds %d documents but %s %d embeddings", exportDocumentsFile, imported, exportEmbeddingsFile, npy.rows)
+	}
+	return imported, nil
+}
+
+// npyHeaderSize is the size reserved for the header of written .npy files,
+// so the row count can be filled in once it is known.
+const npyHeaderSize = 128
+
+// npyWriter writes a 2-D little-endian float32 array in NumPy's .npy
+// format, one row at a time.
+type npyWriter struct {
+	file      *os.File
+	buf       *bufio.Writer
+	dimension int
+	rows      int
+}
+
+func newNpyWriter(file *os.File, dimension int) (*npyWriter, error) {
+	w := &npyWriter{file: file, buf: bufio.NewWriter(file), dimension: dimension}
+	if _, err := w.buf.Write(npyHeader(0, dimension)); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// WriteRow appends a row, which must have the array's dimension.
+func (w *npyWriter) WriteRow(row []float32) error {
+	if len(row) != w.dimension {
+		return fmt.Errorf("embedding has %d dimensions, expected %d", len(row), w.dimension)
+	}
+	var b [4]byte
+	for _, x := range row {
+		binary.LittleEndian.PutUint32(b[:], math.Float32bits(x))
+		if _, err := w.buf.Write(b[:]); err != nil {
+			return err
+		}
+	}
+	w.rows++
+	return nil
+}
+
+// Close flushes the rows and rewrites the header with their count.
+func (w *npyWriter) Close() error {
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	_, err := w.file.WriteAt(npyHeader(w.rows, w.dimension), 0)
+	return err
+}
+
+// npyHeader returns a version 1.0 header of npyHeaderSize bytes for a
+// rows x dimension float32 array.
+func npyHeader(rows, dimension int) []byte {
+	dict := fmt.Sprintf("{'descr': '<f4', 'fortran_order': False, 'shape': (%d, %d), }", rows, dimension)
+	header := make([]byte, 0, npyHeaderSize)
+	header = append(header, "\x93NUMPY\x01\x00"...)
+	header = binary.LittleEndian.AppendUint16(header, npyHeaderSize-10)
+	header = append(header, dict...)
+	for len(header) < npyHeaderSize-1 {
+		header = append(header, ' ')
+	}
+	return append(header, '\n')
+}
+
+var (
+	npyFloat32Pattern = regexp.MustCompile(`'descr':\s*'<f4'.*'fortran_order':\s*False`)
+	npyShapePattern   = regexp.MustCompile(`'shape':\s*\((\d+),\s*(\d+)\)`)
+)
+
+// npyReader reads the rows of a 2-D little-endian float32 .npy array.
+type npyReader struct {
+	r         *bufio.Reader
+	rows      int
+	dimension int
+	read      int
+}
+
+func newNpyReader(r io.Reader) (*npyReader, error) {
+	br := bufio.NewReader(r)
+	prefix := make([]byte, 8)
+	if _, err := io.ReadFull(br, prefix); err != nil || string(prefix[:6]) != "\x93NUMPY" {
+		return nil, errors.New("not a .npy file")
+	}
+	var size int
+	switch prefix[6] {
+	case 1:
+		var n uint16
+		if err := binary.Read(br, binary.LittleEndian, &n); err != nil {
+			return nil, err
+		}
+		size = int(n)
+	case 2, 3:
+		var n uint32
+		if err := binary.Read(br, binary.LittleEndian, &n); err != nil {
+			return nil, err
+		}
+		size = int(n)
+	default:
+		return nil, fmt.Errorf("unsupported .npy version %d", prefix[6])
+	}
+	dict := make([]byte, size)
+	if _, err := io.ReadFull(br, dict); err != nil {
+		return nil, err
+	}
+	header := string(dict)
+	if !npyFloat32Pattern.MatchString(header) {
+		return nil, fmt.Errorf("expected a C-ordered little-endian float32 array, got %s", header)
+	}
+	shape := npyShapePattern.FindStringSubmatch(header)
+	if shape == nil {
+		return nil, fmt.Errorf("expected a 2-D array, got %s", header)
+	}
+	rows, _ := strconv.Atoi(shape[1])
+	dimension, _ := strconv.Atoi(shape[2])
+	return &npyReader{r: br, rows: rows, dimension: dimension}, nil
+}
+
+// ReadRow returns the next row, or an error once all rows have been read.
+func (r *npyReader) ReadRow() ([]float32, error) {
+	if r.read == r.rows {
+		return nil, fmt.Errorf("the array has only %d rows", r.rows)
+	}
+	data := make([]byte, 4*r.dimension)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return nil, err
+	}
+	row := make([]float32, r.dimension)
+	for i := range row {
+		row[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+	}
+	r.read++
+	return row, nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/milvus-io/milvus-sdk-go/v2/client"
+	"github.com/milvus-io/milvus-sdk-go/v2/entity"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	source := NewMemoryStore(NewHashingEmbedder(64))
+	texts := []string{"Go is a programming language.", "Milvus is a vector database.", "Docker runs containers."}
+	metadata := []map[string]any{{"page": float64(1)}, nil, {"partition": "ops"}}
+	if !source.InsertDocuments(ctx, texts, []string{"go.md", "milvus.md", "docker.md"}, metadata) {
+		t.Fatal("insert failed")
+	}
+
+	dir := filepath.Join(t.TempDir(), "backup")
+	manifest, err := ExportCollection(ctx, source, dir, ExportManifest{Backend: "memory", EmbeddingModel: "hashing", Dimension: 64}, 2)
+	if err != nil {
+		t.Fatalf("ExportCollection returned error: %v", err)
+	}
+	if manifest.Documents != 3 {
+		t.Fatalf("expected 3 exported documents, got %d", manifest.Documents)
+	}
+	if read, err := ReadExportManifest(dir); err != nil || read.Documents != 3 || read.EmbeddingModel != "hashing" || read.Version != exportFormatVersion {
+		t.Fatalf("unexpected manifest %+v (%v)", read, err)
+	}
+	if _, err := ExportCollection(ctx, source, dir, manifest, 2); err == nil {
+		t.Fatal("expected exporting into a non-empty directory to fail")
+	}
+
+	// The target cannot embed, so the import must restore the exported
+	// embeddings rather than compute new ones.
+	embedder := &outageEmbedder{Embedder: NewHashingEmbedder(64), down: true}
+	target := NewMemoryStore(embedder)
+	imported, err := ImportCollection(ctx, target, dir, 2, false)
+	if err != nil || imported != 3 {
+		t.Fatalf("ImportCollection = %d, %v", imported, err)
+	}
+	if !slices.EqualFunc(target.vectors(), source.vectors(), slices.Equal) {
+		t.Fatal("expected the embeddings to be restored unchanged")
+	}
+	embedder.down = false
+	docs := target.SearchSimilar(ctx, "vector database", 1, nil)
+	if len(docs) != 1 || docs[0].Source != "milvus.md" {
+		t.Fatalf("expected the restored collection to be searchable, got %+v", docs)
+	}
+	docs = target.SearchSimilar(ctx, "containers", 1, Filter{Eq("partition", "ops")})
+	if len(docs) != 1 || docs[0].Source != "docker.md" {
+		t.Fatalf("expected metadata to be restored, got %+v", docs)
+	}
+
+	embedder.down = true
+	if _, err := ImportCollection(ctx, target, dir, 2, true); err == nil {
+		t.Fatal("expected --reembed to embed the texts again")
+	}
+}
+
+func TestNpyFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "embeddings.npy")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := newNpyWriter(file, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteRow([]float32{1, -0.5})
+	w.WriteRow([]float32{0.25, 2})
+	if err := w.WriteRow([]float32{1}); err == nil {
+		t.Fatal("expected a row of the wrong dimension to be rejected")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	data, _ := os.ReadFile(path)
+	if len(data) != npyHeaderSize+2*2*4 || data[npyHeaderSize-1] != '\n' {
+		t.Fatalf("unexpected file layout of %d bytes", len(data))
+	}
+	if !bytes.Contains(data[:npyHeaderSize], []byte("'shape': (2, 2)")) {
+		t.Fatalf("expected the header to record the row count, got %q", data[:npyHeaderSize])
+	}
+	r, err := newNpyReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := r.ReadRow()
+	second, _ := r.ReadRow()
+	if !slices.Equal(first, []float32{1, -0.5}) || !slices.Equal(second, []float32{0.25, 2}) {
+		t.Fatalf("read rows %v and %v", first, second)
+	}
+	if _, err := r.ReadRow(); err == nil {
+		t.Fatal("expected reading past the last row to fail")
+	}
+
+	// Headers written by NumPy itself are padded differently.
+	header := "{'descr': '<f4', 'fortran_order': False, 'shape': (1, 1), }" + "    \n"
+	numpy := append([]byte("\x93NUMPY\x01\x00"), byte(len(header)), 0)
+	numpy = append(append(numpy, header...), 0, 0, 0x80, 0x3f)
+	if r, err := newNpyReader(bytes.NewReader(numpy)); err != nil {
+		t.Fatalf("newNpyReader returned error: %v", err)
+	} else if row, _ := r.ReadRow(); !slices.Equal(row, []float32{1}) {
+		t.Fatalf("read %v", row)
+	}
+	if _, err := newNpyReader(bytes.NewReader([]byte("\x93NUMPY\x01\x00\x3c\x00{'descr': '<f8', 'fortran_order': False, 'shape': (1, 1), }"))); err == nil {
+		t.Fatal("expected float64 arrays to be rejected")
+	}
+}
+
+// exportSDK serves rows from Query in descending ID order, honoring the
+// "id > N" expression and limit of a paged export.
+type exportSDK struct {
+	*fakeMilvusSDK
+	ids     []int64
+	queries int
+}
+
+func (e *exportSDK) Query(ctx context.Context, name string, partitions []string, expr string, fields []string, opts ...client.SearchQueryOptionFunc) (client.ResultSet, error) {
+	e.queries++
+	var after int64
+	fmt.Sscanf(expr, "id > %d", &after)
+	option := &client.SearchQueryOption{}
+	for _, opt := range opts {
+		opt(option)
+	}
+	var ids []int64
+	for _, id := range e.ids {
+		if id > after && int64(len(ids)) < option.Limit {
+			ids = append(ids, id)
+		}
+	}
+	slices.Reverse(ids)
+	var texts, sources []string
+	var metadata [][]byte
+	var embeddings [][]float32
+	for _, id := range ids {
+		texts = append(texts, fmt.Sprintf("chunk %d", id))
+		sources = append(sources, "doc.md")
+		metadata = append(metadata, []byte(fmt.Sprintf(`{"chunk": %d}`, id)))
+		embeddings = append(embeddings, []float32{float32(id), 0})
+	}
+	return client.ResultSet{
+		entity.NewColumnInt64("id", ids),
+		entity.NewColumnVarChar("text", texts),
+		entity.NewColumnVarChar("source", sources),
+		entity.NewColumnJSONBytes("metadata", metadata),
+		entity.NewColumnFloatVector("embedding", 2, embeddings),
+	}, nil
+}
+
+func TestMilvusExportDocumentsPagesByID(t *testing.T) {
+	sdk := &exportSDK{fakeMilvusSDK: &fakeMilvusSDK{collections: []*entity.Collection{{Name: "rag_documents"}}}, ids: []int64{3, 7, 8, 12, 20}}
+	store := &MilvusClientImpl{client: sdk, collectionName: "rag_documents"}
+	var texts []string
+	err := store.ExportDocuments(context.Background(), 2, func(records []ExportRecord) error {
+		for _, record := range records {
+			texts = append(texts, record.Text)
+			if record.Metadata["chunk"] != float64(record.Embedding[0]) {
+				t.Errorf("metadata %v does not belong to embedding %v", record.Metadata, record.Embedding)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ExportDocuments returned error: %v", err)
+	}
+	want := []string{"chunk 3", "chunk 7", "chunk 8", "chunk 12", "chunk 20"}
+	if !slices.Equal(texts, want) || sdk.queries != 4 {
+		t.Fatalf("exported %v in %d queries, want %v in 4", texts, sdk.queries, want)
+	}
+}
+
+func TestQdrantExportDocuments(t *testing.T) {
+	var scrolls []map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		scrolls = append(scrolls, body)
+		if body["offset"] == nil {
+			w.Write([]byte(`{"result":{"points":[{"id":"a","vector":[1,0],"payload":{"text":"one","source":"s","metadata":{"page":1}}}],"next_page_offset":"b"}}`))
+			return
+		}
+		w.Write([]byte(`{"result":{"points":[{"id":"b","vector":[0,1],"payload":{"text":"two","source":"s","metadata":{}}}],"next_page_offset":null}}`))
+	}))
+	defer server.Close()
+
+	store := NewQdrantStore(server.URL, "", "docs", NewHashingEmbedder(2), 2)
+	var records []ExportRecord
+	err := store.ExportDocuments(context.Background(), 1, func(batch []ExportRecord) error {
+		records = append(records, batch...)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ExportDocuments returned error: %v", err)
+	}
+	if len(records) != 2 || records[1].Text != "two" || !slices.Equal(records[0].Embedding, []float32{1, 0}) || records[0].Metadata["page"] != float64(1) {
+		t.Fatalf("unexpected records %+v", records)
+	}
+	if scrolls[0]["with_vector"] != true || scrolls[1]["offset"] != "b" {
+		t.Fatalf("unexpected scroll requests %v", scrolls)
+	}
+}
+
+func TestParseVector(t *testing.T) {
+	vector, err := parseVector(formatVector([]float32{1, 0.5, -2}))
+	if err != nil || !slices.Equal(vector, []float32{1, 0.5, -2}) {
+		t.Fatalf("parseVector = %v, %v", vector, err)
+	}
+	if _, err := parseVector("[1,x]"); err == nil {
+		t.Fatal("expected an invalid literal to be rejected")
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Rating is a user's verdict on an answer.
+type Rating string
+
+const (
+	RatingUp   Rating = "up"
+	RatingDown Rating = "down"
+)
+
+// Feedback is a user's response to the answer of a recorded query.
+type Feedback struct {
+	QueryID string
+	Rating  Rating
+	// Correction is the answer the user expected, if they gave one.
+	Correction string
+	Comment    string
+	Time       time.Time
+}
+
+// Validate checks that the feedback names a query and has a known rating.
+func (f Feedback) Validate() error {
+	if f.QueryID == "" {
+		return fmt.Errorf("query ID is required")
+	}
+	if f.Rating != RatingUp && f.Rating != RatingDown {
+		return fmt.Errorf("rating must be %q or %q", RatingUp, RatingDown)
+	}
+	return nil
+}
+
+// ReviewOptions select the queries a feedback report surfaces.
+type ReviewOptions struct {
+	// MinSimilarity flags answers whose best context document scored below
+	// it; 0 disables the check.
+	MinSimilarity float32
+	// Since limits the report to queries after this time; zero means all.
+	Since time.Time
+	// Scan is how many of the most recent queries are examined; 0 means 1000.
+	Scan int
+	// Tenant limits the report to one tenant's queries; empty means all.
+	Tenant string
+	// Roles, unless nil, limits the report to queries whose context documents
+	// a caller with these roles may read.
+	Roles []string
+}
+
+// ReviewItem is a query worth a look, with the reasons it was flagged.
+type ReviewItem struct {
+	Query         QueryRecord
+	TopSimilarity float32 // similarity of the best context document, 0 without context
+	Reasons       []string
+}
+
+// SourceCount is how often a source was in the context of flagged answers.
+type SourceCount struct {
+	Source string
+	Count  int
+}
+
+// FeedbackReport summarizes feedback and weak answers over recent queries.
+type FeedbackReport struct {
+	Queries   int // queries examined
+	Upvotes   int
+	Downvotes int
+	Items     []ReviewItem // flagged queries, newest first
+	// Sources are the sources most often in the context of flagged answers,
+	// most frequent first: candidates for rewriting or splitting.
+	Sources []SourceCount
+}
+
+// ReviewFeedback examines recent queries and flags those with negative
+// feedback, no usable context, or a best context document below
+// MinSimilarity. Questions flagged for missing or weak context usually point
+// at gaps in the corpus; downvoted answers with strong context point at
+// documents that are wrong, stale, or chunked badly.
+func ReviewFeedback(ctx context.Context, history HistoryStore, opts ReviewOptions) (FeedbackReport, error) {
+	var report FeedbackReport
+	if opts.Scan <= 0 {
+		opts.Scan = 1000
+	}
+	records, err := history.ListQueries(ctx, HistoryFilter{Limit: opts.Scan, Tenant: opts.Tenant})
+	if err != nil {
+		return report, err
+	}
+
+	sourceCounts := make(map[string]int)
+	for _, record := range records {
+		if !opts.Since.IsZero() && record.Time.Before(opts.Since) {
+			break // records are newest first
+		}
+		if opts.Roles != nil && !queryReadableBy(record, opts.Roles) {
+			continue
+		}
+		report.Queries++
+		item := ReviewItem{Query: record}
+		for _, doc := range record.Documents {
+			item.TopSimilarity = max(item.TopSimilarity, doc.Similarity)
+		}
+		if record.Feedback != nil {
+			switch record.Feedback.Rating {
+			case RatingUp:
+				report.Upvotes++
+			case RatingDown:
+				report.Downvotes++
+				item.Reasons = append(item.Reasons, "negative feedback")
+			}
+		}
+		switch {
+		case record.Error != "":
+			// Failed queries say nothing about the corpus.
+		case record.NoContext || len(record.Documents) == 0:
+			item.Reasons = append(item.Reasons, "no relevant context")
+		case opts.MinSimilarity > 0 && item.TopSimilarity < opts.MinSimilarity:
+			item.Reasons = append(item.Reasons, fmt.Sprintf("best context %.0f%% similar", item.TopSimilarity*100))
+		}
+		if len(item.Reasons) == 0 {
+			continue
+		}
+		seen := make(map[string]bool)
+		for _, doc := range record.Documents {
+			if !seen[doc.Source] {
+				seen[doc.Source] = true
+				sourceCounts[doc.Source]++
+			}
+		}
+		report.Items = append(report.Items, item)
+	}
+
+	for source, count := range sourceCounts {
+		report.Sources = append(report.Sources, SourceCount{Source: source, Count: count})
+	}
+	sort.Slice(report.Sources, func(i, j int) bool {
+		if report.Sources[i].Count != report.Sources[j].Count {
+			return report.Sources[i].Count > report.Sources[j].Count
+		}
+		return report.Sources[i].Source < report.Sources[j].Source
+	})
+	return report, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReviewFeedback(t *testing.T) {
+	ctx := context.Background()
+	history := newTestHistory(t)
+	start := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	records := []QueryRecord{
+		{ID: "good", Question: "What is Go?", Documents: []Document{{Source: "Go Docs", Similarity: 0.9}}},
+		{ID: "wrong", Question: "Who made Go?", Documents: []Document{{Source: "Go Docs", Similarity: 0.8}, {Source: "Go FAQ", Similarity: 0.7}}},
+		{ID: "weak", Question: "What is Rust?", Documents: []Document{{Source: "Go FAQ", Similarity: 0.3}}},
+		{ID: "empty", Question: "What is Zig?", NoContext: true},
+		{ID: "failed", Question: "What is C?", Error: "boom"},
+	}
+	for i, record := range records {
+		record.Time = start.Add(time.Duration(i) * time.Minute)
+		if err := history.SaveQuery(ctx, record); err != nil {
+			t.Fatalf("SaveQuery: %v", err)
+		}
+	}
+	if err := history.SaveFeedback(ctx, Feedback{QueryID: "good", Rating: RatingUp}); err != nil {
+		t.Fatalf("SaveFeedback: %v", err)
+	}
+	if err := history.SaveFeedback(ctx, Feedback{QueryID: "wrong", Rating: RatingDown, Correction: "Google"}); err != nil {
+		t.Fatalf("SaveFeedback: %v", err)
+	}
+	if err := history.SaveFeedback(ctx, Feedback{QueryID: "missing", Rating: RatingUp}); !errors.Is(err, ErrQueryNotFound) {
+		t.Fatalf("expected ErrQueryNotFound, got %v", err)
+	}
+	record, _ := history.GetQuery(ctx, "wrong")
+	if record.Feedback == nil || record.Feedback.Correction != "Google" || record.Feedback.Time.IsZero() {
+		t.Fatalf("expected the feedback on the record, got %+v", record.Feedback)
+	}
+
+	report, err := ReviewFeedback(ctx, history, ReviewOptions{MinSimilarity: 0.5})
+	if err != nil {
+		t.Fatalf("ReviewFeedback: %v", err)
+	}
+	if report.Queries != 5 || report.Upvotes != 1 || report.Downvotes != 1 {
+		t.Fatalf("unexpected counts %+v", report)
+	}
+	var flagged []string
+	for _, item := range report.Items {
+		flagged = append(flagged, item.Query.ID+": "+strings.Join(item.Reasons, ", "))
+	}
+	want := []string{"empty: no relevant context", "weak: best context 30% similar", "wrong: negative feedback"}
+	if strings.Join(flagged, "; ") != strings.Join(want, "; ") {
+		t.Fatalf("flagged %v, want %v", flagged, want)
+	}
+	if len(report.Sources) != 2 || report.Sources[0] != (SourceCount{Source: "Go FAQ", Count: 2}) {
+		t.Fatalf("unexpected sources %+v", report.Sources)
+	}
+
+	report, _ = ReviewFeedback(ctx, history, ReviewOptions{Since: start.Add(150 * time.Second)})
+	if report.Queries != 2 || len(report.Items) != 1 {
+		t.Fatalf("expected only the queries since the cutoff, got %+v", report)
+	}
+}
+
+func TestServerFeedback(t *testing.T) {
+	server, _ := newTestServer()
+	server.engine.history = newTestHistory(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"question":"Who made Go?"}`))
+	req.Header.Set("X-Request-ID", "req-1")
+	server.ServeHTTP(httptest.NewRecorder(), req)
+
+	cases := []struct {
+		body   string
+		status int
+	}{
+		{`{"query_id":"req-1","rating":"down","correction":"Google made Go."}`, http.StatusNoContent},
+		{`{"query_id":"req-1","rating":"meh"}`, http.StatusBadRequest},
+		{`{"query_id":"other","rating":"up"}`, http.StatusNotFound},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/feedback", strings.NewReader(c.body)))
+		if rec.Code != c.status {
+			t.Errorf("%s: expected %d, got %d: %s", c.body, c.status, rec.Code, rec.Body)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/feedback/report", nil))
+	var report feedbackReportJSON
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatalf("decoding report: %v", err)
+	}
+	if report.Downvotes != 1 || len(report.Flagged) != 1 || report.Flagged[0].Feedback.Correction != "Google made Go." {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
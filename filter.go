@@ -0,0 +1,323 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Condition compares one document field with a literal value. Field is either
+// "source" or the key of a metadata entry.
+type Condition struct {
+	Field string
+	Op    string // one of ==, !=, >, >=, <, <=, readable_by (see ReadableBy), in (see InPartitions), or not_expired (see NotExpired)
+	Value any    // string, float64, or bool; []string for readable_by and in
+}
+
+// Filter scopes retrieval to documents matching every condition. A nil
+// Filter matches all documents. Vector stores translate it to their native
+// filter syntax.
+type Filter []Condition
+
+// Eq is shorthand for an equality condition.
+func Eq(field string, value any) Condition {
+	return Condition{Field: field, Op: "==", Value: value}
+}
+
+// ParseFilter parses expressions such as
+//
+//	source == "Go Docs" and year >= 2023
+//
+// Conditions are joined with "and" (or "&&"). Values may be quoted strings,
+// numbers, true/false, or bare words, which are treated as strings so dates
+// like 2024-01-31 compare lexicographically.
+func ParseFilter(expr string) (Filter, error) {
+	tokens, err := tokenizeFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	var filter Filter
+	for i := 0; i < len(tokens); {
+		if len(tokens)-i < 3 {
+			return nil, fmt.Errorf("incomplete condition in filter %q", expr)
+		}
+		field, op, raw := tokens[i], tokens[i+1], tokens[i+2]
+		if !isFilterOp(op.text) {
+			return nil, fmt.Errorf("unknown operator %q in filter %q", op.text, expr)
+		}
+		if field.quoted || !isFilterIdent(field.text) {
+			return nil, fmt.Errorf("invalid field name %q in filter %q", field.text, expr)
+		}
+		filter = append(filter, Condition{Field: field.text, Op: op.text, Value: raw.value()})
+		i += 3
+
+		if i < len(tokens) {
+			if join := strings.ToLower(tokens[i].text); tokens[i].quoted || (join != "and" && join != "&&") {
+				return nil, fmt.Errorf("expected \"and\" after condition in filter %q", expr)
+			}
+			i++
+			if i == len(tokens) {
+				return nil, fmt.Errorf("dangling \"and\" in filter %q", expr)
+			}
+		}
+	}
+	return filter, nil
+}
+
+// Match reports whether a document satisfies every condition of the filter.
+func (f Filter) Match(doc Document) bool {
+	for _, cond := range f {
+		if cond.Op == opReadableBy {
+			roles, _ := cond.Value.([]string)
+			if !aclAllows(doc.Metadata[cond.Field], roles) {
+				return false
+			}
+			continue
+		}
+		if cond.Op == opNotExpired {
+			now, _ := toFloat(cond.Value)
+			if !expiryAllows(doc.Metadata[cond.Field], now) {
+				return false
+			}
+			continue
+		}
+		var actual any
+		if cond.Field == "source" {
+			actual = doc.Source
+		} else {
+			value, ok := doc.Metadata[cond.Field]
+			if !ok {
+				return false
+			}
+			actual = value
+		}
+		if cond.Op == opIn {
+			values, _ := cond.Value.([]string)
+			if s, ok := actual.(string); !ok || !slices.Contains(values, s) {
+				return false
+			}
+			continue
+		}
+		if !compareFilterValues(actual, cond.Op, cond.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// String renders the filter in the syntax accepted by ParseFilter.
+func (f Filter) String() string {
+	parts := make([]string, len(f))
+	for i, cond := range f {
+		parts[i] = fmt.Sprintf("%s %s %s", cond.Field, cond.Op, formatFilterValue(cond.Value))
+	}
+	return strings.Join(parts, " and ")
+}
+
+// milvusExpr translates the filter into a Milvus boolean expression over the
+// source field and the metadata JSON field. With partitionKey, partition
+// conditions test the collection's partition key field instead, which lets
+// Milvus skip the partitions that cannot match.
+func (f Filter) milvusExpr(partitionKey bool) string {
+	parts := make([]string, len(f))
+	for i, cond := range f {
+		field := cond.Field
+		if field != "source" && !(partitionKey && field == partitionField) {
+			field = fmt.Sprintf("metadata[%s]", strconv.Quote(field))
+		}
+		if cond.Op == opReadableBy {
+			roles, _ := cond.Value.([]string)
+			quoted := make([]string, len(roles))
+			for j, role := range roles {
+				quoted[j] = strconv.Quote(role)
+			}
+			parts[i] = fmt.Sprintf("(not exists %s || json_contains_any(%s, [%s]))", field, field, strings.Join(quoted, ", "))
+			continue
+		}
+		if cond.Op == opNotExpired {
+			parts[i] = fmt.Sprintf("(not exists %s || %s > %s)", field, field, formatFilterValue(cond.Value))
+			continue
+		}
+		parts[i] = fmt.Sprintf("%s %s %s", field, cond.Op, formatFilterValue(cond.Value))
+	}
+	return strings.Join(parts, " && ")
+}
+
+func formatFilterValue(value any) string {
+	switch v := value.(type) {
+	case string:
+		return strconv.Quote(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(v)
+	case []string:
+		quoted := make([]string, len(v))
+		for i, s := range v {
+			quoted[i] = strconv.Quote(s)
+		}
+		return "[" + strings.Join(quoted, ", ") + "]"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// compareFilterValues applies op to two values of compatible types. Numbers
+// are compared numerically, strings lexicographically, and booleans only for
+// (in)equality. Mismatched types never match.
+func compareFilterValues(actual any, op string, expected any) bool {
+	if a, ok := toFloat(actual); ok {
+		b, ok := toFloat(expected)
+		if !ok {
+			return false
+		}
+		return applyOrdering(compareFloats(a, b), op)
+	}
+	if a, ok := actual.(string); ok {
+		b, ok := expected.(string)
+		if !ok {
+			return false
+		}
+		return applyOrdering(strings.Compare(a, b), op)
+	}
+	if a, ok := actual.(bool); ok {
+		b, ok := expected.(bool)
+		if !ok {
+			return false
+		}
+		switch op {
+		case "==":
+			return a == b
+		case "!=":
+			return a != b
+		}
+	}
+	return false
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func applyOrdering(cmp int, op string) bool {
+	switch op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return false
+}
+
+func toFloat(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+type filterToken struct {
+	text   string
+	quoted bool
+}
+
+// value converts a literal token into the Go value it represents.
+func (t filterToken) value() any {
+	if t.quoted {
+		return t.text
+	}
+	switch strings.ToLower(t.text) {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if n, err := strconv.ParseFloat(t.text, 64); err == nil {
+		return n
+	}
+	return t.text
+}
+
+func tokenizeFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"' || r == '\'':
+			end := i + 1
+			var text strings.Builder
+			for ; end < len(runes) && runes[end] != r; end++ {
+				if runes[end] == '\\' && end+1 < len(runes) {
+					end++
+				}
+				text.WriteRune(runes[end])
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("unterminated string in filter %q", expr)
+			}
+			tokens = append(tokens, filterToken{text: text.String(), quoted: true})
+			i = end + 1
+		case strings.ContainsRune("=!<>&", r):
+			end := i
+			for end < len(runes) && strings.ContainsRune("=!<>&", runes[end]) {
+				end++
+			}
+			tokens = append(tokens, filterToken{text: string(runes[i:end])})
+			i = end
+		default:
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) && !strings.ContainsRune("=!<>&\"'", runes[end]) {
+				end++
+			}
+			tokens = append(tokens, filterToken{text: string(runes[i:end])})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+func isFilterOp(op string) bool {
+	switch op {
+	case "==", "!=", ">", ">=", "<", "<=":
+		return true
+	}
+	return false
+}
+
+func isFilterIdent(name string) bool {
+	for i, r := range name {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return name != ""
+}
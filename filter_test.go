@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	filter, err := ParseFilter(`source == "Go Docs" and year >= 2023 && date < 2024-06-01 and draft != true`)
+	if err != nil {
+		t.Fatalf("ParseFilter returned error: %v", err)
+	}
+	expected := Filter{
+		{Field: "source", Op: "==", Value: "Go Docs"},
+		{Field: "year", Op: ">=", Value: float64(2023)},
+		{Field: "date", Op: "<", Value: "2024-06-01"},
+		{Field: "draft", Op: "!=", Value: true},
+	}
+	if len(filter) != len(expected) {
+		t.Fatalf("expected %d conditions, got %d", len(expected), len(filter))
+	}
+	for i := range expected {
+		if filter[i] != expected[i] {
+			t.Fatalf("condition %d expected %+v got %+v", i, expected[i], filter[i])
+		}
+	}
+}
+
+func TestParseFilterRejectsInvalidExpressions(t *testing.T) {
+	for _, expr := range []string{`source =`, `source ~ "x"`, `source == "x" or year > 1`, `source == "x" and`, `"source" == "x"`, `source == "x`} {
+		if _, err := ParseFilter(expr); err == nil {
+			t.Fatalf("expected error for %q", expr)
+		}
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	doc := Document{Source: "Go Docs", Metadata: map[string]any{"year": float64(2024), "date": "2024-03-01"}}
+	cases := []struct {
+		filter Filter
+		want   bool
+	}{
+		{nil, true},
+		{Filter{Eq("source", "Go Docs")}, true},
+		{Filter{Eq("source", "Other")}, false},
+		{Filter{{Field: "year", Op: ">", Value: 2023}}, true},
+		{Filter{{Field: "date", Op: "<", Value: "2024-01-01"}}, false},
+		{Filter{{Field: "missing", Op: "==", Value: "x"}}, false},
+		{Filter{{Field: "year", Op: "==", Value: "2024"}}, false},
+	}
+	for i, c := range cases {
+		if got := c.filter.Match(doc); got != c.want {
+			t.Fatalf("case %d (%s): expected %t got %t", i, c.filter, c.want, got)
+		}
+	}
+}
+
+func TestFilterMilvusExpr(t *testing.T) {
+	filter := Filter{Eq("source", `say "hi"`), {Field: "year", Op: ">", Value: float64(2023)}}
+	expected := `source == "say \"hi\"" && metadata["year"] > 2023`
+	if got := filter.milvusExpr(false); got != expected {
+		t.Fatalf("expected %s got %s", expected, got)
+	}
+}
+
+func TestRetrieveWithFilter(t *testing.T) {
+	mv := NewMemoryStore(NewHashingEmbedder(256))
+	engine := NewRAGEngine(&dummyOpenAI{}, mv)
+	engine.AddDocumentsWithMetadata(context.Background(),
+		[]string{"old", "new"},
+		[]string{"docs", "docs"},
+		[]map[string]any{{"year": float64(2020)}, {"year": float64(2024)}},
+	)
+	docs := engine.Retrieve(context.Background(), "anything", 5, WithFilter(Filter{{Field: "year", Op: ">=", Value: float64(2023)}}))
+	if len(docs) != 1 || docs[0].Text != "new" {
+		t.Fatalf("expected only the 2024 document, got %+v", docs)
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	geminiBaseURL = "https://generativelanguage.googleapis.com/v1beta"
+	// geminiBatchSize is the most texts Gemini embeds per request.
+	geminiBatchSize = 100
+)
+
+// geminiEmbeddingDimensions lists the default dimensions of Gemini embedding
+// models.
+var geminiEmbeddingDimensions = map[string]int{
+	"text-embedding-004":   768,
+	"gemini-embedding-001": 3072,
+}
+
+// GeminiClient talks to the Google Gemini API. It implements both LLMClient
+// and Embedder.
+type GeminiClient struct {
+	apiKey             string
+	baseURL            string
+	embeddingModel     string
+	embeddingDimension int
+	shorten            bool // request embeddingDimension explicitly
+	httpClient         *http.Client
+}
+
+// NewGeminiClient creates a client authenticated with apiKey that embeds with
+// embeddingModel. A dimension of 0 uses the model's default; newer models can
+// also return shortened vectors.
+func NewGeminiClient(apiKey, embeddingModel string, dimension int) (*GeminiClient, error) {
+	g := &GeminiClient{
+		apiKey:         apiKey,
+		baseURL:        geminiBaseURL,
+		embeddingModel: embeddingModel,
+		httpClient:     http.DefaultClient,
+	}
+	if embeddingModel != "" {
+		size, err := embeddingDimension("Gemini", embeddingModel, geminiEmbeddingDimensions, dimension)
+		if err != nil {
+			return nil, err
+		}
+		g.embeddingDimension = size
+		g.shorten = size != geminiEmbeddingDimensions[embeddingModel]
+	}
+	return g, nil
+}
+
+// Ping lists one model, which fails unless the API key is valid.
+func (g *GeminiClient) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(g.baseURL, "/")+"/models?pageSize=1", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("x-goog-api-key", g.apiKey)
+	return pingHTTP(g.httpClient, req, "Gemini")
+}
+
+type geminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	InlineData       *geminiInlineData       `json:"inlineData,omitempty"`
+	FunctionCall     *geminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *geminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type geminiFunctionCall struct {
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+type geminiFunctionResponse struct {
+	Name     string         `json:"name"`
+	Response map[string]any `json:"response"`
+}
+
+type geminiTool struct {
+	FunctionDeclarations []geminiFunctionDeclaration `json:"functionDeclarations"`
+}
+
+type geminiFunctionDeclaration struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Parameters  map[string]any `json:"parameters"`
+}
+
+type geminiInlineData struct {
+	MIMEType string `json:"mimeType"`
+	Data     string `json:"data"` // base64
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiGenerateRequest struct {
+	SystemInstruction *geminiContent          `json:"systemInstruction,omitempty"`
+	Contents          []geminiContent         `json:"contents"`
+	GenerationConfig  *geminiGenerationConfig `json:"generationConfig,omitempty"`
+	Tools             []geminiTool            `json:"tools,omitempty"`
+}
+
+type geminiGenerationConfig struct {
+	ResponseMIMEType string   `json:"responseMimeType,omitempty"`
+	Temperature      *float64 `json:"temperature,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+}
+
+type geminiGenerateResponse struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	UsageMetadata struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+	} `json:"usageMetadata"`
+}
+
+func (g *GeminiClient) ChatCompletion(ctx context.Context, model string, messages []Message) (string, error) {
+	return g.generate(ctx, model, messages, false)
+}
+
+// ChatCompletionJSON asks Gemini for an application/json response, which
+// guarantees syntactically valid JSON; conformance to the schema is checked
+// by the caller.
+func (g *GeminiClient) ChatCompletionJSON(ctx context.Context, model string, messages []Message, schema map[string]any) (string, error) {
+	return g.generate(ctx, model, messages, true)
+}
+
+func (g *GeminiClient) generate(ctx context.Context, model string, messages []Message, jsonMode bool) (string, error) {
+	req := geminiChatRequest(messages)
+	if jsonMode {
+		req.GenerationConfig = &geminiGenerationConfig{ResponseMIMEType: "application/json"}
+	}
+	return g.generateContent(ctx, model, req)
+}
+
+// ChatCompletionTools offers tools as Gemini function declarations. Gemini
+// calls functions by name without call IDs, so the calls of a reply are
+// numbered call_1, call_2, ...
+func (g *GeminiClient) ChatCompletionTools(ctx context.Context, model string, messages []Message, tools []Tool) (Message, error) {
+	req := geminiChatRequest(messages)
+	declarations := make([]geminiFunctionDeclaration, len(tools))
+	for i, tool := range tools {
+		declarations[i] = geminiFunctionDeclaration{Name: tool.Name, Description: tool.Description, Parameters: toolParameters(tool)}
+	}
+	req.Tools = []geminiTool{{FunctionDeclarations: declarations}}
+	content, _, err := g.generateCandidate(ctx, model, req)
+	if err != nil {
+		return Message{}, err
+	}
+	reply := Message{Role: "assistant"}
+	for _, part := range content.Parts {
+		reply.Content += part.Text
+		if part.FunctionCall != nil {
+			reply.ToolCalls = append(reply.ToolCalls, ToolCall{
+				ID:        fmt.Sprintf("call_%d", len(reply.ToolCalls)+1),
+				Name:      part.FunctionCall.Name,
+				Arguments: part.FunctionCall.Args,
+			})
+		}
+	}
+	return reply, nil
+}
+
+// geminiChatRequest converts messages to a request. Gemini takes the system
+// prompt as a separate instruction, calls the assistant role "model", and
+// takes consecutive function results as the parts of one user turn.
+func geminiChatRequest(messages []Message) geminiGenerateRequest {
+	var req geminiGenerateRequest
+	var system []string
+	for i, msg := range messages {
+		switch msg.Role {
+		case "system":
+			system = append(system, msg.Content)
+		case "assistant":
+			var parts []geminiPart
+			if msg.Content != "" || len(msg.ToolCalls) == 0 {
+				parts = append(parts, geminiPart{Text: msg.Content})
+			}
+			for _, call := range msg.ToolCalls {
+				parts = append(parts, geminiPart{FunctionCall: &geminiFunctionCall{Name: call.Name, Args: toolArguments(call.Arguments)}})
+			}
+			req.Contents = append(req.Contents, geminiContent{Role: "model", Parts: parts})
+		case "tool":
+			part := geminiPart{FunctionResponse: &geminiFunctionResponse{
+				Name:     toolCallName(messages[:i], msg.ToolCallID),
+				Response: map[string]any{"content": msg.Content},
+			}}
+			if last := len(req.Contents) - 1; last >= 0 && req.Contents[last].Parts[0].FunctionResponse != nil {
+				req.Contents[last].Parts = append(req.Contents[last].Parts, part)
+				continue
+			}
+			req.Contents = append(req.Contents, geminiContent{Role: "user", Parts: []geminiPart{part}})
+		default:
+			req.Contents = append(req.Contents, geminiContent{Role: "user", Parts: []geminiPart{{Text: msg.Content}}})
+		}
+	}
+	if len(system) > 0 {
+		req.SystemInstruction = &geminiContent{Parts: []geminiPart{{Text: strings.Join(system, "\n\n")}}}
+	}
+	return req
+}
+
+// DescribeImage sends the image inline, ahead of prompt.
+func (g *GeminiClient) DescribeImage(ctx context.Context, model string, image Image, prompt string) (string, error) {
+	parts := []geminiPart{
+		{InlineData: &geminiInlineData{MIMEType: image.MIMEType, Data: base64.StdEncoding.EncodeToString(image.Data)}},
+		{Text: prompt},
+	}
+	return g.generateContent(ctx, model, geminiGenerateRequest{Contents: []geminiContent{{Role: "user", Parts: parts}}})
+}
+
+// generateContent sends a generateContent request and returns the reply text.
+func (g *GeminiClient) generateContent(ctx context.Context, model string, req geminiGenerateRequest) (string, error) {
+	content, finishReason, err := g.generateCandidate(ctx, model, req)
+	if err != nil {
+		return "", err
+	}
+	var text strings.Builder
+	for _, part := range content.Parts {
+		text.WriteString(part.Text)
+	}
+	if text.Len() == 0 {
+		return "", fmt.Errorf("no response from Gemini (finish reason %s)", finishReason)
+	}
+	return text.String(), nil
+}
+
+// generateCandidate sends a generateContent request and returns the content
+// of the first candidate, with the reason generation stopped.
+func (g *GeminiClient) generateCandidate(ctx context.Context, model string, req geminiGenerateRequest) (geminiContent, string, error) {
+	if seed, ok := deterministicSeed(ctx); ok {
+		config := geminiGenerationConfig{Temperature: new(float64), Seed: &seed}
+		if req.GenerationConfig != nil {
+			config.ResponseMIMEType = req.GenerationConfig.ResponseMIMEType
+		}
+		req.GenerationConfig = &config
+	}
+	var resp geminiGenerateResponse
+	if err := g.post(ctx, "/models/"+url.PathEscape(model)+":generateContent", req, &resp); err != nil {
+		return geminiContent{}, "", err
+	}
+	recordTokenUsage(ctx, resp.UsageMetadata.PromptTokenCount, resp.UsageMetadata.CandidatesTokenCount)
+	if len(resp.Candidates) == 0 {
+		return geminiContent{}, "", fmt.Errorf("no response from Gemini")
+	}
+	return resp.Candidates[0].Content, resp.Candidates[0].FinishReason, nil
+}
+
+type geminiEmbedRequest struct {
+	Model                string        `json:"model"`
+	Content              geminiContent `json:"content"`
+	TaskType             string        `json:"taskType"`
+	OutputDimensionality int           `json:"outputDimensionality,omitempty"`
+}
+
+type geminiBatchEmbedResponse struct {
+	Embeddings []struct {
+		Values []float32 `json:"values"`
+	} `json:"embeddings"`
+}
+
+func (g *GeminiClient) Dimension() int {
+	return g.embeddingDimension
+}
+
+// Embed embeds texts as retrieval documents, or as retrieval queries under a
+// query context. Gemini does not report embedding tokens, so they are
+// estimated from the text length.
+func (g *GeminiClient) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	taskType := "RETRIEVAL_DOCUMENT"
+	if isQueryEmbedding(ctx) {
+		taskType = "RETRIEVAL_QUERY"
+	}
+	model := "models/" + g.embeddingModel
+
+	var tokens int
+	embeddings, err := embedInBatches(texts, geminiBatchSize, func(batch []string) ([][]float32, error) {
+		requests := make([]geminiEmbedRequest, len(batch))
+		for i, text := range batch {
+			requests[i] = geminiEmbedRequest{Model: model, Content: geminiContent{Parts: []geminiPart{{Text: text}}}, TaskType: taskType}
+			if g.shorten {
+				requests[i].OutputDimensionality = g.embeddingDimension
+			}
+			tokens += EstimateTokens(text)
+		}
+		var resp geminiBatchEmbedResponse
+		if err := g.post(ctx, "/"+model+":batchEmbedContents", map[string]any{"requests": requests}, &resp); err != nil {
+			return nil, err
+		}
+		if len(resp.Embeddings) != len(batch) {
+			return nil, fmt.Errorf("expected %d embeddings from Gemini, got %d", len(batch), len(resp.Embeddings))
+		}
+		embeddings := make([][]float32, len(batch))
+		for i, item := range resp.Embeddings {
+			embeddings[i] = item.Values
+		}
+		return embeddings, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	recordTokenUsage(ctx, tokens, 0)
+	return embeddings, nil
+}
+
+// post sends a JSON request to the Gemini API and decodes the JSON reply.
+func (g *GeminiClient) post(ctx context.Context, path string, payload, out any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(g.baseURL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-goog-api-key", g.apiKey)
+
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error.Message != "" {
+			return fmt.Errorf("Gemini API error (status %d): %s", resp.StatusCode, apiErr.Error.Message)
+		}
+		return fmt.Errorf("Gemini API error (status %d)", resp.StatusCode)
+	}
+	return json.Unmarshal(data, out)
+}
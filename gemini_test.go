@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newGeminiTestServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-goog-api-key") != "test-key" {
+			t.Errorf("api key header not set")
+		}
+		switch r.URL.Path {
+		case "/models/gemini-2.0-flash:generateContent":
+			var req geminiGenerateRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Errorf("decoding generate request: %v", err)
+			}
+			if req.SystemInstruction == nil || req.SystemInstruction.Parts[0].Text != "be nice" {
+				t.Errorf("expected the system prompt as systemInstruction, got %+v", req.SystemInstruction)
+			}
+			if len(req.Contents) != 3 || req.Contents[1].Role != "model" {
+				t.Errorf("expected assistant turns with the model role, got %+v", req.Contents)
+			}
+			w.Write([]byte(`{"candidates":[{"content":{"role":"model","parts":[{"text":"hello"}]}}],"usageMetadata":{"promptTokenCount":5,"candidatesTokenCount":1}}`))
+		case "/models/text-embedding-004:batchEmbedContents":
+			var req struct {
+				Requests []geminiEmbedRequest `json:"requests"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Errorf("decoding embed request: %v", err)
+			}
+			if len(req.Requests) != 2 || req.Requests[0].TaskType != "RETRIEVAL_QUERY" || req.Requests[0].Model != "models/text-embedding-004" {
+				t.Errorf("unexpected embed requests %+v", req.Requests)
+			}
+			w.Write([]byte(`{"embeddings":[{"values":[0.1,0.2]},{"values":[0.3,0.4]}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"code":404,"message":"model not found","status":"NOT_FOUND"}}`))
+		}
+	}))
+}
+
+func TestGeminiClientChatCompletion(t *testing.T) {
+	server := newGeminiTestServer(t)
+	defer server.Close()
+
+	client, err := NewGeminiClient("test-key", "", 0)
+	if err != nil {
+		t.Fatalf("NewGeminiClient returned error: %v", err)
+	}
+	client.baseURL = server.URL
+	resp, err := client.ChatCompletion(context.Background(), "gemini-2.0-flash", []Message{
+		{Role: "system", Content: "be nice"},
+		{Role: "user", Content: "hi"},
+		{Role: "assistant", Content: "hello"},
+		{Role: "user", Content: "again"},
+	})
+	if err != nil {
+		t.Fatalf("ChatCompletion returned error: %v", err)
+	}
+	if resp != "hello" {
+		t.Fatalf("unexpected response: %s", resp)
+	}
+
+	if _, err := client.ChatCompletion(context.Background(), "missing-model", []Message{{Role: "user", Content: "hi"}}); err == nil {
+		t.Fatalf("expected error for unknown model")
+	}
+}
+
+func TestGeminiClientEmbed(t *testing.T) {
+	server := newGeminiTestServer(t)
+	defer server.Close()
+
+	client, err := NewGeminiClient("test-key", "text-embedding-004", 0)
+	if err != nil {
+		t.Fatalf("NewGeminiClient returned error: %v", err)
+	}
+	client.baseURL = server.URL
+	if client.Dimension() != 768 {
+		t.Fatalf("expected the model's dimension, got %d", client.Dimension())
+	}
+	embeddings, err := client.Embed(withQueryEmbedding(context.Background()), []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("Embed returned error: %v", err)
+	}
+	if len(embeddings) != 2 || embeddings[1][0] != 0.3 {
+		t.Fatalf("unexpected embeddings %v", embeddings)
+	}
+
+	if _, err := NewGeminiClient("test-key", "unknown-model", 0); err == nil {
+		t.Fatalf("expected error for unknown embedding model without a dimension")
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const githubAPIURL = "https://api.github.com"
+
+// GitHubRepo reads the files of a GitHub repository at a branch, tag, or
+// commit through the REST API, so no local clone is needed.
+type GitHubRepo struct {
+	owner      string
+	name       string
+	ref        string
+	token      string
+	apiURL     string
+	httpClient *http.Client
+}
+
+// NewGitHubRepo creates a reader for owner/name at ref, or at the default
+// branch when ref is empty. A token is needed for private repositories and
+// raises the API rate limit.
+func NewGitHubRepo(owner, name, ref, token string) *GitHubRepo {
+	return &GitHubRepo{owner: owner, name: name, ref: ref, token: token, apiURL: githubAPIURL, httpClient: http.DefaultClient}
+}
+
+// ParseGitHubRepo parses owner/repo, owner/repo@ref, or a github.com URL such
+// as https://github.com/owner/repo/tree/ref.
+func ParseGitHubRepo(spec string) (owner, name, ref string, err error) {
+	spec = strings.TrimSpace(spec)
+	if u, parseErr := url.Parse(spec); parseErr == nil && u.Host != "" {
+		if u.Host != "github.com" && u.Host != "www.github.com" {
+			return "", "", "", fmt.Errorf("invalid GitHub repository %q: not a github.com URL", spec)
+		}
+		parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+		if len(parts) >= 4 && parts[2] == "tree" {
+			ref = strings.Join(parts[3:], "/")
+		}
+		spec = strings.Join(parts[:min(len(parts), 2)], "/")
+	} else if repo, at, ok := strings.Cut(spec, "@"); ok {
+		spec, ref = repo, at
+	}
+	owner, name, ok := strings.Cut(strings.TrimSuffix(spec, ".git"), "/")
+	if !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+		return "", "", "", fmt.Errorf("invalid GitHub repository %q (expected owner/repo[@ref])", spec)
+	}
+	return owner, name, ref, nil
+}
+
+// FullName returns owner/repo.
+func (g *GitHubRepo) FullName() string {
+	return g.owner + "/" + g.name
+}
+
+type githubTreeEntry struct {
+	Path string `json:"path"`
+	Type string `json:"type"`
+	SHA  string `json:"sha"`
+	Size int64  `json:"size"`
+}
+
+// IngestGitHubRepo ingests the supported files of the repository (README and
+// docs in Markdown, source code, PDFs, and the other types LoadFile reads),
+// each with the chunker for its type, in parallel like IngestDirectory.
+// Include and Exclude patterns are matched against each file's path and name,
+// and hidden files and directories are skipped. Each file's source is
+// github.com/owner/repo/path, which stays the same across commits so that
+// re-ingesting replaces the file's chunks, and its metadata records repo,
+// path, commit, and url, a link to the file at that commit. Code chunks also
+// carry line_start and line_end, so citations link to the exact lines.
+func IngestGitHubRepo(ctx context.Context, engine *RAGEngine, repo *GitHubRepo, opts DirectoryOptions) (DirectoryReport, error) {
+	start := time.Now()
+	var report DirectoryReport
+	commit, err := repo.resolve(ctx)
+	if err != nil {
+		return report, fmt.Errorf("resolving %s: %w", repo.FullName(), err)
+	}
+	var tree struct {
+		Tree      []githubTreeEntry `json:"tree"`
+		Truncated bool              `json:"truncated"`
+	}
+	if err := repo.get(ctx, "/git/trees/"+commit+"?recursive=1", &tree); err != nil {
+		return report, fmt.Errorf("listing %s: %w", repo.FullName(), err)
+	}
+	if tree.Truncated {
+		slog.WarnContext(ctx, "Repository tree is too large to list completely; some files are missing", "repo", repo.FullName())
+	}
+
+	blobs := make(map[string]string)
+	var paths []string
+	for _, entry := range tree.Tree {
+		if entry.Type != "blob" {
+			continue
+		}
+		if hiddenPath(entry.Path) || !supportedFile(entry.Path) || !selected(entry.Path, opts.Include, opts.Exclude) {
+			report.Skipped++
+			continue
+		}
+		if entry.Size > maxObjectBytes {
+			report.Errors = append(report.Errors, FileError{Path: entry.Path, Err: fmt.Errorf("file is larger than %d bytes", maxObjectBytes)})
+			continue
+		}
+		blobs[entry.Path] = entry.SHA
+		paths = append(paths, entry.Path)
+	}
+	slog.InfoContext(ctx, "Found files to ingest", "repo", repo.FullName(), "commit", commit, "files", len(paths), "skipped", report.Skipped)
+
+	ingestAll(ctx, paths, opts.Workers, &report, func(path string) (IngestReport, error) {
+		body, err := repo.open(ctx, "/git/blobs/"+blobs[path], "application/vnd.github.raw")
+		if err != nil {
+			return IngestReport{}, err
+		}
+		defer body.Close()
+		pages, err := loadDownload(body, path)
+		if err != nil {
+			return IngestReport{}, err
+		}
+		for i := range pages {
+			pages[i].Source = "github.com/" + repo.FullName() + "/" + path
+			meta := map[string]any{
+				"repo":   repo.FullName(),
+				"path":   path,
+				"commit": commit,
+				"url":    "https://github.com/" + repo.FullName() + "/blob/" + commit + "/" + path,
+			}
+			for k, v := range pages[i].Metadata {
+				meta[k] = v
+			}
+			pages[i].Metadata = meta
+		}
+		chunks, ok := ingestPages(ctx, engine, pages, opts.ChunkSize, opts.Overlap)
+		if !ok {
+			return chunks, fmt.Errorf("storing chunks failed (%s)", chunks)
+		}
+		return chunks, nil
+	})
+	report.Duration = time.Since(start)
+	return report, ctx.Err()
+}
+
+// resolve returns the SHA of the commit the ref, or the default branch,
+// points to.
+func (g *GitHubRepo) resolve(ctx context.Context) (string, error) {
+	ref := g.ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	var commit struct {
+		SHA string `json:"sha"`
+	}
+	segments := strings.Split(ref, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	if err := g.get(ctx, "/commits/"+strings.Join(segments, "/"), &commit); err != nil {
+		return "", err
+	}
+	return commit.SHA, nil
+}
+
+// get requests a repository API path and decodes the JSON reply.
+func (g *GitHubRepo) get(ctx context.Context, path string, out any) error {
+	body, err := g.open(ctx, path, "application/vnd.github+json")
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return json.NewDecoder(body).Decode(out)
+}
+
+// open requests a repository API path and returns the response body.
+func (g *GitHubRepo) open(ctx context.Context, path, accept string) (io.ReadCloser, error) {
+	endpoint := strings.TrimRight(g.apiURL, "/") + "/repos/" + url.PathEscape(g.owner) + "/" + url.PathEscape(g.name) + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if g.token != "" {
+		req.Header.Set("Authorization", "Bearer "+g.token)
+	}
+
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Message != "" {
+			return nil, fmt.Errorf("GitHub API error (status %d): %s", resp.StatusCode, apiErr.Message)
+		}
+		return nil, fmt.Errorf("GitHub API error (status %d)", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
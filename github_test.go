@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseGitHubRepo(t *testing.T) {
+	cases := map[string][3]string{
+		"acme/api":                                   {"acme", "api", ""},
+		"acme/api@v1.2.0":                            {"acme", "api", "v1.2.0"},
+		"https://github.com/acme/api":                {"acme", "api", ""},
+		"https://github.com/acme/api.git":            {"acme", "api", ""},
+		"https://github.com/acme/api/tree/feature/x": {"acme", "api", "feature/x"},
+	}
+	for spec, want := range cases {
+		owner, name, ref, err := ParseGitHubRepo(spec)
+		if err != nil || owner != want[0] || name != want[1] || ref != want[2] {
+			t.Errorf("ParseGitHubRepo(%q) = %q, %q, %q, %v; want %v", spec, owner, name, ref, err, want)
+		}
+	}
+	for _, spec := range []string{"acme", "https://gitlab.com/acme/api", "acme/api/extra"} {
+		if _, _, _, err := ParseGitHubRepo(spec); err == nil {
+			t.Errorf("expected error for %q", spec)
+		}
+	}
+}
+
+func TestIngestGitHubRepo(t *testing.T) {
+	files := map[string]string{
+		"blob-readme": "# API\n\nThe API serves orders.",
+		"blob-main":   "package main\n\n// Serve starts the server.\nfunc Serve() {}\n",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer gh-token" {
+			t.Errorf("expected the token to be sent")
+		}
+		switch {
+		case r.URL.Path == "/repos/acme/api/commits/release/v2":
+			w.Write([]byte(`{"sha":"abc123"}`))
+		case r.URL.Path == "/repos/acme/api/git/trees/abc123" && r.URL.Query().Get("recursive") == "1":
+			w.Write([]byte(`{"truncated":false,"tree":[
+				{"path":"README.md","type":"blob","sha":"blob-readme","size":30},
+				{"path":"cmd","type":"tree","sha":"t1"},
+				{"path":"cmd/main.go","type":"blob","sha":"blob-main","size":60},
+				{"path":"logo.svg","type":"blob","sha":"blob-logo","size":10},
+				{"path":".github/workflows/ci.yml","type":"blob","sha":"blob-ci","size":10},
+				{"path":"docs/missing.md","type":"blob","sha":"blob-missing","size":10}
+			]}`))
+		case strings.HasPrefix(r.URL.Path, "/repos/acme/api/git/blobs/"):
+			if r.Header.Get("Accept") != "application/vnd.github.raw" {
+				t.Errorf("expected a raw blob request")
+			}
+			content, ok := files[strings.TrimPrefix(r.URL.Path, "/repos/acme/api/git/blobs/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"message":"Not Found"}`))
+				return
+			}
+			w.Write([]byte(content))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"Not Found"}`))
+		}
+	}))
+	defer server.Close()
+
+	repo := NewGitHubRepo("acme", "api", "release/v2", "gh-token")
+	repo.apiURL = server.URL
+	store := NewMemoryStore(NewHashingEmbedder(64))
+	engine := NewRAGEngine(&dummyOpenAI{}, store)
+
+	report, err := IngestGitHubRepo(context.Background(), engine, repo, DirectoryOptions{Workers: 2, ChunkSize: 1000})
+	if err != nil {
+		t.Fatalf("IngestGitHubRepo returned error: %v", err)
+	}
+	if report.Files != 2 || report.Skipped != 2 || len(report.Errors) != 1 || report.Errors[0].Path != "docs/missing.md" {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	docs := store.SearchSimilar(context.Background(), "Serve", 10, Filter{Eq("source", "github.com/acme/api/cmd/main.go")})
+	if len(docs) == 0 {
+		t.Fatalf("expected chunks for cmd/main.go")
+	}
+	meta := docs[0].Metadata
+	if meta["commit"] != "abc123" || meta["path"] != "cmd/main.go" || meta["repo"] != "acme/api" || meta["language"] != "go" {
+		t.Fatalf("unexpected metadata %v", meta)
+	}
+	if url := citationURL(meta); !strings.HasPrefix(url, "https://github.com/acme/api/blob/abc123/cmd/main.go#L") {
+		t.Fatalf("expected a link to the chunk's lines, got %q", url)
+	}
+
+	missing := NewGitHubRepo("acme", "missing", "", "gh-token")
+	missing.apiURL = server.URL
+	if _, err := IngestGitHubRepo(context.Background(), engine, missing, DirectoryOptions{}); err == nil {
+		t.Fatalf("expected error for an unknown repository")
+	}
+}
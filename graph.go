@@ -0,0 +1,408 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// maxGraphHops caps how far WithGraphExpansion follows relations.
+	maxGraphHops = 2
+	// maxGraphRelations caps the relations one graph expansion follows.
+	maxGraphRelations = 50
+	// maxChunkRelations caps the relations extracted from one chunk.
+	maxChunkRelations = 20
+	// graphRelationField is the metadata field naming the relation a
+	// document was pulled in for by graph expansion.
+	graphRelationField = "graph_relation"
+)
+
+// Relation is one edge of the knowledge graph: Subject Predicate Object, as
+// stated in a chunk of Source.
+type Relation struct {
+	Subject   string
+	Predicate string
+	Object    string
+	Source    string
+	ChunkHash string // content_hash of the chunk that states it
+}
+
+func (rel Relation) String() string {
+	return rel.Subject + " " + rel.Predicate + " " + rel.Object
+}
+
+// GraphStore holds the knowledge graph extracted from the ingested chunks,
+// separately per tenant.
+type GraphStore interface {
+	AddRelations(ctx context.Context, tenant string, relations []Relation) error
+	// MatchEntities returns the entities of the graph named in text.
+	MatchEntities(ctx context.Context, tenant, text string) ([]string, error)
+	// Neighbors returns the relations reachable from entities in at most
+	// hops steps, nearest first, up to limit.
+	Neighbors(ctx context.Context, tenant string, entities []string, hops, limit int) ([]Relation, error)
+	// DeleteRelations removes the relations stated in the given chunks of
+	// source, or in all of its chunks if hashes is nil.
+	DeleteRelations(ctx context.Context, tenant, source string, hashes []string) error
+}
+
+// WithGraph extracts entities and relations from every chunk ingested with
+// ingestPages into graph, with model, for graph expansion at query time (see
+// WithGraphExpansion). It costs one LLM call per new or changed chunk.
+func WithGraph(graph GraphStore, model string) EngineOption {
+	return func(r *RAGEngine) {
+		r.graph = graph
+		r.graphModel = model
+	}
+}
+
+// WithGraphExpansion adds to the search results the chunks that state the
+// relations of the entities named in the query, following relations up to
+// hops steps away. This finds the context of multi-hop questions, such as
+// "who manages the team that owns the billing service?", that no single
+// search retrieves. It needs WithGraph.
+func WithGraphExpansion(hops int) RetrieveOption {
+	return func(c *retrieveConfig) {
+		c.graphHops = min(hops, maxGraphHops)
+	}
+}
+
+// extractGraph adds the relations stated in the chunks to the graph. Failures
+// are logged and do not fail ingestion.
+func (r *RAGEngine) extractGraph(ctx context.Context, texts, sources []string, metadata []map[string]any) {
+	ctx, span := tracer.Start(ctx, "rag.graph_extract", trace.WithAttributes(attribute.Int("rag.chunks", len(texts))))
+	defer span.End()
+
+	var relations []Relation
+	for i, text := range texts {
+		hash, _ := metadata[i]["content_hash"].(string)
+		extracted, err := r.extractRelations(ctx, text)
+		if err != nil {
+			errorsTotal.WithLabelValues("graph").Inc()
+			slog.WarnContext(ctx, "Extracting relations failed", "source", sources[i], "error", err)
+			continue
+		}
+		for _, rel := range extracted {
+			rel.Source, rel.ChunkHash = sources[i], hash
+			relations = append(relations, rel)
+		}
+	}
+	span.SetAttributes(attribute.Int("rag.relations", len(relations)))
+	if len(relations) == 0 {
+		return
+	}
+	if err := r.graph.AddRelations(ctx, r.tenant, relations); err != nil {
+		endSpan(span, err)
+		errorsTotal.WithLabelValues("graph").Inc()
+		slog.ErrorContext(ctx, "Storing relations failed", "error", err)
+		return
+	}
+	slog.InfoContext(ctx, "Extracted relations", "chunks", len(texts), "relations", len(relations))
+}
+
+// extractRelations asks the LLM for the entity relations text states.
+func (r *RAGEngine) extractRelations(ctx context.Context, text string) ([]Relation, error) {
+	prompt := fmt.Sprintf("List the relations between named entities (people, teams, organizations, products, "+
+		"services, places, documents, concepts) that the text below states. Write one relation per line as "+
+		"\"subject | relation | object\", with short relations such as \"owns\", \"manages\", \"depends on\", or "+
+		"\"is part of\", and entity names as they appear in the text. List at most %d; reply with nothing if there are none.\n\nText:\n%s",
+		maxChunkRelations, text)
+	messages := []Message{
+		{Role: "system", Content: "You extract knowledge graph triples from documents."},
+		{Role: "user", Content: prompt},
+	}
+	response, err := r.llm.ChatCompletion(ctx, r.graphModel, messages)
+	if err != nil {
+		return nil, err
+	}
+	var relations []Relation
+	for _, line := range strings.Split(response, "\n") {
+		parts := strings.Split(strings.TrimLeft(strings.TrimSpace(line), "0123456789.)-*• "), "|")
+		if len(parts) != 3 {
+			continue
+		}
+		rel := Relation{
+			Subject:   strings.Trim(strings.TrimSpace(parts[0]), "\""),
+			Predicate: strings.TrimSpace(parts[1]),
+			Object:    strings.Trim(strings.TrimSpace(parts[2]), "\"."),
+		}
+		if rel.Subject == "" || rel.Predicate == "" || rel.Object == "" || strings.EqualFold(rel.Subject, rel.Object) {
+			continue
+		}
+		relations = append(relations, rel)
+		if len(relations) == maxChunkRelations {
+			break
+		}
+	}
+	return relations, nil
+}
+
+// graphDocuments returns the chunks stating the relations around the
+// entities named in query, each marked with its relation.
+func (r *RAGEngine) graphDocuments(ctx context.Context, query string, cfg retrieveConfig) []Document {
+	ctx, span := tracer.Start(ctx, "rag.graph_expand", trace.WithAttributes(attribute.Int("rag.graph_hops", cfg.graphHops)))
+	defer span.End()
+
+	entities, err := r.graph.MatchEntities(ctx, r.tenant, query)
+	if err == nil && len(entities) > 0 {
+		var relations []Relation
+		relations, err = r.graph.Neighbors(ctx, r.tenant, entities, cfg.graphHops, maxGraphRelations)
+		if err == nil {
+			docs := r.relationChunks(ctx, relations, cfg.filter)
+			span.SetAttributes(attribute.Int("rag.entities", len(entities)), attribute.Int("rag.relations", len(relations)), attribute.Int("rag.documents", len(docs)))
+			slog.DebugContext(ctx, "Expanded query through the knowledge graph", "entities", entities, "relations", len(relations), "documents", len(docs))
+			return docs
+		}
+	}
+	if err != nil {
+		endSpan(span, err)
+		errorsTotal.WithLabelValues("graph").Inc()
+		slog.WarnContext(ctx, "Graph expansion failed, using the search results alone", "error", err)
+	}
+	return nil
+}
+
+// relationChunks looks up the chunk stating each relation in the vector
+// store, within filter, so the caller's tenant, roles, and filters apply.
+func (r *RAGEngine) relationChunks(ctx context.Context, relations []Relation, filter Filter) []Document {
+	var docs []Document
+	seen := make(map[string]bool)
+	for _, rel := range relations {
+		chunk := rel.Source + "\x00" + rel.ChunkHash
+		if seen[chunk] {
+			continue
+		}
+		seen[chunk] = true
+		chunkFilter := append(slices.Clip(filter), Eq("source", rel.Source), Eq("content_hash", rel.ChunkHash))
+		found, err := r.search(ctx, rel.String(), 1, chunkFilter)
+		if err != nil {
+			return docs
+		}
+		for _, doc := range found {
+			doc.Metadata = maps.Clone(doc.Metadata)
+			if doc.Metadata == nil {
+				doc.Metadata = map[string]any{}
+			}
+			doc.Metadata[graphRelationField] = rel.String()
+			docs = append(docs, doc)
+		}
+	}
+	return docs
+}
+
+// deleteGraphRelations removes the relations of the given chunks of source,
+// or of the whole source if hashes is nil, when the chunks are removed.
+func (r *RAGEngine) deleteGraphRelations(ctx context.Context, source string, hashes []string) {
+	if r.graph == nil {
+		return
+	}
+	if err := r.graph.DeleteRelations(ctx, r.tenant, source, hashes); err != nil {
+		errorsTotal.WithLabelValues("graph").Inc()
+		slog.ErrorContext(ctx, "Removing relations failed", "source", source, "error", err)
+	}
+}
+
+// SQLiteGraph is a GraphStore in a SQLite database file.
+type SQLiteGraph struct {
+	db *sql.DB
+
+	once    sync.Once
+	initErr error
+}
+
+// NewSQLiteGraph opens the graph database at path. The file and its tables
+// are created on first use.
+func NewSQLiteGraph(path string) (*SQLiteGraph, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, fmt.Errorf("opening graph database: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+	return &SQLiteGraph{db: db}, nil
+}
+
+// Close closes the database.
+func (g *SQLiteGraph) Close() error {
+	return g.db.Close()
+}
+
+// ensureSchema creates the tables on first use.
+func (g *SQLiteGraph) ensureSchema(ctx context.Context) error {
+	g.once.Do(func() {
+		_, g.initErr = g.db.ExecContext(ctx, `
+CREATE TABLE IF NOT EXISTS relations (
+	tenant      TEXT NOT NULL,
+	subject     TEXT NOT NULL,
+	predicate   TEXT NOT NULL,
+	object      TEXT NOT NULL,
+	subject_key TEXT NOT NULL,
+	object_key  TEXT NOT NULL,
+	source      TEXT NOT NULL,
+	chunk_hash  TEXT NOT NULL,
+	created     INTEGER NOT NULL,
+	UNIQUE (tenant, subject_key, predicate, object_key, source, chunk_hash)
+);
+CREATE INDEX IF NOT EXISTS relations_subject_idx ON relations (tenant, subject_key);
+CREATE INDEX IF NOT EXISTS relations_object_idx ON relations (tenant, object_key);
+CREATE INDEX IF NOT EXISTS relations_source_idx ON relations (tenant, source, chunk_hash);`)
+	})
+	return g.initErr
+}
+
+// entityKey normalizes an entity name for matching.
+func entityKey(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(name)), " ")
+}
+
+func (g *SQLiteGraph) AddRelations(ctx context.Context, tenant string, relations []Relation) error {
+	if err := g.ensureSchema(ctx); err != nil {
+		return err
+	}
+	tx, err := g.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().Unix()
+	for _, rel := range relations {
+		if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO relations
+			(tenant, subject, predicate, object, subject_key, object_key, source, chunk_hash, created)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			tenant, rel.Subject, rel.Predicate, rel.Object, entityKey(rel.Subject), entityKey(rel.Object), rel.Source, rel.ChunkHash, now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// MatchEntities finds the graph's entity names in text as whole words,
+// ignoring case. The longest names are matched first, and names inside a
+// longer match are skipped.
+func (g *SQLiteGraph) MatchEntities(ctx context.Context, tenant, text string) ([]string, error) {
+	if err := g.ensureSchema(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := g.db.QueryContext(ctx, `SELECT subject_key FROM relations WHERE tenant = ?
+		UNION SELECT object_key FROM relations WHERE tenant = ?`, tenant, tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(names, func(a, b string) int { return len(b) - len(a) })
+
+	text = " " + strings.Join(strings.Fields(strings.ToLower(nonWord.ReplaceAllString(text, " "))), " ") + " "
+	var matched []string
+	for _, name := range names {
+		key := " " + strings.Join(strings.Fields(nonWord.ReplaceAllString(name, " ")), " ") + " "
+		if strings.TrimSpace(key) == "" || !strings.Contains(text, key) {
+			continue
+		}
+		matched = append(matched, name)
+		text = strings.ReplaceAll(text, key, "  ")
+	}
+	return matched, nil
+}
+
+// nonWord matches the characters entity matching ignores.
+var nonWord = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+
+// Neighbors walks the graph breadth first from entities.
+func (g *SQLiteGraph) Neighbors(ctx context.Context, tenant string, entities []string, hops, limit int) ([]Relation, error) {
+	if err := g.ensureSchema(ctx); err != nil {
+		return nil, err
+	}
+	visited := make(map[string]bool)
+	frontier := make([]string, 0, len(entities))
+	for _, entity := range entities {
+		if key := entityKey(entity); !visited[key] {
+			visited[key] = true
+			frontier = append(frontier, key)
+		}
+	}
+	var relations []Relation
+	seen := make(map[Relation]bool)
+	for hop := 0; hop < hops && len(frontier) > 0 && len(relations) < limit; hop++ {
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(frontier)), ",")
+		args := []any{tenant}
+		for range 2 {
+			for _, key := range frontier {
+				args = append(args, key)
+			}
+		}
+		args = append(args, limit-len(relations))
+		rows, err := g.db.QueryContext(ctx, `SELECT subject, predicate, object, subject_key, object_key, source, chunk_hash
+			FROM relations WHERE tenant = ? AND (subject_key IN (`+placeholders+`) OR object_key IN (`+placeholders+`))
+			ORDER BY created, rowid LIMIT ?`, args...)
+		if err != nil {
+			return nil, err
+		}
+		var next []string
+		for rows.Next() {
+			var rel Relation
+			var subjectKey, objectKey string
+			if err := rows.Scan(&rel.Subject, &rel.Predicate, &rel.Object, &subjectKey, &objectKey, &rel.Source, &rel.ChunkHash); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if seen[rel] {
+				continue
+			}
+			seen[rel] = true
+			relations = append(relations, rel)
+			for _, key := range []string{subjectKey, objectKey} {
+				if !visited[key] {
+					visited[key] = true
+					next = append(next, key)
+				}
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		frontier = next
+	}
+	return relations, nil
+}
+
+func (g *SQLiteGraph) DeleteRelations(ctx context.Context, tenant, source string, hashes []string) error {
+	if err := g.ensureSchema(ctx); err != nil {
+		return err
+	}
+	if hashes == nil {
+		_, err := g.db.ExecContext(ctx, `DELETE FROM relations WHERE tenant = ? AND source = ?`, tenant, source)
+		return err
+	}
+	tx, err := g.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, hash := range hashes {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM relations WHERE tenant = ? AND source = ? AND chunk_hash = ?`, tenant, source, hash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func newTestGraph(t *testing.T) *SQLiteGraph {
+	t.Helper()
+	graph, err := NewSQLiteGraph(filepath.Join(t.TempDir(), "graph.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { graph.Close() })
+	return graph
+}
+
+func relationStrings(relations []Relation) []string {
+	var out []string
+	for _, rel := range relations {
+		out = append(out, rel.String())
+	}
+	return out
+}
+
+func TestSQLiteGraphWalksNeighbors(t *testing.T) {
+	ctx := context.Background()
+	graph := newTestGraph(t)
+	err := graph.AddRelations(ctx, "", []Relation{
+		{Subject: "Payments team", Predicate: "owns", Object: "billing service", Source: "billing.md", ChunkHash: "h1"},
+		{Subject: "Alice Smith", Predicate: "manages", Object: "Payments Team", Source: "teams.md", ChunkHash: "h2"},
+		{Subject: "Alice Smith", Predicate: "reports to", Object: "Bob", Source: "teams.md", ChunkHash: "h2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := graph.AddRelations(ctx, "acme", []Relation{{Subject: "Billing service", Predicate: "runs on", Object: "Kubernetes", Source: "infra.md", ChunkHash: "h3"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	entities, err := graph.MatchEntities(ctx, "", "Who manages the team that owns the Billing Service?")
+	if err != nil || !slices.Equal(entities, []string{"billing service"}) {
+		t.Fatalf("unexpected entities %v, %v", entities, err)
+	}
+	if entities, _ := graph.MatchEntities(ctx, "", "What does the payments team do?"); !slices.Equal(entities, []string{"payments team"}) {
+		t.Fatalf("expected the longest entity only, got %v", entities)
+	}
+
+	one, _ := graph.Neighbors(ctx, "", entities[:0:0], 1, 10)
+	if len(one) != 0 {
+		t.Fatalf("expected no relations without entities, got %v", one)
+	}
+	one, _ = graph.Neighbors(ctx, "", []string{"Billing service"}, 1, 10)
+	if got := relationStrings(one); !slices.Equal(got, []string{"Payments team owns billing service"}) {
+		t.Fatalf("unexpected relations one hop away: %v", got)
+	}
+	two, _ := graph.Neighbors(ctx, "", []string{"Billing service"}, 2, 10)
+	if got := relationStrings(two); !slices.Equal(got, []string{"Payments team owns billing service", "Alice Smith manages Payments Team"}) {
+		t.Fatalf("unexpected relations two hops away: %v", got)
+	}
+	if limited, _ := graph.Neighbors(ctx, "", []string{"Billing service"}, 2, 1); len(limited) != 1 {
+		t.Fatalf("expected the limit to apply, got %v", limited)
+	}
+	if other, _ := graph.Neighbors(ctx, "acme", []string{"billing service"}, 2, 10); len(other) != 1 || other[0].Source != "infra.md" {
+		t.Fatalf("expected the tenant's own graph, got %v", other)
+	}
+
+	if err := graph.DeleteRelations(ctx, "", "teams.md", []string{"h2"}); err != nil {
+		t.Fatal(err)
+	}
+	if two, _ := graph.Neighbors(ctx, "", []string{"Billing service"}, 2, 10); len(two) != 1 {
+		t.Fatalf("expected the deleted chunk's relations to be gone, got %v", two)
+	}
+}
+
+func TestEngineExpandsQueriesThroughTheGraph(t *testing.T) {
+	ctx := context.Background()
+	graph := newTestGraph(t)
+	oa := &sequenceOpenAI{replies: []string{
+		"Payments team | owns | billing service",
+		"1. Alice Smith | manages | Payments team\nnot a relation",
+		"",
+	}}
+	engine := NewRAGEngine(oa, NewMemoryStore(NewHashingEmbedder(256)), WithGraph(graph, "gpt-test"))
+	ingestPages(ctx, engine, []Page{
+		{Text: "The Payments team owns the billing service.", Source: "billing.md"},
+		{Text: "Alice Smith manages the Payments team.", Source: "teams.md"},
+		{Text: "The cafeteria serves lunch at noon.", Source: "lunch.md"},
+	}, 1000, 0)
+	if len(oa.calls) != 3 {
+		t.Fatalf("expected one extraction call per chunk, got %d", len(oa.calls))
+	}
+
+	docs := engine.Retrieve(ctx, "Who is in charge of the billing service?", 2, WithGraphExpansion(2))
+	var found bool
+	for _, doc := range docs {
+		if doc.Source == "teams.md" {
+			found = doc.Metadata[graphRelationField] == "Alice Smith manages Payments team"
+		}
+	}
+	if !found {
+		t.Fatalf("expected the manager's chunk through the graph, got %+v", docs)
+	}
+	if docs := engine.Retrieve(ctx, "Who is in charge of the billing service?", 3, WithGraphExpansion(2), WithFilter(Filter{Eq("source", "billing.md")})); len(docs) != 1 {
+		t.Fatalf("expected graph expansion to respect the filter, got %+v", docs)
+	}
+
+	// Re-ingesting changed content and deleting a source drop their relations.
+	oa.replies = []string{""}
+	ingestPages(ctx, engine, []Page{{Text: "The Platform team owns the billing service now.", Source: "billing.md"}}, 1000, 0)
+	if err := engine.DeleteBySource(ctx, "teams.md"); err != nil {
+		t.Fatal(err)
+	}
+	if relations, _ := graph.Neighbors(ctx, "", []string{"billing service", "payments team"}, 2, 10); len(relations) != 0 {
+		t.Fatalf("expected the relations to be removed, got %v", relations)
+	}
+}
+
+func TestServerRejectsGraphHopsWithoutAGraph(t *testing.T) {
+	server, _ := newTestServer()
+	for body, want := range map[string]string{
+		`{"question":"q","graph_hops":1}`: "knowledge graph",
+		`{"question":"q","graph_hops":3}`: "between 0 and 2",
+	} {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("%s: unexpected response %d %s", body, rec.Code, rec.Body.String())
+		}
+	}
+}
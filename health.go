@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Pinger is implemented by clients and stores that can cheaply check that
+// their backend is reachable and accepts the configured credentials.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// ReadinessCheck is a dependency the server needs to answer queries.
+type ReadinessCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+const (
+	// readinessTimeout bounds each readiness check.
+	readinessTimeout = 5 * time.Second
+	// readinessCacheTTL is how long a readiness result is reused, so that
+	// frequent probes from several replicas do not add load or API calls.
+	readinessCacheTTL = 10 * time.Second
+)
+
+// DependencyChecks returns the readiness checks of the vector store and the
+// LLM, for those that implement Pinger.
+func DependencyChecks(store VectorStore, llm LLMClient) []ReadinessCheck {
+	var checks []ReadinessCheck
+	if p, ok := store.(Pinger); ok {
+		checks = append(checks, ReadinessCheck{Name: "vectorstore", Check: p.Ping})
+	}
+	if instrumented, ok := llm.(*instrumentedLLM); ok {
+		llm = instrumented.llm
+	}
+	if p, ok := llm.(Pinger); ok {
+		checks = append(checks, ReadinessCheck{Name: "llm", Check: p.Ping})
+	}
+	return checks
+}
+
+// CheckResult is the outcome of a ReadinessCheck.
+type CheckResult struct {
+	Name       string `json:"name"`
+	OK         bool   `json:"ok"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// ReadinessReport is the outcome of all readiness checks.
+type ReadinessReport struct {
+	Status string        `json:"status"` // "ready" or "unavailable"
+	Checks []CheckResult `json:"checks"`
+}
+
+// RunReadinessChecks runs checks concurrently, each with readinessTimeout.
+func RunReadinessChecks(ctx context.Context, checks []ReadinessCheck) ReadinessReport {
+	results := make([]CheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+			defer cancel()
+			start := time.Now()
+			err := check.Check(ctx)
+			results[i] = CheckResult{Name: check.Name, OK: err == nil, DurationMS: time.Since(start).Milliseconds()}
+			if err != nil {
+				results[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+	report := ReadinessReport{Status: "ready", Checks: results}
+	for _, result := range results {
+		if !result.OK {
+			report.Status = "unavailable"
+		}
+	}
+	return report
+}
+
+// SetReadinessChecks sets the checks /readyz runs.
+func (s *Server) SetReadinessChecks(checks []ReadinessCheck) {
+	s.readyMu.Lock()
+	defer s.readyMu.Unlock()
+	s.readyChecks = checks
+	s.readyAt = time.Time{}
+}
+
+// readiness returns the result of the readiness checks, reusing a result
+// younger than readinessCacheTTL.
+func (s *Server) readiness(ctx context.Context) ReadinessReport {
+	s.readyMu.Lock()
+	defer s.readyMu.Unlock()
+	if time.Since(s.readyAt) > readinessCacheTTL {
+		// Probes must not fail because one probe request was cancelled.
+		s.ready = RunReadinessChecks(context.WithoutCancel(ctx), s.readyChecks)
+		s.readyAt = time.Now()
+	}
+	return s.ready
+}
+
+// handleHealthz reports that the process is serving. It checks no
+// dependencies, so an outage of Milvus or the LLM provider makes replicas
+// unready rather than restarting them all.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleReadyz reports whether every dependency check passes, with 503
+// Service Unavailable if one fails.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	report := s.readiness(r.Context())
+	status := http.StatusOK
+	if report.Status != "ready" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
+
+// pingHTTP sends req and fails unless the response status is 200 OK. The
+// providers' Ping methods use it with a cheap authenticated GET, such as
+// listing models.
+func pingHTTP(httpClient *http.Client, req *http.Request, service string) error {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s API error (status %d)", service, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/milvus-io/milvus-sdk-go/v2/client"
+	"github.com/milvus-io/milvus-sdk-go/v2/entity"
+)
+
+func TestServerProbes(t *testing.T) {
+	server, _ := newTestServer()
+	if err := server.RequireAPIKeys([]APIKey{{Key: "sk-acme", Tenant: "acme"}}); err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	var storeErr error
+	server.SetReadinessChecks([]ReadinessCheck{
+		{Name: "vectorstore", Check: func(ctx context.Context) error { calls++; return storeErr }},
+		{Name: "llm", Check: func(ctx context.Context) error { return nil }},
+	})
+
+	// Probes need no API key.
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected /healthz to pass, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var report ReadinessReport
+	json.Unmarshal(rec.Body.Bytes(), &report)
+	if rec.Code != http.StatusOK || report.Status != "ready" || len(report.Checks) != 2 || !report.Checks[0].OK {
+		t.Fatalf("expected ready, got %d %+v", rec.Code, report)
+	}
+
+	// Results are reused for a while.
+	storeErr = errors.New("connection refused")
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusOK || calls != 1 {
+		t.Fatalf("expected the cached result, got %d after %d checks", rec.Code, calls)
+	}
+
+	server.readyAt = server.readyAt.Add(-readinessCacheTTL - 1)
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	json.Unmarshal(rec.Body.Bytes(), &report)
+	if rec.Code != http.StatusServiceUnavailable || report.Status != "unavailable" || report.Checks[0].Error != "connection refused" || !report.Checks[1].OK {
+		t.Fatalf("expected the failed store to make the server unready, got %d %+v", rec.Code, report)
+	}
+}
+
+type healthMilvusSDK struct {
+	client.Client
+	healthy bool
+	load    entity.LoadState
+}
+
+func (h *healthMilvusSDK) CheckHealth(ctx context.Context) (*entity.MilvusState, error) {
+	return &entity.MilvusState{IsHealthy: h.healthy, Reasons: []string{"querynode down"}}, nil
+}
+
+func (h *healthMilvusSDK) GetLoadState(ctx context.Context, collection string, partitions []string) (entity.LoadState, error) {
+	return h.load, nil
+}
+
+func TestMilvusPing(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		sdk  *healthMilvusSDK
+		want string
+	}{
+		{&healthMilvusSDK{healthy: true, load: entity.LoadStateLoaded}, ""},
+		{&healthMilvusSDK{healthy: true, load: entity.LoadStateNotExist}, ""},
+		{&healthMilvusSDK{healthy: true, load: entity.LoadStateNotLoad}, "collection docs is not loaded"},
+		{&healthMilvusSDK{healthy: true, load: entity.LoadStateLoading}, "still loading"},
+		{&healthMilvusSDK{healthy: false}, "Milvus is unhealthy: querynode down"},
+	} {
+		err := (&MilvusClientImpl{client: tc.sdk, collectionName: "docs"}).Ping(ctx)
+		if (tc.want == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), tc.want)) {
+			t.Errorf("%+v: expected %q, got %v", tc.sdk, tc.want, err)
+		}
+	}
+}
+
+func TestDependencyChecksPingProviders(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags", "/collections/docs":
+			w.WriteHeader(status)
+		case "/models":
+			if r.Header.Get("x-api-key") != "key" && r.Header.Get("x-goog-api-key") != "key" {
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	store := NewQdrantStore(server.URL, "", "docs", nil, 8)
+	checks := DependencyChecks(store, instrumentLLM(NewOllamaClient(server.URL, "")))
+	if len(checks) != 2 || checks[0].Name != "vectorstore" || checks[1].Name != "llm" {
+		t.Fatalf("unexpected checks %+v", checks)
+	}
+	if report := RunReadinessChecks(context.Background(), checks); report.Status != "ready" {
+		t.Fatalf("expected ready, got %+v", report)
+	}
+	// A missing collection is created on first insert, so it passes.
+	status = http.StatusNotFound
+	if err := store.Ping(context.Background()); err != nil {
+		t.Fatalf("expected a missing collection to pass, got %v", err)
+	}
+	status = http.StatusInternalServerError
+	if report := RunReadinessChecks(context.Background(), checks); report.Status != "unavailable" || report.Checks[1].OK {
+		t.Fatalf("expected unavailable, got %+v", report)
+	}
+
+	anthropic := NewAnthropicClient("wrong")
+	anthropic.baseURL = server.URL
+	if err := anthropic.Ping(context.Background()); err == nil || !strings.Contains(err.Error(), "status 401") {
+		t.Fatalf("expected an invalid Anthropic key to fail, got %v", err)
+	}
+	gemini, _ := NewGeminiClient("key", "", 0)
+	gemini.baseURL = server.URL
+	if err := gemini.Ping(context.Background()); err != nil {
+		t.Fatalf("expected a valid Gemini key to pass, got %v", err)
+	}
+	if checks := DependencyChecks(NewMemoryStore(NewHashingEmbedder(8)), &scriptedOpenAI{}); len(checks) != 0 {
+		t.Fatalf("expected no checks without pingable dependencies, got %+v", checks)
+	}
+}
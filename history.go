@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite" // registers the "sqlite" driver
+)
+
+// ErrQueryNotFound is returned by HistoryStore.GetQuery for an unknown ID.
+var ErrQueryNotFound = errors.New("query not found")
+
+// ErrDuplicateQueryID is returned by HistoryStore.SaveQuery for a record
+// whose ID is already stored, such as a client's reused X-Request-ID.
+var ErrDuplicateQueryID = errors.New("query ID already recorded")
+
+// QueryRecord is the audit record of one answered question: what was asked,
+// the context and prompt the model saw, and what it answered.
+type QueryRecord struct {
+	ID        string
+	Tenant    string // the tenant that asked, empty without tenants
+	Time      time.Time
+	Question  string
+	Model     string
+	Prompt    []Message
+	Documents []Document // the context documents, in prompt order
+	Answer    string
+	NoContext bool
+	Error     string // set when generating the answer failed
+	Duration  time.Duration
+	Feedback  *Feedback // the latest feedback on the answer, if any
+}
+
+// HistoryFilter selects records from a HistoryStore.
+type HistoryFilter struct {
+	Limit  int       // at most this many records, newest first; 0 means 20
+	Before time.Time // only records older than this, for paging; zero means now
+	Search string    // only questions containing this text, case-insensitively
+	Tenant string    // only this tenant's queries; empty means all
+}
+
+// HistoryStore persists QueryRecords.
+type HistoryStore interface {
+	// SaveQuery stores the record, or returns ErrDuplicateQueryID if one
+	// with its ID is already stored, which it leaves as it is.
+	SaveQuery(ctx context.Context, record QueryRecord) error
+	// GetQuery returns the record with the ID, or ErrQueryNotFound.
+	GetQuery(ctx context.Context, id string) (QueryRecord, error)
+	ListQueries(ctx context.Context, filter HistoryFilter) ([]QueryRecord, error)
+	// SaveFeedback attaches feedback to a recorded query, replacing earlier
+	// feedback on it, or returns ErrQueryNotFound.
+	SaveFeedback(ctx context.Context, feedback Feedback) error
+}
+
+// WithHistory records every generated answer, with its question, context
+// documents, prompt, and timing, in store under the query ID of the request
+// (see withQueryID). Failing to record is logged and does not fail the query.
+func WithHistory(store HistoryStore) EngineOption {
+	return func(r *RAGEngine) {
+		r.history = store
+	}
+}
+
+// recordQuery saves the outcome of a generate call to the history store.
+func (r *RAGEngine) recordQuery(ctx context.Context, query, model string, docs []Document, messages []Message, answer Answer, err error, start time.Time) {
+	id, _ := ctx.Value(queryIDKey{}).(string)
+	if id == "" {
+		id = newQueryID()
+	}
+	record := QueryRecord{
+		ID:        id,
+		Tenant:    r.tenant,
+		Time:      start.UTC(),
+		Question:  query,
+		Model:     model,
+		Prompt:    messages,
+		Documents: docs,
+		Answer:    answer.Text,
+		NoContext: answer.NoContext,
+		Duration:  time.Since(start),
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	// Record even when the request was cancelled after the answer arrived.
+	saveErr := r.history.SaveQuery(context.WithoutCancel(ctx), record)
+	if errors.Is(saveErr, ErrDuplicateQueryID) {
+		record.ID = newQueryID()
+		slog.WarnContext(ctx, "Query ID already recorded, recording under a new ID", "recorded_id", record.ID)
+		saveErr = r.history.SaveQuery(context.WithoutCancel(ctx), record)
+	}
+	if saveErr != nil {
+		slog.WarnContext(ctx, "Recording query history failed", "error", saveErr)
+	}
+}
+
+// visibleQuery returns a recorded query the engine's caller may see: one of
+// its tenant, whose context its roles could read. Other queries are reported
+// as ErrQueryNotFound.
+func (r *RAGEngine) visibleQuery(ctx context.Context, id string) (QueryRecord, error) {
+	record, err := r.history.GetQuery(ctx, id)
+	if err == nil && ((r.tenant != "" && record.Tenant != r.tenant) || !r.canRead(record)) {
+		return QueryRecord{}, ErrQueryNotFound
+	}
+	return record, err
+}
+
+// queryRecorded reports whether the history has a query with id, of any
+// tenant.
+func (r *RAGEngine) queryRecorded(ctx context.Context, id string) bool {
+	if r.history == nil {
+		return false
+	}
+	_, err := r.history.GetQuery(ctx, id)
+	return err == nil
+}
+
+// SQLiteHistory is a HistoryStore in a SQLite database file.
+type SQLiteHistory struct {
+	db *sql.DB
+
+	once    sync.Once
+	initErr error
+}
+
+// NewSQLiteHistory opens the history database at path. The file and its
+// tables are created on first use.
+func NewSQLiteHistory(path string) (*SQLiteHistory, error) {
+	// foreign_keys makes feedback go with the query it rates.
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)")
+	if err != nil {
+		return nil, fmt.Errorf("opening history database: %w", err)
+	}
+	// SQLite allows one writer at a time; a single connection serializes
+	// concurrent requests instead of failing them with "database is locked".
+	db.SetMaxOpenConns(1)
+	return &SQLiteHistory{db: db}, nil
+}
+
+// Close closes the database.
+func (h *SQLiteHistory) Close() error {
+	return h.db.Close()
+}
+
+// ensureSchema creates the tables on first use.
+func (h *SQLiteHistory) ensureSchema(ctx context.Context) error {
+	h.once.Do(func() {
+		_, h.initErr = h.db.ExecContext(ctx, `
+CREATE TABLE IF NOT EXISTS queries (
+	id          TEXT PRIMARY KEY,
+	tenant      TEXT NOT NULL DEFAULT '',
+	time        INTEGER NOT NULL,
+	question    TEXT NOT NULL,
+	model       TEXT NOT NULL,
+	prompt      TEXT NOT NULL,
+	documents   TEXT NOT NULL,
+	answer      TEXT NOT NULL,
+	no_context  INTEGER NOT NULL,
+	error       TEXT NOT NULL,
+	duration_ms INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS queries_time_idx ON queries (time);
+CREATE TABLE IF NOT EXISTS feedback (
+	query_id   TEXT PRIMARY KEY REFERENCES queries (id) ON DELETE CASCADE,
+	rating     TEXT NOT NULL,
+	correction TEXT NOT NULL,
+	comment    TEXT NOT NULL,
+	time       INTEGER NOT NULL
+);`)
+		if h.initErr == nil {
+			// Databases created before tenants were recorded lack the column.
+			_, err := h.db.ExecContext(ctx, "ALTER TABLE queries ADD COLUMN tenant TEXT NOT NULL DEFAULT ''")
+			if err != nil && !strings.Contains(err.Error(), "duplicate column") {
+				h.initErr = err
+			}
+		}
+		if h.initErr != nil {
+			h.initErr = fmt.Errorf("preparing history schema: %w", h.initErr)
+		}
+	})
+	return h.initErr
+}
+
+type historyMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type historyDocument struct {
+	Source     string         `json:"source"`
+	Text       string         `json:"text"`
+	Similarity float32        `json:"similarity"`
+	Metadata   map[string]any `json:"metadata,omitempty"`
+}
+
+func (h *SQLiteHistory) SaveQuery(ctx context.Context, record QueryRecord) error {
+	if err := h.ensureSchema(ctx); err != nil {
+		return err
+	}
+	prompt := make([]historyMessage, len(record.Prompt))
+	for i, m := range record.Prompt {
+		prompt[i] = historyMessage{Role: m.Role, Content: m.Content}
+	}
+	docs := make([]historyDocument, len(record.Documents))
+	for i, d := range record.Documents {
+		docs[i] = historyDocument{Source: d.Source, Text: d.Text, Similarity: d.Similarity, Metadata: d.Metadata}
+	}
+	promptJSON, err := json.Marshal(prompt)
+	if err != nil {
+		return err
+	}
+	docsJSON, err := json.Marshal(docs)
+	if err != nil {
+		return err
+	}
+	result, err := h.db.ExecContext(ctx,
+		`INSERT INTO queries (id, tenant, time, question, model, prompt, documents, answer, no_context, error, duration_ms)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (id) DO NOTHING`,
+		record.ID, record.Tenant, record.Time.UnixNano(), record.Question, record.Model, string(promptJSON), string(docsJSON),
+		record.Answer, record.NoContext, record.Error, record.Duration.Milliseconds())
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrDuplicateQueryID
+	}
+	return nil
+}
+
+// querySelect reads queries with their feedback, in the columns scanQuery
+// expects.
+const querySelect = `SELECT q.id, q.tenant, q.time, q.question, q.model, q.prompt, q.documents, q.answer, q.no_context, q.error, q.duration_ms,
+	f.rating, f.correction, f.comment, f.time
+FROM queries q LEFT JOIN feedback f ON f.query_id = q.id`
+
+func (h *SQLiteHistory) GetQuery(ctx context.Context, id string) (QueryRecord, error) {
+	if err := h.ensureSchema(ctx); err != nil {
+		return QueryRecord{}, err
+	}
+	record, err := scanQuery(h.db.QueryRowContext(ctx, querySelect+" WHERE q.id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return QueryRecord{}, ErrQueryNotFound
+	}
+	return record, err
+}
+
+func (h *SQLiteHistory) ListQueries(ctx context.Context, filter HistoryFilter) ([]QueryRecord, error) {
+	if err := h.ensureSchema(ctx); err != nil {
+		return nil, err
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = 20
+	}
+	stmt := querySelect + " WHERE 1 = 1"
+	var args []any
+	if !filter.Before.IsZero() {
+		stmt += " AND q.time < ?"
+		args = append(args, filter.Before.UnixNano())
+	}
+	if filter.Tenant != "" {
+		stmt += " AND q.tenant = ?"
+		args = append(args, filter.Tenant)
+	}
+	if filter.Search != "" {
+		stmt += ` AND q.question LIKE ? ESCAPE '\'`
+		escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(filter.Search)
+		args = append(args, "%"+escaped+"%")
+	}
+	stmt += " ORDER BY q.time DESC LIMIT ?"
+	args = append(args, filter.Limit)
+
+	rows, err := h.db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var records []QueryRecord
+	for rows.Next() {
+		record, err := scanQuery(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func (h *SQLiteHistory) SaveFeedback(ctx context.Context, feedback Feedback) error {
+	if err := h.ensureSchema(ctx); err != nil {
+		return err
+	}
+	if feedback.Time.IsZero() {
+		feedback.Time = time.Now().UTC()
+	}
+	result, err := h.db.ExecContext(ctx,
+		`INSERT OR REPLACE INTO feedback (query_id, rating, correction, comment, time)
+SELECT id, ?, ?, ?, ? FROM queries WHERE id = ?`,
+		string(feedback.Rating), feedback.Correction, feedback.Comment, feedback.Time.UnixNano(), feedback.QueryID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrQueryNotFound
+	}
+	return nil
+}
+
+// scanQuery reads a row selected by querySelect.
+func scanQuery(row interface{ Scan(...any) error }) (QueryRecord, error) {
+	var record QueryRecord
+	var nanos, durationMS int64
+	var promptJSON, docsJSON string
+	var rating, correction, comment sql.NullString
+	var feedbackNanos sql.NullInt64
+	err := row.Scan(&record.ID, &record.Tenant, &nanos, &record.Question, &record.Model, &promptJSON, &docsJSON,
+		&record.Answer, &record.NoContext, &record.Error, &durationMS,
+		&rating, &correction, &comment, &feedbackNanos)
+	if err != nil {
+		return record, err
+	}
+	record.Time = time.Unix(0, nanos).UTC()
+	record.Duration = time.Duration(durationMS) * time.Millisecond
+	if rating.Valid {
+		record.Feedback = &Feedback{
+			QueryID:    record.ID,
+			Rating:     Rating(rating.String),
+			Correction: correction.String,
+			Comment:    comment.String,
+			Time:       time.Unix(0, feedbackNanos.Int64).UTC(),
+		}
+	}
+
+	var prompt []historyMessage
+	if err := json.Unmarshal([]byte(promptJSON), &prompt); err != nil {
+		return record, fmt.Errorf("decoding prompt of query %s: %w", record.ID, err)
+	}
+	for _, m := range prompt {
+		record.Prompt = append(record.Prompt, Message{Role: m.Role, Content: m.Content})
+	}
+	var docs []historyDocument
+	if err := json.Unmarshal([]byte(docsJSON), &docs); err != nil {
+		return record, fmt.Errorf("decoding documents of query %s: %w", record.ID, err)
+	}
+	for _, d := range docs {
+		record.Documents = append(record.Documents, Document{Source: d.Source, Text: d.Text, Similarity: d.Similarity, Metadata: d.Metadata})
+	}
+	return record, nil
+}
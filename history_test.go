@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestHistory(t *testing.T) *SQLiteHistory {
+	t.Helper()
+	history, err := NewSQLiteHistory(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteHistory: %v", err)
+	}
+	t.Cleanup(func() { history.Close() })
+	return history
+}
+
+func TestSQLiteHistory(t *testing.T) {
+	ctx := context.Background()
+	history := newTestHistory(t)
+	start := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	for i, question := range []string{"What is Go?", "What is Milvus?", "Is 100% of Go_lang fast?"} {
+		err := history.SaveQuery(ctx, QueryRecord{
+			ID:        question,
+			Time:      start.Add(time.Duration(i) * time.Minute),
+			Question:  question,
+			Model:     "gpt-test",
+			Prompt:    []Message{{Role: "user", Content: question}},
+			Documents: []Document{{Source: "Go Docs", Text: "Go is a language", Similarity: 0.9, Metadata: map[string]any{"page": float64(2)}}},
+			Answer:    "An answer [1].",
+			Duration:  1500 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatalf("SaveQuery: %v", err)
+		}
+	}
+
+	record, err := history.GetQuery(ctx, "What is Go?")
+	if err != nil {
+		t.Fatalf("GetQuery: %v", err)
+	}
+	if !record.Time.Equal(start) || record.Duration != 1500*time.Millisecond || record.Prompt[0].Content != "What is Go?" ||
+		record.Documents[0].Source != "Go Docs" || record.Documents[0].Metadata["page"] != float64(2) {
+		t.Fatalf("unexpected record %+v", record)
+	}
+	if _, err := history.GetQuery(ctx, "missing"); !errors.Is(err, ErrQueryNotFound) {
+		t.Fatalf("expected ErrQueryNotFound, got %v", err)
+	}
+	if err := history.SaveQuery(ctx, QueryRecord{ID: "What is Go?", Question: "Replaced?"}); !errors.Is(err, ErrDuplicateQueryID) {
+		t.Fatalf("expected ErrDuplicateQueryID for a reused ID, got %v", err)
+	}
+	if record, _ := history.GetQuery(ctx, "What is Go?"); record.Question != "What is Go?" {
+		t.Fatalf("expected the first record to be kept, got %+v", record)
+	}
+
+	records, err := history.ListQueries(ctx, HistoryFilter{Limit: 2})
+	if err != nil {
+		t.Fatalf("ListQueries: %v", err)
+	}
+	if len(records) != 2 || records[0].Question != "Is 100% of Go_lang fast?" {
+		t.Fatalf("expected the two newest queries first, got %+v", records)
+	}
+	records, _ = history.ListQueries(ctx, HistoryFilter{Before: records[1].Time})
+	if len(records) != 1 || records[0].Question != "What is Go?" {
+		t.Fatalf("expected the query before the page, got %+v", records)
+	}
+	records, _ = history.ListQueries(ctx, HistoryFilter{Search: "100%"})
+	if len(records) != 1 {
+		t.Fatalf("expected the search to match %% literally, got %+v", records)
+	}
+	records, _ = history.ListQueries(ctx, HistoryFilter{Search: "milvus"})
+	if len(records) != 1 || records[0].Question != "What is Milvus?" {
+		t.Fatalf("expected a case-insensitive search, got %+v", records)
+	}
+}
+
+func TestEngineRecordsHistory(t *testing.T) {
+	history := newTestHistory(t)
+	engine := NewRAGEngine(&scriptedOpenAI{reply: "Cats purr [1]."}, &dummyMilvus{}, WithHistory(history))
+
+	ctx := withQueryID(context.Background(), "q-1")
+	if _, err := engine.GenerateResponse(ctx, "do cats purr?", []Document{{Text: "cats purr", Source: "cat facts"}}, "gpt-test"); err != nil {
+		t.Fatalf("GenerateResponse: %v", err)
+	}
+	record, err := history.GetQuery(context.Background(), "q-1")
+	if err != nil {
+		t.Fatalf("GetQuery: %v", err)
+	}
+	if record.Answer != "Cats purr [1]." || record.Model != "gpt-test" || len(record.Documents) != 1 ||
+		!strings.Contains(record.Prompt[len(record.Prompt)-1].Content, "cats purr") {
+		t.Fatalf("unexpected record %+v", record)
+	}
+
+	failing := NewRAGEngine(&scriptedOpenAI{err: errors.New("boom")}, &dummyMilvus{}, WithHistory(history))
+	failing.GenerateResponse(withQueryID(context.Background(), "q-2"), "do cats purr?", nil, "gpt-test")
+	if record, err := history.GetQuery(context.Background(), "q-2"); err != nil || record.Error != "boom" {
+		t.Fatalf("expected the failure to be recorded, got %+v, %v", record, err)
+	}
+
+	// A reused query ID records the second query under a new ID.
+	if _, err := engine.GenerateResponse(ctx, "do dogs purr?", nil, "gpt-test"); err != nil {
+		t.Fatalf("GenerateResponse: %v", err)
+	}
+	records, _ := history.ListQueries(context.Background(), HistoryFilter{Search: "purr"})
+	if len(records) != 3 || records[0].ID == "q-1" || records[0].Question != "do dogs purr?" {
+		t.Fatalf("expected the reused ID to get a new record, got %+v", records)
+	}
+	if record, _ := history.GetQuery(context.Background(), "q-1"); record.Question != "do cats purr?" {
+		t.Fatalf("expected the first record under the ID to be kept, got %+v", record)
+	}
+}
+
+func TestServerHistory(t *testing.T) {
+	server, _ := newTestServer()
+	server.engine.history = newTestHistory(t)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"question":"Who made Go?"}`))
+	req.Header.Set("X-Request-ID", "req-42")
+	server.ServeHTTP(rec, req)
+	var resp queryResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.QueryID != "req-42" {
+		t.Fatalf("expected the query ID in the response, got %q", resp.QueryID)
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/history?search=go", nil))
+	var list struct {
+		Queries []historyEntryJSON `json:"queries"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil || len(list.Queries) != 1 || list.Queries[0].ID != "req-42" {
+		t.Fatalf("unexpected history list %+v, %v", list, err)
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/history/req-42", nil))
+	var entry historyEntryJSON
+	if err := json.NewDecoder(rec.Body).Decode(&entry); err != nil || entry.Question != "Who made Go?" || len(entry.Prompt) == 0 {
+		t.Fatalf("unexpected history entry %+v, %v", entry, err)
+	}
+
+	// A reused ID is replaced, so the one returned names the new query.
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"question":"Who uses Go?"}`))
+	req.Header.Set("X-Request-ID", "req-42")
+	server.ServeHTTP(rec, req)
+	resp = queryResponse{}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.QueryID == "req-42" || rec.Header().Get("X-Request-ID") != resp.QueryID {
+		t.Fatalf("expected a new query ID for the reused one, got %q and header %q", resp.QueryID, rec.Header().Get("X-Request-ID"))
+	}
+	if record, err := server.engine.history.GetQuery(context.Background(), resp.QueryID); err != nil || record.Question != "Who uses Go?" {
+		t.Fatalf("expected the returned ID to name the new query, got %+v (%v)", record, err)
+	}
+
+	for path, status := range map[string]int{"/history/missing": http.StatusNotFound, "/history?limit=0": http.StatusBadRequest} {
+		rec = httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != status {
+			t.Errorf("GET %s: expected %d, got %d", path, status, rec.Code)
+		}
+	}
+}
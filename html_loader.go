@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// skippedElements hold navigation, scripts, and other boilerplate that should
+// never end up in a chunk.
+var skippedElements = map[string]bool{
+	"script": true, "style": true, "noscript": true, "template": true,
+	"nav": true, "header": true, "footer": true, "aside": true,
+	"form": true, "iframe": true, "svg": true, "button": true,
+}
+
+// blockElements start a new line in the extracted text.
+var blockElements = map[string]bool{
+	"p": true, "div": true, "section": true, "article": true, "main": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"li": true, "ul": true, "ol": true, "pre": true, "blockquote": true,
+	"table": true, "tr": true, "br": true, "hr": true, "dt": true, "dd": true,
+}
+
+// maxPageBytes bounds how much of a single response body is read.
+const maxPageBytes = 10 << 20
+
+// HTMLLoader fetches web pages and extracts their readable text.
+type HTMLLoader struct {
+	httpClient *http.Client
+}
+
+// NewHTMLLoader creates a loader using the default HTTP client.
+func NewHTMLLoader() *HTMLLoader {
+	return &HTMLLoader{httpClient: http.DefaultClient}
+}
+
+// LoadURL fetches a single page. The returned page uses the URL as its source,
+// starts with the page title, and records the title in its metadata.
+func (h *HTMLLoader) LoadURL(pageURL string) (Page, error) {
+	page, _, err := h.fetch(context.Background(), pageURL)
+	return page, err
+}
+
+// Crawl loads startURL and follows same-host links breadth-first up to depth
+// hops away, visiting at most maxPages pages. Pages that fail to load are
+// logged and skipped.
+func (h *HTMLLoader) Crawl(startURL string, depth, maxPages int) ([]Page, error) {
+	start, err := url.Parse(startURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing start URL: %w", err)
+	}
+
+	type queued struct {
+		url   string
+		depth int
+	}
+	visited := map[string]bool{normalizeURL(start): true}
+	queue := []queued{{url: start.String()}}
+
+	var pages []Page
+	for len(queue) > 0 && len(pages) < maxPages {
+		next := queue[0]
+		queue = queue[1:]
+
+		page, links, err := h.fetch(context.Background(), next.url)
+		if err != nil {
+			if next.depth == 0 {
+				return nil, err
+			}
+			slog.Warn("Skipping page", "url", next.url, "error", err)
+			continue
+		}
+		if page.Text != "" {
+			pages = append(pages, page)
+			slog.Info("Loaded page", "url", next.url, "characters", len(page.Text))
+		}
+
+		if next.depth >= depth {
+			continue
+		}
+		for _, link := range links {
+			if link.Host != start.Host || visited[normalizeURL(link)] {
+				continue
+			}
+			visited[normalizeURL(link)] = true
+			queue = append(queue, queued{url: link.String(), depth: next.depth + 1})
+		}
+	}
+	return pages, nil
+}
+
+// fetch downloads and parses a page, returning its text and outgoing links.
+func (h *HTMLLoader) fetch(ctx context.Context, pageURL string) (Page, []*url.URL, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return Page{}, nil, err
+	}
+	req.Header.Set("User-Agent", crawlerUserAgent)
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return Page{}, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Page{}, nil, fmt.Errorf("fetching %s: status %d", pageURL, resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" && !strings.Contains(ct, "html") {
+		return Page{}, nil, fmt.Errorf("fetching %s: unsupported content type %q", pageURL, ct)
+	}
+
+	doc, err := html.Parse(io.LimitReader(resp.Body, maxPageBytes))
+	if err != nil {
+		return Page{}, nil, fmt.Errorf("parsing %s: %w", pageURL, err)
+	}
+
+	title, text := extractHTMLText(doc)
+	var metadata map[string]any
+	if title != "" {
+		text = strings.TrimSpace(title + "\n\n" + text)
+		metadata = map[string]any{titleField: title}
+	}
+	return Page{Text: text, Source: pageURL, Metadata: metadata}, extractLinks(doc, resp.Request.URL), nil
+}
+
+// extractHTMLText returns the document title and its visible body text with
+// boilerplate elements removed and whitespace collapsed. Tables with a header
+// row and at least one more row are written as Markdown pipe tables.
+func extractHTMLText(doc *html.Node) (string, string) {
+	var title string
+	var text strings.Builder
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			if n.Data == "title" {
+				if title == "" && n.FirstChild != nil {
+					title = strings.Join(strings.Fields(n.FirstChild.Data), " ")
+				}
+				return
+			}
+			if skippedElements[n.Data] {
+				return
+			}
+			if n.Data == "table" {
+				if rows := htmlTableRows(n); len(rows) >= 2 && len(rows[0]) >= 2 {
+					text.WriteString("\n" + renderPipeTable(rows[0], rows[1:]) + "\n")
+					return
+				}
+			}
+			if blockElements[n.Data] {
+				text.WriteString("\n")
+			}
+		}
+		if n.Type == html.TextNode {
+			text.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if n.Type == html.ElementNode && blockElements[n.Data] {
+			text.WriteString("\n")
+		}
+	}
+	walk(doc)
+
+	var lines []string
+	for _, line := range strings.Split(text.String(), "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return title, strings.Join(lines, "\n")
+}
+
+// htmlTableRows returns the cell texts of a table's rows, leaving out those
+// of nested tables, which stay in the text of their cell. Rows are padded to
+// the width of the first.
+func htmlTableRows(table *html.Node) [][]string {
+	var rows [][]string
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode || c.Data == "table" {
+				continue
+			}
+			if c.Data != "tr" {
+				walk(c)
+				continue
+			}
+			var row []string
+			for cell := c.FirstChild; cell != nil; cell = cell.NextSibling {
+				if cell.Type == html.ElementNode && (cell.Data == "td" || cell.Data == "th") {
+					row = append(row, htmlCellText(cell))
+				}
+			}
+			if len(row) > 0 {
+				rows = append(rows, row)
+			}
+		}
+	}
+	walk(table)
+	for i := 1; i < len(rows); i++ {
+		rows[i] = fitRow(rows[i], len(rows[0]))
+	}
+	return rows
+}
+
+// htmlCellText returns the visible text of a table cell on one line.
+func htmlCellText(cell *html.Node) string {
+	var b strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && skippedElements[n.Data] {
+			return
+		}
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data + " ")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(cell)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// extractLinks resolves every anchor href against base, keeping http(s) links only.
+func extractLinks(doc *html.Node, base *url.URL) []*url.URL {
+	var links []*url.URL
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "a" {
+			for _, attr := range n.Attr {
+				if attr.Key != "href" {
+					continue
+				}
+				link, err := base.Parse(attr.Val)
+				if err == nil && (link.Scheme == "http" || link.Scheme == "https") {
+					link.Fragment = ""
+					links = append(links, link)
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return links
+}
+
+// normalizeURL gives a canonical key for deduplicating crawled URLs.
+func normalizeURL(u *url.URL) string {
+	normalized := *u
+	normalized.Fragment = ""
+	normalized.Path = strings.TrimSuffix(normalized.Path, "/")
+	return normalized.String()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLoadURLStripsBoilerplate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><head><title>Go  Guide</title><script>var x = 1;</script></head>
+<body><nav>Home | Docs</nav><main><h1>Intro</h1><p>Go is   simple.</p></main><footer>© 2024</footer></body></html>`)
+	}))
+	defer server.Close()
+
+	page, err := NewHTMLLoader().LoadURL(server.URL)
+	if err != nil {
+		t.Fatalf("LoadURL returned error: %v", err)
+	}
+	if page.Source != server.URL {
+		t.Fatalf("expected source %s, got %s", server.URL, page.Source)
+	}
+	expected := "Go Guide\n\nIntro\nGo is simple."
+	if page.Text != expected {
+		t.Fatalf("expected %q, got %q", expected, page.Text)
+	}
+	for _, noise := range []string{"var x", "Home", "2024"} {
+		if strings.Contains(page.Text, noise) {
+			t.Fatalf("boilerplate %q leaked into text", noise)
+		}
+	}
+}
+
+func TestCrawlFollowsSameHostLinksToDepth(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<p>root</p><a href="/a">a</a><a href="/a#top">a again</a><a href="https://example.com/x">external</a>`)
+	})
+	mux.HandleFunc("/a", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<p>page a</p><a href="/b">b</a>`)
+	})
+	mux.HandleFunc("/b", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<p>page b</p>`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	pages, err := NewHTMLLoader().Crawl(server.URL+"/", 1, 10)
+	if err != nil {
+		t.Fatalf("Crawl returned error: %v", err)
+	}
+	if len(pages) != 2 {
+		t.Fatalf("expected 2 pages at depth 1, got %d", len(pages))
+	}
+	if pages[1].Source != server.URL+"/a" {
+		t.Fatalf("unexpected second page source %s", pages[1].Source)
+	}
+}
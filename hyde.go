@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// WithHyDE searches with a hypothetical document instead of the raw query:
+// model drafts a short passage answering the question, and that draft is
+// embedded and searched. A draft shares vocabulary and shape with the
+// passages that hold the real answer, so this often retrieves much better for
+// short or keyword-style queries. Reranking still scores against the query.
+func WithHyDE(model string) RetrieveOption {
+	return func(c *retrieveConfig) {
+		c.hydeModel = model
+	}
+}
+
+// hypotheticalDocument asks the LLM for a passage answering query. On failure
+// it returns query, so retrieval falls back to a plain search.
+func (r *RAGEngine) hypotheticalDocument(ctx context.Context, query, model string) string {
+	ctx, span := tracer.Start(ctx, "rag.hyde")
+	defer span.End()
+
+	messages := []Message{
+		{Role: "system", Content: "You write short reference passages."},
+		{Role: "user", Content: "Write a passage of a few sentences, in the style of documentation or an encyclopedia, " +
+			"that answers the question below. Write it even if you are unsure of the facts; it is only used for search. " +
+			"Reply with the passage only.\n\nQuestion: " + query},
+	}
+	draft, err := r.llm.ChatCompletion(ctx, model, messages)
+	if err != nil {
+		endSpan(span, err)
+		slog.WarnContext(ctx, "Could not draft a hypothetical document, searching with the query", "error", err)
+		return query
+	}
+	draft = strings.TrimSpace(draft)
+	if draft == "" {
+		return query
+	}
+	span.SetAttributes(attribute.Int("rag.hyde_characters", len(draft)))
+	slog.DebugContext(ctx, "Drafted hypothetical document", "preview", truncateText(draft, 80))
+	return draft
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRetrieveWithHyDESearchesTheDraft(t *testing.T) {
+	mv := &dummyMilvus{}
+	llm := &scriptedOpenAI{reply: "  Goroutines are functions running concurrently with other functions.  "}
+	engine := NewRAGEngine(llm, mv)
+
+	engine.Retrieve(context.Background(), "goroutines?", 3, WithHyDE("gpt-test"))
+	if mv.lastQuery != "Goroutines are functions running concurrently with other functions." {
+		t.Fatalf("expected the search to use the hypothetical document, got %q", mv.lastQuery)
+	}
+
+	engine = NewRAGEngine(&scriptedOpenAI{err: errors.New("unavailable")}, mv)
+	engine.Retrieve(context.Background(), "goroutines?", 3, WithHyDE("gpt-test"))
+	if mv.lastQuery != "goroutines?" {
+		t.Fatalf("expected a fallback to the query, got %q", mv.lastQuery)
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"sort"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Page is a unit of extracted text together with the source label and
+// metadata its chunks are stored under (for example source "manual.pdf"
+// with metadata {"page": 3}).
+type Page struct {
+	Text     string
+	Source   string
+	Metadata map[string]any
+	Format   string // "markdown", "code", "transcript", or empty for plain text; selects the chunker
+	Image    *Image // an image to describe with the vision model; Text is its caption
+	Audio    *Audio // a recording to transcribe; replaces Text
+}
+
+// ingestBatchSize caps how many chunks are sent to the vector store per insert.
+const ingestBatchSize = 100
+
+// chunkPages splits every page into chunks, keeping each chunk paired with
+// the source and metadata of the page it came from. The page's format selects
+// the chunker. The chunk's offsets within the page are added to its metadata
+// as chunk_start and chunk_end.
+func chunkPages(pages []Page, chunkSize, overlap int) (texts, sources []string, metadata []map[string]any) {
+	for _, page := range pages {
+		for _, chunk := range pageChunker(page)(page.Text, chunkSize, overlap) {
+			meta := chunkMetadata(page, chunk)
+			texts = append(texts, chunk.Text)
+			sources = append(sources, page.Source)
+			metadata = append(metadata, meta)
+		}
+	}
+	return texts, sources, metadata
+}
+
+// chunkMetadata combines the page's metadata with the chunk's own and its
+// offsets.
+func chunkMetadata(page Page, chunk Chunk) map[string]any {
+	meta := make(map[string]any, len(page.Metadata)+len(chunk.Metadata)+2)
+	for k, v := range page.Metadata {
+		meta[k] = v
+	}
+	for k, v := range chunk.Metadata {
+		meta[k] = v
+	}
+	meta["chunk_start"] = chunk.Start
+	meta["chunk_end"] = chunk.End
+	return meta
+}
+
+// DedupStore is implemented by vector stores that can look up and remove a
+// source's chunks by content hash, which lets re-ingestion skip unchanged
+// chunks and clear out stale ones.
+type DedupStore interface {
+	// ContentHashes returns the content hashes stored for source. Chunks
+	// ingested before hashing was introduced are not included.
+	ContentHashes(ctx context.Context, source string) (map[string]bool, error)
+	// DeleteContentHashes removes the source's chunks with the given hashes.
+	DeleteContentHashes(ctx context.Context, source string, hashes []string) error
+}
+
+// IngestReport counts what ingestion did with each chunk.
+type IngestReport struct {
+	Inserted int // chunks of sources that had no stored chunks
+	Updated  int // new or changed chunks of sources that were already stored
+	Skipped  int // chunks whose content was already stored for their source
+	Removed  int // stale chunks deleted because their source no longer contains them
+	Dropped  int // chunks left out as noise by the engine's NoiseFilter
+}
+
+// Stored is the number of chunks written to the store.
+func (r IngestReport) Stored() int {
+	return r.Inserted + r.Updated
+}
+
+func (r IngestReport) String() string {
+	return fmt.Sprintf("%d inserted, %d updated, %d skipped, %d removed, %d dropped", r.Inserted, r.Updated, r.Skipped, r.Removed, r.Dropped)
+}
+
+// contentHash identifies a chunk by its text.
+func contentHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// chunkHash identifies a chunk by its text and metadata, leaving out a
+// content_hash it already carries.
+func chunkHash(text string, metadata map[string]any) string {
+	fields := maps.Clone(metadata)
+	delete(fields, "content_hash")
+	// Maps encode with sorted keys, so equal metadata hashes alike.
+	encoded, err := json.Marshal(fields)
+	if err != nil {
+		encoded = []byte(fmt.Sprint(fields))
+	}
+	return contentHash(text + "\x00" + string(encoded))
+}
+
+// ingestPages chunks the pages, records each chunk's content hash in its
+// metadata as content_hash, and adds the chunks to the engine in batches.
+// When the store implements DedupStore, chunks already stored for their
+// source are skipped, and once a source's new chunks are stored, its chunks
+// that are no longer present are removed. The content hash covers a chunk's
+// metadata as well as its text, so a chunk that moved within its source, or
+// whose metadata changed, is stored again rather than skipped with stale
+// offsets. With parent documents enabled, the parent sections are stored
+// first and the chunks are cut from them. A page's ACL metadata, or else the
+// engine's ingest ACL, is normalized to a list of roles; the page's
+// partition, or else the engine's ingest partition, and its expiry, or else
+// the engine's ingest expiry, in Unix seconds, are stored with its chunks.
+// With a noise filter, the pages are cleaned before they are chunked and
+// noisy chunks are dropped. With chunk headers, each chunk's header is part
+// of its text.
+func ingestPages(ctx context.Context, engine *RAGEngine, pages []Page, chunkSize, overlap int) (report IngestReport, ok bool) {
+	pages = engine.describeImages(ctx, pages)
+	pages = engine.transcribeAudio(ctx, pages)
+	pages = detectPageLanguages(pages)
+	if engine.noiseFilter != nil {
+		pages = engine.noiseFilter.cleanPages(ctx, pages)
+	}
+	var texts, sources []string
+	var metadata []map[string]any
+	var parents map[string]string
+	if engine.parents != nil {
+		texts, sources, metadata, parents = chunkPagesWithParents(pages, engine.parentSize, chunkSize, overlap)
+	} else {
+		texts, sources, metadata = chunkPages(pages, chunkSize, overlap)
+	}
+	if engine.noiseFilter != nil {
+		texts, sources, metadata, report.Dropped = engine.noiseFilter.filterChunks(ctx, texts, sources, metadata)
+	}
+	if engine.chunkHeader != "" {
+		addChunkHeaders(engine.chunkHeader, texts, sources, metadata)
+	}
+	slog.InfoContext(ctx, "Split pages into chunks", "pages", len(pages), "chunks", len(texts), "dropped", report.Dropped)
+	for i, text := range texts {
+		if acl := parseACL(metadata[i][aclField]); len(acl) > 0 || len(engine.ingestACL) > 0 {
+			if len(acl) == 0 {
+				acl = engine.ingestACL
+			}
+			metadata[i][aclField] = acl
+		} else {
+			delete(metadata[i], aclField)
+		}
+		partition, _ := metadata[i][partitionField].(string)
+		if partition == "" {
+			partition = engine.ingestPartition
+		}
+		if partition != "" {
+			metadata[i][partitionField] = partition
+		}
+		if expires, ok := chunkExpiry(ctx, metadata[i][expiresField], engine.ingestExpiry, sources[i]); ok {
+			metadata[i][expiresField] = expires
+		} else {
+			delete(metadata[i], expiresField)
+		}
+		// Changing who may read a chunk, its partition, its lifetime, or its
+		// offsets must replace it on re-ingestion.
+		metadata[i]["content_hash"] = chunkHash(text, metadata[i])
+	}
+
+	ctx, span := tracer.Start(ctx, "rag.ingest", trace.WithAttributes(
+		attribute.Int("rag.pages", len(pages)),
+		attribute.Int("rag.chunks", len(texts)),
+	))
+	defer func() {
+		span.SetAttributes(
+			attribute.Int("rag.ingest.inserted", report.Inserted),
+			attribute.Int("rag.ingest.updated", report.Updated),
+			attribute.Int("rag.ingest.skipped", report.Skipped),
+			attribute.Int("rag.ingest.removed", report.Removed),
+			attribute.Int("rag.ingest.dropped", report.Dropped),
+		)
+		if !ok {
+			span.SetStatus(codes.Error, "ingestion failed")
+			errorsTotal.WithLabelValues("ingest").Inc()
+		}
+		span.End()
+	}()
+
+	if len(parents) > 0 {
+		if err := engine.parents.PutParents(ctx, parents); err != nil {
+			slog.ErrorContext(ctx, "Storing parent sections failed", "error", err)
+			return report, false
+		}
+	}
+
+	dedup, ok := engine.store.(DedupStore)
+	if !ok {
+		stored, ok := insertChunks(ctx, engine, texts, sources, metadata)
+		report.Inserted = stored
+		return report, ok
+	}
+
+	// Decide per source which chunks are new and which stored ones are stale.
+	existing := make(map[string]map[string]bool)
+	current := make(map[string]map[string]bool)
+	var keep []int
+	for i, source := range sources {
+		if existing[source] == nil {
+			hashes, err := dedup.ContentHashes(ctx, source)
+			if err != nil {
+				slog.ErrorContext(ctx, "Reading stored chunks failed", "source", source, "error", err)
+				return report, false
+			}
+			existing[source] = hashes
+			current[source] = make(map[string]bool)
+		}
+		hash := metadata[i]["content_hash"].(string)
+		if existing[source][hash] || current[source][hash] {
+			report.Skipped++
+		} else {
+			keep = append(keep, i)
+		}
+		current[source][hash] = true
+	}
+
+	var keptTexts, keptSources []string
+	var keptMetadata []map[string]any
+	for _, i := range keep {
+		keptTexts = append(keptTexts, texts[i])
+		keptSources = append(keptSources, sources[i])
+		keptMetadata = append(keptMetadata, metadata[i])
+	}
+	stored, ok := insertChunks(ctx, engine, keptTexts, keptSources, keptMetadata)
+	for _, source := range keptSources[:stored] {
+		if len(existing[source]) > 0 {
+			report.Updated++
+		} else {
+			report.Inserted++
+		}
+	}
+	if !ok {
+		return report, false
+	}
+
+	for source, hashes := range existing {
+		var stale []string
+		for hash := range hashes {
+			if !current[source][hash] {
+				stale = append(stale, hash)
+			}
+		}
+		if len(stale) == 0 {
+			continue
+		}
+		sort.Strings(stale)
+		if err := dedup.DeleteContentHashes(ctx, source, stale); err != nil {
+			slog.ErrorContext(ctx, "Removing stale chunks failed", "source", source, "error", err)
+			return report, false
+		}
+		engine.deleteGraphRelations(ctx, source, stale)
+		slog.InfoContext(ctx, "Removed stale chunks", "source", source, "chunks", len(stale))
+		report.Removed += len(stale)
+	}
+	return report, true
+}
+
+// insertChunks adds chunks to the engine in batches of ingestBatchSize,
+// returning how many were stored before any failure. With chunk enrichment,
+// each batch's representations are stored after it; failing to store them
+// is logged but does not fail the ingestion.
+func insertChunks(ctx context.Context, engine *RAGEngine, texts, sources []string, metadata []map[string]any) (int, bool) {
+	for start := 0; start < len(texts); start += ingestBatchSize {
+		end := min(start+ingestBatchSize, len(texts))
+		if !engine.AddDocumentsWithMetadata(ctx, texts[start:end], sources[start:end], metadata[start:end]) {
+			return start, false
+		}
+		if engine.enricher != nil {
+			repTexts, repSources, repMetadata := engine.enrichChunks(ctx, texts[start:end], sources[start:end], metadata[start:end])
+			for repStart := 0; repStart < len(repTexts); repStart += ingestBatchSize {
+				repEnd := min(repStart+ingestBatchSize, len(repTexts))
+				if !engine.AddDocumentsWithMetadata(ctx, repTexts[repStart:repEnd], repSources[repStart:repEnd], repMetadata[repStart:repEnd]) {
+					slog.ErrorContext(ctx, "Storing chunk representations failed", "representations", repEnd-repStart)
+				}
+			}
+		}
+		if engine.graph != nil {
+			engine.extractGraph(ctx, texts[start:end], sources[start:end], metadata[start:end])
+		}
+	}
+	return len(texts), true
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestChunkPagesKeepsPageMetadata(t *testing.T) {
+	pages := []Page{
+		{Text: strings.Repeat("A", 15), Source: "doc.pdf", Metadata: map[string]any{"page": 1}},
+		{Text: "short page", Source: "doc.pdf", Metadata: map[string]any{"page": 2}},
+	}
+	texts, sources, metadata := chunkPages(pages, 10, 2)
+	if len(texts) != 3 || len(sources) != 3 || len(metadata) != 3 {
+		t.Fatalf("expected 3 chunks, got %d texts, %d sources, %d metadata", len(texts), len(sources), len(metadata))
+	}
+	expected := []int{1, 1, 2}
+	for i := range expected {
+		if sources[i] != "doc.pdf" || metadata[i]["page"] != expected[i] {
+			t.Fatalf("chunk %d expected page %d got %v from %s", i, expected[i], metadata[i]["page"], sources[i])
+		}
+	}
+}
+
+func TestIngestPagesBatchesInserts(t *testing.T) {
+	mv := &dummyMilvus{}
+	engine := NewRAGEngine(&dummyOpenAI{}, mv)
+	pages := []Page{{Text: "one. two. three.", Source: "doc.pdf", Metadata: map[string]any{"page": 1}}}
+	report, ok := ingestPages(context.Background(), engine, pages, 1000, 0)
+	if !ok || report.Inserted != 1 {
+		t.Fatalf("expected 1 inserted chunk, got %s (ok=%t)", report, ok)
+	}
+	if len(mv.insertedSources) != 1 || mv.insertedSources[0] != "doc.pdf" {
+		t.Fatalf("unexpected sources inserted: %v", mv.insertedSources)
+	}
+}
+
+func TestIngestPagesDeduplicatesBySource(t *testing.T) {
+	store := NewMemoryStore(NewHashingEmbedder(64))
+	engine := NewRAGEngine(&dummyOpenAI{}, store)
+	pages := []Page{
+		{Text: "alpha", Source: "a.pdf"},
+		{Text: "beta", Source: "a.pdf"},
+		{Text: "alpha", Source: "b.pdf"},
+	}
+	report, ok := ingestPages(context.Background(), engine, pages, 1000, 0)
+	if !ok || report != (IngestReport{Inserted: 3}) {
+		t.Fatalf("unexpected first report %s (ok=%t)", report, ok)
+	}
+
+	report, ok = ingestPages(context.Background(), engine, pages, 1000, 0)
+	if !ok || report != (IngestReport{Skipped: 3}) {
+		t.Fatalf("expected re-ingestion to skip everything, got %s", report)
+	}
+
+	// a.pdf changed: "beta" was replaced by "gamma" and "alpha" is repeated.
+	changed := []Page{{Text: "alpha", Source: "a.pdf"}, {Text: "gamma", Source: "a.pdf"}, {Text: "alpha", Source: "a.pdf"}}
+	report, ok = ingestPages(context.Background(), engine, changed, 1000, 0)
+	if !ok || report != (IngestReport{Updated: 1, Skipped: 2, Removed: 1}) {
+		t.Fatalf("unexpected report after change %s", report)
+	}
+	if store.Len() != 3 {
+		t.Fatalf("expected alpha and gamma for a.pdf plus alpha for b.pdf, got %d chunks", store.Len())
+	}
+	hashes, _ := store.ContentHashes(context.Background(), "a.pdf")
+	offsets := map[string]any{"chunk_start": 0, "chunk_end": 5}
+	if len(hashes) != 2 || !hashes[chunkHash("gamma", offsets)] || hashes[chunkHash("beta", map[string]any{"chunk_start": 0, "chunk_end": 4})] {
+		t.Fatalf("unexpected hashes for a.pdf: %v", hashes)
+	}
+}
+
+func TestIngestPagesReplacesMovedChunks(t *testing.T) {
+	store := NewMemoryStore(NewHashingEmbedder(64))
+	engine := NewRAGEngine(&dummyOpenAI{}, store)
+	text := "Go was designed at Google in 2007.\n\nGoroutines are cheap to start."
+	if _, ok := ingestPages(context.Background(), engine, []Page{{Text: text, Source: "go.md"}}, 40, 0); !ok {
+		t.Fatal("ingestion failed")
+	}
+
+	// A new paragraph in front moves the unchanged ones.
+	moved := "Go is a programming language.\n\n" + text
+	report, ok := ingestPages(context.Background(), engine, []Page{{Text: moved, Source: "go.md"}}, 40, 0)
+	if !ok || report.Skipped != 0 || report.Removed != 2 {
+		t.Fatalf("expected the moved chunks to be replaced, got %s", report)
+	}
+	docs := store.SearchSimilar(context.Background(), "goroutines cheap", 1, nil)
+	start := strings.Index(moved, "Goroutines")
+	if len(docs) != 1 || metadataInt(docs[0].Metadata, "chunk_start") != start || metadataInt(docs[0].Metadata, "chunk_end") != len(moved) {
+		t.Fatalf("expected the chunk at %d-%d, got %+v", start, len(moved), docs)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"regexp"
+	"strings"
+)
+
+// InjectionPolicy is what the prompt injection guard does with retrieved
+// chunks that contain instruction-like text, from least to most strict.
+type InjectionPolicy string
+
+const (
+	// InjectionOff disables the guard.
+	InjectionOff InjectionPolicy = "off"
+	// InjectionFlag keeps suspicious chunks in place but labels them in the
+	// prompt as untrusted data.
+	InjectionFlag InjectionPolicy = "flag"
+	// InjectionDemote labels suspicious chunks and moves them after the
+	// clean ones, so the token budget trims them first.
+	InjectionDemote InjectionPolicy = "demote"
+	// InjectionStrip removes the suspicious sentences from chunks and drops
+	// chunks with nothing left.
+	InjectionStrip InjectionPolicy = "strip"
+	// InjectionDrop removes suspicious chunks from the context entirely.
+	InjectionDrop InjectionPolicy = "drop"
+)
+
+// ParseInjectionPolicy parses an INJECTION_GUARD value; empty means off.
+func ParseInjectionPolicy(value string) (InjectionPolicy, error) {
+	switch policy := InjectionPolicy(strings.ToLower(strings.TrimSpace(value))); policy {
+	case "":
+		return InjectionOff, nil
+	case InjectionOff, InjectionFlag, InjectionDemote, InjectionStrip, InjectionDrop:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown injection guard policy %q (expected off, flag, demote, strip, or drop)", value)
+}
+
+// WithInjectionGuard scans retrieved chunks for text addressed to the model,
+// such as "ignore previous instructions" or fake chat role markers, and
+// handles the chunks that contain it according to policy before the prompt
+// is assembled. Documents can come from web pages, tickets, or anyone with
+// write access to a source, so their text must not be able to steer the
+// model.
+func WithInjectionGuard(policy InjectionPolicy) EngineOption {
+	return func(r *RAGEngine) {
+		r.injectionPolicy = policy
+	}
+}
+
+// injectionField is the metadata key set on chunks the guard flagged.
+const injectionField = "injection_suspected"
+
+// injectionPatterns match instruction-like text. They aim at phrasing that
+// addresses the model rather than describing instructions in general, to
+// keep false positives on ordinary documentation low.
+var injectionPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\b(ignore|disregard|forget|override|bypass)\b[^.!?\n]{0,40}\b(previous|prior|above|earlier|preceding|your|the system'?s?)\b[^.!?\n]{0,20}\b(instructions?|prompts?|directions|rules|guidelines|context)\b`),
+	regexp.MustCompile(`(?i)\b(new|updated|real|actual) (system )?instructions\s*:`),
+	regexp.MustCompile(`(?i)\b(from now on,? you (are|will|must|should)|you are no longer (bound|restricted|limited|an? ))`),
+	regexp.MustCompile(`(?i)\b(reveal|print|repeat|output|show)\b[^.!?\n]{0,20}\b(system|developer|hidden) (prompt|message|instructions)\b`),
+	regexp.MustCompile(`(?i)\bdo not (tell|inform|mention (this|it) to|let) the user\b`),
+	regexp.MustCompile(`(?i)\b(as an ai|as the assistant|assistant)\b[^.!?\n]{0,20}\byou (must|should|will) (now )?(always|only|never)\b`),
+	regexp.MustCompile(`(?im)^\s*(system|assistant|developer)\s*:\s*(you|from now on|ignore|always|never)\b`),
+	regexp.MustCompile(`(?i)<\|?(im_start|im_end|system|endoftext)\|?>|\[/?INST\]|<</?SYS>>`),
+}
+
+// findInjections returns the byte ranges of instruction-like text in text.
+func findInjections(text string) [][]int {
+	var spans [][]int
+	for _, pattern := range injectionPatterns {
+		spans = append(spans, pattern.FindAllStringIndex(text, -1)...)
+	}
+	return spans
+}
+
+// stripInjections removes the sentences containing instruction-like text,
+// marking where text was removed.
+func stripInjections(text string, spans [][]int) string {
+	remove := make([]bool, len(text))
+	for _, span := range spans {
+		start, end := span[0], span[1]
+		for start > 0 && !strings.ContainsRune(".!?\n", rune(text[start-1])) {
+			start--
+		}
+		for end < len(text) && !strings.ContainsRune(".!?\n", rune(text[end-1])) {
+			end++
+		}
+		for i := start; i < end; i++ {
+			remove[i] = true
+		}
+	}
+	var b strings.Builder
+	kept := false
+	for i := 0; i < len(text); {
+		if !remove[i] {
+			kept = kept || !strings.ContainsRune(" \t\n", rune(text[i]))
+			b.WriteByte(text[i])
+			i++
+			continue
+		}
+		for i < len(text) && remove[i] {
+			i++
+		}
+		b.WriteString(" [removed] ")
+	}
+	if !kept {
+		return ""
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// guardContext applies the injection policy to the documents about to be
+// formatted into a prompt.
+func (r *RAGEngine) guardContext(ctx context.Context, docs []Document) []Document {
+	if r.injectionPolicy == "" || r.injectionPolicy == InjectionOff {
+		return docs
+	}
+	var clean, flagged []Document
+	for _, doc := range docs {
+		spans := findInjections(doc.Text)
+		if len(spans) == 0 {
+			clean = append(clean, doc)
+			continue
+		}
+		injectionsTotal.WithLabelValues(string(r.injectionPolicy)).Inc()
+		slog.WarnContext(ctx, "Retrieved document contains instruction-like text",
+			"source", doc.Source,
+			"policy", r.injectionPolicy,
+			"match", truncateText(doc.Text[spans[0][0]:spans[0][1]], 80))
+
+		switch r.injectionPolicy {
+		case InjectionDrop:
+			continue
+		case InjectionStrip:
+			if doc.Text = stripInjections(doc.Text, spans); doc.Text == "" {
+				continue
+			}
+			clean = append(clean, doc)
+			continue
+		}
+		doc.Metadata = maps.Clone(doc.Metadata)
+		if doc.Metadata == nil {
+			doc.Metadata = map[string]any{}
+		}
+		doc.Metadata[injectionField] = true
+		if r.injectionPolicy == InjectionDemote {
+			flagged = append(flagged, doc)
+		} else {
+			clean = append(clean, doc)
+		}
+	}
+	return append(clean, flagged...)
+}
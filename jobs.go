@@ -0,0 +1,518 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrJobNotFound is returned by JobQueue for an unknown job ID.
+var ErrJobNotFound = errors.New("job not found")
+
+// JobStatus is the state of an ingestion job.
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+	JobCanceled  JobStatus = "canceled"
+)
+
+// done reports whether the status is final.
+func (s JobStatus) done() bool {
+	return s == JobSucceeded || s == JobFailed || s == JobCanceled
+}
+
+// JobProgress counts what an ingestion job has done so far.
+type JobProgress struct {
+	Documents          int `json:"documents"`           // documents submitted
+	DocumentsProcessed int `json:"documents_processed"` // documents chunked and stored
+	ChunksEmbedded     int `json:"chunks_embedded"`     // new or changed chunks embedded and stored
+	ChunksSkipped      int `json:"chunks_skipped"`      // chunks already stored unchanged
+	ChunksRemoved      int `json:"chunks_removed"`      // stale chunks deleted
+	ChunksDropped      int `json:"chunks_dropped"`      // chunks left out as noise
+}
+
+// IngestJob is an asynchronous ingestion of the documents of a POST /jobs
+// request.
+type IngestJob struct {
+	ID       string      `json:"id"`
+	Tenant   string      `json:"tenant,omitempty"`
+	Status   JobStatus   `json:"status"`
+	Created  time.Time   `json:"created"`
+	Started  *time.Time  `json:"started,omitempty"`
+	Finished *time.Time  `json:"finished,omitempty"`
+	Progress JobProgress `json:"progress"`
+	Error    string      `json:"error,omitempty"`
+}
+
+// jobBatchDocuments is how many documents a job ingests between progress
+// updates and cancellation checks.
+const jobBatchDocuments = 20
+
+// JobQueue runs ingestion jobs in the background. Jobs and their documents
+// are kept in a SQLite database, so queued jobs survive a restart, and jobs
+// that were running are started again; deduplication skips the chunks they
+// had already stored.
+type JobQueue struct {
+	db      *sql.DB
+	engine  *RAGEngine // jobs of a tenant run on engine.ForTenant
+	workers int
+	wake    chan struct{}
+
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc // of the running jobs
+}
+
+// NewJobQueue opens the job database at path, creating it if needed, and
+// returns a queue whose jobs ingest into engine on workers goroutines once
+// Run is called.
+func NewJobQueue(ctx context.Context, path string, engine *RAGEngine, workers int) (*JobQueue, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, fmt.Errorf("opening job database: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+	_, err = db.ExecContext(ctx, `
+CREATE TABLE IF NOT EXISTS jobs (
+	id       TEXT PRIMARY KEY,
+	tenant   TEXT NOT NULL,
+	status   TEXT NOT NULL,
+	created  INTEGER NOT NULL,
+	started  INTEGER NOT NULL DEFAULT 0,
+	finished INTEGER NOT NULL DEFAULT 0,
+	request  TEXT NOT NULL,
+	progress TEXT NOT NULL,
+	error    TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS jobs_status_idx ON jobs (status, created);`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("preparing job schema: %w", err)
+	}
+	return &JobQueue{db: db, engine: engine, workers: max(workers, 1), wake: make(chan struct{}, 1), cancels: make(map[string]context.CancelFunc)}, nil
+}
+
+// Close closes the database.
+func (q *JobQueue) Close() error {
+	return q.db.Close()
+}
+
+// Submit queues the ingestion of req's documents for tenant and returns the
+// job. The request must have been validated.
+func (q *JobQueue) Submit(ctx context.Context, tenant string, req documentsRequest) (IngestJob, error) {
+	job := IngestJob{
+		ID:       newQueryID(),
+		Tenant:   tenant,
+		Status:   JobQueued,
+		Created:  time.Now().UTC(),
+		Progress: JobProgress{Documents: len(req.Documents)},
+	}
+	request, err := json.Marshal(req)
+	if err != nil {
+		return job, err
+	}
+	progress, _ := json.Marshal(job.Progress)
+	_, err = q.db.ExecContext(ctx, "INSERT INTO jobs (id, tenant, status, created, request, progress) VALUES (?, ?, ?, ?, ?, ?)",
+		job.ID, job.Tenant, job.Status, job.Created.UnixNano(), string(request), string(progress))
+	if err != nil {
+		return job, fmt.Errorf("queueing job: %w", err)
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	slog.InfoContext(ctx, "Queued ingestion job", "job", job.ID, "documents", len(req.Documents))
+	return job, nil
+}
+
+// jobSelect reads jobs in the columns scanJob expects.
+const jobSelect = "SELECT id, tenant, status, created, started, finished, progress, error FROM jobs"
+
+// Get returns the job with id, or ErrJobNotFound.
+func (q *JobQueue) Get(ctx context.Context, id string) (IngestJob, error) {
+	job, err := scanJob(q.db.QueryRowContext(ctx, jobSelect+" WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return job, ErrJobNotFound
+	}
+	return job, err
+}
+
+// List returns the tenant's newest jobs, at most limit, optionally only those
+// with status.
+func (q *JobQueue) List(ctx context.Context, tenant string, status JobStatus, limit int) ([]IngestJob, error) {
+	stmt := jobSelect + " WHERE tenant = ?"
+	args := []any{tenant}
+	if status != "" {
+		stmt += " AND status = ?"
+		args = append(args, status)
+	}
+	stmt += " ORDER BY created DESC LIMIT ?"
+	args = append(args, limit)
+	rows, err := q.db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	jobs := []IngestJob{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// Cancel stops the job with id: a queued job is marked canceled, and a
+// running job stops in the batch it is storing and is marked canceled by its
+// worker. It returns the job, which is unchanged if it had already finished.
+func (q *JobQueue) Cancel(ctx context.Context, id string) (IngestJob, error) {
+	now := time.Now().UnixNano()
+	if _, err := q.db.ExecContext(ctx, "UPDATE jobs SET status = ?, finished = ? WHERE id = ? AND status = ?", JobCanceled, now, id, JobQueued); err != nil {
+		return IngestJob{}, err
+	}
+	q.mu.Lock()
+	if cancel, ok := q.cancels[id]; ok {
+		cancel()
+	}
+	q.mu.Unlock()
+	return q.Get(ctx, id)
+}
+
+// Run restarts the jobs that were running when the process stopped and runs
+// queued jobs until ctx is canceled.
+func (q *JobQueue) Run(ctx context.Context) {
+	if _, err := q.db.ExecContext(ctx, "UPDATE jobs SET status = ? WHERE status = ?", JobQueued, JobRunning); err != nil {
+		slog.ErrorContext(ctx, "Requeueing interrupted jobs failed", "error", err)
+	}
+	var wg sync.WaitGroup
+	for range q.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// work runs queued jobs one at a time until ctx is canceled.
+func (q *JobQueue) work(ctx context.Context) {
+	// Poll as well, in case a wake-up went to another worker.
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		job, req, err := q.claim(ctx)
+		if err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "Claiming a job failed", "error", err)
+		}
+		if job.ID != "" {
+			q.run(ctx, job, req)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// claim marks the oldest queued job running and returns it with its
+// request. It returns a zero job if none is queued.
+func (q *JobQueue) claim(ctx context.Context) (IngestJob, documentsRequest, error) {
+	var req documentsRequest
+	for {
+		var id, request string
+		err := q.db.QueryRowContext(ctx, "SELECT id, request FROM jobs WHERE status = ? ORDER BY created LIMIT 1", JobQueued).Scan(&id, &request)
+		if errors.Is(err, sql.ErrNoRows) {
+			return IngestJob{}, req, nil
+		}
+		if err != nil {
+			return IngestJob{}, req, err
+		}
+		result, err := q.db.ExecContext(ctx, "UPDATE jobs SET status = ?, started = ? WHERE id = ? AND status = ?", JobRunning, time.Now().UnixNano(), id, JobQueued)
+		if err != nil {
+			return IngestJob{}, req, err
+		}
+		if claimed, _ := result.RowsAffected(); claimed == 0 {
+			continue // another worker claimed it, or it was canceled
+		}
+		job, err := q.Get(ctx, id)
+		if err == nil {
+			err = json.Unmarshal([]byte(request), &req)
+		}
+		return job, req, err
+	}
+}
+
+// run ingests the job's documents in batches, recording progress after each
+// batch, and records how the job ended.
+func (q *JobQueue) run(ctx context.Context, job IngestJob, req documentsRequest) {
+	jobCtx, cancel := context.WithCancel(ctx)
+	q.mu.Lock()
+	q.cancels[job.ID] = cancel
+	q.mu.Unlock()
+	defer func() {
+		q.mu.Lock()
+		delete(q.cancels, job.ID)
+		q.mu.Unlock()
+		cancel()
+	}()
+
+	slog.InfoContext(ctx, "Running ingestion job", "job", job.ID, "documents", job.Progress.Documents)
+	err := q.ingest(jobCtx, &job, req)
+	switch {
+	case ctx.Err() != nil:
+		return // shutting down; the job runs again on the next start
+	case jobCtx.Err() != nil:
+		job.Status = JobCanceled
+	case err != nil:
+		job.Status = JobFailed
+		job.Error = err.Error()
+	default:
+		job.Status = JobSucceeded
+	}
+	finished := time.Now().UTC()
+	job.Finished = &finished
+	if err := q.finish(ctx, job); err != nil {
+		slog.ErrorContext(ctx, "Recording job result failed", "job", job.ID, "error", err)
+	}
+	switch job.Status {
+	case JobSucceeded:
+		webhooks.Notify(EventIngestionCompleted, job.Tenant, job)
+	case JobFailed:
+		webhooks.Notify(EventIngestionFailed, job.Tenant, job)
+	}
+	slog.InfoContext(ctx, "Ingestion job finished", "job", job.ID, "status", job.Status, "documents", job.Progress.DocumentsProcessed, "chunks_embedded", job.Progress.ChunksEmbedded, "error", job.Error)
+}
+
+// ingest stores the documents in batches of whole sources, so that
+// deduplication never sees part of a source, and saves the job's progress
+// after each batch.
+func (q *JobQueue) ingest(ctx context.Context, job *IngestJob, req documentsRequest) error {
+	engine := q.engine
+	if job.Tenant != "" {
+		var err error
+		if engine, err = engine.ForTenant(job.Tenant); err != nil {
+			return err
+		}
+		ctx = withUsageTenant(ctx, job.Tenant)
+	}
+	pages, err := req.pages()
+	if err != nil {
+		return err
+	}
+	if engine, err = req.engine(engine); err != nil {
+		return err
+	}
+
+	job.Progress = JobProgress{Documents: len(pages)}
+	for _, batch := range batchBySource(pages, jobBatchDocuments) {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		report, ok := ingestPages(ctx, engine, batch, req.ChunkSize, req.Overlap)
+		job.Progress.ChunksEmbedded += report.Stored()
+		job.Progress.ChunksSkipped += report.Skipped
+		job.Progress.ChunksRemoved += report.Removed
+		job.Progress.ChunksDropped += report.Dropped
+		if !ok {
+			return fmt.Errorf("storing documents %d-%d failed", job.Progress.DocumentsProcessed+1, job.Progress.DocumentsProcessed+len(batch))
+		}
+		job.Progress.DocumentsProcessed += len(batch)
+		progress, _ := json.Marshal(job.Progress)
+		if _, err := q.db.ExecContext(context.WithoutCancel(ctx), "UPDATE jobs SET progress = ? WHERE id = ?", string(progress), job.ID); err != nil {
+			slog.WarnContext(ctx, "Recording job progress failed", "job", job.ID, "error", err)
+		}
+	}
+	return nil
+}
+
+// finish records the job's final status, progress, and error.
+func (q *JobQueue) finish(ctx context.Context, job IngestJob) error {
+	progress, _ := json.Marshal(job.Progress)
+	_, err := q.db.ExecContext(ctx, "UPDATE jobs SET status = ?, finished = ?, progress = ?, error = ? WHERE id = ?",
+		job.Status, job.Finished.UnixNano(), string(progress), job.Error, job.ID)
+	return err
+}
+
+// batchBySource splits pages into batches of about size pages, keeping the
+// pages of a source together.
+func batchBySource(pages []Page, size int) [][]Page {
+	var order []string
+	bySource := make(map[string][]Page)
+	for _, page := range pages {
+		if _, ok := bySource[page.Source]; !ok {
+			order = append(order, page.Source)
+		}
+		bySource[page.Source] = append(bySource[page.Source], page)
+	}
+	var batches [][]Page
+	var batch []Page
+	for _, source := range order {
+		batch = append(batch, bySource[source]...)
+		if len(batch) >= size {
+			batches = append(batches, batch)
+			batch = nil
+		}
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+func scanJob(row interface{ Scan(...any) error }) (IngestJob, error) {
+	var job IngestJob
+	var created, started, finished int64
+	var progress string
+	if err := row.Scan(&job.ID, &job.Tenant, &job.Status, &created, &started, &finished, &progress, &job.Error); err != nil {
+		return job, err
+	}
+	job.Created = time.Unix(0, created).UTC()
+	if started > 0 {
+		t := time.Unix(0, started).UTC()
+		job.Started = &t
+	}
+	if finished > 0 {
+		t := time.Unix(0, finished).UTC()
+		job.Finished = &t
+	}
+	if err := json.Unmarshal([]byte(progress), &job.Progress); err != nil {
+		return job, fmt.Errorf("decoding progress of job %s: %w", job.ID, err)
+	}
+	return job, nil
+}
+
+// SetJobQueue enables the /jobs API, which queues ingestion on queue.
+func (s *Server) SetJobQueue(queue *JobQueue) {
+	s.jobs = queue
+}
+
+type jobListJSON struct {
+	Jobs []IngestJob `json:"jobs"`
+}
+
+// handleSubmitJob validates a documents request like POST /documents and
+// queues it, answering 202 Accepted with the job to poll.
+func (s *Server) handleSubmitJob(w http.ResponseWriter, r *http.Request) {
+	if s.jobs == nil {
+		writeError(w, http.StatusNotFound, "ingestion jobs are disabled")
+		return
+	}
+	var req documentsRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	engine := s.requestEngine(r)
+	if _, err := req.pages(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, err := req.engine(engine); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	job, err := s.jobs.Submit(r.Context(), engine.tenant, req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Queueing ingestion job failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "queueing the job failed")
+		return
+	}
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// handleJobs lists the tenant's jobs, newest first. It takes limit (default
+// 20, at most 100) and status.
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	if s.jobs == nil {
+		writeError(w, http.StatusNotFound, "ingestion jobs are disabled")
+		return
+	}
+	limit := 20
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > 100 {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and 100")
+			return
+		}
+		limit = n
+	}
+	status := JobStatus(r.URL.Query().Get("status"))
+	switch status {
+	case "", JobQueued, JobRunning, JobSucceeded, JobFailed, JobCanceled:
+	default:
+		writeError(w, http.StatusBadRequest, "status must be queued, running, succeeded, failed, or canceled")
+		return
+	}
+	jobs, err := s.jobs.List(r.Context(), s.requestEngine(r).tenant, status, limit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Listing jobs failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "listing jobs failed")
+		return
+	}
+	writeJSON(w, http.StatusOK, jobListJSON{Jobs: jobs})
+}
+
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	if s.jobs == nil {
+		writeError(w, http.StatusNotFound, "ingestion jobs are disabled")
+		return
+	}
+	job, err := s.visibleJob(r)
+	s.writeJobResult(w, r, job, err)
+}
+
+// handleCancelJob cancels a queued or running job. Canceling a finished job
+// answers 409 Conflict.
+func (s *Server) handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	if s.jobs == nil {
+		writeError(w, http.StatusNotFound, "ingestion jobs are disabled")
+		return
+	}
+	job, err := s.visibleJob(r)
+	if err == nil && job.Status.done() {
+		writeError(w, http.StatusConflict, fmt.Sprintf("job already %s", job.Status))
+		return
+	}
+	if err == nil {
+		job, err = s.jobs.Cancel(r.Context(), job.ID)
+	}
+	s.writeJobResult(w, r, job, err)
+}
+
+// visibleJob returns the job named by the request path if it belongs to the
+// request's tenant, or else ErrJobNotFound.
+func (s *Server) visibleJob(r *http.Request) (IngestJob, error) {
+	job, err := s.jobs.Get(r.Context(), r.PathValue("id"))
+	if err == nil && job.Tenant != s.requestEngine(r).tenant {
+		return IngestJob{}, ErrJobNotFound
+	}
+	return job, err
+}
+
+func (s *Server) writeJobResult(w http.ResponseWriter, r *http.Request, job IngestJob, err error) {
+	switch {
+	case errors.Is(err, ErrJobNotFound):
+		writeError(w, http.StatusNotFound, "job not found")
+	case err != nil:
+		slog.ErrorContext(r.Context(), "Reading job failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "reading the job failed")
+	default:
+		writeJSON(w, http.StatusOK, job)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForJob polls the queue until the job finishes.
+func waitForJob(t *testing.T, queue *JobQueue, id string) IngestJob {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := queue.Get(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status.done() {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return IngestJob{}
+}
+
+func TestJobsAPIIngestsInTheBackground(t *testing.T) {
+	server, store := newTestServer()
+	queue, err := NewJobQueue(context.Background(), filepath.Join(t.TempDir(), "jobs.db"), server.engine, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer queue.Close()
+	server.SetJobQueue(queue)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go queue.Run(ctx)
+
+	var docs []string
+	for i := range 45 {
+		docs = append(docs, fmt.Sprintf(`{"text":"Document number %d.","source":"doc-%d"}`, i, i))
+	}
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(`{"documents":[`+strings.Join(docs, ",")+`]}`)))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", rec.Code, rec.Body)
+	}
+	var job IngestJob
+	json.NewDecoder(rec.Body).Decode(&job)
+	if job.Status != JobQueued || rec.Header().Get("Location") != "/jobs/"+job.ID {
+		t.Fatalf("unexpected job %+v", job)
+	}
+
+	job = waitForJob(t, queue, job.ID)
+	want := JobProgress{Documents: 45, DocumentsProcessed: 45, ChunksEmbedded: 45}
+	if job.Status != JobSucceeded || job.Progress != want || job.Started == nil || job.Finished == nil {
+		t.Fatalf("unexpected finished job %+v", job)
+	}
+	if store.Len() != 45 {
+		t.Fatalf("expected 45 stored chunks, got %d", store.Len())
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs?status=succeeded", nil))
+	var list jobListJSON
+	json.NewDecoder(rec.Body).Decode(&list)
+	if len(list.Jobs) != 1 || list.Jobs[0].ID != job.ID {
+		t.Fatalf("unexpected job list %+v", list)
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/jobs/"+job.ID+"/cancel", nil))
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409 canceling a finished job, got %d", rec.Code)
+	}
+
+	for _, tc := range []struct{ method, path, body string }{
+		{http.MethodPost, "/jobs", `{"documents":[{"text":"no source"}]}`},
+		{http.MethodPost, "/jobs", `{"documents":[{"text":"t","source":"s"}],"expires_at":"soon"}`},
+		{http.MethodGet, "/jobs?status=lost", ""},
+	} {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s %s: expected 400, got %d", tc.method, tc.path, rec.Code)
+		}
+	}
+}
+
+func TestJobsAreCanceledAndResumed(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "jobs.db")
+	store := NewMemoryStore(NewHashingEmbedder(64))
+	engine := NewRAGEngine(&dummyOpenAI{}, store)
+	queue, err := NewJobQueue(ctx, path, engine, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := documentsRequest{Documents: []documentJSON{{Text: "Queued text.", Source: "a"}}, ChunkSize: 1000, Overlap: 200}
+
+	canceled, _ := queue.Submit(ctx, "", req)
+	if job, err := queue.Cancel(ctx, canceled.ID); err != nil || job.Status != JobCanceled {
+		t.Fatalf("Cancel = %+v, %v", job, err)
+	}
+	if _, err := queue.Get(ctx, "missing"); err != ErrJobNotFound {
+		t.Fatalf("expected ErrJobNotFound, got %v", err)
+	}
+
+	// A job left running by a stopped process starts again.
+	interrupted, _ := queue.Submit(ctx, "", req)
+	queue.db.Exec("UPDATE jobs SET status = ? WHERE id = ?", JobRunning, interrupted.ID)
+	queue.Close()
+
+	queue, err = NewJobQueue(ctx, path, engine, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer queue.Close()
+	runCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	go queue.Run(runCtx)
+	if job := waitForJob(t, queue, interrupted.ID); job.Status != JobSucceeded || store.Len() != 1 {
+		t.Fatalf("expected the interrupted job to run again, got %+v with %d chunks", job, store.Len())
+	}
+	if job, _ := queue.Get(ctx, canceled.ID); job.Status != JobCanceled {
+		t.Fatalf("expected the canceled job to stay canceled, got %s", job.Status)
+	}
+}
+
+func TestBatchBySourceKeepsSourcesTogether(t *testing.T) {
+	pages := []Page{{Source: "a"}, {Source: "b"}, {Source: "a"}, {Source: "c"}, {Source: "d"}}
+	var sizes []string
+	for _, batch := range batchBySource(pages, 2) {
+		var sources []string
+		for _, page := range batch {
+			sources = append(sources, page.Source)
+		}
+		sizes = append(sizes, strings.Join(sources, ""))
+	}
+	if got := strings.Join(sizes, " "); got != "aa bc d" {
+		t.Fatalf("batches = %s", got)
+	}
+}
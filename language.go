@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// langField is the metadata key holding the ISO 639-1 code of a chunk's
+// natural language, e.g. "de". It is not "language", which code chunks use
+// for their programming language.
+const langField = "lang"
+
+// languageNames are the languages detectLanguage can tell apart, by ISO
+// 639-1 code.
+var languageNames = map[string]string{
+	"ar": "Arabic", "de": "German", "el": "Greek", "en": "English", "es": "Spanish",
+	"fr": "French", "he": "Hebrew", "hi": "Hindi", "it": "Italian", "ja": "Japanese",
+	"ko": "Korean", "nl": "Dutch", "pt": "Portuguese", "ru": "Russian", "th": "Thai",
+	"uk": "Ukrainian", "zh": "Chinese",
+}
+
+// languageWords are frequent words of the languages written in Latin
+// script, which tell them apart where the script cannot.
+var languageWords = map[string][]string{
+	"en": {"the", "and", "of", "to", "is", "in", "that", "it", "for", "with", "are", "was", "on", "this", "what", "how", "does", "do", "which", "who"},
+	"es": {"el", "la", "los", "las", "de", "que", "y", "en", "es", "un", "una", "por", "para", "con", "del", "se", "qué", "cómo", "cuál", "dónde"},
+	"fr": {"le", "la", "les", "de", "des", "et", "est", "un", "une", "que", "qui", "dans", "pour", "pas", "sur", "du", "au", "avec", "comment", "quel"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "ein", "eine", "zu", "den", "mit", "von", "auf", "für", "sich", "dem", "wie", "was", "wer", "wird"},
+	"it": {"il", "lo", "la", "gli", "di", "che", "e", "è", "un", "una", "per", "non", "con", "del", "della", "sono", "come", "cosa", "quale", "perché"},
+	"pt": {"o", "a", "os", "as", "de", "que", "e", "do", "da", "em", "um", "uma", "para", "com", "não", "é", "dos", "como", "qual", "onde"},
+	"nl": {"de", "het", "een", "en", "van", "is", "dat", "op", "te", "in", "voor", "niet", "met", "zijn", "er", "wat", "hoe", "wie", "ook", "bij"},
+}
+
+// detectLanguage returns the ISO 639-1 code of the language text is
+// written in, or "" when it cannot tell. Scripts identify most languages;
+// Latin-script languages are told apart by their most frequent words, and
+// Cyrillic Ukrainian by its own letters.
+func detectLanguage(text string) string {
+	scripts := map[string]int{}
+	letters := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			scripts["ja"]++
+		case unicode.Is(unicode.Han, r):
+			scripts["zh"]++
+		case unicode.Is(unicode.Hangul, r):
+			scripts["ko"]++
+		case unicode.Is(unicode.Cyrillic, r):
+			scripts["ru"]++
+			if strings.ContainsRune("іїєґІЇЄҐ", r) {
+				scripts["uk"]++
+			}
+		case unicode.Is(unicode.Arabic, r):
+			scripts["ar"]++
+		case unicode.Is(unicode.Hebrew, r):
+			scripts["he"]++
+		case unicode.Is(unicode.Greek, r):
+			scripts["el"]++
+		case unicode.Is(unicode.Devanagari, r):
+			scripts["hi"]++
+		case unicode.Is(unicode.Thai, r):
+			scripts["th"]++
+		case unicode.Is(unicode.Latin, r):
+			scripts["latin"]++
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+	// Japanese mixes kana with Han characters, so any kana decides it.
+	if scripts["ja"] > 0 && scripts["ja"]+scripts["zh"] >= letters/2 {
+		return "ja"
+	}
+	if scripts["uk"] > 0 {
+		return "uk"
+	}
+	script, count := "", 0
+	for name, n := range scripts {
+		if name != "uk" && (n > count || (n == count && name < script)) {
+			script, count = name, n
+		}
+	}
+	if count < letters/2 {
+		return ""
+	}
+	if script != "latin" {
+		return script
+	}
+
+	scores := map[string]int{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) && r != '\'' }) {
+		for lang, words := range languageWords {
+			if slices.Contains(words, word) {
+				scores[lang]++
+			}
+		}
+	}
+	best, second := "", 0
+	for _, lang := range slices.Sorted(maps.Keys(scores)) {
+		if scores[lang] > scores[best] {
+			best, second = lang, scores[best]
+		} else if scores[lang] > second {
+			second = scores[lang]
+		}
+	}
+	if best == "" || scores[best] == second {
+		return ""
+	}
+	return best
+}
+
+// detectPageLanguages records the language of every page of natural
+// language text that does not name its own in its metadata, so that
+// retrieval can be restricted to it with WithLanguages.
+func detectPageLanguages(pages []Page) []Page {
+	detected := make([]Page, len(pages))
+	for i, page := range pages {
+		detected[i] = page
+		if page.Format == "code" || page.Metadata[langField] != nil {
+			continue
+		}
+		if lang := detectLanguage(page.Text); lang != "" {
+			detected[i].Metadata = maps.Clone(page.Metadata)
+			if detected[i].Metadata == nil {
+				detected[i].Metadata = map[string]any{}
+			}
+			detected[i].Metadata[langField] = lang
+		}
+	}
+	return detected
+}
+
+// WithLanguages restricts retrieval to documents in one of langs, given as
+// ISO 639-1 codes such as "en". Documents whose language was not detected
+// are left out too.
+func WithLanguages(langs ...string) RetrieveOption {
+	return func(c *retrieveConfig) {
+		c.languages = slices.Clone(langs)
+	}
+}
+
+// parseLanguages splits a comma-separated list of ISO 639-1 codes of
+// languages detectLanguage knows. It returns nil for an empty list.
+func parseLanguages(list string) ([]string, error) {
+	var langs []string
+	for _, lang := range strings.Split(list, ",") {
+		lang = strings.ToLower(strings.TrimSpace(lang))
+		if lang == "" || slices.Contains(langs, lang) {
+			continue
+		}
+		if languageNames[lang] == "" {
+			return nil, fmt.Errorf("unknown language %q (expected an ISO 639-1 code such as en, de, or es)", lang)
+		}
+		langs = append(langs, lang)
+	}
+	return langs, nil
+}
+
+// CrossLingual searches a knowledge base written in other languages than
+// the questions: the question is also searched translated into each of
+// Languages, and the answer is written in the question's language.
+type CrossLingual struct {
+	Languages []string // ISO 639-1 codes of the knowledge base's languages
+	Model     string   // translates the questions
+}
+
+// WithCrossLingual enables cross-lingual retrieval and answers.
+func WithCrossLingual(cross CrossLingual) EngineOption {
+	return func(r *RAGEngine) {
+		r.crossLingual = &cross
+	}
+}
+
+// translateQuery returns the query translated into every language of the
+// knowledge base other than its own. Failed translations are left out, so
+// retrieval goes on with the query as written.
+func (r *RAGEngine) translateQuery(ctx context.Context, query string) []string {
+	queryLang := detectLanguage(query)
+	ctx, span := tracer.Start(ctx, "rag.translate_query", trace.WithAttributes(attribute.String("rag.query_language", queryLang)))
+	defer span.End()
+
+	var translations []string
+	for _, lang := range r.crossLingual.Languages {
+		if lang == queryLang {
+			continue
+		}
+		messages := []Message{
+			{Role: "system", Content: "You translate search queries."},
+			{Role: "user", Content: fmt.Sprintf("Translate the question below into %s, keeping names, product terms, and codes as they are. "+
+				"Reply with the translation only.\n\nQuestion: %s", languageNames[lang], query)},
+		}
+		translation, err := r.llm.ChatCompletion(ctx, r.crossLingual.Model, messages)
+		translation = strings.Trim(strings.TrimSpace(translation), "\"")
+		if err != nil {
+			slog.WarnContext(ctx, "Could not translate query", "language", lang, "error", err)
+			continue
+		}
+		if translation != "" && !strings.EqualFold(translation, query) && !slices.Contains(translations, translation) {
+			translations = append(translations, translation)
+		}
+	}
+	span.SetAttributes(attribute.Int("rag.translations", len(translations)))
+	slog.InfoContext(ctx, "Translated query", "query", query, "language", queryLang, "translations", translations)
+	return translations
+}
+
+// answerLanguagePrompt asks for the answer in the language of query, when
+// it can be detected, since the context may be in another.
+func answerLanguagePrompt(question, query string) string {
+	name := languageNames[detectLanguage(query)]
+	if name == "" {
+		return question
+	}
+	return question + "\n\nWrite the answer in " + name + ", whatever the language of the context."
+}
+
+// crossLingualFromEnv configures cross-lingual mode from CROSS_LINGUAL, the
+// comma-separated languages of the knowledge base, translating with
+// CROSS_LINGUAL_MODEL (default: the chat model). It returns nil when
+// CROSS_LINGUAL is unset or "off".
+func crossLingualFromEnv(chatModel string) (*CrossLingual, error) {
+	raw := os.Getenv("CROSS_LINGUAL")
+	if raw == "" || raw == "off" {
+		return nil, nil
+	}
+	langs, err := parseLanguages(raw)
+	if err != nil || len(langs) == 0 {
+		return nil, fmt.Errorf("invalid CROSS_LINGUAL %q (expected the knowledge base's languages, e.g. en,de)", raw)
+	}
+	model := os.Getenv("CROSS_LINGUAL_MODEL")
+	if model == "" {
+		model = chatModel
+	}
+	return &CrossLingual{Languages: langs, Model: model}, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	for text, want := range map[string]string{
+		"How does the billing service store invoices?":             "en",
+		"¿Dónde guarda el servicio las facturas de los clientes?":  "es",
+		"Wie speichert der Dienst die Rechnungen für die Kunden?":  "de",
+		"Comment le service stocke-t-il les factures des clients?": "fr",
+		"Onde o serviço guarda as faturas dos clientes?":           "pt",
+		"Где сервис хранит счета?":                                 "ru",
+		"Де сервіс зберігає рахунки?":                              "uk",
+		"サービスは請求書をどこに保存しますか？":                                      "ja",
+		"服务把发票存储在哪里？":                                              "zh",
+		"Kubernetes Milvus":                                        "",
+		"42 + 7":                                                   "",
+	} {
+		if got := detectLanguage(text); got != want {
+			t.Errorf("detectLanguage(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestEngineFiltersByDetectedLanguage(t *testing.T) {
+	ctx := context.Background()
+	engine := NewRAGEngine(&dummyOpenAI{}, NewMemoryStore(NewHashingEmbedder(256)))
+	ingestPages(ctx, engine, []Page{
+		{Text: "The billing service stores the invoices in Postgres.", Source: "billing-en.md"},
+		{Text: "Der Abrechnungsdienst speichert die Rechnungen in Postgres.", Source: "billing-de.md"},
+		{Text: "func main() { fmt.Println(\"the invoices\") }", Source: "main.go", Format: "code"},
+	}, 1000, 0)
+
+	docs := engine.Retrieve(ctx, "invoices Postgres", 3, WithLanguages("de"))
+	if len(docs) != 1 || docs[0].Source != "billing-de.md" || docs[0].Metadata[langField] != "de" {
+		t.Fatalf("expected the German document only, got %+v", docs)
+	}
+	docs = engine.Retrieve(ctx, "invoices Postgres", 3, WithLanguages("en"), WithFilter(Filter{Eq("source", "billing-en.md")}))
+	if len(docs) != 1 {
+		t.Fatalf("expected the language and the filter to both apply, got %+v", docs)
+	}
+}
+
+func TestCrossLingualTranslatesTheQueryAndAnswersInItsLanguage(t *testing.T) {
+	ctx := context.Background()
+	oa := &sequenceOpenAI{replies: []string{
+		"Where are the invoices stored?",
+		"Die Rechnungen liegen in Postgres [1].",
+	}}
+	store := NewMemoryStore(NewHashingEmbedder(256))
+	engine := NewRAGEngine(oa, store, WithCrossLingual(CrossLingual{Languages: []string{"en", "de"}, Model: "gpt-test"}))
+	ingestPages(ctx, engine, []Page{
+		{Text: "Where are the invoices stored? The invoices are stored in Postgres.", Source: "billing.md"},
+		{Text: "The cafeteria serves lunch at noon.", Source: "lunch.md"},
+	}, 1000, 0)
+
+	question := "Wo werden die Rechnungen gespeichert?"
+	docs := engine.Retrieve(ctx, question, 1)
+	if len(oa.calls) != 1 || !strings.Contains(oa.calls[0][1].Content, "into English") {
+		t.Fatalf("expected one translation into English only, got %+v", oa.calls)
+	}
+	if len(docs) != 1 || docs[0].Source != "billing.md" {
+		t.Fatalf("expected the English document through the translation, got %+v", docs)
+	}
+	if _, err := engine.GenerateResponse(ctx, question, docs, "gpt-test"); err != nil {
+		t.Fatal(err)
+	}
+	if prompt := oa.calls[1][len(oa.calls[1])-1].Content; !strings.Contains(prompt, "Write the answer in German") {
+		t.Fatalf("expected the answer language in the prompt, got %q", prompt)
+	}
+}
+
+func TestServerRejectsUnknownLanguages(t *testing.T) {
+	server, _ := newTestServer()
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"question":"q","languages":["english"]}`)))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "unknown language") {
+		t.Fatalf("unexpected response %d %s", rec.Code, rec.Body.String())
+	}
+}
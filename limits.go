@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/milvus-io/milvus-sdk-go/v2/client"
+	"github.com/milvus-io/milvus-sdk-go/v2/entity"
+	"golang.org/x/time/rate"
+)
+
+// Limits caps the request rate and the number of concurrent requests to one
+// backend, so bulk ingestion stays within API rate limits and does not
+// overload the vector database. Calls wait for their turn rather than fail.
+type Limits struct {
+	limiter  *rate.Limiter // nil means no rate limit
+	inFlight chan struct{} // nil means no concurrency cap
+}
+
+// NewLimits allows perSecond requests per second, in bursts of up to one
+// second's worth, with at most maxInFlight at a time. Zero disables either
+// limit; with both zero it returns nil, which limits nothing.
+func NewLimits(perSecond float64, maxInFlight int) *Limits {
+	if perSecond <= 0 && maxInFlight <= 0 {
+		return nil
+	}
+	l := &Limits{}
+	if perSecond > 0 {
+		l.limiter = rate.NewLimiter(rate.Limit(perSecond), max(1, int(perSecond)))
+	}
+	if maxInFlight > 0 {
+		l.inFlight = make(chan struct{}, maxInFlight)
+	}
+	return l
+}
+
+// acquire waits for a concurrency slot and a rate token. The returned
+// function frees the slot.
+func (l *Limits) acquire(ctx context.Context) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	release = func() {}
+	if l.inFlight != nil {
+		select {
+		case l.inFlight <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		var once sync.Once
+		release = func() { once.Do(func() { <-l.inFlight }) }
+	}
+	if l.limiter != nil {
+		if err := l.limiter.Wait(ctx); err != nil {
+			release()
+			return nil, err
+		}
+	}
+	return release, nil
+}
+
+// transport wraps base so that its requests observe the limits. A request
+// holds its concurrency slot until the response body is closed.
+func (l *Limits) transport(base http.RoundTripper) http.RoundTripper {
+	if l == nil {
+		return base
+	}
+	return &limitedTransport{base: base, limits: l}
+}
+
+type limitedTransport struct {
+	base   http.RoundTripper
+	limits *Limits
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	release, err := t.limits.acquire(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	defer b.release()
+	return b.ReadCloser.Close()
+}
+
+// limitedMilvusClient applies Limits to the Milvus calls that read or write
+// documents. Collection management calls pass through unlimited.
+type limitedMilvusClient struct {
+	client.Client
+	limits *Limits
+}
+
+// limitMilvus returns c with its data calls limited by limits.
+func limitMilvus(c client.Client, limits *Limits) client.Client {
+	if limits == nil {
+		return c
+	}
+	return &limitedMilvusClient{Client: c, limits: limits}
+}
+
+func (c *limitedMilvusClient) Insert(ctx context.Context, collName string, partitionName string, columns ...entity.Column) (entity.Column, error) {
+	release, err := c.limits.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return c.Client.Insert(ctx, collName, partitionName, columns...)
+}
+
+func (c *limitedMilvusClient) Flush(ctx context.Context, collName string, async bool, opts ...client.FlushOption) error {
+	release, err := c.limits.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return c.Client.Flush(ctx, collName, async, opts...)
+}
+
+func (c *limitedMilvusClient) Delete(ctx context.Context, collName string, partitionName string, expr string) error {
+	release, err := c.limits.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return c.Client.Delete(ctx, collName, partitionName, expr)
+}
+
+func (c *limitedMilvusClient) DeleteByPks(ctx context.Context, collName string, partitionName string, ids entity.Column) error {
+	release, err := c.limits.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return c.Client.DeleteByPks(ctx, collName, partitionName, ids)
+}
+
+func (c *limitedMilvusClient) Search(ctx context.Context, collName string, partitions []string, expr string, outputFields []string, vectors []entity.Vector, vectorField string, metricType entity.MetricType, topK int, sp entity.SearchParam, opts ...client.SearchQueryOptionFunc) ([]client.SearchResult, error) {
+	release, err := c.limits.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return c.Client.Search(ctx, collName, partitions, expr, outputFields, vectors, vectorField, metricType, topK, sp, opts...)
+}
+
+func (c *limitedMilvusClient) HybridSearch(ctx context.Context, collName string, partitions []string, limit int, outputFields []string, reranker client.Reranker, subRequests []*client.ANNSearchRequest, opts ...client.SearchQueryOptionFunc) ([]client.SearchResult, error) {
+	release, err := c.limits.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return c.Client.HybridSearch(ctx, collName, partitions, limit, outputFields, reranker, subRequests, opts...)
+}
+
+func (c *limitedMilvusClient) Query(ctx context.Context, collectionName string, partitionNames []string, expr string, outputFields []string, opts ...client.SearchQueryOptionFunc) (client.ResultSet, error) {
+	release, err := c.limits.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return c.Client.Query(ctx, collectionName, partitionNames, expr, outputFields, opts...)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLimitsCapConcurrency(t *testing.T) {
+	var current, peak atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := current.Add(1)
+		for {
+			old := peak.Load()
+			if n <= old || peak.CompareAndSwap(old, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		current.Add(-1)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: NewLimits(0, 2).transport(http.DefaultTransport)}
+	var wg sync.WaitGroup
+	for range 6 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(server.URL)
+			if err != nil {
+				t.Errorf("request failed: %v", err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+	if peak.Load() != 2 {
+		t.Fatalf("expected at most 2 requests in flight, saw %d", peak.Load())
+	}
+}
+
+func TestLimitsRate(t *testing.T) {
+	limits := NewLimits(100, 0) // bursts of 100
+	start := time.Now()
+	for range 120 {
+		release, err := limits.acquire(context.Background())
+		if err != nil {
+			t.Fatalf("acquire returned error: %v", err)
+		}
+		release()
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("expected 20 requests beyond the burst to take about 200ms, took %s", elapsed)
+	}
+}
+
+func TestLimitsHonorCancellation(t *testing.T) {
+	limits := NewLimits(0, 1)
+	release, _ := limits.acquire(context.Background())
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := limits.acquire(ctx); err == nil {
+		t.Fatalf("expected acquire to give up when the context ends")
+	}
+}
+
+func TestNewLimitsDisabled(t *testing.T) {
+	if limits := NewLimits(0, 0); limits != nil {
+		t.Fatalf("expected no limits, got %+v", limits)
+	}
+	var limits *Limits
+	if limits.transport(http.DefaultTransport) != http.DefaultTransport {
+		t.Fatalf("expected the base transport when nothing is limited")
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// loadTestStages are the stages a load test times, in the order reported.
+var loadTestStages = []string{"connect", "retrieve", "first_token", "generate", "total"}
+
+// LoadTest sends questions to the /query/stream endpoint of a running
+// server at a fixed rate, whether or not earlier requests have been
+// answered, as independent clients would. The stream's events mark where
+// each request's stages end: the response headers, the end of retrieval,
+// the first token of the answer, and the final citations.
+type LoadTest struct {
+	URL       string
+	APIKey    string
+	Questions []string
+	QPS       float64
+	Duration  time.Duration
+	// MaxInFlight caps the requests awaiting their answer; a request due
+	// while it is reached is dropped. 0 means 1000.
+	MaxInFlight int
+	Client      *http.Client
+}
+
+// LoadTestStage is the latency and errors of one stage over a load test.
+// Its Stats.Ops counts the requests that completed the stage, and
+// Stats.Errors those that failed in it.
+type LoadTestStage struct {
+	Name  string
+	Stats LatencyStats
+}
+
+// ErrorRate returns the share of the requests reaching the stage that
+// failed in it.
+func (s LoadTestStage) ErrorRate() float64 {
+	if s.Stats.Ops+s.Stats.Errors == 0 {
+		return 0
+	}
+	return float64(s.Stats.Errors) / float64(s.Stats.Ops+s.Stats.Errors)
+}
+
+// LoadTestReport is the outcome of a load test.
+type LoadTestReport struct {
+	Sent, Dropped int
+	// Canceled counts the requests cut short by canceling the test; they
+	// are left out of the stages.
+	Canceled int
+	Elapsed  time.Duration
+	Stages   []LoadTestStage
+	// Failures counts the failed requests by what went wrong, such as
+	// "status 429" or "error event".
+	Failures map[string]int
+}
+
+// loadTestResult is what one request of a load test observed. Each
+// duration is measured from the start of the request, and is zero when
+// the stage was not reached.
+type loadTestResult struct {
+	connect, retrieved, generating, firstToken, done time.Duration
+	failedStage                                      string
+	failure                                          string
+}
+
+// Run sends requests until the test's duration has passed or ctx is
+// canceled, then waits for the requests in flight.
+func (t *LoadTest) Run(ctx context.Context) LoadTestReport {
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	maxInFlight := t.MaxInFlight
+	if maxInFlight <= 0 {
+		maxInFlight = 1000
+	}
+	sending, stop := context.WithTimeout(ctx, t.Duration)
+	defer stop()
+
+	var mu sync.Mutex
+	var results []loadTestResult
+	var wg sync.WaitGroup
+	inFlight := make(chan struct{}, maxInFlight)
+	report := LoadTestReport{Failures: make(map[string]int)}
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / t.QPS))
+	defer ticker.Stop()
+	start := time.Now()
+	for i := 0; sending.Err() == nil; i++ {
+		select {
+		case inFlight <- struct{}{}:
+			report.Sent++
+			wg.Add(1)
+			go func(question string) {
+				defer wg.Done()
+				defer func() { <-inFlight }()
+				result := t.send(ctx, client, question)
+				mu.Lock()
+				results = append(results, result)
+				mu.Unlock()
+			}(t.Questions[i%len(t.Questions)])
+		default:
+			report.Dropped++
+		}
+		select {
+		case <-ticker.C:
+		case <-sending.Done():
+		}
+	}
+	wg.Wait()
+	report.Elapsed = time.Since(start)
+
+	latencies := make(map[string][]time.Duration)
+	failed := make(map[string]int)
+	for _, result := range results {
+		if result.failure == "canceled" {
+			report.Canceled++
+			continue
+		}
+		reached := map[string]time.Duration{
+			"connect": result.connect, "retrieve": result.retrieved, "first_token": result.firstToken, "total": result.done,
+		}
+		if result.generating > 0 && result.done > 0 {
+			reached["generate"] = result.done - result.generating
+		}
+		for stage, latency := range reached {
+			if latency > 0 {
+				latencies[stage] = append(latencies[stage], latency)
+			}
+		}
+		if result.failure != "" {
+			failed[result.failedStage]++
+			failed["total"]++
+			report.Failures[result.failure]++
+		}
+	}
+	for _, stage := range loadTestStages {
+		report.Stages = append(report.Stages, LoadTestStage{Name: stage, Stats: latencyStats(latencies[stage], failed[stage], report.Elapsed)})
+	}
+	return report
+}
+
+// send asks question over the stream endpoint and times its events.
+func (t *LoadTest) send(ctx context.Context, client *http.Client, question string) (result loadTestResult) {
+	start := time.Now()
+	fail := func(stage, failure string) loadTestResult {
+		if ctx.Err() != nil {
+			failure = "canceled"
+		}
+		result.failedStage, result.failure = stage, failure
+		return result
+	}
+	body, _ := json.Marshal(map[string]string{"question": question})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(t.URL, "/")+"/query/stream", bytes.NewReader(body))
+	if err != nil {
+		return fail("connect", "invalid request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if t.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+t.APIKey)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fail("connect", "connection failed")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fail("connect", fmt.Sprintf("status %d", resp.StatusCode))
+	}
+	result.connect = time.Since(start)
+
+	stage := "retrieve"
+	event := ""
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if name, ok := strings.CutPrefix(line, "event: "); ok {
+			event = name
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		elapsed := time.Since(start)
+		switch event {
+		case "status":
+			var status streamStatusJSON
+			json.Unmarshal([]byte(data), &status)
+			switch status.Stage {
+			case "retrieved":
+				result.retrieved, stage = elapsed, "generate"
+			case "generating":
+				result.generating = elapsed
+			}
+		case "token":
+			if result.firstToken == 0 {
+				result.firstToken = elapsed
+			}
+		case "citations":
+			result.done = elapsed
+			return result
+		case "error":
+			return fail(stage, "error event")
+		}
+	}
+	return fail(stage, "stream ended early")
+}
+
+// loadQuestions reads the questions of a load test from path: one per line,
+// or JSONL records with a "question" field such as an eval dataset's.
+// Blank lines are skipped.
+func loadQuestions(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var questions []string
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "{"):
+			var record struct {
+				Question string `json:"question"`
+			}
+			if err := json.Unmarshal([]byte(line), &record); err != nil || record.Question == "" {
+				return nil, fmt.Errorf("%s:%d: expected a JSON record with a question", path, n+1)
+			}
+			line = record.Question
+		}
+		questions = append(questions, line)
+	}
+	if len(questions) == 0 {
+		return nil, fmt.Errorf("%s has no questions", path)
+	}
+	return questions, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoadQuestions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queries.txt")
+	os.WriteFile(path, []byte("Who made Go?\n\n{\"question\": \"What is Milvus?\", \"expected_sources\": [\"milvus.md\"]}\n"), 0o644)
+	questions, err := loadQuestions(path)
+	if err != nil || !slices.Equal(questions, []string{"Who made Go?", "What is Milvus?"}) {
+		t.Fatalf("unexpected questions %q (%v)", questions, err)
+	}
+	os.WriteFile(path, []byte("{\"q\": \"missing\"}\n"), 0o644)
+	if _, err := loadQuestions(path); err == nil {
+		t.Fatal("expected a record without a question to be rejected")
+	}
+}
+
+func TestLoadTestReportsStages(t *testing.T) {
+	store := NewMemoryStore(NewHashingEmbedder(256))
+	store.InsertDocuments(context.Background(), []string{"Go is a language from Google."}, []string{"Go Docs"}, nil)
+	server := NewServer(NewRAGEngine(&streamingOpenAI{pieces: []string{"Go was made ", "at Google [1]."}}, store), "gpt-test")
+	var requests atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1)%4 == 0 {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		server.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	test := &LoadTest{URL: ts.URL + "/", Questions: []string{"Who made Go?"}, QPS: 100, Duration: 200 * time.Millisecond}
+	report := test.Run(context.Background())
+	if report.Sent < 10 || report.Dropped != 0 || report.Canceled != 0 {
+		t.Fatalf("expected about 20 requests sent, got %+v", report)
+	}
+	stages := make(map[string]LatencyStats)
+	for _, stage := range report.Stages {
+		stages[stage.Name] = stage.Stats
+	}
+	failed := report.Failures["status 503"]
+	if failed == 0 || stages["connect"].Errors != failed || stages["total"].Errors != failed || stages["total"].Ops != report.Sent-failed {
+		t.Fatalf("expected every fourth request to fail to connect, got %+v and %v", stages, report.Failures)
+	}
+	for _, name := range []string{"retrieve", "first_token", "generate"} {
+		if stages[name].Ops != stages["total"].Ops || stages[name].Errors != 0 || stages[name].P50 > stages["total"].P99 {
+			t.Errorf("unexpected %s stage %+v", name, stages[name])
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// setupLogging installs the default slog logger. LOG_LEVEL selects the
+// minimum level (debug, info, warn, or error; default info) and LOG_FORMAT the
+// output: "pretty" (default) for a compact console view, "json" for log
+// collectors, or "text" for logfmt-style lines. Every format adds the query
+// ID and trace ID carried by the context of the log call.
+func setupLogging(w io.Writer) error {
+	var level slog.Level
+	if raw := os.Getenv("LOG_LEVEL"); raw != "" {
+		if err := level.UnmarshalText([]byte(raw)); err != nil {
+			return fmt.Errorf("invalid LOG_LEVEL %q (expected debug, info, warn, or error)", raw)
+		}
+	}
+
+	var handler slog.Handler
+	switch format := os.Getenv("LOG_FORMAT"); format {
+	case "", "pretty":
+		handler = newPrettyHandler(w, level)
+	case "json":
+		handler = slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})
+	case "text":
+		handler = slog.NewTextHandler(w, &slog.HandlerOptions{Level: level})
+	default:
+		return fmt.Errorf("unknown LOG_FORMAT %q (expected pretty, json, or text)", format)
+	}
+	slog.SetDefault(slog.New(contextHandler{handler}))
+	return nil
+}
+
+type queryIDKey struct{}
+
+// withQueryID returns a context whose log lines carry id as query_id.
+func withQueryID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, queryIDKey{}, id)
+}
+
+// newQueryID returns a random ID for correlating the log lines of a request.
+func newQueryID() string {
+	id, err := newUUID()
+	if err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return id
+}
+
+// contextHandler adds the query ID and the active trace ID from the context
+// to every record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id, ok := ctx.Value(queryIDKey{}).(string); ok {
+		r.AddAttrs(slog.String("query_id", id))
+	}
+	if span := trace.SpanContextFromContext(ctx); span.HasTraceID() {
+		r.AddAttrs(slog.String("trace_id", span.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// prettyHandler writes one human-readable line per record: the time, a level
+// marker, the message, and the attributes as key=value pairs.
+type prettyHandler struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	level  slog.Leveler
+	prefix string // group prefix for attribute keys
+	attrs  []slog.Attr
+}
+
+func newPrettyHandler(w io.Writer, level slog.Leveler) *prettyHandler {
+	return &prettyHandler{mu: &sync.Mutex{}, w: w, level: level}
+}
+
+func (h *prettyHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *prettyHandler) Handle(_ context.Context, r slog.Record) error {
+	var buf bytes.Buffer
+	buf.WriteString(r.Time.Format("15:04:05"))
+	buf.WriteString(" " + levelMarker(r.Level) + " ")
+	buf.WriteString(r.Message)
+	for _, attr := range h.attrs {
+		writePrettyAttr(&buf, "", attr)
+	}
+	r.Attrs(func(attr slog.Attr) bool {
+		writePrettyAttr(&buf, h.prefix, attr)
+		return true
+	})
+	buf.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.w.Write(buf.Bytes())
+	return err
+}
+
+func (h *prettyHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append([]slog.Attr{}, h.attrs...)
+	for _, attr := range attrs {
+		attr.Key = h.prefix + attr.Key
+		clone.attrs = append(clone.attrs, attr)
+	}
+	return &clone
+}
+
+func (h *prettyHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	clone := *h
+	clone.prefix = h.prefix + name + "."
+	return &clone
+}
+
+func levelMarker(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return "❌"
+	case level >= slog.LevelWarn:
+		return "⚠️ "
+	case level >= slog.LevelInfo:
+		return "•"
+	default:
+		return "·"
+	}
+}
+
+func writePrettyAttr(buf *bytes.Buffer, prefix string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+	if attr.Value.Kind() == slog.KindGroup {
+		for _, member := range attr.Value.Group() {
+			writePrettyAttr(buf, prefix+attr.Key+".", member)
+		}
+		return
+	}
+	value := attr.Value.String()
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+		value = strconv.Quote(value)
+	}
+	buf.WriteString(" " + prefix + attr.Key + "=" + value)
+}
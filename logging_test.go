@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// captureLogs installs a logger writing to a buffer for the duration of the test.
+func captureLogs(t *testing.T, format, level string) *bytes.Buffer {
+	t.Helper()
+	t.Setenv("LOG_FORMAT", format)
+	t.Setenv("LOG_LEVEL", level)
+	previous := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	var buf bytes.Buffer
+	if err := setupLogging(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestJSONLogsCarryQueryID(t *testing.T) {
+	buf := captureLogs(t, "json", "info")
+	ctx := withQueryID(context.Background(), "q-123")
+	slog.InfoContext(ctx, "Processing query", "query", "what is go")
+	slog.DebugContext(ctx, "hidden at info level")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 log line, got %d: %s", len(lines), buf)
+	}
+	var record map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record["query_id"] != "q-123" || record["query"] != "what is go" || record["level"] != "INFO" {
+		t.Fatalf("unexpected record %v", record)
+	}
+}
+
+func TestPrettyLogs(t *testing.T) {
+	buf := captureLogs(t, "", "debug")
+	logger := slog.Default().With("store", "memory").WithGroup("search")
+	logger.WarnContext(withQueryID(context.Background(), "q-1"), "No documents found", "query", "go routines")
+
+	line := buf.String()
+	for _, want := range []string{"⚠️", "No documents found", "store=memory", `search.query="go routines"`, "query_id=q-1"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("log line %q is missing %q", line, want)
+		}
+	}
+}
+
+func TestSetupLoggingRejectsUnknownSettings(t *testing.T) {
+	previous := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	t.Setenv("LOG_FORMAT", "xml")
+	if err := setupLogging(&bytes.Buffer{}); err == nil {
+		t.Fatal("expected an error for LOG_FORMAT=xml")
+	}
+	t.Setenv("LOG_FORMAT", "json")
+	t.Setenv("LOG_LEVEL", "loud")
+	if err := setupLogging(&bytes.Buffer{}); err == nil {
+		t.Fatal("expected an error for LOG_LEVEL=loud")
+	}
+}
+
+func TestServerAssignsRequestIDs(t *testing.T) {
+	buf := captureLogs(t, "json", "debug")
+	server, _ := newTestServer()
+
+	req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"question":"Who made Go?"}`))
+	req.Header.Set("X-Request-ID", "client-id")
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	if got := rec.Header().Get("X-Request-ID"); got != "client-id" {
+		t.Fatalf("expected the client's request ID to be echoed, got %q", got)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if !strings.Contains(line, `"query_id":"client-id"`) {
+			t.Errorf("log line without the query ID: %s", line)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"question":"Who made Go?"}`)))
+	if rec.Header().Get("X-Request-ID") == "" {
+		t.Fatal("expected a generated request ID")
+	}
+}
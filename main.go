@@ -2,232 +2,694 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/milvus-io/milvus-sdk-go/v2/client"
 	"github.com/milvus-io/milvus-sdk-go/v2/entity"
 	"github.com/sashabaranov/go-openai"
 )
 
-// OpenAIClientImpl implements the OpenAIClient interface
+// OpenAIClientImpl implements the LLMClient interface using the OpenAI API
 type OpenAIClientImpl struct {
 	client *openai.Client
 }
 
-func (o *OpenAIClientImpl) ChatCompletion(model string, messages []Message) (string, error) {
-	var openaiMessages []openai.ChatCompletionMessage
-	for _, msg := range messages {
-		openaiMessages = append(openaiMessages, openai.ChatCompletionMessage{
-			Role:    msg.Role,
-			Content: msg.Content,
-		})
-	}
+// Ping lists the available models, which fails unless the API key is valid.
+func (o *OpenAIClientImpl) Ping(ctx context.Context) error {
+	_, err := o.client.ListModels(ctx)
+	return err
+}
 
-	resp, err := o.client.CreateChatCompletion(
-		context.Background(),
-		openai.ChatCompletionRequest{
-			Model:    model,
-			Messages: openaiMessages,
-		},
-	)
+func (o *OpenAIClientImpl) ChatCompletion(ctx context.Context, model string, messages []Message) (string, error) {
+	return o.complete(ctx, openai.ChatCompletionRequest{Model: model}, messages)
+}
+
+// ChatCompletionJSON uses OpenAI's JSON mode, which guarantees a syntactically
+// valid JSON object; conformance to the schema is checked by the caller.
+func (o *OpenAIClientImpl) ChatCompletionJSON(ctx context.Context, model string, messages []Message, schema map[string]any) (string, error) {
+	return o.complete(ctx, openai.ChatCompletionRequest{
+		Model:          model,
+		ResponseFormat: &openai.ChatCompletionResponseFormat{Type: openai.ChatCompletionResponseFormatTypeJSONObject},
+	}, messages)
+}
+
+// ChatCompletionStream streams the completion, passing each content delta to
+// onDelta as it arrives.
+func (o *OpenAIClientImpl) ChatCompletionStream(ctx context.Context, model string, messages []Message, onDelta func(string) error) (string, error) {
+	req := openai.ChatCompletionRequest{Model: model, StreamOptions: &openai.StreamOptions{IncludeUsage: true}}
+	setOpenAISampling(ctx, &req)
+	req.Messages = openAIMessages(messages)
+	stream, err := o.client.CreateChatCompletionStream(ctx, req)
 	if err != nil {
 		return "", err
 	}
+	defer stream.Close()
+
+	var text strings.Builder
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return text.String(), err
+		}
+		if resp.Usage != nil {
+			recordTokenUsage(ctx, resp.Usage.PromptTokens, resp.Usage.CompletionTokens)
+		}
+		if len(resp.Choices) == 0 || resp.Choices[0].Delta.Content == "" {
+			continue
+		}
+		delta := resp.Choices[0].Delta.Content
+		text.WriteString(delta)
+		if err := onDelta(delta); err != nil {
+			return text.String(), err
+		}
+	}
+	return text.String(), nil
+}
 
+// setOpenAISampling fixes the temperature and seed of req in deterministic
+// mode (see WithDeterministic).
+func setOpenAISampling(ctx context.Context, req *openai.ChatCompletionRequest) {
+	if seed, ok := deterministicSeed(ctx); ok {
+		req.Temperature = openAIZeroTemperature
+		req.Seed = &seed
+	}
+}
+
+// DescribeImage sends the image as a data URL ahead of prompt, for vision
+// models such as gpt-4o.
+func (o *OpenAIClientImpl) DescribeImage(ctx context.Context, model string, image Image, prompt string) (string, error) {
+	dataURL := "data:" + image.MIMEType + ";base64," + base64.StdEncoding.EncodeToString(image.Data)
+	resp, err := o.client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model: model,
+		Messages: []openai.ChatCompletionMessage{{
+			Role: "user",
+			MultiContent: []openai.ChatMessagePart{
+				{Type: openai.ChatMessagePartTypeImageURL, ImageURL: &openai.ChatMessageImageURL{URL: dataURL}},
+				{Type: openai.ChatMessagePartTypeText, Text: prompt},
+			},
+		}},
+	})
+	if err != nil {
+		return "", err
+	}
+	recordTokenUsage(ctx, resp.Usage.PromptTokens, resp.Usage.CompletionTokens)
 	if len(resp.Choices) == 0 {
 		return "", fmt.Errorf("no response from OpenAI")
 	}
-
 	return resp.Choices[0].Message.Content, nil
 }
 
-// MilvusClientImpl implements the MilvusClient interface
-type MilvusClientImpl struct {
-	client         client.Client
-	collectionName string
-}
-
-func (m *MilvusClientImpl) InsertDocuments(texts, sources []string) bool {
-	ctx := context.Background()
-
-	// Check if collection exists, create if not
-	hasCollection, err := m.client.HasCollection(ctx, m.collectionName)
-	if err != nil {
-		log.Printf("Error checking collection: %v", err)
-		return false
-	}
-
-	if !hasCollection {
-		// Create collection schema
-		schema := &entity.Schema{
-			CollectionName: m.collectionName,
-			Description:    "RAG documents collection",
-			Fields: []*entity.Field{
-				{
-					Name:       "id",
-					DataType:   entity.FieldTypeInt64,
-					PrimaryKey: true,
-					AutoID:     true,
-				},
-				{
-					Name:     "text",
-					DataType: entity.FieldTypeVarChar,
-					TypeParams: map[string]string{
-						"max_length": "65535",
-					},
-				},
-				{
-					Name:     "source",
-					DataType: entity.FieldTypeVarChar,
-					TypeParams: map[string]string{
-						"max_length": "255",
-					},
-				},
-				{
-					Name:     "embedding",
-					DataType: entity.FieldTypeFloatVector,
-					TypeParams: map[string]string{
-						"dim": "1536", // OpenAI ada-002 embedding dimension
-					},
-				},
-			},
+func (o *OpenAIClientImpl) complete(ctx context.Context, req openai.ChatCompletionRequest, messages []Message) (string, error) {
+	reply, err := o.create(ctx, req, messages)
+	return reply.Content, err
+}
+
+// ChatCompletionTools offers tools as OpenAI functions.
+func (o *OpenAIClientImpl) ChatCompletionTools(ctx context.Context, model string, messages []Message, tools []Tool) (Message, error) {
+	req := openai.ChatCompletionRequest{Model: model}
+	for _, tool := range tools {
+		req.Tools = append(req.Tools, openai.Tool{Type: openai.ToolTypeFunction, Function: &openai.FunctionDefinition{
+			Name:        tool.Name,
+			Description: tool.Description,
+			Parameters:  toolParameters(tool),
+		}})
+	}
+	reply, err := o.create(ctx, req, messages)
+	if err != nil {
+		return Message{}, err
+	}
+	message := Message{Role: "assistant", Content: reply.Content}
+	for _, call := range reply.ToolCalls {
+		message.ToolCalls = append(message.ToolCalls, ToolCall{ID: call.ID, Name: call.Function.Name, Arguments: json.RawMessage(call.Function.Arguments)})
+	}
+	return message, nil
+}
+
+// create sends a chat completion request and returns the reply message.
+func (o *OpenAIClientImpl) create(ctx context.Context, req openai.ChatCompletionRequest, messages []Message) (openai.ChatCompletionMessage, error) {
+	setOpenAISampling(ctx, &req)
+	req.Messages = openAIMessages(messages)
+
+	resp, err := o.client.CreateChatCompletion(ctx, req)
+	if err != nil {
+		return openai.ChatCompletionMessage{}, err
+	}
+
+	recordTokenUsage(ctx, resp.Usage.PromptTokens, resp.Usage.CompletionTokens)
+	if len(resp.Choices) == 0 {
+		return openai.ChatCompletionMessage{}, fmt.Errorf("no response from OpenAI")
+	}
+
+	return resp.Choices[0].Message, nil
+}
+
+// openAIMessages converts messages, with their tool calls and results, to
+// the OpenAI format.
+func openAIMessages(messages []Message) []openai.ChatCompletionMessage {
+	converted := make([]openai.ChatCompletionMessage, len(messages))
+	for i, msg := range messages {
+		converted[i] = openai.ChatCompletionMessage{Role: msg.Role, Content: msg.Content, ToolCallID: msg.ToolCallID}
+		for _, call := range msg.ToolCalls {
+			converted[i].ToolCalls = append(converted[i].ToolCalls, openai.ToolCall{
+				ID:       call.ID,
+				Type:     openai.ToolTypeFunction,
+				Function: openai.FunctionCall{Name: call.Name, Arguments: string(toolArguments(call.Arguments))},
+			})
 		}
+	}
+	return converted
+}
+
+// app bundles the engine and the clients it was built from, shared by the
+// demo flow and CLI subcommands.
+type app struct {
+	engine         *RAGEngine
+	store          VectorStore
+	embedder       Embedder
+	embeddingModel string
+	chatModel      string
+	experiment     *Experiment // nil without EXPERIMENT_FILE
+	close          func()
+}
+
+// newAppFromEnv wires the LLM, embeddings, and vector store clients from
+// environment variables. It returns errMissingAPIKey when the selected
+// provider has no credentials.
+func newAppFromEnv() (*app, error) {
+	provider := os.Getenv("LLM_PROVIDER")
+	if provider == "" {
+		provider = "openai"
+	}
+	llmClient, chatModel, err := newLLMClient(provider)
+	if err != nil {
+		return nil, err
+	}
+	llmClient = instrumentLLM(llmClient)
 
-		err = m.client.CreateCollection(ctx, schema, entity.DefaultShardNumber)
+	embedder, embeddingModel, err := newEmbedder(provider, "")
+	if err != nil {
+		return nil, err
+	}
+
+	store, closeStore, err := newVectorStore(instrumentEmbedder(embedder, embeddingModel))
+	if err != nil {
+		return nil, err
+	}
+	searchStore, closeStore, err := searchCollectionsFromEnv(store, closeStore, instrumentEmbedder(embedder, embeddingModel))
+	if err != nil {
+		return nil, err
+	}
+
+	// Create RAG engine
+	var opts []EngineOption
+	rerankOpts, err := rerankerFromEnv(llmClient, chatModel)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, rerankOpts...)
+	if raw := os.Getenv("MIN_SIMILARITY"); raw != "" {
+		minSimilarity, err := strconv.ParseFloat(raw, 32)
+		if err != nil || minSimilarity < 0 || minSimilarity > 1 {
+			return nil, fmt.Errorf("invalid MIN_SIMILARITY %q (expected 0.0-1.0)", raw)
+		}
+		opts = append(opts, WithMinSimilarity(float32(minSimilarity)))
+	}
+	injectionPolicy, err := ParseInjectionPolicy(os.Getenv("INJECTION_GUARD"))
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, WithInjectionGuard(injectionPolicy))
+	tableFormat, err := ParseTableFormat(os.Getenv("TABLE_FORMAT"))
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, WithTableFormat(tableFormat))
+	moderator, err := newModerator(os.Getenv("MODERATION"))
+	if err != nil {
+		return nil, err
+	}
+	if moderator != nil {
+		opts = append(opts, WithModerator(moderator))
+	}
+	storeFallbacks, err := ParseStoreFallbacks(os.Getenv("STORE_FALLBACK"))
+	if err != nil {
+		return nil, err
+	}
+	for _, fallback := range storeFallbacks {
+		if fallback != FallbackLLM && historyDB() == "" {
+			return nil, fmt.Errorf("STORE_FALLBACK %s needs the query history (HISTORY_DB is off)", fallback)
+		}
+	}
+	opts = append(opts, WithStoreFallback(storeFallbacks...))
+	timeouts, err := stageTimeoutsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, WithStageTimeouts(timeouts))
+	rewriter, err := queryRewriterFromEnv(chatModel)
+	if err != nil {
+		return nil, err
+	}
+	if rewriter != nil {
+		opts = append(opts, WithQueryRewriter(rewriter))
+	}
+	webSearch, err := webSearchFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if webSearch != nil {
+		opts = append(opts, WithWebSearch(webSearch))
+	}
+	seed, responses, deterministic, err := deterministicFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if deterministic {
+		opts = append(opts, WithDeterministic(seed, responses))
+	}
+	verifyMode, err := ParseVerificationMode(os.Getenv("VERIFY_ANSWERS"))
+	if err != nil {
+		return nil, err
+	}
+	if verifyMode != VerifyOff {
+		model := os.Getenv("VERIFY_MODEL")
+		if model == "" {
+			model = chatModel
+		}
+		opts = append(opts, WithVerification(verifyMode, model))
+	}
+	if model := os.Getenv("SELF_EVAL_MODEL"); model != "" {
+		opts = append(opts, WithSelfEvaluation(model))
+	}
+	topicGuard, err := topicGuardFromEnv(llmClient, chatModel)
+	if err != nil {
+		return nil, err
+	}
+	if topicGuard != nil {
+		opts = append(opts, WithTopicGuard(*topicGuard))
+	}
+	crossLingual, err := crossLingualFromEnv(chatModel)
+	if err != nil {
+		return nil, err
+	}
+	if crossLingual != nil {
+		opts = append(opts, WithCrossLingual(*crossLingual))
+	}
+	corrective, err := correctiveFromEnv(llmClient, chatModel, webSearch != nil)
+	if err != nil {
+		return nil, err
+	}
+	if corrective != nil {
+		opts = append(opts, WithCorrectiveRetrieval(corrective))
+	}
+	router, err := routerFromEnv(llmClient, chatModel, searchStore != store)
+	if err != nil {
+		return nil, err
+	}
+	if router != nil {
+		opts = append(opts, WithQueryRouter(router))
+	}
+	ranking, err := rankingFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if ranking != nil {
+		opts = append(opts, WithRankingPolicy(*ranking))
+	}
+	compressor, err := compressorFromEnv(llmClient, chatModel)
+	if err != nil {
+		return nil, err
+	}
+	if compressor != nil {
+		opts = append(opts, WithContextCompression(compressor))
+	}
+	if os.Getenv("NO_CONTEXT_FALLBACK") == "true" {
+		opts = append(opts, WithNoContextFallback())
+	}
+	if raw := os.Getenv("CONTEXT_TOKEN_BUDGET"); raw != "" {
+		budget, err := strconv.Atoi(raw)
+		if err != nil || budget <= 0 {
+			return nil, fmt.Errorf("invalid CONTEXT_TOKEN_BUDGET %q (expected a positive number of tokens)", raw)
+		}
+		opts = append(opts, WithContextBudget(budget))
+	}
+	if raw := os.Getenv("PARENT_CHUNK_SIZE"); raw != "" {
+		parentSize, err := strconv.Atoi(raw)
+		if err != nil || parentSize <= 0 {
+			return nil, fmt.Errorf("invalid PARENT_CHUNK_SIZE %q (expected a positive number of bytes)", raw)
+		}
+		dir := os.Getenv("PARENT_STORE_DIR")
+		if dir == "" {
+			dir = "parent_documents"
+		}
+		parents, err := NewFileParentStore(dir)
 		if err != nil {
-			log.Printf("Error creating collection: %v", err)
-			return false
+			return nil, fmt.Errorf("opening parent store: %w", err)
 		}
-
-		// Create index
-		idx, err := entity.NewIndexHNSW(entity.L2, 8, 96)
+		opts = append(opts, WithParentDocuments(parents, parentSize))
+	}
+	noiseFilter, err := noiseFilterFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if noiseFilter != nil {
+		opts = append(opts, WithNoiseFilter(*noiseFilter))
+	}
+	if os.Getenv("CHUNK_HEADERS") == "true" {
+		// Newlines in the template are written as \n.
+		template := strings.ReplaceAll(os.Getenv("CHUNK_HEADER_TEMPLATE"), `\n`, "\n")
+		if template != "" {
+			if err := checkHeaderTemplate(template); err != nil {
+				return nil, fmt.Errorf("invalid CHUNK_HEADER_TEMPLATE: %w", err)
+			}
+		}
+		opts = append(opts, WithChunkHeaders(template))
+	}
+	enricher, err := enricherFromEnv(llmClient, chatModel)
+	if err != nil {
+		return nil, err
+	}
+	if enricher != nil {
+		opts = append(opts, WithChunkEnrichment(enricher))
+	}
+	transcriber, err := transcriberFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if transcriber != nil {
+		opts = append(opts, WithTranscriber(transcriber))
+	}
+	if visionModel := os.Getenv("VISION_MODEL"); visionModel != "" {
+		dir := os.Getenv("IMAGE_STORE_DIR")
+		if dir == "" {
+			dir = "images"
+		}
+		images, err := NewFileImageStore(dir)
 		if err != nil {
-			log.Printf("Error creating index: %v", err)
-			return false
+			return nil, fmt.Errorf("opening image store: %w", err)
 		}
+		// Every provider's client can describe images; llmClient forwards
+		// DescribeImage to it.
+		opts = append(opts, WithVision(llmClient.(VisionClient), visionModel, images))
+	}
 
-		err = m.client.CreateIndex(ctx, m.collectionName, "embedding", idx, false)
+	closeAll := closeStore
+	if path := historyDB(); path != "" {
+		history, err := NewSQLiteHistory(path)
 		if err != nil {
-			log.Printf("Error creating index on collection: %v", err)
-			return false
+			closeStore()
+			return nil, err
 		}
-
-		// Load collection
-		err = m.client.LoadCollection(ctx, m.collectionName, false)
+		opts = append(opts, WithHistory(history))
+		closeAll = func() {
+			history.Close()
+			closeStore()
+		}
+	}
+	if path := graphDB(); path != "" {
+		graph, err := NewSQLiteGraph(path)
 		if err != nil {
-			log.Printf("Error loading collection: %v", err)
-			return false
+			closeAll()
+			return nil, err
+		}
+		graphModel := os.Getenv("GRAPH_MODEL")
+		if graphModel == "" {
+			graphModel = chatModel
+		}
+		opts = append(opts, WithGraph(graph, graphModel))
+		closeRest := closeAll
+		closeAll = func() {
+			graph.Close()
+			closeRest()
+		}
+	}
+	experiment, closeExperiment, err := experimentFromEnv(provider, embedder, embeddingModel)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+	if experiment != nil {
+		closeRest := closeAll
+		closeAll = func() {
+			closeExperiment()
+			closeRest()
 		}
 	}
 
-	// For this demo, we'll use dummy embeddings (in a real implementation, you'd generate embeddings using OpenAI's embedding API)
-	embeddings := make([][]float32, len(texts))
-	for i := range texts {
-		// Create dummy embeddings - in real implementation, use OpenAI embeddings API
-		embedding := make([]float32, 1536)
-		for j := range embedding {
-			embedding[j] = float32(i+j) * 0.01 // Simple dummy values
+	engine := NewRAGEngine(llmClient, searchStore, opts...)
+	if tenant := os.Getenv("TENANT"); tenant != "" {
+		engine, err = engine.ForTenant(tenant)
+		if err != nil {
+			closeAll()
+			return nil, err
 		}
-		embeddings[i] = embedding
+	}
+	if roles := envRoles(); roles != nil {
+		engine = engine.ForRoles(roles)
 	}
 
-	// Prepare data for insertion
-	log.Printf("📝 Preparing to insert %d documents into collection '%s'", len(texts), m.collectionName)
-	textColumn := entity.NewColumnVarChar("text", texts)
-	sourceColumn := entity.NewColumnVarChar("source", sources)
-	embeddingColumn := entity.NewColumnFloatVector("embedding", 1536, embeddings)
+	return &app{
+		engine:         engine,
+		store:          store,
+		embedder:       embedder,
+		embeddingModel: embeddingModel,
+		chatModel:      chatModel,
+		experiment:     experiment,
+		close:          closeAll,
+	}, nil
+}
 
-	_, err = m.client.Insert(ctx, m.collectionName, "", textColumn, sourceColumn, embeddingColumn)
-	if err != nil {
-		log.Printf("❌ Error inserting documents: %v", err)
-		return false
+// newVectorStore connects to the backend selected by VECTOR_STORE ("milvus",
+// the default, "pgvector", "qdrant", or "memory") and returns it with a
+// function that closes it. The schema dimension is the embedder's.
+func newVectorStore(embedder Embedder) (VectorStore, func(), error) {
+	return openVectorStore(embedder, collectionNameFromEnv())
+}
+
+// collectionNameFromEnv returns COLLECTION_NAME, by default rag_documents.
+func collectionNameFromEnv() string {
+	if name := os.Getenv("COLLECTION_NAME"); name != "" {
+		return name
 	}
-	
-	log.Printf("✅ Successfully inserted %d documents", len(texts))
+	return "rag_documents"
+}
 
-	// Flush to ensure data is persisted
-	log.Printf("💾 Flushing collection to ensure data persistence...")
-	err = m.client.Flush(ctx, m.collectionName, false)
+// searchCollectionsFromEnv returns a collection store over store, the
+// collection of COLLECTION_NAME, and the collections in SEARCH_COLLECTIONS,
+// e.g. "tickets:0.5,wiki:0.8", opened with the same backend and embedder,
+// along with a function that closes them all. Without SEARCH_COLLECTIONS it
+// returns store and closeStore.
+func searchCollectionsFromEnv(store VectorStore, closeStore func(), embedder Embedder) (VectorStore, func(), error) {
+	weights, err := parseCollectionWeights(os.Getenv("SEARCH_COLLECTIONS"))
+	if err != nil {
+		closeStore()
+		return nil, nil, fmt.Errorf("invalid SEARCH_COLLECTIONS: %w", err)
+	}
+	if weights == nil {
+		return store, closeStore, nil
+	}
+	primary := collectionNameFromEnv()
+	collections := []Collection{{Name: primary, Store: store, Weight: weights[primary]}}
+	closers := []func(){closeStore}
+	closeAll := func() {
+		for _, fn := range closers {
+			fn()
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(weights)) {
+		if name == primary {
+			continue
+		}
+		collection, closeCollection, err := openVectorStore(embedder, name)
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("opening collection %s: %w", name, err)
+		}
+		closers = append(closers, closeCollection)
+		collections = append(collections, Collection{Name: name, Store: collection, Weight: weights[name]})
+	}
+	multi, err := NewCollectionStore(collections...)
 	if err != nil {
-		log.Printf("❌ Error flushing collection: %v", err)
-		return false
+		closeAll()
+		return nil, nil, err
 	}
-	
-	log.Printf("✅ Collection flushed successfully")
-	return true
+	slog.Info("Searching several collections", "collections", len(collections))
+	return multi, closeAll, nil
 }
 
-func (m *MilvusClientImpl) SearchSimilar(query string, limit int) []Document {
-	ctx := context.Background()
-
-	// For this demo, we'll use a dummy query embedding
-	// In a real implementation, you'd generate embeddings using OpenAI's embedding API
-	queryEmbedding := make([]float32, 1536)
-	for i := range queryEmbedding {
-		queryEmbedding[i] = float32(i) * 0.01 // Simple dummy values
+// experimentFromEnv loads the experiment of EXPERIMENT_FILE, if set, and
+// opens the collections its pipelines search with the configured backend and
+// the pipeline's embedding model, by default embeddingModel. It returns the
+// experiment with a function that closes those collections.
+func experimentFromEnv(provider string, embedder Embedder, embeddingModel string) (*Experiment, func(), error) {
+	path := os.Getenv("EXPERIMENT_FILE")
+	if path == "" {
+		return nil, nil, nil
 	}
-
-	searchParams, _ := entity.NewIndexHNSWSearchParam(16)
-	results, err := m.client.Search(
-		ctx,
-		m.collectionName,
-		[]string{},
-		"",
-		[]string{"text", "source"},
-		[]entity.Vector{entity.FloatVector(queryEmbedding)},
-		"embedding",
-		entity.L2,
-		limit,
-		searchParams,
-	)
-
+	config, err := LoadExperimentConfig(path)
 	if err != nil {
-		log.Printf("Error searching documents: %v", err)
-		return []Document{}
-	}
-
-	var documents []Document
-	if len(results) > 0 {
-		log.Printf("🔍 Milvus search returned %d results", results[0].ResultCount)
-		for i := 0; i < results[0].ResultCount; i++ {
-			text, _ := results[0].Fields.GetColumn("text").Get(i)
-			source, _ := results[0].Fields.GetColumn("source").Get(i)
-			
-			// Get similarity score (Milvus returns distance, convert to similarity)
-			// For L2 distance, smaller values mean more similar
-			distance := results[0].Scores[i]
-			// Convert L2 distance to similarity score (0-1 range)
-			// Using exponential decay: similarity = e^(-distance)
-			similarity := float32(1.0 / (1.0 + distance))
-			
-			log.Printf("   🎯 Document %d: L2 distance=%.4f, similarity=%.4f (%.1f%%)", 
-				i+1, distance, similarity, similarity*100)
-			
-			documents = append(documents, Document{
-				Text:       text.(string),
-				Source:     source.(string),
-				Similarity: similarity,
-			})
+		return nil, nil, err
+	}
+	experiment := &Experiment{Name: config.Name}
+	var closers []func()
+	closeAll := func() {
+		for _, fn := range closers {
+			fn()
 		}
-	} else {
-		log.Printf("⚠️  No documents found matching the query")
 	}
-
-	return documents
+	for _, p := range config.Pipelines {
+		arm := ExperimentArm{Pipeline: p}
+		sameModel := p.EmbeddingModel == "" || p.EmbeddingModel == embeddingModel
+		if p.Collection != "" && (p.Collection != collectionNameFromEnv() || !sameModel) {
+			pipelineEmbedder, model := embedder, embeddingModel
+			if !sameModel {
+				if pipelineEmbedder, model, err = newEmbedder(provider, p.EmbeddingModel); err != nil {
+					closeAll()
+					return nil, nil, fmt.Errorf("pipeline %s: %w", p.Name, err)
+				}
+			}
+			store, closeStore, err := openVectorStore(instrumentEmbedder(pipelineEmbedder, model), p.Collection)
+			if err != nil {
+				closeAll()
+				return nil, nil, fmt.Errorf("pipeline %s: opening collection %s: %w", p.Name, p.Collection, err)
+			}
+			closers = append(closers, closeStore)
+			arm.Store = store
+		}
+		experiment.Arms = append(experiment.Arms, arm)
+	}
+	slog.Info("Running an experiment", "experiment", experiment.Name, "pipelines", experiment.pipelines())
+	return experiment, closeAll, nil
 }
 
-func main() {
-	// Check for required environment variables
-	openaiAPIKey := os.Getenv("OPENAI_API_KEY")
-	if openaiAPIKey == "" {
-		log.Println("Warning: OPENAI_API_KEY not set. Using demo mode.")
-		runDemoMode()
-		return
+// openVectorStore is newVectorStore for the named collection, or table.
+func openVectorStore(embedder Embedder, collection string) (VectorStore, func(), error) {
+	dimension := embedder.Dimension()
+	switch backend := os.Getenv("VECTOR_STORE"); backend {
+	case "", "milvus":
+		store, err := connectMilvusCollection(collection)
+		if err != nil {
+			return nil, nil, err
+		}
+		store.embedder = embedder
+		store.dimension = dimension
+		limits, err := limitsFromEnv("MILVUS_REQUESTS_PER_SECOND", time.Second, "MILVUS_MAX_CONCURRENCY")
+		if err != nil {
+			store.client.Close()
+			return nil, nil, err
+		}
+		store.client = limitMilvus(store.client, limits)
+		if err := store.CheckDimension(context.Background()); err != nil {
+			store.client.Close()
+			return nil, nil, err
+		}
+		if err := store.CheckMetric(context.Background()); err != nil {
+			store.client.Close()
+			return nil, nil, err
+		}
+		if err := store.CheckIndex(context.Background()); err != nil {
+			store.client.Close()
+			return nil, nil, err
+		}
+		store.partitionKey = os.Getenv("MILVUS_PARTITION_KEY") == "true"
+		if err := store.CheckPartitionKey(context.Background()); err != nil {
+			store.client.Close()
+			return nil, nil, err
+		}
+		store.sparse, store.sparseWeight, err = sparseFromEnv()
+		if err != nil {
+			store.client.Close()
+			return nil, nil, err
+		}
+		if err := store.CheckSparse(context.Background()); err != nil {
+			store.client.Close()
+			return nil, nil, err
+		}
+		return store, func() { store.client.Close() }, nil
+	case "pgvector":
+		dsn := os.Getenv("DATABASE_URL")
+		if dsn == "" {
+			return nil, nil, fmt.Errorf("DATABASE_URL must be set when VECTOR_STORE=pgvector")
+		}
+		table := collection
+		indexType := os.Getenv("PGVECTOR_INDEX")
+		if indexType == "" {
+			indexType = "hnsw"
+		}
+		quantization, err := quantizationFromEnv("pgvector", QuantizationFloat16, QuantizationBinary)
+		if err != nil {
+			return nil, nil, err
+		}
+		store, err := NewPgVectorStore(dsn, table, embedder, dimension, indexType)
+		if err != nil {
+			return nil, nil, err
+		}
+		store.quantization = quantization
+		if err := store.CheckDimension(context.Background()); err != nil {
+			store.Close()
+			return nil, nil, err
+		}
+		return store, func() { store.Close() }, nil
+	case "qdrant":
+		host := os.Getenv("QDRANT_HOST")
+		if host == "" {
+			host = "localhost"
+		}
+		port := os.Getenv("QDRANT_PORT")
+		if port == "" {
+			port = "6333"
+		}
+		quantization, err := quantizationFromEnv("Qdrant", QuantizationFloat16, QuantizationInt8, QuantizationBinary)
+		if err != nil {
+			return nil, nil, err
+		}
+		baseURL := fmt.Sprintf("http://%s:%s", host, port)
+		slog.Info("Using Qdrant", "url", baseURL)
+		store := NewQdrantStore(baseURL, os.Getenv("QDRANT_API_KEY"), collection, embedder, dimension)
+		store.quantization = quantization
+		if err := store.CheckDimension(context.Background()); err != nil {
+			return nil, nil, err
+		}
+		return store, func() {}, nil
+	case "memory":
+		if _, err := quantizationFromEnv("memory"); err != nil {
+			return nil, nil, err
+		}
+		slog.Warn("Using the in-memory vector store; documents are lost on exit")
+		return NewMemoryStore(embedder), func() {}, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown VECTOR_STORE %q (expected milvus, pgvector, qdrant, or memory)", backend)
 	}
+}
+
+// connectMilvus opens a pool of MILVUS_POOL_SIZE connections (default 4) to
+// the Milvus server configured by MILVUS_HOST and MILVUS_PORT, for the
+// collection named by COLLECTION_NAME. MILVUS_HEALTH_INTERVAL (default 30s)
+// sets how often the pool checks its connections. The store's metric and
+// index come from MILVUS_METRIC and milvusIndexFromEnv.
+func connectMilvus() (*MilvusClientImpl, error) {
+	return connectMilvusCollection(collectionNameFromEnv())
+}
 
+// connectMilvusCollection is connectMilvus for the named collection.
+func connectMilvusCollection(collectionName string) (*MilvusClientImpl, error) {
 	milvusHost := os.Getenv("MILVUS_HOST")
 	if milvusHost == "" {
 		milvusHost = "localhost"
@@ -238,36 +700,165 @@ func main() {
 		milvusPort = "19530"
 	}
 
-	collectionName := os.Getenv("COLLECTION_NAME")
-	if collectionName == "" {
-		collectionName = "rag_documents"
+	poolSize := 4
+	if raw := os.Getenv("MILVUS_POOL_SIZE"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid MILVUS_POOL_SIZE %q (expected a positive number of connections)", raw)
+		}
+		poolSize = n
+	}
+	healthInterval := 30 * time.Second
+	if raw := os.Getenv("MILVUS_HEALTH_INTERVAL"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid MILVUS_HEALTH_INTERVAL %q (expected a duration such as 30s, or 0 to disable)", raw)
+		}
+		healthInterval = d
+	}
+	metric := entity.MetricType(strings.ToUpper(os.Getenv("MILVUS_METRIC")))
+	switch metric {
+	case "", entity.L2, entity.IP, entity.COSINE:
+	default:
+		return nil, fmt.Errorf("invalid MILVUS_METRIC %q (expected COSINE, IP, or L2)", metric)
+	}
+	index, err := milvusIndexFromEnv()
+	if err != nil {
+		return nil, err
 	}
 
-	// Initialize OpenAI client
-	openaiClient := &OpenAIClientImpl{
-		client: openai.NewClient(openaiAPIKey),
+	address := fmt.Sprintf("%s:%s", milvusHost, milvusPort)
+	dial := func(ctx context.Context) (client.Client, error) {
+		return client.NewGrpcClient(ctx, address)
 	}
+	pool, err := newMilvusPool(context.Background(), dial, poolSize, healthInterval)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Milvus: %w", err)
+	}
+	return &MilvusClientImpl{client: pool, collectionName: collectionName, metric: metric, index: index}, nil
+}
 
-	// Initialize Milvus client
-	milvusClient, err := client.NewGrpcClient(context.Background(), fmt.Sprintf("%s:%s", milvusHost, milvusPort))
+// milvusIndexFromEnv reads the index of new collections from MILVUS_INDEX
+// (HNSW, the default, IVF_FLAT, IVF_SQ8, or DISKANN) and its parameters from
+// MILVUS_HNSW_M, MILVUS_HNSW_EF_CONSTRUCTION, MILVUS_HNSW_EF,
+// MILVUS_IVF_NLIST, MILVUS_IVF_NPROBE, and MILVUS_DISKANN_SEARCH_LIST, which
+// default to DefaultMilvusIndex. VECTOR_QUANTIZATION=int8 selects IVF_SQ8,
+// the index that stores vectors as 8-bit integers; binary is rejected.
+func milvusIndexFromEnv() (MilvusIndexConfig, error) {
+	indexType, err := ParseMilvusIndexType(os.Getenv("MILVUS_INDEX"))
+	if err != nil {
+		return MilvusIndexConfig{}, fmt.Errorf("invalid MILVUS_INDEX: %w", err)
+	}
+	quantization, err := quantizationFromEnv("Milvus", QuantizationInt8, QuantizationBinary)
 	if err != nil {
-		log.Fatalf("Failed to connect to Milvus: %v", err)
+		return MilvusIndexConfig{}, err
+	}
+	if quantization == QuantizationBinary {
+		// Milvus indexes binary vectors (BIN_FLAT, BIN_IVF_FLAT) only in a
+		// field of their own, and would still index and load the float
+		// vectors next to them, so they would save no memory.
+		return MilvusIndexConfig{}, fmt.Errorf("VECTOR_QUANTIZATION=binary is not supported by the Milvus store, whose binary indexes need a separate binary vector field; use int8 (IVF_SQ8) on Milvus, or binary with VECTOR_STORE=pgvector or qdrant")
+	}
+	if quantization == QuantizationInt8 {
+		switch indexType {
+		case "":
+			indexType = entity.IvfSQ8
+		case entity.IvfSQ8:
+		default:
+			return MilvusIndexConfig{}, fmt.Errorf("VECTOR_QUANTIZATION=int8 needs the IVF_SQ8 index on Milvus, not MILVUS_INDEX=%s", indexType)
+		}
+	}
+	config := MilvusIndexConfig{Type: indexType}
+	for _, param := range []struct {
+		name  string
+		value *int
+	}{
+		{"MILVUS_HNSW_M", &config.M},
+		{"MILVUS_HNSW_EF_CONSTRUCTION", &config.EfConstruction},
+		{"MILVUS_HNSW_EF", &config.Ef},
+		{"MILVUS_IVF_NLIST", &config.NList},
+		{"MILVUS_IVF_NPROBE", &config.NProbe},
+		{"MILVUS_DISKANN_SEARCH_LIST", &config.SearchList},
+	} {
+		raw := os.Getenv(param.name)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			return MilvusIndexConfig{}, fmt.Errorf("invalid %s %q (expected a positive number)", param.name, raw)
+		}
+		*param.value = n
 	}
-	defer milvusClient.Close()
+	if _, err := config.index(entity.COSINE); err != nil {
+		return MilvusIndexConfig{}, fmt.Errorf("invalid Milvus index parameters: %w", err)
+	}
+	if _, err := config.searchParam(1); err != nil {
+		return MilvusIndexConfig{}, fmt.Errorf("invalid Milvus search parameters: %w", err)
+	}
+	return config, nil
+}
 
-	milvusClientImpl := &MilvusClientImpl{
-		client:         milvusClient,
-		collectionName: collectionName,
+// sparseFromEnv reads the sparse embedder of the Milvus store from
+// MILVUS_SPARSE (bm25 or splade, whose server is at SPLADE_URL, with
+// SPLADE_API_KEY), and the weight of its scores in hybrid searches from
+// MILVUS_SPARSE_WEIGHT, which defaults to 0.3. Without MILVUS_SPARSE the
+// store keeps dense embeddings only.
+func sparseFromEnv() (SparseEmbedder, float64, error) {
+	mode := os.Getenv("MILVUS_SPARSE")
+	if mode == "" {
+		return nil, 0, nil
+	}
+	embedder, err := newSparseEmbedder(mode, os.Getenv("SPLADE_URL"), os.Getenv("SPLADE_API_KEY"))
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid MILVUS_SPARSE: %w", err)
+	}
+	weight := 0.3
+	if raw := os.Getenv("MILVUS_SPARSE_WEIGHT"); raw != "" {
+		w, err := strconv.ParseFloat(raw, 64)
+		if err != nil || w < 0 || w > 1 {
+			return nil, 0, fmt.Errorf("invalid MILVUS_SPARSE_WEIGHT %q (expected a number from 0 to 1)", raw)
+		}
+		weight = w
 	}
+	return embedder, weight, nil
+}
 
-	// Create RAG engine
-	engine := NewRAGEngine(openaiClient, milvusClientImpl)
+// quantizationFromEnv reads VECTOR_QUANTIZATION and rejects the encodings
+// that backend cannot store.
+func quantizationFromEnv(backend string, supported ...Quantization) (Quantization, error) {
+	quantization, err := ParseQuantization(os.Getenv("VECTOR_QUANTIZATION"))
+	if err != nil {
+		return "", fmt.Errorf("invalid VECTOR_QUANTIZATION: %w", err)
+	}
+	if quantization != QuantizationNone && !slices.Contains(supported, quantization) {
+		return "", fmt.Errorf("VECTOR_QUANTIZATION=%s is not supported by the %s store", quantization, backend)
+	}
+	return quantization, nil
+}
+
+// runDemo implements `rag demo`: it ingests a few sample documents and
+// answers a sample question against the configured backends, falling back to
+// mock clients when the selected provider has no API key.
+func runDemo(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("demo", flag.ExitOnError)
+	fs.Parse(args)
+
+	a, err := newAppFromEnv()
+	if errors.Is(err, errMissingAPIKey) {
+		slog.Warn("Using demo mode", "reason", err)
+		runDemoMode(ctx)
+		return
+	}
+	if err != nil {
+		fatal("Configuration error", "error", err)
+	}
+	defer a.close()
+	engine := a.engine
 
 	// Demo: Add some documents
-	log.Println("🚀 Starting RAG Engine Demo")
-	log.Println("=" + strings.Repeat("=", 50))
-	
-	log.Println("📚 Phase 1: Document Ingestion")
+	slog.Info("Starting RAG engine demo")
+	slog.Info("Phase 1: document ingestion")
 	texts := []string{
 		"Go is a programming language developed by Google. It's known for its simplicity and efficiency.",
 		"Milvus is an open-source vector database that supports similarity search and AI applications.",
@@ -281,77 +872,820 @@ func main() {
 		"Docker Documentation",
 	}
 
-	log.Printf("📄 Preparing to ingest %d documents:", len(texts))
 	for i, text := range texts {
-		log.Printf("   %d. %s (Source: %s)", i+1, truncateText(text, 60), sources[i])
+		slog.Info("Sample document", "index", i+1, "source", sources[i], "preview", truncateText(text, 60))
 	}
 
-	success := engine.AddDocuments(texts, sources)
+	success := engine.AddDocuments(ctx, texts, sources)
 	if !success {
-		log.Fatalf("❌ Failed to add documents to the knowledge base")
+		fatal("Failed to add documents to the knowledge base")
 	}
-	log.Println("✅ All documents successfully added to knowledge base!")
+	slog.Info("All documents added to the knowledge base", "documents", len(texts))
 
 	// Demo: Search and generate response
-	log.Println("\n🔍 Phase 2: Query Processing & Retrieval")
-	log.Println("=" + strings.Repeat("=", 50))
-	
+	slog.Info("Phase 2: query processing and retrieval")
+
 	query := "What is Go programming language?"
-	log.Printf("❓ User Query: %s", query)
-	
-	log.Println("\n🎯 Performing vector similarity search...")
-	context := milvusClientImpl.SearchSimilar(query, 3)
-	log.Printf("📊 Retrieved %d relevant documents from knowledge base", len(context))
+	ctx = withQueryID(ctx, newQueryID())
+	docs := engine.Retrieve(ctx, query, 3)
+	slog.InfoContext(ctx, "Retrieved documents from the knowledge base", "documents", len(docs))
 
-	log.Println("\n🤖 Phase 3: Response Generation")
-	log.Println("=" + strings.Repeat("=", 50))
-	
-	response, err := engine.GenerateResponse(query, context, "gpt-3.5-turbo")
+	slog.InfoContext(ctx, "Phase 3: response generation")
+
+	response, err := engine.GenerateResponse(ctx, query, docs, a.chatModel)
 	if err != nil {
-		log.Fatalf("❌ Failed to generate response: %v", err)
+		fatal("Failed to generate response", "error", err)
 	}
 
-	log.Println("\n📋 Final Results:")
-	log.Println("=" + strings.Repeat("=", 50))
 	fmt.Printf("❓ Query: %s\n", query)
-	fmt.Printf("✅ Response: %s\n", response)
-	log.Printf("📈 Processing completed successfully!")
+	fmt.Printf("✅ Response: %s\n", response.Text)
+	printCitations(response)
+	logCacheStats(a.embedder)
+	slog.Info("Demo completed")
+}
+
+// errMissingAPIKey is returned when the selected provider has no credentials.
+var errMissingAPIKey = errors.New("API key not set")
+
+// newLLMClient builds the chat client for the given provider along with its
+// chat model, which can be overridden with CHAT_MODEL.
+func newLLMClient(provider string) (LLMClient, string, error) {
+	var llmClient LLMClient
+	var model string
+	switch provider {
+	case "openai":
+		apiKey := os.Getenv("OPENAI_API_KEY")
+		if apiKey == "" {
+			return nil, "", fmt.Errorf("OPENAI_API_KEY: %w", errMissingAPIKey)
+		}
+		client, err := newOpenAIClient("OpenAI", openai.DefaultConfig(apiKey))
+		if err != nil {
+			return nil, "", err
+		}
+		llmClient = &OpenAIClientImpl{client: client}
+		model = "gpt-3.5-turbo"
+	case "azure":
+		client, err := newAzureOpenAIClient(os.Getenv("AZURE_OPENAI_CHAT_DEPLOYMENT"))
+		if err != nil {
+			return nil, "", err
+		}
+		llmClient = &OpenAIClientImpl{client: client}
+		model = "gpt-4o-mini"
+	case "anthropic":
+		apiKey := os.Getenv("ANTHROPIC_API_KEY")
+		if apiKey == "" {
+			return nil, "", fmt.Errorf("ANTHROPIC_API_KEY: %w", errMissingAPIKey)
+		}
+		llmClient = NewAnthropicClient(apiKey)
+		model = "claude-3-5-haiku-latest"
+	case "gemini":
+		apiKey := os.Getenv("GEMINI_API_KEY")
+		if apiKey == "" {
+			return nil, "", fmt.Errorf("GEMINI_API_KEY: %w", errMissingAPIKey)
+		}
+		llmClient, _ = NewGeminiClient(apiKey, "", 0)
+		model = "gemini-2.0-flash"
+	case "ollama":
+		llmClient = NewOllamaClient(ollamaHost(), "")
+		model = "llama3.2"
+	default:
+		return nil, "", fmt.Errorf("unknown LLM_PROVIDER %q", provider)
+	}
+
+	if override := os.Getenv("CHAT_MODEL"); override != "" {
+		model = override
+	}
+	return llmClient, model, nil
+}
+
+// newModerator returns the moderator selected by MODERATION: "openai" uses
+// the OpenAI moderation endpoint with OPENAI_API_KEY and MODERATION_MODEL
+// (default omni-moderation-latest); empty or "off" disables moderation.
+func newModerator(kind string) (Moderator, error) {
+	switch kind {
+	case "", "off":
+		return nil, nil
+	case "openai":
+		apiKey := os.Getenv("OPENAI_API_KEY")
+		if apiKey == "" {
+			return nil, fmt.Errorf("OPENAI_API_KEY (for MODERATION=openai): %w", errMissingAPIKey)
+		}
+		client, err := newOpenAIClient("OpenAI", openai.DefaultConfig(apiKey))
+		if err != nil {
+			return nil, err
+		}
+		model := os.Getenv("MODERATION_MODEL")
+		if model == "" {
+			model = openai.ModerationOmniLatest
+		}
+		return NewOpenAIModerator(client, model), nil
+	default:
+		return nil, fmt.Errorf("unknown MODERATION %q (expected openai or off)", kind)
+	}
+}
+
+// newAzureOpenAIClient creates a client for the Azure OpenAI resource at
+// AZURE_OPENAI_ENDPOINT that sends requests to deployment (see
+// azureOpenAIConfig). AZURE_OPENAI_API_VERSION overrides the API version.
+func newAzureOpenAIClient(deployment string) (*openai.Client, error) {
+	apiKey := os.Getenv("AZURE_OPENAI_API_KEY")
+	if apiKey == "" {
+		return nil, fmt.Errorf("AZURE_OPENAI_API_KEY: %w", errMissingAPIKey)
+	}
+	endpoint := os.Getenv("AZURE_OPENAI_ENDPOINT")
+	if endpoint == "" {
+		return nil, fmt.Errorf("AZURE_OPENAI_ENDPOINT must be set when using Azure OpenAI")
+	}
+	config := azureOpenAIConfig(apiKey, endpoint, os.Getenv("AZURE_OPENAI_API_VERSION"), deployment)
+	return newOpenAIClient("Azure OpenAI", config)
+}
+
+// newOpenAIClient creates an OpenAI or Azure OpenAI client from config whose
+// requests are retried with exponential backoff on 429 and 5xx responses.
+// OPENAI_MAX_RETRIES (default 4) and OPENAI_RETRY_TIMEOUT (default 2m) bound
+// the retries, and OPENAI_REQUESTS_PER_MINUTE and OPENAI_MAX_CONCURRENCY limit
+// every attempt.
+func newOpenAIClient(provider string, config openai.ClientConfig) (*openai.Client, error) {
+	limits, err := limitsFromEnv("OPENAI_REQUESTS_PER_MINUTE", time.Minute, "OPENAI_MAX_CONCURRENCY")
+	if err != nil {
+		return nil, err
+	}
+	policy := DefaultRetryPolicy
+	if raw := os.Getenv("OPENAI_MAX_RETRIES"); raw != "" {
+		retries, err := strconv.Atoi(raw)
+		if err != nil || retries < 0 {
+			return nil, fmt.Errorf("invalid OPENAI_MAX_RETRIES %q (expected a number of retries)", raw)
+		}
+		policy.MaxAttempts = retries + 1
+	}
+	if raw := os.Getenv("OPENAI_RETRY_TIMEOUT"); raw != "" {
+		timeout, err := time.ParseDuration(raw)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid OPENAI_RETRY_TIMEOUT %q (expected a duration such as 90s)", raw)
+		}
+		policy.MaxElapsed = timeout
+	}
+	config.HTTPClient = newRetryingHTTPClient(provider, policy, limits.transport(http.DefaultTransport))
+	return openai.NewClientWithConfig(config), nil
+}
+
+// limitsFromEnv reads a request rate per unit of time from rateVar and a
+// concurrency cap from inFlightVar. Both are optional.
+func limitsFromEnv(rateVar string, unit time.Duration, inFlightVar string) (*Limits, error) {
+	var perSecond float64
+	if raw := os.Getenv(rateVar); raw != "" {
+		parsed, err := strconv.ParseFloat(raw, 64)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid %s %q (expected a positive number)", rateVar, raw)
+		}
+		perSecond = parsed / unit.Seconds()
+	}
+	var maxInFlight int
+	if raw := os.Getenv(inFlightVar); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid %s %q (expected a positive number of requests)", inFlightVar, raw)
+		}
+		maxInFlight = parsed
+	}
+	return NewLimits(perSecond, maxInFlight), nil
+}
+
+// newEmbedder builds the embedding backend selected by EMBEDDING_PROVIDER
+// ("openai", "azure", "gemini", "cohere", "voyage", "ollama", or "onnx"),
+// which defaults to the LLM provider, and returns it with the model name.
+// Anthropic has no embeddings API, so it borrows OpenAI embeddings when
+// OPENAI_API_KEY is set. model, by default EMBEDDING_MODEL, picks the
+// model and EMBEDDING_DIM its vector dimension, for models that are not
+// known or that can return shortened vectors; the vector store schema
+// follows the embedder's Dimension.
+func newEmbedder(provider, model string) (Embedder, string, error) {
+	embeddingProvider := os.Getenv("EMBEDDING_PROVIDER")
+	explicit := embeddingProvider != ""
+	if !explicit || embeddingProvider == "anthropic" {
+		embeddingProvider = provider
+	}
+	if embeddingProvider == "anthropic" {
+		embeddingProvider = "openai"
+	}
+	if model == "" {
+		model = os.Getenv("EMBEDDING_MODEL")
+	}
+	dimension := 0
+	if raw := os.Getenv("EMBEDDING_DIM"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			return nil, "", fmt.Errorf("invalid EMBEDDING_DIM %q", raw)
+		}
+		dimension = parsed
+	}
+
+	var embedder Embedder
+	var err error
+	switch embeddingProvider {
+	case "openai":
+		apiKey := os.Getenv("OPENAI_API_KEY")
+		if apiKey == "" {
+			if explicit {
+				return nil, "", fmt.Errorf("OPENAI_API_KEY: %w", errMissingAPIKey)
+			}
+			slog.Warn("No embeddings provider configured, using local hashing embeddings")
+			return NewHashingEmbedder(1536), "hashing", nil
+		}
+		if model == "" {
+			model = "text-embedding-ada-002"
+		}
+		var client *openai.Client
+		if client, err = newOpenAIClient("OpenAI", openai.DefaultConfig(apiKey)); err != nil {
+			return nil, "", err
+		}
+		embedder, err = NewOpenAIEmbedder(client, model, dimension)
+	case "azure":
+		if model == "" {
+			model = "text-embedding-ada-002"
+		}
+		var client *openai.Client
+		if client, err = newAzureOpenAIClient(os.Getenv("AZURE_OPENAI_EMBEDDING_DEPLOYMENT")); err != nil {
+			return nil, "", err
+		}
+		embedder, err = NewOpenAIEmbedder(client, model, dimension)
+	case "cohere":
+		apiKey := os.Getenv("COHERE_API_KEY")
+		if apiKey == "" {
+			return nil, "", fmt.Errorf("COHERE_API_KEY: %w", errMissingAPIKey)
+		}
+		if model == "" {
+			model = "embed-english-v3.0"
+		}
+		embedder, err = NewCohereEmbedder(apiKey, model, dimension)
+	case "voyage":
+		apiKey := os.Getenv("VOYAGE_API_KEY")
+		if apiKey == "" {
+			return nil, "", fmt.Errorf("VOYAGE_API_KEY: %w", errMissingAPIKey)
+		}
+		if model == "" {
+			model = "voyage-3.5"
+		}
+		embedder, err = NewVoyageEmbedder(apiKey, model, dimension)
+	case "gemini":
+		apiKey := os.Getenv("GEMINI_API_KEY")
+		if apiKey == "" {
+			return nil, "", fmt.Errorf("GEMINI_API_KEY: %w", errMissingAPIKey)
+		}
+		if model == "" {
+			model = "text-embedding-004"
+		}
+		embedder, err = NewGeminiClient(apiKey, model, dimension)
+	case "ollama":
+		if model == "" {
+			model = "nomic-embed-text"
+		}
+		client := NewOllamaClient(ollamaHost(), model)
+		client.embeddingDimension = dimension
+		if client.Dimension() == 0 {
+			return nil, "", fmt.Errorf("unknown dimension for Ollama embedding model %q; set EMBEDDING_DIM", model)
+		}
+		embedder = client
+	case "onnx":
+		model = os.Getenv("ONNX_MODEL_PATH")
+		if model == "" {
+			return nil, "", fmt.Errorf("ONNX_MODEL_PATH must be set when EMBEDDING_PROVIDER=onnx")
+		}
+		vocab := os.Getenv("ONNX_VOCAB_PATH")
+		if vocab == "" {
+			vocab = filepath.Join(filepath.Dir(model), "vocab.txt")
+		}
+		embedder, err = NewONNXEmbedder(model, vocab, os.Getenv("ONNX_LIBRARY_PATH"))
+		if err == nil && dimension > 0 && dimension != embedder.Dimension() {
+			err = fmt.Errorf("EMBEDDING_DIM %d does not match the ONNX model's dimension %d", dimension, embedder.Dimension())
+		}
+	default:
+		return nil, "", fmt.Errorf("unknown EMBEDDING_PROVIDER %q (expected openai, azure, gemini, cohere, voyage, ollama, or onnx)", embeddingProvider)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Vectors of different sizes from one model must not share cache entries.
+	namespace := embeddingProvider + ":" + model
+	if dimension > 0 {
+		namespace += ":" + strconv.Itoa(dimension)
+	}
+	embedder, err = withEmbeddingCache(embedder, namespace)
+	return embedder, model, err
+}
+
+// withEmbeddingCache wraps an embedder with a cache sized by
+// EMBEDDING_CACHE_SIZE (default 10000 vectors, 0 disables the in-memory
+// cache) and persisted to EMBEDDING_CACHE_DIR when set.
+func withEmbeddingCache(embedder Embedder, namespace string) (Embedder, error) {
+	capacity := 10000
+	if raw := os.Getenv("EMBEDDING_CACHE_SIZE"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("invalid EMBEDDING_CACHE_SIZE %q", raw)
+		}
+		capacity = parsed
+	}
+	dir := os.Getenv("EMBEDDING_CACHE_DIR")
+	if capacity == 0 && dir == "" {
+		return embedder, nil
+	}
+	return NewCachedEmbedder(embedder, namespace, capacity, dir)
+}
+
+// logCacheStats reports embedding cache effectiveness, if caching is enabled.
+func logCacheStats(embedder Embedder) {
+	cache, ok := embedder.(*CachedEmbedder)
+	if !ok {
+		return
+	}
+	stats := cache.Stats()
+	slog.Info("Embedding cache",
+		"memory_hits", stats.Hits,
+		"disk_hits", stats.DiskHits,
+		"misses", stats.Misses,
+		"hit_rate", fmt.Sprintf("%.1f%%", stats.HitRate()*100))
+}
+
+// usageFile returns the file token usage is recorded in, from USAGE_FILE
+// (default usage.json).
+func usageFile() string {
+	if path := os.Getenv("USAGE_FILE"); path != "" {
+		return path
+	}
+	return "usage.json"
+}
+
+// queryRewriterFromEnv configures query rewriting from QUERY_REWRITE: "off"
+// (the default), "rules" for the glossary in QUERY_GLOSSARY_FILE alone, or
+// "llm" to also have chatModel rewrite every query.
+func queryRewriterFromEnv(chatModel string) (*QueryRewriter, error) {
+	mode := os.Getenv("QUERY_REWRITE")
+	var rewriter QueryRewriter
+	switch mode {
+	case "", "off":
+		return nil, nil
+	case "rules":
+	case "llm":
+		rewriter.Model = chatModel
+	default:
+		return nil, fmt.Errorf("invalid QUERY_REWRITE %q (expected off, rules, or llm)", mode)
+	}
+	if path := os.Getenv("QUERY_GLOSSARY_FILE"); path != "" {
+		glossary, err := LoadQueryGlossary(path)
+		if err != nil {
+			return nil, err
+		}
+		rewriter.Glossary = glossary
+	} else if mode == "rules" {
+		return nil, errors.New("QUERY_REWRITE=rules needs a QUERY_GLOSSARY_FILE")
+	}
+	return &rewriter, nil
+}
+
+// correctiveFromEnv configures corrective retrieval from CORRECTIVE_RAG:
+// "off" (the default), "llm" to grade chunks with CORRECTIVE_MODEL (default
+// the chat model), or "keyword" to grade them locally. CORRECTIVE_MIN_RELEVANT
+// (default 1) is how many chunks must pass, and CORRECTIVE_FALLBACKS (default
+// rewrite) the fallbacks to try when fewer do; web needs webSearch.
+func correctiveFromEnv(llmClient LLMClient, chatModel string, webSearch bool) (*CorrectiveRetrieval, error) {
+	model := os.Getenv("CORRECTIVE_MODEL")
+	if model == "" {
+		model = chatModel
+	}
+	corrective := CorrectiveRetrieval{Model: model, MinRelevant: 1}
+	switch mode := os.Getenv("CORRECTIVE_RAG"); mode {
+	case "", "off":
+		return nil, nil
+	case "llm":
+		corrective.Grader = NewLLMGrader(llmClient, model)
+	case "keyword":
+		corrective.Grader = &KeywordGrader{}
+	default:
+		return nil, fmt.Errorf("invalid CORRECTIVE_RAG %q (expected off, llm, or keyword)", mode)
+	}
+	if raw := os.Getenv("CORRECTIVE_MIN_RELEVANT"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid CORRECTIVE_MIN_RELEVANT %q (expected a positive number of chunks)", raw)
+		}
+		corrective.MinRelevant = n
+	}
+	fallbacks, ok := os.LookupEnv("CORRECTIVE_FALLBACKS")
+	if !ok {
+		fallbacks = string(CorrectRewrite)
+	}
+	actions, err := ParseCorrectiveActions(fallbacks)
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(actions, CorrectWebSearch) && !webSearch {
+		return nil, errors.New("CORRECTIVE_FALLBACKS web needs a WEB_SEARCH provider")
+	}
+	corrective.Actions = actions
+	return &corrective, nil
+}
+
+// rerankerFromEnv configures reranking from RERANKER: empty for none, "llm"
+// to score the candidates with RERANK_MODEL (default the chat model) in one
+// call, "window" to score them in windows of RERANK_WINDOW (default 20)
+// concurrent calls, or "local". RERANK_DEPTH is how many candidates to
+// rerank.
+func rerankerFromEnv(llmClient LLMClient, chatModel string) ([]EngineOption, error) {
+	model := os.Getenv("RERANK_MODEL")
+	if model == "" {
+		model = chatModel
+	}
+	mode := os.Getenv("RERANKER")
+	reranker, err := newReranker(mode, llmClient, model)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RERANKER %q (expected off, llm, window, or local)", mode)
+	}
+	if reranker == nil {
+		return nil, nil
+	}
+	if windowed, ok := reranker.(*WindowedReranker); ok {
+		if raw := os.Getenv("RERANK_WINDOW"); raw != "" {
+			size, err := strconv.Atoi(raw)
+			if err != nil || size < 2 {
+				return nil, fmt.Errorf("invalid RERANK_WINDOW %q (expected at least 2 candidates)", raw)
+			}
+			windowed.Window = size
+		}
+	}
+	opts := []EngineOption{WithReranker(reranker)}
+	if raw := os.Getenv("RERANK_DEPTH"); raw != "" {
+		depth, err := strconv.Atoi(raw)
+		if err != nil || depth <= 0 {
+			return nil, fmt.Errorf("invalid RERANK_DEPTH %q (expected a positive number of candidates)", raw)
+		}
+		opts = append(opts, WithRerankDepth(depth))
+	}
+	return opts, nil
+}
+
+// routerFromEnv configures query routing from QUERY_ROUTER: "off" (the
+// default), "keyword" to route by the keywords of the routes in ROUTES_FILE,
+// or "llm" to have ROUTER_MODEL (default the chat model) choose from their
+// descriptions. Routing needs several collections to choose from.
+func routerFromEnv(llmClient LLMClient, chatModel string, collections bool) (QueryRouter, error) {
+	mode := os.Getenv("QUERY_ROUTER")
+	switch mode {
+	case "", "off":
+		return nil, nil
+	case "keyword", "llm":
+	default:
+		return nil, fmt.Errorf("invalid QUERY_ROUTER %q (expected off, keyword, or llm)", mode)
+	}
+	if !collections {
+		return nil, fmt.Errorf("QUERY_ROUTER %s needs SEARCH_COLLECTIONS", mode)
+	}
+	path := os.Getenv("ROUTES_FILE")
+	if path == "" {
+		return nil, fmt.Errorf("QUERY_ROUTER %s needs a ROUTES_FILE", mode)
+	}
+	routes, err := LoadRoutes(path)
+	if err != nil {
+		return nil, err
+	}
+	if mode == "keyword" {
+		return &KeywordRouter{Routes: routes}, nil
+	}
+	model := os.Getenv("ROUTER_MODEL")
+	if model == "" {
+		model = chatModel
+	}
+	return NewLLMRouter(llmClient, model, routes), nil
+}
+
+// compressorFromEnv configures context compression from CONTEXT_COMPRESSION:
+// "off" (the default), "extractive" to keep the sentences with the most
+// query terms, or "llm" to summarize each chunk with COMPRESSION_MODEL
+// (default the chat model).
+func compressorFromEnv(llmClient LLMClient, chatModel string) (ContextCompressor, error) {
+	switch mode := os.Getenv("CONTEXT_COMPRESSION"); mode {
+	case "", "off":
+		return nil, nil
+	case "extractive":
+		return &ExtractiveCompressor{}, nil
+	case "llm":
+		model := os.Getenv("COMPRESSION_MODEL")
+		if model == "" {
+			model = chatModel
+		}
+		return NewLLMCompressor(llmClient, model), nil
+	default:
+		return nil, fmt.Errorf("invalid CONTEXT_COMPRESSION %q (expected off, extractive, or llm)", mode)
+	}
+}
+
+// rankingFromEnv configures a ranking policy from SOURCE_BOOSTS, e.g.
+// "handbook/*:1.5,archive/*:0.5", and RECENCY_HALF_LIFE, e.g. "720h", with
+// RECENCY_WEIGHT (default 0.2) and RECENCY_FIELD (default "updated"). It
+// returns nil when neither is set.
+func rankingFromEnv() (*RankingPolicy, error) {
+	boosts, err := parseSourceBoosts(os.Getenv("SOURCE_BOOSTS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SOURCE_BOOSTS: %w", err)
+	}
+	policy := RankingPolicy{SourceBoosts: boosts, TimeField: os.Getenv("RECENCY_FIELD")}
+	if raw := os.Getenv("RECENCY_HALF_LIFE"); raw != "" {
+		halfLife, err := time.ParseDuration(raw)
+		if err != nil || halfLife <= 0 {
+			return nil, fmt.Errorf("invalid RECENCY_HALF_LIFE %q (expected a positive duration, e.g. 720h)", raw)
+		}
+		policy.HalfLife = halfLife
+	}
+	if raw := os.Getenv("RECENCY_WEIGHT"); raw != "" {
+		weight, err := strconv.ParseFloat(raw, 64)
+		if err != nil || weight <= 0 || weight > 1 {
+			return nil, fmt.Errorf("invalid RECENCY_WEIGHT %q (expected 0.0-1.0)", raw)
+		}
+		policy.RecencyWeight = weight
+	}
+	if len(policy.SourceBoosts) == 0 && policy.HalfLife == 0 {
+		return nil, nil
+	}
+	return &policy, nil
+}
+
+// noiseFilterFromEnv returns the ingest noise filter enabled by
+// NOISE_FILTER=true, with thresholds from NOISE_MIN_CHARS and
+// NOISE_MIN_TERMS and extra boilerplate phrases from NOISE_BOILERPLATE, or
+// nil when it is off.
+func noiseFilterFromEnv() (*NoiseFilter, error) {
+	if os.Getenv("NOISE_FILTER") != "true" {
+		return nil, nil
+	}
+	var filter NoiseFilter
+	for name, dest := range map[string]*int{"NOISE_MIN_CHARS": &filter.MinChars, "NOISE_MIN_TERMS": &filter.MinTerms} {
+		raw := os.Getenv(name)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid %s %q (expected a positive number)", name, raw)
+		}
+		*dest = n
+	}
+	for _, phrase := range strings.Split(os.Getenv("NOISE_BOILERPLATE"), ",") {
+		if phrase = strings.TrimSpace(phrase); phrase != "" {
+			filter.Boilerplate = append(filter.Boilerplate, phrase)
+		}
+	}
+	return &filter, nil
+}
+
+// enricherFromEnv returns the chunk enricher enabled by
+// CHUNK_ENRICHMENT=true, writing with ENRICHMENT_MODEL (default the chat
+// model) ENRICHMENT_QUESTIONS questions per chunk, or nil when it is off.
+func enricherFromEnv(llmClient LLMClient, chatModel string) (*ChunkEnricher, error) {
+	if os.Getenv("CHUNK_ENRICHMENT") != "true" {
+		return nil, nil
+	}
+	model := os.Getenv("ENRICHMENT_MODEL")
+	if model == "" {
+		model = chatModel
+	}
+	enricher := NewChunkEnricher(llmClient, model)
+	if raw := os.Getenv("ENRICHMENT_QUESTIONS"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > maxEnrichmentQuestions {
+			return nil, fmt.Errorf("invalid ENRICHMENT_QUESTIONS %q (expected 1-%d)", raw, maxEnrichmentQuestions)
+		}
+		enricher.Questions = n
+	}
+	return enricher, nil
+}
+
+// usageAlertFromEnv returns the total cost in USD, from USAGE_ALERT_USD, at
+// which a usage.threshold_exceeded webhook is sent, or 0 when unset.
+func usageAlertFromEnv() (float64, error) {
+	raw := os.Getenv("USAGE_ALERT_USD")
+	if raw == "" {
+		return 0, nil
+	}
+	usd, err := strconv.ParseFloat(raw, 64)
+	if err != nil || usd <= 0 {
+		return 0, fmt.Errorf("invalid USAGE_ALERT_USD %q (expected a positive amount in USD)", raw)
+	}
+	return usd, nil
+}
+
+// syncStateFile returns the file `rag sync` remembers synced pages in, from
+// SYNC_STATE_FILE (default sync_state.json).
+func syncStateFile() string {
+	if path := os.Getenv("SYNC_STATE_FILE"); path != "" {
+		return path
+	}
+	return "sync_state.json"
+}
+
+// expiryIntervalFromEnv reads how often `rag serve` deletes expired
+// documents from EXPIRY_SWEEP_INTERVAL, default hourly. Zero disables the
+// sweeps; retrieval ignores expired documents either way.
+func expiryIntervalFromEnv() (time.Duration, error) {
+	raw := os.Getenv("EXPIRY_SWEEP_INTERVAL")
+	if raw == "" {
+		return time.Hour, nil
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid EXPIRY_SWEEP_INTERVAL %q (expected a duration such as 1h, or 0 to disable)", raw)
+	}
+	return d, nil
+}
+
+// stageTimeoutsFromEnv reads the timeouts of the query stages from
+// EMBED_TIMEOUT, SEARCH_TIMEOUT, RERANK_TIMEOUT, GENERATE_TIMEOUT, and
+// QUERY_TIMEOUT. Each is optional and unbounded by default.
+func stageTimeoutsFromEnv() (StageTimeouts, error) {
+	var timeouts StageTimeouts
+	for _, v := range []struct {
+		name    string
+		timeout *time.Duration
+	}{
+		{"EMBED_TIMEOUT", &timeouts.Embed},
+		{"SEARCH_TIMEOUT", &timeouts.Search},
+		{"RERANK_TIMEOUT", &timeouts.Rerank},
+		{"GENERATE_TIMEOUT", &timeouts.Generate},
+		{"QUERY_TIMEOUT", &timeouts.Query},
+	} {
+		raw := os.Getenv(v.name)
+		if raw == "" {
+			continue
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil || d < 0 {
+			return StageTimeouts{}, fmt.Errorf("invalid %s %q (expected a duration such as 5s, or 0 for none)", v.name, raw)
+		}
+		*v.timeout = d
+	}
+	return timeouts, nil
+}
+
+// envRoles returns the caller roles listed, comma-separated, in ROLES, or
+// nil when it is unset, which leaves retrieval unrestricted.
+func envRoles() []string {
+	return parseACL(os.Getenv("ROLES"))
+}
+
+// historyDB returns the SQLite file queries are recorded in, from HISTORY_DB
+// (default history.db), or "" when HISTORY_DB is "off".
+func historyDB() string {
+	switch path := os.Getenv("HISTORY_DB"); path {
+	case "off":
+		return ""
+	case "":
+		return "history.db"
+	default:
+		return path
+	}
+}
+
+// jobsDB returns the SQLite file `rag serve` keeps ingestion jobs in, from
+// JOBS_DB (default jobs.db), or "" when JOBS_DB is "off".
+func jobsDB() string {
+	switch path := os.Getenv("JOBS_DB"); path {
+	case "off":
+		return ""
+	case "":
+		return "jobs.db"
+	default:
+		return path
+	}
+}
+
+// graphDB returns the SQLite file the knowledge graph is kept in, from
+// GRAPH_DB, or "" when it is unset or "off", the default, since extracting
+// the graph costs an LLM call per ingested chunk.
+func graphDB() string {
+	if path := os.Getenv("GRAPH_DB"); path != "off" {
+		return path
+	}
+	return ""
+}
+
+// jobWorkersFromEnv returns how many ingestion jobs run at once, from
+// JOB_WORKERS (default 2).
+func jobWorkersFromEnv() (int, error) {
+	raw := os.Getenv("JOB_WORKERS")
+	if raw == "" {
+		return 2, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid JOB_WORKERS %q (expected a positive number of jobs)", raw)
+	}
+	return n, nil
+}
+
+// newBucket returns the bucket of an s3:// or gs:// URI and the key prefix
+// within it. S3 credentials come from AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY, and AWS_SESSION_TOKEN, the region from AWS_REGION,
+// and S3_ENDPOINT points at an S3-compatible server. GCS requests use the
+// access token in GCS_ACCESS_TOKEN. Without credentials, buckets are read
+// anonymously.
+func newBucket(uri string) (Bucket, string, error) {
+	scheme, name, prefix, err := ParseBucketURI(uri)
+	if err != nil {
+		return nil, "", err
+	}
+	if scheme == "gs" {
+		return NewGCSBucket(name, os.Getenv("GCS_ENDPOINT"), os.Getenv("GCS_ACCESS_TOKEN")), prefix, nil
+	}
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	return NewS3Bucket(name, region, os.Getenv("S3_ENDPOINT"),
+		os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_SESSION_TOKEN")), prefix, nil
+}
+
+// connectorKinds are the workspaces newConnector can sync.
+var connectorKinds = []string{"notion", "confluence", "sitemap"}
+
+// newConnector returns the connector of kind, one of connectorKinds, reading
+// its credentials from the environment. database selects a Notion database
+// (empty for every shared page), space a Confluence space, and sitemapURL
+// the sitemap to sync.
+func newConnector(kind, database, space, sitemapURL string) (Connector, error) {
+	switch kind {
+	case "notion":
+		token := os.Getenv("NOTION_TOKEN")
+		if token == "" {
+			return nil, errors.New("NOTION_TOKEN is required to sync from Notion")
+		}
+		return NewNotionConnector(token, database), nil
+	case "confluence":
+		baseURL := os.Getenv("CONFLUENCE_URL")
+		if baseURL == "" || space == "" {
+			return nil, errors.New("CONFLUENCE_URL and a space are required to sync from Confluence")
+		}
+		return NewConfluenceConnector(baseURL, space, os.Getenv("CONFLUENCE_EMAIL"), os.Getenv("CONFLUENCE_API_TOKEN")), nil
+	case "sitemap":
+		if sitemapURL == "" {
+			return nil, errors.New("a sitemap URL is required to sync from a sitemap")
+		}
+		return NewSitemapConnector(sitemapURL), nil
+	default:
+		return nil, fmt.Errorf("unknown connector %q (expected notion, confluence, or sitemap)", kind)
+	}
+}
+
+// ollamaHost returns the Ollama server URL from OLLAMA_HOST, accepting the
+// scheme-less host:port form that Ollama itself uses.
+func ollamaHost() string {
+	host := os.Getenv("OLLAMA_HOST")
+	if host == "" {
+		return "http://localhost:11434"
+	}
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	return host
 }
 
 // runDemoMode runs the application without OpenAI API, using mock responses
-func runDemoMode() {
+func runDemoMode(ctx context.Context) {
 	fmt.Println("Running in demo mode (no OpenAI API key provided)")
 	fmt.Println("This demonstrates the RAG engine structure without actual LLM calls.")
 
-	// Create mock implementations
+	// Use a mock LLM and an in-memory store seeded with a small knowledge base
 	mockOpenAI := &mockOpenAIClient{}
-	mockMilvus := &mockMilvusClient{
-		documents: []Document{
-			{Text: "Go is a programming language developed by Google.", Source: "Go Docs", Similarity: 0.85},
-			{Text: "Milvus is a vector database for AI applications.", Source: "Milvus Docs", Similarity: 0.72},
+	store := NewMemoryStore(NewHashingEmbedder(256))
+	store.InsertDocuments(ctx,
+		[]string{
+			"Go is a programming language developed by Google. Go has goroutines and channels for its concurrency model.",
+			"Milvus is a vector database for AI applications.",
 		},
-	}
+		[]string{"Go Docs", "Milvus Docs"},
+		nil,
+	)
 
-	engine := NewRAGEngine(mockOpenAI, mockMilvus)
+	engine := NewRAGEngine(mockOpenAI, store, WithReranker(&KeywordReranker{}))
 
 	// Demo functionality
 	fmt.Println("\n1. Adding documents...")
 	texts := []string{"Sample document about Go programming"}
 	sources := []string{"Demo Source"}
-	success := engine.AddDocuments(texts, sources)
+	success := engine.AddDocuments(ctx, texts, sources)
 	fmt.Printf("Documents added: %t\n", success)
 
 	fmt.Println("\n2. Searching for similar documents...")
-	context := mockMilvus.SearchSimilar("What is Go?", 2)
-	fmt.Printf("Found %d relevant documents\n", len(context))
+	docs := engine.Retrieve(ctx, "What is Go?", 2)
+	fmt.Printf("Found %d relevant documents\n", len(docs))
 
 	fmt.Println("\n3. Generating response...")
-	response, err := engine.GenerateResponse("What is Go?", context, "gpt-3.5-turbo")
+	response, err := engine.GenerateResponse(ctx, "What is Go?", docs, "gpt-3.5-turbo")
 	if err != nil {
-		log.Printf("Error: %v", err)
+		slog.Error("Demo step failed", "error", err)
 		return
 	}
-	fmt.Printf("Response: %s\n", response)
+	fmt.Printf("Response: %s\n", response.Text)
+	printCitations(response)
 
 	fmt.Println("\n4. Testing text chunking...")
 	longText := strings.Repeat("This is a sample sentence for chunking. ", 20)
@@ -360,41 +1694,77 @@ func runDemoMode() {
 	for i, chunk := range chunks {
 		fmt.Printf("Chunk %d: %s...\n", i+1, chunk[:min(50, len(chunk))])
 	}
-}
-
-// Mock implementations for demo mode
-type mockOpenAIClient struct{}
 
-func (m *mockOpenAIClient) ChatCompletion(model string, messages []Message) (string, error) {
-	return "This is a mock response from the RAG engine. In a real implementation, this would be generated by OpenAI's GPT model based on the provided context.", nil
-}
-
-type mockMilvusClient struct {
-	documents []Document
+	fmt.Println("\n5. Multi-turn chat...")
+	conv := engine.NewConversation()
+	for _, question := range []string{"What is Go?", "What about its concurrency model?"} {
+		answer, err := engine.Chat(ctx, conv, question, 2, "gpt-3.5-turbo")
+		if err != nil {
+			slog.Error("Demo step failed", "error", err)
+			return
+		}
+		fmt.Printf("Q: %s\nA: %s\n", question, answer.Text)
+	}
 }
 
-func (m *mockMilvusClient) InsertDocuments(texts, sources []string) bool {
-	for i, text := range texts {
-		if i < len(sources) {
-			// Assign random similarity for demo purposes
-			similarity := 0.6 + (float32(i%5) * 0.08) // Values between 0.6 and 0.92
-			m.documents = append(m.documents, Document{Text: text, Source: sources[i], Similarity: similarity})
+// printCitations lists the sources referenced by an answer.
+func printCitations(answer Answer) {
+	for _, c := range answer.Citations {
+		location := ""
+		if c.ChunkStart >= 0 {
+			location = fmt.Sprintf(" (bytes %d-%d)", c.ChunkStart, c.ChunkEnd)
+		}
+		if c.URL != "" {
+			location = " " + c.URL
+		}
+		if c.Web {
+			fmt.Printf("   [%d] web: %s\n", c.Marker, c.URL)
+			continue
+		}
+		if c.Timecode != "" {
+			location = " at " + c.Timecode + location
+		}
+		if c.ImageURL != "" {
+			location += ", image " + c.ImageURL
+		}
+		fmt.Printf("   [%d] %s%s\n", c.Marker, c.Source, location)
+	}
+	if c := answer.Confidence; c != nil {
+		fmt.Printf("Confidence: %.2f (%s)\n", c.Score, c.Level)
+	}
+	if v := answer.Verification; v != nil && v.Unsupported > 0 {
+		fmt.Printf("Unsupported claims (%d of %d):\n", v.Unsupported, len(v.Claims))
+		for _, claim := range v.Claims {
+			if !claim.Supported {
+				fmt.Printf("   - %s\n", claim.Text)
+			}
 		}
 	}
-	return true
 }
 
-func (m *mockMilvusClient) SearchSimilar(query string, limit int) []Document {
-	// Return up to 'limit' documents
-	if len(m.documents) <= limit {
-		return m.documents
+// printGrading lists the chunks corrective retrieval discarded, if any, and
+// the fallbacks it ran.
+func printGrading(report *RelevanceReport) {
+	if len(report.Discarded) == 0 && len(report.Actions) == 0 {
+		return
+	}
+	fmt.Printf("\nDiscarded %d of %d graded chunks as irrelevant", len(report.Discarded), report.Graded)
+	if len(report.Actions) > 0 {
+		var actions []string
+		for _, action := range report.Actions {
+			actions = append(actions, string(action))
+		}
+		fmt.Printf(" (fallbacks: %s)", strings.Join(actions, ", "))
+	}
+	fmt.Println(":")
+	for _, doc := range report.Discarded {
+		fmt.Printf("   - %s: %s\n", doc.Source, truncateText(doc.Text, 80))
 	}
-	return m.documents[:limit]
 }
 
-func min(a, b int) int {
-	if a < b {
-		return a
-	}
-	return b
+// Mock LLM for demo mode
+type mockOpenAIClient struct{}
+
+func (m *mockOpenAIClient) ChatCompletion(ctx context.Context, model string, messages []Message) (string, error) {
+	return "This is a mock response from the RAG engine [1]. In a real implementation, this would be generated by OpenAI's GPT model based on the provided context.", nil
 }
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// ChunkMarkdown splits Markdown text along its structure. Every heading
+// starts a new chunk, sections are split between paragraphs, lists, and code
+// blocks, and fenced code blocks are never split, even when longer than
+// chunkSize. Only a paragraph longer than chunkSize is cut with
+// ChunkTextWithOffsets, using overlap. Pipe tables get chunks of their own,
+// split between rows only (see tableChunks). Each chunk's metadata records
+// the headings it sits under as heading_path, e.g. "Install > Linux".
+func ChunkMarkdown(text string, chunkSize, overlap int) []Chunk {
+	var chunks []Chunk
+	for _, section := range markdownSections(text) {
+		path := strings.Join(section.headings, " > ")
+		for _, chunk := range packMarkdownBlocks(text, section.blocks, chunkSize, overlap) {
+			if path != "" {
+				if chunk.Metadata == nil {
+					chunk.Metadata = make(map[string]any, 1)
+				}
+				chunk.Metadata["heading_path"] = path
+			}
+			chunks = append(chunks, chunk)
+		}
+	}
+	return chunks
+}
+
+// markdownBlock is a paragraph, heading, fenced code block, or table, given
+// as a byte range of the document.
+type markdownBlock struct {
+	start, end int
+	code       bool
+	table      *pipeTable // with offsets in the document
+}
+
+// markdownSection is a heading and the blocks up to the next heading.
+type markdownSection struct {
+	headings []string // titles of the enclosing headings, outermost first
+	blocks   []markdownBlock
+}
+
+// empty reports whether the section has no content besides its heading.
+func (s markdownSection) empty() bool {
+	return len(s.blocks) == 0 || (len(s.headings) > 0 && len(s.blocks) == 1)
+}
+
+// markdownSections parses the ATX headings, fenced code blocks, pipe tables,
+// and blank line separated paragraphs of text.
+func markdownSections(text string) []markdownSection {
+	type heading struct {
+		level int
+		title string
+	}
+	var (
+		sections  []markdownSection
+		current   markdownSection
+		stack     []heading
+		paragraph = -1 // start of the open paragraph
+		fence     string
+		fenceAt   int
+	)
+	flush := func(end int) {
+		if paragraph >= 0 {
+			current.blocks = append(current.blocks, tableBlocks(text, paragraph, end)...)
+			paragraph = -1
+		}
+	}
+
+	for offset := 0; offset < len(text); {
+		lineEnd := strings.IndexByte(text[offset:], '\n')
+		if lineEnd < 0 {
+			lineEnd = len(text)
+		} else {
+			lineEnd += offset + 1
+		}
+		line := strings.TrimSpace(text[offset:lineEnd])
+
+		switch {
+		case fence != "":
+			if strings.HasPrefix(line, fence) && strings.Trim(line, fence[:1]) == "" {
+				current.blocks = append(current.blocks, markdownBlock{start: fenceAt, end: lineEnd, code: true})
+				fence = ""
+			}
+		case strings.HasPrefix(line, "```") || strings.HasPrefix(line, "~~~"):
+			flush(offset)
+			fence = line[:3]
+			fenceAt = offset
+		case headingLevel(line) > 0:
+			flush(offset)
+			if !current.empty() {
+				sections = append(sections, current)
+			}
+			level := headingLevel(line)
+			for len(stack) > 0 && stack[len(stack)-1].level >= level {
+				stack = stack[:len(stack)-1]
+			}
+			stack = append(stack, heading{level, strings.TrimSpace(strings.Trim(line[level:], " #"))})
+			current = markdownSection{}
+			for _, h := range stack {
+				current.headings = append(current.headings, h.title)
+			}
+			current.blocks = []markdownBlock{{start: offset, end: lineEnd}}
+		case line == "":
+			flush(offset)
+		default:
+			if paragraph < 0 {
+				paragraph = offset
+			}
+		}
+		offset = lineEnd
+	}
+	if fence != "" {
+		current.blocks = append(current.blocks, markdownBlock{start: fenceAt, end: len(text), code: true})
+	}
+	flush(len(text))
+	if !current.empty() {
+		sections = append(sections, current)
+	}
+	return sections
+}
+
+// tableBlocks splits the paragraph text[start:end] into its pipe tables and
+// the text around them.
+func tableBlocks(text string, start, end int) []markdownBlock {
+	var blocks []markdownBlock
+	offset := start
+	for _, table := range findPipeTables(text[start:end]) {
+		table.start += start
+		table.end += start
+		for i := range table.rowSpans {
+			table.rowSpans[i][0] += start
+			table.rowSpans[i][1] += start
+		}
+		if strings.TrimSpace(text[offset:table.start]) != "" {
+			blocks = append(blocks, markdownBlock{start: offset, end: table.start})
+		}
+		blocks = append(blocks, markdownBlock{start: table.start, end: table.end, table: &table})
+		offset = table.end
+	}
+	if strings.TrimSpace(text[offset:end]) != "" {
+		blocks = append(blocks, markdownBlock{start: offset, end: end})
+	}
+	return blocks
+}
+
+// headingLevel returns the level of an ATX heading line, or 0.
+func headingLevel(line string) int {
+	level := 0
+	for level < len(line) && line[level] == '#' {
+		level++
+	}
+	if level == 0 || level > 6 || (level < len(line) && line[level] != ' ' && line[level] != '\t') {
+		return 0
+	}
+	return level
+}
+
+// packMarkdownBlocks joins consecutive blocks into chunks of up to chunkSize
+// characters.
+func packMarkdownBlocks(text string, blocks []markdownBlock, chunkSize, overlap int) []Chunk {
+	var chunks []Chunk
+	for i := 0; i < len(blocks); {
+		block := blocks[i]
+		if block.table != nil {
+			chunks = append(chunks, tableChunks(*block.table, chunkSize)...)
+			i++
+			continue
+		}
+		if !block.code && utf8.RuneCountInString(text[block.start:block.end]) > chunkSize {
+			for _, chunk := range ChunkTextWithOffsets(text[block.start:block.end], chunkSize, overlap) {
+				chunk.Start += block.start
+				chunk.End += block.start
+				chunks = append(chunks, chunk)
+			}
+			i++
+			continue
+		}
+
+		j := i + 1
+		if j < len(blocks) && blocks[j].table != nil && headingLevel(strings.TrimSpace(text[block.start:block.end])) > 0 {
+			// A heading right above a table is in the table chunks'
+			// heading_path; it needs no chunk of its own.
+			i = j
+			continue
+		}
+		for j < len(blocks) && blocks[j].table == nil && utf8.RuneCountInString(text[block.start:blocks[j].end]) <= chunkSize {
+			j++
+		}
+		if chunk, ok := trimmedChunk(text, block.start, blocks[j-1].end); ok {
+			chunks = append(chunks, chunk)
+		}
+		i = j
+	}
+	return chunks
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const markdownDoc = `Intro paragraph.
+
+# Install
+
+## Linux
+
+Use the package manager.
+
+` + "```sh\n# not a heading\napt install rag\n\napt upgrade rag\n```" + `
+
+## macOS
+
+Use Homebrew.
+`
+
+func TestChunkMarkdownFollowsHeadings(t *testing.T) {
+	chunks := ChunkMarkdown(markdownDoc, 1000, 0)
+	var paths []string
+	for _, chunk := range chunks {
+		if markdownDoc[chunk.Start:chunk.End] != chunk.Text {
+			t.Fatalf("offsets %d-%d do not match chunk %q", chunk.Start, chunk.End, chunk.Text)
+		}
+		path, _ := chunk.Metadata["heading_path"].(string)
+		paths = append(paths, path)
+	}
+	want := []string{"", "Install > Linux", "Install > macOS"}
+	if strings.Join(paths, "|") != strings.Join(want, "|") {
+		t.Fatalf("heading paths = %q, want %q", paths, want)
+	}
+	if !strings.HasPrefix(chunks[1].Text, "## Linux") || !strings.Contains(chunks[1].Text, "apt upgrade rag\n```") {
+		t.Fatalf("expected the Linux section with its code block, got %q", chunks[1].Text)
+	}
+}
+
+func TestChunkMarkdownKeepsCodeBlocksIntact(t *testing.T) {
+	code := "```go\n" + strings.Repeat("fmt.Println(\"hello\")\n", 10) + "```"
+	text := "# Example\n\nSome text before.\n\n" + code + "\n\nSome text after."
+	chunks := ChunkMarkdown(text, 60, 0)
+	found := false
+	for _, chunk := range chunks {
+		if strings.Contains(chunk.Text, "```go") {
+			found = chunk.Text == code
+		}
+		if chunk.Metadata["heading_path"] != "Example" {
+			t.Errorf("chunk %q has heading path %v", chunk.Text, chunk.Metadata["heading_path"])
+		}
+	}
+	if !found {
+		t.Fatalf("expected the code block as one chunk, got %q", chunks)
+	}
+}
+
+func TestIngestMarkdownFileRecordsHeadingPath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "guide.md")
+	if err := os.WriteFile(path, []byte(markdownDoc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pages, err := LoadTextFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, sources, metadata := chunkPages(pages, 1000, 0)
+	if len(metadata) != 3 || sources[0] != "guide.md" || metadata[2]["heading_path"] != "Install > macOS" {
+		t.Fatalf("unexpected chunk metadata %v", metadata)
+	}
+}
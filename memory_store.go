@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore implements the VectorStore interface in process, ranking stored
+// embeddings by cosine similarity. Nothing is persisted; it backs demo mode
+// and tests, and small knowledge bases that fit in memory.
+type MemoryStore struct {
+	embedder Embedder
+
+	mu      sync.RWMutex
+	entries []memoryEntry
+}
+
+type memoryEntry struct {
+	doc    Document
+	vector []float32
+}
+
+// NewMemoryStore creates an empty store that embeds text with embedder.
+func NewMemoryStore(embedder Embedder) *MemoryStore {
+	return &MemoryStore{embedder: embedder}
+}
+
+func (m *MemoryStore) InsertDocuments(ctx context.Context, texts, sources []string, metadata []map[string]any) bool {
+	entries, err := m.newEntries(ctx, texts, sources, metadata)
+	if err != nil {
+		slog.ErrorContext(ctx, "Generating embeddings failed", "error", err)
+		return false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = append(m.entries, entries...)
+	return true
+}
+
+// newEntries embeds the texts and pairs each with its document.
+func (m *MemoryStore) newEntries(ctx context.Context, texts, sources []string, metadata []map[string]any) ([]memoryEntry, error) {
+	embeddings, err := embedDocuments(ctx, m.embedder, texts)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]memoryEntry, len(texts))
+	for i, text := range texts {
+		doc := Document{Text: text, Source: sources[i]}
+		if metadata != nil {
+			doc.Metadata = metadata[i]
+		}
+		entries[i] = memoryEntry{doc: doc, vector: embeddings[i]}
+	}
+	return entries, nil
+}
+
+func (m *MemoryStore) SearchSimilar(ctx context.Context, query string, limit int, filter Filter) []Document {
+	queryEmbedding, err := embedQuery(ctx, m.embedder, query)
+	if err != nil {
+		slog.ErrorContext(ctx, "Embedding query failed", "error", err)
+		reportSearchFailure(ctx, err)
+		return []Document{}
+	}
+
+	m.mu.RLock()
+	var documents []Document
+	for _, entry := range m.entries {
+		if !filter.Match(entry.doc) {
+			continue
+		}
+		doc := entry.doc
+		doc.Similarity = max(0, cosineSimilarity(queryEmbedding, entry.vector))
+		documents = append(documents, doc)
+	}
+	m.mu.RUnlock()
+
+	sort.SliceStable(documents, func(i, j int) bool {
+		return documents[i].Similarity > documents[j].Similarity
+	})
+	if len(documents) > limit {
+		documents = documents[:limit]
+	}
+	return documents
+}
+
+func (m *MemoryStore) ContentHashes(ctx context.Context, source string) (map[string]bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	hashes := make(map[string]bool)
+	for _, entry := range m.entries {
+		if hash, ok := entry.doc.Metadata["content_hash"].(string); ok && entry.doc.Source == source {
+			hashes[hash] = true
+		}
+	}
+	return hashes, nil
+}
+
+func (m *MemoryStore) DeleteContentHashes(ctx context.Context, source string, hashes []string) error {
+	remove := make(map[string]bool, len(hashes))
+	for _, hash := range hashes {
+		remove[hash] = true
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.entries[:0]
+	for _, entry := range m.entries {
+		hash, _ := entry.doc.Metadata["content_hash"].(string)
+		if entry.doc.Source != source || !remove[hash] {
+			kept = append(kept, entry)
+		}
+	}
+	m.entries = kept
+	return nil
+}
+
+func (m *MemoryStore) DeleteBySource(ctx context.Context, source string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.removeSource(source)
+	return nil
+}
+
+// DeleteExpired removes the entries that expired at or before now.
+func (m *MemoryStore) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	before := len(m.entries)
+	m.entries = slices.DeleteFunc(m.entries, func(entry memoryEntry) bool {
+		return !expiryAllows(entry.doc.Metadata[expiresField], float64(now.Unix()))
+	})
+	return before - len(m.entries), nil
+}
+
+func (m *MemoryStore) UpdateDocument(ctx context.Context, source string, texts []string, metadata []map[string]any) error {
+	entries, err := m.newEntries(ctx, texts, repeatSource(source, len(texts)), metadata)
+	if err != nil {
+		return fmt.Errorf("embedding chunks of %s: %w", source, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.removeSource(source)
+	m.entries = append(m.entries, entries...)
+	return nil
+}
+
+// removeSource drops the entries of source. The caller must hold m.mu.
+func (m *MemoryStore) removeSource(source string) {
+	kept := m.entries[:0]
+	for _, entry := range m.entries {
+		if entry.doc.Source != source {
+			kept = append(kept, entry)
+		}
+	}
+	m.entries = kept
+}
+
+// ExportDocuments passes the stored documents to fn in insertion order.
+func (m *MemoryStore) ExportDocuments(ctx context.Context, batch int, fn func([]ExportRecord) error) error {
+	m.mu.RLock()
+	records := make([]ExportRecord, len(m.entries))
+	for i, entry := range m.entries {
+		records[i] = ExportRecord{Text: entry.doc.Text, Source: entry.doc.Source, Metadata: entry.doc.Metadata, Embedding: entry.vector}
+	}
+	m.mu.RUnlock()
+	for start := 0; start < len(records); start += batch {
+		if err := fn(records[start:min(start+batch, len(records))]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// vectors returns the stored embeddings, in insertion order.
+func (m *MemoryStore) vectors() [][]float32 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	vectors := make([][]float32, len(m.entries))
+	for i, entry := range m.entries {
+		vectors[i] = entry.vector
+	}
+	return vectors
+}
+
+// Len returns the number of stored documents.
+func (m *MemoryStore) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.entries)
+}
+
+// cosineSimilarity returns the cosine of the angle between a and b, or 0 if
+// either is a zero vector or their lengths differ.
+func cosineSimilarity(a, b []float32) float32 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return float32(dot / (math.Sqrt(normA) * math.Sqrt(normB)))
+}
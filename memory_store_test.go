@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMemoryStoreRanksByCosineSimilarity(t *testing.T) {
+	store := NewMemoryStore(NewHashingEmbedder(256))
+	store.InsertDocuments(context.Background(),
+		[]string{"milvus stores vectors", "go has goroutines and channels", "goroutines are cheap in go"},
+		[]string{"milvus", "go-1", "go-2"},
+		nil,
+	)
+
+	docs := store.SearchSimilar(context.Background(), "goroutines in go", 2, nil)
+	if len(docs) != 2 {
+		t.Fatalf("expected 2 documents, got %d", len(docs))
+	}
+	if docs[0].Source != "go-2" || docs[1].Source != "go-1" {
+		t.Fatalf("unexpected ranking: %s, %s", docs[0].Source, docs[1].Source)
+	}
+	if docs[0].Similarity <= docs[1].Similarity || docs[0].Similarity > 1 {
+		t.Fatalf("expected descending similarities within 0-1, got %f, %f", docs[0].Similarity, docs[1].Similarity)
+	}
+}
+
+func TestMemoryStoreAppliesFilter(t *testing.T) {
+	store := NewMemoryStore(NewHashingEmbedder(256))
+	store.InsertDocuments(context.Background(),
+		[]string{"go release notes", "go release notes"},
+		[]string{"old", "new"},
+		[]map[string]any{{"year": float64(2020)}, {"year": float64(2024)}},
+	)
+
+	docs := store.SearchSimilar(context.Background(), "release", 5, Filter{{Field: "year", Op: ">", Value: float64(2023)}})
+	if len(docs) != 1 || docs[0].Source != "new" {
+		t.Fatalf("expected only the 2024 document, got %+v", docs)
+	}
+	if store.Len() != 2 {
+		t.Fatalf("expected 2 stored documents, got %d", store.Len())
+	}
+}
+
+func TestCosineSimilarity(t *testing.T) {
+	if got := cosineSimilarity([]float32{1, 0}, []float32{1, 0}); got != 1 {
+		t.Fatalf("expected identical vectors to score 1, got %f", got)
+	}
+	if got := cosineSimilarity([]float32{1, 0}, []float32{0, 1}); got != 0 {
+		t.Fatalf("expected orthogonal vectors to score 0, got %f", got)
+	}
+	if got := cosineSimilarity([]float32{0, 0}, []float32{1, 1}); got != 0 {
+		t.Fatalf("expected zero vector to score 0, got %f", got)
+	}
+}
+
+func TestMemoryStoreDeleteAndUpdateBySource(t *testing.T) {
+	store := NewMemoryStore(NewHashingEmbedder(64))
+	engine := NewRAGEngine(&dummyOpenAI{}, store)
+	engine.AddDocuments(context.Background(), []string{"old intro", "old details", "other"}, []string{"guide", "guide", "faq"})
+
+	if err := engine.UpdateDocument(context.Background(), "guide", []string{"new intro"}, []map[string]any{{"rev": float64(2)}}); err != nil {
+		t.Fatalf("UpdateDocument: %v", err)
+	}
+	docs := store.SearchSimilar(context.Background(), "intro", 10, Filter{Eq("source", "guide")})
+	if len(docs) != 1 || docs[0].Text != "new intro" || docs[0].Metadata["rev"] != float64(2) {
+		t.Fatalf("expected only the new chunk for guide, got %+v", docs)
+	}
+
+	if err := engine.DeleteBySource(context.Background(), "guide"); err != nil {
+		t.Fatalf("DeleteBySource: %v", err)
+	}
+	if store.Len() != 1 {
+		t.Fatalf("expected only the faq chunk to remain, got %d", store.Len())
+	}
+
+	if err := engine.UpdateDocument(context.Background(), "faq", []string{"a", "b"}, []map[string]any{{}}); err == nil {
+		t.Fatalf("expected mismatched metadata to be rejected")
+	}
+}
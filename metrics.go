@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus metrics, served by `rag serve` at /metrics alongside the Go
+// runtime and process metrics of the default registry.
+var (
+	queriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rag_queries_total",
+		Help: "Answers generated, by outcome (answered, no_context, flagged, out_of_scope, error).",
+	}, []string{"status"})
+
+	retrievalDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "rag_retrieval_duration_seconds",
+		Help:    "Time to retrieve documents for a query, including reranking.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+	})
+
+	llmDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rag_llm_request_duration_seconds",
+		Help:    "Latency of chat completion requests, by model.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 10),
+	}, []string{"model"})
+
+	llmTokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rag_llm_tokens_total",
+		Help: "Tokens reported by the LLM provider, by model and direction (input, output).",
+	}, []string{"model", "direction"})
+
+	embeddingRequests = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "rag_embedding_requests_total",
+		Help: "Embedding requests, including those answered from the embedding cache.",
+	})
+
+	embeddingTexts = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "rag_embedding_texts_total",
+		Help: "Texts sent for embedding.",
+	})
+
+	embeddingTokens = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "rag_embedding_tokens_total",
+		Help: "Input tokens reported by the embeddings provider.",
+	})
+
+	embeddingDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "rag_embedding_request_duration_seconds",
+		Help:    "Latency of embedding requests.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+	})
+
+	errorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rag_errors_total",
+		Help: "Failures by pipeline stage (llm, embedding, rerank, grade, websearch, graph, vision, transcribe, vectorstore, ingest, moderation).",
+	}, []string{"stage"})
+
+	droppedChunks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rag_ingest_chunks_dropped_total",
+		Help: "Chunks dropped as noise at ingest, by reason (short, low_information).",
+	}, []string{"reason"})
+
+	injectionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rag_context_injections_total",
+		Help: "Retrieved documents with instruction-like text, by the injection guard policy applied.",
+	}, []string{"policy"})
+
+	answerConfidence = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "rag_answer_confidence",
+		Help:    "Confidence scores of generated answers, from 0 to 1.",
+		Buckets: prometheus.LinearBuckets(0.1, 0.1, 10),
+	})
+
+	compressionRatio = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "rag_context_compression_ratio",
+		Help:    "Tokens of compressed context as a share of the uncompressed context.",
+		Buckets: prometheus.LinearBuckets(0.1, 0.1, 10),
+	})
+
+	routedQueries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rag_routed_queries_total",
+		Help: "Queries routed to each collection by the query router, or to all of them.",
+	}, []string{"collection"})
+
+	experimentQueries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rag_experiment_queries_total",
+		Help: "Queries answered by each pipeline of an experiment, by outcome (answered, no_context, flagged, out_of_scope, error).",
+	}, []string{"experiment", "pipeline", "status"})
+
+	experimentDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rag_experiment_query_duration_seconds",
+		Help:    "Time to retrieve and answer a query, by experiment and pipeline.",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"experiment", "pipeline"})
+
+	experimentConfidence = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rag_experiment_answer_confidence",
+		Help:    "Confidence scores of the answers of each pipeline of an experiment, from 0 to 1.",
+		Buckets: prometheus.LinearBuckets(0.1, 0.1, 10),
+	}, []string{"experiment", "pipeline"})
+
+	verifiedClaims = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rag_verified_claims_total",
+		Help: "Answer claims checked against their context, by whether it supports them.",
+	}, []string{"supported"})
+
+	moderationFlagged = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rag_moderation_flagged_total",
+		Help: "Questions and answers flagged by moderation, by stage (query, answer).",
+	}, []string{"stage"})
+
+	storeFallbacks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rag_vectorstore_fallbacks_total",
+		Help: "Retrievals after a failed vector store search, by the fallback used (cache, keyword, llm, or none).",
+	}, []string{"fallback"})
+
+	stageTimeouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rag_stage_timeouts_total",
+		Help: "Query stages canceled for running past their timeout, by stage (embed, search, rerank, generate, query).",
+	}, []string{"stage"})
+
+	gradedChunks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rag_graded_chunks_total",
+		Help: "Retrieved chunks graded by corrective retrieval, by verdict (relevant, discarded).",
+	}, []string{"verdict"})
+
+	correctiveActions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rag_corrective_actions_total",
+		Help: "Corrective retrieval fallbacks run after too few chunks were relevant, by action (rewrite, web).",
+	}, []string{"action"})
+
+	webSearches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rag_web_searches_total",
+		Help: "Web searches run because the knowledge base had nothing relevant, by outcome (results, empty, error).",
+	}, []string{"result"})
+
+	apiRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rag_api_retries_total",
+		Help: "Provider API requests retried after a 429 or 5xx response, by provider and status code.",
+	}, []string{"provider", "code"})
+
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rag_http_requests_total",
+		Help: "API requests served, by route and status code.",
+	}, []string{"route", "code"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rag_http_request_duration_seconds",
+		Help:    "Latency of API requests, by route.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"route"})
+
+	chatSessions = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "rag_chat_sessions",
+		Help: "Open WebSocket chat sessions.",
+	})
+
+	milvusConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "rag_milvus_connections",
+		Help: "Connected Milvus connections in the pool.",
+	})
+)
+
+// queryStatus is the rag_queries_total label for a generated answer.
+func queryStatus(noContext bool, err error) string {
+	switch {
+	case errors.Is(err, ErrContentFlagged):
+		return "flagged"
+	case err != nil:
+		return "error"
+	case noContext:
+		return "no_context"
+	default:
+		return "answered"
+	}
+}
+
+// tokenUsage collects the token counts a provider reports for one request,
+// so the instrumented wrappers can add them to the token counters.
+type tokenUsage struct {
+	input, output int
+}
+
+type tokenUsageKey struct{}
+
+// withTokenUsage returns a context in which recordTokenUsage fills usage.
+func withTokenUsage(ctx context.Context, usage *tokenUsage) context.Context {
+	return context.WithValue(ctx, tokenUsageKey{}, usage)
+}
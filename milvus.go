@@ -0,0 +1,680 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/milvus-io/milvus-sdk-go/v2/client"
+	"github.com/milvus-io/milvus-sdk-go/v2/entity"
+)
+
+// MilvusClientImpl implements the VectorStore interface on Milvus
+type MilvusClientImpl struct {
+	client         client.Client
+	collectionName string
+	embedder       Embedder
+	dimension      int
+	// metric is the index metric: L2, IP, or COSINE. Empty means COSINE for
+	// new collections and whatever an existing collection is indexed with.
+	metric entity.MetricType
+	// partitionKey stores each document's partition in a partition key
+	// field, so searches scoped to partitions skip the others. It applies
+	// to new collections; existing ones keep the layout they were built with.
+	partitionKey bool
+	index        MilvusIndexConfig
+	// sparse, when set, stores a sparse vector of every document next to
+	// its embedding, and searches both and fuses their scores, weighing the
+	// sparse one by sparseWeight and the dense one by the rest.
+	sparse       SparseEmbedder
+	sparseWeight float64
+}
+
+func (m *MilvusClientImpl) metricType() entity.MetricType {
+	if m.metric == "" {
+		return entity.COSINE
+	}
+	return m.metric
+}
+
+// Ping checks that Milvus is healthy and, once the collection exists, that
+// it is loaded, since Milvus only searches loaded collections. A missing
+// collection is fine: it is created on the first insert.
+func (m *MilvusClientImpl) Ping(ctx context.Context) error {
+	state, err := m.client.CheckHealth(ctx)
+	if err != nil {
+		return fmt.Errorf("checking Milvus health: %w", err)
+	}
+	if !state.IsHealthy {
+		return fmt.Errorf("Milvus is unhealthy: %s", strings.Join(state.Reasons, "; "))
+	}
+	load, err := m.client.GetLoadState(ctx, m.collectionName, nil)
+	if err != nil {
+		return fmt.Errorf("checking load state of %s: %w", m.collectionName, err)
+	}
+	switch load {
+	case entity.LoadStateNotExist, entity.LoadStateLoaded:
+		return nil
+	case entity.LoadStateLoading:
+		return fmt.Errorf("collection %s is still loading", m.collectionName)
+	}
+	return fmt.Errorf("collection %s is not loaded", m.collectionName)
+}
+
+// CheckMetric adopts the metric of an existing collection's index, or fails
+// if it differs from the configured one, since Milvus rejects searches with
+// a metric other than the index's.
+func (m *MilvusClientImpl) CheckMetric(ctx context.Context) error {
+	hasCollection, err := m.client.HasCollection(ctx, m.collectionName)
+	if err != nil {
+		return fmt.Errorf("checking collection %s: %w", m.collectionName, err)
+	}
+	if !hasCollection {
+		return nil
+	}
+	indexes, err := m.client.DescribeIndex(ctx, m.collectionName, "embedding")
+	if err != nil {
+		return fmt.Errorf("describing index of %s: %w", m.collectionName, err)
+	}
+	if len(indexes) == 0 {
+		return nil
+	}
+	indexed := entity.MetricType(strings.ToUpper(indexes[0].Params()["metric_type"]))
+	switch {
+	case indexed == "":
+		return nil
+	case m.metric == "":
+		m.metric = indexed
+		return nil
+	case m.metric != indexed:
+		return fmt.Errorf("collection %s is indexed with the %s metric but MILVUS_METRIC is %s; "+
+			"unset MILVUS_METRIC or re-ingest into a new collection", m.collectionName, indexed, m.metric)
+	}
+	return nil
+}
+
+// CheckPartitionKey adopts the layout of an existing collection: whether it
+// has a partition key field decides whether documents are written to it and
+// partition filters use it.
+func (m *MilvusClientImpl) CheckPartitionKey(ctx context.Context) error {
+	hasCollection, err := m.client.HasCollection(ctx, m.collectionName)
+	if err != nil {
+		return fmt.Errorf("checking collection %s: %w", m.collectionName, err)
+	}
+	if !hasCollection {
+		return nil
+	}
+	coll, err := m.client.DescribeCollection(ctx, m.collectionName)
+	if err != nil {
+		return fmt.Errorf("describing collection %s: %w", m.collectionName, err)
+	}
+	hasKey := false
+	if coll.Schema != nil {
+		for _, field := range coll.Schema.Fields {
+			hasKey = hasKey || (field.Name == partitionField && field.IsPartitionKey)
+		}
+	}
+	if m.partitionKey && !hasKey {
+		slog.WarnContext(ctx, "Collection has no partition key; partition filters scan the whole collection",
+			"collection", m.collectionName)
+	}
+	m.partitionKey = hasKey
+	return nil
+}
+
+// CheckSparse adopts the layout of an existing collection: without a sparse
+// vector field, documents are stored and searched with their embedding
+// alone. A collection with one needs a sparse embedder, since Milvus rejects
+// inserts that leave the field out.
+func (m *MilvusClientImpl) CheckSparse(ctx context.Context) error {
+	hasCollection, err := m.client.HasCollection(ctx, m.collectionName)
+	if err != nil {
+		return fmt.Errorf("checking collection %s: %w", m.collectionName, err)
+	}
+	if !hasCollection {
+		return nil
+	}
+	coll, err := m.client.DescribeCollection(ctx, m.collectionName)
+	if err != nil {
+		return fmt.Errorf("describing collection %s: %w", m.collectionName, err)
+	}
+	hasSparse := false
+	if coll.Schema != nil {
+		for _, field := range coll.Schema.Fields {
+			hasSparse = hasSparse || (field.Name == sparseField && field.DataType == entity.FieldTypeSparseVector)
+		}
+	}
+	switch {
+	case hasSparse && m.sparse == nil:
+		return fmt.Errorf("collection %s stores sparse vectors but MILVUS_SPARSE is not set; "+
+			"set it to the sparse embedder the collection was built with", m.collectionName)
+	case !hasSparse && m.sparse != nil:
+		slog.WarnContext(ctx, "Collection has no sparse vector field; searching its embeddings alone",
+			"collection", m.collectionName)
+		m.sparse = nil
+	}
+	return nil
+}
+
+// milvusSimilarity converts a Milvus search score to a 0-1 similarity.
+// Vectors are normalized before insertion and search, so IP and COSINE
+// scores are cosine similarities, and the squared L2 distance between unit
+// vectors is 2 - 2·cos.
+func milvusSimilarity(metric entity.MetricType, score float32) float32 {
+	similarity := score
+	if metric == entity.L2 {
+		similarity = 1 - score/2
+	}
+	return max(0, min(1, similarity))
+}
+
+// CheckDimension verifies that an existing collection stores vectors of the
+// embedder's dimension, so a changed embedding model fails at startup rather
+// than on the first insert or, worse, with meaningless search results. A
+// missing collection passes; it is created with the right dimension.
+func (m *MilvusClientImpl) CheckDimension(ctx context.Context) error {
+	hasCollection, err := m.client.HasCollection(ctx, m.collectionName)
+	if err != nil {
+		return fmt.Errorf("checking collection %s: %w", m.collectionName, err)
+	}
+	if !hasCollection {
+		return nil
+	}
+	coll, err := m.client.DescribeCollection(ctx, m.collectionName)
+	if err != nil {
+		return fmt.Errorf("describing collection %s: %w", m.collectionName, err)
+	}
+	stored := collectionDimension(coll)
+	if stored == 0 || stored == m.dimension {
+		return nil
+	}
+	return fmt.Errorf("collection %s stores %d-dimensional embeddings but the configured embedder produces %d; "+
+		"set COLLECTION_NAME to a new collection and re-ingest, drop the old one with `rag collections drop --name %s`, "+
+		"or switch back to the embedding model it was built with", m.collectionName, stored, m.dimension, m.collectionName)
+}
+
+// collectionDimension returns the dimension of a collection's embedding
+// field, or 0 if it has none.
+func collectionDimension(coll *entity.Collection) int {
+	if coll.Schema == nil {
+		return 0
+	}
+	for _, field := range coll.Schema.Fields {
+		if field.Name == "embedding" {
+			dimension, _ := strconv.Atoi(field.TypeParams["dim"])
+			return dimension
+		}
+	}
+	return 0
+}
+
+func (m *MilvusClientImpl) InsertDocuments(ctx context.Context, texts, sources []string, metadata []map[string]any) bool {
+
+	// Check if collection exists, create if not
+	hasCollection, err := m.client.HasCollection(ctx, m.collectionName)
+	if err != nil {
+		slog.ErrorContext(ctx, "Checking collection failed", "collection", m.collectionName, "error", err)
+		return false
+	}
+
+	if !hasCollection {
+		// Create collection schema
+		schema := &entity.Schema{
+			CollectionName: m.collectionName,
+			Description:    "RAG documents collection",
+			Fields: []*entity.Field{
+				{
+					Name:       "id",
+					DataType:   entity.FieldTypeInt64,
+					PrimaryKey: true,
+					AutoID:     true,
+				},
+				{
+					Name:     "text",
+					DataType: entity.FieldTypeVarChar,
+					TypeParams: map[string]string{
+						"max_length": "65535",
+					},
+				},
+				{
+					Name:     "source",
+					DataType: entity.FieldTypeVarChar,
+					TypeParams: map[string]string{
+						"max_length": "255",
+					},
+				},
+				{
+					Name:     "metadata",
+					DataType: entity.FieldTypeJSON,
+				},
+				{
+					Name:     "embedding",
+					DataType: entity.FieldTypeFloatVector,
+					TypeParams: map[string]string{
+						"dim": strconv.Itoa(m.dimension),
+					},
+				},
+			},
+		}
+
+		if m.partitionKey {
+			schema.Fields = append(schema.Fields, &entity.Field{
+				Name:     partitionField,
+				DataType: entity.FieldTypeVarChar,
+				TypeParams: map[string]string{
+					"max_length": "255",
+				},
+				IsPartitionKey: true,
+			})
+		}
+
+		if m.sparse != nil {
+			schema.Fields = append(schema.Fields, &entity.Field{
+				Name:     sparseField,
+				DataType: entity.FieldTypeSparseVector,
+			})
+		}
+
+		err = m.client.CreateCollection(ctx, schema, entity.DefaultShardNumber)
+		if err != nil {
+			slog.ErrorContext(ctx, "Creating collection failed", "collection", m.collectionName, "error", err)
+			return false
+		}
+
+		// Create index
+		idx, err := m.index.index(m.metricType())
+		if err != nil {
+			slog.ErrorContext(ctx, "Creating index failed", "error", err)
+			return false
+		}
+
+		err = m.client.CreateIndex(ctx, m.collectionName, "embedding", idx, false)
+		if err != nil {
+			slog.ErrorContext(ctx, "Creating index on collection failed", "collection", m.collectionName, "error", err)
+			return false
+		}
+
+		if m.sparse != nil {
+			sparseIdx, err := entity.NewIndexSparseInverted(entity.IP, 0)
+			if err != nil {
+				slog.ErrorContext(ctx, "Creating sparse index failed", "error", err)
+				return false
+			}
+			err = m.client.CreateIndex(ctx, m.collectionName, sparseField, sparseIdx, false)
+			if err != nil {
+				slog.ErrorContext(ctx, "Creating sparse index on collection failed", "collection", m.collectionName, "error", err)
+				return false
+			}
+		}
+
+		// Load collection
+		err = m.client.LoadCollection(ctx, m.collectionName, false)
+		if err != nil {
+			slog.ErrorContext(ctx, "Loading collection failed", "collection", m.collectionName, "error", err)
+			return false
+		}
+	}
+
+	embeddings, err := embedDocuments(ctx, m.embedder, texts)
+	if err != nil {
+		slog.ErrorContext(ctx, "Generating embeddings failed", "error", err)
+		return false
+	}
+	for i, embedding := range embeddings {
+		if len(embedding) != m.dimension {
+			slog.ErrorContext(ctx, "Embedding dimension does not match the collection", "collection", m.collectionName, "expected", m.dimension, "got", len(embedding))
+			return false
+		}
+		embeddings[i] = normalized(embedding)
+	}
+
+	// Prepare data for insertion
+	slog.InfoContext(ctx, "Inserting documents", "documents", len(texts), "collection", m.collectionName)
+	textColumn := entity.NewColumnVarChar("text", texts)
+	sourceColumn := entity.NewColumnVarChar("source", sources)
+	metadataJSON := make([][]byte, len(texts))
+	for i := range texts {
+		var meta map[string]any
+		if metadata != nil {
+			meta = metadata[i]
+		}
+		if meta == nil {
+			meta = map[string]any{}
+		}
+		metadataJSON[i], err = json.Marshal(meta)
+		if err != nil {
+			slog.ErrorContext(ctx, "Encoding metadata failed", "error", err)
+			return false
+		}
+	}
+	columns := []entity.Column{
+		textColumn,
+		sourceColumn,
+		entity.NewColumnJSONBytes("metadata", metadataJSON),
+		entity.NewColumnFloatVector("embedding", m.dimension, embeddings),
+	}
+	if m.partitionKey {
+		partitions := make([]string, len(texts))
+		for i := range partitions {
+			if metadata != nil {
+				partitions[i], _ = metadata[i][partitionField].(string)
+			}
+		}
+		columns = append(columns, entity.NewColumnVarChar(partitionField, partitions))
+	}
+	if m.sparse != nil {
+		sparse, err := m.sparse.EmbedSparse(ctx, texts)
+		if err != nil {
+			slog.ErrorContext(ctx, "Generating sparse vectors failed", "error", err)
+			return false
+		}
+		column, err := sparseColumn(sparse)
+		if err != nil {
+			slog.ErrorContext(ctx, "Encoding sparse vectors failed", "error", err)
+			return false
+		}
+		columns = append(columns, column)
+	}
+
+	_, err = m.client.Insert(ctx, m.collectionName, "", columns...)
+	if err != nil {
+		slog.ErrorContext(ctx, "Inserting documents failed", "error", err)
+		return false
+	}
+	
+	slog.InfoContext(ctx, "Inserted documents", "documents", len(texts))
+
+	// Flush to ensure data is persisted
+	slog.DebugContext(ctx, "Flushing collection", "collection", m.collectionName)
+	err = m.client.Flush(ctx, m.collectionName, false)
+	if err != nil {
+		slog.ErrorContext(ctx, "Flushing collection failed", "collection", m.collectionName, "error", err)
+		return false
+	}
+	
+	slog.DebugContext(ctx, "Collection flushed", "collection", m.collectionName)
+	return true
+}
+
+func (m *MilvusClientImpl) SearchSimilar(ctx context.Context, query string, limit int, filter Filter) []Document {
+
+	queryEmbedding, err := embedQuery(ctx, m.embedder, query)
+	if err != nil {
+		slog.ErrorContext(ctx, "Embedding query failed", "error", err)
+		reportSearchFailure(ctx, err)
+		return []Document{}
+	}
+	queryEmbedding = normalized(queryEmbedding)
+	metric := m.metricType()
+
+	searchParams, err := m.index.searchParam(limit)
+	if err != nil {
+		slog.ErrorContext(ctx, "Configuring search failed", "error", err)
+		reportSearchFailure(ctx, err)
+		return []Document{}
+	}
+	expr := filter.milvusExpr(m.partitionKey)
+	outputFields := []string{"text", "source", "metadata"}
+	sparse := m.sparseQuery(ctx, query)
+	var results []client.SearchResult
+	if sparse != nil {
+		sparseParams, _ := entity.NewIndexSparseInvertedSearchParam(0)
+		requests := []*client.ANNSearchRequest{
+			client.NewANNSearchRequest("embedding", metric, expr, []entity.Vector{entity.FloatVector(queryEmbedding)}, searchParams, limit),
+			client.NewANNSearchRequest(sparseField, entity.IP, expr, []entity.Vector{sparse}, sparseParams, limit),
+		}
+		reranker := client.NewWeightedReranker([]float64{1 - m.sparseWeight, m.sparseWeight})
+		results, err = m.client.HybridSearch(ctx, m.collectionName, []string{}, limit, outputFields, reranker, requests)
+	} else {
+		results, err = m.client.Search(
+			ctx,
+			m.collectionName,
+			[]string{},
+			expr,
+			outputFields,
+			[]entity.Vector{entity.FloatVector(queryEmbedding)},
+			"embedding",
+			metric,
+			limit,
+			searchParams,
+		)
+	}
+
+	if err != nil {
+		slog.ErrorContext(ctx, "Searching documents failed", "error", err)
+		reportSearchFailure(ctx, err)
+		return []Document{}
+	}
+
+	var documents []Document
+	if len(results) > 0 {
+		slog.DebugContext(ctx, "Milvus search returned results", "results", results[0].ResultCount)
+		for i := 0; i < results[0].ResultCount; i++ {
+			text, _ := results[0].Fields.GetColumn("text").Get(i)
+			source, _ := results[0].Fields.GetColumn("source").Get(i)
+			var metadata map[string]any
+			if column := results[0].Fields.GetColumn("metadata"); column != nil {
+				if raw, err := column.Get(i); err == nil {
+					if data, ok := raw.([]byte); ok && json.Unmarshal(data, &metadata) != nil {
+						slog.WarnContext(ctx, "Ignoring unreadable metadata", "rank", i+1)
+					}
+				}
+			}
+			
+			score := results[0].Scores[i]
+			similarity := milvusSimilarity(metric, score)
+			if sparse != nil {
+				// The weighted reranker normalizes both scores to 0-1
+				// before fusing them.
+				similarity = max(0, min(1, score))
+			}
+			slog.DebugContext(ctx, "Search result", "rank", i+1, "metric", metric, "score", score, "similarity", similarity)
+
+			documents = append(documents, Document{
+				Text:       text.(string),
+				Source:     source.(string),
+				Metadata:   metadata,
+				Similarity: similarity,
+			})
+		}
+	} else {
+		slog.InfoContext(ctx, "No documents found matching the query")
+	}
+
+	return documents
+}
+
+// sparseQuery returns the sparse vector of query, or nil when the store has
+// no sparse embedder or query has no terms it knows. A failing sparse
+// embedder leaves the search to the dense embedding.
+func (m *MilvusClientImpl) sparseQuery(ctx context.Context, query string) entity.Vector {
+	if m.sparse == nil {
+		return nil
+	}
+	vectors, err := m.sparse.EmbedSparse(withQueryEmbedding(ctx), []string{query})
+	if err != nil {
+		slog.WarnContext(ctx, "Sparse query embedding failed; searching embeddings alone", "error", err)
+		return nil
+	}
+	if len(vectors) == 0 || len(vectors[0].Indices) == 0 {
+		return nil
+	}
+	vector, err := entity.NewSliceSparseEmbedding(vectors[0].Indices, vectors[0].Values)
+	if err != nil {
+		slog.WarnContext(ctx, "Sparse query embedding is invalid; searching embeddings alone", "error", err)
+		return nil
+	}
+	return vector
+}
+
+// sparseColumn returns the sparse vector column of vectors.
+func sparseColumn(vectors []SparseVector) (entity.Column, error) {
+	embeddings := make([]entity.SparseEmbedding, len(vectors))
+	for i, vector := range vectors {
+		embedding, err := entity.NewSliceSparseEmbedding(vector.Indices, vector.Values)
+		if err != nil {
+			return nil, err
+		}
+		embeddings[i] = embedding
+	}
+	return entity.NewColumnSparseVectors(sparseField, embeddings), nil
+}
+
+func (m *MilvusClientImpl) ContentHashes(ctx context.Context, source string) (map[string]bool, error) {
+	hashes := make(map[string]bool)
+	hasCollection, err := m.client.HasCollection(ctx, m.collectionName)
+	if err != nil || !hasCollection {
+		return hashes, err
+	}
+
+	results, err := m.client.Query(ctx, m.collectionName, nil, "source == "+strconv.Quote(source), []string{"metadata"})
+	if err != nil {
+		return nil, fmt.Errorf("querying chunks of %s: %w", source, err)
+	}
+	column := results.GetColumn("metadata")
+	if column == nil {
+		return hashes, nil
+	}
+	for i := 0; i < column.Len(); i++ {
+		raw, err := column.Get(i)
+		if err != nil {
+			continue
+		}
+		var meta struct {
+			ContentHash string `json:"content_hash"`
+		}
+		if data, ok := raw.([]byte); ok && json.Unmarshal(data, &meta) == nil && meta.ContentHash != "" {
+			hashes[meta.ContentHash] = true
+		}
+	}
+	return hashes, nil
+}
+
+// ExportDocuments pages through the collection in primary key order, batch
+// rows at a time. A missing collection has nothing to export.
+func (m *MilvusClientImpl) ExportDocuments(ctx context.Context, batch int, fn func([]ExportRecord) error) error {
+	hasCollection, err := m.client.HasCollection(ctx, m.collectionName)
+	if err != nil || !hasCollection {
+		return err
+	}
+	last := int64(-1)
+	for {
+		expr := fmt.Sprintf("id > %d", last)
+		results, err := m.client.Query(ctx, m.collectionName, nil, expr, []string{"id", "text", "source", "metadata", "embedding"}, client.WithLimit(int64(batch)))
+		if err != nil {
+			return fmt.Errorf("querying %s: %w", expr, err)
+		}
+		ids, _ := results.GetColumn("id").(*entity.ColumnInt64)
+		texts, _ := results.GetColumn("text").(*entity.ColumnVarChar)
+		sources, _ := results.GetColumn("source").(*entity.ColumnVarChar)
+		metadata, _ := results.GetColumn("metadata").(*entity.ColumnJSONBytes)
+		embeddings, _ := results.GetColumn("embedding").(*entity.ColumnFloatVector)
+		if ids == nil || ids.Len() == 0 {
+			return nil
+		}
+		if texts == nil || sources == nil || metadata == nil || embeddings == nil {
+			return fmt.Errorf("querying %s: missing output fields", expr)
+		}
+
+		order := make([]int, ids.Len())
+		for i := range order {
+			order[i] = i
+		}
+		sort.Slice(order, func(a, b int) bool { return ids.Data()[order[a]] < ids.Data()[order[b]] })
+		records := make([]ExportRecord, len(order))
+		for i, row := range order {
+			records[i] = ExportRecord{Text: texts.Data()[row], Source: sources.Data()[row], Embedding: embeddings.Data()[row]}
+			if err := json.Unmarshal(metadata.Data()[row], &records[i].Metadata); err != nil {
+				return fmt.Errorf("decoding metadata of row %d: %w", ids.Data()[row], err)
+			}
+			last = max(last, ids.Data()[row])
+		}
+		if err := fn(records); err != nil {
+			return err
+		}
+	}
+}
+
+// milvusExpiryBatch caps the expired chunks DeleteExpired removes at once,
+// below Milvus's limit on query results; the rest go on the next sweep.
+const milvusExpiryBatch = 10000
+
+// DeleteExpired looks up the IDs of expired chunks and deletes them by
+// primary key, which every Milvus version supports.
+func (m *MilvusClientImpl) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	hasCollection, err := m.client.HasCollection(ctx, m.collectionName)
+	if err != nil || !hasCollection {
+		return 0, err
+	}
+	expr := fmt.Sprintf("metadata[%s] <= %d", strconv.Quote(expiresField), now.Unix())
+	results, err := m.client.Query(ctx, m.collectionName, nil, expr, []string{"id"}, client.WithLimit(milvusExpiryBatch))
+	if err != nil {
+		return 0, fmt.Errorf("querying expired chunks: %w", err)
+	}
+	ids, _ := results.GetColumn("id").(*entity.ColumnInt64)
+	if ids == nil || ids.Len() == 0 {
+		return 0, nil
+	}
+	if err := m.client.DeleteByPks(ctx, m.collectionName, "", ids); err != nil {
+		return 0, fmt.Errorf("deleting expired chunks: %w", err)
+	}
+	return ids.Len(), nil
+}
+
+func (m *MilvusClientImpl) DeleteContentHashes(ctx context.Context, source string, hashes []string) error {
+	quoted := make([]string, len(hashes))
+	for i, hash := range hashes {
+		quoted[i] = strconv.Quote(hash)
+	}
+	expr := fmt.Sprintf(`source == %s && metadata["content_hash"] in [%s]`, strconv.Quote(source), strings.Join(quoted, ", "))
+	if err := m.client.Delete(ctx, m.collectionName, "", expr); err != nil {
+		return fmt.Errorf("deleting chunks of %s: %w", source, err)
+	}
+	return nil
+}
+
+func (m *MilvusClientImpl) DeleteBySource(ctx context.Context, source string) error {
+	hasCollection, err := m.client.HasCollection(ctx, m.collectionName)
+	if err != nil || !hasCollection {
+		return err
+	}
+	if err := m.client.Delete(ctx, m.collectionName, "", "source == "+strconv.Quote(source)); err != nil {
+		return fmt.Errorf("deleting chunks of %s: %w", source, err)
+	}
+	return nil
+}
+
+// UpdateDocument inserts the new chunks before deleting the old ones by
+// primary key, so searches never see the source missing.
+func (m *MilvusClientImpl) UpdateDocument(ctx context.Context, source string, texts []string, metadata []map[string]any) error {
+	var oldIDs []int64
+	hasCollection, err := m.client.HasCollection(ctx, m.collectionName)
+	if err != nil {
+		return fmt.Errorf("checking collection: %w", err)
+	}
+	if hasCollection {
+		results, err := m.client.Query(ctx, m.collectionName, nil, "source == "+strconv.Quote(source), []string{"id"})
+		if err != nil {
+			return fmt.Errorf("querying chunks of %s: %w", source, err)
+		}
+		if column, ok := results.GetColumn("id").(*entity.ColumnInt64); ok {
+			oldIDs = column.Data()
+		}
+	}
+
+	if !m.InsertDocuments(ctx, texts, repeatSource(source, len(texts)), metadata) {
+		return fmt.Errorf("inserting new chunks of %s failed", source)
+	}
+	if len(oldIDs) == 0 {
+		return nil
+	}
+	if err := m.client.DeleteByPks(ctx, m.collectionName, "", entity.NewColumnInt64("id", oldIDs)); err != nil {
+		return fmt.Errorf("deleting old chunks of %s: %w", source, err)
+	}
+	return nil
+}
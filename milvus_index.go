@@ -0,0 +1,204 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/milvus-io/milvus-sdk-go/v2/entity"
+)
+
+// MilvusIndexConfig selects the vector index of new Milvus collections and
+// the parameters searches run with. Only the parameters of the selected type
+// are used, and zero values take those of DefaultMilvusIndex.
+type MilvusIndexConfig struct {
+	Type entity.IndexType // HNSW, IVF_FLAT, IVF_SQ8, or DISKANN; empty means HNSW
+
+	// HNSW: graph degree and build-time candidate list size, and the
+	// search-time candidate list size.
+	M              int
+	EfConstruction int
+	Ef             int
+
+	// IVF_FLAT and IVF_SQ8: number of clusters, and clusters probed per
+	// search.
+	NList  int
+	NProbe int
+
+	// DISKANN: search-time candidate list size.
+	SearchList int
+}
+
+// DefaultMilvusIndex is the index new collections get unless configured
+// otherwise.
+var DefaultMilvusIndex = MilvusIndexConfig{
+	Type:           entity.HNSW,
+	M:              8,
+	EfConstruction: 96,
+	Ef:             16,
+	NList:          1024,
+	NProbe:         16,
+	SearchList:     100,
+}
+
+// ParseMilvusIndexType accepts the index types the store can build, in any
+// case, and empty for the default.
+func ParseMilvusIndexType(name string) (entity.IndexType, error) {
+	switch indexType := entity.IndexType(strings.ToUpper(name)); indexType {
+	case "", entity.HNSW, entity.IvfFlat, entity.IvfSQ8, entity.DISKANN:
+		return indexType, nil
+	}
+	return "", fmt.Errorf("unsupported Milvus index type %q (expected HNSW, IVF_FLAT, IVF_SQ8, or DISKANN)", name)
+}
+
+// withDefaults fills in the unset fields from DefaultMilvusIndex.
+func (c MilvusIndexConfig) withDefaults() MilvusIndexConfig {
+	d := DefaultMilvusIndex
+	c.Type = cmp.Or(c.Type, d.Type)
+	c.M = cmp.Or(c.M, d.M)
+	c.EfConstruction = cmp.Or(c.EfConstruction, d.EfConstruction)
+	c.Ef = cmp.Or(c.Ef, d.Ef)
+	c.NList = cmp.Or(c.NList, d.NList)
+	c.NProbe = cmp.Or(c.NProbe, d.NProbe)
+	c.SearchList = cmp.Or(c.SearchList, d.SearchList)
+	return c
+}
+
+// index returns the index to build with metric.
+func (c MilvusIndexConfig) index(metric entity.MetricType) (entity.Index, error) {
+	c = c.withDefaults()
+	switch c.Type {
+	case entity.IvfFlat:
+		return entity.NewIndexIvfFlat(metric, c.NList)
+	case entity.IvfSQ8:
+		return entity.NewIndexIvfSQ8(metric, c.NList)
+	case entity.DISKANN:
+		return entity.NewIndexDISKANN(metric)
+	case entity.HNSW:
+		return entity.NewIndexHNSW(metric, c.M, c.EfConstruction)
+	}
+	return nil, fmt.Errorf("unsupported Milvus index type %s", c.Type)
+}
+
+// searchParam returns the search parameters for a search of limit results.
+// HNSW and DISKANN reject candidate lists shorter than the result count, so
+// those are raised to limit.
+func (c MilvusIndexConfig) searchParam(limit int) (entity.SearchParam, error) {
+	c = c.withDefaults()
+	switch c.Type {
+	case entity.IvfFlat:
+		return entity.NewIndexIvfFlatSearchParam(c.NProbe)
+	case entity.IvfSQ8:
+		return entity.NewIndexIvfSQ8SearchParam(c.NProbe)
+	case entity.DISKANN:
+		return entity.NewIndexDISKANNSearchParam(max(c.SearchList, limit))
+	case entity.HNSW:
+		return entity.NewIndexHNSWSearchParam(max(c.Ef, limit))
+	}
+	return nil, fmt.Errorf("unsupported Milvus index type %s", c.Type)
+}
+
+// describe summarizes the build parameters of the index type.
+func (c MilvusIndexConfig) describe() string {
+	c = c.withDefaults()
+	switch c.Type {
+	case entity.IvfFlat:
+		return fmt.Sprintf("IVF_FLAT nlist=%d", c.NList)
+	case entity.IvfSQ8:
+		return fmt.Sprintf("IVF_SQ8 nlist=%d", c.NList)
+	case entity.DISKANN:
+		return "DISKANN"
+	}
+	return fmt.Sprintf("HNSW M=%d efConstruction=%d", c.M, c.EfConstruction)
+}
+
+// CheckIndex adopts the type of an existing collection's index, so searches
+// send the parameters it expects. A configured type that differs is only
+// applied by `rag index rebuild`.
+func (m *MilvusClientImpl) CheckIndex(ctx context.Context) error {
+	indexed, err := m.indexType(ctx)
+	if err != nil || indexed == "" {
+		return err
+	}
+	if m.index.Type != "" && m.index.Type != indexed {
+		slog.WarnContext(ctx, "Collection keeps its index until it is rebuilt with `rag index rebuild`",
+			"collection", m.collectionName, "index", indexed, "configured", m.index.Type)
+	}
+	m.index.Type = indexed
+	return nil
+}
+
+// indexType returns the type of the collection's vector index, or "" when
+// the collection or its index does not exist yet.
+func (m *MilvusClientImpl) indexType(ctx context.Context) (entity.IndexType, error) {
+	hasCollection, err := m.client.HasCollection(ctx, m.collectionName)
+	if err != nil {
+		return "", fmt.Errorf("checking collection %s: %w", m.collectionName, err)
+	}
+	if !hasCollection {
+		return "", nil
+	}
+	indexes, err := m.client.DescribeIndex(ctx, m.collectionName, "embedding")
+	if err != nil {
+		return "", fmt.Errorf("describing index of %s: %w", m.collectionName, err)
+	}
+	if len(indexes) == 0 {
+		return "", nil
+	}
+	indexType := indexes[0].IndexType()
+	if raw := indexes[0].Params()["index_type"]; raw != "" {
+		indexType = entity.IndexType(raw)
+	}
+	return indexType, nil
+}
+
+// IndexInfo describes the vector index of a collection.
+type IndexInfo struct {
+	Type   entity.IndexType
+	Params map[string]string
+}
+
+// DescribeIndex returns the vector index of the store's collection.
+func (m *MilvusClientImpl) DescribeIndex(ctx context.Context) (*IndexInfo, error) {
+	indexes, err := m.client.DescribeIndex(ctx, m.collectionName, "embedding")
+	if err != nil {
+		return nil, fmt.Errorf("describing index of %s: %w", m.collectionName, err)
+	}
+	if len(indexes) == 0 {
+		return nil, fmt.Errorf("collection %s has no vector index", m.collectionName)
+	}
+	return &IndexInfo{Type: indexes[0].IndexType(), Params: indexes[0].Params()}, nil
+}
+
+// RebuildIndex replaces the collection's vector index with the configured
+// one, using the configured metric or, if none, the current index's. The
+// collection is released while the index is rebuilt, so searches fail until
+// it is loaded again.
+func (m *MilvusClientImpl) RebuildIndex(ctx context.Context) error {
+	if m.metric == "" {
+		if info, err := m.DescribeIndex(ctx); err == nil {
+			m.metric = entity.MetricType(strings.ToUpper(info.Params["metric_type"]))
+		}
+	}
+	idx, err := m.index.index(m.metricType())
+	if err != nil {
+		return fmt.Errorf("configuring index: %w", err)
+	}
+	slog.InfoContext(ctx, "Releasing collection", "collection", m.collectionName)
+	if err := m.client.ReleaseCollection(ctx, m.collectionName); err != nil {
+		return fmt.Errorf("releasing collection %s: %w", m.collectionName, err)
+	}
+	if err := m.client.DropIndex(ctx, m.collectionName, "embedding"); err != nil {
+		return fmt.Errorf("dropping index of %s: %w", m.collectionName, err)
+	}
+	slog.InfoContext(ctx, "Building index", "collection", m.collectionName, "index", m.index.describe(), "metric", m.metricType())
+	if err := m.client.CreateIndex(ctx, m.collectionName, "embedding", idx, false); err != nil {
+		return fmt.Errorf("creating index on %s: %w", m.collectionName, err)
+	}
+	if err := m.client.LoadCollection(ctx, m.collectionName, false); err != nil {
+		return fmt.Errorf("loading collection %s: %w", m.collectionName, err)
+	}
+	return nil
+}
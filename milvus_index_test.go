@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/milvus-io/milvus-sdk-go/v2/client"
+	"github.com/milvus-io/milvus-sdk-go/v2/entity"
+)
+
+func TestMilvusIndexConfig(t *testing.T) {
+	idx, err := MilvusIndexConfig{}.index(entity.COSINE)
+	if err != nil || idx.IndexType() != entity.HNSW || idx.Params()["params"] != `{"M":"8","efConstruction":"96"}` {
+		t.Fatalf("expected the default HNSW index, got %v (%v)", idx.Params(), err)
+	}
+	idx, err = MilvusIndexConfig{Type: entity.IvfFlat, NList: 256}.index(entity.L2)
+	if err != nil || idx.Params()["params"] != `{"nlist":"256"}` || idx.Params()["metric_type"] != "L2" {
+		t.Fatalf("unexpected IVF_FLAT index %v (%v)", idx.Params(), err)
+	}
+	idx, err = MilvusIndexConfig{Type: entity.IvfSQ8}.index(entity.COSINE)
+	if err != nil || idx.IndexType() != entity.IvfSQ8 || idx.Params()["params"] != `{"nlist":"1024"}` {
+		t.Fatalf("unexpected IVF_SQ8 index %v (%v)", idx.Params(), err)
+	}
+	if _, err := (MilvusIndexConfig{M: 100}).index(entity.COSINE); err == nil {
+		t.Fatal("expected an out-of-range M to be rejected")
+	}
+
+	cases := []struct {
+		config MilvusIndexConfig
+		limit  int
+		want   map[string]any
+	}{
+		{MilvusIndexConfig{}, 3, map[string]any{"ef": 16}},
+		{MilvusIndexConfig{Ef: 64}, 3, map[string]any{"ef": 64}},
+		{MilvusIndexConfig{}, 50, map[string]any{"ef": 50}}, // ef may not be below the result count
+		{MilvusIndexConfig{Type: entity.IvfFlat, NProbe: 8}, 50, map[string]any{"nprobe": 8}},
+		{MilvusIndexConfig{Type: entity.IvfSQ8}, 3, map[string]any{"nprobe": 16}},
+		{MilvusIndexConfig{Type: entity.DISKANN}, 200, map[string]any{"search_list": 200}},
+	}
+	for i, c := range cases {
+		param, err := c.config.searchParam(c.limit)
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		for k, v := range c.want {
+			if param.Params()[k] != v {
+				t.Errorf("case %d: %s = %v, want %v", i, k, param.Params()[k], v)
+			}
+		}
+	}
+}
+
+func TestParseMilvusIndexType(t *testing.T) {
+	for name, want := range map[string]entity.IndexType{"": "", "hnsw": entity.HNSW, "ivf_flat": entity.IvfFlat, "IVF_SQ8": entity.IvfSQ8, "DISKANN": entity.DISKANN} {
+		if got, err := ParseMilvusIndexType(name); err != nil || got != want {
+			t.Errorf("ParseMilvusIndexType(%q) = %s, %v", name, got, err)
+		}
+	}
+	if _, err := ParseMilvusIndexType("annoy"); err == nil {
+		t.Error("expected an unsupported index type to be rejected")
+	}
+}
+
+func TestMilvusCheckIndexAdoptsExistingType(t *testing.T) {
+	sdk := &fakeMilvusSDK{collections: []*entity.Collection{{Name: "rag_documents"}}}
+	store := &MilvusClientImpl{client: sdk, collectionName: "rag_documents", index: MilvusIndexConfig{Type: entity.IvfFlat}}
+	if err := store.CheckIndex(context.Background()); err != nil || store.index.Type != entity.HNSW {
+		t.Fatalf("expected the collection's HNSW index to be adopted, got %s (%v)", store.index.Type, err)
+	}
+
+	store = &MilvusClientImpl{client: sdk, collectionName: "new_documents", index: MilvusIndexConfig{Type: entity.DISKANN}}
+	if err := store.CheckIndex(context.Background()); err != nil || store.index.Type != entity.DISKANN {
+		t.Fatalf("expected new collections to use the configured index, got %s (%v)", store.index.Type, err)
+	}
+}
+
+// indexRecorder records the index management calls of a rebuild.
+type indexRecorder struct {
+	*fakeMilvusSDK
+	calls   []string
+	created entity.Index
+}
+
+func (r *indexRecorder) ReleaseCollection(ctx context.Context, name string, opts ...client.ReleaseCollectionOption) error {
+	r.calls = append(r.calls, "release")
+	return nil
+}
+
+func (r *indexRecorder) DropIndex(ctx context.Context, name, field string, opts ...client.IndexOption) error {
+	r.calls = append(r.calls, "drop")
+	return nil
+}
+
+func (r *indexRecorder) CreateIndex(ctx context.Context, name, field string, idx entity.Index, async bool, opts ...client.IndexOption) error {
+	r.calls = append(r.calls, "create")
+	r.created = idx
+	return nil
+}
+
+func (r *indexRecorder) LoadCollection(ctx context.Context, name string, async bool, opts ...client.LoadCollectionOption) error {
+	r.calls = append(r.calls, "load")
+	return nil
+}
+
+func TestMilvusRebuildIndex(t *testing.T) {
+	sdk := &indexRecorder{fakeMilvusSDK: &fakeMilvusSDK{collections: []*entity.Collection{{Name: "rag_documents"}}, metric: "IP"}}
+	store := &MilvusClientImpl{client: sdk, collectionName: "rag_documents", index: MilvusIndexConfig{Type: entity.IvfFlat, NList: 128}}
+	if err := store.RebuildIndex(context.Background()); err != nil {
+		t.Fatalf("RebuildIndex returned error: %v", err)
+	}
+	if want := []string{"release", "drop", "create", "load"}; !slices.Equal(sdk.calls, want) {
+		t.Fatalf("calls = %v, want %v", sdk.calls, want)
+	}
+	if sdk.created.IndexType() != entity.IvfFlat || sdk.created.Params()["metric_type"] != "IP" {
+		t.Fatalf("expected an IVF_FLAT index keeping the IP metric, got %v", sdk.created.Params())
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/milvus-io/milvus-sdk-go/v2/client"
+	"github.com/milvus-io/milvus-sdk-go/v2/entity"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// milvusDialTimeout bounds one attempt to connect to Milvus.
+	milvusDialTimeout = 10 * time.Second
+	// milvusHealthTimeout bounds the health check of one connection.
+	milvusHealthTimeout = 5 * time.Second
+)
+
+// milvusReconnectPolicy spaces out attempts to reconnect to an unreachable
+// Milvus. Only its delays are used.
+var milvusReconnectPolicy = RetryPolicy{BaseDelay: 500 * time.Millisecond, MaxDelay: 30 * time.Second}
+
+// milvusDialer opens a Milvus connection.
+type milvusDialer func(ctx context.Context) (client.Client, error)
+
+// milvusPool is a client.Client that spreads calls round-robin over several
+// Milvus connections, so concurrent searches do not queue on one HTTP/2
+// connection. A connection that fails with a transport error is closed and
+// the call is retried once on a fresh one; connections are re-dialed lazily,
+// on the next call that needs them, with exponential backoff between failed
+// attempts. With a health interval, a background loop also checks every
+// connection and reconnects dead ones before a query runs into them. This
+// lets long-running servers survive Milvus restarts.
+type milvusPool struct {
+	// Client is nil: the pool implements the methods the store and the
+	// collections command use, and any other method panics.
+	client.Client
+
+	dial  milvusDialer
+	conns []*milvusConn
+	next  atomic.Uint64
+
+	closed  atomic.Bool
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// milvusConn is a slot of the pool.
+type milvusConn struct {
+	mu       sync.Mutex
+	client   client.Client // nil while disconnected
+	lastErr  error         // why the slot is disconnected
+	failures int           // consecutive failed dials
+	retryAt  time.Time     // no dial before this
+}
+
+// newMilvusPool dials size connections. The first must succeed, so a wrong
+// address still fails at startup; the others are retried lazily. A positive
+// healthInterval starts the health check loop, which Close stops.
+func newMilvusPool(ctx context.Context, dial milvusDialer, size int, healthInterval time.Duration) (*milvusPool, error) {
+	p := &milvusPool{dial: dial, stop: make(chan struct{}), stopped: make(chan struct{})}
+	for i := 0; i < max(size, 1); i++ {
+		conn := &milvusConn{}
+		p.conns = append(p.conns, conn)
+		if _, err := conn.connect(ctx, dial); err != nil && i == 0 {
+			return nil, err
+		}
+	}
+	if healthInterval <= 0 {
+		close(p.stopped)
+		return p, nil
+	}
+	go func() {
+		defer close(p.stopped)
+		ticker := time.NewTicker(healthInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.checkHealth(context.Background())
+			}
+		}
+	}()
+	return p, nil
+}
+
+// connect returns the slot's client, dialing it if the slot is disconnected
+// and its backoff has passed.
+func (c *milvusConn) connect(ctx context.Context, dial milvusDialer) (client.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client != nil {
+		return c.client, nil
+	}
+	if time.Now().Before(c.retryAt) {
+		return nil, c.lastErr
+	}
+	ctx, cancel := context.WithTimeout(ctx, milvusDialTimeout)
+	defer cancel()
+	sdk, err := dial(ctx)
+	if err != nil {
+		c.failures++
+		c.lastErr = fmt.Errorf("connecting to Milvus: %w", err)
+		c.retryAt = time.Now().Add(milvusReconnectPolicy.backoff(c.failures))
+		slog.WarnContext(ctx, "Connecting to Milvus failed", "attempt", c.failures, "error", err)
+		return nil, c.lastErr
+	}
+	if c.failures > 0 || c.lastErr != nil {
+		slog.InfoContext(ctx, "Reconnected to Milvus", "failed_attempts", c.failures)
+	}
+	c.client, c.lastErr, c.failures = sdk, nil, 0
+	milvusConnections.Inc()
+	return sdk, nil
+}
+
+// drop closes the slot's client after it failed with err, unless it was
+// already replaced. The next call may reconnect at once.
+func (c *milvusConn) drop(ctx context.Context, sdk client.Client, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client != sdk {
+		return
+	}
+	sdk.Close()
+	c.client, c.lastErr, c.retryAt = nil, err, time.Time{}
+	milvusConnections.Dec()
+	slog.WarnContext(ctx, "Milvus connection lost", "error", err)
+}
+
+// milvusConnectionError reports whether err means the connection, rather
+// than the request, failed.
+func milvusConnectionError(err error) bool {
+	return status.Code(err) == codes.Unavailable || errors.Is(err, client.ErrClientNotReady)
+}
+
+// acquire returns the next connected slot in round-robin order.
+func (p *milvusPool) acquire(ctx context.Context) (*milvusConn, client.Client, error) {
+	if p.closed.Load() {
+		return nil, nil, errors.New("the Milvus connection pool is closed")
+	}
+	start := p.next.Add(1)
+	var lastErr error
+	for i := range p.conns {
+		conn := p.conns[(start+uint64(i))%uint64(len(p.conns))]
+		sdk, err := conn.connect(ctx, p.dial)
+		if sdk != nil {
+			return conn, sdk, nil
+		}
+		lastErr = err
+	}
+	return nil, nil, fmt.Errorf("no Milvus connection available: %w", lastErr)
+}
+
+// call runs fn on a pooled connection. If the connection fails, it is
+// dropped and fn runs once more on another, or a re-dialed, connection.
+func (p *milvusPool) call(ctx context.Context, fn func(sdk client.Client) error) error {
+	for attempt := 1; ; attempt++ {
+		conn, sdk, err := p.acquire(ctx)
+		if err != nil {
+			return err
+		}
+		err = fn(sdk)
+		if err == nil || !milvusConnectionError(err) {
+			return err
+		}
+		conn.drop(ctx, sdk, err)
+		if attempt == 2 || ctx.Err() != nil {
+			return err
+		}
+	}
+}
+
+// checkHealth asks each connection for Milvus' health, dropping those that
+// fail, and re-dials disconnected slots whose backoff has passed.
+func (p *milvusPool) checkHealth(ctx context.Context) {
+	for _, conn := range p.conns {
+		sdk, err := conn.connect(ctx, p.dial)
+		if err != nil {
+			continue
+		}
+		checkCtx, cancel := context.WithTimeout(ctx, milvusHealthTimeout)
+		_, err = sdk.CheckHealth(checkCtx)
+		cancel()
+		if err != nil {
+			conn.drop(ctx, sdk, err)
+		}
+	}
+}
+
+// Close stops the health check loop and closes every connection.
+func (p *milvusPool) Close() error {
+	if p.closed.Swap(true) {
+		return nil
+	}
+	close(p.stop)
+	<-p.stopped
+	for _, conn := range p.conns {
+		conn.mu.Lock()
+		if conn.client != nil {
+			conn.client.Close()
+			conn.client = nil
+			milvusConnections.Dec()
+		}
+		conn.mu.Unlock()
+	}
+	return nil
+}
+
+func (p *milvusPool) CheckHealth(ctx context.Context) (result *entity.MilvusState, err error) {
+	err = p.call(ctx, func(sdk client.Client) error {
+		result, err = sdk.CheckHealth(ctx)
+		return err
+	})
+	return result, err
+}
+
+func (p *milvusPool) ListCollections(ctx context.Context, opts ...client.ListCollectionOption) (collections []*entity.Collection, err error) {
+	err = p.call(ctx, func(sdk client.Client) error {
+		collections, err = sdk.ListCollections(ctx, opts...)
+		return err
+	})
+	return collections, err
+}
+
+func (p *milvusPool) CreateCollection(ctx context.Context, schema *entity.Schema, shardsNum int32, opts ...client.CreateCollectionOption) error {
+	return p.call(ctx, func(sdk client.Client) error {
+		return sdk.CreateCollection(ctx, schema, shardsNum, opts...)
+	})
+}
+
+func (p *milvusPool) DescribeCollection(ctx context.Context, collName string) (coll *entity.Collection, err error) {
+	err = p.call(ctx, func(sdk client.Client) error {
+		coll, err = sdk.DescribeCollection(ctx, collName)
+		return err
+	})
+	return coll, err
+}
+
+func (p *milvusPool) DropCollection(ctx context.Context, collName string, opts ...client.DropCollectionOption) error {
+	return p.call(ctx, func(sdk client.Client) error {
+		return sdk.DropCollection(ctx, collName, opts...)
+	})
+}
+
+func (p *milvusPool) RenameCollection(ctx context.Context, collName, newName string) error {
+	return p.call(ctx, func(sdk client.Client) error {
+		return sdk.RenameCollection(ctx, collName, newName)
+	})
+}
+
+func (p *milvusPool) CreateAlias(ctx context.Context, collName string, alias string) error {
+	return p.call(ctx, func(sdk client.Client) error {
+		return sdk.CreateAlias(ctx, collName, alias)
+	})
+}
+
+func (p *milvusPool) AlterAlias(ctx context.Context, collName string, alias string) error {
+	return p.call(ctx, func(sdk client.Client) error {
+		return sdk.AlterAlias(ctx, collName, alias)
+	})
+}
+
+func (p *milvusPool) GetCollectionStatistics(ctx context.Context, collName string) (stats map[string]string, err error) {
+	err = p.call(ctx, func(sdk client.Client) error {
+		stats, err = sdk.GetCollectionStatistics(ctx, collName)
+		return err
+	})
+	return stats, err
+}
+
+func (p *milvusPool) LoadCollection(ctx context.Context, collName string, async bool, opts ...client.LoadCollectionOption) error {
+	return p.call(ctx, func(sdk client.Client) error {
+		return sdk.LoadCollection(ctx, collName, async, opts...)
+	})
+}
+
+func (p *milvusPool) ReleaseCollection(ctx context.Context, collName string, opts ...client.ReleaseCollectionOption) error {
+	return p.call(ctx, func(sdk client.Client) error {
+		return sdk.ReleaseCollection(ctx, collName, opts...)
+	})
+}
+
+func (p *milvusPool) HasCollection(ctx context.Context, collName string) (has bool, err error) {
+	err = p.call(ctx, func(sdk client.Client) error {
+		has, err = sdk.HasCollection(ctx, collName)
+		return err
+	})
+	return has, err
+}
+
+func (p *milvusPool) GetLoadState(ctx context.Context, collName string, partitionNames []string) (state entity.LoadState, err error) {
+	err = p.call(ctx, func(sdk client.Client) error {
+		state, err = sdk.GetLoadState(ctx, collName, partitionNames)
+		return err
+	})
+	return state, err
+}
+
+func (p *milvusPool) CreateIndex(ctx context.Context, collName string, fieldName string, idx entity.Index, async bool, opts ...client.IndexOption) error {
+	return p.call(ctx, func(sdk client.Client) error {
+		return sdk.CreateIndex(ctx, collName, fieldName, idx, async, opts...)
+	})
+}
+
+func (p *milvusPool) DescribeIndex(ctx context.Context, collName string, fieldName string, opts ...client.IndexOption) (indexes []entity.Index, err error) {
+	err = p.call(ctx, func(sdk client.Client) error {
+		indexes, err = sdk.DescribeIndex(ctx, collName, fieldName, opts...)
+		return err
+	})
+	return indexes, err
+}
+
+func (p *milvusPool) DropIndex(ctx context.Context, collName string, fieldName string, opts ...client.IndexOption) error {
+	return p.call(ctx, func(sdk client.Client) error {
+		return sdk.DropIndex(ctx, collName, fieldName, opts...)
+	})
+}
+
+func (p *milvusPool) Insert(ctx context.Context, collName string, partitionName string, columns ...entity.Column) (ids entity.Column, err error) {
+	err = p.call(ctx, func(sdk client.Client) error {
+		ids, err = sdk.Insert(ctx, collName, partitionName, columns...)
+		return err
+	})
+	return ids, err
+}
+
+func (p *milvusPool) Flush(ctx context.Context, collName string, async bool, opts ...client.FlushOption) error {
+	return p.call(ctx, func(sdk client.Client) error {
+		return sdk.Flush(ctx, collName, async, opts...)
+	})
+}
+
+func (p *milvusPool) Delete(ctx context.Context, collName string, partitionName string, expr string) error {
+	return p.call(ctx, func(sdk client.Client) error {
+		return sdk.Delete(ctx, collName, partitionName, expr)
+	})
+}
+
+func (p *milvusPool) DeleteByPks(ctx context.Context, collName string, partitionName string, ids entity.Column) error {
+	return p.call(ctx, func(sdk client.Client) error {
+		return sdk.DeleteByPks(ctx, collName, partitionName, ids)
+	})
+}
+
+func (p *milvusPool) Search(ctx context.Context, collName string, partitions []string, expr string, outputFields []string, vectors []entity.Vector, vectorField string, metricType entity.MetricType, topK int, sp entity.SearchParam, opts ...client.SearchQueryOptionFunc) (results []client.SearchResult, err error) {
+	err = p.call(ctx, func(sdk client.Client) error {
+		results, err = sdk.Search(ctx, collName, partitions, expr, outputFields, vectors, vectorField, metricType, topK, sp, opts...)
+		return err
+	})
+	return results, err
+}
+
+func (p *milvusPool) HybridSearch(ctx context.Context, collName string, partitions []string, limit int, outputFields []string, reranker client.Reranker, subRequests []*client.ANNSearchRequest, opts ...client.SearchQueryOptionFunc) (results []client.SearchResult, err error) {
+	err = p.call(ctx, func(sdk client.Client) error {
+		results, err = sdk.HybridSearch(ctx, collName, partitions, limit, outputFields, reranker, subRequests, opts...)
+		return err
+	})
+	return results, err
+}
+
+func (p *milvusPool) Query(ctx context.Context, collectionName string, partitionNames []string, expr string, outputFields []string, opts ...client.SearchQueryOptionFunc) (results client.ResultSet, err error) {
+	err = p.call(ctx, func(sdk client.Client) error {
+		results, err = sdk.Query(ctx, collectionName, partitionNames, expr, outputFields, opts...)
+		return err
+	})
+	return results, err
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/milvus-io/milvus-sdk-go/v2/client"
+	"github.com/milvus-io/milvus-sdk-go/v2/entity"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// poolFakeSDK is one fake Milvus connection. It answers searches until its
+// server goes down.
+type poolFakeSDK struct {
+	client.Client
+	server   *poolFakeServer
+	searches int
+	closed   bool
+}
+
+func (f *poolFakeSDK) Search(ctx context.Context, collName string, partitions []string, expr string, outputFields []string, vectors []entity.Vector, vectorField string, metricType entity.MetricType, topK int, sp entity.SearchParam, opts ...client.SearchQueryOptionFunc) ([]client.SearchResult, error) {
+	if f.server.down || f.closed {
+		return nil, status.Error(codes.Unavailable, "connection refused")
+	}
+	f.searches++
+	return nil, nil
+}
+
+func (f *poolFakeSDK) HasCollection(ctx context.Context, collName string) (bool, error) {
+	return false, errors.New("collection name is invalid")
+}
+
+func (f *poolFakeSDK) CheckHealth(ctx context.Context) (*entity.MilvusState, error) {
+	if f.server.down {
+		return nil, status.Error(codes.Unavailable, "connection refused")
+	}
+	return &entity.MilvusState{IsHealthy: true}, nil
+}
+
+func (f *poolFakeSDK) Close() error {
+	f.closed = true
+	return nil
+}
+
+// poolFakeServer hands out poolFakeSDKs. With refuseDial, dialing fails as
+// it does while Milvus is down.
+type poolFakeServer struct {
+	down       bool
+	refuseDial bool
+	dialed     []*poolFakeSDK
+}
+
+func (s *poolFakeServer) dial(ctx context.Context) (client.Client, error) {
+	if s.refuseDial {
+		return nil, errors.New("connection refused")
+	}
+	sdk := &poolFakeSDK{server: s}
+	s.dialed = append(s.dialed, sdk)
+	return sdk, nil
+}
+
+func TestMilvusPoolSpreadsCalls(t *testing.T) {
+	ctx := context.Background()
+	server := &poolFakeServer{}
+	pool, err := newMilvusPool(ctx, server.dial, 3, 0)
+	if err != nil {
+		t.Fatalf("newMilvusPool: %v", err)
+	}
+	defer pool.Close()
+	for i := 0; i < 6; i++ {
+		if _, err := pool.Search(ctx, "docs", nil, "", nil, nil, "embedding", entity.COSINE, 3, nil); err != nil {
+			t.Fatalf("Search: %v", err)
+		}
+	}
+	if len(server.dialed) != 3 {
+		t.Fatalf("expected 3 connections, got %d", len(server.dialed))
+	}
+	for i, sdk := range server.dialed {
+		if sdk.searches != 2 {
+			t.Fatalf("expected connection %d to serve 2 searches, got %d", i, sdk.searches)
+		}
+	}
+
+	// Errors about the request, not the connection, keep the connection.
+	if _, err := pool.HasCollection(ctx, ""); err == nil || len(server.dialed) != 3 {
+		t.Fatalf("expected the request error without reconnecting, got %v after %d dials", err, len(server.dialed))
+	}
+
+	pool.Close()
+	if !server.dialed[0].closed {
+		t.Fatal("expected Close to close the connections")
+	}
+	if _, err := pool.Search(ctx, "docs", nil, "", nil, nil, "embedding", entity.COSINE, 3, nil); err == nil {
+		t.Fatal("expected a closed pool to fail")
+	}
+}
+
+func TestMilvusPoolReconnects(t *testing.T) {
+	ctx := context.Background()
+	server := &poolFakeServer{}
+	pool, err := newMilvusPool(ctx, server.dial, 1, 0)
+	if err != nil {
+		t.Fatalf("newMilvusPool: %v", err)
+	}
+	defer pool.Close()
+	search := func() error {
+		_, err := pool.Search(ctx, "docs", nil, "", nil, nil, "embedding", entity.COSINE, 3, nil)
+		return err
+	}
+
+	// A restarted Milvus breaks the old connection; the call is retried on
+	// a new one.
+	server.dialed[0].closed = true
+	if err := search(); err != nil {
+		t.Fatalf("expected the search to succeed on a new connection, got %v", err)
+	}
+	if len(server.dialed) != 2 || server.dialed[1].searches != 1 {
+		t.Fatalf("expected one reconnect, got %d dials", len(server.dialed))
+	}
+
+	// While Milvus is down, reconnects back off instead of dialing on every
+	// call.
+	server.down, server.refuseDial = true, true
+	if err := search(); err == nil || pool.conns[0].failures != 1 {
+		t.Fatalf("expected the search and one reconnect to fail, got %v with %d failures", err, pool.conns[0].failures)
+	}
+	if err := search(); err == nil || pool.conns[0].failures != 1 {
+		t.Fatalf("expected no dial during the backoff, got %v with %d failures", err, pool.conns[0].failures)
+	}
+
+	// Once Milvus is back, the next call after the backoff reconnects.
+	server.down, server.refuseDial = false, false
+	pool.conns[0].retryAt = pool.conns[0].retryAt.AddDate(-1, 0, 0)
+	if err := search(); err != nil {
+		t.Fatalf("expected a reconnect once Milvus is back, got %v", err)
+	}
+	if pool.conns[0].failures != 0 {
+		t.Fatalf("expected the failures to reset, got %d", pool.conns[0].failures)
+	}
+}
+
+func TestMilvusPoolHealthCheck(t *testing.T) {
+	ctx := context.Background()
+	server := &poolFakeServer{}
+	pool, err := newMilvusPool(ctx, server.dial, 2, 0)
+	if err != nil {
+		t.Fatalf("newMilvusPool: %v", err)
+	}
+	defer pool.Close()
+
+	server.down = true
+	pool.checkHealth(ctx)
+	if !server.dialed[0].closed || !server.dialed[1].closed {
+		t.Fatal("expected unhealthy connections to be dropped")
+	}
+
+	server.down = false
+	pool.checkHealth(ctx)
+	if len(server.dialed) != 4 || pool.conns[0].client == nil || pool.conns[1].client == nil {
+		t.Fatalf("expected the health check to reconnect both slots, got %d dials", len(server.dialed))
+	}
+
+	if _, err := newMilvusPool(ctx, (&poolFakeServer{refuseDial: true}).dial, 2, 0); err == nil {
+		t.Fatal("expected the pool to fail when the first connection fails")
+	}
+}
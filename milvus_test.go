@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/milvus-io/milvus-sdk-go/v2/entity"
+)
+
+func TestMilvusCheckDimension(t *testing.T) {
+	sdk := &fakeMilvusSDK{collections: []*entity.Collection{
+		{Name: "rag_documents", Schema: &entity.Schema{Fields: []*entity.Field{
+			{Name: "id", DataType: entity.FieldTypeInt64, PrimaryKey: true},
+			{Name: "embedding", DataType: entity.FieldTypeFloatVector, TypeParams: map[string]string{"dim": "1536"}},
+		}}},
+	}}
+	ctx := context.Background()
+
+	store := &MilvusClientImpl{client: sdk, collectionName: "rag_documents", dimension: 1536}
+	if err := store.CheckDimension(ctx); err != nil {
+		t.Fatalf("expected matching dimensions to pass, got %v", err)
+	}
+
+	store.dimension = 1024
+	err := store.CheckDimension(ctx)
+	if err == nil || !strings.Contains(err.Error(), "stores 1536-dimensional embeddings but the configured embedder produces 1024") {
+		t.Fatalf("expected a dimension mismatch error, got %v", err)
+	}
+
+	store.collectionName = "new_documents"
+	if err := store.CheckDimension(ctx); err != nil {
+		t.Fatalf("expected a missing collection to pass, got %v", err)
+	}
+}
+
+func TestMilvusCheckMetric(t *testing.T) {
+	sdk := &fakeMilvusSDK{collections: []*entity.Collection{{Name: "rag_documents"}}, metric: "L2"}
+	ctx := context.Background()
+
+	store := &MilvusClientImpl{client: sdk, collectionName: "rag_documents"}
+	if err := store.CheckMetric(ctx); err != nil {
+		t.Fatalf("CheckMetric returned error: %v", err)
+	}
+	if store.metricType() != entity.L2 {
+		t.Fatalf("expected the index's L2 metric to be adopted, got %s", store.metricType())
+	}
+
+	store.metric = entity.IP
+	if err := store.CheckMetric(ctx); err == nil || !strings.Contains(err.Error(), "indexed with the L2 metric but MILVUS_METRIC is IP") {
+		t.Fatalf("expected a metric mismatch error, got %v", err)
+	}
+
+	store = &MilvusClientImpl{client: sdk, collectionName: "new_documents"}
+	if err := store.CheckMetric(ctx); err != nil || store.metricType() != entity.COSINE {
+		t.Fatalf("expected new collections to default to COSINE, got %s (%v)", store.metricType(), err)
+	}
+}
+
+func TestMilvusSimilarity(t *testing.T) {
+	tests := []struct {
+		metric entity.MetricType
+		score  float32
+		want   float32
+	}{
+		{entity.COSINE, 0.8, 0.8},
+		{entity.IP, 0.8, 0.8},
+		{entity.COSINE, -0.3, 0},
+		{entity.L2, 0, 1},     // identical unit vectors
+		{entity.L2, 0.4, 0.8}, // cos = 1 - d/2
+		{entity.L2, 4, 0},     // opposite unit vectors
+	}
+	for _, tt := range tests {
+		if got := milvusSimilarity(tt.metric, tt.score); math.Abs(float64(got-tt.want)) > 1e-6 {
+			t.Errorf("milvusSimilarity(%s, %v) = %v, want %v", tt.metric, tt.score, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+)
+
+// mmrOverfetch is how many candidates per requested document MMR selects from.
+const mmrOverfetch = 4
+
+// mmrEmbedder compares candidate texts with each other. Stores return
+// documents without their vectors, so MMR measures redundancy on lexical
+// hashing vectors, which is what near-duplicate chunks have in common anyway.
+var mmrEmbedder = NewHashingEmbedder(1024)
+
+// WithMMR selects documents by Maximal Marginal Relevance: an over-fetched
+// candidate set is narrowed one document at a time, each time picking the one
+// that best balances relevance to the query against similarity to the
+// documents already picked. lambda weighs the two, from 1 (relevance only,
+// plain top-k) towards 0 (diversity only); 0.5 is a good default.
+func WithMMR(lambda float64) RetrieveOption {
+	return func(c *retrieveConfig) {
+		c.mmrLambda = lambda
+	}
+}
+
+// selectMMR picks up to limit documents from candidates by MMR. When ranked is
+// set the candidates are in reranker order and relevance is taken from their
+// position instead of their similarity scores.
+func selectMMR(ctx context.Context, candidates []Document, limit int, lambda float64, ranked bool) []Document {
+	if len(candidates) <= limit {
+		return candidates
+	}
+
+	relevance := make([]float64, len(candidates))
+	texts := make([]string, len(candidates))
+	for i, doc := range candidates {
+		relevance[i] = float64(doc.Similarity)
+		if ranked {
+			relevance[i] = 1 - float64(i)/float64(len(candidates))
+		}
+		texts[i] = doc.Text
+	}
+	vectors, _ := mmrEmbedder.Embed(ctx, texts)
+
+	selected := make([]int, 0, limit)
+	used := make([]bool, len(candidates))
+	for len(selected) < limit {
+		best, bestScore := -1, 0.0
+		for i := range candidates {
+			if used[i] {
+				continue
+			}
+			var redundancy float64
+			for _, j := range selected {
+				if sim := float64(cosineSimilarity(vectors[i], vectors[j])); sim > redundancy {
+					redundancy = sim
+				}
+			}
+			score := lambda*relevance[i] - (1-lambda)*redundancy
+			if best < 0 || score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		used[best] = true
+		selected = append(selected, best)
+	}
+
+	docs := make([]Document, len(selected))
+	for i, idx := range selected {
+		docs[i] = candidates[idx]
+	}
+	slog.DebugContext(ctx, "Selected diverse documents", "candidates", len(candidates), "selected", len(docs), "lambda", lambda)
+	return docs
+}
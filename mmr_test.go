@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRetrieveWithMMRSkipsNearDuplicates(t *testing.T) {
+	store := NewMemoryStore(NewHashingEmbedder(256))
+	store.InsertDocuments(context.Background(),
+		[]string{
+			"go channels connect goroutines",
+			"go channels connect goroutines safely",
+			"go channels connect concurrent goroutines",
+			"the go scheduler multiplexes goroutines onto threads",
+		},
+		[]string{"a", "b", "c", "scheduler"}, nil)
+	engine := NewRAGEngine(&dummyOpenAI{}, store)
+	query := "go channels goroutines"
+
+	plain := engine.Retrieve(context.Background(), query, 2)
+	if plain[0].Source == "scheduler" || plain[1].Source == "scheduler" {
+		t.Fatalf("expected plain retrieval to return two channel chunks, got %s and %s", plain[0].Source, plain[1].Source)
+	}
+
+	diverse := engine.Retrieve(context.Background(), query, 2, WithMMR(0.5))
+	if len(diverse) != 2 {
+		t.Fatalf("expected 2 documents, got %d", len(diverse))
+	}
+	if diverse[0].Source != plain[0].Source || diverse[1].Source != "scheduler" {
+		t.Fatalf("expected the top match followed by the scheduler chunk, got %s and %s", diverse[0].Source, diverse[1].Source)
+	}
+}
+
+func TestSelectMMRWithFullRelevanceWeightKeepsOrder(t *testing.T) {
+	docs := []Document{
+		{Text: "alpha beta", Similarity: 0.9},
+		{Text: "alpha beta", Similarity: 0.8},
+		{Text: "gamma delta", Similarity: 0.7},
+	}
+	selected := selectMMR(context.Background(), docs, 2, 1, false)
+	if selected[0].Similarity != 0.9 || selected[1].Similarity != 0.8 {
+		t.Fatalf("expected top-k order with lambda 1, got %+v", selected)
+	}
+}
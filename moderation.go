@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// ErrContentFlagged is matched (via errors.Is) by every *PolicyError.
+var ErrContentFlagged = errors.New("content violates the usage policy")
+
+// PolicyError is returned instead of an answer when moderation flags the
+// question or the generated answer.
+type PolicyError struct {
+	Stage      string   // "query" or "answer"
+	Categories []string // the policy categories that were flagged, e.g. "harassment"
+}
+
+func (e *PolicyError) Error() string {
+	if len(e.Categories) == 0 {
+		return fmt.Sprintf("the %s was flagged by moderation", e.Stage)
+	}
+	return fmt.Sprintf("the %s was flagged by moderation (%s)", e.Stage, strings.Join(e.Categories, ", "))
+}
+
+func (e *PolicyError) Is(target error) bool {
+	return target == ErrContentFlagged
+}
+
+// ModerationResult is a Moderator's verdict on a text.
+type ModerationResult struct {
+	Flagged    bool
+	Categories []string // flagged categories, sorted
+}
+
+// Moderator screens text against a content policy.
+type Moderator interface {
+	Moderate(ctx context.Context, text string) (ModerationResult, error)
+}
+
+// WithModerator screens every question before it is answered and every
+// generated answer before it is returned. Flagged content yields a
+// *PolicyError instead of an answer. If the moderator fails, the query fails
+// too, so an outage never lets content through unscreened.
+func WithModerator(moderator Moderator) EngineOption {
+	return func(r *RAGEngine) {
+		r.moderator = moderator
+	}
+}
+
+// moderate returns a *PolicyError if the moderator flags text.
+func (r *RAGEngine) moderate(ctx context.Context, stage, text string) error {
+	ctx, span := tracer.Start(ctx, "rag.moderate")
+	result, err := r.moderator.Moderate(ctx, text)
+	endSpan(span, err)
+	if err != nil {
+		errorsTotal.WithLabelValues("moderation").Inc()
+		return fmt.Errorf("moderating the %s: %w", stage, err)
+	}
+	if !result.Flagged {
+		return nil
+	}
+	moderationFlagged.WithLabelValues(stage).Inc()
+	slog.WarnContext(ctx, "Moderation flagged content", "stage", stage, "categories", result.Categories)
+	return &PolicyError{Stage: stage, Categories: result.Categories}
+}
+
+// OpenAIModerator uses the OpenAI moderation endpoint, which is free to call
+// with any OpenAI API key.
+type OpenAIModerator struct {
+	client *openai.Client
+	model  string
+}
+
+// NewOpenAIModerator returns a moderator using model, or the endpoint's
+// default model if model is empty.
+func NewOpenAIModerator(client *openai.Client, model string) *OpenAIModerator {
+	return &OpenAIModerator{client: client, model: model}
+}
+
+func (m *OpenAIModerator) Moderate(ctx context.Context, text string) (ModerationResult, error) {
+	resp, err := m.client.Moderations(ctx, openai.ModerationRequest{Input: text, Model: m.model})
+	if err != nil {
+		return ModerationResult{}, err
+	}
+	var result ModerationResult
+	for _, r := range resp.Results {
+		result.Flagged = result.Flagged || r.Flagged
+		result.Categories = append(result.Categories, flaggedCategories(r.Categories)...)
+	}
+	sort.Strings(result.Categories)
+	return result, nil
+}
+
+// flaggedCategories returns the API names of the categories set in c.
+func flaggedCategories(c openai.ResultCategories) []string {
+	data, _ := json.Marshal(c)
+	var set map[string]bool
+	json.Unmarshal(data, &set)
+	var names []string
+	for name, flagged := range set {
+		if flagged {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// wordModerator flags text containing any of its words.
+type wordModerator struct {
+	words   []string
+	err     error
+	checked []string
+}
+
+func (m *wordModerator) Moderate(ctx context.Context, text string) (ModerationResult, error) {
+	m.checked = append(m.checked, text)
+	if m.err != nil {
+		return ModerationResult{}, m.err
+	}
+	for _, word := range m.words {
+		if strings.Contains(text, word) {
+			return ModerationResult{Flagged: true, Categories: []string{"harassment"}}, nil
+		}
+	}
+	return ModerationResult{}, nil
+}
+
+func TestModerationScreensQueriesAndAnswers(t *testing.T) {
+	ctx := context.Background()
+	docs := []Document{{Text: "Go was made at Google.", Source: "go", Similarity: 0.9}}
+
+	moderator := &wordModerator{words: []string{"insult"}}
+	oa := &dummyOpenAI{}
+	engine := NewRAGEngine(oa, &dummyMilvus{}, WithModerator(moderator))
+	_, err := engine.GenerateResponse(ctx, "write an insult", docs, "gpt-test")
+	var policyErr *PolicyError
+	if !errors.As(err, &policyErr) || policyErr.Stage != "query" || !errors.Is(err, ErrContentFlagged) {
+		t.Fatalf("expected a query policy error, got %v", err)
+	}
+	if oa.lastMessages != nil {
+		t.Fatal("expected a flagged query not to reach the model")
+	}
+
+	answer, err := engine.GenerateResponse(ctx, "Where was Go made?", docs, "gpt-test")
+	if err != nil || answer.Text != "stubbed" {
+		t.Fatalf("GenerateResponse = %+v, %v", answer, err)
+	}
+	if !slices.Equal(moderator.checked[1:], []string{"Where was Go made?", "stubbed"}) {
+		t.Fatalf("expected the question and answer to be screened, got %q", moderator.checked)
+	}
+
+	engine = NewRAGEngine(&scriptedOpenAI{reply: "Here is an insult."}, &dummyMilvus{}, WithModerator(moderator))
+	answer, err = engine.GenerateResponse(ctx, "Where was Go made?", docs, "gpt-test")
+	if !errors.As(err, &policyErr) || policyErr.Stage != "answer" || answer.Text != "" {
+		t.Fatalf("expected an answer policy error, got %+v, %v", answer, err)
+	}
+
+	engine = NewRAGEngine(&dummyOpenAI{}, &dummyMilvus{}, WithModerator(&wordModerator{err: errors.New("unavailable")}))
+	if _, err := engine.GenerateResponse(ctx, "Where was Go made?", docs, "gpt-test"); err == nil || errors.Is(err, ErrContentFlagged) {
+		t.Fatalf("expected a moderation failure to fail the query, got %v", err)
+	}
+}
+
+func TestServerReportsPolicyErrors(t *testing.T) {
+	server, _ := newTestServer()
+	server.engine.moderator = &wordModerator{words: []string{"insult"}}
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"question":"write an insult"}`)))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d: %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Stage      string   `json:"stage"`
+		Categories []string `json:"categories"`
+	}
+	json.NewDecoder(rec.Body).Decode(&body)
+	if body.Stage != "query" || !slices.Equal(body.Categories, []string{"harassment"}) {
+		t.Fatalf("unexpected body %+v", body)
+	}
+}
+
+func TestOpenAIModerator(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.ModerationRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if r.URL.Path != "/moderations" || req.Model != openai.ModerationOmniLatest {
+			t.Errorf("unexpected request %s for model %q", r.URL.Path, req.Model)
+		}
+		flagged := strings.Contains(req.Input, "threat")
+		json.NewEncoder(w).Encode(map[string]any{"results": []map[string]any{{
+			"flagged":    flagged,
+			"categories": map[string]bool{"violence": flagged, "harassment/threatening": flagged, "sexual": false},
+		}}})
+	}))
+	defer server.Close()
+	config := openai.DefaultConfig("key")
+	config.BaseURL = server.URL
+	moderator := NewOpenAIModerator(openai.NewClientWithConfig(config), openai.ModerationOmniLatest)
+
+	result, err := moderator.Moderate(context.Background(), "a threat")
+	if err != nil || !result.Flagged || !slices.Equal(result.Categories, []string{"harassment/threatening", "violence"}) {
+		t.Fatalf("Moderate = %+v, %v", result, err)
+	}
+	if result, err := moderator.Moderate(context.Background(), "hello"); err != nil || result.Flagged || len(result.Categories) != 0 {
+		t.Fatalf("Moderate = %+v, %v", result, err)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// collectionField is the metadata key set on search results of a collection
+// store to the collection each came from.
+const collectionField = "collection"
+
+// Collection is one corpus searched by a collection store, with its own
+// store, and thus its own chunking and embedding settings.
+type Collection struct {
+	Name  string
+	Store VectorStore
+	// Weight scales the similarity of the collection's results before they
+	// are merged; 0 means 1. Above 1 favours the collection.
+	Weight float64
+}
+
+// collectionStore searches several collections as one store.
+type collectionStore struct {
+	collections []Collection
+}
+
+// collectionDedupStore is a collectionStore whose first collection supports
+// incremental re-ingestion.
+type collectionDedupStore struct {
+	*collectionStore
+	DedupStore
+}
+
+// NewCollectionStore returns a store that searches every collection at once,
+// e.g. "docs", "tickets", and "wiki", and merges the results by similarity
+// scaled by the collection weights. Results carry their collection in the
+// "collection" metadata field. Writes go to the first collection; ingest into
+// the others through engines of their own stores.
+func NewCollectionStore(collections ...Collection) (VectorStore, error) {
+	if len(collections) == 0 {
+		return nil, errors.New("no collections")
+	}
+	seen := make(map[string]bool)
+	for _, c := range collections {
+		if c.Name == "" || seen[c.Name] {
+			return nil, fmt.Errorf("invalid or duplicate collection name %q", c.Name)
+		}
+		if c.Weight < 0 {
+			return nil, fmt.Errorf("invalid weight %g for collection %s (expected a positive number)", c.Weight, c.Name)
+		}
+		seen[c.Name] = true
+	}
+	s := &collectionStore{collections: slices.Clone(collections)}
+	if dedup, ok := collections[0].Store.(DedupStore); ok {
+		return &collectionDedupStore{collectionStore: s, DedupStore: dedup}, nil
+	}
+	return s, nil
+}
+
+type collectionWeightsKey struct{}
+
+// WithCollectionWeights restricts retrieval from a collection store to the
+// named collections, weighting their results by the given weights instead
+// of the configured ones; a weight of 0 keeps the configured one.
+func WithCollectionWeights(weights map[string]float64) RetrieveOption {
+	return func(c *retrieveConfig) {
+		c.collections = maps.Clone(weights)
+	}
+}
+
+// parseCollectionWeights parses a comma-separated list of collections with
+// optional weights, such as "docs:1,tickets:0.5,wiki"; a missing weight is 1.
+// It returns nil for an empty list.
+func parseCollectionWeights(list string) (map[string]float64, error) {
+	var weights map[string]float64
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, raw, hasWeight := strings.Cut(entry, ":")
+		if name = strings.TrimSpace(name); name == "" {
+			return nil, fmt.Errorf("missing collection name in %q", entry)
+		}
+		weight := 1.0
+		if hasWeight {
+			w, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+			if err != nil || w <= 0 {
+				return nil, fmt.Errorf("invalid weight %q for collection %s (expected a positive number)", raw, name)
+			}
+			weight = w
+		}
+		if weights == nil {
+			weights = make(map[string]float64)
+		}
+		weights[name] = weight
+	}
+	return weights, nil
+}
+
+func (c *collectionStore) InsertDocuments(ctx context.Context, texts, sources []string, metadata []map[string]any) bool {
+	return c.collections[0].Store.InsertDocuments(ctx, texts, sources, metadata)
+}
+
+func (c *collectionStore) DeleteBySource(ctx context.Context, source string) error {
+	return c.collections[0].Store.DeleteBySource(ctx, source)
+}
+
+func (c *collectionStore) UpdateDocument(ctx context.Context, source string, texts []string, metadata []map[string]any) error {
+	return c.collections[0].Store.UpdateDocument(ctx, source, texts, metadata)
+}
+
+// SearchSimilar searches the collections concurrently for limit documents
+// each and returns the best limit by weighted similarity. A failed
+// collection is left out; only when all fail is the search reported as
+// failed.
+func (c *collectionStore) SearchSimilar(ctx context.Context, query string, limit int, filter Filter) []Document {
+	requested, _ := ctx.Value(collectionWeightsKey{}).(map[string]float64)
+	var searched []Collection
+	for _, coll := range c.collections {
+		if requested != nil {
+			weight, ok := requested[coll.Name]
+			if !ok {
+				continue
+			}
+			if weight > 0 {
+				coll.Weight = weight
+			}
+		}
+		searched = append(searched, coll)
+	}
+
+	results := make([][]Document, len(searched))
+	failures := make([]searchFailure, len(searched))
+	var wg sync.WaitGroup
+	for i, coll := range searched {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = coll.Store.SearchSimilar(withSearchFailure(ctx, &failures[i]), query, limit, filter)
+		}()
+	}
+	wg.Wait()
+
+	var merged []Document
+	failed := 0
+	for i, coll := range searched {
+		if err := failures[i].err; err != nil {
+			failed++
+			slog.WarnContext(ctx, "Searching a collection failed, leaving it out", "collection", coll.Name, "error", err)
+			if failed == len(searched) {
+				reportSearchFailure(ctx, err)
+			}
+			continue
+		}
+		weight := coll.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		for _, doc := range results[i] {
+			doc.Similarity = min(doc.Similarity*float32(weight), 1)
+			doc.Metadata = maps.Clone(doc.Metadata)
+			if doc.Metadata == nil {
+				doc.Metadata = map[string]any{}
+			}
+			doc.Metadata[collectionField] = coll.Name
+			merged = append(merged, doc)
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].Similarity > merged[j].Similarity })
+	if len(merged) > limit {
+		merged = merged[:limit]
+	}
+	return merged
+}
+
+// Ping checks every collection whose store can be checked.
+func (c *collectionStore) Ping(ctx context.Context) error {
+	for _, coll := range c.collections {
+		if p, ok := coll.Store.(Pinger); ok {
+			if err := p.Ping(ctx); err != nil {
+				return fmt.Errorf("collection %s: %w", coll.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// names returns the names of the collections, in order.
+func (c *collectionStore) names() []string {
+	names := make([]string, len(c.collections))
+	for i, coll := range c.collections {
+		names[i] = coll.Name
+	}
+	return names
+}
+
+// Collections returns the collections the engine searches, or nil if its
+// store is not a collection store.
+func (r *RAGEngine) Collections() []string {
+	store := r.store
+	for {
+		switch s := store.(type) {
+		case *collectionStore:
+			return s.names()
+		case *collectionDedupStore:
+			return s.names()
+		case *tenantStore:
+			store = s.inner
+		case *tenantDedupStore:
+			store = s.inner
+		case *aclStore:
+			store = s.VectorStore
+		case *aclDedupStore:
+			store = s.VectorStore
+		default:
+			return nil
+		}
+	}
+}
+
+// checkCollectionWeights reports an error unless every collection in weights
+// is searched by the engine and has a positive weight.
+func (r *RAGEngine) checkCollectionWeights(weights map[string]float64) error {
+	names := r.Collections()
+	if names == nil {
+		return errors.New("collections need a multi-collection store (SEARCH_COLLECTIONS)")
+	}
+	for name, weight := range weights {
+		if !slices.Contains(names, name) {
+			return fmt.Errorf("unknown collection %q (expected one of %s)", name, strings.Join(names, ", "))
+		}
+		if weight <= 0 {
+			return fmt.Errorf("invalid weight %g for collection %s (expected a positive number)", weight, name)
+		}
+	}
+	return nil
+}
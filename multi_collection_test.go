@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newCollections returns a docs and a tickets collection, embedded with
+// different settings, holding the same text.
+func newCollections(t *testing.T) (*MemoryStore, *MemoryStore, *outageEmbedder) {
+	t.Helper()
+	docs := NewMemoryStore(NewHashingEmbedder(256))
+	ticketEmbedder := &outageEmbedder{Embedder: NewHashingEmbedder(64)}
+	tickets := NewMemoryStore(ticketEmbedder)
+	ctx := context.Background()
+	docs.InsertDocuments(ctx, []string{"Invoices are stored in Postgres."}, []string{"billing.md"}, nil)
+	tickets.InsertDocuments(ctx, []string{"Invoices are stored in Postgres."}, []string{"TICKET-12"}, nil)
+	return docs, tickets, ticketEmbedder
+}
+
+func TestCollectionStoreMergesByWeightedSimilarity(t *testing.T) {
+	docs, tickets, _ := newCollections(t)
+	store, err := NewCollectionStore(Collection{Name: "docs", Store: docs}, Collection{Name: "tickets", Store: tickets, Weight: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := NewRAGEngine(&dummyOpenAI{}, store)
+	results := engine.Retrieve(context.Background(), "Invoices are stored in Postgres.", 2)
+	if len(results) != 2 || results[0].Metadata[collectionField] != "docs" || results[1].Metadata[collectionField] != "tickets" {
+		t.Fatalf("expected docs before the down-weighted tickets, got %+v", results)
+	}
+	if results[1].Similarity > 0.51 {
+		t.Fatalf("expected the tickets similarity halved, got %v", results[1].Similarity)
+	}
+
+	results = engine.Retrieve(context.Background(), "Invoices are stored in Postgres.", 2, WithCollectionWeights(map[string]float64{"tickets": 2}))
+	if len(results) != 1 || results[0].Source != "TICKET-12" {
+		t.Fatalf("expected only the tickets collection, got %+v", results)
+	}
+
+	engine.store.InsertDocuments(context.Background(), []string{"Refunds take five days."}, []string{"refunds.md"}, nil)
+	if got := docs.SearchSimilar(context.Background(), "Refunds", 5, nil); len(got) != 2 {
+		t.Fatalf("expected writes to go to the first collection, got %d documents", len(got))
+	}
+}
+
+func TestCollectionStoreLeavesOutFailedCollections(t *testing.T) {
+	docs, tickets, ticketEmbedder := newCollections(t)
+	ticketEmbedder.down = true
+	store, _ := NewCollectionStore(Collection{Name: "docs", Store: docs}, Collection{Name: "tickets", Store: tickets})
+	var failure searchFailure
+	results := store.SearchSimilar(withSearchFailure(context.Background(), &failure), "invoices", 5, nil)
+	if len(results) != 1 || failure.err != nil {
+		t.Fatalf("expected the docs results without a failure, got %+v, %v", results, failure.err)
+	}
+
+	store, _ = NewCollectionStore(Collection{Name: "tickets", Store: tickets})
+	store.SearchSimilar(withSearchFailure(context.Background(), &failure), "invoices", 5, nil)
+	if failure.err == nil {
+		t.Fatal("expected a failure when every collection fails")
+	}
+}
+
+func TestServerValidatesCollections(t *testing.T) {
+	docs, tickets, _ := newCollections(t)
+	store, _ := NewCollectionStore(Collection{Name: "docs", Store: docs}, Collection{Name: "tickets", Store: tickets})
+	server := NewServer(NewRAGEngine(&scriptedOpenAI{reply: "Postgres [1]."}, store), "gpt-test")
+	for body, want := range map[string]int{
+		`{"question":"Where are invoices stored?","collections":{"tickets":1}}`: http.StatusOK,
+		`{"question":"Where are invoices stored?","collections":{"wiki":1}}`:    http.StatusBadRequest,
+		`{"question":"Where are invoices stored?","collections":{"docs":0}}`:    http.StatusBadRequest,
+	} {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(body)))
+		if rec.Code != want {
+			t.Errorf("%s: got %d %s, want %d", body, rec.Code, rec.Body, want)
+		}
+	}
+}
+
+func TestParseCollectionWeights(t *testing.T) {
+	weights, err := parseCollectionWeights(" docs:1, tickets:0.5,wiki ")
+	if err != nil || len(weights) != 3 || weights["tickets"] != 0.5 || weights["wiki"] != 1 {
+		t.Fatalf("unexpected weights %v, %v", weights, err)
+	}
+	if _, err := parseCollectionWeights("docs:-1"); err == nil {
+		t.Fatal("expected an error for a negative weight")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxQueryVariants caps the rewordings a single API request may ask for.
+const maxQueryVariants = 10
+
+// WithMultiQuery has model rewrite the query into variants differently
+// worded questions. The original query and every variant are searched, and
+// the results are merged, keeping each document once with its best score,
+// before reranking. This improves recall for vague or unusually worded
+// questions at the cost of one LLM call and variants extra searches; 3 to 5
+// variants work well.
+func WithMultiQuery(model string, variants int) RetrieveOption {
+	return func(c *retrieveConfig) {
+		c.multiQueryModel = model
+		c.multiQueryVariants = variants
+	}
+}
+
+// expandQuery asks the LLM for up to n reformulations of query. On failure it
+// returns none, so retrieval continues with the original query alone.
+func (r *RAGEngine) expandQuery(ctx context.Context, query, model string, n int) []string {
+	ctx, span := tracer.Start(ctx, "rag.expand_query", trace.WithAttributes(attribute.Int("rag.variants_requested", n)))
+	defer span.End()
+
+	prompt := fmt.Sprintf("Write %d different versions of the question below, to search a document collection for it. "+
+		"Use synonyms, spell out abbreviations, and vary between broader and more specific wording, "+
+		"but keep the meaning. Reply with one question per line and nothing else.\n\nQuestion: %s", n, query)
+	messages := []Message{
+		{Role: "system", Content: "You rewrite questions into alternative search queries."},
+		{Role: "user", Content: prompt},
+	}
+	response, err := r.llm.ChatCompletion(ctx, model, messages)
+	if err != nil {
+		endSpan(span, err)
+		slog.WarnContext(ctx, "Could not expand query, searching with it alone", "error", err)
+		return nil
+	}
+
+	seen := map[string]bool{strings.ToLower(query): true}
+	var variants []string
+	for _, line := range strings.Split(response, "\n") {
+		variant := strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "0123456789.)-*• "))
+		variant = strings.Trim(variant, "\"")
+		if variant == "" || seen[strings.ToLower(variant)] {
+			continue
+		}
+		seen[strings.ToLower(variant)] = true
+		variants = append(variants, variant)
+		if len(variants) == n {
+			break
+		}
+	}
+	span.SetAttributes(attribute.Int("rag.variants", len(variants)))
+	slog.InfoContext(ctx, "Expanded query", "query", query, "variants", variants)
+	return variants
+}
+
+// mergeResults combines the results of several searches, keeping each
+// document once with its highest similarity, ordered by similarity.
+func mergeResults(results ...[]Document) []Document {
+	index := make(map[string]int)
+	var merged []Document
+	for _, docs := range results {
+		for _, doc := range docs {
+			key := doc.Source + "\x00" + doc.Text
+			if i, ok := index[key]; ok {
+				if doc.Similarity > merged[i].Similarity {
+					merged[i].Similarity = doc.Similarity
+				}
+				continue
+			}
+			index[key] = len(merged)
+			merged = append(merged, doc)
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Similarity > merged[j].Similarity
+	})
+	return merged
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRetrieveWithMultiQueryMergesVariantResults(t *testing.T) {
+	store := NewMemoryStore(NewHashingEmbedder(256))
+	store.InsertDocuments(context.Background(),
+		[]string{"goroutines are lightweight threads", "channels pass values between goroutines", "docker images"},
+		[]string{"goroutines", "channels", "docker"}, nil)
+	llm := &sequenceOpenAI{replies: []string{"1. What are goroutines?\n2. How do channels pass values?\n3. What are goroutines?"}}
+	engine := NewRAGEngine(llm, store)
+
+	docs := engine.Retrieve(context.Background(), "concurrency", 3, WithMultiQuery("gpt-test", 3))
+	if len(llm.calls) != 1 {
+		t.Fatalf("expected one expansion call, got %d", len(llm.calls))
+	}
+	sources := map[string]bool{}
+	for _, doc := range docs {
+		if sources[doc.Source] {
+			t.Fatalf("document %s returned twice", doc.Source)
+		}
+		sources[doc.Source] = true
+	}
+	if !sources["goroutines"] || !sources["channels"] {
+		t.Fatalf("expected both variant matches, got %+v", docs)
+	}
+	for i := 1; i < len(docs); i++ {
+		if docs[i].Similarity > docs[i-1].Similarity {
+			t.Fatal("expected merged results ordered by similarity")
+		}
+	}
+}
+
+func TestRetrieveWithMultiQueryFallsBackOnLLMError(t *testing.T) {
+	store := NewMemoryStore(NewHashingEmbedder(256))
+	store.InsertDocuments(context.Background(), []string{"goroutines are lightweight threads"}, []string{"go"}, nil)
+	engine := NewRAGEngine(&scriptedOpenAI{err: errors.New("unavailable")}, store)
+
+	docs := engine.Retrieve(context.Background(), "goroutines", 1, WithMultiQuery("gpt-test", 3))
+	if len(docs) != 1 || docs[0].Source != "go" {
+		t.Fatalf("expected retrieval with the original query, got %+v", docs)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// defaultBoilerplate are phrases of cookie banners, navigation, and page
+// furniture that loaders leave in the text.
+var defaultBoilerplate = []string{
+	"we use cookies", "this site uses cookies", "this website uses cookies",
+	"accept all cookies", "accept cookies", "cookie settings", "cookie preferences", "manage cookies",
+	"all rights reserved", "skip to main content", "skip to content", "back to top",
+	"subscribe to our newsletter", "sign up for our newsletter", "follow us on",
+	"share on facebook", "share on twitter", "print this page", "enable javascript",
+}
+
+// boilerplateLineLength is the length up to which a line with a boilerplate
+// phrase is dropped; longer lines are prose that merely mentions it, such as
+// a paragraph of a cookie policy.
+const boilerplateLineLength = 200
+
+// NoiseFilter cleans pages and drops chunks at ingest that would only take
+// up space in the store and crowd out useful results: boilerplate lines,
+// headers and footers repeated across the pages of a document, and chunks
+// that are near-empty or carry too little information. Code is only
+// filtered by the chunk thresholds.
+type NoiseFilter struct {
+	// MinChars is the fewest non-space characters a chunk must have; 0
+	// means 20.
+	MinChars int
+	// MinTerms is the fewest distinct words, other than stopwords and
+	// single characters, a chunk must have; 0 means 3.
+	MinTerms int
+	// Boilerplate are phrases, in addition to the built-in ones, that mark
+	// a short line as boilerplate; they match regardless of case.
+	Boilerplate []string
+}
+
+// WithNoiseFilter drops noise from the pages and chunks of every ingestion.
+// Chunk offsets then count in the cleaned page text.
+func WithNoiseFilter(filter NoiseFilter) EngineOption {
+	return func(r *RAGEngine) {
+		r.noiseFilter = &filter
+	}
+}
+
+// cleanPages returns the pages with boilerplate lines, and lines repeated on
+// at least half the pages of a paged document (and on three or more), left
+// out. Documents are paged when their pages have a page or slide number.
+func (f *NoiseFilter) cleanPages(ctx context.Context, pages []Page) []Page {
+	phrases := make([]string, 0, len(defaultBoilerplate)+len(f.Boilerplate))
+	phrases = append(phrases, defaultBoilerplate...)
+	for _, phrase := range f.Boilerplate {
+		if phrase = strings.ToLower(strings.TrimSpace(phrase)); phrase != "" {
+			phrases = append(phrases, phrase)
+		}
+	}
+
+	// Count the pages of each paged document each line appears on.
+	paged := make(map[string]int)
+	seen := make(map[string]map[string]int)
+	for _, page := range pages {
+		if page.Format == "code" || (page.Metadata["page"] == nil && page.Metadata["slide"] == nil) {
+			continue
+		}
+		paged[page.Source]++
+		if seen[page.Source] == nil {
+			seen[page.Source] = make(map[string]int)
+		}
+		lines := make(map[string]bool)
+		for _, line := range strings.Split(page.Text, "\n") {
+			if key := lineKey(line); key != "" {
+				lines[key] = true
+			}
+		}
+		for key := range lines {
+			seen[page.Source][key]++
+		}
+	}
+
+	cleaned := make([]Page, len(pages))
+	stripped := 0
+	for i, page := range pages {
+		cleaned[i] = page
+		if page.Format == "code" {
+			continue
+		}
+		lines := strings.Split(page.Text, "\n")
+		kept := lines[:0:0]
+		for _, line := range lines {
+			repeats := seen[page.Source][lineKey(line)]
+			if (repeats >= 3 && repeats*2 >= paged[page.Source]) || isBoilerplate(line, phrases) {
+				stripped++
+				continue
+			}
+			kept = append(kept, line)
+		}
+		if len(kept) < len(lines) {
+			cleaned[i].Text = strings.Join(kept, "\n")
+		}
+	}
+	if stripped > 0 {
+		slog.DebugContext(ctx, "Stripped boilerplate lines", "lines", stripped)
+	}
+	return cleaned
+}
+
+// lineKey normalizes a line for spotting repeated headers and footers: case,
+// spacing, and digits are ignored, so "Page 3 of 10" repeats. Lines without
+// letters, such as Markdown rules and table separators, have no key.
+func lineKey(line string) string {
+	if !strings.ContainsFunc(line, unicode.IsLetter) {
+		return ""
+	}
+	line = strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return '#'
+		}
+		return unicode.ToLower(r)
+	}, line)
+	return strings.Join(strings.Fields(line), " ")
+}
+
+// isBoilerplate reports whether line is short and contains one of phrases.
+func isBoilerplate(line string, phrases []string) bool {
+	line = strings.TrimSpace(line)
+	if line == "" || len(line) > boilerplateLineLength {
+		return false
+	}
+	line = strings.ToLower(line)
+	return slices.ContainsFunc(phrases, func(phrase string) bool { return strings.Contains(line, phrase) })
+}
+
+// filterChunks returns the chunks that clear the filter's thresholds and the
+// number it dropped.
+func (f *NoiseFilter) filterChunks(ctx context.Context, texts, sources []string, metadata []map[string]any) ([]string, []string, []map[string]any, int) {
+	minChars, minTerms := f.MinChars, f.MinTerms
+	if minChars <= 0 {
+		minChars = 20
+	}
+	if minTerms <= 0 {
+		minTerms = 3
+	}
+	var keptTexts, keptSources []string
+	var keptMetadata []map[string]any
+	dropped := 0
+	for i, text := range texts {
+		reason := ""
+		switch {
+		case countNonSpace(text) < minChars:
+			reason = "short"
+		case contentTerms(text) < minTerms:
+			reason = "low_information"
+		}
+		if reason != "" {
+			slog.DebugContext(ctx, "Dropping noisy chunk", "source", sources[i], "reason", reason)
+			droppedChunks.WithLabelValues(reason).Inc()
+			dropped++
+			continue
+		}
+		keptTexts = append(keptTexts, text)
+		keptSources = append(keptSources, sources[i])
+		keptMetadata = append(keptMetadata, metadata[i])
+	}
+	return keptTexts, keptSources, keptMetadata, dropped
+}
+
+// countNonSpace returns the number of characters of text other than spaces.
+func countNonSpace(text string) int {
+	n := 0
+	for _, r := range text {
+		if !unicode.IsSpace(r) {
+			n++
+		}
+	}
+	return n
+}
+
+// stopwords are the frequent words of the languages detectLanguage knows by
+// their words.
+var stopwords = func() map[string]bool {
+	words := make(map[string]bool)
+	for _, list := range languageWords {
+		for _, word := range list {
+			words[word] = true
+		}
+	}
+	return words
+}()
+
+// contentTerms counts the distinct words of text that are neither stopwords
+// nor single characters. In scripts written without spaces, such as Chinese
+// and Japanese, every character counts as a word.
+func contentTerms(text string) int {
+	terms := make(map[string]bool)
+	for _, term := range tokenize(text) {
+		if strings.ContainsFunc(term, unspaced) {
+			for _, r := range term {
+				terms[string(r)] = true
+			}
+			continue
+		}
+		if len([]rune(term)) > 1 && !stopwords[term] {
+			terms[term] = true
+		}
+	}
+	return len(terms)
+}
+
+// unspaced reports whether r belongs to a script written without spaces
+// between words.
+func unspaced(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Thai)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestNoiseFilterStripsRepeatedHeadersAndBoilerplate(t *testing.T) {
+	var pages []Page
+	for i, topic := range []string{"travel", "equipment", "training", "meals"} {
+		text := fmt.Sprintf("ACME Corp Handbook\nThis section covers %s expenses and their approval.\n---\nPage %d of 4", topic, i+1)
+		pages = append(pages, Page{Text: text, Source: "handbook.pdf", Metadata: map[string]any{"page": i + 1}})
+	}
+	pages = append(pages,
+		Page{Text: "We use cookies to improve your experience. Accept all cookies\nRefunds are issued within five days.", Source: "https://example.com/refunds"},
+		Page{Text: "ACME Corp Handbook\nfunc main() {}", Source: "main.go", Format: "code"},
+	)
+	cleaned := (&NoiseFilter{}).cleanPages(context.Background(), pages)
+	if want := "This section covers travel expenses and their approval.\n---"; cleaned[0].Text != want {
+		t.Fatalf("expected the header and footer stripped, got %q", cleaned[0].Text)
+	}
+	if cleaned[4].Text != "Refunds are issued within five days." {
+		t.Fatalf("expected the cookie banner stripped, got %q", cleaned[4].Text)
+	}
+	if cleaned[5].Text != pages[5].Text || !strings.HasPrefix(pages[0].Text, "ACME") {
+		t.Fatal("expected code and the pages passed in to be left as they were")
+	}
+}
+
+func TestNoiseFilterDropsLowInformationChunks(t *testing.T) {
+	texts := []string{
+		"Page 7",
+		"The and of the, is it? It is what it is.",
+		"Invoices are stored in Postgres for seven years.",
+		"请假需要经理批准。",
+	}
+	sources := []string{"a.md", "b.md", "c.md", "d.md"}
+	metadata := make([]map[string]any, len(texts))
+	kept, keptSources, keptMetadata, dropped := (&NoiseFilter{MinChars: 8}).filterChunks(context.Background(), texts, sources, metadata)
+	if dropped != 2 || len(kept) != 2 || keptSources[0] != "c.md" || keptSources[1] != "d.md" || len(keptMetadata) != 2 {
+		t.Fatalf("expected the short and stopword-only chunks dropped, got %q from %v", kept, keptSources)
+	}
+}
+
+func TestIngestPagesReportsDroppedChunks(t *testing.T) {
+	store := NewMemoryStore(NewHashingEmbedder(64))
+	engine := NewRAGEngine(&dummyOpenAI{}, store, WithNoiseFilter(NoiseFilter{}))
+	pages := []Page{
+		{Text: "Expense reports are due by the fifth of each month.", Source: "policy.md"},
+		{Text: "Back to top", Source: "nav.md"},
+		{Text: "See below.", Source: "stub.md"},
+	}
+	report, ok := ingestPages(context.Background(), engine, pages, 1000, 0)
+	if !ok || report != (IngestReport{Inserted: 1, Dropped: 1}) {
+		t.Fatalf("expected one chunk stored and the stub dropped, got %s (ok=%t)", report, ok)
+	}
+}
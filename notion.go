@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	notionBaseURL = "https://api.notion.com/v1"
+	notionVersion = "2022-06-28"
+)
+
+// NotionConnector syncs the pages of a Notion database, or every page shared
+// with the integration when no database is given.
+type NotionConnector struct {
+	token      string
+	databaseID string
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewNotionConnector creates a connector authenticated with an internal
+// integration token. The database, or the pages to sync, must be shared with
+// the integration.
+func NewNotionConnector(token, databaseID string) *NotionConnector {
+	return &NotionConnector{token: token, databaseID: databaseID, baseURL: notionBaseURL, httpClient: http.DefaultClient}
+}
+
+func (n *NotionConnector) Name() string {
+	if n.databaseID == "" {
+		return "notion"
+	}
+	return "notion:" + n.databaseID
+}
+
+type notionRichText struct {
+	PlainText string `json:"plain_text"`
+}
+
+type notionPage struct {
+	ID             string    `json:"id"`
+	URL            string    `json:"url"`
+	LastEditedTime time.Time `json:"last_edited_time"`
+	Archived       bool      `json:"archived"`
+	InTrash        bool      `json:"in_trash"`
+	Properties     map[string]struct {
+		Type  string           `json:"type"`
+		Title []notionRichText `json:"title"`
+	} `json:"properties"`
+}
+
+// title returns the text of the page's title property.
+func (p notionPage) title() string {
+	for _, prop := range p.Properties {
+		if prop.Type == "title" {
+			return plainText(prop.Title)
+		}
+	}
+	return ""
+}
+
+func plainText(texts []notionRichText) string {
+	var b strings.Builder
+	for _, t := range texts {
+		b.WriteString(t.PlainText)
+	}
+	return b.String()
+}
+
+// List queries the database, or searches for pages, following the cursor
+// through every result page.
+func (n *NotionConnector) List(ctx context.Context) ([]ConnectorItem, error) {
+	path := "/search"
+	body := map[string]any{"filter": map[string]string{"property": "object", "value": "page"}}
+	if n.databaseID != "" {
+		path = "/databases/" + url.PathEscape(n.databaseID) + "/query"
+		body = map[string]any{}
+	}
+
+	var items []ConnectorItem
+	for cursor := ""; ; {
+		body["page_size"] = 100
+		if cursor != "" {
+			body["start_cursor"] = cursor
+		}
+		var resp struct {
+			Results    []notionPage `json:"results"`
+			HasMore    bool         `json:"has_more"`
+			NextCursor string       `json:"next_cursor"`
+		}
+		if err := n.do(ctx, http.MethodPost, path, body, &resp); err != nil {
+			return nil, err
+		}
+		for _, page := range resp.Results {
+			if page.Archived || page.InTrash {
+				continue
+			}
+			items = append(items, ConnectorItem{
+				ID:      page.ID,
+				Title:   page.title(),
+				URL:     "https://www.notion.so/" + strings.ReplaceAll(page.ID, "-", ""),
+				Updated: page.LastEditedTime,
+			})
+		}
+		if !resp.HasMore || resp.NextCursor == "" {
+			return items, nil
+		}
+		cursor = resp.NextCursor
+	}
+}
+
+type notionBlock struct {
+	ID          string `json:"id"`
+	Type        string `json:"type"`
+	HasChildren bool   `json:"has_children"`
+	raw         map[string]json.RawMessage
+}
+
+func (b *notionBlock) UnmarshalJSON(data []byte) error {
+	type block notionBlock
+	if err := json.Unmarshal(data, (*block)(b)); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &b.raw)
+}
+
+// content decodes the block's type-specific payload.
+func (b notionBlock) content() (text string, language string, cells []string) {
+	var payload struct {
+		RichText []notionRichText   `json:"rich_text"`
+		Language string             `json:"language"`
+		Cells    [][]notionRichText `json:"cells"`
+	}
+	json.Unmarshal(b.raw[b.Type], &payload)
+	for _, cell := range payload.Cells {
+		cells = append(cells, plainText(cell))
+	}
+	return plainText(payload.RichText), payload.Language, cells
+}
+
+// Load converts the page's blocks to Markdown: headings, lists, to-dos,
+// quotes, code, and tables keep their structure, and nested blocks are
+// indented under their parent. Child pages are synced on their own.
+func (n *NotionConnector) Load(ctx context.Context, item ConnectorItem) (Page, error) {
+	var lines []string
+	if item.Title != "" {
+		lines = append(lines, "# "+item.Title, "")
+	}
+	if err := n.appendBlocks(ctx, item.ID, 0, &lines); err != nil {
+		return Page{}, err
+	}
+	return Page{Text: strings.TrimSpace(strings.Join(lines, "\n")), Format: "markdown"}, nil
+}
+
+func (n *NotionConnector) appendBlocks(ctx context.Context, blockID string, depth int, lines *[]string) error {
+	indent := strings.Repeat("  ", depth)
+	var table [][]string
+	flushTable := func() {
+		if len(table) > 0 {
+			*lines = append(*lines, markdownTable(table), "")
+			table = nil
+		}
+	}
+	for cursor := ""; ; {
+		query := url.Values{"page_size": {"100"}}
+		if cursor != "" {
+			query.Set("start_cursor", cursor)
+		}
+		var resp struct {
+			Results    []notionBlock `json:"results"`
+			HasMore    bool          `json:"has_more"`
+			NextCursor string        `json:"next_cursor"`
+		}
+		if err := n.do(ctx, http.MethodGet, "/blocks/"+url.PathEscape(blockID)+"/children?"+query.Encode(), nil, &resp); err != nil {
+			return err
+		}
+		for _, block := range resp.Results {
+			text, language, cells := block.content()
+			if block.Type == "table_row" {
+				table = append(table, cells)
+				continue
+			}
+			flushTable()
+			switch block.Type {
+			case "heading_1", "heading_2", "heading_3":
+				level := int(block.Type[len(block.Type)-1]-'0') + 1
+				*lines = append(*lines, strings.Repeat("#", level)+" "+text, "")
+			case "bulleted_list_item", "toggle":
+				*lines = append(*lines, indent+"- "+text)
+			case "numbered_list_item":
+				*lines = append(*lines, indent+"1. "+text)
+			case "to_do":
+				*lines = append(*lines, indent+"- [ ] "+text)
+			case "quote", "callout":
+				*lines = append(*lines, indent+"> "+text, "")
+			case "code":
+				*lines = append(*lines, "```"+language, text, "```", "")
+			case "child_page", "child_database":
+				continue
+			default:
+				if text != "" {
+					*lines = append(*lines, indent+text, "")
+				}
+			}
+			if block.HasChildren {
+				childDepth := depth
+				if block.Type != "table" {
+					childDepth++
+				}
+				if err := n.appendBlocks(ctx, block.ID, childDepth, lines); err != nil {
+					return err
+				}
+			}
+		}
+		if !resp.HasMore || resp.NextCursor == "" {
+			break
+		}
+		cursor = resp.NextCursor
+	}
+	flushTable()
+	return nil
+}
+
+// do sends a request to the Notion API and decodes the JSON reply.
+func (n *NotionConnector) do(ctx context.Context, method, path string, payload, out any) error {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(n.baseURL, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+n.token)
+	req.Header.Set("Notion-Version", notionVersion)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Message != "" {
+			return fmt.Errorf("Notion API error (status %d): %s: %s", resp.StatusCode, apiErr.Code, apiErr.Message)
+		}
+		return fmt.Errorf("Notion API error (status %d)", resp.StatusCode)
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNotionConnector(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" || r.Header.Get("Notion-Version") == "" {
+			t.Errorf("missing auth or version headers")
+		}
+		switch r.URL.Path {
+		case "/databases/db1/query":
+			var body map[string]any
+			json.NewDecoder(r.Body).Decode(&body)
+			if body["start_cursor"] == nil {
+				w.Write([]byte(`{"results":[{"id":"aaaa-1111","last_edited_time":"2025-03-01T10:00:00.000Z","properties":{"Name":{"type":"title","title":[{"plain_text":"Runbook"}]}}}],"has_more":true,"next_cursor":"c2"}`))
+				return
+			}
+			w.Write([]byte(`{"results":[{"id":"bbbb-2222","archived":true,"last_edited_time":"2025-03-01T10:00:00.000Z","properties":{}}],"has_more":false}`))
+		case "/blocks/aaaa-1111/children":
+			w.Write([]byte(`{"results":[
+				{"id":"h","type":"heading_1","heading_1":{"rich_text":[{"plain_text":"Restarts"}]}},
+				{"id":"p","type":"paragraph","paragraph":{"rich_text":[{"plain_text":"Restart the "},{"plain_text":"worker."}]}},
+				{"id":"l","type":"bulleted_list_item","has_children":true,"bulleted_list_item":{"rich_text":[{"plain_text":"Check the queue"}]}},
+				{"id":"c","type":"code","code":{"language":"bash","rich_text":[{"plain_text":"systemctl restart worker"}]}},
+				{"id":"t","type":"table","has_children":true,"table":{}},
+				{"id":"sub","type":"child_page","child_page":{"title":"Other page"}}
+			],"has_more":false}`))
+		case "/blocks/l/children":
+			w.Write([]byte(`{"results":[{"id":"l2","type":"bulleted_list_item","bulleted_list_item":{"rich_text":[{"plain_text":"Depth below 100"}]}}],"has_more":false}`))
+		case "/blocks/t/children":
+			w.Write([]byte(`{"results":[
+				{"id":"r1","type":"table_row","table_row":{"cells":[[{"plain_text":"Service"}],[{"plain_text":"Owner"}]]}},
+				{"id":"r2","type":"table_row","table_row":{"cells":[[{"plain_text":"worker"}],[{"plain_text":"infra"}]]}}
+			],"has_more":false}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"object":"error","code":"object_not_found","message":"Could not find block"}`))
+		}
+	}))
+	defer server.Close()
+
+	connector := NewNotionConnector("secret", "db1")
+	connector.baseURL = server.URL
+	items, err := connector.List(context.Background())
+	if err != nil {
+		t.Fatalf("List returned error: %v", err)
+	}
+	if len(items) != 1 || items[0].Title != "Runbook" || items[0].URL != "https://www.notion.so/aaaa1111" {
+		t.Fatalf("expected the one live page, got %+v", items)
+	}
+	if !items[0].Updated.Equal(time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected edit time %v", items[0].Updated)
+	}
+
+	page, err := connector.Load(context.Background(), items[0])
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	want := "# Runbook\n\n## Restarts\n\nRestart the worker.\n\n- Check the queue\n  - Depth below 100\n```bash\nsystemctl restart worker\n```\n\n| Service | Owner |\n| --- | --- |\n| worker | infra |"
+	if page.Text != want || page.Format != "markdown" {
+		t.Fatalf("unexpected page text:\n%s\nwant:\n%s", page.Text, want)
+	}
+
+	if _, err := connector.Load(context.Background(), ConnectorItem{ID: "missing"}); err == nil {
+		t.Fatalf("expected error for a missing page")
+	}
+}
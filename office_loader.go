@@ -0,0 +1,381 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	wordNS    = "http://schemas.openxmlformats.org/wordprocessingml/2006/main"
+	drawingNS = "http://schemas.openxmlformats.org/drawingml/2006/main"
+	relNS     = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+)
+
+var (
+	// headingStyle matches the paragraph style IDs Word gives its built-in
+	// headings.
+	headingStyle = regexp.MustCompile(`^(?i:heading)\s?([1-6])$`)
+	slideFile    = regexp.MustCompile(`^ppt/slides/slide(\d+)\.xml$`)
+)
+
+// LoadDOCX extracts the text of a Word document as a single Markdown page, so
+// it is chunked along its headings. Paragraphs styled as headings become
+// Markdown headings, list paragraphs become list items, and tables become
+// Markdown tables whose first row is the header. The title in the document
+// properties, if any, is recorded as title.
+func LoadDOCX(path string) ([]Page, error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening DOCX %s: %w", path, err)
+	}
+	defer r.Close()
+
+	f, err := openZipFile(&r.Reader, "word/document.xml")
+	if err != nil {
+		return nil, fmt.Errorf("reading DOCX %s: %w", path, err)
+	}
+	defer f.Close()
+	text, err := docxText(f)
+	if err != nil {
+		return nil, fmt.Errorf("parsing DOCX %s: %w", path, err)
+	}
+	if text == "" {
+		return nil, nil
+	}
+	page := Page{Text: text, Source: filepath.Base(path), Format: "markdown"}
+	if title := officeTitle(&r.Reader); title != "" {
+		page.Metadata = map[string]any{titleField: title}
+	}
+	return []Page{page}, nil
+}
+
+// docxText converts the body of word/document.xml to Markdown.
+func docxText(r io.Reader) (string, error) {
+	var out []string
+	var para strings.Builder
+	var style string
+	var tableDepth int
+	var cell []string
+	var row []string
+	var rows [][]string
+
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Space != wordNS {
+				continue
+			}
+			switch t.Name.Local {
+			case "p":
+				para.Reset()
+				style = ""
+			case "pStyle":
+				style = xmlAttr(t, "val")
+			case "t":
+				var s string
+				if err := dec.DecodeElement(&s, &t); err != nil {
+					return "", err
+				}
+				para.WriteString(s)
+			case "tab":
+				para.WriteString("\t")
+			case "br", "cr":
+				para.WriteString("\n")
+			case "tbl":
+				tableDepth++
+				if tableDepth == 1 {
+					rows = nil
+				}
+			case "tr":
+				if tableDepth == 1 {
+					row = nil
+				}
+			case "tc":
+				if tableDepth == 1 {
+					cell = nil
+				}
+			}
+		case xml.EndElement:
+			if t.Name.Space != wordNS {
+				continue
+			}
+			switch t.Name.Local {
+			case "p":
+				text := strings.TrimSpace(para.String())
+				if text == "" {
+					continue
+				}
+				if tableDepth > 0 {
+					// Nested tables are flattened into the cell of the outer one.
+					cell = append(cell, text)
+					continue
+				}
+				if m := headingStyle.FindStringSubmatch(style); m != nil {
+					level, _ := strconv.Atoi(m[1])
+					text = strings.Repeat("#", level) + " " + text
+				} else if strings.EqualFold(style, "Title") {
+					text = "# " + text
+				} else if strings.EqualFold(style, "ListParagraph") {
+					text = "- " + text
+				}
+				out = append(out, text)
+			case "tc":
+				if tableDepth == 1 {
+					row = append(row, strings.Join(cell, " "))
+				}
+			case "tr":
+				if tableDepth == 1 {
+					rows = append(rows, row)
+				}
+			case "tbl":
+				tableDepth--
+				if tableDepth == 0 {
+					if table := markdownTable(rows); table != "" {
+						out = append(out, table)
+					}
+				}
+			}
+		}
+	}
+	return strings.Join(out, "\n\n"), nil
+}
+
+// markdownTable renders rows as a Markdown table with the first row as the
+// header.
+func markdownTable(rows [][]string) string {
+	var width int
+	for _, row := range rows {
+		width = max(width, len(row))
+	}
+	if width == 0 {
+		return ""
+	}
+	var b strings.Builder
+	writeRow := func(row []string) {
+		b.WriteString("|")
+		for i := range width {
+			var cell string
+			if i < len(row) {
+				cell = strings.ReplaceAll(strings.Join(strings.Fields(row[i]), " "), "|", `\|`)
+			}
+			b.WriteString(" " + cell + " |")
+		}
+		b.WriteString("\n")
+	}
+	writeRow(rows[0])
+	b.WriteString("|" + strings.Repeat(" --- |", width) + "\n")
+	for _, row := range rows[1:] {
+		writeRow(row)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// LoadPPTX extracts the text of every slide of a PowerPoint presentation, in
+// presentation order. Each slide is a page whose metadata records its number,
+// so answers can point back to the slide, along with the presentation's
+// title, if it has one; slides without text are skipped.
+func LoadPPTX(path string) ([]Page, error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening PPTX %s: %w", path, err)
+	}
+	defer r.Close()
+
+	slides, err := pptxSlides(&r.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("reading PPTX %s: %w", path, err)
+	}
+	name := filepath.Base(path)
+	title := officeTitle(&r.Reader)
+	var pages []Page
+	for i, slide := range slides {
+		f, err := openZipFile(&r.Reader, slide)
+		if err != nil {
+			return nil, fmt.Errorf("reading PPTX %s: %w", path, err)
+		}
+		text, err := slideText(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s slide %d: %w", name, i+1, err)
+		}
+		if text == "" {
+			continue
+		}
+		meta := map[string]any{"slide": i + 1}
+		if title != "" {
+			meta[titleField] = title
+		}
+		pages = append(pages, Page{Text: text, Source: name, Metadata: meta})
+	}
+	return pages, nil
+}
+
+// pptxSlides returns the archive paths of the slides in presentation order,
+// which follows the slide list of ppt/presentation.xml rather than the slide
+// file names. Presentations without a readable slide list fall back to the
+// numeric order of the file names.
+func pptxSlides(r *zip.Reader) ([]string, error) {
+	if slides, err := pptxSlideOrder(r); err == nil && len(slides) > 0 {
+		return slides, nil
+	}
+	var slides []string
+	numbers := make(map[string]int)
+	for _, f := range r.File {
+		if m := slideFile.FindStringSubmatch(f.Name); m != nil {
+			numbers[f.Name], _ = strconv.Atoi(m[1])
+			slides = append(slides, f.Name)
+		}
+	}
+	if len(slides) == 0 {
+		return nil, fmt.Errorf("no slides found")
+	}
+	sort.Slice(slides, func(i, j int) bool { return numbers[slides[i]] < numbers[slides[j]] })
+	return slides, nil
+}
+
+// pptxSlideOrder resolves the slide list of ppt/presentation.xml through its
+// relationships to archive paths.
+func pptxSlideOrder(r *zip.Reader) ([]string, error) {
+	f, err := openZipFile(r, "ppt/_rels/presentation.xml.rels")
+	if err != nil {
+		return nil, err
+	}
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	err = xml.NewDecoder(f).Decode(&rels)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	targets := make(map[string]string)
+	for _, rel := range rels.Relationships {
+		if strings.HasPrefix(rel.Target, "/") {
+			targets[rel.ID] = strings.TrimPrefix(rel.Target, "/")
+		} else {
+			targets[rel.ID] = path.Join("ppt", rel.Target)
+		}
+	}
+
+	f, err = openZipFile(r, "ppt/presentation.xml")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var slides []string
+	dec := xml.NewDecoder(f)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return slides, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if t, ok := tok.(xml.StartElement); ok && t.Name.Local == "sldId" {
+			for _, attr := range t.Attr {
+				if attr.Name.Space == relNS && attr.Name.Local == "id" && targets[attr.Value] != "" {
+					slides = append(slides, targets[attr.Value])
+				}
+			}
+		}
+	}
+}
+
+// slideText returns the text of a slide's shapes and tables, one paragraph
+// per line.
+func slideText(r io.Reader) (string, error) {
+	var lines []string
+	var para strings.Builder
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Space != drawingNS {
+				continue
+			}
+			switch t.Name.Local {
+			case "p":
+				para.Reset()
+			case "t":
+				var s string
+				if err := dec.DecodeElement(&s, &t); err != nil {
+					return "", err
+				}
+				para.WriteString(s)
+			case "br":
+				para.WriteString("\n")
+			}
+		case xml.EndElement:
+			if t.Name.Space == drawingNS && t.Name.Local == "p" {
+				if text := strings.TrimSpace(para.String()); text != "" {
+					lines = append(lines, text)
+				}
+			}
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// openZipFile opens the named file of an Office archive.
+func openZipFile(r *zip.Reader, name string) (io.ReadCloser, error) {
+	for _, f := range r.File {
+		if f.Name == name {
+			return f.Open()
+		}
+	}
+	return nil, fmt.Errorf("%s not found in archive", name)
+}
+
+// officeTitle returns the title in the document properties of an Office
+// file (docProps/core.xml), or "" if it has none.
+func officeTitle(r *zip.Reader) string {
+	f, err := openZipFile(r, "docProps/core.xml")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	var props struct {
+		Title string `xml:"title"`
+	}
+	if err := xml.NewDecoder(f).Decode(&props); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(props.Title)
+}
+
+// xmlAttr returns the value of the attribute with the given local name.
+func xmlAttr(el xml.StartElement, local string) string {
+	for _, attr := range el.Attr {
+		if attr.Name.Local == local {
+			return attr.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeZip writes an Office archive with the given parts to dir/name.
+func writeZip(t *testing.T, dir, name string, parts map[string]string) string {
+	path := filepath.Join(dir, name)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for part, content := range parts {
+		w, err := zw.Create(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const testDocument = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">
+  <w:body>
+    <w:p><w:pPr><w:pStyle w:val="Heading1"/></w:pPr><w:r><w:t>Vacation</w:t></w:r></w:p>
+    <w:p><w:r><w:t xml:space="preserve">Employees get </w:t></w:r><w:r><w:rPr><w:b/></w:rPr><w:t>25 days</w:t></w:r><w:r><w:t>.</w:t></w:r></w:p>
+    <w:p><w:pPr><w:pStyle w:val="ListParagraph"/></w:pPr><w:r><w:t>Ask your manager</w:t></w:r></w:p>
+    <w:tbl>
+      <w:tr><w:tc><w:p><w:r><w:t>Country</w:t></w:r></w:p></w:tc><w:tc><w:p><w:r><w:t>Days</w:t></w:r></w:p></w:tc></w:tr>
+      <w:tr><w:tc><w:p><w:r><w:t>Spain</w:t></w:r></w:p></w:tc><w:tc><w:p><w:r><w:t>30</w:t></w:r></w:p><w:p><w:r><w:t>plus holidays</w:t></w:r></w:p></w:tc></w:tr>
+    </w:tbl>
+    <w:p/>
+  </w:body>
+</w:document>`
+
+func TestLoadDOCX(t *testing.T) {
+	path := writeZip(t, t.TempDir(), "policy.docx", map[string]string{
+		"word/document.xml": testDocument,
+		"docProps/core.xml": `<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties" xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Leave Policy</dc:title></cp:coreProperties>`,
+	})
+
+	pages, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile returned error: %v", err)
+	}
+	if len(pages) != 1 || pages[0].Source != "policy.docx" || pages[0].Format != "markdown" || pages[0].Metadata[titleField] != "Leave Policy" {
+		t.Fatalf("unexpected pages %+v", pages)
+	}
+	want := strings.Join([]string{
+		"# Vacation",
+		"Employees get 25 days.",
+		"- Ask your manager",
+		"| Country | Days |\n| --- | --- |\n| Spain | 30 plus holidays |",
+	}, "\n\n")
+	if pages[0].Text != want {
+		t.Fatalf("unexpected text:\n%s\nwant:\n%s", pages[0].Text, want)
+	}
+
+	if _, err := LoadDOCX(writeZip(t, t.TempDir(), "empty.docx", map[string]string{"other.xml": "<x/>"})); err == nil {
+		t.Fatalf("expected error for an archive without a document")
+	}
+}
+
+func slideXML(paragraphs ...string) string {
+	var b strings.Builder
+	b.WriteString(`<p:sld xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main" xmlns:p="http://schemas.openxmlformats.org/presentationml/2006/main"><p:cSld><p:spTree><p:sp><p:txBody>`)
+	for _, text := range paragraphs {
+		b.WriteString("<a:p><a:r><a:t>" + text + "</a:t></a:r></a:p>")
+	}
+	b.WriteString(`</p:txBody></p:sp></p:spTree></p:cSld></p:sld>`)
+	return b.String()
+}
+
+func TestLoadPPTXFollowsPresentationOrder(t *testing.T) {
+	path := writeZip(t, t.TempDir(), "roadmap.pptx", map[string]string{
+		"ppt/presentation.xml": `<p:presentation xmlns:p="http://schemas.openxmlformats.org/presentationml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+  <p:sldIdLst><p:sldId id="256" r:id="rId3"/><p:sldId id="257" r:id="rId2"/><p:sldId id="258" r:id="rId4"/></p:sldIdLst>
+</p:presentation>`,
+		"ppt/_rels/presentation.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+  <Relationship Id="rId2" Type="slide" Target="slides/slide1.xml"/>
+  <Relationship Id="rId3" Type="slide" Target="slides/slide2.xml"/>
+  <Relationship Id="rId4" Type="slide" Target="/ppt/slides/slide3.xml"/>
+</Relationships>`,
+		"ppt/slides/slide1.xml": slideXML("Q2", "Ship the watcher"),
+		"ppt/slides/slide2.xml": slideXML("Roadmap 2025"),
+		"ppt/slides/slide3.xml": slideXML(),
+	})
+
+	pages, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile returned error: %v", err)
+	}
+	if len(pages) != 2 {
+		t.Fatalf("expected the two slides with text, got %+v", pages)
+	}
+	if pages[0].Text != "Roadmap 2025" || pages[0].Metadata["slide"] != 1 {
+		t.Fatalf("unexpected first slide %+v", pages[0])
+	}
+	if pages[1].Text != "Q2\nShip the watcher" || pages[1].Metadata["slide"] != 2 || pages[1].Source != "roadmap.pptx" {
+		t.Fatalf("unexpected second slide %+v", pages[1])
+	}
+}
+
+func TestLoadPPTXWithoutSlideList(t *testing.T) {
+	path := writeZip(t, t.TempDir(), "deck.pptx", map[string]string{
+		"ppt/slides/slide10.xml": slideXML("ten"),
+		"ppt/slides/slide2.xml":  slideXML("two"),
+	})
+	pages, err := LoadPPTX(path)
+	if err != nil {
+		t.Fatalf("LoadPPTX returned error: %v", err)
+	}
+	if len(pages) != 2 || pages[0].Text != "two" || pages[1].Text != "ten" {
+		t.Fatalf("expected numeric slide order, got %+v", pages)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// OllamaClient talks to a local Ollama server. It implements both LLMClient
+// and Embedder, so a full pipeline can run without any cloud credentials.
+type OllamaClient struct {
+	baseURL            string
+	embeddingModel     string
+	embeddingDimension int
+	httpClient         *http.Client
+}
+
+// ollamaEmbeddingDimensions lists the dimensions of common Ollama embedding
+// models.
+var ollamaEmbeddingDimensions = map[string]int{
+	"nomic-embed-text":       768,
+	"mxbai-embed-large":      1024,
+	"all-minilm":             384,
+	"snowflake-arctic-embed": 1024,
+	"bge-m3":                 1024,
+}
+
+// NewOllamaClient creates a client for the Ollama server at baseURL
+// (e.g. http://localhost:11434) that embeds with embeddingModel.
+func NewOllamaClient(baseURL, embeddingModel string) *OllamaClient {
+	return &OllamaClient{
+		baseURL:        strings.TrimRight(baseURL, "/"),
+		embeddingModel: embeddingModel,
+		httpClient:     http.DefaultClient,
+	}
+}
+
+// Ping lists the local models, which fails unless the server is reachable.
+func (o *OllamaClient) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.baseURL+"/api/tags", nil)
+	if err != nil {
+		return err
+	}
+	return pingHTTP(o.httpClient, req, "Ollama")
+}
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Images    []string         `json:"images,omitempty"` // base64-encoded, for vision models
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"` // the tool a "tool" message answers
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+// ollamaTool declares a tool in the OpenAI function format.
+type ollamaTool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string         `json:"name"`
+		Description string         `json:"description,omitempty"`
+		Parameters  map[string]any `json:"parameters"`
+	} `json:"function"`
+}
+
+type ollamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Format   any             `json:"format,omitempty"` // "json" or a JSON Schema
+	Options  *ollamaOptions  `json:"options,omitempty"`
+	Tools    []ollamaTool    `json:"tools,omitempty"`
+}
+
+// ollamaOptions fixes the sampling in deterministic mode.
+type ollamaOptions struct {
+	Temperature float64 `json:"temperature"`
+	Seed        int     `json:"seed"`
+}
+
+type ollamaChatResponse struct {
+	Message         ollamaMessage `json:"message"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+}
+
+func (o *OllamaClient) ChatCompletion(ctx context.Context, model string, messages []Message) (string, error) {
+	return o.chat(ctx, ollamaChatRequest{Model: model}, messages)
+}
+
+// ChatCompletionJSON passes the schema as Ollama's structured output format,
+// constraining generation to matching JSON.
+func (o *OllamaClient) ChatCompletionJSON(ctx context.Context, model string, messages []Message, schema map[string]any) (string, error) {
+	return o.chat(ctx, ollamaChatRequest{Model: model, Format: schema}, messages)
+}
+
+func (o *OllamaClient) chat(ctx context.Context, req ollamaChatRequest, messages []Message) (string, error) {
+	req.Messages = ollamaMessages(messages)
+	return o.send(ctx, req)
+}
+
+// ChatCompletionTools offers tools to models that support them, such as
+// llama3.1 or qwen2.5. Ollama has no call IDs, so the calls of a reply are
+// numbered call_1, call_2, ...
+func (o *OllamaClient) ChatCompletionTools(ctx context.Context, model string, messages []Message, tools []Tool) (Message, error) {
+	req := ollamaChatRequest{Model: model, Messages: ollamaMessages(messages)}
+	for _, tool := range tools {
+		declared := ollamaTool{Type: "function"}
+		declared.Function.Name = tool.Name
+		declared.Function.Description = tool.Description
+		declared.Function.Parameters = toolParameters(tool)
+		req.Tools = append(req.Tools, declared)
+	}
+	resp, err := o.chatResponse(ctx, req)
+	if err != nil {
+		return Message{}, err
+	}
+	reply := Message{Role: "assistant", Content: resp.Message.Content}
+	for i, call := range resp.Message.ToolCalls {
+		reply.ToolCalls = append(reply.ToolCalls, ToolCall{
+			ID:        fmt.Sprintf("call_%d", i+1),
+			Name:      call.Function.Name,
+			Arguments: call.Function.Arguments,
+		})
+	}
+	return reply, nil
+}
+
+// ollamaMessages converts messages, with their tool calls and results, to
+// the Ollama format, which names the tool a result answers.
+func ollamaMessages(messages []Message) []ollamaMessage {
+	converted := make([]ollamaMessage, len(messages))
+	for i, msg := range messages {
+		converted[i] = ollamaMessage{Role: msg.Role, Content: msg.Content}
+		for _, call := range msg.ToolCalls {
+			var toolCall ollamaToolCall
+			toolCall.Function.Name = call.Name
+			toolCall.Function.Arguments = toolArguments(call.Arguments)
+			converted[i].ToolCalls = append(converted[i].ToolCalls, toolCall)
+		}
+		if msg.Role == "tool" {
+			converted[i].ToolName = toolCallName(messages[:i], msg.ToolCallID)
+		}
+	}
+	return converted
+}
+
+// DescribeImage sends the image with prompt to a vision model such as llava.
+func (o *OllamaClient) DescribeImage(ctx context.Context, model string, image Image, prompt string) (string, error) {
+	message := ollamaMessage{Role: "user", Content: prompt, Images: []string{base64.StdEncoding.EncodeToString(image.Data)}}
+	return o.send(ctx, ollamaChatRequest{Model: model, Messages: []ollamaMessage{message}})
+}
+
+// send posts a chat request and returns the reply.
+func (o *OllamaClient) send(ctx context.Context, req ollamaChatRequest) (string, error) {
+	resp, err := o.chatResponse(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	if resp.Message.Content == "" {
+		return "", fmt.Errorf("no response from Ollama")
+	}
+	return resp.Message.Content, nil
+}
+
+// chatResponse posts a chat request and decodes the reply.
+func (o *OllamaClient) chatResponse(ctx context.Context, req ollamaChatRequest) (*ollamaChatResponse, error) {
+	if seed, ok := deterministicSeed(ctx); ok {
+		req.Options = &ollamaOptions{Temperature: 0, Seed: seed}
+	}
+	var resp ollamaChatResponse
+	if err := o.post(ctx, "/api/chat", req, &resp); err != nil {
+		return nil, err
+	}
+	recordTokenUsage(ctx, resp.PromptEvalCount, resp.EvalCount)
+	return &resp, nil
+}
+
+type ollamaEmbedRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type ollamaEmbedResponse struct {
+	Embeddings      [][]float32 `json:"embeddings"`
+	PromptEvalCount int         `json:"prompt_eval_count"`
+}
+
+// Dimension returns the configured embedding dimension, or the known
+// dimension of the embedding model.
+func (o *OllamaClient) Dimension() int {
+	if o.embeddingDimension > 0 {
+		return o.embeddingDimension
+	}
+	return ollamaEmbeddingDimensions[strings.Split(o.embeddingModel, ":")[0]]
+}
+
+func (o *OllamaClient) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	var resp ollamaEmbedResponse
+	if err := o.post(ctx, "/api/embed", ollamaEmbedRequest{Model: o.embeddingModel, Input: texts}, &resp); err != nil {
+		return nil, err
+	}
+	recordTokenUsage(ctx, resp.PromptEvalCount, 0)
+	if len(resp.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings from Ollama, got %d", len(texts), len(resp.Embeddings))
+	}
+	return resp.Embeddings, nil
+}
+
+// post sends a JSON request to the Ollama API and decodes the JSON reply.
+func (o *OllamaClient) post(ctx context.Context, path string, payload, out any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("Ollama API error (status %d): %s", resp.StatusCode, apiErr.Error)
+		}
+		return fmt.Errorf("Ollama API error (status %d)", resp.StatusCode)
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newOllamaTestServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/chat":
+			var req ollamaChatRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Errorf("decoding chat request: %v", err)
+			}
+			if req.Stream {
+				t.Errorf("expected non-streaming chat request")
+			}
+			w.Write([]byte(`{"message":{"role":"assistant","content":"local answer"}}`))
+		case "/api/embed":
+			var req ollamaEmbedRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Errorf("decoding embed request: %v", err)
+			}
+			if req.Model != "nomic-embed-text" {
+				t.Errorf("unexpected embedding model %s", req.Model)
+			}
+			w.Write([]byte(`{"embeddings":[[0.1,0.2],[0.3,0.4]]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"not found"}`))
+		}
+	}))
+}
+
+func TestOllamaClientChatCompletion(t *testing.T) {
+	server := newOllamaTestServer(t)
+	defer server.Close()
+
+	client := NewOllamaClient(server.URL+"/", "nomic-embed-text")
+	resp, err := client.ChatCompletion(context.Background(), "llama3.2", []Message{{Role: "user", Content: "hi"}})
+	if err != nil {
+		t.Fatalf("ChatCompletion returned error: %v", err)
+	}
+	if resp != "local answer" {
+		t.Fatalf("unexpected response: %s", resp)
+	}
+}
+
+func TestOllamaClientEmbed(t *testing.T) {
+	server := newOllamaTestServer(t)
+	defer server.Close()
+
+	client := NewOllamaClient(server.URL, "nomic-embed-text")
+	embeddings, err := client.Embed(context.Background(), []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("Embed returned error: %v", err)
+	}
+	if len(embeddings) != 2 || embeddings[1][0] != 0.3 {
+		t.Fatalf("unexpected embeddings: %v", embeddings)
+	}
+
+	if _, err := client.Embed(context.Background(), []string{"only one"}); err == nil {
+		t.Fatalf("expected error when embedding count mismatches input")
+	}
+}
@@ -0,0 +1,165 @@
+//go:build onnx
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	ort "github.com/yalue/onnxruntime_go"
+)
+
+// onnxMaxTokens is the longest input BERT-style models accept.
+const onnxMaxTokens = 512
+
+// ONNXEmbedder runs a sentence-transformers model exported to ONNX locally
+// with ONNX Runtime. Token embeddings are mean-pooled over the attention mask
+// and L2-normalized, unless the model already outputs sentence embeddings.
+type ONNXEmbedder struct {
+	mu        sync.Mutex
+	session   *ort.DynamicAdvancedSession
+	tokenizer *WordPieceTokenizer
+	inputs    []string
+	pooled    bool // the output is [batch, dim] rather than [batch, tokens, dim]
+	dimension int
+}
+
+// NewONNXEmbedder loads the model at modelPath and its WordPiece vocabulary.
+// libraryPath locates the ONNX Runtime shared library; if empty, the
+// platform's default library name is used.
+func NewONNXEmbedder(modelPath, vocabPath, libraryPath string) (Embedder, error) {
+	tokenizer, err := LoadWordPieceTokenizer(vocabPath)
+	if err != nil {
+		return nil, err
+	}
+	if !ort.IsInitialized() {
+		if libraryPath != "" {
+			ort.SetSharedLibraryPath(libraryPath)
+		}
+		if err := ort.InitializeEnvironment(); err != nil {
+			return nil, fmt.Errorf("initializing ONNX Runtime: %w", err)
+		}
+	}
+
+	inputInfo, outputInfo, err := ort.GetInputOutputInfo(modelPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading ONNX model %s: %w", modelPath, err)
+	}
+	if len(outputInfo) == 0 {
+		return nil, fmt.Errorf("ONNX model %s has no outputs", modelPath)
+	}
+	var inputs []string
+	for _, info := range inputInfo {
+		switch info.Name {
+		case "input_ids", "attention_mask", "token_type_ids":
+			inputs = append(inputs, info.Name)
+		default:
+			return nil, fmt.Errorf("ONNX model %s has unsupported input %q", modelPath, info.Name)
+		}
+	}
+	output := outputInfo[0]
+	dims := output.Dimensions
+	if len(dims) < 2 || dims[len(dims)-1] <= 0 {
+		return nil, fmt.Errorf("ONNX model %s output %q has no fixed embedding dimension", modelPath, output.Name)
+	}
+
+	session, err := ort.NewDynamicAdvancedSession(modelPath, inputs, []string{output.Name}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("loading ONNX model %s: %w", modelPath, err)
+	}
+	return &ONNXEmbedder{
+		session:   session,
+		tokenizer: tokenizer,
+		inputs:    inputs,
+		pooled:    len(dims) == 2,
+		dimension: int(dims[len(dims)-1]),
+	}, nil
+}
+
+func (o *ONNXEmbedder) Dimension() int {
+	return o.dimension
+}
+
+func (o *ONNXEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+
+	// Pad the batch to its longest input.
+	encoded := make([][]int64, len(texts))
+	seqLen := 0
+	for i, text := range texts {
+		encoded[i] = o.tokenizer.Encode(text, onnxMaxTokens)
+		seqLen = max(seqLen, len(encoded[i]))
+	}
+	batch := int64(len(texts))
+	ids := make([]int64, len(texts)*seqLen)
+	mask := make([]int64, len(texts)*seqLen)
+	for i, tokens := range encoded {
+		for j, id := range tokens {
+			ids[i*seqLen+j] = id
+			mask[i*seqLen+j] = 1
+		}
+	}
+
+	shape := ort.NewShape(batch, int64(seqLen))
+	values := make([]ort.Value, 0, len(o.inputs))
+	defer func() {
+		for _, value := range values {
+			value.Destroy()
+		}
+	}()
+	for _, name := range o.inputs {
+		data := ids
+		switch name {
+		case "attention_mask":
+			data = mask
+		case "token_type_ids":
+			data = make([]int64, len(ids))
+		}
+		tensor, err := ort.NewTensor(shape, data)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, tensor)
+	}
+
+	outShape := ort.NewShape(batch, int64(seqLen), int64(o.dimension))
+	if o.pooled {
+		outShape = ort.NewShape(batch, int64(o.dimension))
+	}
+	output, err := ort.NewEmptyTensor[float32](outShape)
+	if err != nil {
+		return nil, err
+	}
+	defer output.Destroy()
+
+	o.mu.Lock()
+	err = o.session.Run(values, []ort.Value{output})
+	o.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("running ONNX model: %w", err)
+	}
+
+	data := output.GetData()
+	embeddings := make([][]float32, len(texts))
+	for i := range texts {
+		embedding := make([]float32, o.dimension)
+		if o.pooled {
+			copy(embedding, data[i*o.dimension:])
+		} else {
+			for j := range encoded[i] {
+				token := data[(i*seqLen+j)*o.dimension:]
+				for k := range embedding {
+					embedding[k] += token[k]
+				}
+			}
+			for k := range embedding {
+				embedding[k] /= float32(len(encoded[i]))
+			}
+		}
+		embeddings[i] = normalized(embedding)
+	}
+	return embeddings, nil
+}
@@ -0,0 +1,11 @@
+//go:build !onnx
+
+package main
+
+import "errors"
+
+// NewONNXEmbedder is only available in builds with the onnx tag, which links
+// against ONNX Runtime through cgo.
+func NewONNXEmbedder(modelPath, vocabPath, libraryPath string) (Embedder, error) {
+	return nil, errors.New("ONNX embeddings are not compiled in; rebuild with -tags onnx")
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+//go:generate go run . openapi --client client/client.go
+
+// apiParam is a path or query parameter of an API operation.
+type apiParam struct {
+	Name        string
+	In          string // "path" or "query"
+	Type        string // "string", "integer", "number", or "date-time"
+	Required    bool
+	Description string
+}
+
+// apiOperation describes a route of the Server. It is the source of the
+// OpenAPI spec served at /openapi.json and of the Go client in client/, so
+// both follow the request and response types the handlers use.
+type apiOperation struct {
+	Method  string
+	Path    string
+	ID      string // the operationId and client method; empty leaves the route out of the client
+	Summary string
+	Params  []apiParam
+	Request any // a value of the JSON request body type, or nil
+	// Response is a value of the JSON response type, or nil if the response
+	// has no body or, with ContentType, is not JSON.
+	Response    any
+	ContentType string
+	Status      int
+	// Moderated operations answer 422 with a policy error when moderation
+	// flags the question or the answer.
+	Moderated bool
+}
+
+// queryStreamParams are the query parameters of GET /query/stream.
+var queryStreamParams = []apiParam{
+	{Name: "question", In: "query", Type: "string", Required: true},
+	{Name: "limit", In: "query", Type: "integer", Description: "the number of documents to retrieve, default 3"},
+	{Name: "filter", In: "query", Type: "string", Description: "a filter expression (see ParseFilter)"},
+	{Name: "partitions", In: "query", Type: "string", Description: "comma-separated partitions to search, default all"},
+	{Name: "languages", In: "query", Type: "string", Description: "comma-separated languages (ISO 639-1) to search, default all"},
+	{Name: "model", In: "query", Type: "string", Description: "the chat model, instead of the server's"},
+	{Name: "pipeline", In: "query", Type: "string", Description: "the pipeline of the running experiment to answer with, instead of the assigned one"},
+}
+
+var apiOperations = []apiOperation{
+	{Method: "POST", Path: "/query", ID: "Query", Summary: "Answer a question from the knowledge base",
+		Request: queryRequest{}, Response: queryResponse{}, Status: http.StatusOK, Moderated: true},
+	{Method: "POST", Path: "/query/stream", Summary: "Answer a question as server-sent events: status, token, and a final citations or error event",
+		Request: queryRequest{}, ContentType: "text/event-stream", Status: http.StatusOK},
+	{Method: "GET", Path: "/query/stream", Summary: "Answer a question as server-sent events, for EventSource clients",
+		Params: queryStreamParams, ContentType: "text/event-stream", Status: http.StatusOK},
+	{Method: "GET", Path: "/chat", Summary: "Multi-turn chat over a WebSocket, one conversation per connection",
+		Params: []apiParam{{Name: "api_key", In: "query", Type: "string", Description: "the API key, for clients that cannot set headers"}},
+		Status: http.StatusSwitchingProtocols},
+	{Method: "POST", Path: "/summarize", ID: "Summarize", Summary: "Summarize every chunk of a source, by map-reduce or refinement",
+		Request: summarizeRequest{}, Response: summarizeResponse{}, Status: http.StatusOK},
+	{Method: "POST", Path: "/documents", ID: "AddDocuments", Summary: "Chunk and ingest documents",
+		Request: documentsRequest{}, Response: documentsResponse{}, Status: http.StatusCreated},
+	{Method: "DELETE", Path: "/documents", ID: "DeleteDocuments", Summary: "Remove every chunk of a source",
+		Params: []apiParam{{Name: "source", In: "query", Type: "string", Required: true}}, Status: http.StatusNoContent},
+	{Method: "POST", Path: "/jobs", ID: "SubmitJob", Summary: "Queue documents for ingestion in the background",
+		Request: documentsRequest{}, Response: IngestJob{}, Status: http.StatusAccepted},
+	{Method: "GET", Path: "/jobs", ID: "Jobs", Summary: "Recent ingestion jobs, newest first",
+		Params: []apiParam{
+			{Name: "limit", In: "query", Type: "integer", Description: "the maximum number of jobs (1-100), default 20"},
+			{Name: "status", In: "query", Type: "string", Description: "queued, running, succeeded, failed, or canceled"},
+		},
+		Response: jobListJSON{}, Status: http.StatusOK},
+	{Method: "GET", Path: "/jobs/{id}", ID: "Job", Summary: "An ingestion job's status and progress",
+		Params:   []apiParam{{Name: "id", In: "path", Type: "string", Required: true}},
+		Response: IngestJob{}, Status: http.StatusOK},
+	{Method: "POST", Path: "/jobs/{id}/cancel", ID: "CancelJob", Summary: "Cancel a queued or running ingestion job; 409 Conflict if it already finished",
+		Params:   []apiParam{{Name: "id", In: "path", Type: "string", Required: true}},
+		Response: IngestJob{}, Status: http.StatusOK},
+	{Method: "GET", Path: "/images/{id}", Summary: "An ingested image or PDF figure, as linked by a citation's image_url",
+		Params:      []apiParam{{Name: "id", In: "path", Type: "string", Required: true}},
+		ContentType: "image/*", Status: http.StatusOK},
+	{Method: "GET", Path: "/usage", ID: "Usage", Summary: "Cumulative token usage and estimated cost",
+		Response: UsageReport{}, Status: http.StatusOK},
+	{Method: "GET", Path: "/history", ID: "History", Summary: "Recent queries, newest first",
+		Params: []apiParam{
+			{Name: "limit", In: "query", Type: "integer", Description: "the maximum number of queries (1-100), default 20"},
+			{Name: "search", In: "query", Type: "string", Description: "text the questions must contain"},
+			{Name: "before", In: "query", Type: "date-time", Description: "the time the queries must be older than, for paging"},
+		},
+		Response: historyListJSON{}, Status: http.StatusOK},
+	{Method: "GET", Path: "/history/{id}", ID: "HistoryQuery", Summary: "A query with its context, prompt, and answer",
+		Params:   []apiParam{{Name: "id", In: "path", Type: "string", Required: true}},
+		Response: historyEntryJSON{}, Status: http.StatusOK},
+	{Method: "POST", Path: "/feedback", ID: "Feedback", Summary: "Rate a query's answer or correct it",
+		Request: feedbackJSON{}, Status: http.StatusNoContent},
+	{Method: "GET", Path: "/feedback/report", ID: "FeedbackReport", Summary: "Queries with negative feedback or weak context",
+		Params: []apiParam{
+			{Name: "min_similarity", In: "query", Type: "number", Description: "the similarity (0-1) below which an answer's best context is flagged, default 0.5"},
+			{Name: "since", In: "query", Type: "date-time", Description: "the time of the oldest query to review"},
+		},
+		Response: feedbackReportJSON{}, Status: http.StatusOK},
+	{Method: "GET", Path: "/metrics", Summary: "Prometheus metrics", ContentType: "text/plain", Status: http.StatusOK},
+	{Method: "GET", Path: "/healthz", Summary: "Liveness: the process is serving", ContentType: "application/json", Status: http.StatusOK},
+	{Method: "GET", Path: "/readyz", Summary: "Readiness: every dependency check passes; 503 Service Unavailable with the same body otherwise",
+		Response: ReadinessReport{}, Status: http.StatusOK},
+	{Method: "GET", Path: "/openapi.json", Summary: "This OpenAPI specification", ContentType: "application/json", Status: http.StatusOK},
+}
+
+// apiField is a field of an API type as encoding/json reads and writes it.
+type apiField struct {
+	GoName    string
+	JSONName  string
+	Type      reflect.Type
+	OmitEmpty bool
+}
+
+// apiFields returns the JSON fields of struct type t, with the fields of
+// embedded structs promoted as encoding/json does.
+func apiFields(t reflect.Type) []apiField {
+	var fields []apiField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && tag == "" {
+			fields = append(fields, apiFields(f.Type)...)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+		omit := strings.Contains(options, "omitempty") || strings.Contains(options, "omitzero")
+		fields = append(fields, apiField{GoName: f.Name, JSONName: name, Type: f.Type, OmitEmpty: omit})
+	}
+	return fields
+}
+
+// apiTypeName is the name of an API struct type in the spec and the client:
+// its Go name, exported, without a JSON suffix (citationJSON is Citation).
+func apiTypeName(t reflect.Type) string {
+	name := []rune(strings.TrimSuffix(t.Name(), "JSON"))
+	name[0] = unicode.ToUpper(name[0])
+	return string(name)
+}
+
+var timeType = reflect.TypeFor[time.Time]()
+
+// jsonSchema returns the schema of the JSON encoding of type t. Structs are
+// added to schemas under their apiTypeName and referred to.
+func jsonSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return jsonSchema(t.Elem(), schemas)
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int32:
+		return map[string]any{"type": "integer"}
+	case reflect.Int64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32:
+		return map[string]any{"type": "number", "format": "float"}
+	case reflect.Float64:
+		return map[string]any{"type": "number", "format": "double"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": jsonSchema(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": jsonSchema(t.Elem(), schemas)}
+	case reflect.Struct:
+		name := apiTypeName(t)
+		if _, ok := schemas[name]; !ok {
+			schemas[name] = nil // reserve the name while the fields are described
+			properties := map[string]any{}
+			var required []string
+			for _, f := range apiFields(t) {
+				properties[f.JSONName] = jsonSchema(f.Type, schemas)
+				if !f.OmitEmpty {
+					required = append(required, f.JSONName)
+				}
+			}
+			schema := map[string]any{"type": "object", "properties": properties}
+			if len(required) > 0 {
+				schema["required"] = required
+			}
+			schemas[name] = schema
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{} // any JSON value
+}
+
+// paramSchema returns the schema of a parameter type.
+func paramSchema(typ string) map[string]any {
+	if typ == "date-time" {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	return map[string]any{"type": typ}
+}
+
+// OpenAPISpec returns the OpenAPI 3 specification of the HTTP API.
+func OpenAPISpec() map[string]any {
+	schemas := map[string]any{}
+	errorResponse := map[string]any{
+		"description": "An error",
+		"content":     map[string]any{"application/json": map[string]any{"schema": jsonSchema(reflect.TypeFor[errorJSON](), schemas)}},
+	}
+	paths := map[string]any{}
+	for _, op := range apiOperations {
+		operation := map[string]any{"summary": op.Summary}
+		if op.ID != "" {
+			operation["operationId"] = op.ID
+		}
+		var params []any
+		for _, p := range op.Params {
+			param := map[string]any{"name": p.Name, "in": p.In, "required": p.Required, "schema": paramSchema(p.Type)}
+			if p.Description != "" {
+				param["description"] = p.Description
+			}
+			params = append(params, param)
+		}
+		if params != nil {
+			operation["parameters"] = params
+		}
+		if op.Request != nil {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content":  map[string]any{"application/json": map[string]any{"schema": jsonSchema(reflect.TypeOf(op.Request), schemas)}},
+			}
+		}
+		success := map[string]any{"description": http.StatusText(op.Status)}
+		switch {
+		case op.Response != nil:
+			success["content"] = map[string]any{"application/json": map[string]any{"schema": jsonSchema(reflect.TypeOf(op.Response), schemas)}}
+		case op.ContentType != "":
+			success["content"] = map[string]any{op.ContentType: map[string]any{"schema": map[string]any{"type": "string"}}}
+		}
+		responses := map[string]any{strconv.Itoa(op.Status): success, "default": errorResponse}
+		if op.Moderated {
+			responses["422"] = map[string]any{
+				"description": "Moderation flagged the question or the answer",
+				"content":     map[string]any{"application/json": map[string]any{"schema": jsonSchema(reflect.TypeFor[policyErrorJSON](), schemas)}},
+			}
+		}
+		operation["responses"] = responses
+
+		item, _ := paths[op.Path].(map[string]any)
+		if item == nil {
+			item = map[string]any{}
+			paths[op.Path] = item
+		}
+		item[strings.ToLower(op.Method)] = operation
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "rag-example API",
+			"version": "1.0.0",
+			"description": "Question answering over a knowledge base with retrieval-augmented generation. " +
+				"With API keys configured, every operation except /metrics, /openapi.json, /healthz, and /readyz requires a key.",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"bearer": map[string]any{"type": "http", "scheme": "bearer"},
+				"apiKey": map[string]any{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			},
+		},
+		"security": []any{map[string]any{"bearer": []string{}}, map[string]any{"apiKey": []string{}}, map[string]any{}},
+	}
+}
+
+var openAPIJSON = sync.OnceValue(func() []byte {
+	data, _ := json.MarshalIndent(OpenAPISpec(), "", "  ")
+	return data
+})
+
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIJSON())
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// generateGoClient returns the source of the Go client package in client/,
+// with a method for every apiOperation that has an ID and a type for every
+// struct those methods send or receive.
+func generateGoClient() ([]byte, error) {
+	types := map[string]reflect.Type{}
+	var collect func(t reflect.Type)
+	collect = func(t reflect.Type) {
+		switch t.Kind() {
+		case reflect.Pointer, reflect.Slice, reflect.Map:
+			collect(t.Elem())
+		case reflect.Struct:
+			if t == timeType || types[apiTypeName(t)] != nil {
+				return
+			}
+			types[apiTypeName(t)] = t
+			for _, f := range apiFields(t) {
+				collect(f.Type)
+			}
+		}
+	}
+	for _, op := range apiOperations {
+		if op.ID == "" {
+			continue
+		}
+		for _, v := range []any{op.Request, op.Response} {
+			if v != nil {
+				collect(reflect.TypeOf(v))
+			}
+		}
+	}
+
+	var b bytes.Buffer
+	b.WriteString(clientPreamble)
+	for _, op := range apiOperations {
+		if op.ID != "" {
+			writeClientMethod(&b, op)
+		}
+	}
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "\ntype %s struct {\n", name)
+		for _, f := range apiFields(types[name]) {
+			tag := f.JSONName
+			if f.OmitEmpty {
+				tag += ",omitempty"
+				if f.Type == timeType {
+					tag = f.JSONName + ",omitzero"
+				}
+			}
+			fmt.Fprintf(&b, "\t%s %s `json:%q`\n", f.GoName, goTypeName(f.Type), tag)
+		}
+		b.WriteString("}\n")
+	}
+	return format.Source(b.Bytes())
+}
+
+// goTypeName is how the client declares a value of type t.
+func goTypeName(t reflect.Type) string {
+	if t == timeType {
+		return "time.Time"
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return "*" + goTypeName(t.Elem())
+	case reflect.Slice:
+		return "[]" + goTypeName(t.Elem())
+	case reflect.Map:
+		return "map[string]" + goTypeName(t.Elem())
+	case reflect.Interface:
+		return "any"
+	case reflect.Struct:
+		return apiTypeName(t)
+	}
+	return t.Kind().String()
+}
+
+// paramGoName converts a parameter name such as min_similarity to
+// MinSimilarity.
+func paramGoName(name string) string {
+	var b strings.Builder
+	for _, part := range strings.Split(name, "_") {
+		if part != "" {
+			b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	return b.String()
+}
+
+// paramGoType is the client's type for a parameter type.
+func paramGoType(typ string) string {
+	switch typ {
+	case "integer":
+		return "int"
+	case "number":
+		return "float64"
+	case "date-time":
+		return "time.Time"
+	}
+	return "string"
+}
+
+// writeClientMethod writes the client method of op. Path parameters and
+// required query parameters become arguments; optional query parameters are
+// fields of an options struct named after the method.
+func writeClientMethod(b *bytes.Buffer, op apiOperation) {
+	args := []string{"ctx context.Context"}
+	path := fmt.Sprintf("%q", op.Path)
+	var optional []apiParam
+	for _, p := range op.Params {
+		arg := strings.ToLower(p.Name[:1]) + paramGoName(p.Name)[1:]
+		switch {
+		case p.In == "path":
+			args = append(args, arg+" string")
+			path = strings.Replace(path, "{"+p.Name+"}", `" + url.PathEscape(`+arg+`) + "`, 1)
+			path = strings.TrimSuffix(path, ` + ""`)
+		case p.Required:
+			args = append(args, arg+" "+paramGoType(p.Type))
+		default:
+			optional = append(optional, p)
+		}
+	}
+	if op.Request != nil {
+		args = append(args, "req "+goTypeName(reflect.TypeOf(op.Request)))
+	}
+	if optional != nil {
+		fmt.Fprintf(b, "\n// %sOptions are the optional parameters of %s.\ntype %sOptions struct {\n", op.ID, op.ID, op.ID)
+		for _, p := range optional {
+			if p.Description != "" {
+				fmt.Fprintf(b, "\t// %s is %s.\n", paramGoName(p.Name), p.Description)
+			}
+			fmt.Fprintf(b, "\t%s %s\n", paramGoName(p.Name), paramGoType(p.Type))
+		}
+		b.WriteString("}\n")
+		args = append(args, "opts "+op.ID+"Options")
+	}
+	result := "error"
+	if op.Response != nil {
+		result = "(*" + goTypeName(reflect.TypeOf(op.Response)) + ", error)"
+	}
+
+	fmt.Fprintf(b, "\n// %s calls %s %s: %s.\n", op.ID, op.Method, op.Path, strings.ToLower(op.Summary[:1])+op.Summary[1:])
+	fmt.Fprintf(b, "func (c *Client) %s(%s) %s {\n", op.ID, strings.Join(args, ", "), result)
+	b.WriteString("\tquery := url.Values{}\n")
+	for _, p := range op.Params {
+		if p.In != "query" {
+			continue
+		}
+		value := strings.ToLower(p.Name[:1]) + paramGoName(p.Name)[1:]
+		if !p.Required {
+			value = "opts." + paramGoName(p.Name)
+		}
+		var set string
+		switch p.Type {
+		case "integer":
+			set = fmt.Sprintf("if %s != 0 {\nquery.Set(%q, strconv.Itoa(%s))\n}\n", value, p.Name, value)
+		case "number":
+			set = fmt.Sprintf("if %s != 0 {\nquery.Set(%q, strconv.FormatFloat(%s, 'g', -1, 64))\n}\n", value, p.Name, value)
+		case "date-time":
+			set = fmt.Sprintf("if !%s.IsZero() {\nquery.Set(%q, %s.Format(time.RFC3339Nano))\n}\n", value, p.Name, value)
+		default:
+			set = fmt.Sprintf("if %s != \"\" {\nquery.Set(%q, %s)\n}\n", value, p.Name, value)
+		}
+		b.WriteString(set)
+	}
+	body := "nil"
+	if op.Request != nil {
+		body = "req"
+	}
+	if op.Response == nil {
+		fmt.Fprintf(b, "return c.do(ctx, %q, %s, query, %s, nil)\n}\n", op.Method, path, body)
+		return
+	}
+	fmt.Fprintf(b, "var resp %s\n", goTypeName(reflect.TypeOf(op.Response)))
+	fmt.Fprintf(b, "if err := c.do(ctx, %q, %s, query, %s, &resp); err != nil {\nreturn nil, err\n}\nreturn &resp, nil\n}\n", op.Method, path, body)
+}
+
+const clientPreamble = `// Code generated by "rag openapi --client"; DO NOT EDIT.
+
+// Package client is a typed Go client for the HTTP API of ` + "`rag serve`" + `. It
+// is generated from the same description of the API as the OpenAPI
+// specification the server publishes at /openapi.json.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client calls the API of a rag server.
+type Client struct {
+	// BaseURL is the server's URL, e.g. "http://localhost:8080".
+	BaseURL string
+	// APIKey is sent as a bearer token if set.
+	APIKey string
+	// HTTPClient sends the requests; nil means http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// New returns a client for the server at baseURL, authenticating with apiKey
+// unless it is empty.
+func New(baseURL, apiKey string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), APIKey: apiKey}
+}
+
+// Error is the error returned for a response with an error status.
+type Error struct {
+	StatusCode int
+	Message    string
+	// Stage and Categories are set when moderation flagged the question
+	// ("query") or the answer ("answer").
+	Stage      string
+	Categories []string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rag API: %d: %s", e.StatusCode, e.Message)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	target := c.BaseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		apiErr := &Error{StatusCode: resp.StatusCode, Message: resp.Status}
+		var payload struct {
+			Error      string   ` + "`json:\"error\"`" + `
+			Stage      string   ` + "`json:\"stage\"`" + `
+			Categories []string ` + "`json:\"categories\"`" + `
+		}
+		if json.NewDecoder(resp.Body).Decode(&payload) == nil && payload.Error != "" {
+			apiErr.Message, apiErr.Stage, apiErr.Categories = payload.Error, payload.Stage, payload.Categories
+		}
+		return apiErr
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+`
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"rag-example/client"
+)
+
+func TestOpenAPISpecMatchesRoutes(t *testing.T) {
+	server, _ := newTestServer()
+	for _, op := range apiOperations {
+		req := httptest.NewRequest(op.Method, strings.Replace(op.Path, "{id}", "q1", 1), nil)
+		if _, pattern := server.mux.Handler(req); pattern != op.Method+" "+op.Path {
+			t.Errorf("%s %s is documented but routed to %q", op.Method, op.Path, pattern)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	var spec struct {
+		OpenAPI string `json:"openapi"`
+		Paths   map[string]map[string]struct {
+			OperationID string `json:"operationId"`
+		} `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Required   []string                  `json:"required"`
+				Properties map[string]map[string]any `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("decoding the spec: %v", err)
+	}
+	if spec.OpenAPI != "3.0.3" || spec.Paths["/history/{id}"]["get"].OperationID != "HistoryQuery" {
+		t.Fatalf("unexpected spec %+v", spec)
+	}
+	request := spec.Components.Schemas["QueryRequest"]
+	if strings.Join(request.Required, ",") != "question" || request.Properties["limit"]["type"] != "integer" {
+		t.Fatalf("unexpected QueryRequest schema %+v", request)
+	}
+	// Embedded fields are promoted, and nested structs are referenced.
+	item := spec.Components.Schemas["ReviewItem"]
+	if item.Properties["question"] == nil || item.Properties["feedback"]["$ref"] != "#/components/schemas/Feedback" {
+		t.Fatalf("unexpected ReviewItem schema %+v", item)
+	}
+}
+
+func TestGeneratedClientIsCurrent(t *testing.T) {
+	want, err := generateGoClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile("client/client.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Fatal("client/client.go is out of date; run `go generate`")
+	}
+}
+
+func TestClientCallsServer(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(NewHashingEmbedder(256))
+	engine := NewRAGEngine(&scriptedOpenAI{reply: "Go was made at Google [1]."}, store, WithHistory(newTestHistory(t)))
+	s := NewServer(engine, "gpt-test")
+	if err := s.RequireAPIKeys([]APIKey{{Key: "sk-acme", Tenant: "acme"}}); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(s)
+	defer server.Close()
+	c := client.New(server.URL, "sk-acme")
+
+	added, err := c.AddDocuments(ctx, client.DocumentsRequest{Documents: []client.Document{{Text: "Go is a language from Google.", Source: "Go Docs"}}})
+	if err != nil || added.Inserted != 1 {
+		t.Fatalf("AddDocuments = %+v, %v", added, err)
+	}
+	answer, err := c.Query(ctx, client.QueryRequest{Question: "Who made Go?", Limit: 1})
+	if err != nil || answer.Answer != "Go was made at Google [1]." || len(answer.Citations) != 1 || answer.Citations[0].Source != "Go Docs" {
+		t.Fatalf("Query = %+v, %v", answer, err)
+	}
+	if err := c.Feedback(ctx, client.Feedback{QueryID: answer.QueryID, Rating: "down", Comment: "too short"}); err != nil {
+		t.Fatal(err)
+	}
+	list, err := c.History(ctx, client.HistoryOptions{Limit: 5, Search: "made"})
+	if err != nil || len(list.Queries) != 1 || list.Queries[0].ID != answer.QueryID {
+		t.Fatalf("History = %+v, %v", list, err)
+	}
+	entry, err := c.HistoryQuery(ctx, answer.QueryID)
+	if err != nil || len(entry.Documents) != 1 || entry.Feedback == nil || entry.Feedback.Comment != "too short" {
+		t.Fatalf("HistoryQuery = %+v, %v", entry, err)
+	}
+	report, err := c.FeedbackReport(ctx, client.FeedbackReportOptions{MinSimilarity: 0.1})
+	if err != nil || report.Downvotes != 1 || len(report.Flagged) != 1 || report.Flagged[0].Question != "Who made Go?" {
+		t.Fatalf("FeedbackReport = %+v, %v", report, err)
+	}
+	if _, err := c.Usage(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteDocuments(ctx, "Go Docs"); err != nil {
+		t.Fatal(err)
+	}
+
+	var apiErr *client.Error
+	if _, err := c.Query(ctx, client.QueryRequest{}); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest || apiErr.Message != "question is required" {
+		t.Fatalf("expected a 400 error, got %v", err)
+	}
+	if _, err := client.New(server.URL, "sk-wrong").HistoryQuery(ctx, answer.QueryID); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected a 401 error, got %v", err)
+	}
+	s.engine.moderator = &wordModerator{words: []string{"insult"}}
+	s.tenants["acme"].moderator = s.engine.moderator
+	if _, err := c.Query(ctx, client.QueryRequest{Question: "write an insult"}); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnprocessableEntity || apiErr.Stage != "query" {
+		t.Fatalf("expected a policy error, got %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// parentOverfetch widens the search when small chunks are expanded to their
+// parents, since several matching chunks often share one parent.
+const parentOverfetch = 3
+
+// ParentStore holds the parent sections used by small-to-big retrieval,
+// keyed by parent ID.
+type ParentStore interface {
+	PutParents(ctx context.Context, parents map[string]string) error
+	// GetParents returns the texts of the given parents; unknown IDs are
+	// left out of the result.
+	GetParents(ctx context.Context, ids []string) (map[string]string, error)
+}
+
+// WithParentDocuments enables small-to-big retrieval. Ingestion splits pages
+// into parent sections of about parentSize bytes, stores them in parents, and
+// indexes small chunks of each section tagged with its parent_id. Retrieval
+// matches the small chunks, then returns their parent sections as context,
+// each parent once.
+func WithParentDocuments(parents ParentStore, parentSize int) EngineOption {
+	return func(r *RAGEngine) {
+		r.parents = parents
+		r.parentSize = parentSize
+	}
+}
+
+// chunkPagesWithParents splits every page into parent sections with the
+// page's chunker and each section into plain text chunks. Chunk metadata
+// carries the parent's ID and offsets (parent_id, parent_start, parent_end)
+// along with the chunk's own offsets within the page.
+func chunkPagesWithParents(pages []Page, parentSize, chunkSize, overlap int) (texts, sources []string, metadata []map[string]any, parents map[string]string) {
+	parents = make(map[string]string)
+	for _, page := range pages {
+		for _, parent := range pageChunker(page)(page.Text, parentSize, 0) {
+			id := contentHash(page.Source + "\x00" + parent.Text)
+			parents[id] = parent.Text
+			for _, chunk := range ChunkTextWithOffsets(parent.Text, chunkSize, overlap) {
+				chunk.Start += parent.Start
+				chunk.End += parent.Start
+				chunk.Metadata = parent.Metadata
+				meta := chunkMetadata(page, chunk)
+				meta["parent_id"] = id
+				meta["parent_start"] = parent.Start
+				meta["parent_end"] = parent.End
+
+				texts = append(texts, chunk.Text)
+				sources = append(sources, page.Source)
+				metadata = append(metadata, meta)
+			}
+		}
+	}
+	return texts, sources, metadata, parents
+}
+
+// expandToParents replaces each chunk with its parent section, keeping the
+// first (best-ranked) chunk of each parent. Chunks without a stored parent
+// are kept as they are.
+func (r *RAGEngine) expandToParents(ctx context.Context, docs []Document) []Document {
+	var ids []string
+	for _, doc := range docs {
+		if id, ok := doc.Metadata["parent_id"].(string); ok {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return docs
+	}
+
+	ctx, span := tracer.Start(ctx, "rag.parent_lookup")
+	texts, err := r.parents.GetParents(ctx, ids)
+	endSpan(span, err)
+	if err != nil {
+		slog.WarnContext(ctx, "Could not look up parent sections, using the matched chunks", "error", err)
+		return docs
+	}
+
+	seen := make(map[string]bool)
+	expanded := make([]Document, 0, len(docs))
+	for _, doc := range docs {
+		id, _ := doc.Metadata["parent_id"].(string)
+		text, ok := texts[id]
+		if !ok {
+			expanded = append(expanded, doc)
+			continue
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		meta := make(map[string]any, len(doc.Metadata))
+		for k, v := range doc.Metadata {
+			meta[k] = v
+		}
+		meta["chunk_start"] = doc.Metadata["parent_start"]
+		meta["chunk_end"] = doc.Metadata["parent_end"]
+		doc.Text = text
+		doc.Metadata = meta
+		expanded = append(expanded, doc)
+	}
+	span.SetAttributes(attribute.Int("rag.parents", len(seen)))
+	slog.DebugContext(ctx, "Expanded chunks to parent sections", "chunks", len(docs), "documents", len(expanded))
+	return expanded
+}
+
+// MemoryParentStore keeps parent sections in memory, for tests and the
+// in-memory vector store.
+type MemoryParentStore struct {
+	mu    sync.RWMutex
+	texts map[string]string
+}
+
+// NewMemoryParentStore creates an empty in-memory parent store.
+func NewMemoryParentStore() *MemoryParentStore {
+	return &MemoryParentStore{texts: make(map[string]string)}
+}
+
+func (m *MemoryParentStore) PutParents(ctx context.Context, parents map[string]string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, text := range parents {
+		m.texts[id] = text
+	}
+	return nil
+}
+
+func (m *MemoryParentStore) GetParents(ctx context.Context, ids []string) (map[string]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	found := make(map[string]string, len(ids))
+	for _, id := range ids {
+		if text, ok := m.texts[id]; ok {
+			found[id] = text
+		}
+	}
+	return found, nil
+}
+
+// FileParentStore keeps each parent section in a text file under dir, so
+// parents written by `rag ingest` are available to later queries.
+type FileParentStore struct {
+	dir string
+}
+
+// NewFileParentStore creates dir if needed and stores parents in it.
+func NewFileParentStore(dir string) (*FileParentStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileParentStore{dir: dir}, nil
+}
+
+// path shards parents into subdirectories by ID prefix.
+func (f *FileParentStore) path(id string) string {
+	return filepath.Join(f.dir, id[:2], id+".txt")
+}
+
+func (f *FileParentStore) PutParents(ctx context.Context, parents map[string]string) error {
+	for id, text := range parents {
+		path := f.path(id)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, []byte(text), 0o644); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FileParentStore) GetParents(ctx context.Context, ids []string) (map[string]string, error) {
+	found := make(map[string]string, len(ids))
+	for _, id := range ids {
+		if len(id) < 2 {
+			continue
+		}
+		data, err := os.ReadFile(f.path(id))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found[id] = string(data)
+	}
+	return found, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestChunkPagesWithParents(t *testing.T) {
+	page := Page{Text: "Alpha one. Alpha two. Alpha three. Beta one. Beta two.", Source: "doc", Metadata: map[string]any{"page": 1}}
+	texts, _, metadata, parents := chunkPagesWithParents([]Page{page}, 35, 12, 0)
+	if len(parents) != 2 {
+		t.Fatalf("expected 2 parent sections, got %d", len(parents))
+	}
+	for i, text := range texts {
+		meta := metadata[i]
+		parent := parents[meta["parent_id"].(string)]
+		if !strings.Contains(parent, text) {
+			t.Errorf("chunk %q is not part of its parent %q", text, parent)
+		}
+		if page.Text[meta["chunk_start"].(int):meta["chunk_end"].(int)] != text {
+			t.Errorf("chunk offsets of %q do not point into the page", text)
+		}
+		if page.Text[meta["parent_start"].(int):meta["parent_end"].(int)] != parent {
+			t.Errorf("parent offsets of %q do not point into the page", parent)
+		}
+		if meta["page"] != 1 {
+			t.Errorf("page metadata was not copied: %v", meta)
+		}
+	}
+}
+
+func TestRetrieveReturnsParentSections(t *testing.T) {
+	store := NewMemoryStore(NewHashingEmbedder(256))
+	engine := NewRAGEngine(&dummyOpenAI{}, store, WithParentDocuments(NewMemoryParentStore(), 200))
+	text := "Goroutines are cheap. Goroutines are scheduled by the runtime. Channels connect goroutines.\n" +
+		strings.Repeat("Unrelated filler about databases and indexes. ", 5)
+	if _, ok := ingestPages(context.Background(), engine, []Page{{Text: text, Source: "go.md"}}, 30, 0); !ok {
+		t.Fatal("ingest failed")
+	}
+
+	docs := engine.Retrieve(context.Background(), "goroutines", 2)
+	if len(docs) == 0 {
+		t.Fatal("expected results")
+	}
+	if !strings.Contains(docs[0].Text, "Goroutines are cheap.") || !strings.Contains(docs[0].Text, "Channels connect goroutines.") {
+		t.Fatalf("expected the parent section, got %q", docs[0].Text)
+	}
+	for _, doc := range docs[1:] {
+		if doc.Metadata["parent_id"] == docs[0].Metadata["parent_id"] {
+			t.Fatal("expected each parent section once")
+		}
+	}
+	if docs[0].Metadata["chunk_start"] != docs[0].Metadata["parent_start"] {
+		t.Fatalf("expected citation offsets of the parent, got %v", docs[0].Metadata)
+	}
+}
+
+func TestFileParentStore(t *testing.T) {
+	store, err := NewFileParentStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := contentHash("parent")
+	if err := store.PutParents(context.Background(), map[string]string{id: "parent text"}); err != nil {
+		t.Fatal(err)
+	}
+	found, err := store.GetParents(context.Background(), []string{id, contentHash("missing")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[id] != "parent text" {
+		t.Fatalf("unexpected parents %v", found)
+	}
+}
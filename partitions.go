@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// partitionField is the metadata key naming the partition a document is
+// grouped in, such as a dataset or a month. Documents without it belong to
+// no partition and are only found by unscoped searches.
+const partitionField = "partition"
+
+// opIn is the Condition operator matching documents whose field equals one
+// of the condition's []string value. It backs partition scoping and cannot
+// be written in ParseFilter syntax.
+const opIn = "in"
+
+// InPartitions is a condition matching documents stored in one of
+// partitions.
+func InPartitions(partitions []string) Condition {
+	return Condition{Field: partitionField, Op: opIn, Value: slices.Clone(partitions)}
+}
+
+// WithPartitions restricts retrieval to documents stored in one of
+// partitions. On Milvus collections with a partition key, the search only
+// visits the partitions holding them.
+func WithPartitions(partitions ...string) RetrieveOption {
+	return func(c *retrieveConfig) {
+		c.partitions = slices.Clone(partitions)
+	}
+}
+
+// parsePartitions splits a comma-separated list of partition names, which
+// follow the rules for tenant names. It returns nil for an empty list.
+func parsePartitions(list string) ([]string, error) {
+	var partitions []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || slices.Contains(partitions, name) {
+			continue
+		}
+		if !tenantNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid partition name %q (use letters, digits, '.', '_', and '-')", name)
+		}
+		partitions = append(partitions, name)
+	}
+	return partitions, nil
+}
+
+// WithIngestPartition returns a copy of the engine that stores the chunks it
+// ingests in partition, unless a page names its own in its metadata. An
+// empty partition leaves chunks unpartitioned.
+func (r *RAGEngine) WithIngestPartition(partition string) *RAGEngine {
+	scoped := *r
+	scoped.ingestPartition = partition
+	return &scoped
+}
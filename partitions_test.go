@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/milvus-io/milvus-sdk-go/v2/entity"
+)
+
+func TestWithPartitionsRestrictsRetrieval(t *testing.T) {
+	ctx := context.Background()
+	engine := NewRAGEngine(&dummyOpenAI{}, NewMemoryStore(NewHashingEmbedder(256)))
+	pages := []Page{
+		{Text: "Expenses are reimbursed within a week.", Source: "expenses.md"},
+		{Text: "Expenses over 500 need approval.", Source: "approvals.md", Metadata: map[string]any{"partition": "finance"}},
+	}
+	if _, ok := ingestPages(ctx, engine.WithIngestPartition("handbook"), pages, 1000, 0); !ok {
+		t.Fatal("ingestion failed")
+	}
+	if _, ok := ingestPages(ctx, engine, []Page{{Text: "Expenses are tracked in the ledger.", Source: "ledger.md"}}, 1000, 0); !ok {
+		t.Fatal("ingestion failed")
+	}
+
+	sources := func(opts ...RetrieveOption) []string {
+		var found []string
+		for _, doc := range engine.Retrieve(ctx, "expenses", 10, opts...) {
+			found = append(found, doc.Source)
+		}
+		slices.Sort(found)
+		return found
+	}
+	cases := []struct {
+		opts []RetrieveOption
+		want []string
+	}{
+		{nil, []string{"approvals.md", "expenses.md", "ledger.md"}},
+		{[]RetrieveOption{WithPartitions("handbook")}, []string{"expenses.md"}},
+		{[]RetrieveOption{WithPartitions("handbook", "finance")}, []string{"approvals.md", "expenses.md"}},
+		{[]RetrieveOption{WithPartitions("finance"), WithFilter(Filter{Eq("source", "expenses.md")})}, nil},
+		{[]RetrieveOption{WithPartitions("archive")}, nil},
+	}
+	for i, c := range cases {
+		if got := sources(c.opts...); !slices.Equal(got, c.want) {
+			t.Errorf("case %d: retrieved %v, want %v", i, got, c.want)
+		}
+	}
+
+	// Moving a document to another partition replaces its chunk.
+	report, _ := ingestPages(ctx, engine.WithIngestPartition("archive"), pages[:1], 1000, 0)
+	if report.Updated != 1 || report.Removed != 1 {
+		t.Fatalf("expected the chunk to be replaced, got %+v", report)
+	}
+	if got := sources(WithPartitions("archive")); !slices.Equal(got, []string{"expenses.md"}) {
+		t.Fatalf("expected the document in its new partition, got %v", got)
+	}
+}
+
+func TestParsePartitions(t *testing.T) {
+	got, err := parsePartitions(" handbook, 2024-q3,,handbook ")
+	if err != nil || !slices.Equal(got, []string{"handbook", "2024-q3"}) {
+		t.Fatalf("parsePartitions = %v, %v", got, err)
+	}
+	if got, err := parsePartitions(""); got != nil || err != nil {
+		t.Fatalf("expected no partitions, got %v, %v", got, err)
+	}
+	if _, err := parsePartitions("hand book"); err == nil {
+		t.Fatal("expected an invalid partition name to be rejected")
+	}
+}
+
+func TestInPartitionsTranslations(t *testing.T) {
+	filter := Filter{InPartitions([]string{"handbook", "finance"})}
+	if got, want := filter.milvusExpr(false), `metadata["partition"] in ["handbook", "finance"]`; got != want {
+		t.Errorf("milvusExpr = %s", got)
+	}
+	if got, want := filter.milvusExpr(true), `partition in ["handbook", "finance"]`; got != want {
+		t.Errorf("milvusExpr with a partition key = %s", got)
+	}
+
+	where, args := filter.sqlWhere([]any{"[0.1]"})
+	expected := "WHERE (jsonb_typeof(metadata -> $2) = 'string' AND $3::jsonb @> (metadata -> $2))"
+	if where != expected || args[1] != "partition" || args[2] != `["handbook","finance"]` {
+		t.Errorf("sqlWhere = %s %v", where, args)
+	}
+
+	native, _ := filter.qdrantFilter()
+	data, _ := json.Marshal(native)
+	if expected := `{"must":[{"key":"metadata.partition","match":{"any":["handbook","finance"]}}]}`; string(data) != expected {
+		t.Errorf("qdrantFilter = %s", data)
+	}
+}
+
+func TestMilvusCheckPartitionKey(t *testing.T) {
+	sdk := &fakeMilvusSDK{collections: []*entity.Collection{
+		{Name: "keyed", Schema: &entity.Schema{Fields: []*entity.Field{
+			{Name: "partition", DataType: entity.FieldTypeVarChar, IsPartitionKey: true},
+		}}},
+		{Name: "plain", Schema: &entity.Schema{}},
+	}}
+	ctx := context.Background()
+
+	cases := []struct {
+		collection string
+		configured bool
+		want       bool
+	}{
+		{"keyed", false, true},
+		{"plain", true, false},
+		{"new", true, true},
+		{"new", false, false},
+	}
+	for _, c := range cases {
+		store := &MilvusClientImpl{client: sdk, collectionName: c.collection, partitionKey: c.configured}
+		if err := store.CheckPartitionKey(ctx); err != nil || store.partitionKey != c.want {
+			t.Errorf("%s (configured %t): partitionKey = %t (%v), want %t", c.collection, c.configured, store.partitionKey, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// LoadPDF extracts the plain text of every page in a PDF file. Each page's
+// source is the file name and its metadata records the page number, so
+// answers can point back to the exact page. Pages with text laid out in
+// aligned columns have those rows written as pipe tables (see pdfRowsText).
+// The title in the document information, if any, is recorded as title.
+// Figures are loaded as image pages of their own, with the page and figure
+// number in their metadata, for the vision model to describe (see
+// WithVision).
+func LoadPDF(path string) ([]Page, error) {
+	f, reader, err := pdf.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening PDF %s: %w", path, err)
+	}
+	defer f.Close()
+
+	name := filepath.Base(path)
+	title := strings.TrimSpace(reader.Trailer().Key("Info").Key("Title").Text())
+	var pages []Page
+	var raw []byte // the file, read when the first figure is found
+	seen := map[string]bool{}
+	for i := 1; i <= reader.NumPage(); i++ {
+		page := reader.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+		text, err := page.GetPlainText(nil)
+		if err != nil {
+			return nil, fmt.Errorf("extracting text from %s page %d: %w", name, i, err)
+		}
+		if tableText, tables := pdfPageTables(page); tables > 0 {
+			text = tableText
+		}
+		text = strings.TrimSpace(text)
+		if text != "" {
+			meta := map[string]any{"page": i}
+			if title != "" {
+				meta[titleField] = title
+			}
+			pages = append(pages, Page{Text: text, Source: name, Metadata: meta})
+		}
+
+		if len(seen) >= maxPDFFigures || page.Resources().Key("XObject").IsNull() {
+			continue
+		}
+		if raw == nil {
+			if raw, err = os.ReadFile(path); err != nil {
+				return nil, err
+			}
+		}
+		for _, figure := range pdfFigures(raw, page) {
+			hash := contentHash(string(figure.Data))
+			if seen[hash] || len(seen) >= maxPDFFigures {
+				continue
+			}
+			seen[hash] = true
+			pages = append(pages, Page{
+				Text:     fmt.Sprintf("Figure %d on page %d of %s", len(seen), i, name),
+				Source:   name,
+				Metadata: map[string]any{"page": i, "figure": len(seen)},
+				Image:    &figure,
+			})
+		}
+	}
+	return pages, nil
+}
+
+// pdfPageTables rebuilds the page's text with its tables, returning the
+// number of tables found; 0 if its rows cannot be read.
+func pdfPageTables(page pdf.Page) (string, int) {
+	rows, err := page.GetTextByRow()
+	if err != nil {
+		return "", 0
+	}
+	cells := make([][]pdfCell, len(rows))
+	for i, row := range rows {
+		for _, text := range row.Content {
+			if strings.TrimSpace(text.S) != "" {
+				cells[i] = append(cells[i], pdfCell{x: text.X, text: text.S})
+			}
+		}
+	}
+	return pdfRowsText(cells)
+}
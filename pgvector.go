@@ -0,0 +1,504 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq" // also registers the "postgres" driver
+)
+
+// tableNamePattern restricts table names to safe, unquoted SQL identifiers.
+var tableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// PgVectorStore implements the VectorStore interface on PostgreSQL with the
+// pgvector extension, using cosine distance for similarity search.
+type PgVectorStore struct {
+	db        *sql.DB
+	table     string
+	embedder  Embedder
+	dimension int
+	indexType string
+	ready     bool
+
+	// quantization stores the vectors of a new table as halfvec when
+	// float16. When binary, the vectors stay full precision in the table and
+	// only their binary quantization is indexed; searches rank the index's
+	// candidates again by the full vectors.
+	quantization Quantization
+}
+
+// NewPgVectorStore opens a connection to the database at dsn. indexType is
+// "hnsw" or "ivfflat". The table and index are created on first insert.
+func NewPgVectorStore(dsn, table string, embedder Embedder, dimension int, indexType string) (*PgVectorStore, error) {
+	if !tableNamePattern.MatchString(table) {
+		return nil, fmt.Errorf("invalid table name %q", table)
+	}
+	if indexType != "hnsw" && indexType != "ivfflat" {
+		return nil, fmt.Errorf("unsupported pgvector index type %q (expected hnsw or ivfflat)", indexType)
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("opening PostgreSQL connection: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to PostgreSQL: %w", err)
+	}
+	return &PgVectorStore{db: db, table: table, embedder: embedder, dimension: dimension, indexType: indexType}, nil
+}
+
+// Ping checks that the database is reachable.
+func (p *PgVectorStore) Ping(ctx context.Context) error {
+	return p.db.PingContext(ctx)
+}
+
+// Close releases the database connection pool.
+func (p *PgVectorStore) Close() error {
+	return p.db.Close()
+}
+
+// pgvectorMaxIndexDimension is the largest vector pgvector's HNSW and
+// IVFFlat indexes accept; halfvec vectors may have twice as many dimensions.
+const pgvectorMaxIndexDimension = 2000
+
+// pgvectorMaxDimension is the largest vector column pgvector stores, which
+// bounds binary-indexed vectors.
+const pgvectorMaxDimension = 16000
+
+// CheckDimension verifies that an existing table stores vectors of the
+// embedder's dimension, and that the vectors of a new one can be indexed.
+func (p *PgVectorStore) CheckDimension(ctx context.Context) error {
+	var column string
+	err := p.db.QueryRowContext(ctx,
+		"SELECT format_type(atttypid, atttypmod) FROM pg_attribute WHERE attrelid = to_regclass($1) AND attname = 'embedding'",
+		p.table).Scan(&column)
+	if errors.Is(err, sql.ErrNoRows) {
+		limit := pgvectorMaxIndexDimension
+		switch p.quantization {
+		case QuantizationFloat16:
+			limit *= 2
+		case QuantizationBinary:
+			limit = pgvectorMaxDimension
+		}
+		if p.dimension > limit {
+			return fmt.Errorf("pgvector cannot index %d-dimensional embeddings (at most %d); set EMBEDDING_DIM to shorten them", p.dimension, limit)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("checking table %s: %w", p.table, err)
+	}
+	stored := vectorTypeDimension(column)
+	if stored == 0 || stored == p.dimension {
+		return nil
+	}
+	return fmt.Errorf("table %s stores %d-dimensional embeddings but the configured embedder produces %d; "+
+		"set COLLECTION_NAME to a new table and re-ingest, or switch back to the embedding model it was built with", p.table, stored, p.dimension)
+}
+
+// vectorTypeDimension returns the dimension of a column type such as
+// "vector(1536)" or "halfvec(768)", or 0 if it has none.
+func vectorTypeDimension(columnType string) int {
+	_, rest, ok := strings.Cut(columnType, "(")
+	if !ok {
+		return 0
+	}
+	dimension, _ := strconv.Atoi(strings.TrimSuffix(rest, ")"))
+	return dimension
+}
+
+// ensureSchema creates the extension, table, and vector index if missing.
+func (p *PgVectorStore) ensureSchema(ctx context.Context) error {
+	if p.ready {
+		return nil
+	}
+
+	for _, stmt := range p.schemaStatements() {
+		if _, err := p.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("preparing pgvector schema: %w", err)
+		}
+	}
+	p.ready = true
+	return nil
+}
+
+// schemaStatements returns the statements creating the table and its index.
+// float16 quantization stores vectors as halfvec, and binary quantization
+// indexes the bits of binary_quantize by Hamming distance; both need
+// pgvector 0.7.
+func (p *PgVectorStore) schemaStatements() []string {
+	column := "vector"
+	indexed := "embedding vector_cosine_ops"
+	switch p.quantization {
+	case QuantizationFloat16:
+		column = "halfvec"
+		indexed = "embedding halfvec_cosine_ops"
+	case QuantizationBinary:
+		indexed = fmt.Sprintf("(%s) bit_hamming_ops", p.binaryEmbedding())
+	}
+	index := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_embedding_idx ON %s USING hnsw (%s)", p.table, p.table, indexed)
+	if p.indexType == "ivfflat" {
+		index = fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_embedding_idx ON %s USING ivfflat (%s) WITH (lists = 100)", p.table, p.table, indexed)
+	}
+	return []string{
+		"CREATE EXTENSION IF NOT EXISTS vector",
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id BIGSERIAL PRIMARY KEY,
+	text TEXT NOT NULL,
+	source VARCHAR(255) NOT NULL,
+	metadata JSONB NOT NULL DEFAULT '{}',
+	embedding %s(%d) NOT NULL
+)`, p.table, column, p.dimension),
+		index,
+	}
+}
+
+// binaryEmbedding is the expression of the binary index.
+func (p *PgVectorStore) binaryEmbedding() string {
+	return fmt.Sprintf("binary_quantize(embedding)::bit(%d)", p.dimension)
+}
+
+func (p *PgVectorStore) InsertDocuments(ctx context.Context, texts, sources []string, metadata []map[string]any) bool {
+	if err := p.ensureSchema(ctx); err != nil {
+		slog.ErrorContext(ctx, "Preparing table failed", "table", p.table, "error", err)
+		return false
+	}
+
+	embeddings, err := embedDocuments(ctx, p.embedder, texts)
+	if err != nil {
+		slog.ErrorContext(ctx, "Generating embeddings failed", "error", err)
+		return false
+	}
+
+	slog.InfoContext(ctx, "Inserting documents", "documents", len(texts), "table", p.table)
+	if err := p.inTx(ctx, func(tx *sql.Tx) error {
+		return p.insertRows(ctx, tx, texts, sources, metadata, embeddings)
+	}); err != nil {
+		slog.ErrorContext(ctx, "Inserting documents failed", "error", err)
+		return false
+	}
+	slog.InfoContext(ctx, "Inserted documents", "documents", len(texts))
+	return true
+}
+
+// insertRows adds documents with their embeddings within tx.
+func (p *PgVectorStore) insertRows(ctx context.Context, tx *sql.Tx, texts, sources []string, metadata []map[string]any, embeddings [][]float32) error {
+	stmt, err := tx.PrepareContext(ctx, fmt.Sprintf("INSERT INTO %s (text, source, metadata, embedding) VALUES ($1, $2, $3, $4)", p.table))
+	if err != nil {
+		return fmt.Errorf("preparing insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for i, text := range texts {
+		meta := map[string]any{}
+		if metadata != nil && metadata[i] != nil {
+			meta = metadata[i]
+		}
+		metaJSON, err := json.Marshal(meta)
+		if err != nil {
+			return fmt.Errorf("encoding metadata: %w", err)
+		}
+		if _, err := stmt.ExecContext(ctx, text, sources[i], string(metaJSON), formatVector(embeddings[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inTx runs fn in a transaction, committing only if it succeeds.
+func (p *PgVectorStore) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (p *PgVectorStore) SearchSimilar(ctx context.Context, query string, limit int, filter Filter) []Document {
+	queryEmbedding, err := embedQuery(ctx, p.embedder, query)
+	if err != nil {
+		slog.ErrorContext(ctx, "Embedding query failed", "error", err)
+		reportSearchFailure(ctx, err)
+		return []Document{}
+	}
+
+	args := []any{formatVector(queryEmbedding)}
+	where, args := filter.sqlWhere(args)
+	args = append(args, limit)
+	sqlQuery := fmt.Sprintf(
+		"SELECT text, source, metadata, embedding <=> $1 AS distance FROM %s %s ORDER BY embedding <=> $1 LIMIT $%d",
+		p.table, where, len(args))
+	if p.quantization == QuantizationBinary {
+		// Take several candidates per result from the binary index, then
+		// rank them by their full vectors.
+		args = append(args, limit*binaryRescoreOversampling)
+		sqlQuery = fmt.Sprintf(
+			"SELECT text, source, metadata, distance FROM ("+
+				"SELECT text, source, metadata, embedding <=> $1 AS distance FROM %s %s ORDER BY %s <~> binary_quantize($1::vector) LIMIT $%d"+
+				") candidates ORDER BY distance LIMIT $%d",
+			p.table, where, p.binaryEmbedding(), len(args), len(args)-1)
+	}
+
+	rows, err := p.db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		slog.ErrorContext(ctx, "Searching documents failed", "error", err)
+		reportSearchFailure(ctx, err)
+		return []Document{}
+	}
+	defer rows.Close()
+
+	var documents []Document
+	for rows.Next() {
+		var doc Document
+		var metaJSON []byte
+		var distance float64
+		if err := rows.Scan(&doc.Text, &doc.Source, &metaJSON, &distance); err != nil {
+			slog.ErrorContext(ctx, "Reading search result failed", "error", err)
+			reportSearchFailure(ctx, err)
+			return []Document{}
+		}
+		if err := json.Unmarshal(metaJSON, &doc.Metadata); err != nil {
+			slog.WarnContext(ctx, "Ignoring unreadable metadata", "rank", len(documents)+1)
+		}
+		// Cosine distance is 1 - cosine similarity; clamp to the 0-1 range.
+		doc.Similarity = float32(max(0, min(1, 1-distance)))
+		slog.DebugContext(ctx, "Search result", "rank", len(documents)+1, "cosine_distance", distance, "similarity", doc.Similarity)
+		documents = append(documents, doc)
+	}
+	if err := rows.Err(); err != nil {
+		slog.ErrorContext(ctx, "Searching documents failed", "error", err)
+		reportSearchFailure(ctx, err)
+		return []Document{}
+	}
+	if len(documents) == 0 {
+		slog.InfoContext(ctx, "No documents found matching the query")
+	}
+	return documents
+}
+
+func (p *PgVectorStore) ContentHashes(ctx context.Context, source string) (map[string]bool, error) {
+	if err := p.ensureSchema(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := p.db.QueryContext(ctx, fmt.Sprintf("SELECT metadata->>'content_hash' FROM %s WHERE source = $1 AND metadata ? 'content_hash'", p.table), source)
+	if err != nil {
+		return nil, fmt.Errorf("querying chunks of %s: %w", source, err)
+	}
+	defer rows.Close()
+
+	hashes := make(map[string]bool)
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes[hash] = true
+	}
+	return hashes, rows.Err()
+}
+
+func (p *PgVectorStore) DeleteContentHashes(ctx context.Context, source string, hashes []string) error {
+	_, err := p.db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE source = $1 AND metadata->>'content_hash' = ANY($2)", p.table), source, pq.Array(hashes))
+	if err != nil {
+		return fmt.Errorf("deleting chunks of %s: %w", source, err)
+	}
+	return nil
+}
+
+func (p *PgVectorStore) DeleteBySource(ctx context.Context, source string) error {
+	if err := p.ensureSchema(ctx); err != nil {
+		return err
+	}
+	if _, err := p.db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE source = $1", p.table), source); err != nil {
+		return fmt.Errorf("deleting chunks of %s: %w", source, err)
+	}
+	return nil
+}
+
+// DeleteExpired removes the rows whose metadata expires at or before now.
+func (p *PgVectorStore) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	if err := p.ensureSchema(ctx); err != nil {
+		return 0, err
+	}
+	result, err := p.db.ExecContext(ctx, fmt.Sprintf(
+		"DELETE FROM %s WHERE jsonb_typeof(metadata -> $1) = 'number' AND (metadata ->> $1)::float8 <= $2", p.table),
+		expiresField, now.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("deleting expired chunks: %w", err)
+	}
+	removed, err := result.RowsAffected()
+	return int(removed), err
+}
+
+// UpdateDocument swaps the source's rows in a single transaction.
+func (p *PgVectorStore) UpdateDocument(ctx context.Context, source string, texts []string, metadata []map[string]any) error {
+	if err := p.ensureSchema(ctx); err != nil {
+		return err
+	}
+	embeddings, err := p.embedder.Embed(ctx, texts)
+	if err != nil {
+		return fmt.Errorf("embedding chunks of %s: %w", source, err)
+	}
+	return p.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE source = $1", p.table), source); err != nil {
+			return fmt.Errorf("deleting chunks of %s: %w", source, err)
+		}
+		return p.insertRows(ctx, tx, texts, repeatSource(source, len(texts)), metadata, embeddings)
+	})
+}
+
+// ExportDocuments reads the table in primary key order, batch rows at a
+// time. A missing table has nothing to export.
+func (p *PgVectorStore) ExportDocuments(ctx context.Context, batch int, fn func([]ExportRecord) error) error {
+	query := fmt.Sprintf("SELECT id, text, source, metadata, embedding::text FROM %s WHERE id > $1 ORDER BY id LIMIT $2", p.table)
+	var last int64
+	for {
+		records, err := p.exportBatch(ctx, query, &last, batch)
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "42P01" { // undefined_table
+			return nil
+		}
+		if err != nil || len(records) == 0 {
+			return err
+		}
+		if err := fn(records); err != nil {
+			return err
+		}
+	}
+}
+
+// exportBatch reads the batch of rows after *last and advances it.
+func (p *PgVectorStore) exportBatch(ctx context.Context, query string, last *int64, batch int) ([]ExportRecord, error) {
+	rows, err := p.db.QueryContext(ctx, query, *last, batch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []ExportRecord
+	for rows.Next() {
+		var record ExportRecord
+		var metaJSON []byte
+		var vector string
+		if err := rows.Scan(last, &record.Text, &record.Source, &metaJSON, &vector); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(metaJSON, &record.Metadata); err != nil {
+			return nil, fmt.Errorf("decoding metadata of row %d: %w", *last, err)
+		}
+		if record.Embedding, err = parseVector(vector); err != nil {
+			return nil, fmt.Errorf("decoding embedding of row %d: %w", *last, err)
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// parseVector parses a vector literal such as "[1,0.5,-2]".
+func parseVector(literal string) ([]float32, error) {
+	literal = strings.TrimSuffix(strings.TrimPrefix(literal, "["), "]")
+	if literal == "" {
+		return nil, nil
+	}
+	parts := strings.Split(literal, ",")
+	vector := make([]float32, len(parts))
+	for i, part := range parts {
+		x, err := strconv.ParseFloat(part, 32)
+		if err != nil {
+			return nil, err
+		}
+		vector[i] = float32(x)
+	}
+	return vector, nil
+}
+
+// formatVector renders a vector in pgvector's text input format, e.g. [1,2,3].
+func formatVector(vector []float32) string {
+	parts := make([]string, len(vector))
+	for i, v := range vector {
+		parts[i] = strconv.FormatFloat(float64(v), 'f', -1, 32)
+	}
+	return "[" + strings.Join(parts, ",") + "]"
+}
+
+// sqlWhere translates the filter into a parameterized WHERE clause, appending
+// its bind values to args. Metadata comparisons check the JSON type first so
+// mismatched types never match, as in Filter.Match.
+func (f Filter) sqlWhere(args []any) (string, []any) {
+	if len(f) == 0 {
+		return "", args
+	}
+
+	clauses := make([]string, len(f))
+	for i, cond := range f {
+		if cond.Op == opIn {
+			valuesJSON, _ := json.Marshal(cond.Value)
+			if cond.Field == "source" {
+				args = append(args, string(valuesJSON))
+				clauses[i] = fmt.Sprintf("source IN (SELECT jsonb_array_elements_text($%d::jsonb))", len(args))
+				continue
+			}
+			// A JSON array contains the scalars equal to one of its elements.
+			args = append(args, cond.Field, string(valuesJSON))
+			clauses[i] = fmt.Sprintf("(jsonb_typeof(metadata -> $%d) = 'string' AND $%d::jsonb @> (metadata -> $%d))",
+				len(args)-1, len(args), len(args)-1)
+			continue
+		}
+		if cond.Field == "source" {
+			args = append(args, fmt.Sprint(cond.Value))
+			clauses[i] = fmt.Sprintf("source %s $%d", sqlOp(cond.Op), len(args))
+			continue
+		}
+		if cond.Op == opReadableBy {
+			rolesJSON, _ := json.Marshal(cond.Value)
+			args = append(args, cond.Field, string(rolesJSON))
+			clauses[i] = fmt.Sprintf("(NOT jsonb_exists(metadata, $%d) OR jsonb_exists_any(metadata -> $%d, ARRAY(SELECT jsonb_array_elements_text($%d::jsonb))))",
+				len(args)-1, len(args)-1, len(args))
+			continue
+		}
+		if cond.Op == opNotExpired {
+			nowJSON, _ := json.Marshal(cond.Value)
+			args = append(args, cond.Field, string(nowJSON))
+			clauses[i] = fmt.Sprintf("(NOT jsonb_exists(metadata, $%d) OR (jsonb_typeof(metadata -> $%d) = 'number' AND metadata -> $%d > $%d::jsonb))",
+				len(args)-1, len(args)-1, len(args)-1, len(args))
+			continue
+		}
+
+		valueJSON, _ := json.Marshal(cond.Value)
+		jsonType := "string"
+		switch cond.Value.(type) {
+		case float64, float32, int, int64:
+			jsonType = "number"
+		case bool:
+			jsonType = "boolean"
+		}
+		args = append(args, cond.Field, string(valueJSON))
+		clauses[i] = fmt.Sprintf("(jsonb_typeof(metadata -> $%d) = '%s' AND metadata -> $%d %s $%d::jsonb)",
+			len(args)-1, jsonType, len(args)-1, sqlOp(cond.Op), len(args))
+	}
+	return "WHERE " + strings.Join(clauses, " AND "), args
+}
+
+// sqlOp maps filter operators to SQL, which spells inequality as <>.
+func sqlOp(op string) string {
+	if op == "==" {
+		return "="
+	}
+	if op == "!=" {
+		return "<>"
+	}
+	return op
+}
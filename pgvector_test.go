@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestFilterSQLWhere(t *testing.T) {
+	filter := Filter{Eq("source", "Go Docs"), {Field: "year", Op: ">=", Value: float64(2023)}, {Field: "draft", Op: "!=", Value: true}}
+	where, args := filter.sqlWhere([]any{"[0.1]"})
+	expected := "WHERE source = $2 AND " +
+		"(jsonb_typeof(metadata -> $3) = 'number' AND metadata -> $3 >= $4::jsonb) AND " +
+		"(jsonb_typeof(metadata -> $5) = 'boolean' AND metadata -> $5 <> $6::jsonb)"
+	if where != expected {
+		t.Fatalf("unexpected WHERE clause:\n%s", where)
+	}
+	expectedArgs := []any{"[0.1]", "Go Docs", "year", "2023", "draft", "true"}
+	if len(args) != len(expectedArgs) {
+		t.Fatalf("expected %d args, got %v", len(expectedArgs), args)
+	}
+	for i := range expectedArgs {
+		if args[i] != expectedArgs[i] {
+			t.Fatalf("arg %d expected %v got %v", i, expectedArgs[i], args[i])
+		}
+	}
+}
+
+func TestFilterSQLWhereEmpty(t *testing.T) {
+	where, args := Filter(nil).sqlWhere([]any{"v"})
+	if where != "" || len(args) != 1 {
+		t.Fatalf("expected no clause for empty filter, got %q %v", where, args)
+	}
+}
+
+func TestFormatVector(t *testing.T) {
+	if got := formatVector([]float32{1, 0.5, -2}); got != "[1,0.5,-2]" {
+		t.Fatalf("unexpected vector literal %s", got)
+	}
+}
+
+func TestNewPgVectorStoreRejectsUnsafeTableNames(t *testing.T) {
+	if _, err := NewPgVectorStore("postgres://unused", "docs; DROP TABLE x", nil, 3, "hnsw"); err == nil {
+		t.Fatalf("expected invalid table name to be rejected")
+	}
+}
+
+func TestVectorTypeDimension(t *testing.T) {
+	for columnType, want := range map[string]int{"vector(1536)": 1536, "halfvec(512)": 512, "vector": 0} {
+		if got := vectorTypeDimension(columnType); got != want {
+			t.Errorf("vectorTypeDimension(%q) = %d, want %d", columnType, got, want)
+		}
+	}
+}
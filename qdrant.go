@@ -0,0 +1,522 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// qdrantPostFilterOverfetch is how many candidates per requested result are
+// fetched when some filter conditions must be applied client-side.
+const qdrantPostFilterOverfetch = 4
+
+// QdrantStore implements the VectorStore interface on Qdrant's REST API using
+// cosine similarity. Text and source are stored as top-level payload fields
+// and metadata under the "metadata" payload key.
+type QdrantStore struct {
+	baseURL    string
+	apiKey     string
+	collection string
+	embedder   Embedder
+	dimension  int
+	httpClient *http.Client
+	ready      bool
+
+	// quantization selects float16 vectors or int8 scalar quantization for
+	// new collections.
+	quantization Quantization
+}
+
+// NewQdrantStore creates a store for the collection on the Qdrant server at
+// baseURL (e.g. http://localhost:6333). apiKey may be empty.
+func NewQdrantStore(baseURL, apiKey, collection string, embedder Embedder, dimension int) *QdrantStore {
+	return &QdrantStore{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		apiKey:     apiKey,
+		collection: collection,
+		embedder:   embedder,
+		dimension:  dimension,
+		httpClient: http.DefaultClient,
+	}
+}
+
+type qdrantPoint struct {
+	ID      string         `json:"id"`
+	Vector  []float32      `json:"vector"`
+	Payload map[string]any `json:"payload"`
+}
+
+type qdrantScoredPoint struct {
+	Score   float32 `json:"score"`
+	Payload struct {
+		Text     string         `json:"text"`
+		Source   string         `json:"source"`
+		Metadata map[string]any `json:"metadata"`
+	} `json:"payload"`
+}
+
+// Ping checks that Qdrant is reachable and accepts the API key. A missing
+// collection is fine: it is created on the first insert.
+func (q *QdrantStore) Ping(ctx context.Context) error {
+	status, err := q.do(ctx, http.MethodGet, "/collections/"+q.collection, nil, nil)
+	if status == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// CheckDimension verifies that an existing collection stores vectors of the
+// embedder's dimension. A missing collection passes, as does an unreachable
+// server, since the collection is only needed on first use.
+func (q *QdrantStore) CheckDimension(ctx context.Context) error {
+	var info struct {
+		Result struct {
+			Config struct {
+				Params struct {
+					Vectors struct {
+						Size int `json:"size"`
+					} `json:"vectors"`
+				} `json:"params"`
+			} `json:"config"`
+		} `json:"result"`
+	}
+	status, err := q.do(ctx, http.MethodGet, "/collections/"+q.collection, nil, &info)
+	switch {
+	case status == http.StatusNotFound:
+		return nil
+	case status == 0 && err != nil:
+		slog.WarnContext(ctx, "Qdrant unreachable; embedding dimension not checked", "error", err)
+		return nil
+	case err != nil:
+		return fmt.Errorf("checking collection %s: %w", q.collection, err)
+	}
+	stored := info.Result.Config.Params.Vectors.Size
+	if stored == 0 || stored == q.dimension {
+		return nil
+	}
+	return fmt.Errorf("collection %s stores %d-dimensional embeddings but the configured embedder produces %d; "+
+		"set COLLECTION_NAME to a new collection and re-ingest, or switch back to the embedding model it was built with", q.collection, stored, q.dimension)
+}
+
+// ensureCollection creates the collection with a cosine vector config if it
+// does not exist yet. With int8 or binary quantization the original vectors
+// are kept on disk to rescore results, and only the quantized ones are held
+// in memory.
+func (q *QdrantStore) ensureCollection(ctx context.Context) error {
+	if q.ready {
+		return nil
+	}
+
+	status, err := q.do(ctx, http.MethodGet, "/collections/"+q.collection, nil, nil)
+	if err != nil && status != http.StatusNotFound {
+		return fmt.Errorf("checking collection: %w", err)
+	}
+	if status == http.StatusNotFound {
+		slog.InfoContext(ctx, "Creating Qdrant collection", "collection", q.collection, "dimension", q.dimension, "distance", "cosine", "vectors", q.quantization)
+		vectors := map[string]any{"size": q.dimension, "distance": "Cosine"}
+		body := map[string]any{"vectors": vectors}
+		switch q.quantization {
+		case QuantizationFloat16:
+			vectors["datatype"] = "float16"
+		case QuantizationInt8:
+			vectors["on_disk"] = true
+			body["quantization_config"] = map[string]any{"scalar": map[string]any{"type": "int8", "always_ram": true}}
+		case QuantizationBinary:
+			vectors["on_disk"] = true
+			body["quantization_config"] = map[string]any{"binary": map[string]any{"always_ram": true}}
+		}
+		if _, err := q.do(ctx, http.MethodPut, "/collections/"+q.collection, body, nil); err != nil {
+			return fmt.Errorf("creating collection: %w", err)
+		}
+	}
+	q.ready = true
+	return nil
+}
+
+func (q *QdrantStore) InsertDocuments(ctx context.Context, texts, sources []string, metadata []map[string]any) bool {
+	if err := q.ensureCollection(ctx); err != nil {
+		slog.ErrorContext(ctx, "Preparing Qdrant collection failed", "collection", q.collection, "error", err)
+		return false
+	}
+
+	embeddings, err := embedDocuments(ctx, q.embedder, texts)
+	if err != nil {
+		slog.ErrorContext(ctx, "Generating embeddings failed", "error", err)
+		return false
+	}
+
+	slog.InfoContext(ctx, "Inserting documents", "documents", len(texts), "collection", q.collection)
+	points := make([]qdrantPoint, len(texts))
+	for i, text := range texts {
+		meta := map[string]any{}
+		if metadata != nil && metadata[i] != nil {
+			meta = metadata[i]
+		}
+		id, err := newUUID()
+		if err != nil {
+			slog.ErrorContext(ctx, "Generating point ID failed", "error", err)
+			return false
+		}
+		points[i] = qdrantPoint{
+			ID:      id,
+			Vector:  embeddings[i],
+			Payload: map[string]any{"text": text, "source": sources[i], "metadata": meta},
+		}
+	}
+
+	path := fmt.Sprintf("/collections/%s/points?wait=true", q.collection)
+	if _, err := q.do(ctx, http.MethodPut, path, map[string]any{"points": points}, nil); err != nil {
+		slog.ErrorContext(ctx, "Inserting documents failed", "error", err)
+		return false
+	}
+	slog.InfoContext(ctx, "Inserted documents", "documents", len(texts))
+	return true
+}
+
+func (q *QdrantStore) SearchSimilar(ctx context.Context, query string, limit int, filter Filter) []Document {
+	queryEmbedding, err := embedQuery(ctx, q.embedder, query)
+	if err != nil {
+		slog.ErrorContext(ctx, "Embedding query failed", "error", err)
+		reportSearchFailure(ctx, err)
+		return []Document{}
+	}
+
+	native, residual := filter.qdrantFilter()
+	fetch := limit
+	if len(residual) > 0 {
+		slog.DebugContext(ctx, "Applying filter conditions client-side", "conditions", len(residual), "filter", residual.String())
+		fetch = limit * qdrantPostFilterOverfetch
+	}
+
+	body := map[string]any{
+		"vector":       queryEmbedding,
+		"limit":        fetch,
+		"with_payload": true,
+	}
+	if native != nil {
+		body["filter"] = native
+	}
+	if q.quantization == QuantizationBinary {
+		// Take several binary candidates per result and rank them again
+		// by the original vectors.
+		body["params"] = map[string]any{"quantization": map[string]any{"rescore": true, "oversampling": binaryRescoreOversampling}}
+	}
+
+	var resp struct {
+		Result []qdrantScoredPoint `json:"result"`
+	}
+	if _, err := q.do(ctx, http.MethodPost, fmt.Sprintf("/collections/%s/points/search", q.collection), body, &resp); err != nil {
+		slog.ErrorContext(ctx, "Searching documents failed", "error", err)
+		reportSearchFailure(ctx, err)
+		return []Document{}
+	}
+
+	var documents []Document
+	for _, point := range resp.Result {
+		doc := Document{
+			Text:       point.Payload.Text,
+			Source:     point.Payload.Source,
+			Metadata:   point.Payload.Metadata,
+			Similarity: max(0, point.Score),
+		}
+		if !residual.Match(doc) {
+			continue
+		}
+		slog.DebugContext(ctx, "Search result", "rank", len(documents)+1, "similarity", doc.Similarity)
+		documents = append(documents, doc)
+		if len(documents) == limit {
+			break
+		}
+	}
+	if len(documents) == 0 {
+		slog.InfoContext(ctx, "No documents found matching the query")
+	}
+	return documents
+}
+
+// qdrantScrollPage is how many points are read per scroll request.
+const qdrantScrollPage = 256
+
+// qdrantRecord is a point returned by the scroll API.
+type qdrantRecord struct {
+	ID      any `json:"id"`
+	Payload struct {
+		Metadata map[string]any `json:"metadata"`
+	} `json:"payload"`
+}
+
+// scrollSource returns the IDs and metadata of every point of source.
+func (q *QdrantStore) scrollSource(ctx context.Context, source string) ([]qdrantRecord, error) {
+	if err := q.ensureCollection(ctx); err != nil {
+		return nil, err
+	}
+
+	var records []qdrantRecord
+	body := map[string]any{
+		"filter":       qdrantSourceFilter(source),
+		"limit":        qdrantScrollPage,
+		"with_payload": []string{"metadata"},
+		"with_vector":  false,
+	}
+	for {
+		var resp struct {
+			Result struct {
+				Points         []qdrantRecord `json:"points"`
+				NextPageOffset any            `json:"next_page_offset"`
+			} `json:"result"`
+		}
+		if _, err := q.do(ctx, http.MethodPost, fmt.Sprintf("/collections/%s/points/scroll", q.collection), body, &resp); err != nil {
+			return nil, fmt.Errorf("reading chunks of %s: %w", source, err)
+		}
+		records = append(records, resp.Result.Points...)
+		if resp.Result.NextPageOffset == nil {
+			return records, nil
+		}
+		body["offset"] = resp.Result.NextPageOffset
+	}
+}
+
+// ExportDocuments scrolls through every point with its vector. A missing
+// collection has nothing to export.
+func (q *QdrantStore) ExportDocuments(ctx context.Context, batch int, fn func([]ExportRecord) error) error {
+	body := map[string]any{"limit": batch, "with_payload": true, "with_vector": true}
+	for {
+		var resp struct {
+			Result struct {
+				Points []struct {
+					Vector  []float32 `json:"vector"`
+					Payload struct {
+						Text     string         `json:"text"`
+						Source   string         `json:"source"`
+						Metadata map[string]any `json:"metadata"`
+					} `json:"payload"`
+				} `json:"points"`
+				NextPageOffset any `json:"next_page_offset"`
+			} `json:"result"`
+		}
+		status, err := q.do(ctx, http.MethodPost, fmt.Sprintf("/collections/%s/points/scroll", q.collection), body, &resp)
+		if status == http.StatusNotFound {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading points: %w", err)
+		}
+		records := make([]ExportRecord, len(resp.Result.Points))
+		for i, point := range resp.Result.Points {
+			records[i] = ExportRecord{Text: point.Payload.Text, Source: point.Payload.Source, Metadata: point.Payload.Metadata, Embedding: point.Vector}
+		}
+		if len(records) > 0 {
+			if err := fn(records); err != nil {
+				return err
+			}
+		}
+		if resp.Result.NextPageOffset == nil {
+			return nil
+		}
+		body["offset"] = resp.Result.NextPageOffset
+	}
+}
+
+func (q *QdrantStore) ContentHashes(ctx context.Context, source string) (map[string]bool, error) {
+	records, err := q.scrollSource(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make(map[string]bool)
+	for _, record := range records {
+		if hash, ok := record.Payload.Metadata["content_hash"].(string); ok {
+			hashes[hash] = true
+		}
+	}
+	return hashes, nil
+}
+
+func (q *QdrantStore) DeleteContentHashes(ctx context.Context, source string, hashes []string) error {
+	body := map[string]any{"filter": map[string]any{"must": []map[string]any{
+		{"key": "source", "match": map[string]any{"value": source}},
+		{"key": "metadata.content_hash", "match": map[string]any{"any": hashes}},
+	}}}
+	if _, err := q.do(ctx, http.MethodPost, fmt.Sprintf("/collections/%s/points/delete?wait=true", q.collection), body, nil); err != nil {
+		return fmt.Errorf("deleting chunks of %s: %w", source, err)
+	}
+	return nil
+}
+
+func (q *QdrantStore) DeleteBySource(ctx context.Context, source string) error {
+	if err := q.ensureCollection(ctx); err != nil {
+		return err
+	}
+	body := map[string]any{"filter": qdrantSourceFilter(source)}
+	if _, err := q.do(ctx, http.MethodPost, fmt.Sprintf("/collections/%s/points/delete?wait=true", q.collection), body, nil); err != nil {
+		return fmt.Errorf("deleting chunks of %s: %w", source, err)
+	}
+	return nil
+}
+
+// DeleteExpired counts the expired points and deletes them by filter.
+func (q *QdrantStore) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	if err := q.ensureCollection(ctx); err != nil {
+		return 0, err
+	}
+	filter := map[string]any{"must": []map[string]any{{"key": "metadata." + expiresField, "range": map[string]any{"lte": now.Unix()}}}}
+	var count struct {
+		Result struct {
+			Count int `json:"count"`
+		} `json:"result"`
+	}
+	if _, err := q.do(ctx, http.MethodPost, fmt.Sprintf("/collections/%s/points/count", q.collection), map[string]any{"filter": filter, "exact": true}, &count); err != nil {
+		return 0, fmt.Errorf("counting expired chunks: %w", err)
+	}
+	if count.Result.Count == 0 {
+		return 0, nil
+	}
+	if _, err := q.do(ctx, http.MethodPost, fmt.Sprintf("/collections/%s/points/delete?wait=true", q.collection), map[string]any{"filter": filter}, nil); err != nil {
+		return 0, fmt.Errorf("deleting expired chunks: %w", err)
+	}
+	return count.Result.Count, nil
+}
+
+// UpdateDocument inserts the new chunks before deleting the old ones by ID,
+// so searches never see the source missing.
+func (q *QdrantStore) UpdateDocument(ctx context.Context, source string, texts []string, metadata []map[string]any) error {
+	old, err := q.scrollSource(ctx, source)
+	if err != nil {
+		return err
+	}
+	if !q.InsertDocuments(ctx, texts, repeatSource(source, len(texts)), metadata) {
+		return fmt.Errorf("inserting new chunks of %s failed", source)
+	}
+	if len(old) == 0 {
+		return nil
+	}
+	ids := make([]any, len(old))
+	for i, record := range old {
+		ids[i] = record.ID
+	}
+	if _, err := q.do(ctx, http.MethodPost, fmt.Sprintf("/collections/%s/points/delete?wait=true", q.collection), map[string]any{"points": ids}, nil); err != nil {
+		return fmt.Errorf("deleting old chunks of %s: %w", source, err)
+	}
+	return nil
+}
+
+func qdrantSourceFilter(source string) map[string]any {
+	return map[string]any{"must": []map[string]any{{"key": "source", "match": map[string]any{"value": source}}}}
+}
+
+// qdrantFilter translates the filter into a Qdrant filter object. Equality
+// and numeric ranges are supported natively; ordering comparisons on strings
+// are returned as a residual filter to apply to the results.
+func (f Filter) qdrantFilter() (map[string]any, Filter) {
+	var must, mustNot []map[string]any
+	var residual Filter
+	for _, cond := range f {
+		key := cond.Field
+		if key != "source" {
+			key = "metadata." + key
+		}
+		switch cond.Op {
+		case opReadableBy:
+			must = append(must, map[string]any{"should": []map[string]any{
+				{"is_empty": map[string]any{"key": key}},
+				{"key": key, "match": map[string]any{"any": cond.Value}},
+			}})
+		case opNotExpired:
+			must = append(must, map[string]any{"should": []map[string]any{
+				{"is_empty": map[string]any{"key": key}},
+				{"key": key, "range": map[string]any{"gt": cond.Value}},
+			}})
+		case opIn:
+			must = append(must, map[string]any{"key": key, "match": map[string]any{"any": cond.Value}})
+		case "==":
+			must = append(must, map[string]any{"key": key, "match": map[string]any{"value": cond.Value}})
+		case "!=":
+			mustNot = append(mustNot, map[string]any{"key": key, "match": map[string]any{"value": cond.Value}})
+		default:
+			if _, numeric := toFloat(cond.Value); !numeric {
+				residual = append(residual, cond)
+				continue
+			}
+			bound := map[string]string{">": "gt", ">=": "gte", "<": "lt", "<=": "lte"}[cond.Op]
+			must = append(must, map[string]any{"key": key, "range": map[string]any{bound: cond.Value}})
+		}
+	}
+
+	if len(must) == 0 && len(mustNot) == 0 {
+		return nil, residual
+	}
+	native := map[string]any{}
+	if len(must) > 0 {
+		native["must"] = must
+	}
+	if len(mustNot) > 0 {
+		native["must_not"] = mustNot
+	}
+	return native, residual
+}
+
+// do sends a JSON request to the Qdrant API, decoding the reply into out if
+// given. It returns the HTTP status code along with any error.
+func (q *QdrantStore) do(ctx context.Context, method, path string, payload, out any) (int, error) {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return 0, err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, q.baseURL+path, body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if q.apiKey != "" {
+		req.Header.Set("api-key", q.apiKey)
+	}
+
+	resp, err := q.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Status struct {
+				Error string `json:"error"`
+			} `json:"status"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Status.Error != "" {
+			return resp.StatusCode, fmt.Errorf("Qdrant API error (status %d): %s", resp.StatusCode, apiErr.Status.Error)
+		}
+		return resp.StatusCode, fmt.Errorf("Qdrant API error (status %d)", resp.StatusCode)
+	}
+	if out != nil {
+		return resp.StatusCode, json.Unmarshal(data, out)
+	}
+	return resp.StatusCode, nil
+}
+
+// newUUID returns a random RFC 4122 version 4 UUID, the string ID format
+// Qdrant accepts for points.
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestQdrantStoreCreatesCollectionAndUpserts(t *testing.T) {
+	var created bool
+	var upserted []qdrantPoint
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/collections/docs":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"status":{"error":"Not found: Collection docs doesn't exist!"}}`))
+		case r.Method == http.MethodPut && r.URL.Path == "/collections/docs":
+			var req struct {
+				Vectors struct {
+					Size     int    `json:"size"`
+					Distance string `json:"distance"`
+				} `json:"vectors"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			if req.Vectors.Size != 3 || req.Vectors.Distance != "Cosine" {
+				t.Errorf("unexpected vector config %+v", req.Vectors)
+			}
+			created = true
+			w.Write([]byte(`{"result":true,"status":"ok"}`))
+		case r.Method == http.MethodPut && r.URL.Path == "/collections/docs/points":
+			var req struct {
+				Points []qdrantPoint `json:"points"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			upserted = req.Points
+			w.Write([]byte(`{"result":{"status":"completed"},"status":"ok"}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	store := NewQdrantStore(server.URL, "", "docs", NewHashingEmbedder(3), 3)
+	ok := store.InsertDocuments(context.Background(), []string{"a", "b"}, []string{"s1", "s2"}, []map[string]any{{"page": 1}, nil})
+	if !ok {
+		t.Fatalf("expected insert to succeed")
+	}
+	if !created {
+		t.Fatalf("expected collection to be created")
+	}
+	if len(upserted) != 2 {
+		t.Fatalf("expected 2 points, got %d", len(upserted))
+	}
+	if upserted[0].ID == upserted[1].ID || len(upserted[0].ID) != 36 {
+		t.Fatalf("expected distinct UUID point IDs, got %q and %q", upserted[0].ID, upserted[1].ID)
+	}
+	if upserted[0].Payload["source"] != "s1" || upserted[0].Payload["text"] != "a" {
+		t.Fatalf("unexpected payload %v", upserted[0].Payload)
+	}
+	if meta, _ := upserted[0].Payload["metadata"].(map[string]any); meta["page"] != float64(1) {
+		t.Fatalf("expected metadata in payload, got %v", upserted[0].Payload["metadata"])
+	}
+}
+
+func TestQdrantStoreSearchSimilar(t *testing.T) {
+	var searchBody map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/collections/docs/points/search" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&searchBody)
+		w.Write([]byte(`{"result":[
+			{"id":"1","score":0.9,"payload":{"text":"new","source":"Go Docs","metadata":{"date":"2024-05-01"}}},
+			{"id":"2","score":0.8,"payload":{"text":"old","source":"Go Docs","metadata":{"date":"2023-05-01"}}}
+		],"status":"ok"}`))
+	}))
+	defer server.Close()
+
+	store := NewQdrantStore(server.URL, "", "docs", NewHashingEmbedder(3), 3)
+	filter := Filter{Eq("source", "Go Docs"), {Field: "date", Op: ">", Value: "2024-01-01"}}
+	docs := store.SearchSimilar(context.Background(), "question", 2, filter)
+
+	if len(docs) != 1 || docs[0].Text != "new" || docs[0].Similarity != 0.9 {
+		t.Fatalf("expected string range to be applied to results, got %+v", docs)
+	}
+	if searchBody["limit"] != float64(2*qdrantPostFilterOverfetch) {
+		t.Fatalf("expected over-fetch for client-side conditions, got limit %v", searchBody["limit"])
+	}
+	if searchBody["filter"] == nil {
+		t.Fatalf("expected native filter in search request")
+	}
+	if searchBody["params"] != nil {
+		t.Fatalf("expected no quantization parameters, got %v", searchBody["params"])
+	}
+
+	store.quantization = QuantizationBinary
+	store.SearchSimilar(context.Background(), "question", 2, nil)
+	if data, _ := json.Marshal(searchBody["params"]); string(data) != `{"quantization":{"oversampling":4,"rescore":true}}` {
+		t.Fatalf("expected binary candidates to be rescored, got %s", data)
+	}
+}
+
+func TestFilterQdrantFilter(t *testing.T) {
+	filter := Filter{Eq("source", "Go Docs"), {Field: "year", Op: ">=", Value: float64(2023)}, {Field: "draft", Op: "!=", Value: true}}
+	native, residual := filter.qdrantFilter()
+	if len(residual) != 0 {
+		t.Fatalf("expected no residual conditions, got %v", residual)
+	}
+
+	data, _ := json.Marshal(native)
+	expected := `{"must":[{"key":"source","match":{"value":"Go Docs"}},{"key":"metadata.year","range":{"gte":2023}}],` +
+		`"must_not":[{"key":"metadata.draft","match":{"value":true}}]}`
+	if string(data) != expected {
+		t.Fatalf("unexpected Qdrant filter:\n%s", data)
+	}
+
+	if native, _ := Filter(nil).qdrantFilter(); native != nil {
+		t.Fatalf("expected no filter for empty conditions, got %v", native)
+	}
+}
+
+func TestQdrantStoreContentHashesPagesThroughScroll(t *testing.T) {
+	var deleteBody map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/collections/docs":
+			w.Write([]byte(`{"result":{},"status":"ok"}`))
+		case "/collections/docs/points/scroll":
+			var req map[string]any
+			json.NewDecoder(r.Body).Decode(&req)
+			if req["offset"] == nil {
+				w.Write([]byte(`{"result":{"points":[{"payload":{"metadata":{"content_hash":"h1"}}}],"next_page_offset":"p2"}}`))
+				return
+			}
+			w.Write([]byte(`{"result":{"points":[{"payload":{"metadata":{"content_hash":"h2"}}},{"payload":{"metadata":{}}}],"next_page_offset":null}}`))
+		case "/collections/docs/points/delete":
+			json.NewDecoder(r.Body).Decode(&deleteBody)
+			w.Write([]byte(`{"result":{"status":"completed"}}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	store := NewQdrantStore(server.URL, "", "docs", NewHashingEmbedder(3), 3)
+	hashes, err := store.ContentHashes(context.Background(), "doc.pdf")
+	if err != nil {
+		t.Fatalf("ContentHashes: %v", err)
+	}
+	if len(hashes) != 2 || !hashes["h1"] || !hashes["h2"] {
+		t.Fatalf("expected hashes from both pages, got %v", hashes)
+	}
+
+	if err := store.DeleteContentHashes(context.Background(), "doc.pdf", []string{"h1"}); err != nil {
+		t.Fatalf("DeleteContentHashes: %v", err)
+	}
+	data, _ := json.Marshal(deleteBody)
+	expected := `{"filter":{"must":[{"key":"source","match":{"value":"doc.pdf"}},{"key":"metadata.content_hash","match":{"any":["h1"]}}]}}`
+	if string(data) != expected {
+		t.Fatalf("unexpected delete request %s", data)
+	}
+}
+
+func TestQdrantStoreCheckDimension(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/collections/docs" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"result":{"config":{"params":{"vectors":{"size":1536,"distance":"Cosine"}}}},"status":"ok"}`))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	if err := NewQdrantStore(server.URL, "", "docs", NewHashingEmbedder(1536), 1536).CheckDimension(ctx); err != nil {
+		t.Fatalf("expected a matching dimension to pass, got %v", err)
+	}
+	if err := NewQdrantStore(server.URL, "", "docs", NewHashingEmbedder(512), 512).CheckDimension(ctx); err == nil || !strings.Contains(err.Error(), "1536-dimensional") {
+		t.Fatalf("expected a dimension mismatch, got %v", err)
+	}
+	if err := NewQdrantStore(server.URL, "", "new", NewHashingEmbedder(512), 512).CheckDimension(ctx); err != nil {
+		t.Fatalf("expected a missing collection to pass, got %v", err)
+	}
+}
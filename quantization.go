@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Quantization selects how a vector store keeps the vectors of new
+// collections. Smaller encodings cut memory for large corpora at some cost in
+// recall; `rag quantization-bench` measures that cost on a corpus.
+type Quantization string
+
+const (
+	QuantizationNone    Quantization = ""        // 32-bit floats
+	QuantizationFloat16 Quantization = "float16" // 16-bit floats, half the memory
+	QuantizationInt8    Quantization = "int8"    // 8-bit scalar quantization, a quarter of the memory
+	QuantizationBinary  Quantization = "binary"  // 1 bit per dimension, a thirty-second of the memory
+)
+
+// binaryRescoreOversampling is how many candidates per requested result a
+// binary search takes from the quantized vectors, to rank again by the
+// full-precision ones. Binary vectors alone rank too coarsely to be used
+// directly, but the true top results are almost always among a few times
+// as many binary candidates.
+const binaryRescoreOversampling = 4
+
+// ParseQuantization accepts "none" (or empty), "float16", "int8", and
+// "binary", in any case.
+func ParseQuantization(name string) (Quantization, error) {
+	switch q := Quantization(strings.ToLower(name)); q {
+	case "", "none":
+		return QuantizationNone, nil
+	case QuantizationFloat16, QuantizationInt8, QuantizationBinary:
+		return q, nil
+	}
+	return "", fmt.Errorf("unsupported quantization %q (expected none, float16, int8, or binary)", name)
+}
+
+func (q Quantization) String() string {
+	if q == QuantizationNone {
+		return "float32"
+	}
+	return string(q)
+}
+
+// bytesPerVector returns the storage a vector of dimension takes, counting
+// the scale int8 vectors are stored with.
+func (q Quantization) bytesPerVector(dimension int) int {
+	switch q {
+	case QuantizationFloat16:
+		return 2 * dimension
+	case QuantizationInt8:
+		return dimension + 4
+	case QuantizationBinary:
+		return (dimension + 7) / 8
+	}
+	return 4 * dimension
+}
+
+// apply returns v as it reads back after being stored with q. Binary keeps
+// the sign of each component, read back as 1 or -1, so that the cosine of
+// two binary vectors ranks them as their Hamming distance does.
+func (q Quantization) apply(v []float32) []float32 {
+	out := slices.Clone(v)
+	switch q {
+	case QuantizationFloat16:
+		for i, x := range out {
+			out[i] = roundFloat16(x)
+		}
+	case QuantizationInt8:
+		var peak float32
+		for _, x := range v {
+			peak = max(peak, float32(math.Abs(float64(x))))
+		}
+		if peak == 0 {
+			break
+		}
+		scale := peak / 127
+		for i, x := range out {
+			out[i] = float32(math.Round(float64(x/scale))) * scale
+		}
+	case QuantizationBinary:
+		for i, x := range out {
+			out[i] = -1
+			if x > 0 {
+				out[i] = 1
+			}
+		}
+	}
+	return out
+}
+
+// roundFloat16 returns x rounded to the nearest IEEE 754 half-precision
+// value, ties to even.
+func roundFloat16(x float32) float32 {
+	abs := math.Abs(float64(x))
+	switch {
+	case abs >= 65520:
+		return float32(math.Copysign(math.Inf(1), float64(x)))
+	case abs < 0x1p-14:
+		// Subnormal halves are spaced 2^-24 apart.
+		return float32(math.RoundToEven(float64(x)/0x1p-24) * 0x1p-24)
+	}
+	// Keep 10 of the 23 mantissa bits; a carry correctly bumps the exponent.
+	const dropped = 13
+	bits := math.Float32bits(x)
+	bits += 1<<(dropped-1) - 1 + (bits>>dropped)&1
+	return math.Float32frombits(bits &^ (1<<dropped - 1))
+}
+
+// QuantizationResult reports how one encoding compares to full precision.
+type QuantizationResult struct {
+	Quantization   Quantization
+	BytesPerVector int
+	// Recall is the mean fraction of each query's exact top k that the
+	// quantized vectors also rank in their top k.
+	Recall float64
+}
+
+// BenchmarkQuantization searches corpus exhaustively for each query, once
+// with the vectors at full precision and once per encoding, and compares the
+// top k results. Queries stay at full precision, as the stores search them,
+// except for binary vectors: those are searched with a binary query, and
+// their top k·binaryRescoreOversampling ranked again at full precision.
+func BenchmarkQuantization(corpus, queries [][]float32, k int) []QuantizationResult {
+	dimension := 0
+	if len(corpus) > 0 {
+		dimension = len(corpus[0])
+	}
+	exact := make([][]int, len(queries))
+	for i, query := range queries {
+		exact[i] = topK(corpus, query, k)
+	}
+
+	var results []QuantizationResult
+	for _, q := range []Quantization{QuantizationNone, QuantizationFloat16, QuantizationInt8, QuantizationBinary} {
+		stored := make([][]float32, len(corpus))
+		for i, v := range corpus {
+			stored[i] = q.apply(v)
+		}
+		result := QuantizationResult{Quantization: q, BytesPerVector: q.bytesPerVector(dimension)}
+		if len(queries) > 0 && len(corpus) > 0 {
+			var sum float64
+			for i, query := range queries {
+				var found []int
+				if q == QuantizationBinary {
+					found = rescore(corpus, topK(stored, q.apply(query), k*binaryRescoreOversampling), query, k)
+				} else {
+					found = topK(stored, query, k)
+				}
+				hits := 0
+				for _, idx := range found {
+					if slices.Contains(exact[i], idx) {
+						hits++
+					}
+				}
+				sum += float64(hits) / float64(len(exact[i]))
+			}
+			result.Recall = sum / float64(len(queries))
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// topK returns the indexes of the k vectors most similar to query, best
+// first.
+func topK(vectors [][]float32, query []float32, k int) []int {
+	indexes := make([]int, len(vectors))
+	scores := make([]float32, len(vectors))
+	for i, v := range vectors {
+		indexes[i] = i
+		scores[i] = cosineSimilarity(query, v)
+	}
+	sort.SliceStable(indexes, func(a, b int) bool { return scores[indexes[a]] > scores[indexes[b]] })
+	return indexes[:min(k, len(indexes))]
+}
+
+// rescore returns the k of candidates, indexes of vectors, most similar to
+// query, best first.
+func rescore(vectors [][]float32, candidates []int, query []float32, k int) []int {
+	scores := make(map[int]float32, len(candidates))
+	for _, idx := range candidates {
+		scores[idx] = cosineSimilarity(query, vectors[idx])
+	}
+	ranked := slices.Clone(candidates)
+	sort.SliceStable(ranked, func(a, b int) bool { return scores[ranked[a]] > scores[ranked[b]] })
+	return ranked[:min(k, len(ranked))]
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/milvus-io/milvus-sdk-go/v2/entity"
+)
+
+func TestParseQuantization(t *testing.T) {
+	for name, want := range map[string]Quantization{"": QuantizationNone, "none": QuantizationNone, "Float16": QuantizationFloat16, "INT8": QuantizationInt8, "binary": QuantizationBinary} {
+		if got, err := ParseQuantization(name); err != nil || got != want {
+			t.Errorf("ParseQuantization(%q) = %s, %v", name, got, err)
+		}
+	}
+	if _, err := ParseQuantization("int4"); err == nil {
+		t.Error("expected an unsupported quantization to be rejected")
+	}
+}
+
+func TestRoundFloat16(t *testing.T) {
+	cases := map[float32]float32{
+		1:          1,
+		-0.5:       -0.5,
+		0.1:        0.0999755859375,
+		1.0009766:  1.0009766, // 1 + 2^-10 is representable
+		1.00048828: 1,         // halfway between 1 and 1 + 2^-10 rounds to even
+		65504:      65504,
+		0x1p-20:    0x1p-20, // subnormal
+		0x1p-26:    0,
+	}
+	for in, want := range cases {
+		if got := roundFloat16(in); got != want {
+			t.Errorf("roundFloat16(%g) = %g, want %g", in, got, want)
+		}
+	}
+	if !math.IsInf(float64(roundFloat16(1e6)), 1) {
+		t.Error("expected values beyond the half range to overflow")
+	}
+}
+
+func TestQuantizationApply(t *testing.T) {
+	v := []float32{0.5, -0.25, 0.01, 0}
+	got := QuantizationInt8.apply(v)
+	for i := range v {
+		if math.Abs(float64(got[i]-v[i])) > 0.5/127 {
+			t.Errorf("int8 component %d = %g, want about %g", i, got[i], v[i])
+		}
+	}
+	if got[0] != 0.5 {
+		t.Errorf("expected the largest component to be exact, got %g", got[0])
+	}
+	if zero := QuantizationInt8.apply([]float32{0, 0}); zero[0] != 0 || zero[1] != 0 {
+		t.Errorf("expected a zero vector to stay zero, got %v", zero)
+	}
+	if binary := QuantizationBinary.apply(v); binary[0] != 1 || binary[1] != -1 || binary[2] != 1 || binary[3] != -1 {
+		t.Errorf("expected binary components to keep their sign, got %v", binary)
+	}
+	if QuantizationNone.apply(v)[2] != 0.01 {
+		t.Error("expected full precision to be unchanged")
+	}
+}
+
+func TestBenchmarkQuantization(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	random := func(n int) [][]float32 {
+		vectors := make([][]float32, n)
+		for i := range vectors {
+			vectors[i] = make([]float32, 64)
+			for j := range vectors[i] {
+				vectors[i][j] = float32(rng.NormFloat64())
+			}
+		}
+		return vectors
+	}
+	results := BenchmarkQuantization(random(500), random(20), 10)
+	if len(results) != 4 {
+		t.Fatalf("expected float32, float16, int8, and binary results, got %+v", results)
+	}
+	if results[0].Recall != 1 || results[0].BytesPerVector != 256 {
+		t.Errorf("expected full precision to match itself, got %+v", results[0])
+	}
+	if results[1].BytesPerVector != 128 || results[1].Recall < 0.95 {
+		t.Errorf("unexpected float16 result %+v", results[1])
+	}
+	if results[2].BytesPerVector != 68 || results[2].Recall < 0.8 {
+		t.Errorf("unexpected int8 result %+v", results[2])
+	}
+	if results[3].BytesPerVector != 8 || results[3].Recall < 0.5 {
+		t.Errorf("unexpected binary result %+v", results[3])
+	}
+}
+
+func TestMilvusIndexFromEnvQuantization(t *testing.T) {
+	t.Setenv("VECTOR_QUANTIZATION", "int8")
+	config, err := milvusIndexFromEnv()
+	if err != nil || config.Type != entity.IvfSQ8 {
+		t.Fatalf("expected int8 to select IVF_SQ8, got %s (%v)", config.Type, err)
+	}
+	t.Setenv("MILVUS_INDEX", "HNSW")
+	if _, err := milvusIndexFromEnv(); err == nil {
+		t.Fatal("expected int8 with an HNSW index to be rejected")
+	}
+	t.Setenv("MILVUS_INDEX", "")
+	t.Setenv("VECTOR_QUANTIZATION", "float16")
+	if _, err := milvusIndexFromEnv(); err == nil || !strings.Contains(err.Error(), "not supported by the Milvus store") {
+		t.Fatalf("expected float16 to be rejected on Milvus, got %v", err)
+	}
+	t.Setenv("VECTOR_QUANTIZATION", "binary")
+	if _, err := milvusIndexFromEnv(); err == nil || !strings.Contains(err.Error(), "binary is not supported by the Milvus store") {
+		t.Fatalf("expected binary to be rejected on Milvus, got %v", err)
+	}
+}
+
+func TestPgVectorHalfvecSchema(t *testing.T) {
+	store := &PgVectorStore{table: "docs", dimension: 3, indexType: "hnsw", quantization: QuantizationFloat16}
+	statements := store.schemaStatements()
+	if !strings.Contains(statements[1], "embedding halfvec(3) NOT NULL") || !strings.Contains(statements[2], "halfvec_cosine_ops") {
+		t.Fatalf("expected a halfvec column and index, got %q", statements)
+	}
+	store.quantization = QuantizationBinary
+	if statements := store.schemaStatements(); !strings.Contains(statements[1], "embedding vector(3)") || !strings.Contains(statements[2], "(binary_quantize(embedding)::bit(3)) bit_hamming_ops") {
+		t.Fatalf("expected a vector column with a binary index, got %q", statements)
+	}
+	store.quantization = QuantizationNone
+	if statements := store.schemaStatements(); !strings.Contains(statements[1], "embedding vector(3)") {
+		t.Fatalf("expected a vector column, got %q", statements[1])
+	}
+}
+
+func TestQdrantQuantizedCollection(t *testing.T) {
+	cases := map[Quantization]string{
+		QuantizationFloat16: `{"vectors":{"datatype":"float16","distance":"Cosine","size":3}}`,
+		QuantizationInt8:    `{"quantization_config":{"scalar":{"always_ram":true,"type":"int8"}},"vectors":{"distance":"Cosine","on_disk":true,"size":3}}`,
+		QuantizationBinary:  `{"quantization_config":{"binary":{"always_ram":true}},"vectors":{"distance":"Cosine","on_disk":true,"size":3}}`,
+	}
+	for quantization, want := range cases {
+		var created string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			var body map[string]any
+			json.NewDecoder(r.Body).Decode(&body)
+			data, _ := json.Marshal(body)
+			created = string(data)
+			w.Write([]byte(`{"result":true,"status":"ok"}`))
+		}))
+		store := NewQdrantStore(server.URL, "", "docs", NewHashingEmbedder(3), 3)
+		store.quantization = quantization
+		if err := store.ensureCollection(context.Background()); err != nil {
+			t.Fatalf("%s: ensureCollection returned error: %v", quantization, err)
+		}
+		if created != want {
+			t.Errorf("%s: created collection with %s", quantization, created)
+		}
+		server.Close()
+	}
+}
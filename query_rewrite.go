@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// QueryGlossary holds the rule-based rewrites of a QueryRewriter.
+type QueryGlossary struct {
+	// Acronyms maps an acronym, matched case-sensitively as a whole word, to
+	// its expansion, e.g. "SLA": "service level agreement".
+	Acronyms map[string]string `json:"acronyms"`
+	// Corrections maps a misspelled word in lower case, matched
+	// case-insensitively as a whole word, to its correct spelling, e.g.
+	// "kubernets": "kubernetes".
+	Corrections map[string]string `json:"corrections"`
+}
+
+// LoadQueryGlossary reads a QueryGlossary from a JSON file, e.g.
+//
+//	{"acronyms": {"SLA": "service level agreement"}, "corrections": {"kubernets": "kubernetes"}}
+func LoadQueryGlossary(path string) (QueryGlossary, error) {
+	var glossary QueryGlossary
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return glossary, err
+	}
+	if err := json.Unmarshal(data, &glossary); err != nil {
+		return glossary, fmt.Errorf("parsing query glossary %s: %w", path, err)
+	}
+	corrections := make(map[string]string, len(glossary.Corrections))
+	for wrong, right := range glossary.Corrections {
+		corrections[strings.ToLower(wrong)] = right
+	}
+	glossary.Corrections = corrections
+	return glossary, nil
+}
+
+// QueryRewriter rewrites queries before they are embedded and searched. The
+// glossary's corrections and acronym expansions are applied first. Then, if
+// Model is set, the LLM fixes the remaining typos and resolves pronouns and
+// other references to earlier turns of a chat; without Model, follow-ups in
+// a chat are still condensed with the chat's model.
+type QueryRewriter struct {
+	Glossary QueryGlossary
+	Model    string // chat model of the LLM rewrite; empty applies the rules alone
+}
+
+// WithQueryRewriter rewrites every query with rw before retrieval. The
+// rewrite is embedded, searched, and reranked against; the answer is still
+// generated for, and recorded with, the question as asked.
+func WithQueryRewriter(rw *QueryRewriter) EngineOption {
+	return func(r *RAGEngine) {
+		r.rewriter = rw
+	}
+}
+
+// withConversation passes the recent turns of a chat and its model to the
+// query rewriter, which resolves the follow-up's references with them.
+func withConversation(history []Turn, model string) RetrieveOption {
+	return func(c *retrieveConfig) {
+		c.history = history
+		c.historyModel = model
+	}
+}
+
+// queryWord matches the words the glossary rules apply to.
+var queryWord = regexp.MustCompile(`[\p{L}\p{N}][\p{L}\p{N}'_-]*`)
+
+// applyRules corrects misspellings and spells out acronyms, keeping each
+// acronym and adding its expansion in parentheses the first time it appears,
+// unless the query already spells it out.
+func (g QueryGlossary) applyRules(query string) string {
+	expanded := make(map[string]bool)
+	return queryWord.ReplaceAllStringFunc(query, func(word string) string {
+		if right, ok := g.Corrections[strings.ToLower(word)]; ok {
+			return matchCapitalization(word, right)
+		}
+		expansion, ok := g.Acronyms[word]
+		if !ok || expanded[word] || strings.Contains(strings.ToLower(query), strings.ToLower(expansion)) {
+			return word
+		}
+		expanded[word] = true
+		return word + " (" + expansion + ")"
+	})
+}
+
+// matchCapitalization capitalizes the first letter of right if that of
+// word is.
+func matchCapitalization(word, right string) string {
+	first, _ := utf8.DecodeRuneInString(word)
+	if !unicode.IsUpper(first) {
+		return right
+	}
+	r, size := utf8.DecodeRuneInString(right)
+	return string(unicode.ToUpper(r)) + right[size:]
+}
+
+// rewriteQuery applies the engine's query rewriter to query, using the chat
+// history in cfg. On an LLM failure it keeps the rule-based rewrite.
+func (r *RAGEngine) rewriteQuery(ctx context.Context, query string, cfg retrieveConfig) string {
+	ctx, span := tracer.Start(ctx, "rag.rewrite_query")
+	defer span.End()
+
+	rewritten := r.rewriter.Glossary.applyRules(query)
+	model := r.rewriter.Model
+	if model == "" && len(cfg.history) > 0 {
+		model = cfg.historyModel
+	}
+	if model != "" {
+		if llmRewrite, err := r.llmRewrite(ctx, rewritten, cfg.history, model); err != nil {
+			endSpan(span, err)
+			slog.WarnContext(ctx, "Could not rewrite query, using the glossary rewrite", "error", err)
+		} else if llmRewrite != "" {
+			rewritten = llmRewrite
+		}
+	}
+	span.SetAttributes(attribute.Bool("rag.query_rewritten", rewritten != query))
+	if rewritten != query {
+		slog.InfoContext(ctx, "Rewrote query", "query", query, "rewritten", rewritten)
+	}
+	return rewritten
+}
+
+// llmRewrite asks model to fix typos, spell out the glossary's acronyms, and
+// resolve references to the conversation in query.
+func (r *RAGEngine) llmRewrite(ctx context.Context, query string, history []Turn, model string) (string, error) {
+	var prompt strings.Builder
+	prompt.WriteString("Rewrite the search query below so it finds the right documents. Fix spelling mistakes and typos, " +
+		"spell out abbreviations, and keep the meaning, language, and product names unchanged.")
+	if len(history) > 0 {
+		prompt.WriteString(" The query is a follow-up in the conversation below: replace pronouns and vague references " +
+			"with what they refer to, so it can be understood on its own.")
+	}
+	prompt.WriteString(" If the query needs no changes, return it unchanged. Reply with the query only.\n\n")
+	// Only the acronyms the query uses, so a large glossary does not swamp
+	// the prompt.
+	words := queryWord.FindAllString(query, -1)
+	var glossary []string
+	for _, acronym := range slices.Sorted(maps.Keys(r.rewriter.Glossary.Acronyms)) {
+		if slices.Contains(words, acronym) {
+			glossary = append(glossary, acronym+": "+r.rewriter.Glossary.Acronyms[acronym])
+		}
+	}
+	if len(glossary) > 0 {
+		prompt.WriteString("Glossary:\n" + strings.Join(glossary, "\n") + "\n\n")
+	}
+	if len(history) > 0 {
+		prompt.WriteString("Conversation:\n")
+		for _, turn := range history {
+			prompt.WriteString("User: " + turn.Question + "\n")
+			prompt.WriteString("Assistant: " + turn.Answer + "\n")
+		}
+		prompt.WriteString("\n")
+	}
+	prompt.WriteString("Query: " + query + "\n\nRewritten query:")
+
+	messages := []Message{
+		{Role: "system", Content: "You rewrite user questions into clear, standalone search queries."},
+		{Role: "user", Content: prompt.String()},
+	}
+	rewritten, err := r.llm.ChatCompletion(ctx, model, messages)
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(strings.TrimSpace(rewritten), "\""), nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGlossaryRulesCorrectAndExpand(t *testing.T) {
+	glossary := QueryGlossary{
+		Acronyms:    map[string]string{"SLA": "service level agreement", "IT": "information technology"},
+		Corrections: map[string]string{"kubernets": "kubernetes", "recieve": "receive"},
+	}
+	cases := map[string]string{
+		"What is the SLA for Kubernets clusters?": "What is the SLA (service level agreement) for Kubernetes clusters?",
+		"Does it recieve the SLA and the SLA?":    "Does it receive the SLA (service level agreement) and the SLA?",
+		"Our service level agreement (SLA)":       "Our service level agreement (SLA)",
+		"Ask IT about it":                         "Ask IT (information technology) about it",
+	}
+	for query, want := range cases {
+		if got := glossary.applyRules(query); got != want {
+			t.Errorf("applyRules(%q) = %q, want %q", query, got, want)
+		}
+	}
+}
+
+func TestQueryRewriterRunsBeforeRetrieval(t *testing.T) {
+	oa := &sequenceOpenAI{replies: []string{`"What is the service level agreement (SLA) for Kubernetes?"`}}
+	mv := &dummyMilvus{}
+	rewriter := &QueryRewriter{Glossary: QueryGlossary{Acronyms: map[string]string{"SLA": "service level agreement"}}, Model: "gpt-mini"}
+	engine := NewRAGEngine(oa, mv, WithQueryRewriter(rewriter))
+
+	engine.Retrieve(context.Background(), "wat is the SLA for kubernets", 3)
+	if mv.lastQuery != "What is the service level agreement (SLA) for Kubernetes?" {
+		t.Fatalf("searched %q", mv.lastQuery)
+	}
+	prompt := oa.calls[0][1].Content
+	if !strings.Contains(prompt, "SLA: service level agreement") || !strings.Contains(prompt, "Query: wat is the SLA (service level agreement) for kubernets") {
+		t.Fatalf("unexpected rewrite prompt %q", prompt)
+	}
+
+	// An LLM failure keeps the glossary rewrite.
+	engine.Retrieve(context.Background(), "SLA terms", 3)
+	if mv.lastQuery != "SLA (service level agreement) terms" {
+		t.Fatalf("searched %q", mv.lastQuery)
+	}
+}
+
+func TestQueryRewriterResolvesFollowUps(t *testing.T) {
+	oa := &sequenceOpenAI{replies: []string{
+		"Milvus is a vector database.",
+		"Who maintains Milvus?",
+		"Zilliz maintains it.",
+	}}
+	mv := &dummyMilvus{}
+	engine := NewRAGEngine(oa, mv, WithQueryRewriter(&QueryRewriter{}))
+	conv := engine.NewConversation()
+
+	if _, err := engine.Chat(context.Background(), conv, "What is Milvus?", 3, "gpt-test"); err != nil {
+		t.Fatal(err)
+	}
+	if mv.lastQuery != "What is Milvus?" || len(oa.calls) != 1 {
+		t.Fatalf("expected the first question to be searched as asked without a rewrite, got %q", mv.lastQuery)
+	}
+	if _, err := engine.Chat(context.Background(), conv, "Who maintains it?", 3, "gpt-test"); err != nil {
+		t.Fatal(err)
+	}
+	if mv.lastQuery != "Who maintains Milvus?" {
+		t.Fatalf("expected the follow-up to be resolved, searched %q", mv.lastQuery)
+	}
+	if prompt := oa.calls[1][1].Content; !strings.Contains(prompt, "User: What is Milvus?") {
+		t.Fatalf("expected the rewrite prompt to carry the conversation, got %q", prompt)
+	}
+}
+
+func TestQueryRewriterFromEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "glossary.json")
+	os.WriteFile(path, []byte(`{"acronyms": {"SLA": "service level agreement"}, "corrections": {"Kubernets": "kubernetes"}}`), 0o644)
+
+	t.Setenv("QUERY_REWRITE", "rules")
+	t.Setenv("QUERY_GLOSSARY_FILE", "")
+	if _, err := queryRewriterFromEnv("gpt-test"); err == nil {
+		t.Fatal("expected rules without a glossary to fail")
+	}
+	t.Setenv("QUERY_GLOSSARY_FILE", path)
+	rewriter, err := queryRewriterFromEnv("gpt-test")
+	if err != nil || rewriter.Model != "" || rewriter.Glossary.Corrections["kubernets"] != "kubernetes" {
+		t.Fatalf("queryRewriterFromEnv = %+v, %v", rewriter, err)
+	}
+	t.Setenv("QUERY_REWRITE", "llm")
+	if rewriter, err := queryRewriterFromEnv("gpt-test"); err != nil || rewriter.Model != "gpt-test" {
+		t.Fatalf("queryRewriterFromEnv = %+v, %v", rewriter, err)
+	}
+	t.Setenv("QUERY_REWRITE", "smart")
+	if _, err := queryRewriterFromEnv("gpt-test"); err == nil {
+		t.Fatal("expected an error for an unknown mode")
+	}
+	t.Setenv("QUERY_GLOSSARY_FILE", filepath.Join(t.TempDir(), "missing.json"))
+	t.Setenv("QUERY_REWRITE", "llm")
+	if _, err := queryRewriterFromEnv("gpt-test"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected a missing glossary to fail, got %v", err)
+	}
+}
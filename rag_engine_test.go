@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"strings"
 	"testing"
 )
@@ -10,7 +11,7 @@ type dummyOpenAI struct {
 	lastMessages []Message
 }
 
-func (d *dummyOpenAI) ChatCompletion(model string, messages []Message) (string, error) {
+func (d *dummyOpenAI) ChatCompletion(ctx context.Context, model string, messages []Message) (string, error) {
 	d.lastModel = model
 	d.lastMessages = messages
 	return "stubbed", nil
@@ -23,23 +24,31 @@ type dummyMilvus struct {
 	lastLimit       int
 }
 
-func (d *dummyMilvus) InsertDocuments(texts, sources []string) bool {
+func (d *dummyMilvus) InsertDocuments(ctx context.Context, texts, sources []string, metadata []map[string]any) bool {
 	d.insertedTexts = texts
 	d.insertedSources = sources
 	return true
 }
 
-func (d *dummyMilvus) SearchSimilar(query string, limit int) []Document {
+func (d *dummyMilvus) SearchSimilar(ctx context.Context, query string, limit int, filter Filter) []Document {
 	d.lastQuery = query
 	d.lastLimit = limit
 	return nil
 }
 
+func (d *dummyMilvus) DeleteBySource(ctx context.Context, source string) error {
+	return nil
+}
+
+func (d *dummyMilvus) UpdateDocument(ctx context.Context, source string, texts []string, metadata []map[string]any) error {
+	return nil
+}
+
 func TestAddDocumentsMismatchedLengths(t *testing.T) {
 	oa := &dummyOpenAI{}
 	mv := &dummyMilvus{}
 	engine := NewRAGEngine(oa, mv)
-	if engine.AddDocuments([]string{"doc1"}, []string{"s1", "s2"}) {
+	if engine.AddDocuments(context.Background(), []string{"doc1"}, []string{"s1", "s2"}) {
 		t.Fatalf("expected AddDocuments to fail on mismatched lengths")
 	}
 }
@@ -48,13 +57,13 @@ func TestGenerateResponseUsesContext(t *testing.T) {
 	oa := &dummyOpenAI{}
 	mv := &dummyMilvus{}
 	engine := NewRAGEngine(oa, mv)
-	ctx := []Document{{Text: "info about cats", Source: "src", Similarity: 0.85}}
-	resp, err := engine.GenerateResponse("question?", ctx, "gpt-test")
+	docs := []Document{{Text: "info about cats", Source: "src", Similarity: 0.85}}
+	resp, err := engine.GenerateResponse(context.Background(), "question?", docs, "gpt-test")
 	if err != nil {
 		t.Fatalf("GenerateResponse returned error: %v", err)
 	}
-	if resp != "stubbed" {
-		t.Fatalf("unexpected response: %s", resp)
+	if resp.Text != "stubbed" {
+		t.Fatalf("unexpected response: %s", resp.Text)
 	}
 	if oa.lastModel != "gpt-test" {
 		t.Fatalf("model not passed to openai client")
@@ -77,3 +86,47 @@ func TestChunkTextOverlaps(t *testing.T) {
 		}
 	}
 }
+
+func TestMinSimilarityShortCircuits(t *testing.T) {
+	oa := &dummyOpenAI{}
+	engine := NewRAGEngine(oa, &dummyMilvus{}, WithMinSimilarity(0.5))
+	docs := []Document{{Text: "irrelevant", Source: "src", Similarity: 0.2}}
+	answer, err := engine.GenerateResponse(context.Background(), "question?", docs, "gpt-test")
+	if err != nil {
+		t.Fatalf("GenerateResponse returned error: %v", err)
+	}
+	if answer.Text != InsufficientContextResponse || !answer.NoContext {
+		t.Fatalf("expected insufficient-context answer, got %+v", answer)
+	}
+	if oa.lastMessages != nil {
+		t.Fatalf("LLM should not be called when no context clears the threshold")
+	}
+}
+
+func TestMinSimilarityDropsIrrelevantDocuments(t *testing.T) {
+	oa := &dummyOpenAI{}
+	engine := NewRAGEngine(oa, &dummyMilvus{}, WithMinSimilarity(0.5))
+	docs := []Document{
+		{Text: "relevant text", Source: "a", Similarity: 0.8},
+		{Text: "noise text", Source: "b", Similarity: 0.1},
+	}
+	if _, err := engine.GenerateResponse(context.Background(), "question?", docs, "gpt-test"); err != nil {
+		t.Fatalf("GenerateResponse returned error: %v", err)
+	}
+	prompt := oa.lastMessages[len(oa.lastMessages)-1].Content
+	if !strings.Contains(prompt, "relevant text") || strings.Contains(prompt, "noise text") {
+		t.Fatalf("expected only the relevant document in the prompt")
+	}
+}
+
+func TestNoContextFallbackAsksModel(t *testing.T) {
+	oa := &dummyOpenAI{}
+	engine := NewRAGEngine(oa, &dummyMilvus{}, WithMinSimilarity(0.5), WithNoContextFallback())
+	answer, err := engine.GenerateResponse(context.Background(), "question?", nil, "gpt-test")
+	if err != nil {
+		t.Fatalf("GenerateResponse returned error: %v", err)
+	}
+	if answer.Text != "stubbed" || !answer.NoContext {
+		t.Fatalf("expected fallback answer flagged as no-context, got %+v", answer)
+	}
+}
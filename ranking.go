@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// updatedField is the metadata key connectors store a page's last edit
+// time in, as an RFC 3339 timestamp, and the default timestamp of recency
+// ranking.
+const updatedField = "updated"
+
+// SourceBoost scales the similarity of the documents of matching sources.
+type SourceBoost struct {
+	// Pattern is a glob (see path.Match) matched against the source and its
+	// base name; a trailing * also matches across slashes, so
+	// "https://wiki.example.com/*" matches every page of the wiki.
+	Pattern string
+	// Boost multiplies the similarity: above 1 promotes the source, below 1
+	// demotes it.
+	Boost float64
+}
+
+// matches reports whether the boost applies to source.
+func (b SourceBoost) matches(source string) bool {
+	if prefix, ok := strings.CutSuffix(b.Pattern, "*"); ok && !strings.ContainsAny(prefix, "*?[\\") && strings.HasPrefix(source, prefix) {
+		return true
+	}
+	if ok, _ := path.Match(b.Pattern, source); ok {
+		return true
+	}
+	ok, _ := path.Match(b.Pattern, path.Base(source))
+	return ok
+}
+
+// RankingPolicy adjusts the similarity of retrieved documents by where they
+// come from and how old they are, so that authoritative and recent
+// documents win ties with similar ones.
+type RankingPolicy struct {
+	// SourceBoosts scale the similarity of matching sources; the first
+	// matching boost applies.
+	SourceBoosts []SourceBoost
+	// HalfLife is the age at which recency takes half of RecencyWeight off
+	// a document's similarity; 0 disables recency ranking. Documents without
+	// a timestamp keep their similarity.
+	HalfLife time.Duration
+	// RecencyWeight is the share of the similarity that age can take away,
+	// from 0 to 1; 0 means 0.2.
+	RecencyWeight float64
+	// TimeField is the metadata field holding a document's timestamp, as an
+	// RFC 3339 timestamp, a date, or Unix seconds; empty means "updated".
+	TimeField string
+}
+
+// rankingOverfetch is how many candidates per requested result are retrieved
+// with a ranking policy, giving it room to promote documents.
+const rankingOverfetch = 3
+
+// WithRankingPolicy adjusts the similarity of the candidates of every search
+// by policy and reorders them before they are reranked. A reranker still
+// decides the order, and its ties keep the policy's.
+func WithRankingPolicy(policy RankingPolicy) EngineOption {
+	return func(r *RAGEngine) {
+		r.ranking = &policy
+	}
+}
+
+// factor returns how much the policy scales the similarity of doc at now.
+func (p *RankingPolicy) factor(ctx context.Context, doc Document, now time.Time) float64 {
+	factor := 1.0
+	for _, boost := range p.SourceBoosts {
+		if boost.matches(doc.Source) {
+			factor = boost.Boost
+			break
+		}
+	}
+	if p.HalfLife <= 0 {
+		return factor
+	}
+	field := p.TimeField
+	if field == "" {
+		field = updatedField
+	}
+	value, ok := doc.Metadata[field]
+	if !ok {
+		return factor
+	}
+	seconds, err := parseExpiry(value)
+	if err != nil {
+		slog.DebugContext(ctx, "Ignoring invalid document timestamp", "source", doc.Source, "field", field, "value", value)
+		return factor
+	}
+	weight := p.RecencyWeight
+	if weight == 0 {
+		weight = 0.2
+	}
+	age := max(now.Sub(time.Unix(int64(seconds), 0)), 0)
+	decay := math.Pow(0.5, age.Seconds()/p.HalfLife.Seconds())
+	return factor * (1 - weight*(1-decay))
+}
+
+// apply scales the similarity of each document, capped at 1, and sorts the
+// documents by it, keeping the order of ties.
+func (p *RankingPolicy) apply(ctx context.Context, docs []Document, now time.Time) []Document {
+	ranked := make([]Document, len(docs))
+	for i, doc := range docs {
+		doc.Similarity = float32(min(float64(doc.Similarity)*p.factor(ctx, doc, now), 1))
+		ranked[i] = doc
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].Similarity > ranked[j].Similarity })
+	return ranked
+}
+
+// parseSourceBoosts parses a comma-separated list of source patterns with
+// their boosts, such as "handbook/*:1.5,archive/*:0.5". The boost follows
+// the last colon, so patterns may be URLs.
+func parseSourceBoosts(list string) ([]SourceBoost, error) {
+	var boosts []SourceBoost
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		i := strings.LastIndex(entry, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid source boost %q (expected pattern:boost)", entry)
+		}
+		pattern := strings.TrimSpace(entry[:i])
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid source pattern %q: %w", pattern, err)
+		}
+		boost, err := strconv.ParseFloat(strings.TrimSpace(entry[i+1:]), 64)
+		if err != nil || boost <= 0 {
+			return nil, fmt.Errorf("invalid boost %q for %s (expected a positive number)", entry[i+1:], pattern)
+		}
+		boosts = append(boosts, SourceBoost{Pattern: pattern, Boost: boost})
+	}
+	return boosts, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSourceBoostPatterns(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, source string
+		want            bool
+	}{
+		{"handbook/*", "handbook/leave.md", true},
+		{"handbook/*", "handbook/hr/leave.md", true},
+		{"*.pdf", "reports/q3.pdf", true},
+		{"https://wiki.example.com/*", "https://wiki.example.com/eng/oncall", true},
+		{"archive/*", "handbook/archive.md", false},
+	} {
+		if got := (SourceBoost{Pattern: tc.pattern}).matches(tc.source); got != tc.want {
+			t.Errorf("%q matches %q = %t, want %t", tc.pattern, tc.source, got, tc.want)
+		}
+	}
+}
+
+func TestRankingPolicyBoostsSourcesAndDecaysAge(t *testing.T) {
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	policy := &RankingPolicy{
+		SourceBoosts: []SourceBoost{{Pattern: "handbook/*", Boost: 1.2}, {Pattern: "archive/*", Boost: 0.5}},
+		HalfLife:     30 * 24 * time.Hour,
+	}
+	docs := []Document{
+		{Source: "archive/leave.md", Similarity: 0.8},
+		{Source: "wiki/leave-old.md", Similarity: 0.8, Metadata: map[string]any{"updated": "2025-05-02T00:00:00Z"}},
+		{Source: "wiki/leave.md", Similarity: 0.8, Metadata: map[string]any{"updated": "2025-06-01"}},
+		{Source: "handbook/leave.md", Similarity: 0.9},
+	}
+	ranked := policy.apply(context.Background(), docs, now)
+	var order []string
+	for _, doc := range ranked {
+		order = append(order, doc.Source)
+	}
+	want := []string{"handbook/leave.md", "wiki/leave.md", "wiki/leave-old.md", "archive/leave.md"}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("got order %v, want %v", order, want)
+		}
+	}
+	// Boosts are capped at 1; a month-old document loses half the weight.
+	if ranked[0].Similarity != 1 || ranked[2].Similarity < 0.71 || ranked[2].Similarity > 0.73 || ranked[3].Similarity != 0.4 {
+		t.Fatalf("unexpected similarities %+v", ranked)
+	}
+	if docs[0].Similarity != 0.8 {
+		t.Fatal("expected the documents passed in to be left as they were")
+	}
+}
+
+func TestRetrievePrefersTheNewerOfTwoMatches(t *testing.T) {
+	store := NewMemoryStore(NewHashingEmbedder(256))
+	store.InsertDocuments(context.Background(),
+		[]string{"Expenses are approved by your manager.", "Expenses are approved by your manager."},
+		[]string{"policy-2019.md", "policy-2024.md"},
+		[]map[string]any{{"updated": "2019-01-01"}, {"updated": "2024-01-01"}},
+	)
+	engine := NewRAGEngine(&dummyOpenAI{}, store, WithRankingPolicy(RankingPolicy{HalfLife: 365 * 24 * time.Hour}))
+	docs := engine.Retrieve(context.Background(), "Who approves expenses?", 1)
+	if len(docs) != 1 || docs[0].Source != "policy-2024.md" {
+		t.Fatalf("expected the newer policy, got %+v", docs)
+	}
+}
+
+func TestParseSourceBoosts(t *testing.T) {
+	boosts, err := parseSourceBoosts("handbook/*:1.5, https://wiki.example.com/*:0.8")
+	if err != nil || len(boosts) != 2 || boosts[1].Pattern != "https://wiki.example.com/*" || boosts[1].Boost != 0.8 {
+		t.Fatalf("unexpected boosts %+v, %v", boosts, err)
+	}
+	for _, list := range []string{"handbook/*", "handbook/*:0", "[:2"} {
+		if _, err := parseSourceBoosts(list); err == nil {
+			t.Errorf("expected an error for %q", list)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RecordOptions selects how the records of a CSV or JSONL file become
+// documents.
+type RecordOptions struct {
+	// TextFields are the fields whose values form each document's text. A
+	// single field is used as is; several are written as "field: value"
+	// lines. With none, every field not mapped to metadata is used.
+	TextFields []string
+	// Metadata maps record fields to the metadata keys they are stored
+	// under, e.g. {"sku": "product_id"}.
+	Metadata map[string]string
+}
+
+// ParseFieldMapping parses a comma-separated list of fields to copy into
+// metadata, each optionally renamed with field=key, e.g. "category,sku=product_id".
+func ParseFieldMapping(list string) map[string]string {
+	mapping := make(map[string]string)
+	for _, item := range strings.Split(list, ",") {
+		field, key, renamed := strings.Cut(strings.TrimSpace(item), "=")
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if key = strings.TrimSpace(key); !renamed || key == "" {
+			key = field
+		}
+		mapping[field] = key
+	}
+	return mapping
+}
+
+// recordFile reports whether path is a CSV or JSONL file.
+func recordFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".csv" || ext == ".jsonl" || ext == ".ndjson"
+}
+
+// LoadRecords loads every row of a CSV file, or every line of a JSONL file,
+// as its own page, so each FAQ entry or catalog item is retrieved and cited
+// on its own. Pages share the file name as source and record their row (the
+// line number for JSONL, the data row for CSV) in the metadata, along with
+// the mapped fields. Records without text are skipped.
+func LoadRecords(path string, opts RecordOptions) ([]Page, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	defer f.Close()
+
+	name := filepath.Base(path)
+	var pages []Page
+	add := func(row int, fields []string, values map[string]any) {
+		text := recordText(fields, values, opts)
+		if text == "" {
+			return
+		}
+		meta := map[string]any{"row": row}
+		for field, key := range opts.Metadata {
+			if value, ok := values[field]; ok && value != nil {
+				meta[key] = value
+			}
+		}
+		pages = append(pages, Page{Text: text, Source: name, Metadata: meta})
+	}
+
+	if strings.ToLower(filepath.Ext(path)) == ".csv" {
+		err = readCSVRecords(f, opts, add)
+	} else {
+		err = readJSONLRecords(f, add)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return pages, nil
+}
+
+// readCSVRecords calls add for every data row of a CSV file with a header row.
+func readCSVRecords(r io.Reader, opts RecordOptions, add func(row int, fields []string, values map[string]any)) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+	columns := make(map[string]bool, len(header))
+	for _, column := range header {
+		columns[column] = true
+	}
+	for _, field := range opts.TextFields {
+		if !columns[field] {
+			return fmt.Errorf("text column %q not found (columns: %s)", field, strings.Join(header, ", "))
+		}
+	}
+	for field := range opts.Metadata {
+		if !columns[field] {
+			return fmt.Errorf("metadata column %q not found (columns: %s)", field, strings.Join(header, ", "))
+		}
+	}
+
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		values := make(map[string]any, len(header))
+		for i, column := range header {
+			if i < len(record) {
+				values[column] = record[i]
+			}
+		}
+		add(row, header, values)
+	}
+}
+
+// readJSONLRecords calls add for every JSON object line, with its fields in
+// the order they appear. Blank lines are ignored.
+func readJSONLRecords(r io.Reader, add func(row int, fields []string, values map[string]any)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		fields, values, err := decodeObject(data)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		add(line, fields, values)
+	}
+	return scanner.Err()
+}
+
+// decodeObject decodes a JSON object, returning its keys in document order.
+func decodeObject(data []byte) ([]string, map[string]any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, nil, errors.New("expected a JSON object")
+	}
+	var fields []string
+	values := make(map[string]any)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		key, _ := tok.(string)
+		var value any
+		if err := dec.Decode(&value); err != nil {
+			return nil, nil, err
+		}
+		if _, seen := values[key]; !seen {
+			fields = append(fields, key)
+		}
+		values[key] = value
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, nil, err
+	}
+	return fields, values, nil
+}
+
+// recordText builds a record's text from the text fields, or from every field
+// not mapped to metadata when none are configured.
+func recordText(fields []string, values map[string]any, opts RecordOptions) string {
+	textFields := opts.TextFields
+	if len(textFields) == 0 {
+		for _, field := range fields {
+			if _, ok := opts.Metadata[field]; !ok {
+				textFields = append(textFields, field)
+			}
+		}
+	}
+	if len(textFields) == 1 {
+		return strings.TrimSpace(recordValue(values[textFields[0]]))
+	}
+	var lines []string
+	for _, field := range textFields {
+		if value := strings.TrimSpace(recordValue(values[field])); value != "" {
+			lines = append(lines, field+": "+value)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// recordValue renders a field value as text; nested JSON values are kept as
+// compact JSON.
+func recordValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(data)
+	}
+}
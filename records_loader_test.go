@@ -0,0 +1,82 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadRecordsCSV(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"faq.csv": "\ufeffquestion,answer,category\n" +
+			"How do I reset my password?,\"Use the \"\"Forgot password\"\" link.\",account\n" +
+			"Do you ship abroad?,Yes.,shipping\n" +
+			",,empty\n",
+	})
+	path := filepath.Join(dir, "faq.csv")
+
+	pages, err := LoadRecords(path, RecordOptions{
+		TextFields: []string{"question", "answer"},
+		Metadata:   ParseFieldMapping("category=topic"),
+	})
+	if err != nil {
+		t.Fatalf("LoadRecords returned error: %v", err)
+	}
+	if len(pages) != 2 {
+		t.Fatalf("expected the two records with text, got %+v", pages)
+	}
+	want := "question: How do I reset my password?\nanswer: Use the \"Forgot password\" link."
+	if pages[0].Text != want || pages[0].Source != "faq.csv" {
+		t.Fatalf("unexpected first record %+v", pages[0])
+	}
+	if !reflect.DeepEqual(pages[1].Metadata, map[string]any{"row": 2, "topic": "shipping"}) {
+		t.Fatalf("unexpected metadata %v", pages[1].Metadata)
+	}
+
+	if _, err := LoadRecords(path, RecordOptions{TextFields: []string{"body"}}); err == nil || !strings.Contains(err.Error(), `"body"`) {
+		t.Fatalf("expected an error naming the missing column, got %v", err)
+	}
+}
+
+func TestLoadRecordsJSONL(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"catalog.jsonl": `{"name":"Desk lamp","description":"Warm LED light","sku":"L-1","price":19.5}` + "\n\n" +
+			`{"name":"Chair","tags":["oak","foldable"],"sku":"C-2"}` + "\n",
+		"broken.jsonl": `{"name":"ok"}` + "\n" + `["not","an","object"]` + "\n",
+	})
+
+	pages, err := LoadFile(filepath.Join(dir, "catalog.jsonl"))
+	if err != nil {
+		t.Fatalf("LoadFile returned error: %v", err)
+	}
+	if len(pages) != 2 || pages[0].Text != "name: Desk lamp\ndescription: Warm LED light\nsku: L-1\nprice: 19.5" {
+		t.Fatalf("expected every field as text in order, got %+v", pages)
+	}
+
+	pages, err = LoadRecords(filepath.Join(dir, "catalog.jsonl"), RecordOptions{
+		TextFields: []string{"description"},
+		Metadata:   ParseFieldMapping("sku=product_id, price"),
+	})
+	if err != nil {
+		t.Fatalf("LoadRecords returned error: %v", err)
+	}
+	if len(pages) != 1 || pages[0].Text != "Warm LED light" {
+		t.Fatalf("expected only the record with a description, got %+v", pages)
+	}
+	if !reflect.DeepEqual(pages[0].Metadata, map[string]any{"row": 1, "product_id": "L-1", "price": 19.5}) {
+		t.Fatalf("unexpected metadata %v", pages[0].Metadata)
+	}
+
+	pages, err = LoadRecords(filepath.Join(dir, "catalog.jsonl"), RecordOptions{Metadata: ParseFieldMapping("sku")})
+	if err != nil {
+		t.Fatalf("LoadRecords returned error: %v", err)
+	}
+	if pages[1].Text != `name: Chair`+"\n"+`tags: ["oak","foldable"]` || pages[1].Metadata["row"] != 3 {
+		t.Fatalf("unexpected second record %+v", pages[1])
+	}
+
+	if _, err := LoadFile(filepath.Join(dir, "broken.jsonl")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected an error naming the bad line, got %v", err)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Snapshot records the answers and retrieved documents for a set of
+// questions, so a later run can be diffed against it.
+type Snapshot struct {
+	Created time.Time       `json:"created"`
+	Model   string          `json:"model"`
+	Limit   int             `json:"limit"`
+	Entries []SnapshotEntry `json:"entries"`
+}
+
+// SnapshotEntry is the recorded outcome of one question.
+type SnapshotEntry struct {
+	Question  string   `json:"question"`
+	Answer    string   `json:"answer"`
+	NoContext bool     `json:"no_context,omitempty"`
+	Documents []string `json:"documents"` // IDs of the retrieved documents, in rank order
+}
+
+// documentID identifies a retrieved chunk across runs by its source and
+// content hash, so it stays the same as long as the chunk's text does.
+func documentID(doc Document) string {
+	hash, _ := doc.Metadata["content_hash"].(string)
+	if hash == "" {
+		hash = contentHash(doc.Text)
+	}
+	if len(hash) > 12 {
+		hash = hash[:12]
+	}
+	return doc.Source + "#" + hash
+}
+
+// TakeSnapshot answers every case and records the answers and the IDs of the
+// documents retrieved for them.
+func (r *RAGEngine) TakeSnapshot(ctx context.Context, cases []EvalCase, limit int, model string, opts ...RetrieveOption) (Snapshot, error) {
+	snapshot := Snapshot{Created: time.Now().UTC(), Model: model, Limit: limit}
+	for _, c := range cases {
+		caseCtx := withQueryID(ctx, newQueryID())
+		docs := r.Retrieve(caseCtx, c.Question, limit, opts...)
+		answer, err := r.GenerateResponse(caseCtx, c.Question, docs, model)
+		if err != nil {
+			return snapshot, fmt.Errorf("answering %q: %w", c.Question, err)
+		}
+		entry := SnapshotEntry{Question: c.Question, Answer: answer.Text, NoContext: answer.NoContext, Documents: []string{}}
+		for _, doc := range docs {
+			entry.Documents = append(entry.Documents, documentID(doc))
+		}
+		snapshot.Entries = append(snapshot.Entries, entry)
+	}
+	return snapshot, nil
+}
+
+// LoadSnapshot reads a snapshot saved by Save.
+func LoadSnapshot(path string) (Snapshot, error) {
+	var snapshot Snapshot
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return snapshot, err
+	}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return snapshot, fmt.Errorf("parsing snapshot %s: %w", path, err)
+	}
+	return snapshot, nil
+}
+
+// Save writes the snapshot as indented JSON, which diffs well under version
+// control.
+func (s Snapshot) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// RegressionThresholds decide when a changed answer counts as a regression.
+type RegressionThresholds struct {
+	// MinAnswerSimilarity is the lowest lexical similarity (0-1) between the
+	// old and new answer that is still accepted.
+	MinAnswerSimilarity float64
+	// MinDocumentOverlap is the lowest share (0-1) of the previously
+	// retrieved documents that must still be retrieved.
+	MinDocumentOverlap float64
+}
+
+// SnapshotDiff describes how one question's outcome changed.
+type SnapshotDiff struct {
+	Question         string
+	New              bool // the question is not in the baseline
+	AnswerChanged    bool
+	AnswerSimilarity float64 // lexical similarity of the old and new answer, 0-1
+	LostContext      bool    // the answer found context before but not now
+	MissingDocuments []string
+	AddedDocuments   []string
+	Reordered        bool    // the same documents were retrieved in a different order
+	DocumentOverlap  float64 // share of the baseline's documents still retrieved
+	Regressions      []string
+}
+
+// Regressed reports whether any change crossed a threshold.
+func (d SnapshotDiff) Regressed() bool {
+	return len(d.Regressions) > 0
+}
+
+// CompareSnapshots diffs every question of current against its entry in
+// baseline. Questions only in the baseline are ignored.
+func CompareSnapshots(ctx context.Context, baseline, current Snapshot, thresholds RegressionThresholds) []SnapshotDiff {
+	previous := make(map[string]SnapshotEntry, len(baseline.Entries))
+	for _, entry := range baseline.Entries {
+		previous[entry.Question] = entry
+	}
+
+	diffs := make([]SnapshotDiff, 0, len(current.Entries))
+	for _, entry := range current.Entries {
+		diff := SnapshotDiff{Question: entry.Question, AnswerSimilarity: 1, DocumentOverlap: 1}
+		old, ok := previous[entry.Question]
+		if !ok {
+			diff.New = true
+			diffs = append(diffs, diff)
+			continue
+		}
+
+		if old.Answer != entry.Answer {
+			diff.AnswerChanged = true
+			diff.AnswerSimilarity = textSimilarity(ctx, old.Answer, entry.Answer)
+		}
+		diff.LostContext = !old.NoContext && entry.NoContext
+
+		now := make(map[string]bool, len(entry.Documents))
+		for _, id := range entry.Documents {
+			now[id] = true
+		}
+		before := make(map[string]bool, len(old.Documents))
+		for _, id := range old.Documents {
+			before[id] = true
+			if !now[id] {
+				diff.MissingDocuments = append(diff.MissingDocuments, id)
+			}
+		}
+		for _, id := range entry.Documents {
+			if !before[id] {
+				diff.AddedDocuments = append(diff.AddedDocuments, id)
+			}
+		}
+		if len(old.Documents) > 0 {
+			diff.DocumentOverlap = 1 - float64(len(diff.MissingDocuments))/float64(len(old.Documents))
+		}
+		if len(diff.MissingDocuments) == 0 && len(diff.AddedDocuments) == 0 {
+			for i := range old.Documents {
+				if i < len(entry.Documents) && old.Documents[i] != entry.Documents[i] {
+					diff.Reordered = true
+					break
+				}
+			}
+		}
+
+		if diff.LostContext {
+			diff.Regressions = append(diff.Regressions, "answer no longer found context")
+		}
+		if diff.AnswerSimilarity < thresholds.MinAnswerSimilarity {
+			diff.Regressions = append(diff.Regressions, fmt.Sprintf("answer similarity %.2f is below %.2f", diff.AnswerSimilarity, thresholds.MinAnswerSimilarity))
+		}
+		if diff.DocumentOverlap < thresholds.MinDocumentOverlap {
+			diff.Regressions = append(diff.Regressions, fmt.Sprintf("only %.0f%% of the documents are still retrieved (minimum %.0f%%)", 100*diff.DocumentOverlap, 100*thresholds.MinDocumentOverlap))
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs
+}
+
+// textSimilarity is the cosine similarity of the texts' hashed word vectors.
+func textSimilarity(ctx context.Context, a, b string) float64 {
+	vectors, err := mmrEmbedder.Embed(ctx, []string{a, b})
+	if err != nil || len(vectors) != 2 {
+		return 0
+	}
+	return float64(cosineSimilarity(vectors[0], vectors[1]))
+}
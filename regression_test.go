@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	store := NewMemoryStore(NewHashingEmbedder(256))
+	store.InsertDocuments(context.Background(),
+		[]string{"Go is a programming language", "Milvus is a vector database"},
+		[]string{"Go Docs", "Milvus Docs"},
+		nil,
+	)
+	engine := NewRAGEngine(&scriptedOpenAI{reply: "Go is a language [1]."}, store)
+
+	snapshot, err := engine.TakeSnapshot(context.Background(), []EvalCase{{Question: "What is Go?"}}, 1, "gpt-test")
+	if err != nil {
+		t.Fatalf("TakeSnapshot: %v", err)
+	}
+	if len(snapshot.Entries) != 1 || len(snapshot.Entries[0].Documents) != 1 || snapshot.Entries[0].Documents[0][:8] != "Go Docs#" {
+		t.Fatalf("unexpected snapshot %+v", snapshot)
+	}
+
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	if err := snapshot.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	loaded, err := LoadSnapshot(path)
+	if err != nil {
+		t.Fatalf("LoadSnapshot: %v", err)
+	}
+	diffs := CompareSnapshots(context.Background(), loaded, snapshot, RegressionThresholds{MinAnswerSimilarity: 0.8, MinDocumentOverlap: 0.5})
+	if len(diffs) != 1 || diffs[0].Regressed() || diffs[0].AnswerChanged || diffs[0].Reordered {
+		t.Fatalf("expected no changes against itself, got %+v", diffs)
+	}
+}
+
+func TestCompareSnapshotsFlagsRegressions(t *testing.T) {
+	baseline := Snapshot{Entries: []SnapshotEntry{
+		{Question: "reworded", Answer: "Go is a compiled programming language.", Documents: []string{"a", "b"}},
+		{Question: "rewritten", Answer: "Go is a compiled programming language.", Documents: []string{"a", "b"}},
+		{Question: "lost", Answer: "Use go install.", Documents: []string{"a", "b"}},
+	}}
+	current := Snapshot{Entries: []SnapshotEntry{
+		{Question: "reworded", Answer: "Go is a compiled programming language!", Documents: []string{"b", "a"}},
+		{Question: "rewritten", Answer: "Milvus stores vectors.", Documents: []string{"a", "c"}},
+		{Question: "lost", Answer: "I don't know.", NoContext: true, Documents: []string{"c"}},
+		{Question: "added", Answer: "New.", Documents: []string{"c"}},
+	}}
+	diffs := CompareSnapshots(context.Background(), baseline, current, RegressionThresholds{MinAnswerSimilarity: 0.8, MinDocumentOverlap: 0.5})
+
+	if d := diffs[0]; d.Regressed() || !d.AnswerChanged || !d.Reordered {
+		t.Errorf("expected a reworded answer and reordered documents to pass, got %+v", d)
+	}
+	if d := diffs[1]; len(d.Regressions) != 1 || d.DocumentOverlap != 0.5 || len(d.MissingDocuments) != 1 || d.AddedDocuments[0] != "c" {
+		t.Errorf("expected only the answer to regress, got %+v", d)
+	}
+	if d := diffs[2]; !d.LostContext || len(d.Regressions) != 3 {
+		t.Errorf("expected lost context, answer, and documents to regress, got %+v", d)
+	}
+	if d := diffs[3]; !d.New || d.Regressed() {
+		t.Errorf("expected a new question, got %+v", d)
+	}
+}
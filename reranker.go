@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Reranker reorders retrieved documents so the most relevant come first.
+type Reranker interface {
+	Rerank(ctx context.Context, query string, docs []Document) ([]Document, error)
+}
+
+// PassageScorer rates candidate passages for a query, higher meaning more
+// relevant. Rerankers that can report their scores implement it, which lets
+// WindowedReranker merge the scores of separate windows.
+type PassageScorer interface {
+	Score(ctx context.Context, query string, docs []Document) ([]float64, error)
+}
+
+// newReranker returns the reranker named by mode: "llm" for an LLMReranker,
+// "window" for a WindowedReranker over one, or "local" for a KeywordReranker,
+// with model scoring for the LLM ones. It returns nil for "off" or "".
+func newReranker(mode string, client LLMClient, model string) (Reranker, error) {
+	switch mode {
+	case "", "off":
+		return nil, nil
+	case "llm":
+		return NewLLMReranker(client, model), nil
+	case "window":
+		return &WindowedReranker{Scorer: NewLLMReranker(client, model)}, nil
+	case "local":
+		return &KeywordReranker{}, nil
+	}
+	return nil, fmt.Errorf("invalid reranker %q (expected off, llm, window, or local)", mode)
+}
+
+// LLMReranker scores each candidate passage with a chat model acting as a
+// cross-encoder. If the model call fails or returns unusable output, the
+// fallback reranker is used instead.
+type LLMReranker struct {
+	client   LLMClient
+	model    string
+	fallback Reranker
+}
+
+// NewLLMReranker builds an LLM-based reranker with a local scoring fallback.
+func NewLLMReranker(client LLMClient, model string) *LLMReranker {
+	return &LLMReranker{client: client, model: model, fallback: &KeywordReranker{}}
+}
+
+// Rerank asks the model to grade every passage from 0 to 10 and sorts by grade.
+func (l *LLMReranker) Rerank(ctx context.Context, query string, docs []Document) ([]Document, error) {
+	if len(docs) < 2 {
+		return docs, nil
+	}
+	scores, err := l.Score(ctx, query, docs)
+	if err != nil {
+		slog.WarnContext(ctx, "LLM reranking failed, using local scoring", "error", err)
+		return l.fallback.Rerank(ctx, query, docs)
+	}
+	return sortByScores(docs, scores), nil
+}
+
+// Score asks the model to grade every passage from 0 to 10, without a
+// fallback.
+func (l *LLMReranker) Score(ctx context.Context, query string, docs []Document) ([]float64, error) {
+	var passages strings.Builder
+	for i, doc := range docs {
+		passages.WriteString(fmt.Sprintf("[%d] %s\n\n", i+1, doc.Text))
+	}
+	prompt := "Rate how relevant each passage is to the question on a scale from 0 (irrelevant) to 10 (directly answers it).\n" +
+		"Reply with one line per passage in the form \"<number>: <score>\" and nothing else.\n\n" +
+		"Question: " + query + "\n\nPassages:\n" + passages.String()
+
+	messages := []Message{
+		{Role: "system", Content: "You are a precise relevance grader for a search engine."},
+		{Role: "user", Content: prompt},
+	}
+	reply, err := l.client.ChatCompletion(ctx, l.model, messages)
+	if err != nil {
+		return nil, err
+	}
+
+	scores, ok := parseRerankScores(reply, len(docs))
+	if !ok {
+		return nil, fmt.Errorf("could not parse reranker output %q", truncateText(reply, 200))
+	}
+	return scores, nil
+}
+
+// parseRerankScores reads "<number>: <score>" lines into a slice indexed by
+// passage position. It reports false unless every passage received a score.
+func parseRerankScores(reply string, n int) ([]float64, bool) {
+	scores := make([]float64, n)
+	seen := make([]bool, n)
+	found := 0
+	for _, line := range strings.Split(reply, "\n") {
+		idxPart, scorePart, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		idxPart = strings.Trim(strings.TrimSpace(idxPart), "[]")
+		idx, err := strconv.Atoi(idxPart)
+		if err != nil || idx < 1 || idx > n || seen[idx-1] {
+			continue
+		}
+		score, err := strconv.ParseFloat(strings.TrimSpace(scorePart), 64)
+		if err != nil {
+			continue
+		}
+		scores[idx-1] = score
+		seen[idx-1] = true
+		found++
+	}
+	return scores, found == n
+}
+
+// KeywordReranker is a local, dependency-free reranker. It blends the vector
+// similarity with a BM25-style term overlap score computed over the candidates.
+type KeywordReranker struct{}
+
+// Rerank orders documents by a mix of lexical overlap and vector similarity.
+func (k *KeywordReranker) Rerank(ctx context.Context, query string, docs []Document) ([]Document, error) {
+	if len(docs) < 2 {
+		return docs, nil
+	}
+	scores, _ := k.Score(ctx, query, docs)
+	return sortByScores(docs, scores), nil
+}
+
+// Score rates documents from 0 to 1, half lexical overlap relative to the
+// best match in docs and half vector similarity.
+func (k *KeywordReranker) Score(ctx context.Context, query string, docs []Document) ([]float64, error) {
+	lexical, maxLexical := bm25Scores(query, docs)
+	scores := make([]float64, len(docs))
+	for i, doc := range docs {
+		var normLexical float64
+		if maxLexical > 0 {
+			normLexical = lexical[i] / maxLexical
+		}
+		scores[i] = 0.5*normLexical + 0.5*float64(doc.Similarity)
+	}
+	return scores, nil
+}
+
+// bm25Scores returns the BM25 score of each document for query, with
+// document frequencies taken over docs, and the highest score.
+func bm25Scores(query string, docs []Document) ([]float64, float64) {
+	queryTerms := tokenize(query)
+	docTerms := make([][]string, len(docs))
+	docFreq := make(map[string]int)
+	var totalLen int
+	for i, doc := range docs {
+		docTerms[i] = tokenize(doc.Text)
+		totalLen += len(docTerms[i])
+		seen := make(map[string]bool)
+		for _, term := range docTerms[i] {
+			if !seen[term] {
+				docFreq[term]++
+				seen[term] = true
+			}
+		}
+	}
+	avgLen := float64(totalLen) / float64(max(len(docs), 1))
+
+	const k1, b = 1.2, 0.75
+	lexical := make([]float64, len(docs))
+	var maxLexical float64
+	for i, terms := range docTerms {
+		tf := make(map[string]int)
+		for _, term := range terms {
+			tf[term]++
+		}
+		for _, term := range queryTerms {
+			freq := float64(tf[term])
+			if freq == 0 {
+				continue
+			}
+			idf := math.Log(1 + (float64(len(docs))-float64(docFreq[term])+0.5)/(float64(docFreq[term])+0.5))
+			norm := 1 - b + b*float64(len(terms))/math.Max(avgLen, 1)
+			lexical[i] += idf * freq * (k1 + 1) / (freq + k1*norm)
+		}
+		if lexical[i] > maxLexical {
+			maxLexical = lexical[i]
+		}
+	}
+	return lexical, maxLexical
+}
+
+// sortByScores returns a copy of docs ordered by descending score. Ties keep
+// their original retrieval order.
+func sortByScores(docs []Document, scores []float64) []Document {
+	order := make([]int, len(docs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return scores[order[a]] > scores[order[b]]
+	})
+
+	sorted := make([]Document, len(docs))
+	for i, idx := range order {
+		sorted[i] = docs[idx]
+	}
+	return sorted
+}
+
+// tokenize lowercases text and splits it into alphanumeric terms.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
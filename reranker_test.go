@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type scriptedOpenAI struct {
+	reply string
+	err   error
+}
+
+func (s *scriptedOpenAI) ChatCompletion(ctx context.Context, model string, messages []Message) (string, error) {
+	return s.reply, s.err
+}
+
+func TestKeywordRerankerPromotesLexicalMatch(t *testing.T) {
+	docs := []Document{
+		{Text: "Docker runs containers", Source: "docker", Similarity: 0.6},
+		{Text: "Go is a programming language from Google", Source: "go", Similarity: 0.55},
+	}
+	reranked, err := (&KeywordReranker{}).Rerank(context.Background(), "what is the go programming language", docs)
+	if err != nil {
+		t.Fatalf("Rerank returned error: %v", err)
+	}
+	if reranked[0].Source != "go" {
+		t.Fatalf("expected go document first, got %s", reranked[0].Source)
+	}
+}
+
+func TestLLMRerankerUsesModelScores(t *testing.T) {
+	oa := &scriptedOpenAI{reply: "1: 2\n2: 9\n3: 5"}
+	docs := []Document{{Source: "a"}, {Source: "b"}, {Source: "c"}}
+	reranked, err := NewLLMReranker(oa, "gpt-test").Rerank(context.Background(), "q", docs)
+	if err != nil {
+		t.Fatalf("Rerank returned error: %v", err)
+	}
+	expected := []string{"b", "c", "a"}
+	for i, src := range expected {
+		if reranked[i].Source != src {
+			t.Fatalf("position %d expected %s got %s", i, src, reranked[i].Source)
+		}
+	}
+}
+
+func TestLLMRerankerFallsBackOnError(t *testing.T) {
+	oa := &scriptedOpenAI{err: errors.New("boom")}
+	docs := []Document{
+		{Text: "unrelated", Source: "a", Similarity: 0.5},
+		{Text: "cats purr", Source: "b", Similarity: 0.5},
+	}
+	reranked, err := NewLLMReranker(oa, "gpt-test").Rerank(context.Background(), "cats", docs)
+	if err != nil {
+		t.Fatalf("Rerank returned error: %v", err)
+	}
+	if reranked[0].Source != "b" {
+		t.Fatalf("expected fallback scoring to promote b, got %s", reranked[0].Source)
+	}
+}
+
+func TestRetrieveReranksAndTrims(t *testing.T) {
+	mv := NewMemoryStore(NewHashingEmbedder(256))
+	mv.InsertDocuments(context.Background(), []string{"weather report", "cats and dogs", "cats purr loudly"}, []string{"a", "b", "c"}, nil)
+	engine := NewRAGEngine(&dummyOpenAI{}, mv, WithReranker(&KeywordReranker{}))
+	docs := engine.Retrieve(context.Background(), "cats", 1)
+	if len(docs) != 1 {
+		t.Fatalf("expected 1 document, got %d", len(docs))
+	}
+	if docs[0].Source == "a" {
+		t.Fatalf("expected reranker to demote non-matching document")
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// RetrievalCaseResult records where the expected sources of one EvalCase
+// ranked among the retrieved documents.
+type RetrievalCaseResult struct {
+	Case             EvalCase
+	RetrievedSources []string // in rank order, one entry per retrieved document
+	FirstHit         int      // 1-based rank of the first expected source, 0 if none was retrieved
+}
+
+// RetrievalMetricsAtK are the metrics over the top K documents, averaged over
+// the dataset.
+type RetrievalMetricsAtK struct {
+	K      int
+	Recall float64 // share of expected sources found in the top K
+	NDCG   float64 // normalized discounted cumulative gain, with binary relevance
+}
+
+// RetrievalBenchmark summarizes retrieval quality over a dataset.
+type RetrievalBenchmark struct {
+	Questions int
+	MRR       float64 // mean reciprocal rank of the first expected source
+	AtK       []RetrievalMetricsAtK
+}
+
+// BenchmarkRetrieval retrieves the documents for every case, without
+// generating answers, and scores the ranking against the expected sources
+// with recall@k and nDCG@k for each k in ks, and MRR over the largest k.
+// Retrieval uses the engine's configuration and opts, so runs before and
+// after a chunking, embedding, or reranking change can be compared. Several
+// chunks of one source count as one hit, at the rank of the first.
+func (r *RAGEngine) BenchmarkRetrieval(ctx context.Context, cases []EvalCase, ks []int, opts ...RetrieveOption) ([]RetrievalCaseResult, RetrievalBenchmark) {
+	ks = slices.Clone(ks)
+	slices.Sort(ks)
+	ks = slices.Compact(ks)
+	bench := RetrievalBenchmark{Questions: len(cases)}
+	for _, k := range ks {
+		bench.AtK = append(bench.AtK, RetrievalMetricsAtK{K: k})
+	}
+	if len(cases) == 0 || len(ks) == 0 {
+		return nil, bench
+	}
+
+	results := make([]RetrievalCaseResult, 0, len(cases))
+	for _, c := range cases {
+		caseCtx := withQueryID(ctx, newQueryID())
+		docs := r.Retrieve(caseCtx, c.Question, ks[len(ks)-1], opts...)
+		result := RetrievalCaseResult{Case: c}
+		for _, doc := range docs {
+			result.RetrievedSources = append(result.RetrievedSources, doc.Source)
+		}
+		hits := relevantRanks(result.RetrievedSources, c.ExpectedSources)
+		if len(hits) > 0 {
+			result.FirstHit = hits[0]
+			bench.MRR += 1 / float64(hits[0])
+		}
+		expected := len(uniqueStrings(c.ExpectedSources))
+		for i, k := range ks {
+			if expected == 0 {
+				continue
+			}
+			var found int
+			var dcg, idcg float64
+			for _, rank := range hits {
+				if rank <= k {
+					found++
+					dcg += 1 / math.Log2(float64(rank)+1)
+				}
+			}
+			for rank := 1; rank <= min(expected, k); rank++ {
+				idcg += 1 / math.Log2(float64(rank)+1)
+			}
+			bench.AtK[i].Recall += float64(found) / float64(expected)
+			bench.AtK[i].NDCG += dcg / idcg
+		}
+		results = append(results, result)
+	}
+	n := float64(len(cases))
+	bench.MRR /= n
+	for i := range bench.AtK {
+		bench.AtK[i].Recall /= n
+		bench.AtK[i].NDCG /= n
+	}
+	return results, bench
+}
+
+// relevantRanks returns the 1-based ranks at which each expected source first
+// appears in retrieved, in increasing order.
+func relevantRanks(retrieved, expected []string) []int {
+	want := make(map[string]bool, len(expected))
+	for _, source := range expected {
+		want[source] = true
+	}
+	var ranks []int
+	for i, source := range retrieved {
+		if want[source] {
+			ranks = append(ranks, i+1)
+			delete(want, source)
+		}
+	}
+	return ranks
+}
+
+// uniqueStrings returns values without duplicates, in their original order.
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	var unique []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
+
+// parseCutoffs parses a comma-separated list of positive ranks such as
+// "1,3,5,10".
+func parseCutoffs(value string) ([]int, error) {
+	var ks []int
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		k, err := strconv.Atoi(part)
+		if err != nil || k < 1 {
+			return nil, fmt.Errorf("invalid cutoff %q: expected a positive integer", part)
+		}
+		ks = append(ks, k)
+	}
+	if len(ks) == 0 {
+		return nil, fmt.Errorf("no cutoffs in %q", value)
+	}
+	return ks, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+func TestBenchmarkRetrieval(t *testing.T) {
+	store := NewMemoryStore(NewHashingEmbedder(256))
+	store.InsertDocuments(context.Background(),
+		[]string{
+			"Go is a programming language with goroutines",
+			"Goroutines are scheduled by the Go runtime",
+			"Milvus is a vector database",
+		},
+		[]string{"Go Docs", "Go Runtime", "Milvus Docs"},
+		nil,
+	)
+	engine := NewRAGEngine(&dummyOpenAI{}, store)
+
+	cases := []EvalCase{
+		{Question: "Go programming language goroutines", ExpectedSources: []string{"Go Docs", "Go Runtime"}},
+		{Question: "vector database", ExpectedSources: []string{"Docker Docs"}},
+	}
+	results, bench := engine.BenchmarkRetrieval(context.Background(), cases, []int{3, 1, 3})
+	if len(bench.AtK) != 2 || bench.AtK[0].K != 1 || bench.AtK[1].K != 3 {
+		t.Fatalf("expected sorted, deduplicated cutoffs, got %+v", bench.AtK)
+	}
+	if results[0].FirstHit != 1 || results[1].FirstHit != 0 {
+		t.Fatalf("unexpected first hits %+v", results)
+	}
+	// The first question finds one of two sources at k=1 and both at k=3; the
+	// second finds nothing.
+	if bench.MRR != 0.5 || bench.AtK[0].Recall != 0.25 || bench.AtK[1].Recall != 0.5 {
+		t.Fatalf("unexpected metrics %+v", bench)
+	}
+	if math.Abs(bench.AtK[0].NDCG-0.5) > 1e-9 || math.Abs(bench.AtK[1].NDCG-0.5) > 1e-9 {
+		t.Fatalf("unexpected nDCG %+v", bench.AtK)
+	}
+}
+
+func TestRelevantRanksCountsEachSourceOnce(t *testing.T) {
+	ranks := relevantRanks([]string{"a", "b", "a", "c"}, []string{"a", "c", "d"})
+	if len(ranks) != 2 || ranks[0] != 1 || ranks[1] != 4 {
+		t.Fatalf("unexpected ranks %v", ranks)
+	}
+}
+
+func TestParseCutoffs(t *testing.T) {
+	ks, err := parseCutoffs("1, 5,10")
+	if err != nil || len(ks) != 3 || ks[2] != 10 {
+		t.Fatalf("parseCutoffs = %v, %v", ks, err)
+	}
+	for _, bad := range []string{"", "0", "3,x"} {
+		if _, err := parseCutoffs(bad); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
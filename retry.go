@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy bounds the retries of API requests that were rate limited
+// (429) or failed on the server side (5xx).
+type RetryPolicy struct {
+	MaxAttempts int           // total attempts, including the first
+	BaseDelay   time.Duration // delay before the first retry, doubled for each further one
+	MaxDelay    time.Duration // cap on a single delay
+	MaxElapsed  time.Duration // no retry is started that would end later than this after the first attempt
+}
+
+// DefaultRetryPolicy retries up to four times within two minutes.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+	MaxElapsed:  2 * time.Minute,
+}
+
+// RateLimitError is returned once a request has been retried as often as the
+// policy allows and the API still answers 429 or 5xx. Callers can detect it
+// with errors.As through the provider client's own error wrapping.
+type RateLimitError struct {
+	Provider   string
+	StatusCode int
+	Attempts   int
+	RetryAfter time.Duration // the server's last requested delay, if any
+	Message    string        // start of the last response body
+}
+
+func (e *RateLimitError) Error() string {
+	msg := fmt.Sprintf("%s API request failed with status %d after %d attempts", e.Provider, e.StatusCode, e.Attempts)
+	if e.RetryAfter > 0 {
+		msg += fmt.Sprintf(" (retry after %s)", e.RetryAfter)
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// retryTransport retries requests according to a RetryPolicy. Retrying at
+// the transport keeps the response headers, such as Retry-After, in reach,
+// which provider SDKs drop from the errors they return.
+type retryTransport struct {
+	base     http.RoundTripper
+	provider string
+	policy   RetryPolicy
+}
+
+// newRetryingHTTPClient returns an HTTP client whose requests to provider
+// are sent through base and retried according to policy.
+func newRetryingHTTPClient(provider string, policy RetryPolicy, base http.RoundTripper) *http.Client {
+	return &http.Client{Transport: &retryTransport{base: base, provider: provider, policy: policy}}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if err != nil || !retryableStatus(resp.StatusCode) {
+			return resp, err
+		}
+		// Requests whose body cannot be replayed are not retried.
+		if req.Body != nil && req.GetBody == nil {
+			return resp, nil
+		}
+
+		retryAfter := retryAfterDelay(resp.Header, time.Now())
+		delay := retryAfter
+		if delay == 0 {
+			delay = t.policy.backoff(attempt)
+		}
+		if attempt >= t.policy.MaxAttempts || time.Since(start)+delay > t.policy.MaxElapsed {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+			resp.Body.Close()
+			return nil, &RateLimitError{
+				Provider:   t.provider,
+				StatusCode: resp.StatusCode,
+				Attempts:   attempt,
+				RetryAfter: retryAfter,
+				Message:    strings.TrimSpace(string(body)),
+			}
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+
+		apiRetries.WithLabelValues(t.provider, strconv.Itoa(resp.StatusCode)).Inc()
+		slog.WarnContext(req.Context(), "Retrying API request", "provider", t.provider, "status", resp.StatusCode, "attempt", attempt, "delay", delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+
+		req = req.Clone(req.Context())
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// backoff returns the delay before retry number attempt: the base delay
+// doubled per attempt and capped, with up to half of it randomized so that
+// concurrent clients spread out.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay << (attempt - 1)
+	if delay > p.MaxDelay || delay <= 0 {
+		delay = p.MaxDelay
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// retryAfterDelay reads the delay a server asked for in Retry-After (seconds
+// or an HTTP date) or OpenAI's retry-after-ms header, or 0 if there is none.
+func retryAfterDelay(header http.Header, now time.Time) time.Duration {
+	if ms, err := strconv.ParseFloat(header.Get("Retry-After-Ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	value := header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+var fastRetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond, MaxElapsed: time.Second}
+
+// newFlakyOpenAIServer answers the first failures requests with status and
+// later ones with an embedding, recording every request body.
+func newFlakyOpenAIServer(failures, status int, bodies *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		*bodies = append(*bodies, string(body))
+		if len(*bodies) <= failures {
+			w.WriteHeader(status)
+			w.Write([]byte(`{"error":{"message":"slow down","type":"rate_limit"}}`))
+			return
+		}
+		w.Write([]byte(`{"data":[{"embedding":[1,2],"index":0}],"usage":{"prompt_tokens":1}}`))
+	}))
+}
+
+func newTestOpenAIClient(baseURL string, policy RetryPolicy) *openai.Client {
+	config := openai.DefaultConfig("key")
+	config.BaseURL = baseURL
+	config.HTTPClient = newRetryingHTTPClient("OpenAI", policy, http.DefaultTransport)
+	return openai.NewClientWithConfig(config)
+}
+
+func TestRetryTransportRetriesRateLimits(t *testing.T) {
+	var bodies []string
+	server := newFlakyOpenAIServer(2, http.StatusTooManyRequests, &bodies)
+	defer server.Close()
+
+	embedder, _ := NewOpenAIEmbedder(newTestOpenAIClient(server.URL, fastRetryPolicy), "text-embedding-ada-002", 0)
+	embeddings, err := embedder.Embed(context.Background(), []string{"hello"})
+	if err != nil {
+		t.Fatalf("Embed returned error: %v", err)
+	}
+	if len(embeddings) != 1 || len(bodies) != 3 {
+		t.Fatalf("expected success on the third attempt, got %d attempts", len(bodies))
+	}
+	if bodies[2] != bodies[0] || bodies[0] == "" {
+		t.Fatalf("expected the request body to be replayed, got %q", bodies)
+	}
+}
+
+func TestRetryTransportReturnsRateLimitError(t *testing.T) {
+	var bodies []string
+	server := newFlakyOpenAIServer(10, http.StatusServiceUnavailable, &bodies)
+	defer server.Close()
+
+	embedder, _ := NewOpenAIEmbedder(newTestOpenAIClient(server.URL, fastRetryPolicy), "text-embedding-ada-002", 0)
+	_, err := embedder.Embed(context.Background(), []string{"hello"})
+	var rateLimit *RateLimitError
+	if !errors.As(err, &rateLimit) {
+		t.Fatalf("expected a RateLimitError, got %v", err)
+	}
+	if rateLimit.StatusCode != http.StatusServiceUnavailable || rateLimit.Attempts != 3 || len(bodies) != 3 {
+		t.Fatalf("unexpected error %+v after %d requests", rateLimit, len(bodies))
+	}
+}
+
+func TestRetryTransportSkipsClientErrors(t *testing.T) {
+	var bodies []string
+	server := newFlakyOpenAIServer(1, http.StatusBadRequest, &bodies)
+	defer server.Close()
+
+	embedder, _ := NewOpenAIEmbedder(newTestOpenAIClient(server.URL, fastRetryPolicy), "text-embedding-ada-002", 0)
+	if _, err := embedder.Embed(context.Background(), []string{"hello"}); err == nil {
+		t.Fatalf("expected the 400 response to fail")
+	}
+	if len(bodies) != 1 {
+		t.Fatalf("expected no retries of a 400 response, got %d requests", len(bodies))
+	}
+}
+
+func TestRetryAfterDelay(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header http.Header
+		want   time.Duration
+	}{
+		{http.Header{"Retry-After": {"3"}}, 3 * time.Second},
+		{http.Header{"Retry-After": {now.Add(10 * time.Second).Format(http.TimeFormat)}}, 10 * time.Second},
+		{http.Header{"Retry-After-Ms": {"250"}, "Retry-After": {"1"}}, 250 * time.Millisecond},
+		{http.Header{"Retry-After": {"soon"}}, 0},
+		{http.Header{}, 0},
+	}
+	for _, tt := range tests {
+		if got := retryAfterDelay(tt.header, now); got != tt.want {
+			t.Errorf("retryAfterDelay(%v) = %s, want %s", tt.header, got, tt.want)
+		}
+	}
+}
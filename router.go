@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Route describes which queries should search a collection.
+type Route struct {
+	Collection string `json:"collection"`
+	// Description says what the collection holds, for LLMRouter.
+	Description string `json:"description,omitempty"`
+	// Keywords send a query to the collection, for KeywordRouter. A keyword
+	// of several words matches when the query has all of them.
+	Keywords []string `json:"keywords,omitempty"`
+}
+
+// QueryRouter picks the collections of a collection store that a query
+// should search.
+type QueryRouter interface {
+	// Route returns the collections to search for query, most relevant
+	// first; none means all of them.
+	Route(ctx context.Context, query string) ([]string, error)
+}
+
+// LoadRoutes reads a JSON array of Route entries, e.g.
+//
+//	[{"collection": "tickets", "description": "Support tickets and their resolutions", "keywords": ["error", "bug"]}]
+func LoadRoutes(path string) ([]Route, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var routes []Route
+	if err := json.Unmarshal(data, &routes); err != nil {
+		return nil, fmt.Errorf("parsing routes %s: %w", path, err)
+	}
+	for i, route := range routes {
+		if route.Collection == "" {
+			return nil, fmt.Errorf("route %d in %s has no collection", i+1, path)
+		}
+	}
+	return routes, nil
+}
+
+// WithQueryRouter routes every query that does not name its collections
+// (see WithCollectionWeights) to the collections router picks, keeping their
+// configured weights. It needs a collection store; if routing fails or
+// picks nothing, every collection is searched.
+func WithQueryRouter(router QueryRouter) EngineOption {
+	return func(r *RAGEngine) {
+		r.router = router
+	}
+}
+
+// routeQuery returns the collections the router picks for query, limited to
+// those the engine searches, or nil for all of them.
+func (r *RAGEngine) routeQuery(ctx context.Context, query string) []string {
+	available := r.Collections()
+	if available == nil {
+		return nil
+	}
+	ctx, span := tracer.Start(ctx, "rag.route")
+	defer span.End()
+	picked, err := r.router.Route(ctx, query)
+	if err != nil {
+		errorsTotal.WithLabelValues("route").Inc()
+		slog.WarnContext(ctx, "Routing the query failed, searching every collection", "error", err)
+		return nil
+	}
+	var collections []string
+	for _, name := range picked {
+		if slices.Contains(available, name) && !slices.Contains(collections, name) {
+			collections = append(collections, name)
+		}
+	}
+	if len(collections) == 0 {
+		routedQueries.WithLabelValues("all").Inc()
+		slog.DebugContext(ctx, "No route for the query, searching every collection")
+		return nil
+	}
+	for _, name := range collections {
+		routedQueries.WithLabelValues(name).Inc()
+	}
+	span.SetAttributes(attribute.StringSlice("rag.collections", collections))
+	slog.InfoContext(ctx, "Routed the query", "collections", collections)
+	return collections
+}
+
+// KeywordRouter is a local, dependency-free router: a query goes to every
+// collection one of whose keywords it mentions.
+type KeywordRouter struct {
+	Routes []Route
+}
+
+func (k *KeywordRouter) Route(ctx context.Context, query string) ([]string, error) {
+	terms := tokenize(query)
+	var collections []string
+	for _, route := range k.Routes {
+		if slices.ContainsFunc(route.Keywords, func(keyword string) bool { return mentionsKeyword(terms, keyword) }) {
+			collections = append(collections, route.Collection)
+		}
+	}
+	return collections, nil
+}
+
+// mentionsKeyword reports whether terms, a tokenized query, contain every
+// word of keyword.
+func mentionsKeyword(terms []string, keyword string) bool {
+	words := tokenize(keyword)
+	return len(words) > 0 && !slices.ContainsFunc(words, func(word string) bool { return !slices.Contains(terms, word) })
+}
+
+// LLMRouter asks a chat model, ideally a small, fast one, which collections
+// can answer the query, from their descriptions. If the call fails, the
+// keywords of the routes decide instead.
+type LLMRouter struct {
+	client   LLMClient
+	model    string
+	routes   []Route
+	fallback QueryRouter
+}
+
+// NewLLMRouter builds a router that asks model to choose among routes, with
+// a keyword fallback.
+func NewLLMRouter(client LLMClient, model string, routes []Route) *LLMRouter {
+	return &LLMRouter{client: client, model: model, routes: routes, fallback: &KeywordRouter{Routes: routes}}
+}
+
+// Route asks the model for the names of the collections to search. A reply
+// of "all", or without any known name, searches every collection.
+func (l *LLMRouter) Route(ctx context.Context, query string) ([]string, error) {
+	var list strings.Builder
+	for _, route := range l.routes {
+		fmt.Fprintf(&list, "- %s: %s\n", route.Collection, route.Description)
+	}
+	messages := []Message{
+		{Role: "system", Content: "You route questions to the knowledge bases that can answer them."},
+		{Role: "user", Content: "Knowledge bases:\n" + list.String() + "\n" +
+			"Which of these knowledge bases could contain the answer to the question below? " +
+			"Reply with their names, comma-separated, most relevant first, and nothing else. " +
+			"Reply with all if the question could be answered by any of them or you cannot tell.\n\n" +
+			"Question: " + query},
+	}
+	reply, err := l.client.ChatCompletion(ctx, l.model, messages)
+	if err != nil {
+		slog.WarnContext(ctx, "LLM routing failed, routing by keywords", "error", err)
+		return l.fallback.Route(ctx, query)
+	}
+	var collections []string
+	for _, name := range strings.FieldsFunc(reply, func(r rune) bool { return r == ',' || r == '\n' }) {
+		name = strings.Trim(strings.TrimSpace(name), "-*`\"'.")
+		if slices.ContainsFunc(l.routes, func(route Route) bool { return route.Collection == name }) {
+			collections = append(collections, name)
+		}
+	}
+	return collections, nil
+}
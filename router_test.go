@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var testRoutes = []Route{
+	{Collection: "docs", Description: "Product documentation", Keywords: []string{"api", "install"}},
+	{Collection: "tickets", Description: "Support tickets and their resolutions", Keywords: []string{"error", "bug report"}},
+}
+
+func TestKeywordRouter(t *testing.T) {
+	router := &KeywordRouter{Routes: testRoutes}
+	for query, want := range map[string][]string{
+		"How do I install the API client?":     {"docs"},
+		"Which bug report mentions error 502?": {"tickets"},
+		"Install fails with an error":          {"docs", "tickets"},
+		"Who is on call this week?":            nil,
+	} {
+		if got, _ := router.Route(context.Background(), query); !slices.Equal(got, want) {
+			t.Errorf("Route(%q) = %v, want %v", query, got, want)
+		}
+	}
+}
+
+func TestLLMRouterParsesTheReply(t *testing.T) {
+	oa := &sequenceOpenAI{replies: []string{"tickets, wiki", "all"}}
+	router := NewLLMRouter(oa, "gpt-mini", testRoutes)
+	if got, err := router.Route(context.Background(), "Why does checkout fail?"); err != nil || !slices.Equal(got, []string{"tickets"}) {
+		t.Fatalf("got %v, %v", got, err)
+	}
+	if !strings.Contains(oa.calls[0][1].Content, "- tickets: Support tickets and their resolutions") {
+		t.Fatalf("expected the descriptions in the prompt, got %q", oa.calls[0][1].Content)
+	}
+	if got, _ := router.Route(context.Background(), "Hello?"); got != nil {
+		t.Fatalf("expected every collection for all, got %v", got)
+	}
+	// Out of replies, the call fails and the keywords decide.
+	if got, _ := router.Route(context.Background(), "How do I install it?"); !slices.Equal(got, []string{"docs"}) {
+		t.Fatalf("expected the keyword fallback, got %v", got)
+	}
+}
+
+// failingRouter always fails.
+type failingRouter struct{}
+
+func (failingRouter) Route(ctx context.Context, query string) ([]string, error) {
+	return nil, errors.New("timeout")
+}
+
+func TestRetrieveSearchesTheRoutedCollections(t *testing.T) {
+	docs, tickets, _ := newCollections(t)
+	store, _ := NewCollectionStore(Collection{Name: "docs", Store: docs}, Collection{Name: "tickets", Store: tickets, Weight: 0.5})
+	engine := NewRAGEngine(&dummyOpenAI{}, store, WithQueryRouter(&KeywordRouter{Routes: testRoutes}))
+
+	results := engine.Retrieve(context.Background(), "Bug report: where are invoices stored?", 5)
+	if len(results) != 1 || results[0].Metadata[collectionField] != "tickets" || results[0].Similarity > 0.5 {
+		t.Fatalf("expected the tickets collection with its weight, got %+v", results)
+	}
+	// Collections named by the caller take precedence over the route.
+	results = engine.Retrieve(context.Background(), "Bug report: where are invoices stored?", 5, WithCollectionWeights(map[string]float64{"docs": 1}))
+	if len(results) != 1 || results[0].Metadata[collectionField] != "docs" {
+		t.Fatalf("expected the requested docs collection, got %+v", results)
+	}
+
+	engine = NewRAGEngine(&dummyOpenAI{}, store, WithQueryRouter(failingRouter{}))
+	if results := engine.Retrieve(context.Background(), "Where are invoices stored?", 5); len(results) != 2 {
+		t.Fatalf("expected every collection when routing fails, got %+v", results)
+	}
+}
+
+func TestLoadRoutes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routes.json")
+	os.WriteFile(path, []byte(`[{"collection": "tickets", "keywords": ["error"]}, {"description": "no name"}]`), 0o644)
+	if _, err := LoadRoutes(path); err == nil || !strings.Contains(err.Error(), "route 2") {
+		t.Fatalf("expected an error for the route without a collection, got %v", err)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+)
+
+// SyncJob is an ingestion source that the scheduler re-ingests on a cron
+// schedule. Exactly one of Dir, Bucket, GitHub, URL, or Connector names the
+// source. Re-ingestion is incremental: unchanged chunks are skipped, and
+// connectors only fetch pages edited since their last sync.
+type SyncJob struct {
+	Name     string `json:"name"`
+	Schedule string `json:"schedule"` // cron expression, see ParseCron
+
+	Dir    string `json:"dir,omitempty"`
+	Bucket string `json:"bucket,omitempty"` // s3:// or gs:// location
+	GitHub string `json:"github,omitempty"` // owner/repo[@ref]
+	// URL is the web page to crawl, or the sitemap of the sitemap connector.
+	URL      string `json:"url,omitempty"`
+	Depth    int    `json:"depth,omitempty"`
+	MaxPages int    `json:"max_pages,omitempty"` // default 100
+	// Connector is notion, confluence, or sitemap.
+	Connector string `json:"connector,omitempty"`
+	Database  string `json:"database,omitempty"` // Notion database
+	Space     string `json:"space,omitempty"`    // Confluence space key
+
+	Include   []string `json:"include,omitempty"`
+	Exclude   []string `json:"exclude,omitempty"`
+	Workers   int      `json:"workers,omitempty"`    // default 4
+	ChunkSize int      `json:"chunk_size,omitempty"` // default 1000
+	Overlap   int      `json:"overlap,omitempty"`    // default 200
+	ACL       []string `json:"acl,omitempty"`
+	Partition string   `json:"partition,omitempty"`
+
+	cron CronSchedule
+}
+
+// LoadSyncJobs reads a JSON array of SyncJob entries, e.g.
+//
+//	[{"name": "handbook", "schedule": "0 * * * *", "bucket": "s3://docs/handbook/"},
+//	 {"name": "wiki", "schedule": "*/30 9-18 * * 1-5", "connector": "confluence", "space": "ENG"}]
+func LoadSyncJobs(path string) ([]SyncJob, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var jobs []SyncJob
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		return nil, fmt.Errorf("parsing sync jobs %s: %w", path, err)
+	}
+	seen := make(map[string]bool)
+	for i := range jobs {
+		job := &jobs[i]
+		if job.Name == "" || seen[job.Name] {
+			return nil, fmt.Errorf("sync job %d in %s needs a unique name", i+1, path)
+		}
+		seen[job.Name] = true
+		if job.cron, err = ParseCron(job.Schedule); err != nil {
+			return nil, fmt.Errorf("sync job %s: %w", job.Name, err)
+		}
+		sources := 0
+		for _, set := range []bool{job.Dir != "", job.Bucket != "", job.GitHub != "", job.URL != "" && job.Connector == "", job.Connector != ""} {
+			if set {
+				sources++
+			}
+		}
+		if sources != 1 {
+			return nil, fmt.Errorf("sync job %s needs exactly one of dir, bucket, github, url, or connector", job.Name)
+		}
+		if job.Connector != "" && !slices.Contains(connectorKinds, job.Connector) {
+			return nil, fmt.Errorf("sync job %s: unknown connector %q (expected notion, confluence, or sitemap)", job.Name, job.Connector)
+		}
+		if job.Partition != "" {
+			if partitions, err := parsePartitions(job.Partition); err != nil || len(partitions) != 1 {
+				return nil, fmt.Errorf("sync job %s: partition must be a single partition name", job.Name)
+			}
+		}
+	}
+	return jobs, nil
+}
+
+func (j SyncJob) options() DirectoryOptions {
+	return DirectoryOptions{
+		Include:   j.Include,
+		Exclude:   j.Exclude,
+		Workers:   cmp.Or(j.Workers, 4),
+		ChunkSize: cmp.Or(j.ChunkSize, 1000),
+		Overlap:   cmp.Or(j.Overlap, 200),
+	}
+}
+
+// SyncRun reports one run of a sync job.
+type SyncRun struct {
+	Job             string    `json:"job"`
+	Started         time.Time `json:"started"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Files           int       `json:"files"`
+	FilesSkipped    int       `json:"files_skipped"`
+	FilesRemoved    int       `json:"files_removed"`
+	FilesFailed     []string  `json:"files_failed,omitempty"`
+	ChunksInserted  int       `json:"chunks_inserted"`
+	ChunksUpdated   int       `json:"chunks_updated"`
+	ChunksSkipped   int       `json:"chunks_skipped"`
+	ChunksRemoved   int       `json:"chunks_removed"`
+	ChunksDropped   int       `json:"chunks_dropped"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// OK reports whether the run ingested every file.
+func (r SyncRun) OK() bool {
+	return r.Error == "" && len(r.FilesFailed) == 0
+}
+
+// Scheduler re-runs sync jobs on their schedules. Runs are sequential, so a
+// job never overlaps itself or another job; a run that overruns the next
+// scheduled time skips it.
+type Scheduler struct {
+	engine *RAGEngine
+	jobs   []SyncJob
+	state  *SyncState
+	// reportPath, if set, is a JSONL file every run's SyncRun is appended to.
+	reportPath string
+	// ingest runs a job; tests replace it.
+	ingest func(ctx context.Context, engine *RAGEngine, job SyncJob, state *SyncState) (DirectoryReport, error)
+}
+
+// NewScheduler returns a scheduler that runs jobs against engine, keeping
+// connector state in state.
+func NewScheduler(engine *RAGEngine, jobs []SyncJob, state *SyncState, reportPath string) *Scheduler {
+	return &Scheduler{engine: engine, jobs: jobs, state: state, reportPath: reportPath, ingest: ingestSyncJob}
+}
+
+// Run runs each job at its scheduled times until ctx is canceled.
+func (s *Scheduler) Run(ctx context.Context) {
+	next := make([]time.Time, len(s.jobs))
+	for i, job := range s.jobs {
+		next[i] = job.cron.Next(time.Now())
+		slog.InfoContext(ctx, "Scheduled sync job", "job", job.Name, "schedule", job.Schedule, "next", next[i])
+	}
+	for {
+		due := -1
+		for i, t := range next {
+			if !t.IsZero() && (due < 0 || t.Before(next[due])) {
+				due = i
+			}
+		}
+		if due < 0 {
+			slog.WarnContext(ctx, "No sync job is scheduled to run again")
+			<-ctx.Done()
+			return
+		}
+		timer := time.NewTimer(time.Until(next[due]))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.RunJob(ctx, s.jobs[due])
+		next[due] = s.jobs[due].cron.Next(time.Now())
+	}
+}
+
+// RunJob runs job once, saves the connector state, and logs and records its
+// report.
+func (s *Scheduler) RunJob(ctx context.Context, job SyncJob) SyncRun {
+	start := time.Now()
+	slog.InfoContext(ctx, "Running sync job", "job", job.Name)
+	engine := s.engine.WithIngestACL(job.ACL).WithIngestPartition(job.Partition)
+	report, err := s.ingest(ctx, engine, job, s.state)
+	if job.Connector != "" {
+		if saveErr := s.state.Save(); saveErr != nil {
+			slog.ErrorContext(ctx, "Saving sync state failed", "error", saveErr)
+		}
+	}
+
+	run := SyncRun{
+		Job:             job.Name,
+		Started:         start.UTC(),
+		DurationSeconds: time.Since(start).Seconds(),
+		Files:           report.Files,
+		FilesSkipped:    report.Skipped,
+		FilesRemoved:    report.Removed,
+		ChunksInserted:  report.Chunks.Inserted,
+		ChunksUpdated:   report.Chunks.Updated,
+		ChunksSkipped:   report.Chunks.Skipped,
+		ChunksRemoved:   report.Chunks.Removed,
+		ChunksDropped:   report.Chunks.Dropped,
+	}
+	for _, fe := range report.Errors {
+		run.FilesFailed = append(run.FilesFailed, fmt.Sprintf("%s: %v", fe.Path, fe.Err))
+	}
+	if err != nil {
+		run.Error = err.Error()
+	}
+	if run.OK() {
+		slog.InfoContext(ctx, "Sync job finished", "job", job.Name, "files", run.Files, "removed", run.FilesRemoved, "chunks", report.Chunks.String(), "duration", time.Since(start).Round(time.Millisecond))
+	} else {
+		slog.ErrorContext(ctx, "Sync job failed", "job", job.Name, "failed", len(run.FilesFailed), "error", run.Error)
+		webhooks.Notify(EventSyncFailed, s.engine.tenant, run)
+	}
+	if err := s.record(run); err != nil {
+		slog.ErrorContext(ctx, "Recording sync report failed", "path", s.reportPath, "error", err)
+	}
+	return run
+}
+
+// record appends run to the report file, if one is configured.
+func (s *Scheduler) record(run SyncRun) error {
+	if s.reportPath == "" {
+		return nil
+	}
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.reportPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// ingestSyncJob ingests the source of job into engine.
+func ingestSyncJob(ctx context.Context, engine *RAGEngine, job SyncJob, state *SyncState) (DirectoryReport, error) {
+	opts := job.options()
+	switch {
+	case job.Dir != "":
+		return IngestDirectory(ctx, engine, job.Dir, opts)
+	case job.Bucket != "":
+		bucket, prefix, err := newBucket(job.Bucket)
+		if err != nil {
+			return DirectoryReport{}, err
+		}
+		return IngestBucket(ctx, engine, bucket, prefix, opts)
+	case job.GitHub != "":
+		owner, name, ref, err := ParseGitHubRepo(job.GitHub)
+		if err != nil {
+			return DirectoryReport{}, err
+		}
+		return IngestGitHubRepo(ctx, engine, NewGitHubRepo(owner, name, ref, os.Getenv("GITHUB_TOKEN")), opts)
+	case job.Connector != "":
+		connector, err := newConnector(job.Connector, job.Database, job.Space, job.URL)
+		if err != nil {
+			return DirectoryReport{}, err
+		}
+		return SyncConnector(ctx, engine, connector, state, opts)
+	default:
+		start := time.Now()
+		pages, err := NewHTMLLoader().Crawl(job.URL, job.Depth, cmp.Or(job.MaxPages, 100))
+		if err != nil {
+			return DirectoryReport{}, err
+		}
+		chunks, ok := ingestPages(ctx, engine, pages, opts.ChunkSize, opts.Overlap)
+		report := DirectoryReport{Files: len(pages), Chunks: chunks, Duration: time.Since(start)}
+		if !ok {
+			return report, errors.New("storing chunks failed")
+		}
+		return report, nil
+	}
+}
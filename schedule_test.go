@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadSyncJobs(t *testing.T) {
+	dir := t.TempDir()
+	write := func(jobs string) string {
+		path := filepath.Join(dir, "jobs.json")
+		if err := os.WriteFile(path, []byte(jobs), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	jobs, err := LoadSyncJobs(write(`[
+		{"name": "handbook", "schedule": "0 * * * *", "dir": "docs", "chunk_size": 500},
+		{"name": "sitemap", "schedule": "@daily", "connector": "sitemap", "url": "https://example.com/sitemap.xml"}
+	]`))
+	if err != nil || len(jobs) != 2 {
+		t.Fatalf("LoadSyncJobs = %+v, %v", jobs, err)
+	}
+	if opts := jobs[0].options(); opts.ChunkSize != 500 || opts.Overlap != 200 || opts.Workers != 4 {
+		t.Fatalf("unexpected options %+v", opts)
+	}
+
+	invalid := map[string]string{
+		`[{"name": "a", "schedule": "0 * * * *"}]`:                                                           "exactly one of",
+		`[{"name": "a", "schedule": "0 * * * *", "dir": "d", "bucket": "s3://b/"}]`:                          "exactly one of",
+		`[{"name": "a", "schedule": "every hour", "dir": "d"}]`:                                              "cron expression",
+		`[{"name": "a", "schedule": "@daily", "dir": "d"}, {"name": "a", "schedule": "@daily", "dir": "e"}]`: "unique name",
+		`[{"name": "a", "schedule": "@daily", "connector": "slack"}]`:                                        "unknown connector",
+		`[{"name": "a", "schedule": "@daily", "dir": "d", "partition": "a,b"}]`:                              "single partition",
+	}
+	for jobs, want := range invalid {
+		if _, err := LoadSyncJobs(write(jobs)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("LoadSyncJobs(%s) returned %v, want an error mentioning %q", jobs, err, want)
+		}
+	}
+}
+
+func TestSchedulerRunJobIngestsIncrementallyAndRecordsReports(t *testing.T) {
+	ctx := context.Background()
+	docs := t.TempDir()
+	os.WriteFile(filepath.Join(docs, "a.md"), []byte("Milvus stores vectors."), 0o644)
+	os.WriteFile(filepath.Join(docs, "b.md"), []byte("Go is a programming language."), 0o644)
+	reportPath := filepath.Join(t.TempDir(), "sync.jsonl")
+
+	engine := NewRAGEngine(&dummyOpenAI{}, NewMemoryStore(NewHashingEmbedder(64)))
+	job := SyncJob{Name: "docs", Dir: docs}
+	scheduler := NewScheduler(engine, []SyncJob{job}, &SyncState{Connectors: map[string]map[string]SyncedPage{}}, reportPath)
+
+	first := scheduler.RunJob(ctx, job)
+	if !first.OK() || first.Files != 2 || first.ChunksInserted != 2 {
+		t.Fatalf("unexpected first run %+v", first)
+	}
+	os.WriteFile(filepath.Join(docs, "b.md"), []byte("Go is a compiled programming language."), 0o644)
+	second := scheduler.RunJob(ctx, job)
+	if !second.OK() || second.ChunksSkipped != 1 || second.ChunksUpdated != 1 || second.ChunksRemoved != 1 {
+		t.Fatalf("expected the re-sync to only replace the edited chunk, got %+v", second)
+	}
+
+	scheduler.ingest = func(context.Context, *RAGEngine, SyncJob, *SyncState) (DirectoryReport, error) {
+		return DirectoryReport{}, errors.New("bucket unreachable")
+	}
+	if failed := scheduler.RunJob(ctx, job); failed.OK() || failed.Error != "bucket unreachable" {
+		t.Fatalf("expected a failed run, got %+v", failed)
+	}
+
+	data, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 recorded runs, got %d", len(lines))
+	}
+	var recorded SyncRun
+	if err := json.Unmarshal([]byte(lines[1]), &recorded); err != nil || recorded.Job != "docs" || recorded.ChunksUpdated != 1 {
+		t.Fatalf("unexpected recorded run %+v (%v)", recorded, err)
+	}
+}
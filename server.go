@@ -0,0 +1,1134 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// maxRequestBytes bounds the size of API request bodies.
+const maxRequestBytes = 10 << 20
+
+// Server exposes the engine over a JSON HTTP API:
+//
+//	POST   /query                  answer a question from the knowledge base
+//	POST   /query/stream           the same, streamed as server-sent events (also GET)
+//	GET    /chat                   multi-turn chat over a WebSocket, one session per connection
+//	POST   /documents              chunk and ingest documents
+//	DELETE /documents?source=...   remove every chunk of a source
+//	GET    /usage                  cumulative token usage and estimated cost
+//	GET    /history                recent queries, newest first
+//	GET    /history/{id}           a query with its context, prompt, and answer
+//	POST   /feedback               rate a query's answer or correct it
+//	GET    /feedback/report        queries with negative feedback or weak context
+//	GET    /metrics                Prometheus metrics
+//	GET    /openapi.json           the OpenAPI specification of this API
+//	GET    /healthz                liveness: the process is serving
+//	GET    /readyz                 readiness: the vector store and LLM are reachable
+//
+// With API keys configured, every endpoint except the monitoring ones
+// (/metrics, /openapi.json, /healthz, and /readyz) requires a key, and each
+// request only sees the documents and history of the key's tenant.
+type Server struct {
+	engine *RAGEngine
+	model  string
+	mux    *http.ServeMux
+
+	keys    []APIKey
+	tenants map[string]*RAGEngine // engines scoped to each key's tenant
+
+	readyMu     sync.Mutex
+	readyChecks []ReadinessCheck
+	ready       ReadinessReport // the last readiness result, from readyAt
+	readyAt     time.Time
+
+	jobs *JobQueue // nil disables /jobs
+
+	experiment *Experiment // nil answers every query with the engine's pipeline
+
+	chatOrigins []string // besides the server's own, origins whose pages may open /chat
+}
+
+// publicPaths are served without an API key: monitoring and probes, which
+// reveal nothing about tenants' documents.
+var publicPaths = map[string]bool{"/metrics": true, "/openapi.json": true, "/healthz": true, "/readyz": true}
+
+// NewServer creates an API server answering with the given chat model.
+func NewServer(engine *RAGEngine, model string) *Server {
+	s := &Server{engine: engine, model: model, mux: http.NewServeMux()}
+	s.mux.HandleFunc("POST /query", s.handleQuery)
+	s.mux.HandleFunc("POST /query/stream", s.handleQueryStream)
+	s.mux.HandleFunc("GET /query/stream", s.handleQueryStream)
+	s.mux.Handle("GET /chat", s.chatHandler())
+	s.mux.HandleFunc("POST /summarize", s.handleSummarize)
+	s.mux.HandleFunc("POST /documents", s.handleDocuments)
+	s.mux.HandleFunc("DELETE /documents", s.handleDeleteDocuments)
+	s.mux.HandleFunc("POST /jobs", s.handleSubmitJob)
+	s.mux.HandleFunc("GET /jobs", s.handleJobs)
+	s.mux.HandleFunc("GET /jobs/{id}", s.handleJob)
+	s.mux.HandleFunc("POST /jobs/{id}/cancel", s.handleCancelJob)
+	s.mux.HandleFunc("GET /images/{id}", s.handleImage)
+	s.mux.HandleFunc("GET /usage", s.handleUsage)
+	s.mux.HandleFunc("GET /history", s.handleHistory)
+	s.mux.HandleFunc("GET /history/{id}", s.handleHistoryQuery)
+	s.mux.HandleFunc("POST /feedback", s.handleFeedback)
+	s.mux.HandleFunc("GET /feedback/report", s.handleFeedbackReport)
+	s.mux.Handle("GET /metrics", promhttp.Handler())
+	s.mux.HandleFunc("GET /openapi.json", s.handleOpenAPI)
+	s.mux.HandleFunc("GET /healthz", s.handleHealthz)
+	s.mux.HandleFunc("GET /readyz", s.handleReadyz)
+	return s
+}
+
+// RequireAPIKeys makes the server authenticate every request except the
+// monitoring endpoints with one of keys, sent as
+// "Authorization: Bearer <key>" or in the X-API-Key header (or, for /chat,
+// the api_key query parameter), and confine it to the key's tenant: its
+// queries only retrieve, its ingestion only writes, and its history only
+// lists the tenant's documents and queries.
+func (s *Server) RequireAPIKeys(keys []APIKey) error {
+	tenants := make(map[string]*RAGEngine)
+	for _, key := range keys {
+		if tenants[key.Tenant] != nil {
+			continue
+		}
+		engine, err := s.engine.ForTenant(key.Tenant)
+		if err != nil {
+			return err
+		}
+		tenants[key.Tenant] = engine
+	}
+	s.keys, s.tenants = keys, tenants
+	return nil
+}
+
+type engineKey struct{}
+
+// requestEngine returns the engine of the request's tenant, or the server's
+// engine when no API keys are configured.
+func (s *Server) requestEngine(r *http.Request) *RAGEngine {
+	if engine, ok := r.Context().Value(engineKey{}).(*RAGEngine); ok {
+		return engine
+	}
+	return s.engine
+}
+
+// authenticate resolves the request's API key to its tenant's engine. It
+// reports false when the key is missing or unknown.
+func (s *Server) authenticate(r *http.Request) (*RAGEngine, bool) {
+	key := r.Header.Get("X-API-Key")
+	if key == "" && r.URL.Path == "/chat" {
+		// Browsers cannot set headers on WebSocket handshakes.
+		key = r.URL.Query().Get("api_key")
+	}
+	if auth := r.Header.Get("Authorization"); key == "" && auth != "" {
+		if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+			key = strings.TrimSpace(token)
+		}
+	}
+	if key == "" {
+		return nil, false
+	}
+	entry, ok := lookupAPIKey(s.keys, key)
+	if !ok {
+		return nil, false
+	}
+	return s.tenants[entry.Tenant].ForRoles(entry.Roles), true
+}
+
+// ServeHTTP routes the request and records its status and latency in the
+// HTTP metrics, labelled by the matched route pattern. Each request gets a
+// query ID, taken from the X-Request-ID header when the client sends one,
+// that is echoed in the response and attached to its log lines.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	id := r.Header.Get("X-Request-ID")
+	if id == "" {
+		id = newQueryID()
+	}
+	w.Header().Set("X-Request-ID", id)
+	r = r.WithContext(withQueryID(r.Context(), id))
+
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	if len(s.keys) > 0 && !publicPaths[r.URL.Path] {
+		if engine, ok := s.authenticate(r); ok {
+			r = r.WithContext(withUsageTenant(context.WithValue(r.Context(), engineKey{}, engine), engine.tenant))
+			r = s.withUnrecordedQueryID(w, r)
+			s.mux.ServeHTTP(rec, r)
+		} else {
+			rec.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(rec, http.StatusUnauthorized, "a valid API key is required")
+		}
+	} else {
+		r = s.withUnrecordedQueryID(w, r)
+		s.mux.ServeHTTP(rec, r)
+	}
+
+	route := r.Pattern
+	if route == "" {
+		route = "unmatched"
+	}
+	elapsed := time.Since(start)
+	httpRequests.WithLabelValues(route, strconv.Itoa(rec.status)).Inc()
+	httpDuration.WithLabelValues(route).Observe(elapsed.Seconds())
+	slog.DebugContext(r.Context(), "Handled request", "route", route, "status", rec.status, "duration", elapsed)
+}
+
+// withUnrecordedQueryID gives the request a new query ID if the client's
+// X-Request-ID already names a query in the history, perhaps another
+// tenant's, so that the ID in the response names the query it answers.
+func (s *Server) withUnrecordedQueryID(w http.ResponseWriter, r *http.Request) *http.Request {
+	id := r.Header.Get("X-Request-ID")
+	if id == "" || !s.requestEngine(r).queryRecorded(r.Context(), id) {
+		return r
+	}
+	id = newQueryID()
+	slog.WarnContext(r.Context(), "X-Request-ID already recorded, using a new query ID", "new_query_id", id)
+	w.Header().Set("X-Request-ID", id)
+	return r.WithContext(withQueryID(r.Context(), id))
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Hijack hands the connection to WebSocket handlers, recording the switch
+// of protocols as the status.
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	s.status = http.StatusSwitchingProtocols
+	return http.NewResponseController(s.ResponseWriter).Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// flush streamed responses.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+type queryRequest struct {
+	Question string  `json:"question"`
+	Limit    int     `json:"limit,omitempty"`  // documents to retrieve, default 3
+	Filter   string  `json:"filter,omitempty"` // filter expression, see ParseFilter
+	Model    string  `json:"model,omitempty"`  // overrides the server's chat model
+	MMR      float64 `json:"mmr,omitempty"`    // MMR relevance weight (0-1), 0 disables
+	// Partitions restricts retrieval to documents in these partitions.
+	Partitions []string `json:"partitions,omitempty"`
+	// Languages restricts retrieval to documents in these languages, as
+	// ISO 639-1 codes such as "de".
+	Languages []string `json:"languages,omitempty"`
+	// Collections restricts retrieval to these collections, with the
+	// weights their results are scaled by (see SEARCH_COLLECTIONS).
+	Collections map[string]float64 `json:"collections,omitempty"`
+	// MultiQuery is the number of LLM-written rewordings also searched.
+	MultiQuery int `json:"multi_query,omitempty"`
+	// HyDE searches with a hypothetical answer drafted by the model.
+	HyDE bool `json:"hyde,omitempty"`
+	// GraphHops adds the chunks stating the relations of the question's
+	// entities, up to this many steps away in the knowledge graph.
+	GraphHops int `json:"graph_hops,omitempty"`
+	// Decompose splits a compound question into parts, retrieving for each
+	// (POST /query only).
+	Decompose bool `json:"decompose,omitempty"`
+	// MaxRounds above 1 lets the model search again with refined queries
+	// until the context suffices, up to this many searches (POST /query only).
+	MaxRounds int `json:"max_rounds,omitempty"`
+	// Pipeline answers with this pipeline of the running experiment instead
+	// of the one the query is assigned to.
+	Pipeline string `json:"pipeline,omitempty"`
+}
+
+type queryResponse struct {
+	QueryID    string          `json:"query_id"` // also in the X-Request-ID header; looks the query up in /history
+	Answer     string          `json:"answer"`
+	Citations  []citationJSON  `json:"citations"`
+	NoContext  bool            `json:"no_context"`
+	OutOfScope bool            `json:"out_of_scope,omitempty"` // the topic guard refused the question
+	Fallback   string          `json:"fallback,omitempty"`     // cache, keyword, or llm when the vector store was unavailable
+	Parts      []partJSON      `json:"parts,omitempty"`        // the sub-questions of a decomposed question
+	Rounds     []roundJSON     `json:"rounds,omitempty"`       // the searches of an agentic answer
+	Grading    *gradingJSON    `json:"grading,omitempty"`      // the relevance grading of corrective retrieval, if enabled
+	Usage      *RequestUsage   `json:"usage"`                  // tokens and estimated cost of this query
+	Confidence *confidenceJSON `json:"confidence,omitempty"`   // how well the context supports the answer
+	// Verification lists the answer's claims checked against the context,
+	// with answer verification enabled.
+	Verification *verificationJSON `json:"verification,omitempty"`
+	// Fingerprint identifies the prompt and context chunks in deterministic mode.
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Pipeline is the pipeline of the running experiment that answered.
+	Pipeline string `json:"pipeline,omitempty"`
+	// TimedOut lists the stages that ran past their timeout, such as
+	// "rerank", and that the answer was produced without.
+	TimedOut []string `json:"timed_out,omitempty"`
+}
+
+type confidenceJSON struct {
+	Score      float64  `json:"score"`
+	Level      string   `json:"level"` // high, medium, or low
+	Similarity float64  `json:"similarity"`
+	Grading    *float64 `json:"grading,omitempty"`     // share of graded chunks that were relevant
+	SelfRating *float64 `json:"self_rating,omitempty"` // the model's own rating, with self-evaluation
+}
+
+type verificationJSON struct {
+	Claims      []claimJSON `json:"claims"`
+	Unsupported int         `json:"unsupported"`
+	Regenerated bool        `json:"regenerated"`
+}
+
+type claimJSON struct {
+	Text      string `json:"text"`
+	Markers   []int  `json:"markers"`
+	Supported bool   `json:"supported"`
+}
+
+type gradingJSON struct {
+	Graded    int                 `json:"graded"`
+	Discarded []roundDocumentJSON `json:"discarded"`         // chunks that failed grading and were left out of the context
+	Actions   []string            `json:"actions,omitempty"` // fallbacks run because too few chunks were relevant: rewrite or web
+}
+
+type roundJSON struct {
+	Query     string              `json:"query"`
+	Documents []roundDocumentJSON `json:"documents"`
+	New       int                 `json:"new"`               // documents earlier rounds had not found
+	Verdict   string              `json:"verdict,omitempty"` // sufficient or insufficient; empty for the last round
+	Reason    string              `json:"reason,omitempty"`
+}
+
+type roundDocumentJSON struct {
+	Source     string  `json:"source"`
+	Text       string  `json:"text"`
+	Similarity float32 `json:"similarity"`
+}
+
+type partJSON struct {
+	Question  string         `json:"question"`
+	Citations []citationJSON `json:"citations"`
+}
+
+type citationJSON struct {
+	Marker     int            `json:"marker"`
+	Source     string         `json:"source"`
+	ChunkStart int            `json:"chunk_start"`
+	ChunkEnd   int            `json:"chunk_end"`
+	URL        string         `json:"url,omitempty"`
+	Web        bool           `json:"web,omitempty"`       // a web search result, not from the knowledge base
+	ImageURL   string         `json:"image_url,omitempty"` // the image the cited chunk describes
+	Timecode   string         `json:"timecode,omitempty"`  // the cited transcript chunk's time range
+	Text       string         `json:"text"`
+	Similarity float32        `json:"similarity"`
+	Metadata   map[string]any `json:"metadata,omitempty"`
+}
+
+type documentsRequest struct {
+	Documents []documentJSON `json:"documents"`
+	ChunkSize int            `json:"chunk_size,omitempty"` // default 1000
+	Overlap   int            `json:"overlap,omitempty"`    // default 200
+	// Partition groups the documents that do not name one in their metadata.
+	Partition string `json:"partition,omitempty"`
+	// ExpiresAt, an RFC 3339 timestamp or a date, is when the documents that
+	// do not set expires_at in their metadata expire.
+	ExpiresAt string `json:"expires_at,omitempty"`
+}
+
+type documentJSON struct {
+	Text     string         `json:"text"`
+	Source   string         `json:"source"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+	Format   string         `json:"format,omitempty"` // "markdown", "code", "transcript", or empty for plain text
+	// Image is a base64-encoded image to describe with the vision model,
+	// with Text as its caption, which may be empty.
+	Image    string `json:"image,omitempty"`
+	MIMEType string `json:"mime_type,omitempty"` // the image's type, detected if empty
+}
+
+type documentsResponse struct {
+	Inserted int `json:"inserted"`
+	Updated  int `json:"updated"`
+	Skipped  int `json:"skipped"`
+	Removed  int `json:"removed"`
+	Dropped  int `json:"dropped"`
+}
+
+func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	var req queryRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	model, opts, ok := s.queryOptions(w, &req)
+	if !ok {
+		return
+	}
+
+	usage := &RequestUsage{}
+	grading := &RelevanceReport{}
+	timeouts := &TimeoutReport{}
+	ctx := withTimeoutReport(withRelevanceReport(withRequestUsage(r.Context(), usage), grading), timeouts)
+	v, err := s.variant(w, r, &req, model, opts)
+	if err != nil {
+		slog.ErrorContext(ctx, "Configuring the pipeline failed", "pipeline", v.Pipeline, "error", err)
+		writeError(w, http.StatusInternalServerError, "configuring the pipeline failed")
+		return
+	}
+	ctx, cancel := v.Engine.withQueryTimeout(ctx)
+	defer cancel()
+	start := time.Now()
+	var answer Answer
+	switch {
+	case req.Decompose:
+		answer, err = v.Engine.AnswerDecomposed(ctx, req.Question, v.Limit, v.Model, v.Options...)
+	case req.MaxRounds > 1:
+		answer, err = v.Engine.AnswerIterative(ctx, req.Question, v.Limit, v.Model, req.MaxRounds, v.Options...)
+	default:
+		var refused bool
+		if answer, refused = v.Engine.OutOfScope(ctx, req.Question); !refused {
+			docs := v.Engine.Retrieve(ctx, req.Question, v.Limit, v.Options...)
+			answer, err = v.Engine.GenerateResponse(ctx, req.Question, docs, v.Model)
+		}
+	}
+	err = stageTimedOut(ctx, stageQuery, err)
+	if s.experiment != nil {
+		s.experiment.observe(v.Pipeline, answer, err, time.Since(start))
+	}
+	if writePolicyError(w, err) || writeTimeoutError(w, err) {
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Query failed", "error", err)
+		writeError(w, http.StatusBadGateway, "generating answer failed")
+		return
+	}
+	resp := newQueryResponse(answer)
+	resp.QueryID = w.Header().Get("X-Request-ID")
+	resp.Grading = newGradingJSON(grading)
+	resp.Usage = usage
+	resp.Pipeline = v.Pipeline
+	resp.TimedOut = timeouts.Stages()
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// variant returns how the request's engine answers the query: with the
+// server's experiment, the pipeline the request names or, by the client's
+// X-Request-ID or else its query ID, the one it is assigned to.
+func (s *Server) variant(w http.ResponseWriter, r *http.Request, req *queryRequest, model string, opts []RetrieveOption) (Variant, error) {
+	engine := s.requestEngine(r)
+	if s.experiment == nil {
+		return Variant{Engine: engine, Model: model, Limit: req.Limit, Options: opts}, nil
+	}
+	arm := s.experiment.arm(req.Pipeline)
+	if arm < 0 {
+		// The client's ID, even if replaced as already recorded, keeps a
+		// client that resends it on the same pipeline.
+		arm = s.experiment.Assign(cmp.Or(r.Header.Get("X-Request-ID"), w.Header().Get("X-Request-ID")))
+	}
+	v, err := s.experiment.Variant(engine, arm, model, req.Limit, opts)
+	if err == nil {
+		slog.InfoContext(r.Context(), "Answering with an experiment pipeline", "experiment", s.experiment.Name, "pipeline", v.Pipeline)
+	}
+	return v, err
+}
+
+// SetExperiment splits the queries of /query and /query/stream between the
+// pipelines of experiment.
+func (s *Server) SetExperiment(experiment *Experiment) {
+	s.experiment = experiment
+}
+
+// queryOptions validates a query request, defaulting its limit, and returns
+// the chat model and retrieval options it selects. For an invalid request it
+// writes a 400 response and returns false.
+func (s *Server) queryOptions(w http.ResponseWriter, req *queryRequest) (string, []RetrieveOption, bool) {
+	model, opts, err := s.parseQueryOptions(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return "", nil, false
+	}
+	return model, opts, true
+}
+
+// parseQueryOptions is queryOptions for callers that report errors
+// themselves.
+func (s *Server) parseQueryOptions(req *queryRequest) (string, []RetrieveOption, error) {
+	if req.Question == "" {
+		return "", nil, errors.New("question is required")
+	}
+	if req.Limit <= 0 {
+		req.Limit = 3
+	}
+	model := s.model
+	if req.Model != "" {
+		model = req.Model
+	}
+	var opts []RetrieveOption
+	if req.Filter != "" {
+		filter, err := ParseFilter(req.Filter)
+		if err != nil {
+			return "", nil, errors.New("invalid filter: " + err.Error())
+		}
+		opts = append(opts, WithFilter(filter))
+	}
+	if len(req.Partitions) > 0 {
+		partitions, err := parsePartitions(strings.Join(req.Partitions, ","))
+		if err != nil {
+			return "", nil, err
+		}
+		opts = append(opts, WithPartitions(partitions...))
+	}
+	if len(req.Languages) > 0 {
+		langs, err := parseLanguages(strings.Join(req.Languages, ","))
+		if err != nil {
+			return "", nil, err
+		}
+		opts = append(opts, WithLanguages(langs...))
+	}
+	if len(req.Collections) > 0 {
+		if err := s.engine.checkCollectionWeights(req.Collections); err != nil {
+			return "", nil, err
+		}
+		opts = append(opts, WithCollectionWeights(req.Collections))
+	}
+	if req.MMR < 0 || req.MMR > 1 {
+		return "", nil, errors.New("mmr must be between 0 and 1")
+	}
+	if req.MaxRounds < 0 || req.MaxRounds > maxAgentRounds {
+		return "", nil, errors.New("max_rounds must be between 0 and " + strconv.Itoa(maxAgentRounds))
+	}
+	if req.Decompose && req.MaxRounds > 1 {
+		return "", nil, errors.New("use only one of decompose or max_rounds")
+	}
+	if req.MMR > 0 {
+		opts = append(opts, WithMMR(req.MMR))
+	}
+	if req.MultiQuery < 0 || req.MultiQuery > maxQueryVariants {
+		return "", nil, errors.New("multi_query must be between 0 and " + strconv.Itoa(maxQueryVariants))
+	}
+	if req.MultiQuery > 0 {
+		opts = append(opts, WithMultiQuery(model, req.MultiQuery))
+	}
+	if req.HyDE {
+		opts = append(opts, WithHyDE(model))
+	}
+	if req.GraphHops < 0 || req.GraphHops > maxGraphHops {
+		return "", nil, errors.New("graph_hops must be between 0 and " + strconv.Itoa(maxGraphHops))
+	}
+	if req.GraphHops > 0 && s.engine.graph == nil {
+		return "", nil, errors.New("graph_hops needs the knowledge graph, which is disabled (GRAPH_DB)")
+	}
+	if req.GraphHops > 0 {
+		opts = append(opts, WithGraphExpansion(req.GraphHops))
+	}
+	if req.Pipeline != "" && s.experiment == nil {
+		return "", nil, errors.New("pipeline needs an experiment, which is not running (EXPERIMENT_FILE)")
+	}
+	if req.Pipeline != "" {
+		if err := s.experiment.checkPipeline(req.Pipeline); err != nil {
+			return "", nil, err
+		}
+	}
+	return model, opts, nil
+}
+
+type streamStatusJSON struct {
+	Stage     string   `json:"stage"` // "retrieving", "retrieved", or "generating"
+	Documents *int     `json:"documents,omitempty"`
+	Sources   []string `json:"sources,omitempty"`
+}
+
+// handleQueryStream answers like handleQuery, as server-sent events: status
+// events while retrieving and generating, token events with the answer text
+// as the model writes it, and a final citations event with the complete
+// response. Failures after the stream has started arrive as an error event.
+// POST takes the JSON body of /query; GET, for EventSource clients, takes
+// question, limit, filter, partitions, model, and pipeline as query
+// parameters.
+func (s *Server) handleQueryStream(w http.ResponseWriter, r *http.Request) {
+	var req queryRequest
+	if r.Method == http.MethodGet {
+		params := r.URL.Query()
+		req.Question, req.Filter, req.Model = params.Get("question"), params.Get("filter"), params.Get("model")
+		req.Pipeline = params.Get("pipeline")
+		if raw := params.Get("partitions"); raw != "" {
+			req.Partitions = strings.Split(raw, ",")
+		}
+		if raw := params.Get("languages"); raw != "" {
+			req.Languages = strings.Split(raw, ",")
+		}
+		if raw := params.Get("limit"); raw != "" {
+			limit, err := strconv.Atoi(raw)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "limit must be a number")
+				return
+			}
+			req.Limit = limit
+		}
+	} else if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := req.answerOnly(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	model, opts, ok := s.queryOptions(w, &req)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // keep proxies such as nginx from buffering the stream
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	send := func(event string, data any) error {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	usage := &RequestUsage{}
+	grading := &RelevanceReport{}
+	timeouts := &TimeoutReport{}
+	ctx := withTimeoutReport(withRelevanceReport(withRequestUsage(r.Context(), usage), grading), timeouts)
+	v, err := s.variant(w, r, &req, model, opts)
+	if err != nil {
+		slog.ErrorContext(ctx, "Configuring the pipeline failed", "pipeline", v.Pipeline, "error", err)
+		send("error", map[string]string{"error": "configuring the pipeline failed"})
+		return
+	}
+	ctx, cancel := v.Engine.withQueryTimeout(ctx)
+	defer cancel()
+	start := time.Now()
+	if answer, refused := v.Engine.OutOfScope(ctx, req.Question); refused {
+		if s.experiment != nil {
+			s.experiment.observe(v.Pipeline, answer, nil, time.Since(start))
+		}
+		send("token", map[string]string{"text": answer.Text})
+		resp := newQueryResponse(answer)
+		resp.QueryID = w.Header().Get("X-Request-ID")
+		resp.Usage = usage
+		resp.Pipeline = v.Pipeline
+		send("citations", resp)
+		return
+	}
+	send("status", streamStatusJSON{Stage: "retrieving"})
+	docs := v.Engine.Retrieve(ctx, req.Question, v.Limit, v.Options...)
+	var sources []string
+	for _, doc := range docs {
+		sources = append(sources, doc.Source)
+	}
+	count := len(docs)
+	send("status", streamStatusJSON{Stage: "retrieved", Documents: &count, Sources: uniqueStrings(sources)})
+	send("status", streamStatusJSON{Stage: "generating"})
+
+	answer, err := v.Engine.GenerateResponseStream(ctx, req.Question, docs, v.Model, func(delta string) error {
+		return send("token", map[string]string{"text": delta})
+	})
+	err = stageTimedOut(ctx, stageQuery, err)
+	if s.experiment != nil && r.Context().Err() == nil {
+		s.experiment.observe(v.Pipeline, answer, err, time.Since(start))
+	}
+	var policyErr *PolicyError
+	var timeoutErr *StageTimeoutError
+	switch {
+	case errors.As(err, &policyErr):
+		send("error", newPolicyErrorJSON(policyErr))
+		return
+	case r.Context().Err() != nil:
+		slog.InfoContext(ctx, "Client disconnected from the stream")
+		return
+	case errors.As(err, &timeoutErr):
+		send("error", errorJSON{Error: timeoutErr.Error()})
+		return
+	case err != nil:
+		slog.ErrorContext(ctx, "Query failed", "error", err)
+		send("error", map[string]string{"error": "generating answer failed"})
+		return
+	}
+	resp := newQueryResponse(answer)
+	resp.QueryID = w.Header().Get("X-Request-ID")
+	resp.Grading = newGradingJSON(grading)
+	resp.Usage = usage
+	resp.Pipeline = v.Pipeline
+	resp.TimedOut = timeouts.Stages()
+	send("citations", resp)
+}
+
+func (s *Server) handleUsage(w http.ResponseWriter, r *http.Request) {
+	report, err := usageLedger.Report()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Reading token usage failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "reading usage failed")
+		return
+	}
+	if tenant := s.requestEngine(r).tenant; tenant != "" {
+		report = report.ForTenant(tenant)
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+type historyEntryJSON struct {
+	ID         string            `json:"id"`
+	Time       time.Time         `json:"time"`
+	Question   string            `json:"question"`
+	Model      string            `json:"model"`
+	Answer     string            `json:"answer"`
+	NoContext  bool              `json:"no_context"`
+	Error      string            `json:"error,omitempty"`
+	DurationMS int64             `json:"duration_ms"`
+	Documents  []historyDocument `json:"documents,omitempty"`
+	Prompt     []historyMessage  `json:"prompt,omitempty"`
+	Feedback   *feedbackJSON     `json:"feedback,omitempty"`
+}
+
+type feedbackJSON struct {
+	QueryID    string    `json:"query_id"`
+	Rating     Rating    `json:"rating"` // "up" or "down"
+	Correction string    `json:"correction,omitempty"`
+	Comment    string    `json:"comment,omitempty"`
+	Time       time.Time `json:"time,omitzero"`
+}
+
+type historyListJSON struct {
+	Queries []historyEntryJSON `json:"queries"`
+}
+
+func newHistoryEntry(record QueryRecord, full bool) historyEntryJSON {
+	entry := historyEntryJSON{
+		ID:         record.ID,
+		Time:       record.Time,
+		Question:   record.Question,
+		Model:      record.Model,
+		Answer:     record.Answer,
+		NoContext:  record.NoContext,
+		Error:      record.Error,
+		DurationMS: record.Duration.Milliseconds(),
+	}
+	if f := record.Feedback; f != nil {
+		entry.Feedback = &feedbackJSON{QueryID: f.QueryID, Rating: f.Rating, Correction: f.Correction, Comment: f.Comment, Time: f.Time}
+	}
+	if full {
+		entry.Documents = []historyDocument{}
+		for _, d := range record.Documents {
+			entry.Documents = append(entry.Documents, historyDocument{Source: d.Source, Text: d.Text, Similarity: d.Similarity, Metadata: d.Metadata})
+		}
+		for _, m := range record.Prompt {
+			entry.Prompt = append(entry.Prompt, historyMessage{Role: m.Role, Content: m.Content})
+		}
+	}
+	return entry
+}
+
+// handleHistory lists recorded queries, newest first. It takes limit (default
+// 20, at most 100), search (text the question contains), and before (an
+// RFC 3339 time, for paging with the time of the last entry returned).
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	engine := s.requestEngine(r)
+	if engine.history == nil {
+		writeError(w, http.StatusNotFound, "query history is disabled")
+		return
+	}
+	filter := HistoryFilter{Limit: 20, Search: r.URL.Query().Get("search"), Tenant: engine.tenant}
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 || limit > 100 {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and 100")
+			return
+		}
+		filter.Limit = limit
+	}
+	if raw := r.URL.Query().Get("before"); raw != "" {
+		before, err := time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "before must be an RFC 3339 time")
+			return
+		}
+		filter.Before = before
+	}
+	records, err := engine.history.ListQueries(r.Context(), filter)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Reading query history failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "reading history failed")
+		return
+	}
+	entries := []historyEntryJSON{}
+	for _, record := range records {
+		if engine.canRead(record) {
+			entries = append(entries, newHistoryEntry(record, false))
+		}
+	}
+	writeJSON(w, http.StatusOK, historyListJSON{Queries: entries})
+}
+
+func (s *Server) handleHistoryQuery(w http.ResponseWriter, r *http.Request) {
+	engine := s.requestEngine(r)
+	if engine.history == nil {
+		writeError(w, http.StatusNotFound, "query history is disabled")
+		return
+	}
+	record, err := engine.visibleQuery(r.Context(), r.PathValue("id"))
+	if errors.Is(err, ErrQueryNotFound) {
+		writeError(w, http.StatusNotFound, "query not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Reading query history failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "reading history failed")
+		return
+	}
+	writeJSON(w, http.StatusOK, newHistoryEntry(record, true))
+}
+
+func (s *Server) handleFeedback(w http.ResponseWriter, r *http.Request) {
+	engine := s.requestEngine(r)
+	if engine.history == nil {
+		writeError(w, http.StatusNotFound, "query history is disabled")
+		return
+	}
+	var req feedbackJSON
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	feedback := Feedback{QueryID: req.QueryID, Rating: req.Rating, Correction: req.Correction, Comment: req.Comment}
+	if err := feedback.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	_, err := engine.visibleQuery(r.Context(), feedback.QueryID)
+	if err == nil {
+		err = engine.history.SaveFeedback(r.Context(), feedback)
+	}
+	if errors.Is(err, ErrQueryNotFound) {
+		writeError(w, http.StatusNotFound, "query not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Saving feedback failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "saving feedback failed")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type reviewItemJSON struct {
+	historyEntryJSON
+	TopSimilarity float32  `json:"top_similarity"`
+	Reasons       []string `json:"reasons"`
+}
+
+type sourceCountJSON struct {
+	Source string `json:"source"`
+	Count  int    `json:"count"`
+}
+
+// handleFeedbackReport reviews recent queries; it takes min_similarity (0-1,
+// default 0.5) and since (an RFC 3339 time).
+func (s *Server) handleFeedbackReport(w http.ResponseWriter, r *http.Request) {
+	engine := s.requestEngine(r)
+	if engine.history == nil {
+		writeError(w, http.StatusNotFound, "query history is disabled")
+		return
+	}
+	opts := ReviewOptions{MinSimilarity: 0.5, Tenant: engine.tenant, Roles: engine.roles}
+	if raw := r.URL.Query().Get("min_similarity"); raw != "" {
+		minSimilarity, err := strconv.ParseFloat(raw, 32)
+		if err != nil || minSimilarity < 0 || minSimilarity > 1 {
+			writeError(w, http.StatusBadRequest, "min_similarity must be between 0 and 1")
+			return
+		}
+		opts.MinSimilarity = float32(minSimilarity)
+	}
+	if raw := r.URL.Query().Get("since"); raw != "" {
+		since, err := time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "since must be an RFC 3339 time")
+			return
+		}
+		opts.Since = since
+	}
+	report, err := ReviewFeedback(r.Context(), engine.history, opts)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Reviewing feedback failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "reading history failed")
+		return
+	}
+	writeJSON(w, http.StatusOK, newFeedbackReportJSON(report))
+}
+
+type feedbackReportJSON struct {
+	Queries   int               `json:"queries"`
+	Upvotes   int               `json:"upvotes"`
+	Downvotes int               `json:"downvotes"`
+	Flagged   []reviewItemJSON  `json:"flagged"`
+	Sources   []sourceCountJSON `json:"sources"`
+}
+
+func newFeedbackReportJSON(report FeedbackReport) feedbackReportJSON {
+	out := feedbackReportJSON{
+		Queries:   report.Queries,
+		Upvotes:   report.Upvotes,
+		Downvotes: report.Downvotes,
+		Flagged:   make([]reviewItemJSON, len(report.Items)),
+		Sources:   make([]sourceCountJSON, len(report.Sources)),
+	}
+	for i, item := range report.Items {
+		out.Flagged[i] = reviewItemJSON{historyEntryJSON: newHistoryEntry(item.Query, false), TopSimilarity: item.TopSimilarity, Reasons: item.Reasons}
+	}
+	for i, source := range report.Sources {
+		out.Sources[i] = sourceCountJSON{Source: source.Source, Count: source.Count}
+	}
+	return out
+}
+
+func (s *Server) handleDocuments(w http.ResponseWriter, r *http.Request) {
+	var req documentsRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	pages, err := req.pages()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	engine, err := req.engine(s.requestEngine(r))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	report, ok := ingestPages(r.Context(), engine, pages, req.ChunkSize, req.Overlap)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "storing documents failed")
+		return
+	}
+	writeJSON(w, http.StatusCreated, documentsResponse{
+		Inserted: report.Inserted,
+		Updated:  report.Updated,
+		Skipped:  report.Skipped,
+		Removed:  report.Removed,
+		Dropped:  report.Dropped,
+	})
+}
+
+// pages validates the request, filling in the default chunk size and
+// overlap, and returns its documents as pages.
+func (req *documentsRequest) pages() ([]Page, error) {
+	if len(req.Documents) == 0 {
+		return nil, errors.New("documents are required")
+	}
+	if req.ChunkSize <= 0 {
+		req.ChunkSize = 1000
+	}
+	if req.Overlap <= 0 {
+		req.Overlap = 200
+	}
+
+	pages := make([]Page, len(req.Documents))
+	for i, doc := range req.Documents {
+		if (doc.Text == "" && doc.Image == "") || doc.Source == "" {
+			return nil, errors.New("every document needs text or an image, and source")
+		}
+		if doc.Format != "" && doc.Format != "markdown" && doc.Format != "code" && doc.Format != "transcript" && doc.Format != "text" {
+			return nil, errors.New("format must be markdown, code, transcript, or text")
+		}
+		pages[i] = Page{Text: doc.Text, Source: doc.Source, Metadata: doc.Metadata, Format: doc.Format}
+		if doc.Image != "" {
+			data, err := base64.StdEncoding.DecodeString(doc.Image)
+			if err != nil {
+				return nil, fmt.Errorf("document %q: image must be base64-encoded", doc.Source)
+			}
+			image, err := decodeImage(data, doc.MIMEType)
+			if err != nil {
+				return nil, fmt.Errorf("document %q: %w", doc.Source, err)
+			}
+			pages[i].Image = &image
+		}
+	}
+	return pages, nil
+}
+
+// engine returns a copy of engine that stores the request's documents in its
+// partition and makes them expire at its expiry.
+func (req *documentsRequest) engine(engine *RAGEngine) (*RAGEngine, error) {
+	if engine.vision == nil && slices.ContainsFunc(req.Documents, func(doc documentJSON) bool { return doc.Image != "" }) {
+		return nil, errors.New("images need a vision model, which is disabled (VISION_MODEL)")
+	}
+	if req.Partition != "" {
+		if partitions, err := parsePartitions(req.Partition); err != nil || len(partitions) != 1 {
+			return nil, errors.New("partition must be a single partition name")
+		}
+	}
+	var expires time.Time
+	if req.ExpiresAt != "" {
+		var err error
+		if expires, err = parseExpiryTime(req.ExpiresAt); err != nil {
+			return nil, err
+		}
+	}
+	return engine.WithIngestPartition(req.Partition).WithIngestExpiry(expires), nil
+}
+
+func (s *Server) handleDeleteDocuments(w http.ResponseWriter, r *http.Request) {
+	source := r.URL.Query().Get("source")
+	if source == "" {
+		writeError(w, http.StatusBadRequest, "source is required")
+		return
+	}
+	if err := s.requestEngine(r).DeleteBySource(r.Context(), source); err != nil {
+		slog.ErrorContext(r.Context(), "Deleting documents failed", "source", source, "error", err)
+		writeError(w, http.StatusInternalServerError, "deleting documents failed")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// answerOnly rejects the options that only POST /query supports, for the
+// streaming and chat endpoints.
+func (req *queryRequest) answerOnly() error {
+	if req.Decompose || req.MaxRounds > 1 {
+		return errors.New("decompose and max_rounds are only supported by POST /query")
+	}
+	return nil
+}
+
+func newQueryResponse(answer Answer) queryResponse {
+	resp := queryResponse{Answer: answer.Text, Citations: newCitationsJSON(answer.Citations), NoContext: answer.NoContext, OutOfScope: answer.OutOfScope, Fallback: string(answer.Fallback), Fingerprint: answer.Fingerprint}
+	if c := answer.Confidence; c != nil {
+		resp.Confidence = &confidenceJSON{Score: c.Score, Level: string(c.Level), Similarity: c.Similarity, Grading: c.Grading, SelfRating: c.SelfRating}
+	}
+	if v := answer.Verification; v != nil {
+		resp.Verification = &verificationJSON{Claims: []claimJSON{}, Unsupported: v.Unsupported, Regenerated: v.Regenerated}
+		for _, claim := range v.Claims {
+			resp.Verification.Claims = append(resp.Verification.Claims, claimJSON{Text: claim.Text, Markers: append([]int{}, claim.Markers...), Supported: claim.Supported})
+		}
+	}
+	for _, part := range answer.Parts {
+		resp.Parts = append(resp.Parts, partJSON{Question: part.Question, Citations: newCitationsJSON(part.Citations)})
+	}
+	for _, round := range answer.Rounds {
+		out := roundJSON{Query: round.Query, Documents: []roundDocumentJSON{}, New: round.New, Verdict: round.Verdict, Reason: round.Reason}
+		for _, doc := range round.Documents {
+			out.Documents = append(out.Documents, roundDocumentJSON{Source: doc.Source, Text: doc.Text, Similarity: doc.Similarity})
+		}
+		resp.Rounds = append(resp.Rounds, out)
+	}
+	return resp
+}
+
+// newGradingJSON returns report in API form, or nil if nothing was graded.
+func newGradingJSON(report *RelevanceReport) *gradingJSON {
+	if report.Graded == 0 {
+		return nil
+	}
+	out := &gradingJSON{Graded: report.Graded, Discarded: []roundDocumentJSON{}}
+	for _, doc := range report.Discarded {
+		out.Discarded = append(out.Discarded, roundDocumentJSON{Source: doc.Source, Text: doc.Text, Similarity: doc.Similarity})
+	}
+	for _, action := range report.Actions {
+		out.Actions = append(out.Actions, string(action))
+	}
+	return out
+}
+
+func newCitationsJSON(citations []Citation) []citationJSON {
+	out := []citationJSON{}
+	for _, c := range citations {
+		out = append(out, citationJSON{
+			Marker:     c.Marker,
+			Source:     c.Source,
+			ChunkStart: c.ChunkStart,
+			ChunkEnd:   c.ChunkEnd,
+			URL:        c.URL,
+			Web:        c.Web,
+			ImageURL:   c.ImageURL,
+			Timecode:   c.Timecode,
+			Text:       c.Document.Text,
+			Similarity: c.Document.Similarity,
+			Metadata:   c.Document.Metadata,
+		})
+	}
+	return out
+}
+
+// decodeJSON reads the request body into v, writing a 400 response and
+// returning false if it is not valid JSON.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBytes)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("Writing response failed", "error", err)
+	}
+}
+
+type errorJSON struct {
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, errorJSON{Error: message})
+}
+
+// writePolicyError writes a 422 response naming the flagged categories if err
+// is a moderation *PolicyError, and reports whether it did.
+func writePolicyError(w http.ResponseWriter, err error) bool {
+	var policyErr *PolicyError
+	if !errors.As(err, &policyErr) {
+		return false
+	}
+	writeJSON(w, http.StatusUnprocessableEntity, newPolicyErrorJSON(policyErr))
+	return true
+}
+
+// writeTimeoutError writes a 504 response naming the stage that timed out if
+// err is a *StageTimeoutError, and reports whether it did.
+func writeTimeoutError(w http.ResponseWriter, err error) bool {
+	var timeoutErr *StageTimeoutError
+	if !errors.As(err, &timeoutErr) {
+		return false
+	}
+	writeError(w, http.StatusGatewayTimeout, timeoutErr.Error())
+	return true
+}
+
+type policyErrorJSON struct {
+	Error      string   `json:"error"`
+	Stage      string   `json:"stage"` // "query" or "answer"
+	Categories []string `json:"categories"`
+}
+
+func newPolicyErrorJSON(err *PolicyError) policyErrorJSON {
+	return policyErrorJSON{Error: err.Error(), Stage: err.Stage, Categories: append([]string{}, err.Categories...)}
+}
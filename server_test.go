@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestServer() (*Server, *MemoryStore) {
+	store := NewMemoryStore(NewHashingEmbedder(256))
+	engine := NewRAGEngine(&scriptedOpenAI{reply: "Go was made at Google [1]."}, store)
+	return NewServer(engine, "gpt-test"), store
+}
+
+func TestServerIngestsAndAnswers(t *testing.T) {
+	server, store := newTestServer()
+
+	body := `{"documents":[{"text":"Go is a language from Google.","source":"Go Docs","metadata":{"team":"go"}},
+		{"text":"Milvus stores vectors.","source":"Milvus Docs"}]}`
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/documents", strings.NewReader(body)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	if store.Len() != 2 {
+		t.Fatalf("expected 2 stored chunks, got %d", store.Len())
+	}
+
+	rec = httptest.NewRecorder()
+	query := `{"question":"Who made Go?","limit":1,"filter":"team == \"go\""}`
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(query)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var resp queryResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if resp.Answer != "Go was made at Google [1]." {
+		t.Fatalf("unexpected answer %q", resp.Answer)
+	}
+	if len(resp.Citations) != 1 || resp.Citations[0].Source != "Go Docs" || resp.Citations[0].ChunkStart != 0 {
+		t.Fatalf("unexpected citations %+v", resp.Citations)
+	}
+}
+
+func TestServerRejectsBadRequests(t *testing.T) {
+	server, _ := newTestServer()
+	cases := []struct {
+		method, path, body string
+		status             int
+	}{
+		{http.MethodPost, "/query", `{"question":""}`, http.StatusBadRequest},
+		{http.MethodPost, "/query", `not json`, http.StatusBadRequest},
+		{http.MethodPost, "/query", `{"question":"q","filter":"year >"}`, http.StatusBadRequest},
+		{http.MethodPost, "/documents", `{"documents":[{"text":"no source"}]}`, http.StatusBadRequest},
+		{http.MethodGet, "/query", ``, http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/documents", ``, http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(c.method, c.path, strings.NewReader(c.body)))
+		if rec.Code != c.status {
+			t.Errorf("%s %s %s: expected %d, got %d", c.method, c.path, c.body, c.status, rec.Code)
+		}
+	}
+}
+
+func TestServerDeletesBySource(t *testing.T) {
+	server, store := newTestServer()
+	store.InsertDocuments(context.Background(), []string{"a", "b"}, []string{"keep", "drop"}, nil)
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/documents?source=drop", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", rec.Code, rec.Body)
+	}
+	if store.Len() != 1 {
+		t.Fatalf("expected 1 remaining document, got %d", store.Len())
+	}
+}
+
+func TestServerExposesMetrics(t *testing.T) {
+	server, _ := newTestServer()
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"question":"Who made Go?"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`rag_queries_total{status="answered"}`,
+		`rag_http_requests_total{code="200",route="POST /query"}`,
+		`rag_retrieval_duration_seconds_count`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics output is missing %s", want)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// crawlerUserAgent identifies the indexer to web servers and selects its
+	// group in robots.txt.
+	crawlerUserAgent = "rag-indexer"
+	// maxSitemaps bounds how many sitemaps a sitemap index may pull in.
+	maxSitemaps = 1000
+)
+
+// SitemapConnector syncs the pages listed in a website's sitemap. It reads
+// sitemap indexes and gzipped sitemaps, and skips pages that robots.txt
+// disallows for its user agent.
+type SitemapConnector struct {
+	sitemapURL string
+	loader     *HTMLLoader
+	httpClient *http.Client
+
+	mu     sync.Mutex
+	robots map[string]*robotsRules // by scheme and host
+}
+
+// NewSitemapConnector creates a connector for the sitemap at sitemapURL.
+func NewSitemapConnector(sitemapURL string) *SitemapConnector {
+	return &SitemapConnector{
+		sitemapURL: sitemapURL,
+		loader:     NewHTMLLoader(),
+		httpClient: http.DefaultClient,
+		robots:     make(map[string]*robotsRules),
+	}
+}
+
+func (s *SitemapConnector) Name() string {
+	return "sitemap:" + s.sitemapURL
+}
+
+type sitemapDocument struct {
+	XMLName  xml.Name
+	URLs     []sitemapEntry `xml:"url"`
+	Sitemaps []sitemapEntry `xml:"sitemap"`
+}
+
+type sitemapEntry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// List reads the sitemap, following a sitemap index to its sitemaps, and
+// returns the pages robots.txt allows. Each page's title is its URL path, so
+// Include and Exclude patterns such as "/docs/*" select sections of the site,
+// and its edit time is its lastmod, if given.
+func (s *SitemapConnector) List(ctx context.Context) ([]ConnectorItem, error) {
+	var items []ConnectorItem
+	seenPages := make(map[string]bool)
+	seenSitemaps := map[string]bool{s.sitemapURL: true}
+	queue := []string{s.sitemapURL}
+	for len(queue) > 0 {
+		sitemapURL := queue[0]
+		queue = queue[1:]
+		doc, err := s.fetchSitemap(ctx, sitemapURL)
+		if err != nil {
+			if sitemapURL == s.sitemapURL {
+				return nil, err
+			}
+			slog.WarnContext(ctx, "Skipping sitemap", "url", sitemapURL, "error", err)
+			continue
+		}
+		for _, entry := range doc.Sitemaps {
+			loc := strings.TrimSpace(entry.Loc)
+			if loc != "" && !seenSitemaps[loc] && len(seenSitemaps) < maxSitemaps {
+				seenSitemaps[loc] = true
+				queue = append(queue, loc)
+			}
+		}
+		for _, entry := range doc.URLs {
+			loc := strings.TrimSpace(entry.Loc)
+			u, err := url.Parse(loc)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || seenPages[loc] {
+				continue
+			}
+			seenPages[loc] = true
+			allowed, err := s.allowed(ctx, u)
+			if err != nil {
+				return nil, err
+			}
+			if !allowed {
+				slog.DebugContext(ctx, "Skipping page disallowed by robots.txt", "url", loc)
+				continue
+			}
+			title := u.Path
+			if title == "" {
+				title = "/"
+			}
+			items = append(items, ConnectorItem{ID: loc, Title: title, URL: loc, Updated: parseLastMod(entry.LastMod)})
+		}
+	}
+	return items, nil
+}
+
+// Load fetches the page and extracts its readable text.
+func (s *SitemapConnector) Load(ctx context.Context, item ConnectorItem) (Page, error) {
+	page, _, err := s.loader.fetch(ctx, item.URL)
+	return page, err
+}
+
+// fetchSitemap downloads and parses a sitemap or sitemap index, decompressing
+// it if it is gzipped.
+func (s *SitemapConnector) fetchSitemap(ctx context.Context, sitemapURL string) (sitemapDocument, error) {
+	var doc sitemapDocument
+	data, status, err := s.get(ctx, sitemapURL)
+	if err != nil {
+		return doc, err
+	}
+	if status != http.StatusOK {
+		return doc, fmt.Errorf("fetching sitemap %s: status %d", sitemapURL, status)
+	}
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return doc, fmt.Errorf("decompressing sitemap %s: %w", sitemapURL, err)
+		}
+		data, err = io.ReadAll(io.LimitReader(zr, maxPageBytes*5))
+		if err != nil {
+			return doc, fmt.Errorf("decompressing sitemap %s: %w", sitemapURL, err)
+		}
+	}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return doc, fmt.Errorf("parsing sitemap %s: %w", sitemapURL, err)
+	}
+	if doc.XMLName.Local != "urlset" && doc.XMLName.Local != "sitemapindex" {
+		return doc, fmt.Errorf("parsing sitemap %s: unexpected root element <%s>", sitemapURL, doc.XMLName.Local)
+	}
+	return doc, nil
+}
+
+// allowed reports whether robots.txt on the page's host lets the indexer
+// fetch it. The rules are fetched once per host.
+func (s *SitemapConnector) allowed(ctx context.Context, u *url.URL) (bool, error) {
+	host := u.Scheme + "://" + u.Host
+	s.mu.Lock()
+	rules, ok := s.robots[host]
+	s.mu.Unlock()
+	if !ok {
+		data, status, err := s.get(ctx, host+"/robots.txt")
+		switch {
+		case err != nil:
+			return false, fmt.Errorf("fetching robots.txt: %w", err)
+		case status == http.StatusOK:
+			rules = parseRobots(bytes.NewReader(data), crawlerUserAgent)
+		case status >= 400 && status < 500:
+			rules = &robotsRules{} // no robots.txt: everything is allowed
+		default:
+			return false, fmt.Errorf("fetching robots.txt from %s: status %d", host, status)
+		}
+		s.mu.Lock()
+		s.robots[host] = rules
+		s.mu.Unlock()
+	}
+	return rules.allowed(u.RequestURI()), nil
+}
+
+// get fetches a URL with the indexer's user agent.
+func (s *SitemapConnector) get(ctx context.Context, target string) ([]byte, int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("User-Agent", crawlerUserAgent)
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxPageBytes*5))
+	return data, resp.StatusCode, err
+}
+
+// parseLastMod parses a sitemap lastmod in any of the W3C datetime forms,
+// returning the zero time when it is missing or malformed.
+func parseLastMod(value string) time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04Z07:00", "2006-01-02", "2006-01", "2006"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// robotsRules are the Allow and Disallow rules of the robots.txt group that
+// applies to a user agent.
+type robotsRules struct {
+	rules []robotsRule
+}
+
+type robotsRule struct {
+	allow   bool
+	length  int // length of the pattern; the longest matching rule wins
+	pattern *regexp.Regexp
+}
+
+// parseRobots reads the rules of the group naming agent, or of the "*" group
+// when no group names it. Patterns support the * wildcard and the $ end
+// anchor.
+func parseRobots(r io.Reader, agent string) *robotsRules {
+	agent = strings.ToLower(agent)
+	var own, wildcard []robotsRule
+	var haveOwn bool
+	var groupAgents []string
+	inRules := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		switch key {
+		case "user-agent":
+			if inRules {
+				groupAgents = nil
+				inRules = false
+			}
+			name := strings.ToLower(value)
+			if name != "*" && name != "" && strings.Contains(agent, name) {
+				haveOwn = true
+			}
+			groupAgents = append(groupAgents, name)
+		case "allow", "disallow":
+			inRules = true
+			if value == "" {
+				continue // an empty Disallow allows everything
+			}
+			rule := robotsRule{allow: key == "allow", length: len(value), pattern: robotsPattern(value)}
+			for _, name := range groupAgents {
+				if name == "*" {
+					wildcard = append(wildcard, rule)
+				} else if name != "" && strings.Contains(agent, name) {
+					own = append(own, rule)
+				}
+			}
+		}
+	}
+	if haveOwn {
+		return &robotsRules{rules: own}
+	}
+	return &robotsRules{rules: wildcard}
+}
+
+// robotsPattern compiles a robots.txt path pattern.
+func robotsPattern(pattern string) *regexp.Regexp {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	expr := "^" + strings.Join(parts, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
+
+// allowed reports whether path may be fetched: the longest matching rule
+// decides, Allow winning ties, and paths no rule matches are allowed.
+func (r *robotsRules) allowed(path string) bool {
+	if path == "" {
+		path = "/"
+	}
+	allow, length := true, -1
+	for _, rule := range r.rules {
+		if !rule.pattern.MatchString(path) {
+			continue
+		}
+		if rule.length > length || (rule.length == length && rule.allow) {
+			allow, length = rule.allow, rule.length
+		}
+	}
+	return allow
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRobots(t *testing.T) {
+	robots := `
+User-agent: *
+Disallow: /private/
+Allow: /private/press
+Disallow: /*.pdf$
+
+User-agent: other-bot
+Disallow: /
+`
+	rules := parseRobots(strings.NewReader(robots), crawlerUserAgent)
+	cases := map[string]bool{
+		"/":                     true,
+		"/docs/intro":           true,
+		"/private/keys":         false,
+		"/private/press/2025":   true,
+		"/files/report.pdf":     false,
+		"/files/report.pdf?v=1": true,
+	}
+	for path, want := range cases {
+		if got := rules.allowed(path); got != want {
+			t.Errorf("allowed(%q) = %t, want %t", path, got, want)
+		}
+	}
+
+	own := parseRobots(strings.NewReader("User-agent: *\nDisallow: /\n\nUser-agent: rag-indexer\nDisallow:\n"), crawlerUserAgent)
+	if !own.allowed("/docs") {
+		t.Errorf("expected the group naming the indexer to take precedence over *")
+	}
+}
+
+func TestParseLastMod(t *testing.T) {
+	for value, want := range map[string]time.Time{
+		"2025-03-01":                time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC),
+		"2025-03-01T10:20:30+00:00": time.Date(2025, 3, 1, 10, 20, 30, 0, time.UTC),
+		"2025-03-01T10:20Z":         time.Date(2025, 3, 1, 10, 20, 0, 0, time.UTC),
+		"not a date":                {},
+	} {
+		if got := parseLastMod(value); !got.Equal(want) {
+			t.Errorf("parseLastMod(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestSitemapConnectorSync(t *testing.T) {
+	var fetched []string
+	lastmod := "2025-03-01"
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") != crawlerUserAgent {
+			t.Errorf("expected the indexer's user agent, got %q", r.Header.Get("User-Agent"))
+		}
+		switch r.URL.Path {
+		case "/robots.txt":
+			w.Write([]byte("User-agent: *\nDisallow: /admin\n"))
+		case "/sitemap.xml":
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>` + server.URL + `/docs.xml.gz</loc></sitemap>
+</sitemapindex>`))
+		case "/docs.xml.gz":
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			zw.Write([]byte(`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>` + server.URL + `/docs/install</loc><lastmod>` + lastmod + `</lastmod></url>
+  <url><loc>` + server.URL + `/blog/news</loc></url>
+  <url><loc>` + server.URL + `/admin/settings</loc></url>
+</urlset>`))
+			zw.Close()
+			w.Write(buf.Bytes())
+		case "/docs/install", "/blog/news":
+			fetched = append(fetched, r.URL.Path)
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html><head><title>" + r.URL.Path + "</title></head><body><p>Install with go install.</p></body></html>"))
+		default:
+			t.Errorf("unexpected request for %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	store := NewMemoryStore(NewHashingEmbedder(64))
+	engine := NewRAGEngine(&dummyOpenAI{}, store)
+	state, _ := LoadSyncState(filepath.Join(t.TempDir(), "state.json"))
+	connector := NewSitemapConnector(server.URL + "/sitemap.xml")
+
+	report, err := SyncConnector(context.Background(), engine, connector, state, DirectoryOptions{Workers: 1, ChunkSize: 1000})
+	if err != nil {
+		t.Fatalf("SyncConnector returned error: %v", err)
+	}
+	if report.Files != 2 || len(fetched) != 2 {
+		t.Fatalf("expected the two allowed pages, got report %+v, fetched %v", report, fetched)
+	}
+	docs := store.SearchSimilar(context.Background(), "install", 10, Filter{Eq("source", server.URL+"/docs/install")})
+	if len(docs) != 1 || docs[0].Metadata["title"] != "/docs/install" || docs[0].Metadata["updated"] != "2025-03-01T00:00:00Z" {
+		t.Fatalf("unexpected documents %+v", docs)
+	}
+
+	// Only the page without a lastmod is fetched again until the lastmod changes.
+	fetched = nil
+	if _, err := SyncConnector(context.Background(), engine, connector, state, DirectoryOptions{Workers: 1, ChunkSize: 1000}); err != nil {
+		t.Fatalf("SyncConnector returned error: %v", err)
+	}
+	if len(fetched) != 1 || fetched[0] != "/blog/news" {
+		t.Fatalf("expected only the page without lastmod to be re-fetched, got %v", fetched)
+	}
+	fetched = nil
+	lastmod = "2025-03-02"
+	if _, err := SyncConnector(context.Background(), engine, connector, state, DirectoryOptions{Workers: 1, ChunkSize: 1000, Include: []string{"/docs/*"}}); err != nil {
+		t.Fatalf("SyncConnector returned error: %v", err)
+	}
+	if len(fetched) != 1 || fetched[0] != "/docs/install" {
+		t.Fatalf("expected the updated page to be re-fetched, got %v", fetched)
+	}
+	if got := storedSources(store); len(got) != 1 {
+		t.Fatalf("expected the excluded blog page to be removed, got %v", got)
+	}
+}
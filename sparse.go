@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// sparseField is the Milvus field holding a document's sparse vector.
+const sparseField = "sparse_embedding"
+
+// spladeBatchSize is the most texts sent to a SPLADE server per request.
+const spladeBatchSize = 32
+
+// SparseVector is a sparse embedding: the weights of the few dimensions,
+// out of 2^32, that a text has. Dimensions stand for terms, so the inner
+// product of two sparse vectors scores their shared keywords.
+type SparseVector struct {
+	Indices []uint32
+	Values  []float32
+}
+
+// SparseEmbedder turns texts into sparse vectors. Query vectors are asked
+// for with a context marked by withQueryEmbedding.
+type SparseEmbedder interface {
+	EmbedSparse(ctx context.Context, texts []string) ([]SparseVector, error)
+}
+
+// newSparseEmbedder returns the sparse embedder named by mode: "bm25" or
+// "splade", which needs url.
+func newSparseEmbedder(mode, url, apiKey string) (SparseEmbedder, error) {
+	switch mode {
+	case "bm25":
+		return NewBM25SparseEmbedder(), nil
+	case "splade":
+		if url == "" {
+			return nil, fmt.Errorf("the splade sparse embedder needs the URL of a SPLADE server")
+		}
+		return NewSPLADEEmbedder(url, apiKey), nil
+	}
+	return nil, fmt.Errorf("unknown sparse embedder %q (expected bm25 or splade)", mode)
+}
+
+// BM25SparseEmbedder embeds texts locally as BM25 term weights, one hashed
+// dimension per term other than stopwords. A document term is weighted by
+// its BM25 term frequency, saturated by K1 and normalized by the document's
+// length against AvgLength; a query term weighs 1, so the inner product is
+// a document's BM25 score without the IDF factor, which would need
+// statistics over the whole collection. Stopwords stand in for it by
+// leaving out the terms with the lowest IDF.
+type BM25SparseEmbedder struct {
+	K1, B float64
+	// AvgLength is the typical number of terms of a chunk.
+	AvgLength float64
+}
+
+// NewBM25SparseEmbedder returns a BM25 embedder with the usual k1 = 1.2 and
+// b = 0.75, for chunks of about 150 words.
+func NewBM25SparseEmbedder() *BM25SparseEmbedder {
+	return &BM25SparseEmbedder{K1: 1.2, B: 0.75, AvgLength: 150}
+}
+
+func (e *BM25SparseEmbedder) EmbedSparse(ctx context.Context, texts []string) ([]SparseVector, error) {
+	query := isQueryEmbedding(ctx)
+	vectors := make([]SparseVector, len(texts))
+	for i, text := range texts {
+		terms := tokenize(text)
+		tf := make(map[uint32]int)
+		var order []uint32
+		for _, term := range terms {
+			if stopwords[term] {
+				continue
+			}
+			index := sparseIndex(term)
+			if tf[index] == 0 {
+				order = append(order, index)
+			}
+			tf[index]++
+		}
+		norm := 1 - e.B + e.B*float64(len(terms))/math.Max(e.AvgLength, 1)
+		vector := SparseVector{Indices: order, Values: make([]float32, len(order))}
+		for j, index := range order {
+			if query {
+				vector.Values[j] = 1
+				continue
+			}
+			freq := float64(tf[index])
+			vector.Values[j] = float32(freq * (e.K1 + 1) / (freq + e.K1*norm))
+		}
+		vectors[i] = vector
+	}
+	return vectors, nil
+}
+
+// sparseIndex hashes term to its sparse dimension. Milvus takes dimensions
+// below 2^32-1.
+func sparseIndex(term string) uint32 {
+	hash := fnv.New32a()
+	hash.Write([]byte(term))
+	return hash.Sum32() % math.MaxUint32
+}
+
+// SPLADEEmbedder embeds texts with a SPLADE model served over HTTP by the
+// embed_sparse endpoint of Hugging Face's text-embeddings-inference. SPLADE
+// learns term weights, and expands texts with related terms they do not
+// contain.
+type SPLADEEmbedder struct {
+	url        string
+	apiKey     string
+	httpClient *http.Client
+}
+
+// NewSPLADEEmbedder returns an embedder for the server at url; apiKey may
+// be empty.
+func NewSPLADEEmbedder(url, apiKey string) *SPLADEEmbedder {
+	return &SPLADEEmbedder{url: strings.TrimSuffix(url, "/"), apiKey: apiKey, httpClient: http.DefaultClient}
+}
+
+type spladeEmbedRequest struct {
+	Inputs []string `json:"inputs"`
+}
+
+type spladeWeight struct {
+	Index uint32  `json:"index"`
+	Value float32 `json:"value"`
+}
+
+func (s *SPLADEEmbedder) EmbedSparse(ctx context.Context, texts []string) ([]SparseVector, error) {
+	vectors := make([]SparseVector, 0, len(texts))
+	for start := 0; start < len(texts); start += spladeBatchSize {
+		batch := texts[start:min(start+spladeBatchSize, len(texts))]
+		var resp [][]spladeWeight
+		if err := postJSON(ctx, s.httpClient, "SPLADE", s.url+"/embed_sparse", s.apiKey, spladeEmbedRequest{Inputs: batch}, &resp); err != nil {
+			return nil, err
+		}
+		if len(resp) != len(batch) {
+			return nil, fmt.Errorf("SPLADE returned %d sparse vectors for %d texts", len(resp), len(batch))
+		}
+		for _, weights := range resp {
+			var vector SparseVector
+			for _, w := range weights {
+				if w.Value > 0 {
+					vector.Indices = append(vector.Indices, w.Index)
+					vector.Values = append(vector.Values, w.Value)
+				}
+			}
+			vectors = append(vectors, vector)
+		}
+	}
+	return vectors, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/milvus-io/milvus-sdk-go/v2/client"
+	"github.com/milvus-io/milvus-sdk-go/v2/entity"
+)
+
+func TestBM25SparseEmbedder(t *testing.T) {
+	embedder := NewBM25SparseEmbedder()
+	ctx := context.Background()
+	docs, err := embedder.EmbedSparse(ctx, []string{"The ERR-4012 error: the error of the disk", "the and of"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	weights := make(map[uint32]float32)
+	for i, index := range docs[0].Indices {
+		weights[index] = docs[0].Values[i]
+	}
+	if len(weights) != 4 || weights[sparseIndex("the")] != 0 {
+		t.Fatalf("expected err, 4012, error, and disk without stopwords, got %+v", docs[0])
+	}
+	if once, twice := weights[sparseIndex("disk")], weights[sparseIndex("error")]; once <= 0 || twice <= once || twice >= 2*once {
+		t.Fatalf("expected a repeated term to weigh more, but less than twice as much: %v vs %v", twice, once)
+	}
+	if len(docs[1].Indices) != 0 {
+		t.Fatalf("expected no terms in a text of stopwords, got %+v", docs[1])
+	}
+
+	queries, _ := embedder.EmbedSparse(withQueryEmbedding(ctx), []string{"disk error error"})
+	if len(queries[0].Values) != 2 || queries[0].Values[0] != 1 || queries[0].Values[1] != 1 {
+		t.Fatalf("expected query terms to weigh 1 each, got %+v", queries[0])
+	}
+}
+
+func TestSPLADEEmbedder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req spladeEmbedRequest
+		if r.URL.Path != "/embed_sparse" || json.NewDecoder(r.Body).Decode(&req) != nil || r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, `{"message": "bad request"}`, http.StatusBadRequest)
+			return
+		}
+		resp := make([][]spladeWeight, len(req.Inputs))
+		for i := range resp {
+			resp[i] = []spladeWeight{{Index: 7, Value: 0.5}, {Index: uint32(100 + i), Value: 1.25}, {Index: 9, Value: 0}}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	vectors, err := NewSPLADEEmbedder(server.URL+"/", "secret").EmbedSparse(context.Background(), make([]string, spladeBatchSize+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vectors) != spladeBatchSize+1 || len(vectors[1].Indices) != 2 || vectors[1].Indices[1] != 101 || vectors[1].Values[1] != 1.25 {
+		t.Fatalf("unexpected sparse vectors %+v", vectors[:2])
+	}
+	if _, err := NewSPLADEEmbedder(server.URL, "wrong").EmbedSparse(context.Background(), []string{"x"}); err == nil || !strings.Contains(err.Error(), "bad request") {
+		t.Fatalf("expected the server's error, got %v", err)
+	}
+}
+
+func TestMilvusCheckSparse(t *testing.T) {
+	sdk := &fakeMilvusSDK{collections: []*entity.Collection{
+		{Name: "hybrid", Schema: &entity.Schema{Fields: []*entity.Field{
+			{Name: sparseField, DataType: entity.FieldTypeSparseVector},
+		}}},
+		{Name: "dense", Schema: &entity.Schema{}},
+	}}
+	ctx := context.Background()
+
+	store := &MilvusClientImpl{client: sdk, collectionName: "dense", sparse: NewBM25SparseEmbedder()}
+	if err := store.CheckSparse(ctx); err != nil || store.sparse != nil {
+		t.Fatalf("expected a collection without sparse vectors to be searched densely (%v)", err)
+	}
+	store = &MilvusClientImpl{client: sdk, collectionName: "hybrid"}
+	if err := store.CheckSparse(ctx); err == nil || !strings.Contains(err.Error(), "MILVUS_SPARSE is not set") {
+		t.Fatalf("expected an error for a sparse collection without a sparse embedder, got %v", err)
+	}
+	store = &MilvusClientImpl{client: sdk, collectionName: "new", sparse: NewBM25SparseEmbedder()}
+	if err := store.CheckSparse(ctx); err != nil || store.sparse == nil {
+		t.Fatalf("expected new collections to keep the sparse embedder (%v)", err)
+	}
+}
+
+// hybridSDK records the columns inserted and the hybrid searches made.
+type hybridSDK struct {
+	fakeMilvusSDK
+	inserted []entity.Column
+	requests []*client.ANNSearchRequest
+	reranker client.Reranker
+	dense    bool
+}
+
+func (h *hybridSDK) Insert(ctx context.Context, collName, partitionName string, columns ...entity.Column) (entity.Column, error) {
+	h.inserted = columns
+	return nil, nil
+}
+
+func (h *hybridSDK) Flush(ctx context.Context, collName string, async bool, opts ...client.FlushOption) error {
+	return nil
+}
+
+func (h *hybridSDK) HybridSearch(ctx context.Context, collName string, partitions []string, limit int, outputFields []string, reranker client.Reranker, subRequests []*client.ANNSearchRequest, opts ...client.SearchQueryOptionFunc) ([]client.SearchResult, error) {
+	h.requests, h.reranker = subRequests, reranker
+	return []client.SearchResult{{
+		ResultCount: 1,
+		Scores:      []float32{0.8},
+		Fields: client.ResultSet{
+			entity.NewColumnVarChar("text", []string{"ERR-4012 means the disk is full."}),
+			entity.NewColumnVarChar("source", []string{"errors.md"}),
+		},
+	}}, nil
+}
+
+func (h *hybridSDK) Search(ctx context.Context, collName string, partitions []string, expr string, outputFields []string, vectors []entity.Vector, vectorField string, metricType entity.MetricType, topK int, sp entity.SearchParam, opts ...client.SearchQueryOptionFunc) ([]client.SearchResult, error) {
+	h.dense = true
+	return nil, nil
+}
+
+func TestMilvusHybridSearch(t *testing.T) {
+	sdk := &hybridSDK{fakeMilvusSDK: fakeMilvusSDK{collections: []*entity.Collection{{Name: "docs"}}}}
+	store := &MilvusClientImpl{client: sdk, collectionName: "docs", embedder: NewHashingEmbedder(16), dimension: 16, sparse: NewBM25SparseEmbedder(), sparseWeight: 0.5}
+	ctx := context.Background()
+
+	if !store.InsertDocuments(ctx, []string{"ERR-4012 means the disk is full."}, []string{"errors.md"}, nil) {
+		t.Fatal("insert failed")
+	}
+	column, ok := sdk.inserted[len(sdk.inserted)-1].(*entity.ColumnSparseFloatVector)
+	if !ok || column.Name() != sparseField || column.Len() != 1 {
+		t.Fatalf("expected a sparse vector column, got %+v", sdk.inserted)
+	}
+
+	docs := store.SearchSimilar(ctx, "what is ERR-4012", 3, nil)
+	if len(sdk.requests) != 2 || len(docs) != 1 || docs[0].Similarity != 0.8 {
+		t.Fatalf("expected a dense and a sparse request fused into one result, got %d requests and %+v", len(sdk.requests), docs)
+	}
+	if params := sdk.reranker.GetParams(); len(params) != 2 || !strings.Contains(params[1].Value, "[0.5,0.5]") {
+		t.Fatalf("expected equal weights, got %v", params)
+	}
+
+	store.SearchSimilar(ctx, "what is it", 3, nil)
+	if !sdk.dense {
+		t.Fatal("expected a query of stopwords to be searched densely")
+	}
+}
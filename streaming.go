@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+)
+
+// StreamingChatClient is implemented by LLM clients that can stream a
+// completion as it is generated. onDelta receives each piece of text in
+// order; an error from it aborts the completion. The full text is returned
+// as with ChatCompletion.
+type StreamingChatClient interface {
+	ChatCompletionStream(ctx context.Context, model string, messages []Message, onDelta func(string) error) (string, error)
+}
+
+// streamCompletion streams the completion from llm if it supports streaming,
+// and otherwise passes the whole completion to onDelta at once.
+func streamCompletion(ctx context.Context, llm LLMClient, model string, messages []Message, onDelta func(string) error) (string, error) {
+	if client, ok := llm.(StreamingChatClient); ok {
+		return client.ChatCompletionStream(ctx, model, messages, onDelta)
+	}
+	response, err := llm.ChatCompletion(ctx, model, messages)
+	if err != nil {
+		return "", err
+	}
+	return response, onDelta(response)
+}
+
+// GenerateResponseStream is GenerateResponse with the answer text passed to
+// onDelta as the model generates it. Answers that are not generated, such as
+// InsufficientContextResponse, arrive as a single delta. With a moderator
+// configured, the answer is held back until it has been screened and then
+// passed as a single delta, so flagged text is never sent.
+func (r *RAGEngine) GenerateResponseStream(ctx context.Context, query string, docs []Document, model string, onDelta func(string) error) (Answer, error) {
+	return r.generate(ctx, query, docs, model, nil, onDelta)
+}
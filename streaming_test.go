@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// streamingOpenAI streams its reply in the given pieces.
+type streamingOpenAI struct {
+	pieces []string
+}
+
+func (s *streamingOpenAI) ChatCompletion(ctx context.Context, model string, messages []Message) (string, error) {
+	return strings.Join(s.pieces, ""), nil
+}
+
+func (s *streamingOpenAI) ChatCompletionStream(ctx context.Context, model string, messages []Message, onDelta func(string) error) (string, error) {
+	for _, piece := range s.pieces {
+		if err := onDelta(piece); err != nil {
+			return "", err
+		}
+	}
+	return strings.Join(s.pieces, ""), nil
+}
+
+func TestGenerateResponseStream(t *testing.T) {
+	ctx := context.Background()
+	docs := []Document{{Text: "Go was made at Google.", Source: "go", Similarity: 0.9}}
+	llm := &streamingOpenAI{pieces: []string{"Go was made ", "at Google ", "[1]."}}
+
+	var deltas []string
+	collect := func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	}
+	answer, err := NewRAGEngine(llm, &dummyMilvus{}).GenerateResponseStream(ctx, "Who made Go?", docs, "gpt-test", collect)
+	if err != nil || answer.Text != "Go was made at Google [1]." || len(answer.Citations) != 1 {
+		t.Fatalf("GenerateResponseStream = %+v, %v", answer, err)
+	}
+	if !slices.Equal(deltas, llm.pieces) {
+		t.Fatalf("expected the model's pieces, got %q", deltas)
+	}
+
+	// A moderator holds the answer back until it is screened.
+	deltas = nil
+	engine := NewRAGEngine(llm, &dummyMilvus{}, WithModerator(&wordModerator{}))
+	if _, err := engine.GenerateResponseStream(ctx, "Who made Go?", docs, "gpt-test", collect); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(deltas, []string{"Go was made at Google [1]."}) {
+		t.Fatalf("expected one screened delta, got %q", deltas)
+	}
+
+	// Answers that are not generated arrive whole.
+	deltas = nil
+	engine = NewRAGEngine(llm, &dummyMilvus{}, WithMinSimilarity(0.95))
+	if _, err := engine.GenerateResponseStream(ctx, "Who made Go?", docs, "gpt-test", collect); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(deltas, []string{InsufficientContextResponse}) {
+		t.Fatalf("expected the insufficient-context response, got %q", deltas)
+	}
+}
+
+type sseEvent struct {
+	name string
+	data string
+}
+
+func readSSE(t *testing.T, body string) []sseEvent {
+	t.Helper()
+	var events []sseEvent
+	var current sseEvent
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			current.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			current.data = strings.TrimPrefix(line, "data: ")
+		case line == "":
+			events = append(events, current)
+			current = sseEvent{}
+		}
+	}
+	return events
+}
+
+func TestServerStreamsQuery(t *testing.T) {
+	store := NewMemoryStore(NewHashingEmbedder(256))
+	server := NewServer(NewRAGEngine(&streamingOpenAI{pieces: []string{"Go was made ", "at Google [1]."}}, store), "gpt-test")
+	store.InsertDocuments(context.Background(), []string{"Go is a language from Google."}, []string{"Go Docs"}, nil)
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodPost, "/query/stream", strings.NewReader(`{"question":"Who made Go?","limit":1}`)),
+		httptest.NewRequest(http.MethodGet, "/query/stream?question=Who+made+Go%3F&limit=1", nil),
+	} {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("%s: expected an event stream, got %d %s", req.Method, rec.Code, rec.Header().Get("Content-Type"))
+		}
+		var names []string
+		var tokens strings.Builder
+		events := readSSE(t, rec.Body.String())
+		for _, event := range events {
+			names = append(names, event.name)
+			if event.name == "token" {
+				var token struct{ Text string }
+				json.Unmarshal([]byte(event.data), &token)
+				tokens.WriteString(token.Text)
+			}
+		}
+		want := []string{"status", "status", "status", "token", "token", "citations"}
+		if !slices.Equal(names, want) {
+			t.Fatalf("%s: expected events %v, got %v", req.Method, want, names)
+		}
+		if !strings.Contains(events[1].data, `"documents":1`) || !strings.Contains(events[1].data, "Go Docs") {
+			t.Fatalf("%s: unexpected retrieval status %s", req.Method, events[1].data)
+		}
+		var resp queryResponse
+		json.Unmarshal([]byte(events[len(events)-1].data), &resp)
+		if tokens.String() != "Go was made at Google [1]." || resp.Answer != tokens.String() || len(resp.Citations) != 1 || resp.Citations[0].Source != "Go Docs" {
+			t.Fatalf("%s: unexpected answer %q and response %+v", req.Method, tokens.String(), resp)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/query/stream", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without a question, got %d", rec.Code)
+	}
+
+	server.engine.moderator = &wordModerator{words: []string{"insult"}}
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/query/stream", strings.NewReader(`{"question":"write an insult"}`)))
+	events := readSSE(t, rec.Body.String())
+	if last := events[len(events)-1]; last.name != "error" || !strings.Contains(last.data, `"stage":"query"`) {
+		t.Fatalf("expected a policy error event, got %+v", events)
+	}
+}
+
+func TestOpenAIClientStreams(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if !req.Stream || req.StreamOptions == nil || !req.StreamOptions.IncludeUsage {
+			t.Errorf("expected a streaming request with usage, got %+v", req)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, piece := range []string{"Hello", ", world"} {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", piece)
+		}
+		fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":7,\"completion_tokens\":3}}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+	config := openai.DefaultConfig("key")
+	config.BaseURL = server.URL
+	client := &OpenAIClientImpl{client: openai.NewClientWithConfig(config)}
+
+	var usage tokenUsage
+	var deltas []string
+	text, err := client.ChatCompletionStream(withTokenUsage(context.Background(), &usage), "gpt-test", []Message{{Role: "user", Content: "hi"}}, func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	if err != nil || text != "Hello, world" || !slices.Equal(deltas, []string{"Hello", ", world"}) {
+		t.Fatalf("ChatCompletionStream = %q, %v (deltas %q)", text, err, deltas)
+	}
+	if usage.input != 7 || usage.output != 3 {
+		t.Fatalf("expected the reported usage, got %+v", usage)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// structuredRetries is how many times a malformed structured answer is sent
+// back to the model for correction before giving up.
+const structuredRetries = 2
+
+// ErrMalformedStructuredAnswer is returned when the model keeps producing
+// output that is not valid JSON or does not satisfy the schema.
+var ErrMalformedStructuredAnswer = errors.New("model did not return valid structured output")
+
+// JSONChatClient is implemented by LLM clients with a native JSON output mode.
+// The schema is passed along for providers that can enforce it; clients
+// without this method are prompted for JSON and validated the same way.
+type JSONChatClient interface {
+	ChatCompletionJSON(ctx context.Context, model string, messages []Message, schema map[string]any) (string, error)
+}
+
+// StructuredAnswer is a generated JSON answer that satisfies the caller's schema.
+type StructuredAnswer struct {
+	Data      json.RawMessage
+	Citations []Citation // markers such as [1] found in the JSON string values
+	NoContext bool       // true when no retrieved document cleared the similarity threshold; Data is nil
+}
+
+// GenerateStructuredResponse answers the query from docs as JSON matching
+// schema, a JSON Schema given as a map (e.g. decoded from a schema file).
+// Malformed output is returned to the model with the validation error and
+// retried up to structuredRetries times.
+//
+// Supported schema keywords are type, properties, required,
+// additionalProperties (false only), items, and enum.
+func (r *RAGEngine) GenerateStructuredResponse(ctx context.Context, query string, docs []Document, model string, schema map[string]any) (answer StructuredAnswer, err error) {
+	slog.InfoContext(ctx, "Processing structured query", "query", query)
+	ctx, span := tracer.Start(r.deterministic(ctx), "rag.generate_structured", trace.WithAttributes(
+		attribute.String("gen_ai.request.model", model),
+		attribute.Int("rag.documents", len(docs)),
+	))
+	defer func() {
+		queriesTotal.WithLabelValues(queryStatus(answer.NoContext, err)).Inc()
+		endSpan(span, err)
+	}()
+	if r.moderator != nil {
+		if err := r.moderate(ctx, "query", query); err != nil {
+			return StructuredAnswer{}, err
+		}
+		defer func() {
+			if err == nil && answer.Data != nil {
+				if err = r.moderate(ctx, "answer", string(answer.Data)); err != nil {
+					answer = StructuredAnswer{}
+				}
+			}
+		}()
+	}
+	if r.minSimilarity > 0 {
+		docs = r.dropIrrelevant(ctx, docs)
+		if len(docs) == 0 {
+			slog.WarnContext(ctx, "No relevant context found, skipping structured generation")
+			return StructuredAnswer{NoContext: true}, nil
+		}
+	}
+
+	schemaJSON, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return StructuredAnswer{}, fmt.Errorf("encoding schema: %w", err)
+	}
+	if len(docs) > 0 {
+		if docs = r.guardContext(ctx, docs); len(docs) == 0 {
+			slog.WarnContext(ctx, "No context left after the injection guard, skipping structured generation")
+			return StructuredAnswer{NoContext: true}, nil
+		}
+		docs = r.compressContext(ctx, query, docs)
+		docs = r.fitContext(ctx, model, messagesText(structuredMessages(query, "", schemaJSON)), docs)
+		if len(docs) == 0 {
+			slog.WarnContext(ctx, "The question leaves no room for context in the token budget, skipping structured generation")
+			return StructuredAnswer{NoContext: true}, nil
+		}
+	}
+	messages := structuredMessages(query, formatContext(docs), schemaJSON)
+
+	slog.InfoContext(ctx, "Generating structured response", "model", model)
+	var lastErr error
+	for attempt := 1; attempt <= structuredRetries+1; attempt++ {
+		response, err := r.completeJSON(ctx, model, messages, schema)
+		if err != nil {
+			slog.ErrorContext(ctx, "Generating response failed", "error", err)
+			return StructuredAnswer{}, err
+		}
+
+		data, err := parseStructuredAnswer(response, schema)
+		if err == nil {
+			citations := extractCitations(string(data), docs)
+			slog.InfoContext(ctx, "Structured response generated", "bytes", len(data), "citations", len(citations))
+			return StructuredAnswer{Data: data, Citations: citations}, nil
+		}
+
+		lastErr = err
+		slog.WarnContext(ctx, "Malformed structured response", "attempt", attempt, "max_attempts", structuredRetries+1, "error", err)
+		messages = append(messages,
+			Message{Role: "assistant", Content: response},
+			Message{Role: "user", Content: fmt.Sprintf("That reply was invalid: %v. Reply again with only a JSON value that satisfies the schema.", err)},
+		)
+	}
+	return StructuredAnswer{}, fmt.Errorf("%w after %d attempts: %v", ErrMalformedStructuredAnswer, structuredRetries+1, lastErr)
+}
+
+// structuredMessages builds the chat messages asking for a JSON answer that
+// conforms to schemaJSON.
+func structuredMessages(query, contextText string, schemaJSON []byte) []Message {
+	prompt := "Answer the user's question using only the context below.\n" +
+		"Reply with a single JSON value that conforms to this JSON Schema, with no surrounding text:\n" +
+		string(schemaJSON) + "\n" +
+		"Where a string states a fact from the context, cite its source using the bracketed numbers, e.g. [1].\n\n" +
+		"Context:\n" + contextText + "\n\nQuestion: " + query
+	return []Message{
+		{Role: "system", Content: "You are a helpful assistant that answers questions based on provided context and replies only with JSON."},
+		{Role: "user", Content: prompt},
+	}
+}
+
+// completeJSON uses the client's native JSON mode when it has one.
+func (r *RAGEngine) completeJSON(ctx context.Context, model string, messages []Message, schema map[string]any) (string, error) {
+	if client, ok := r.llm.(JSONChatClient); ok {
+		return client.ChatCompletionJSON(ctx, model, messages, schema)
+	}
+	return r.llm.ChatCompletion(ctx, model, messages)
+}
+
+// parseStructuredAnswer extracts the JSON value from a model reply, tolerating
+// a Markdown code fence around it, and validates it against schema.
+func parseStructuredAnswer(response string, schema map[string]any) (json.RawMessage, error) {
+	text := strings.TrimSpace(response)
+	if strings.HasPrefix(text, "```") {
+		text = strings.TrimPrefix(text, "```json")
+		text = strings.TrimPrefix(text, "```")
+		text = strings.TrimSuffix(strings.TrimSpace(text), "```")
+		text = strings.TrimSpace(text)
+	}
+
+	var value any
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		return nil, fmt.Errorf("not valid JSON: %w", err)
+	}
+	if err := validateSchema(value, schema, "$"); err != nil {
+		return nil, err
+	}
+	return json.RawMessage(text), nil
+}
+
+// validateSchema checks a decoded JSON value against a JSON Schema subset,
+// reporting the first violation with its path (e.g. $.items[2].name).
+func validateSchema(value any, schema map[string]any, path string) error {
+	if types := schemaStrings(schema["type"]); len(types) > 0 {
+		matched := false
+		for _, t := range types {
+			if jsonTypeMatches(value, t) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(types, " or "), jsonTypeName(value))
+		}
+	}
+
+	enum, ok := schema["enum"].([]any)
+	if names, isStrings := schema["enum"].([]string); isStrings {
+		enum, ok = make([]any, len(names)), true
+		for i, name := range names {
+			enum[i] = name
+		}
+	}
+	if ok {
+		found := false
+		for _, allowed := range enum {
+			if fmt.Sprint(allowed) == fmt.Sprint(value) && jsonTypeName(allowed) == jsonTypeName(value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: %v is not one of %v", path, value, enum)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		properties, _ := schema["properties"].(map[string]any)
+		for _, name := range schemaStrings(schema["required"]) {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s: missing required property %q", path, name)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			propSchema, known := properties[name].(map[string]any)
+			if !known {
+				if additional, ok := schema["additionalProperties"].(bool); ok && !additional {
+					return fmt.Errorf("%s: unexpected property %q", path, name)
+				}
+				continue
+			}
+			if err := validateSchema(v[name], propSchema, path+"."+name); err != nil {
+				return err
+			}
+		}
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				if err := validateSchema(item, items, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// schemaStrings reads a keyword that may be a string or a list of strings,
+// whether the schema was decoded from JSON or written as a Go literal.
+func schemaStrings(raw any) []string {
+	switch v := raw.(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func jsonTypeMatches(value any, typeName string) bool {
+	switch typeName {
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	default:
+		return jsonTypeName(value) == typeName
+	}
+}
+
+func jsonTypeName(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
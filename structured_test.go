@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+var answerSchema = map[string]any{
+	"type":     "object",
+	"required": []string{"answer", "confidence"},
+	"properties": map[string]any{
+		"answer":     map[string]any{"type": "string"},
+		"confidence": map[string]any{"type": "string", "enum": []string{"low", "medium", "high"}},
+		"sources":    map[string]any{"type": "array", "items": map[string]any{"type": "integer"}},
+	},
+	"additionalProperties": false,
+}
+
+// jsonModeOpenAI records whether the JSON mode entry point was used.
+type jsonModeOpenAI struct {
+	sequenceOpenAI
+	jsonCalls int
+}
+
+func (j *jsonModeOpenAI) ChatCompletionJSON(ctx context.Context, model string, messages []Message, schema map[string]any) (string, error) {
+	j.jsonCalls++
+	return j.ChatCompletion(ctx, model, messages)
+}
+
+func TestGenerateStructuredResponseRetriesMalformedOutput(t *testing.T) {
+	oa := &jsonModeOpenAI{sequenceOpenAI: sequenceOpenAI{replies: []string{
+		"Sure! Here is the answer.",
+		`{"answer": "Go is from Google [1]", "confidence": "certain"}`,
+		"```json\n{\"answer\": \"Go is from Google [1]\", \"confidence\": \"high\", \"sources\": [1]}\n```",
+	}}}
+	engine := NewRAGEngine(oa, &dummyMilvus{})
+	docs := []Document{{Text: "Go was designed at Google.", Source: "Go Docs", Similarity: 0.9}}
+
+	answer, err := engine.GenerateStructuredResponse(context.Background(), "Who made Go?", docs, "gpt-test", answerSchema)
+	if err != nil {
+		t.Fatalf("GenerateStructuredResponse: %v", err)
+	}
+	var parsed struct {
+		Answer     string `json:"answer"`
+		Confidence string `json:"confidence"`
+	}
+	if err := json.Unmarshal(answer.Data, &parsed); err != nil || parsed.Confidence != "high" {
+		t.Fatalf("unexpected data %s (%v)", answer.Data, err)
+	}
+	if len(answer.Citations) != 1 || answer.Citations[0].Source != "Go Docs" {
+		t.Fatalf("expected a citation of Go Docs, got %+v", answer.Citations)
+	}
+	if oa.jsonCalls != 3 {
+		t.Fatalf("expected JSON mode on every attempt, got %d calls", oa.jsonCalls)
+	}
+	retry := oa.calls[2]
+	if last := retry[len(retry)-1].Content; !strings.Contains(last, `$.confidence: certain is not one of`) {
+		t.Fatalf("expected validation error to be fed back, got %q", last)
+	}
+}
+
+func TestGenerateStructuredResponseGivesUp(t *testing.T) {
+	oa := &sequenceOpenAI{replies: []string{"no", "still no", "never"}}
+	engine := NewRAGEngine(oa, &dummyMilvus{})
+	docs := []Document{{Text: "text", Source: "src", Similarity: 0.9}}
+
+	_, err := engine.GenerateStructuredResponse(context.Background(), "q", docs, "gpt-test", answerSchema)
+	if !errors.Is(err, ErrMalformedStructuredAnswer) {
+		t.Fatalf("expected ErrMalformedStructuredAnswer, got %v", err)
+	}
+	if len(oa.calls) != structuredRetries+1 {
+		t.Fatalf("expected %d attempts, got %d", structuredRetries+1, len(oa.calls))
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	cases := []struct {
+		doc     string
+		wantErr string
+	}{
+		{`{"answer": "a", "confidence": "low", "sources": [1, 2]}`, ""},
+		{`{"answer": "a"}`, `missing required property "confidence"`},
+		{`{"answer": 3, "confidence": "low"}`, "$.answer: expected string, got number"},
+		{`{"answer": "a", "confidence": "low", "sources": [1.5]}`, "$.sources[0]: expected integer"},
+		{`{"answer": "a", "confidence": "low", "extra": true}`, `unexpected property "extra"`},
+		{`[]`, "$: expected object, got array"},
+	}
+	for _, c := range cases {
+		var value any
+		if err := json.Unmarshal([]byte(c.doc), &value); err != nil {
+			t.Fatal(err)
+		}
+		err := validateSchema(value, answerSchema, "$")
+		if c.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", c.doc, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+			t.Errorf("%s: expected error containing %q, got %v", c.doc, c.wantErr, err)
+		}
+	}
+}
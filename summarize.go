@@ -0,0 +1,287 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SummaryMode is how Summarize works through a source's chunks.
+type SummaryMode string
+
+const (
+	// SummaryMapReduce summarizes batches of chunks independently, then
+	// combines the partial summaries, in as many levels as they need.
+	SummaryMapReduce SummaryMode = "map_reduce"
+	// SummaryRefine summarizes the first batch, then revises the summary
+	// with each following batch in document order.
+	SummaryRefine SummaryMode = "refine"
+)
+
+// ParseSummaryMode parses a summary mode name; empty means SummaryMapReduce.
+func ParseSummaryMode(value string) (SummaryMode, error) {
+	switch mode := SummaryMode(strings.ToLower(strings.TrimSpace(value))); mode {
+	case "":
+		return SummaryMapReduce, nil
+	case SummaryMapReduce, SummaryRefine:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown summary mode %q (expected map_reduce or refine)", value)
+	}
+}
+
+const (
+	// maxSummaryChunks caps the chunks of a source Summarize reads.
+	maxSummaryChunks = 5000
+	// summaryBatchTokens is the most chunk text sent in one summarization
+	// call, unless the model's budget is smaller.
+	summaryBatchTokens = 6000
+)
+
+// ErrSourceNotFound is returned by Summarize for a source with no chunks the
+// engine's caller may read.
+var ErrSourceNotFound = errors.New("source not found")
+
+// Summary is the summary of a whole source.
+type Summary struct {
+	Source string
+	Mode   SummaryMode
+	Text   string
+	Chunks int // chunks summarized
+	Calls  int // LLM calls made
+}
+
+// Summarize summarizes every chunk stored under source, however long the
+// source is: the chunks, in document order, are packed into batches that fit
+// the model's token budget and summarized with model in the given mode.
+func (r *RAGEngine) Summarize(ctx context.Context, source, model string, mode SummaryMode) (Summary, error) {
+	ctx, span := tracer.Start(r.deterministic(ctx), "rag.summarize", trace.WithAttributes(attribute.String("rag.summary_mode", string(mode))))
+	defer span.End()
+
+	summary := Summary{Source: source, Mode: mode}
+	chunks, err := r.sourceChunks(ctx, source)
+	if err != nil {
+		endSpan(span, err)
+		return summary, err
+	}
+	summary.Chunks = len(chunks)
+	batches := r.summaryBatches(model, chunks)
+	slog.InfoContext(ctx, "Summarizing source", "source", source, "mode", mode, "chunks", len(chunks), "batches", len(batches))
+
+	var text string
+	switch mode {
+	case SummaryRefine:
+		text, err = r.refineSummary(ctx, source, model, batches, &summary.Calls)
+	default:
+		text, err = r.mapReduceSummary(ctx, source, model, batches, &summary.Calls)
+	}
+	span.SetAttributes(attribute.Int("rag.chunks", len(chunks)), attribute.Int("rag.summary_calls", summary.Calls))
+	if err != nil {
+		endSpan(span, err)
+		return summary, err
+	}
+	summary.Text = text
+	return summary, nil
+}
+
+// sourceChunks returns the chunks of source in document order.
+func (r *RAGEngine) sourceChunks(ctx context.Context, source string) ([]Document, error) {
+	docs, err := r.search(ctx, source, maxSummaryChunks, Filter{Eq("source", source), NotExpired(time.Now())})
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrSourceNotFound, source)
+	}
+	if len(docs) == maxSummaryChunks {
+		slog.WarnContext(ctx, "Source has more chunks than can be summarized, summarizing the first", "source", source, "chunks", maxSummaryChunks)
+	}
+	sort.SliceStable(docs, func(i, j int) bool {
+		return metadataInt(docs[i].Metadata, "chunk_start") < metadataInt(docs[j].Metadata, "chunk_start")
+	})
+	return docs, nil
+}
+
+// summaryBatches packs the chunk texts into batches of at most the batch
+// token size. A chunk larger than that gets a batch of its own.
+func (r *RAGEngine) summaryBatches(model string, chunks []Document) []string {
+	size := summaryBatchTokens
+	if budget := r.tokenBudget(model); budget > 0 {
+		size = min(size, budget/2)
+	}
+	var batches []string
+	var batch strings.Builder
+	tokens := 0
+	for _, chunk := range chunks {
+		text := chunkBody(chunk)
+		cost := r.tokens(text)
+		if batch.Len() > 0 && tokens+cost > size {
+			batches = append(batches, batch.String())
+			batch.Reset()
+			tokens = 0
+		}
+		if batch.Len() > 0 {
+			batch.WriteString("\n\n")
+		}
+		batch.WriteString(text)
+		tokens += cost
+	}
+	if batch.Len() > 0 {
+		batches = append(batches, batch.String())
+	}
+	return batches
+}
+
+// mapReduceSummary summarizes each batch, then combines the summaries in
+// groups that fit a batch until one is left.
+func (r *RAGEngine) mapReduceSummary(ctx context.Context, source, model string, batches []string, calls *int) (string, error) {
+	summaries := make([]string, len(batches))
+	for i, batch := range batches {
+		prompt := fmt.Sprintf("Summarize this excerpt (part %d of %d) of %q. Keep the key facts, figures, names, and conclusions.\n\n%s",
+			i+1, len(batches), source, batch)
+		summary, err := r.summaryCall(ctx, model, prompt, calls)
+		if err != nil {
+			return "", err
+		}
+		summaries[i] = summary
+	}
+	for len(summaries) > 1 {
+		groups := r.summaryBatches(model, textDocuments(summaries))
+		if len(groups) == len(summaries) {
+			// Every summary fills a batch on its own; combine them in pairs
+			// so the reduction still makes progress.
+			groups = pairUp(summaries)
+		}
+		reduced := make([]string, len(groups))
+		for i, group := range groups {
+			prompt := fmt.Sprintf("Combine these summaries of consecutive parts of %q into one coherent summary, "+
+				"in the order of the parts. Remove repetition but keep every distinct key fact.\n\n%s", source, group)
+			summary, err := r.summaryCall(ctx, model, prompt, calls)
+			if err != nil {
+				return "", err
+			}
+			reduced[i] = summary
+		}
+		summaries = reduced
+	}
+	return summaries[0], nil
+}
+
+// refineSummary summarizes the first batch and revises the summary with each
+// of the others in turn.
+func (r *RAGEngine) refineSummary(ctx context.Context, source, model string, batches []string, calls *int) (string, error) {
+	var summary string
+	for i, batch := range batches {
+		var prompt string
+		if i == 0 {
+			prompt = fmt.Sprintf("Summarize the beginning of %q. Keep the key facts, figures, names, and conclusions.\n\n%s", source, batch)
+		} else {
+			prompt = fmt.Sprintf("Here is a summary of %q so far:\n\n%s\n\nRevise it to also cover the next part (part %d of %d) below. "+
+				"Keep what is still accurate, add the new key facts, and correct anything the new part changes. "+
+				"Reply with the revised summary only.\n\n%s", source, summary, i+1, len(batches), batch)
+		}
+		var err error
+		if summary, err = r.summaryCall(ctx, model, prompt, calls); err != nil {
+			return "", err
+		}
+	}
+	return summary, nil
+}
+
+// summaryCall sends one summarization prompt to model.
+func (r *RAGEngine) summaryCall(ctx context.Context, model, prompt string, calls *int) (string, error) {
+	messages := []Message{
+		{Role: "system", Content: "You write accurate, concise summaries of documents. Do not add information that is not in the text."},
+		{Role: "user", Content: prompt},
+	}
+	*calls++
+	summary, err := r.llm.ChatCompletion(ctx, model, messages)
+	if err != nil {
+		return "", fmt.Errorf("summarizing: %w", err)
+	}
+	return strings.TrimSpace(summary), nil
+}
+
+// textDocuments wraps texts as documents, for summaryBatches.
+func textDocuments(texts []string) []Document {
+	docs := make([]Document, len(texts))
+	for i, text := range texts {
+		docs[i] = Document{Text: text}
+	}
+	return docs
+}
+
+// pairUp joins texts two by two.
+func pairUp(texts []string) []string {
+	var pairs []string
+	for i := 0; i < len(texts); i += 2 {
+		if i+1 < len(texts) {
+			pairs = append(pairs, texts[i]+"\n\n"+texts[i+1])
+		} else {
+			pairs = append(pairs, texts[i])
+		}
+	}
+	return pairs
+}
+
+type summarizeRequest struct {
+	Source string `json:"source"`
+	Mode   string `json:"mode,omitempty"`  // map_reduce (the default) or refine
+	Model  string `json:"model,omitempty"` // overrides the server's chat model
+}
+
+type summarizeResponse struct {
+	Source  string        `json:"source"`
+	Mode    string        `json:"mode"`
+	Summary string        `json:"summary"`
+	Chunks  int           `json:"chunks"` // chunks summarized
+	Calls   int           `json:"calls"`  // LLM calls made
+	Usage   *RequestUsage `json:"usage"`
+}
+
+func (s *Server) handleSummarize(w http.ResponseWriter, r *http.Request) {
+	var req summarizeRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Source == "" {
+		writeError(w, http.StatusBadRequest, "source is required")
+		return
+	}
+	mode, err := ParseSummaryMode(req.Mode)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	model := cmp.Or(req.Model, s.model)
+
+	usage := &RequestUsage{}
+	ctx := withRequestUsage(r.Context(), usage)
+	summary, err := s.requestEngine(r).Summarize(ctx, req.Source, model, mode)
+	if errors.Is(err, ErrSourceNotFound) {
+		writeError(w, http.StatusNotFound, "source not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Summarizing failed", "source", req.Source, "error", err)
+		writeError(w, http.StatusBadGateway, "summarizing failed")
+		return
+	}
+	writeJSON(w, http.StatusOK, summarizeResponse{
+		Source:  summary.Source,
+		Mode:    string(summary.Mode),
+		Summary: summary.Text,
+		Chunks:  summary.Chunks,
+		Calls:   summary.Calls,
+		Usage:   usage,
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// summaryTestEngine returns an engine whose summary batches hold one of the
+// four chunks of handbook.md each, plus a short second source.
+func summaryTestEngine(t *testing.T, oa LLMClient) *RAGEngine {
+	t.Helper()
+	engine := NewRAGEngine(oa, NewMemoryStore(NewHashingEmbedder(256)), WithContextBudget(60))
+	sections := []string{
+		"Section one covers onboarding: new hires get a laptop and an account on day one.",
+		"Section two covers vacation: everyone gets twenty five days of paid leave a year.",
+		"Section three covers expenses: travel is booked through the company portal only.",
+		"Section four covers security: passwords rotate every ninety days, keys never do.",
+	}
+	ingestPages(context.Background(), engine, []Page{
+		{Text: strings.Join(sections, " "), Source: "handbook.md"},
+		{Text: "Unrelated notes.", Source: "notes.md"},
+	}, 90, 0)
+	return engine
+}
+
+func TestSummarizeMapReduce(t *testing.T) {
+	oa := &sequenceOpenAI{replies: []string{"onboarding", "vacation", "expenses", "security", "The handbook covers four topics."}}
+	summary, err := summaryTestEngine(t, oa).Summarize(context.Background(), "handbook.md", "gpt-test", SummaryMapReduce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Text != "The handbook covers four topics." || summary.Chunks != 4 || summary.Calls != 5 {
+		t.Fatalf("unexpected summary %+v", summary)
+	}
+	if first := oa.calls[0][1].Content; !strings.Contains(first, "part 1 of 4") || !strings.Contains(first, "Section one") {
+		t.Fatalf("expected the first part to be summarized first, got %q", first)
+	}
+	if reduce := oa.calls[4][1].Content; !strings.Contains(reduce, "onboarding\n\nvacation\n\nexpenses\n\nsecurity") {
+		t.Fatalf("expected the partial summaries in order, got %q", reduce)
+	}
+}
+
+func TestSummarizeRefine(t *testing.T) {
+	oa := &sequenceOpenAI{replies: []string{"v1", "v2", "v3", "v4"}}
+	summary, err := summaryTestEngine(t, oa).Summarize(context.Background(), "handbook.md", "gpt-test", SummaryRefine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Text != "v4" || summary.Calls != 4 {
+		t.Fatalf("unexpected summary %+v", summary)
+	}
+	if last := oa.calls[3][1].Content; !strings.Contains(last, "so far:\n\nv3") || !strings.Contains(last, "Section four") {
+		t.Fatalf("expected the last call to refine the previous summary, got %q", last)
+	}
+
+	_, err = summaryTestEngine(t, oa).Summarize(context.Background(), "missing.md", "gpt-test", SummaryRefine)
+	if !errors.Is(err, ErrSourceNotFound) {
+		t.Fatalf("expected ErrSourceNotFound, got %v", err)
+	}
+}
+
+func TestServerSummarizes(t *testing.T) {
+	server := NewServer(summaryTestEngine(t, &scriptedOpenAI{reply: "A summary."}), "gpt-test")
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/summarize", strings.NewReader(`{"source":"notes.md","mode":"refine"}`)))
+	var resp summarizeResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("unexpected response %d: %v", rec.Code, err)
+	}
+	if resp.Summary != "A summary." || resp.Mode != "refine" || resp.Chunks != 1 || resp.Calls != 1 {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	for body, want := range map[string]int{
+		`{"source":"missing.md"}`:              http.StatusNotFound,
+		`{"source":"notes.md","mode":"brief"}`: http.StatusBadRequest,
+		`{}`:                                   http.StatusBadRequest,
+	} {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/summarize", strings.NewReader(body)))
+		if rec.Code != want {
+			t.Errorf("%s: expected %d, got %d", body, want, rec.Code)
+		}
+	}
+}
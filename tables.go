@@ -0,0 +1,400 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Metadata fields of a chunk holding (part of) a table.
+const (
+	tableHeadersField  = "table_headers"   // the column headers
+	tableRowsField     = "table_rows"      // the chunk's rows, as lists of cells
+	tableFirstRowField = "table_first_row" // the number of the chunk's first row in the table, from 1
+)
+
+// TableFormat is how WithTableFormat presents table chunks in the prompt.
+type TableFormat string
+
+const (
+	// TableMarkdown renders the rows as a Markdown table with aligned
+	// columns, under a caption giving the row numbers.
+	TableMarkdown TableFormat = "markdown"
+	// TableRecords writes each row on its own line as "header: value"
+	// pairs, so no value can be read under the wrong column.
+	TableRecords TableFormat = "records"
+)
+
+// ParseTableFormat parses a table format name; empty or "off" means table
+// chunks are shown as stored.
+func ParseTableFormat(value string) (TableFormat, error) {
+	switch format := TableFormat(strings.ToLower(strings.TrimSpace(value))); format {
+	case "", "off":
+		return "", nil
+	case TableMarkdown, TableRecords:
+		return format, nil
+	default:
+		return "", fmt.Errorf("invalid TABLE_FORMAT %q (expected off, markdown, or records)", value)
+	}
+}
+
+// WithTableFormat rewrites the text of table chunks in the prompt from their
+// stored rows and headers (see ChunkTextWithTables), in format.
+func WithTableFormat(format TableFormat) EngineOption {
+	return func(r *RAGEngine) {
+		r.tableFormat = format
+	}
+}
+
+// pipeTable is a Markdown pipe table found in a text. Offsets are bytes.
+type pipeTable struct {
+	start, end int
+	headers    []string
+	rows       [][]string
+	rowSpans   [][2]int // the start and end offsets of each row's line
+}
+
+// findPipeTables finds the pipe tables in text: a header row, a delimiter
+// row such as "| --- | :---: |", and the rows after it that contain a pipe.
+func findPipeTables(text string) []pipeTable {
+	type line struct {
+		start, end int // end excludes the line break
+		text       string
+	}
+	var lines []line
+	for offset := 0; offset < len(text); {
+		end := strings.IndexByte(text[offset:], '\n')
+		next := offset + end + 1
+		if end < 0 {
+			end, next = len(text)-offset, len(text)
+		}
+		lines = append(lines, line{offset, offset + end, strings.TrimSpace(text[offset : offset+end])})
+		offset = next
+	}
+
+	var tables []pipeTable
+	for i := 0; i+1 < len(lines); i++ {
+		headers := splitTableRow(lines[i].text)
+		if len(headers) < 2 || !strings.Contains(lines[i].text, "|") || !delimiterRow(lines[i+1].text, len(headers)) {
+			continue
+		}
+		table := pipeTable{start: lines[i].start, end: lines[i+1].end, headers: headers}
+		j := i + 2
+		for ; j < len(lines) && strings.Contains(lines[j].text, "|"); j++ {
+			table.rows = append(table.rows, fitRow(splitTableRow(lines[j].text), len(headers)))
+			table.rowSpans = append(table.rowSpans, [2]int{lines[j].start, lines[j].end})
+			table.end = lines[j].end
+		}
+		tables = append(tables, table)
+		i = j - 1
+	}
+	return tables
+}
+
+// splitTableRow splits a pipe table row into its trimmed cells. "\|" is a
+// pipe inside a cell.
+func splitTableRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+	var cells []string
+	var cell strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case line[i] == '|':
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(line[i])
+		}
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+// delimiterRow reports whether line is the delimiter row of a table with the
+// given number of columns.
+func delimiterRow(line string, columns int) bool {
+	if !strings.Contains(line, "-") {
+		return false
+	}
+	cells := splitTableRow(line)
+	if len(cells) != columns {
+		return false
+	}
+	for _, cell := range cells {
+		cell = strings.TrimSuffix(strings.TrimPrefix(cell, ":"), ":")
+		if cell == "" || strings.Trim(cell, "-") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// fitRow pads or cuts row to the given number of columns.
+func fitRow(row []string, columns int) []string {
+	for len(row) < columns {
+		row = append(row, "")
+	}
+	return row[:columns]
+}
+
+// renderPipeTable writes a Markdown pipe table.
+func renderPipeTable(headers []string, rows [][]string) string {
+	var b strings.Builder
+	writeRow := func(cells []string) {
+		b.WriteString("|")
+		for _, cell := range cells {
+			b.WriteString(" " + strings.ReplaceAll(cell, "|", `\|`) + " |")
+		}
+		b.WriteString("\n")
+	}
+	writeRow(headers)
+	b.WriteString("|" + strings.Repeat(" --- |", len(headers)) + "\n")
+	for _, row := range rows {
+		writeRow(row)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// tableChunks splits a table into chunks of whole rows of up to about
+// chunkSize characters, each starting with the header rows, so every chunk
+// reads as a table of its own. A row longer than chunkSize gets a chunk of
+// its own. The headers and rows are kept in the chunks' metadata.
+func tableChunks(table pipeTable, chunkSize int) []Chunk {
+	if len(table.rows) == 0 {
+		return []Chunk{{
+			Text:     renderPipeTable(table.headers, nil),
+			Start:    table.start,
+			End:      table.end,
+			Metadata: tableMetadata(table.headers, nil, 1),
+		}}
+	}
+	var chunks []Chunk
+	for first := 0; first < len(table.rows); {
+		last := first + 1
+		for last < len(table.rows) && utf8.RuneCountInString(renderPipeTable(table.headers, table.rows[first:last+1])) <= chunkSize {
+			last++
+		}
+		start := table.rowSpans[first][0]
+		if first == 0 {
+			start = table.start
+		}
+		chunks = append(chunks, Chunk{
+			Text:     renderPipeTable(table.headers, table.rows[first:last]),
+			Start:    start,
+			End:      table.rowSpans[last-1][1],
+			Metadata: tableMetadata(table.headers, table.rows[first:last], first+1),
+		})
+		first = last
+	}
+	return chunks
+}
+
+func tableMetadata(headers []string, rows [][]string, firstRow int) map[string]any {
+	if rows == nil {
+		rows = [][]string{}
+	}
+	return map[string]any{tableHeadersField: headers, tableRowsField: rows, tableFirstRowField: firstRow}
+}
+
+// ChunkTextWithTables splits text like ChunkTextWithOffsets, except that the
+// Markdown pipe tables in it (as written by the HTML and PDF loaders) are
+// split between rows only, into chunks that repeat the header (see
+// tableChunks).
+func ChunkTextWithTables(text string, chunkSize, overlap int) []Chunk {
+	tables := findPipeTables(text)
+	if len(tables) == 0 {
+		return ChunkTextWithOffsets(text, chunkSize, overlap)
+	}
+	var chunks []Chunk
+	chunkGap := func(start, end int) {
+		if strings.TrimSpace(text[start:end]) == "" {
+			return
+		}
+		for _, chunk := range ChunkTextWithOffsets(text[start:end], chunkSize, overlap) {
+			chunk.Start += start
+			chunk.End += start
+			chunks = append(chunks, chunk)
+		}
+	}
+	offset := 0
+	for _, table := range tables {
+		chunkGap(offset, table.start)
+		chunks = append(chunks, tableChunks(table, chunkSize)...)
+		offset = table.end
+	}
+	chunkGap(offset, len(text))
+	return chunks
+}
+
+// documentTable returns the headers, rows, and first row number of a table
+// chunk, from its metadata as stored or as read back from JSON.
+func documentTable(doc Document) (headers []string, rows [][]string, firstRow int, ok bool) {
+	headers = metadataStrings(doc.Metadata[tableHeadersField])
+	if len(headers) == 0 {
+		return nil, nil, 0, false
+	}
+	switch v := doc.Metadata[tableRowsField].(type) {
+	case [][]string:
+		rows = v
+	case []any:
+		for _, row := range v {
+			rows = append(rows, fitRow(metadataStrings(row), len(headers)))
+		}
+	}
+	return headers, rows, max(metadataInt(doc.Metadata, tableFirstRowField), 1), true
+}
+
+// metadataStrings reads a list of strings stored in metadata.
+func metadataStrings(value any) []string {
+	switch v := value.(type) {
+	case []string:
+		return v
+	case []any:
+		values := make([]string, len(v))
+		for i, item := range v {
+			values[i] = fmt.Sprint(item)
+		}
+		return values
+	}
+	return nil
+}
+
+// formatTables returns docs with the text of table chunks rewritten in
+// format. Other documents are unchanged.
+func formatTables(docs []Document, format TableFormat) []Document {
+	formatted := make([]Document, len(docs))
+	for i, doc := range docs {
+		if headers, rows, firstRow, ok := documentTable(doc); ok {
+			doc.Text = formatTable(headers, rows, firstRow, format)
+		}
+		formatted[i] = doc
+	}
+	return formatted
+}
+
+// formatTable writes the rows numbered from firstRow in format.
+func formatTable(headers []string, rows [][]string, firstRow int, format TableFormat) string {
+	caption := fmt.Sprintf("Table rows %d-%d (columns: %s):", firstRow, firstRow+max(len(rows), 1)-1, strings.Join(headers, ", "))
+	var b strings.Builder
+	b.WriteString(caption)
+	if format == TableRecords {
+		for i, row := range rows {
+			b.WriteString("\nRow " + strconv.Itoa(firstRow+i) + ": ")
+			for j, cell := range row {
+				if j > 0 {
+					b.WriteString("; ")
+				}
+				b.WriteString(headers[j] + ": " + cell)
+			}
+		}
+		return b.String()
+	}
+
+	widths := make([]int, len(headers))
+	for _, row := range append([][]string{headers}, rows...) {
+		for j, cell := range row {
+			widths[j] = max(widths[j], utf8.RuneCountInString(cell), 3)
+		}
+	}
+	writeRow := func(cells []string) {
+		b.WriteString("\n|")
+		for j, cell := range cells {
+			pad := strings.Repeat(" ", widths[j]-utf8.RuneCountInString(cell))
+			if numericCell(cell) {
+				b.WriteString(" " + pad + cell + " |")
+			} else {
+				b.WriteString(" " + cell + pad + " |")
+			}
+		}
+	}
+	writeRow(headers)
+	b.WriteString("\n|")
+	for _, width := range widths {
+		b.WriteString(" " + strings.Repeat("-", width) + " |")
+	}
+	for _, row := range rows {
+		writeRow(row)
+	}
+	return b.String()
+}
+
+// numericCell reports whether cell holds a number, which formatTable aligns
+// right, such as "1,200", "-3.5", "$40", or "12%".
+func numericCell(cell string) bool {
+	cell = strings.TrimSuffix(strings.TrimLeft(cell, "$€£-+"), "%")
+	_, err := strconv.ParseFloat(strings.ReplaceAll(cell, ",", ""), 64)
+	return err == nil
+}
+
+// pdfCell is a piece of text on a PDF page and its horizontal position.
+type pdfCell struct {
+	x    float64
+	text string
+}
+
+const (
+	// pdfTableMinRows is how many aligned rows, the header included, make
+	// a table.
+	pdfTableMinRows = 3
+	// pdfColumnTolerance is how far, in points, a cell may start from its
+	// column's header and still belong to the column.
+	pdfColumnTolerance = 24
+)
+
+// pdfRowsText rebuilds the text of a PDF page from its rows of text pieces,
+// writing runs of at least pdfTableMinRows rows whose pieces line up in two
+// or more columns as pipe tables. It returns the text and the number of
+// tables found.
+func pdfRowsText(rows [][]pdfCell) (string, int) {
+	var lines []string
+	tables := 0
+	for i := 0; i < len(rows); {
+		j := i + 1
+		for len(rows[i]) >= 2 && j < len(rows) && pdfRowAligned(rows[i], rows[j]) {
+			j++
+		}
+		if j-i < pdfTableMinRows {
+			var texts []string
+			for _, cell := range rows[i] {
+				texts = append(texts, cell.text)
+			}
+			if line := strings.Join(strings.Fields(strings.Join(texts, " ")), " "); line != "" {
+				lines = append(lines, line)
+			}
+			i++
+			continue
+		}
+		cells := make([][]string, j-i)
+		for k, row := range rows[i:j] {
+			for _, cell := range row {
+				cells[k] = append(cells[k], strings.Join(strings.Fields(cell.text), " "))
+			}
+		}
+		lines = append(lines, "", renderPipeTable(cells[0], cells[1:]), "")
+		tables++
+		i = j
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n")), tables
+}
+
+// pdfRowAligned reports whether row has a piece under each of the header's
+// and no others.
+func pdfRowAligned(header, row []pdfCell) bool {
+	if len(row) != len(header) {
+		return false
+	}
+	for k := range row {
+		if math.Abs(row[k].x-header[k].x) > pdfColumnTolerance {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+const tableDoc = `Quarterly revenue by region.
+
+| Region | Q1 |
+| --- | ---: |
+| EMEA | 1,200 |
+| APAC | 980 |
+| AMER | 2,000 |
+
+Figures are in thousands.`
+
+func TestChunkTextWithTablesSplitsBetweenRows(t *testing.T) {
+	chunks := ChunkTextWithTables(tableDoc, 70, 0)
+	if len(chunks) != 4 {
+		t.Fatalf("expected the intro, two table chunks, and the outro, got %+v", chunks)
+	}
+	if chunks[0].Text != "Quarterly revenue by region." || chunks[3].Text != "Figures are in thousands." {
+		t.Fatalf("unexpected prose chunks %q, %q", chunks[0].Text, chunks[3].Text)
+	}
+	if want := "| Region | Q1 |\n| --- | --- |\n| AMER | 2,000 |"; chunks[2].Text != want {
+		t.Fatalf("expected the header on the second table chunk, got %q", chunks[2].Text)
+	}
+	if got := tableDoc[chunks[2].Start:chunks[2].End]; got != "| AMER | 2,000 |" {
+		t.Fatalf("expected the offsets of the chunk's rows, got %q", got)
+	}
+	if !strings.HasPrefix(tableDoc[chunks[1].Start:], "| Region |") {
+		t.Fatalf("expected the first table chunk to start at the header, got offset %d", chunks[1].Start)
+	}
+	meta := chunks[2].Metadata
+	if rows := meta[tableRowsField].([][]string); len(rows) != 1 || rows[0][1] != "2,000" || meta[tableFirstRowField] != 3 {
+		t.Fatalf("unexpected table metadata %v", meta)
+	}
+	if headers := meta[tableHeadersField].([]string); strings.Join(headers, ",") != "Region,Q1" {
+		t.Fatalf("unexpected headers %v", headers)
+	}
+}
+
+func TestChunkMarkdownKeepsTablesUnderTheirHeading(t *testing.T) {
+	doc := "# Pricing\n\n| Plan | Price |\n|---|---|\n| Basic | $10 |\n| Pro \\| Team | $20 |\n\nPrices exclude VAT."
+	chunks := ChunkMarkdown(doc, 1000, 0)
+	if len(chunks) != 2 {
+		t.Fatalf("expected the table and the paragraph, got %+v", chunks)
+	}
+	if chunks[0].Metadata["heading_path"] != "Pricing" || chunks[1].Metadata["heading_path"] != "Pricing" {
+		t.Fatalf("expected the heading path on both chunks, got %v, %v", chunks[0].Metadata, chunks[1].Metadata)
+	}
+	rows := chunks[0].Metadata[tableRowsField].([][]string)
+	if len(rows) != 2 || rows[1][0] != "Pro | Team" {
+		t.Fatalf("unexpected rows %q", rows)
+	}
+	if !strings.Contains(chunks[0].Text, `| Pro \| Team | $20 |`) {
+		t.Fatalf("expected the escaped pipe in the table text, got %q", chunks[0].Text)
+	}
+}
+
+func TestHTMLTablesBecomePipeTables(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<html><body><p>Plans:</p><table>
+		<thead><tr><th>Plan</th><th>Price</th></tr></thead>
+		<tbody><tr><td>Basic</td><td>$10 <em>per month</em></td></tr><tr><td>Pro</td></tr></tbody>
+		</table><table><tr><td>layout only</td></tr></table></body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, text := extractHTMLText(doc)
+	want := "Plans:\n| Plan | Price |\n| --- | --- |\n| Basic | $10 per month |\n| Pro | |\nlayout only"
+	if text != want {
+		t.Fatalf("got %q, want %q", text, want)
+	}
+	if chunks := ChunkTextWithTables(text, 1000, 0); len(chunks) != 3 || chunks[1].Metadata[tableFirstRowField] != 1 {
+		t.Fatalf("expected the table in a chunk of its own, got %+v", chunks)
+	}
+}
+
+func TestPDFRowsTextFindsAlignedColumns(t *testing.T) {
+	rows := [][]pdfCell{
+		{{x: 50, text: "Price list"}},
+		{{x: 50, text: "Plan"}, {x: 200, text: "Price"}},
+		{{x: 50, text: "Basic"}, {x: 210, text: "$10"}},
+		{{x: 50, text: "Pro"}, {x: 208, text: "$20"}},
+		{{x: 50, text: "All prices"}, {x: 120, text: "exclude VAT."}},
+	}
+	text, tables := pdfRowsText(rows)
+	want := "Price list\n\n| Plan | Price |\n| --- | --- |\n| Basic | $10 |\n| Pro | $20 |\n\nAll prices exclude VAT."
+	if tables != 1 || text != want {
+		t.Fatalf("got %d tables in %q, want %q", tables, text, want)
+	}
+	if _, tables := pdfRowsText(rows[3:]); tables != 0 {
+		t.Fatal("expected rows that do not line up not to make a table")
+	}
+}
+
+func TestFormatTables(t *testing.T) {
+	// Metadata read back from a store comes as decoded JSON.
+	var meta map[string]any
+	json.Unmarshal([]byte(`{"table_headers":["Region","Q1"],"table_rows":[["EMEA","1,200"],["APAC","980"]],"table_first_row":11}`), &meta)
+	docs := []Document{{Text: "| Region | Q1 |", Metadata: meta}, {Text: "Prose."}}
+
+	records := formatTables(docs, TableRecords)
+	want := "Table rows 11-12 (columns: Region, Q1):\nRow 11: Region: EMEA; Q1: 1,200\nRow 12: Region: APAC; Q1: 980"
+	if records[0].Text != want || records[1].Text != "Prose." {
+		t.Fatalf("got %q, want %q", records[0].Text, want)
+	}
+	if docs[0].Text != "| Region | Q1 |" {
+		t.Fatal("expected the documents to be left unchanged")
+	}
+	markdown := formatTables(docs, TableMarkdown)
+	want = "Table rows 11-12 (columns: Region, Q1):\n| Region | Q1    |\n| ------ | ----- |\n| EMEA   | 1,200 |\n| APAC   |   980 |"
+	if markdown[0].Text != want {
+		t.Fatalf("got %q, want %q", markdown[0].Text, want)
+	}
+
+	if _, err := ParseTableFormat("csv"); err == nil {
+		t.Fatal("expected an unknown format to fail")
+	}
+}
+
+func TestEngineFormatsTablesInThePrompt(t *testing.T) {
+	oa := &dummyOpenAI{}
+	engine := NewRAGEngine(oa, &dummyMilvus{}, WithTableFormat(TableRecords))
+	chunk := ChunkTextWithTables("| Plan | Price |\n| --- | --- |\n| Basic | $10 |", 1000, 0)[0]
+	docs := []Document{{Text: chunk.Text, Source: "pricing.md", Metadata: chunk.Metadata, Similarity: 0.9}}
+	if _, err := engine.GenerateResponse(context.Background(), "What does Basic cost?", docs, "gpt-test"); err != nil {
+		t.Fatal(err)
+	}
+	if prompt := oa.lastMessages[len(oa.lastMessages)-1].Content; !strings.Contains(prompt, "Row 1: Plan: Basic; Price: $10") {
+		t.Fatalf("expected the table as records in the prompt, got %q", prompt)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// tenantField is the metadata key every chunk of a tenant carries.
+const tenantField = "tenant"
+
+// tenantNamePattern restricts tenant names so they cannot collide with the
+// separator of namespaced sources.
+var tenantNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// tenantStore confines a VectorStore to one tenant. Sources are stored with
+// the tenant as a namespace prefix ("acme::handbook.pdf"), so deleting or
+// updating a source never touches another tenant's documents, and every
+// chunk carries the tenant in its metadata, which every search is filtered
+// on. Callers see their sources without the prefix.
+type tenantStore struct {
+	inner  VectorStore
+	tenant string
+	prefix string
+}
+
+// tenantDedupStore is a tenantStore over a store that supports incremental
+// re-ingestion.
+type tenantDedupStore struct {
+	*tenantStore
+	dedup DedupStore
+}
+
+// NewTenantStore returns a view of store that only reads and writes the
+// documents of tenant.
+func NewTenantStore(store VectorStore, tenant string) (VectorStore, error) {
+	if !tenantNamePattern.MatchString(tenant) {
+		return nil, fmt.Errorf("invalid tenant name %q (use letters, digits, '.', '_', and '-')", tenant)
+	}
+	t := &tenantStore{inner: store, tenant: tenant, prefix: tenant + "::"}
+	if dedup, ok := store.(DedupStore); ok {
+		return &tenantDedupStore{tenantStore: t, dedup: dedup}, nil
+	}
+	return t, nil
+}
+
+func (t *tenantStore) InsertDocuments(ctx context.Context, texts, sources []string, metadata []map[string]any) bool {
+	scoped := make([]string, len(sources))
+	for i, source := range sources {
+		scoped[i] = t.prefix + source
+	}
+	return t.inner.InsertDocuments(ctx, texts, scoped, t.stamp(metadata, len(texts)))
+}
+
+func (t *tenantStore) SearchSimilar(ctx context.Context, query string, limit int, filter Filter) []Document {
+	scoped := make(Filter, 0, len(filter)+1)
+	for _, cond := range filter {
+		if cond.Field == tenantField {
+			continue // callers cannot widen the search to another tenant
+		}
+		if value, ok := cond.Value.(string); ok && cond.Field == "source" {
+			cond.Value = t.prefix + value
+		}
+		scoped = append(scoped, cond)
+	}
+	scoped = append(scoped, Eq(tenantField, t.tenant))
+
+	docs := t.inner.SearchSimilar(ctx, query, limit, scoped)
+	kept := docs[:0]
+	for _, doc := range docs {
+		// Stores enforce the filter; checking again guards against a backend
+		// that ignored it.
+		if doc.Metadata[tenantField] != t.tenant || !strings.HasPrefix(doc.Source, t.prefix) {
+			continue
+		}
+		doc.Source = strings.TrimPrefix(doc.Source, t.prefix)
+		doc.Metadata = maps.Clone(doc.Metadata)
+		delete(doc.Metadata, tenantField)
+		kept = append(kept, doc)
+	}
+	return kept
+}
+
+func (t *tenantStore) DeleteBySource(ctx context.Context, source string) error {
+	return t.inner.DeleteBySource(ctx, t.prefix+source)
+}
+
+func (t *tenantStore) UpdateDocument(ctx context.Context, source string, texts []string, metadata []map[string]any) error {
+	return t.inner.UpdateDocument(ctx, t.prefix+source, texts, t.stamp(metadata, len(texts)))
+}
+
+// stamp returns copies of the metadata entries with the tenant set.
+func (t *tenantStore) stamp(metadata []map[string]any, n int) []map[string]any {
+	stamped := make([]map[string]any, n)
+	for i := range stamped {
+		stamped[i] = map[string]any{}
+		if metadata != nil {
+			maps.Copy(stamped[i], metadata[i])
+		}
+		stamped[i][tenantField] = t.tenant
+	}
+	return stamped
+}
+
+func (t *tenantDedupStore) ContentHashes(ctx context.Context, source string) (map[string]bool, error) {
+	return t.dedup.ContentHashes(ctx, t.prefix+source)
+}
+
+func (t *tenantDedupStore) DeleteContentHashes(ctx context.Context, source string, hashes []string) error {
+	return t.dedup.DeleteContentHashes(ctx, t.prefix+source, hashes)
+}
+
+// ForTenant returns a copy of the engine whose retrieval, ingestion, and
+// query history are confined to tenant.
+func (r *RAGEngine) ForTenant(tenant string) (*RAGEngine, error) {
+	if r.tenant != "" {
+		return nil, fmt.Errorf("engine is already confined to tenant %q", r.tenant)
+	}
+	store, err := NewTenantStore(r.store, tenant)
+	if err != nil {
+		return nil, err
+	}
+	scoped := *r
+	scoped.store = store
+	scoped.tenant = tenant
+	return &scoped, nil
+}
+
+// APIKey grants access to the API server as a tenant, reading the documents
+// its roles allow (see ForRoles).
+type APIKey struct {
+	Key    string   `json:"key"`
+	Tenant string   `json:"tenant"`
+	Roles  []string `json:"roles,omitempty"`
+}
+
+// LoadAPIKeys reads a JSON array of APIKey entries, e.g.
+//
+//	[{"key": "sk-acme-...", "tenant": "acme", "roles": ["hr"]}, {"key": "sk-globex-...", "tenant": "globex"}]
+func LoadAPIKeys(path string) ([]APIKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("parsing API keys %s: %w", path, err)
+	}
+	seen := make(map[string]bool)
+	for i, key := range keys {
+		if key.Key == "" {
+			return nil, fmt.Errorf("API key %d in %s is empty", i+1, path)
+		}
+		if !tenantNamePattern.MatchString(key.Tenant) {
+			return nil, fmt.Errorf("API key %d in %s: invalid tenant name %q", i+1, path, key.Tenant)
+		}
+		if seen[key.Key] {
+			return nil, fmt.Errorf("API key %d in %s is listed twice", i+1, path)
+		}
+		seen[key.Key] = true
+	}
+	return keys, nil
+}
+
+// lookupAPIKey returns the entry for key, comparing in constant time.
+func lookupAPIKey(keys []APIKey, key string) (APIKey, bool) {
+	var found APIKey
+	ok := false
+	for _, k := range keys {
+		if subtle.ConstantTimeCompare([]byte(k.Key), []byte(key)) == 1 {
+			found, ok = k, true
+		}
+	}
+	return found, ok
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTenantStoreIsolatesTenants(t *testing.T) {
+	ctx := context.Background()
+	shared := NewMemoryStore(NewHashingEmbedder(256))
+	engine := NewRAGEngine(&dummyOpenAI{}, shared)
+	acme, err := engine.ForTenant("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	globex, _ := engine.ForTenant("globex")
+
+	page := func(text string) []Page { return []Page{{Text: text, Source: "handbook.md"}} }
+	if _, ok := ingestPages(ctx, acme, page("Acme ships anvils on Mondays."), 1000, 0); !ok {
+		t.Fatal("ingesting for acme failed")
+	}
+	if _, ok := ingestPages(ctx, globex, page("Globex ships anvils on Fridays."), 1000, 0); !ok {
+		t.Fatal("ingesting for globex failed")
+	}
+
+	docs := acme.Retrieve(ctx, "when are anvils shipped", 10)
+	if len(docs) != 1 || docs[0].Source != "handbook.md" || !strings.Contains(docs[0].Text, "Mondays") {
+		t.Fatalf("expected only acme's document, got %+v", docs)
+	}
+	if _, ok := docs[0].Metadata[tenantField]; ok {
+		t.Fatalf("expected the tenant to be hidden from metadata, got %+v", docs[0].Metadata)
+	}
+	// A filter naming the other tenant cannot widen the search.
+	docs = acme.Retrieve(ctx, "anvils", 10, WithFilter(Filter{Eq("source", "handbook.md"), Eq(tenantField, "globex")}))
+	if len(docs) != 1 || !strings.Contains(docs[0].Text, "Mondays") {
+		t.Fatalf("expected the filter to stay within acme, got %+v", docs)
+	}
+
+	// Re-ingesting an unchanged page is deduplicated within the tenant.
+	report, _ := ingestPages(ctx, acme, page("Acme ships anvils on Mondays."), 1000, 0)
+	if report.Skipped != 1 {
+		t.Fatalf("expected the unchanged chunk to be skipped, got %+v", report)
+	}
+
+	if err := acme.DeleteBySource(ctx, "handbook.md"); err != nil {
+		t.Fatal(err)
+	}
+	if docs := globex.Retrieve(ctx, "anvils", 10); len(docs) != 1 {
+		t.Fatalf("expected deleting acme's source to leave globex's, got %+v", docs)
+	}
+	if docs := acme.Retrieve(ctx, "anvils", 10); len(docs) != 0 {
+		t.Fatalf("expected acme's documents to be gone, got %+v", docs)
+	}
+
+	if _, err := engine.ForTenant("a::b"); err == nil {
+		t.Fatalf("expected an error for a tenant name with the separator")
+	}
+	if _, err := acme.ForTenant("globex"); err == nil {
+		t.Fatalf("expected an error for nesting tenants")
+	}
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	os.WriteFile(path, []byte(`[{"key":"k1","tenant":"acme"},{"key":"k2","tenant":"globex"}]`), 0o600)
+	keys, err := LoadAPIKeys(path)
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("LoadAPIKeys = %+v, %v", keys, err)
+	}
+	if key, ok := lookupAPIKey(keys, "k2"); !ok || key.Tenant != "globex" {
+		t.Fatalf("lookupAPIKey = %+v, %t", key, ok)
+	}
+	if _, ok := lookupAPIKey(keys, "k3"); ok {
+		t.Fatalf("expected an unknown key to be rejected")
+	}
+
+	for _, bad := range []string{`[{"key":"","tenant":"acme"}]`, `[{"key":"k","tenant":""}]`, `[{"key":"k","tenant":"a"},{"key":"k","tenant":"b"}]`} {
+		os.WriteFile(path, []byte(bad), 0o600)
+		if _, err := LoadAPIKeys(path); err == nil {
+			t.Errorf("expected an error for %s", bad)
+		}
+	}
+}
+
+func TestServerRequiresAPIKeys(t *testing.T) {
+	server, _ := newTestServer()
+	server.engine.history = newTestHistory(t)
+	if err := server.RequireAPIKeys([]APIKey{{Key: "acme-key", Tenant: "acme"}, {Key: "globex-key", Tenant: "globex"}}); err != nil {
+		t.Fatal(err)
+	}
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		req.Header.Set("X-Request-ID", "req-"+key)
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodPost, "/query", "", `{"question":"q"}`); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a key, got %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/query", "wrong", `{"question":"q"}`); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for an unknown key, got %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/metrics", "", ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected metrics without a key, got %d", rec.Code)
+	}
+
+	if rec := do(http.MethodPost, "/documents", "globex-key", `{"documents":[{"text":"Globex secret plans.","source":"plans"}]}`); rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodPost, "/query", "acme-key", `{"question":"Globex secret plans"}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var entry historyEntryJSON
+	json.NewDecoder(do(http.MethodGet, "/history/req-acme-key", "acme-key", "").Body).Decode(&entry)
+	if entry.ID != "req-acme-key" || len(entry.Documents) != 0 {
+		t.Fatalf("expected acme's query to get no context, got %+v", entry)
+	}
+
+	// Each tenant only sees its own history.
+	do(http.MethodPost, "/query", "globex-key", `{"question":"Globex secret plans"}`)
+	if rec := do(http.MethodGet, "/history/req-globex-key", "acme-key", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected acme not to see globex's query, got %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/history/req-globex-key", "globex-key", ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected globex to see its query, got %d", rec.Code)
+	}
+	var list struct {
+		Queries []historyEntryJSON `json:"queries"`
+	}
+	json.NewDecoder(do(http.MethodGet, "/history", "acme-key", "").Body).Decode(&list)
+	if len(list.Queries) != 1 || list.Queries[0].ID != "req-acme-key" {
+		t.Fatalf("expected only acme's query, got %+v", list.Queries)
+	}
+	if rec := do(http.MethodPost, "/feedback", "acme-key", `{"query_id":"req-globex-key","rating":"down"}`); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected acme not to rate globex's query, got %d", rec.Code)
+	}
+}
+
+func TestServerIgnoresOtherTenantsRequestID(t *testing.T) {
+	server, _ := newTestServer()
+	server.engine.history = newTestHistory(t)
+	if err := server.RequireAPIKeys([]APIKey{{Key: "acme-key", Tenant: "acme"}, {Key: "globex-key", Tenant: "globex"}}); err != nil {
+		t.Fatal(err)
+	}
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+key)
+		req.Header.Set("X-Request-ID", "shared")
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec
+	}
+
+	do(http.MethodPost, "/query", "acme-key", `{"question":"acme question"}`)
+	if rec := do(http.MethodPost, "/feedback", "acme-key", `{"query_id":"shared","rating":"down"}`); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected acme to rate its query, got %d: %s", rec.Code, rec.Body)
+	}
+
+	// Globex reusing acme's ID gets its own and leaves acme's record alone.
+	rec := do(http.MethodPost, "/query", "globex-key", `{"question":"globex question"}`)
+	id := rec.Header().Get("X-Request-ID")
+	if id == "" || id == "shared" {
+		t.Fatalf("expected globex to get a new query ID, got %q", id)
+	}
+	record, err := server.engine.history.GetQuery(context.Background(), "shared")
+	if err != nil || record.Tenant != "acme" || record.Question != "acme question" || record.Feedback == nil {
+		t.Fatalf("expected acme's rated query to be kept, got %+v (%v)", record, err)
+	}
+	record, err = server.engine.history.GetQuery(context.Background(), id)
+	if err != nil || record.Tenant != "globex" || record.Feedback != nil {
+		t.Fatalf("expected globex's query under its new ID without feedback, got %+v (%v)", record, err)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// LoadTextFile reads a plain text, Markdown, or source code file as a single
+// page whose source is the file name. Files ending in .md or .markdown are
+// marked as Markdown so they are chunked along their headings, and source
+// files (see codeLanguages) as code, with their language in the metadata, so
+// they are chunked along their declarations.
+func LoadTextFile(path string) ([]Page, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	if !utf8.Valid(data) {
+		return nil, fmt.Errorf("%s is not UTF-8 text", path)
+	}
+	text := strings.TrimSpace(string(data))
+	if text == "" {
+		return nil, nil
+	}
+	page := Page{Text: text, Source: filepath.Base(path)}
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".md" || ext == ".markdown" {
+		page.Format = "markdown"
+	} else if language, ok := codeLanguages[ext]; ok {
+		page.Format = "code"
+		page.Metadata = map[string]any{"language": language}
+	}
+	return []Page{page}, nil
+}
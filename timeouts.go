@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Stages of a query that StageTimeouts bound.
+const (
+	stageEmbed    = "embed"
+	stageSearch   = "search"
+	stageRerank   = "rerank"
+	stageGenerate = "generate"
+	stageQuery    = "query"
+)
+
+// StageTimeouts bound the stages of answering a query. A zero timeout leaves
+// its stage unbounded.
+//
+// A stage that runs out of time is canceled, and the query goes on with
+// what it has where it can: a query embedding or search that times out
+// fails like an unavailable vector store, so the StoreFallback answers (see
+// WithStoreFallback), and the searches of other query variants keep their
+// results; a rerank that times out keeps the retrieval order. Generation
+// has nothing to fall back on, so its timeout, like Query's, fails the
+// query with a *StageTimeoutError.
+type StageTimeouts struct {
+	Embed    time.Duration // embedding the question, for each search
+	Search   time.Duration // one vector store search, including the embedding
+	Rerank   time.Duration
+	Generate time.Duration // generating the answer, streamed or not
+	// Query bounds the whole query, from retrieval to the end of the
+	// answer. The server applies it to each request.
+	Query time.Duration
+}
+
+// WithStageTimeouts bounds the stages of every query (see StageTimeouts).
+func WithStageTimeouts(timeouts StageTimeouts) EngineOption {
+	return func(r *RAGEngine) {
+		r.timeouts = timeouts
+	}
+}
+
+// StageTimeoutError reports that a stage of a query ran past its timeout.
+// It matches context.DeadlineExceeded.
+type StageTimeoutError struct {
+	Stage   string // "embed", "search", "rerank", "generate", or "query"
+	Timeout time.Duration
+}
+
+func (e *StageTimeoutError) Error() string {
+	return fmt.Sprintf("%s timed out after %s", e.Stage, e.Timeout)
+}
+
+func (e *StageTimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// withStageTimeout returns ctx bounded by timeout, whose expiry cancels it
+// with a *StageTimeoutError for stage as the cause. A zero timeout leaves
+// ctx unbounded.
+func withStageTimeout(ctx context.Context, stage string, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeoutCause(ctx, timeout, &StageTimeoutError{Stage: stage, Timeout: timeout})
+}
+
+// stageTimedOut returns the *StageTimeoutError that caused err, if a stage
+// timeout of ctx expired, and err otherwise. The expiry of stage's own
+// timeout is logged, counted in the metrics, and added to the request's
+// TimeoutReport; that of an enclosing stage is left to it.
+func stageTimedOut(ctx context.Context, stage string, err error) error {
+	var timeout *StageTimeoutError
+	if err == nil || !errors.As(context.Cause(ctx), &timeout) {
+		return err
+	}
+	if timeout.Stage == stage {
+		stageTimeouts.WithLabelValues(stage).Inc()
+		slog.WarnContext(ctx, "Stage timed out", "stage", stage, "timeout", timeout.Timeout)
+		if report, ok := ctx.Value(timeoutReportKey{}).(*TimeoutReport); ok {
+			report.add(stage)
+		}
+	}
+	return timeout
+}
+
+// withQueryTimeout returns ctx bounded by the engine's query timeout, for a
+// request answering one question.
+func (r *RAGEngine) withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withStageTimeout(ctx, stageQuery, r.timeouts.Query)
+}
+
+type embedTimeoutKey struct{}
+
+// withEmbedTimeout returns a context in which embedQuery bounds query
+// embeddings by timeout. Stores embed the question themselves, so the
+// engine passes its embed timeout through the context.
+func withEmbedTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	if timeout <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, embedTimeoutKey{}, timeout)
+}
+
+// TimeoutReport collects the stages of one request that ran out of time, so
+// that an answer produced without them can say so.
+type TimeoutReport struct {
+	mu     sync.Mutex
+	stages []string
+}
+
+type timeoutReportKey struct{}
+
+// withTimeoutReport returns a context in which stage timeouts are added to
+// report.
+func withTimeoutReport(ctx context.Context, report *TimeoutReport) context.Context {
+	return context.WithValue(ctx, timeoutReportKey{}, report)
+}
+
+func (t *TimeoutReport) add(stage string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !slices.Contains(t.stages, stage) {
+		t.stages = append(t.stages, stage)
+	}
+}
+
+// Stages returns the stages that timed out, in the order they did.
+func (t *TimeoutReport) Stages() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return slices.Clone(t.stages)
+}
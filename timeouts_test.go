@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stalled waits for its context to end before failing, like a provider
+// that stopped answering. It stands in for an embedder, a reranker, and
+// an LLM.
+type stalled struct {
+	Embedder
+}
+
+func (s *stalled) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (s *stalled) Rerank(ctx context.Context, query string, docs []Document) ([]Document, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (s *stalled) ChatCompletion(ctx context.Context, model string, messages []Message) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func TestStageTimeoutsKeepPartialResults(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(NewHashingEmbedder(256))
+	store.InsertDocuments(ctx, []string{"Go was created at Google.", "Go has goroutines."}, []string{"Go Docs", "Go Tour"}, nil)
+
+	engine := NewRAGEngine(&dummyOpenAI{}, store, WithReranker(&stalled{}), WithStageTimeouts(StageTimeouts{Rerank: 10 * time.Millisecond}))
+	report := &TimeoutReport{}
+	docs := engine.Retrieve(withTimeoutReport(ctx, report), "who created go", 2)
+	if len(docs) != 2 || docs[0].Source != "Go Docs" || !slices.Equal(report.Stages(), []string{"rerank"}) {
+		t.Fatalf("expected the retrieval order after the rerank timed out, got %+v and %v", docs, report.Stages())
+	}
+
+	for stage, timeouts := range map[string]StageTimeouts{
+		"embed":  {Embed: 10 * time.Millisecond},
+		"search": {Search: 10 * time.Millisecond},
+	} {
+		slow := NewMemoryStore(&stalled{Embedder: NewHashingEmbedder(256)})
+		engine := NewRAGEngine(&dummyOpenAI{}, slow, WithStoreFallback(FallbackLLM), WithStageTimeouts(timeouts))
+		report := &TimeoutReport{}
+		docs := engine.Retrieve(withTimeoutReport(ctx, report), "who created go", 2)
+		if documentsFallback(docs) != FallbackLLM || !slices.Equal(report.Stages(), []string{stage}) {
+			t.Fatalf("expected the %s timeout to fall back like an outage, got %+v and %v", stage, docs, report.Stages())
+		}
+	}
+}
+
+func TestGenerateTimeout(t *testing.T) {
+	docs := []Document{{Text: "Go was created at Google.", Source: "Go Docs", Similarity: 0.9}}
+	engine := NewRAGEngine(&stalled{}, NewMemoryStore(NewHashingEmbedder(16)), WithStageTimeouts(StageTimeouts{Generate: 10 * time.Millisecond}))
+	_, err := engine.GenerateResponse(context.Background(), "who created go", docs, "gpt-test")
+	var timeout *StageTimeoutError
+	if !errors.As(err, &timeout) || timeout.Stage != "generate" || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a generate timeout, got %v", err)
+	}
+
+	// An expired query timeout is reported as such, not as the generation's.
+	ctx, cancel := withStageTimeout(context.Background(), stageQuery, time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+	if _, err := engine.GenerateResponse(ctx, "who created go", docs, "gpt-test"); !errors.As(err, &timeout) || timeout.Stage != "query" {
+		t.Fatalf("expected the query timeout, got %v", err)
+	}
+}
+
+func TestServerQueryTimeout(t *testing.T) {
+	store := NewMemoryStore(NewHashingEmbedder(256))
+	store.InsertDocuments(context.Background(), []string{"Go was created at Google."}, []string{"Go Docs"}, nil)
+	server := NewServer(NewRAGEngine(&stalled{}, store, WithStageTimeouts(StageTimeouts{Query: 20 * time.Millisecond})), "gpt-test")
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"question": "who created go"}`)))
+	var resp errorJSON
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusGatewayTimeout || resp.Error != "query timed out after 20ms" {
+		t.Fatalf("expected 504 for the query timeout, got %d %+v", rec.Code, resp)
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/query/stream", strings.NewReader(`{"question": "who created go"}`)))
+	events := readSSE(t, rec.Body.String())
+	if last := events[len(events)-1]; last.name != "error" || !strings.Contains(last.data, "query timed out") {
+		t.Fatalf("expected a timeout error event, got %+v", events)
+	}
+}
+
+func TestServerReportsTimedOutStages(t *testing.T) {
+	store := NewMemoryStore(NewHashingEmbedder(256))
+	store.InsertDocuments(context.Background(), []string{"Go was created at Google."}, []string{"Go Docs"}, nil)
+	engine := NewRAGEngine(&scriptedOpenAI{reply: "Google [1]."}, store, WithReranker(&stalled{}), WithStageTimeouts(StageTimeouts{Rerank: 10 * time.Millisecond}))
+
+	rec := httptest.NewRecorder()
+	NewServer(engine, "gpt-test").ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"question": "who created go"}`)))
+	var resp queryResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusOK || resp.Answer != "Google [1]." || !slices.Equal(resp.TimedOut, []string{"rerank"}) {
+		t.Fatalf("expected an answer noting the rerank timeout, got %d %+v", rec.Code, resp)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"unicode/utf8"
+)
+
+// TokenCounter returns the number of tokens text uses in a model's prompt.
+type TokenCounter func(text string) int
+
+// EstimateTokens approximates a token count at four characters per token,
+// which is close for English text with the OpenAI, Anthropic, and Llama
+// tokenizers and errs on the high side for code and non-Latin scripts less
+// often than a word count does.
+func EstimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// answerTokenReserve is the part of a model's context window kept free for
+// the generated answer.
+const answerTokenReserve = 1024
+
+// minTrimmedTokens is the smallest excerpt worth keeping when a document has
+// to be cut to fit the budget; below it the document is dropped.
+const minTrimmedTokens = 64
+
+// modelContextWindows lists the context window, in tokens, of common chat
+// models. Names are matched by prefix, so dated and "-latest" variants share
+// an entry.
+var modelContextWindows = []struct {
+	prefix string
+	tokens int
+}{
+	{"gpt-3.5-turbo", 16385},
+	{"gpt-4o", 128000},
+	{"gpt-4-turbo", 128000},
+	{"gpt-4.1", 1047576},
+	{"gpt-4", 8192},
+	{"o1", 200000},
+	{"o3", 200000},
+	{"claude", 200000},
+	{"gemini", 1048576},
+	{"llama3.1", 128000},
+	{"llama3.2", 128000},
+	{"llama3", 8192},
+	{"mistral", 32768},
+	{"gemma2", 8192},
+	{"qwen2.5", 32768},
+}
+
+// contextWindow returns the context window of model, or 0 if it is unknown.
+func contextWindow(model string) int {
+	for _, entry := range modelContextWindows {
+		if strings.HasPrefix(model, entry.prefix) {
+			return entry.tokens
+		}
+	}
+	return 0
+}
+
+// WithContextBudget caps the prompt at tokens: retrieved documents are added
+// in rank order until the budget is used, the first one that does not fit is
+// trimmed, and the rest are dropped. Without it, the budget is the model's
+// context window minus room for the answer, for models in the built-in list.
+func WithContextBudget(tokens int) EngineOption {
+	return func(r *RAGEngine) {
+		r.contextBudget = tokens
+	}
+}
+
+// WithTokenCounter replaces EstimateTokens, e.g. with an exact tokenizer for
+// the model in use.
+func WithTokenCounter(counter TokenCounter) EngineOption {
+	return func(r *RAGEngine) {
+		r.countTokens = counter
+	}
+}
+
+// tokenBudget returns the prompt budget for model, or 0 for no limit.
+func (r *RAGEngine) tokenBudget(model string) int {
+	budget := r.contextBudget
+	if window := contextWindow(model); window > 0 {
+		if limit := window - answerTokenReserve; budget <= 0 || budget > limit {
+			budget = limit
+		}
+	}
+	return budget
+}
+
+func (r *RAGEngine) tokens(text string) int {
+	if r.countTokens != nil {
+		return r.countTokens(text)
+	}
+	return EstimateTokens(text)
+}
+
+// fitContext keeps the highest-ranked documents whose formatted text fits in
+// the model's budget alongside the rest of the prompt, given as fixed.
+func (r *RAGEngine) fitContext(ctx context.Context, model, fixed string, docs []Document) []Document {
+	budget := r.tokenBudget(model)
+	if budget <= 0 || len(docs) == 0 {
+		return docs
+	}
+
+	remaining := budget - r.tokens(fixed)
+	kept := make([]Document, 0, len(docs))
+	trimmed := false
+	for i, doc := range docs {
+		cost := r.tokens(formatContextEntry(i, doc))
+		if cost <= remaining {
+			kept = append(kept, doc)
+			remaining -= cost
+			continue
+		}
+		header := Document{Source: doc.Source, Similarity: doc.Similarity}
+		if room := remaining - r.tokens(formatContextEntry(i, header)); room >= minTrimmedTokens {
+			doc.Text = r.trimToTokens(doc.Text, room)
+			kept = append(kept, doc)
+			trimmed = true
+		}
+		break
+	}
+
+	if dropped := len(docs) - len(kept); dropped > 0 || trimmed {
+		slog.InfoContext(ctx, "Fitted context to the token budget",
+			"budget", budget, "kept", len(kept), "dropped", dropped, "trimmed", trimmed)
+	}
+	return kept
+}
+
+// trimToTokens returns the longest prefix of text, cut at a word boundary
+// when possible, that fits in n tokens.
+func (r *RAGEngine) trimToTokens(text string, n int) string {
+	runes := []rune(text)
+	lo, hi := 0, len(runes)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if r.tokens(string(runes[:mid])) <= n {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	prefix := string(runes[:lo])
+	if cut := strings.LastIndexAny(prefix, " \n\t"); cut > len(prefix)/2 {
+		prefix = prefix[:cut]
+	}
+	return prefix
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestGenerateFitsContextToTokenBudget(t *testing.T) {
+	oa := &dummyOpenAI{}
+	engine := NewRAGEngine(oa, &dummyMilvus{}, WithContextBudget(400))
+	docs := []Document{
+		{Text: strings.Repeat("first ", 100), Source: "one", Similarity: 0.9},
+		{Text: strings.Repeat("second ", 200), Source: "two", Similarity: 0.8},
+		{Text: "third", Source: "three", Similarity: 0.7},
+	}
+
+	if _, err := engine.GenerateResponse(context.Background(), "question", docs, "unknown-model"); err != nil {
+		t.Fatal(err)
+	}
+	prompt := oa.lastMessages[len(oa.lastMessages)-1].Content
+	if !strings.Contains(prompt, "Source: one") || !strings.Contains(prompt, "Source: two") {
+		t.Fatalf("expected the top two documents in the prompt: %s", prompt)
+	}
+	if strings.Contains(prompt, "Source: three") {
+		t.Fatal("expected the lowest-ranked document to be dropped")
+	}
+	if strings.Count(prompt, "second") >= 200 {
+		t.Fatal("expected the second document to be trimmed")
+	}
+	if total := EstimateTokens(messagesText(oa.lastMessages)); total > 400 {
+		t.Fatalf("prompt uses %d tokens, over the budget of 400", total)
+	}
+}
+
+func TestGenerateWithoutRoomForContext(t *testing.T) {
+	oa := &dummyOpenAI{}
+	engine := NewRAGEngine(oa, &dummyMilvus{}, WithContextBudget(10))
+	docs := []Document{{Text: "Go was made at Google.", Source: "go", Similarity: 0.9}}
+
+	answer, err := engine.GenerateResponse(context.Background(), "Who made Go?", docs, "gpt-4o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !answer.NoContext || oa.lastMessages != nil {
+		t.Fatalf("expected an insufficient-context answer without calling the model, got %+v", answer)
+	}
+}
+
+func TestTokenBudgetDefaultsToModelWindow(t *testing.T) {
+	engine := NewRAGEngine(&dummyOpenAI{}, &dummyMilvus{})
+	if got := engine.tokenBudget("gpt-4o-mini"); got != 128000-answerTokenReserve {
+		t.Errorf("gpt-4o-mini budget = %d", got)
+	}
+	if got := engine.tokenBudget("my-finetune"); got != 0 {
+		t.Errorf("unknown model budget = %d, want no limit", got)
+	}
+	engine = NewRAGEngine(&dummyOpenAI{}, &dummyMilvus{}, WithContextBudget(500000))
+	if got := engine.tokenBudget("gpt-4"); got != 8192-answerTokenReserve {
+		t.Errorf("budget above the window = %d, want it capped", got)
+	}
+}
+
+func TestWithTokenCounter(t *testing.T) {
+	words := func(text string) int { return len(strings.Fields(text)) }
+	engine := NewRAGEngine(&dummyOpenAI{}, &dummyMilvus{}, WithTokenCounter(words))
+	if got := engine.trimToTokens("one two three four five", 3); got != "one two three" {
+		t.Errorf("trimToTokens = %q", got)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxToolRounds bounds how many rounds of tool calls AnswerWithTools runs
+// before it gives up on a final answer.
+const maxToolRounds = 5
+
+// ErrTooManyToolRounds is returned by AnswerWithTools when the model keeps
+// calling tools instead of answering.
+var ErrTooManyToolRounds = errors.New("model did not answer within the tool call limit")
+
+// Tool is a function the model may call while answering, e.g. a calculator
+// or an internal API, offered alongside the retrieved context.
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  map[string]any // JSON Schema of the arguments object
+}
+
+// ToolCall is a model's request to run a tool.
+type ToolCall struct {
+	ID        string          // answered by a "tool" message with this ToolCallID
+	Name      string          // the Tool's name
+	Arguments json.RawMessage // a JSON object matching the tool's Parameters
+}
+
+// ToolCallingClient is implemented by LLM clients that can offer tools to
+// the model. The reply is the assistant message, with text, tool calls, or
+// both; the results go back as ToolResult messages after it.
+type ToolCallingClient interface {
+	ChatCompletionTools(ctx context.Context, model string, messages []Message, tools []Tool) (Message, error)
+}
+
+// ToolResult returns the message that reports the result of call to the model.
+func ToolResult(call ToolCall, content string) Message {
+	return Message{Role: "tool", Content: content, ToolCallID: call.ID}
+}
+
+// ToolStep is one step of GenerateWithTools: either the final answer, or
+// tool calls for the caller to run.
+type ToolStep struct {
+	Answer               // the final answer, when ToolCalls is empty
+	ToolCalls []ToolCall // the tools to run before asking again
+	// Reply is the model's message. Pass it back in the next call's turns,
+	// followed by a ToolResult for each call.
+	Reply Message
+}
+
+// GenerateWithTools answers the query from docs like GenerateResponse, but
+// offers tools to the model, so that the engine can run inside an agent
+// framework that owns the tools. turns holds the replies and tool results
+// of the earlier steps, and is empty on the first call. The caller runs
+// the step's ToolCalls, appends Reply and the results to turns, and calls
+// again until a step has no tool calls. AnswerWithTools runs this loop.
+func (r *RAGEngine) GenerateWithTools(ctx context.Context, query string, docs []Document, model string, tools []Tool, turns []Message) (step ToolStep, err error) {
+	ctx, span := tracer.Start(r.deterministic(ctx), "rag.generate_tools", trace.WithAttributes(
+		attribute.String("gen_ai.request.model", model),
+		attribute.Int("rag.documents", len(docs)),
+		attribute.Int("rag.tools", len(tools)),
+	))
+	defer func() {
+		span.SetAttributes(attribute.Int("rag.tool_calls", len(step.ToolCalls)))
+		endSpan(span, err)
+	}()
+	client, ok := r.llm.(ToolCallingClient)
+	if !ok {
+		return ToolStep{}, fmt.Errorf("%T cannot call tools", r.llm)
+	}
+	if r.moderator != nil && len(turns) == 0 {
+		if err := r.moderate(ctx, "query", query); err != nil {
+			return ToolStep{}, err
+		}
+	}
+	if r.minSimilarity > 0 {
+		docs = r.dropIrrelevant(ctx, docs)
+	}
+	if r.tableFormat != "" {
+		docs = formatTables(docs, r.tableFormat)
+	}
+	question := questionPrompt(ctx, query)
+	if r.crossLingual != nil {
+		question = answerLanguagePrompt(question, query)
+	}
+	// Without context the tools may still answer, so the model is asked
+	// either way.
+	if len(docs) > 0 {
+		docs = r.guardContext(ctx, docs)
+		docs = r.compressContext(ctx, query, docs)
+		docs = r.fitContext(ctx, model, messagesText(append(toolMessages(question, ""), turns...)), docs)
+	}
+	messages := append(toolMessages(question, formatContext(docs)), turns...)
+
+	slog.InfoContext(ctx, "Generating response with tools", "model", model, "tools", len(tools), "turns", len(turns))
+	reply, err := client.ChatCompletionTools(ctx, model, messages, tools)
+	if err != nil {
+		slog.ErrorContext(ctx, "Generating response failed", "error", err)
+		return ToolStep{}, err
+	}
+	reply.Role = "assistant"
+	if len(reply.ToolCalls) > 0 {
+		names := make([]string, len(reply.ToolCalls))
+		for i, call := range reply.ToolCalls {
+			names[i] = call.Name
+		}
+		slog.InfoContext(ctx, "Model called tools", "tools", names)
+		return ToolStep{ToolCalls: reply.ToolCalls, Reply: reply}, nil
+	}
+	if r.moderator != nil {
+		if err := r.moderate(ctx, "answer", reply.Content); err != nil {
+			return ToolStep{}, err
+		}
+	}
+	answer := Answer{Text: reply.Content, Citations: extractCitations(reply.Content, docs)}
+	slog.InfoContext(ctx, "Response generated", "characters", len(answer.Text), "citations", len(answer.Citations))
+	return ToolStep{Answer: answer, Reply: reply}, nil
+}
+
+// AnswerWithTools runs GenerateWithTools to the final answer, running the
+// model's tool calls with run. An error from run is reported to the model
+// rather than returned, so that it can try another way.
+func (r *RAGEngine) AnswerWithTools(ctx context.Context, query string, docs []Document, model string, tools []Tool, run func(context.Context, ToolCall) (string, error)) (Answer, error) {
+	var turns []Message
+	for round := 0; round <= maxToolRounds; round++ {
+		step, err := r.GenerateWithTools(ctx, query, docs, model, tools, turns)
+		if err != nil {
+			return Answer{}, err
+		}
+		if len(step.ToolCalls) == 0 {
+			return step.Answer, nil
+		}
+		turns = append(turns, step.Reply)
+		for _, call := range step.ToolCalls {
+			if !slices.ContainsFunc(tools, func(tool Tool) bool { return tool.Name == call.Name }) {
+				turns = append(turns, ToolResult(call, fmt.Sprintf("error: unknown tool %q", call.Name)))
+				continue
+			}
+			result, err := run(ctx, call)
+			if err != nil {
+				slog.WarnContext(ctx, "Tool call failed", "tool", call.Name, "error", err)
+				result = "error: " + err.Error()
+			}
+			turns = append(turns, ToolResult(call, result))
+		}
+	}
+	return Answer{}, fmt.Errorf("%w (%d rounds)", ErrTooManyToolRounds, maxToolRounds)
+}
+
+// toolMessages builds the chat messages of GenerateWithTools, which lets
+// the tools answer what the context does not.
+func toolMessages(query, contextText string) []Message {
+	prompt := "Answer the user's question using the context below and the tools available to you.\n" +
+		"Call a tool when the context does not hold what the question needs, such as a calculation or live data.\n" +
+		"If neither the context nor the tools answer the question, say \"" + InsufficientContextResponse + "\"\n" +
+		"Cite the sources from the context that support each statement using their bracketed numbers, e.g. [1] or [2][3].\n\n" +
+		"Context:\n" + contextText + "\n\nQuestion: " + query
+	return []Message{
+		{Role: "system", Content: "You are a helpful assistant that answers questions based on provided context and tools."},
+		{Role: "user", Content: prompt},
+	}
+}
+
+// toolCallName returns the name of the tool call with the given ID among
+// messages, the ones before a tool result, for providers that identify tool
+// results by name. Such providers have no call IDs, so the clients number
+// the calls anew in every reply and the latest match wins.
+func toolCallName(messages []Message, id string) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		for _, call := range messages[i].ToolCalls {
+			if call.ID == id {
+				return call.Name
+			}
+		}
+	}
+	return ""
+}
+
+// toolArguments returns the arguments of a tool call as a JSON object,
+// which providers require even for tools without parameters.
+func toolArguments(arguments json.RawMessage) json.RawMessage {
+	if len(arguments) == 0 {
+		return json.RawMessage("{}")
+	}
+	return arguments
+}
+
+// toolParameters returns the JSON Schema of a tool's arguments, an empty
+// object schema when it takes none.
+func toolParameters(tool Tool) map[string]any {
+	if tool.Parameters == nil {
+		return map[string]any{"type": "object", "properties": map[string]any{}}
+	}
+	return tool.Parameters
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// toolOpenAI replies with each of replies in turn and records the messages
+// and tools of every call.
+type toolOpenAI struct {
+	replies []Message
+	calls   [][]Message
+	tools   [][]Tool
+}
+
+func (t *toolOpenAI) ChatCompletion(ctx context.Context, model string, messages []Message) (string, error) {
+	return "", errors.New("expected a call with tools")
+}
+
+func (t *toolOpenAI) ChatCompletionTools(ctx context.Context, model string, messages []Message, tools []Tool) (Message, error) {
+	t.calls = append(t.calls, messages)
+	t.tools = append(t.tools, tools)
+	reply := t.replies[0]
+	t.replies = t.replies[1:]
+	return reply, nil
+}
+
+var calculator = Tool{Name: "multiply", Description: "Multiplies two numbers.", Parameters: map[string]any{
+	"type":       "object",
+	"properties": map[string]any{"a": map[string]any{"type": "number"}, "b": map[string]any{"type": "number"}},
+}}
+
+func TestAnswerWithToolsRunsTheModelsToolCalls(t *testing.T) {
+	ctx := context.Background()
+	oa := &toolOpenAI{replies: []Message{
+		{Role: "assistant", ToolCalls: []ToolCall{
+			{ID: "a", Name: "multiply", Arguments: json.RawMessage(`{"a":12,"b":30}`)},
+			{ID: "b", Name: "weather"},
+		}},
+		{Role: "assistant", Content: "A seat costs 30 EUR [1], so 12 seats cost 360 EUR."},
+	}}
+	engine := NewRAGEngine(oa, NewMemoryStore(NewHashingEmbedder(256)))
+	docs := []Document{{Text: "A seat costs 30 EUR per month.", Source: "pricing.md", Similarity: 0.9}}
+
+	var ran []string
+	answer, err := engine.AnswerWithTools(ctx, "What do 12 seats cost?", docs, "gpt-test", []Tool{calculator}, func(ctx context.Context, call ToolCall) (string, error) {
+		ran = append(ran, string(call.Arguments))
+		return "360", nil
+	})
+	if err != nil || !strings.Contains(answer.Text, "360 EUR") || len(answer.Citations) != 1 {
+		t.Fatalf("unexpected answer %+v, %v", answer, err)
+	}
+	if len(ran) != 1 || ran[0] != `{"a":12,"b":30}` {
+		t.Fatalf("expected the multiply call only, got %q", ran)
+	}
+	if len(oa.calls) != 2 || len(oa.tools[1]) != 1 || !strings.Contains(oa.calls[0][1].Content, "pricing") {
+		t.Fatalf("expected two calls with the tool and context, got %+v", oa.calls)
+	}
+	turns := oa.calls[1][2:]
+	if len(turns) != 3 || len(turns[0].ToolCalls) != 2 || turns[1].ToolCallID != "a" || turns[1].Content != "360" ||
+		turns[2].ToolCallID != "b" || !strings.Contains(turns[2].Content, "unknown tool") {
+		t.Fatalf("expected the reply and both tool results, got %+v", turns)
+	}
+}
+
+func TestAnswerWithToolsStopsAfterTheRoundLimit(t *testing.T) {
+	call := Message{Role: "assistant", ToolCalls: []ToolCall{{ID: "a", Name: "multiply"}}}
+	oa := &toolOpenAI{}
+	for range maxToolRounds + 1 {
+		oa.replies = append(oa.replies, call)
+	}
+	engine := NewRAGEngine(oa, NewMemoryStore(NewHashingEmbedder(256)))
+	_, err := engine.AnswerWithTools(context.Background(), "q", nil, "gpt-test", []Tool{calculator}, func(context.Context, ToolCall) (string, error) {
+		return "", errors.New("overflow")
+	})
+	if !errors.Is(err, ErrTooManyToolRounds) {
+		t.Fatalf("expected ErrTooManyToolRounds, got %v", err)
+	}
+}
+
+func TestGenerateWithToolsNeedsAToolCallingClient(t *testing.T) {
+	engine := NewRAGEngine(&dummyOpenAI{}, NewMemoryStore(NewHashingEmbedder(256)))
+	if _, err := engine.GenerateWithTools(context.Background(), "q", nil, "gpt-test", []Tool{calculator}, nil); err == nil {
+		t.Fatal("expected an error for a client without tools")
+	}
+}
+
+// toolConversation is a question, a reply with two tool calls, and their
+// results.
+var toolConversation = []Message{
+	{Role: "system", Content: "be nice"},
+	{Role: "user", Content: "What do 12 seats cost?"},
+	{Role: "assistant", ToolCalls: []ToolCall{
+		{ID: "toolu_1", Name: "multiply", Arguments: json.RawMessage(`{"a":12,"b":30}`)},
+		{ID: "toolu_2", Name: "currency"},
+	}},
+	{Role: "tool", ToolCallID: "toolu_1", Content: "360"},
+	{Role: "tool", ToolCallID: "toolu_2", Content: "EUR"},
+}
+
+func TestAnthropicClientSendsToolsAndResults(t *testing.T) {
+	var got map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"content":[{"type":"text","text":"Checking."},{"type":"tool_use","id":"toolu_3","name":"multiply","input":{"a":1,"b":2}}]}`))
+	}))
+	defer server.Close()
+	client := NewAnthropicClient("test-key")
+	client.baseURL = server.URL
+
+	reply, err := client.ChatCompletionTools(context.Background(), "claude-test", toolConversation, []Tool{calculator})
+	if err != nil || reply.Content != "Checking." || len(reply.ToolCalls) != 1 || reply.ToolCalls[0].ID != "toolu_3" || string(reply.ToolCalls[0].Arguments) != `{"a":1,"b":2}` {
+		t.Fatalf("unexpected reply %+v, %v", reply, err)
+	}
+	encoded, _ := json.Marshal(got)
+	for _, want := range []string{
+		`"input_schema":{"properties"`,
+		`{"id":"toolu_2","input":{},"name":"currency","type":"tool_use"}`,
+		`{"content":[{"content":"360","tool_use_id":"toolu_1","type":"tool_result"},{"content":"EUR","tool_use_id":"toolu_2","type":"tool_result"}],"role":"user"}`,
+	} {
+		if !strings.Contains(string(encoded), want) {
+			t.Errorf("expected %s in the request, got %s", want, encoded)
+		}
+	}
+}
+
+func TestGeminiClientNamesFunctionResponses(t *testing.T) {
+	var got geminiGenerateRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"multiply","args":{"a":1}}}]}}]}`))
+	}))
+	defer server.Close()
+	client, _ := NewGeminiClient("test-key", "", 0)
+	client.baseURL = server.URL
+
+	reply, err := client.ChatCompletionTools(context.Background(), "gemini-test", toolConversation, []Tool{calculator})
+	if err != nil || len(reply.ToolCalls) != 1 || reply.ToolCalls[0].ID != "call_1" || reply.ToolCalls[0].Name != "multiply" {
+		t.Fatalf("unexpected reply %+v, %v", reply, err)
+	}
+	if len(got.Contents) != 3 || len(got.Contents[1].Parts) != 2 || got.Contents[1].Parts[0].FunctionCall == nil {
+		t.Fatalf("expected the function calls in one model turn, got %+v", got.Contents)
+	}
+	results := got.Contents[2].Parts
+	if len(results) != 2 || results[0].FunctionResponse.Name != "multiply" || results[1].FunctionResponse.Name != "currency" {
+		t.Fatalf("expected both results named after their calls, got %+v", results)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// defaultTopicRefusal answers out-of-scope questions unless the TopicGuard
+// has a Refusal of its own.
+const defaultTopicRefusal = "Sorry, I can only answer questions about {domain}."
+
+// TopicClassifier decides whether a question falls within the domain the
+// knowledge base covers.
+type TopicClassifier interface {
+	InScope(ctx context.Context, domain, query string) (bool, error)
+}
+
+// TopicGuard refuses questions outside the knowledge base's domain before
+// any retrieval or generation tokens are spent on them.
+type TopicGuard struct {
+	Domain     string // what the knowledge base covers, e.g. "the Acme billing API"
+	Classifier TopicClassifier
+	// Refusal is the answer to an out-of-scope question, with {domain} and
+	// {question} replaced. Empty means defaultTopicRefusal.
+	Refusal string
+}
+
+// WithTopicGuard screens questions with the guard's classifier, so that
+// Chat, AnswerDecomposed, AnswerIterative, and the query endpoints answer
+// out-of-scope questions with the refusal instead (see OutOfScope).
+func WithTopicGuard(guard TopicGuard) EngineOption {
+	return func(r *RAGEngine) {
+		r.topicGuard = &guard
+	}
+}
+
+// OutOfScope returns the refusal, and true, when the topic guard finds
+// query outside the knowledge base's domain. Callers that retrieve and
+// generate themselves check it first. If the classifier fails, the query
+// goes through: the guard saves tokens, it is not a policy like moderation.
+func (r *RAGEngine) OutOfScope(ctx context.Context, query string) (Answer, bool) {
+	if r.topicGuard == nil {
+		return Answer{}, false
+	}
+	ctx, span := tracer.Start(ctx, "rag.classify_topic")
+	defer span.End()
+	inScope, err := r.topicGuard.Classifier.InScope(ctx, r.topicGuard.Domain, query)
+	if err != nil {
+		errorsTotal.WithLabelValues("topic").Inc()
+		slog.WarnContext(ctx, "Topic classification failed, answering anyway", "error", err)
+		return Answer{}, false
+	}
+	span.SetAttributes(attribute.Bool("rag.in_scope", inScope))
+	if inScope {
+		return Answer{}, false
+	}
+	queriesTotal.WithLabelValues("out_of_scope").Inc()
+	slog.InfoContext(ctx, "Refusing out-of-scope question", "query", query)
+	refusal := r.topicGuard.Refusal
+	if refusal == "" {
+		refusal = defaultTopicRefusal
+	}
+	refusal = strings.NewReplacer("{domain}", r.topicGuard.Domain, "{question}", query).Replace(refusal)
+	return Answer{Text: refusal, OutOfScope: true}, true
+}
+
+// LLMTopicClassifier asks a chat model, ideally a small, fast one, whether
+// the question is about the domain.
+type LLMTopicClassifier struct {
+	client LLMClient
+	model  string
+}
+
+// NewLLMTopicClassifier builds a classifier that asks model.
+func NewLLMTopicClassifier(client LLMClient, model string) *LLMTopicClassifier {
+	return &LLMTopicClassifier{client: client, model: model}
+}
+
+// InScope asks the model for a yes or no verdict. Anything but a clear no
+// counts as in scope, so that vague questions still get an answer.
+func (l *LLMTopicClassifier) InScope(ctx context.Context, domain, query string) (bool, error) {
+	messages := []Message{
+		{Role: "system", Content: "You decide whether questions are within the scope of a knowledge base."},
+		{Role: "user", Content: "The knowledge base covers " + domain + ".\n" +
+			"Is the question below about that domain, or could documents about it answer it? " +
+			"Greetings and questions about the assistant itself count as in scope. Reply with yes or no only.\n\n" +
+			"Question: " + query},
+	}
+	reply, err := l.client.ChatCompletion(ctx, l.model, messages)
+	if err != nil {
+		return false, err
+	}
+	verdict := strings.ToLower(strings.Trim(strings.TrimSpace(reply), ".!\"'"))
+	return !strings.HasPrefix(verdict, "no"), nil
+}
+
+// KeywordTopicClassifier is a local, dependency-free classifier: a question
+// is in scope if it mentions one of Keywords. A keyword of several words
+// matches when the question has all of them.
+type KeywordTopicClassifier struct {
+	Keywords []string
+}
+
+func (k *KeywordTopicClassifier) InScope(ctx context.Context, domain, query string) (bool, error) {
+	terms := tokenize(query)
+	return slices.ContainsFunc(k.Keywords, func(keyword string) bool { return mentionsKeyword(terms, keyword) }), nil
+}
+
+// topicGuardFromEnv configures the topic guard from TOPIC_GUARD (off, llm,
+// or keyword) and TOPIC_DOMAIN, with TOPIC_MODEL (default: the chat model)
+// classifying for llm, TOPIC_KEYWORDS (comma-separated) for keyword, and
+// TOPIC_REFUSAL as the refusal. It returns nil when the guard is off.
+func topicGuardFromEnv(llmClient LLMClient, chatModel string) (*TopicGuard, error) {
+	guard := TopicGuard{Domain: os.Getenv("TOPIC_DOMAIN"), Refusal: os.Getenv("TOPIC_REFUSAL")}
+	switch mode := os.Getenv("TOPIC_GUARD"); mode {
+	case "", "off":
+		return nil, nil
+	case "llm":
+		model := os.Getenv("TOPIC_MODEL")
+		if model == "" {
+			model = chatModel
+		}
+		guard.Classifier = NewLLMTopicClassifier(llmClient, model)
+	case "keyword":
+		var keywords []string
+		for _, keyword := range strings.Split(os.Getenv("TOPIC_KEYWORDS"), ",") {
+			if keyword = strings.TrimSpace(keyword); keyword != "" {
+				keywords = append(keywords, keyword)
+			}
+		}
+		if len(keywords) == 0 {
+			return nil, fmt.Errorf("TOPIC_GUARD keyword needs TOPIC_KEYWORDS")
+		}
+		guard.Classifier = &KeywordTopicClassifier{Keywords: keywords}
+	default:
+		return nil, fmt.Errorf("invalid TOPIC_GUARD %q (expected off, llm, or keyword)", mode)
+	}
+	if guard.Domain == "" {
+		return nil, fmt.Errorf("TOPIC_GUARD %s needs TOPIC_DOMAIN, a description of what the knowledge base covers", os.Getenv("TOPIC_GUARD"))
+	}
+	return &guard, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestKeywordTopicClassifier(t *testing.T) {
+	classifier := &KeywordTopicClassifier{Keywords: []string{"invoice", "billing cycle"}}
+	for query, want := range map[string]bool{
+		"How do I download an invoice?":      true,
+		"When does the billing cycle start?": true,
+		"Is billing monthly?":                false,
+		"What is the capital of France?":     false,
+	} {
+		if got, _ := classifier.InScope(context.Background(), "billing", query); got != want {
+			t.Errorf("InScope(%q) = %v, want %v", query, got, want)
+		}
+	}
+}
+
+func TestServerRefusesOutOfScopeQuestionsBeforeRetrieval(t *testing.T) {
+	oa := &sequenceOpenAI{replies: []string{"No.", "yes", "Invoices are in Postgres [1]."}}
+	store := NewMemoryStore(NewHashingEmbedder(256))
+	engine := NewRAGEngine(oa, store, WithTopicGuard(TopicGuard{
+		Domain:     "the Acme billing service",
+		Classifier: NewLLMTopicClassifier(oa, "gpt-mini"),
+		Refusal:    "I only know about {domain}.",
+	}))
+	store.InsertDocuments(context.Background(), []string{"Invoices are stored in Postgres."}, []string{"billing.md"}, nil)
+	server := NewServer(engine, "gpt-test")
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"question":"Who won the 1998 World Cup?"}`)))
+	var resp queryResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != http.StatusOK || !resp.OutOfScope || resp.Answer != "I only know about the Acme billing service." || len(resp.Citations) != 0 {
+		t.Fatalf("expected the refusal, got %d %s", rec.Code, rec.Body)
+	}
+	if len(oa.calls) != 1 || !strings.Contains(oa.calls[0][1].Content, "Acme billing") {
+		t.Fatalf("expected only the classification call, got %+v", oa.calls)
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"question":"Where are invoices stored?"}`)))
+	resp = queryResponse{}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if resp.OutOfScope || len(resp.Citations) != 1 || len(oa.calls) != 3 {
+		t.Fatalf("expected an answer to the in-scope question, got %s", rec.Body)
+	}
+}
+
+func TestTopicGuardLetsQuestionsThroughWhenTheClassifierFails(t *testing.T) {
+	oa := &sequenceOpenAI{}
+	engine := NewRAGEngine(oa, NewMemoryStore(NewHashingEmbedder(256)), WithTopicGuard(TopicGuard{
+		Domain:     "billing",
+		Classifier: NewLLMTopicClassifier(oa, "gpt-mini"),
+	}))
+	if _, refused := engine.OutOfScope(context.Background(), "Where are invoices stored?"); refused {
+		t.Fatal("expected the question to go through")
+	}
+}
+
+func TestChatStreamsTheRefusal(t *testing.T) {
+	engine := NewRAGEngine(&dummyOpenAI{}, NewMemoryStore(NewHashingEmbedder(256)), WithTopicGuard(TopicGuard{
+		Domain:     "billing",
+		Classifier: &KeywordTopicClassifier{Keywords: []string{"invoice"}},
+	}))
+	var streamed string
+	answer, err := engine.ChatStream(context.Background(), engine.NewConversation(), "Tell me a joke", 3, "gpt-test", func(delta string) error {
+		streamed += delta
+		return nil
+	})
+	if err != nil || !answer.OutOfScope || streamed != "Sorry, I can only answer questions about billing." {
+		t.Fatalf("expected the default refusal, got %+v %q, %v", answer, streamed, err)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans around ingestion, embedding, vector search, and
+// LLM calls. Until setupTracing installs a provider, spans are no-ops.
+var tracer = otel.Tracer("rag-example")
+
+// setupTracing exports spans to an OTLP/HTTP collector when
+// OTEL_EXPORTER_OTLP_ENDPOINT is set, naming the service after
+// OTEL_SERVICE_NAME (default "rag"). The returned function flushes pending
+// spans and must be called before exiting.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	// The exporter reads the endpoint, headers, and TLS settings from the
+	// standard OTEL_EXPORTER_OTLP_* variables.
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("creating OTLP exporter: %w", err)
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", "rag")),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("building trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// endSpan marks the span as failed when err is non-nil and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// recordTokenUsage attaches the token counts reported by a provider to the
+// span in ctx, which is the LLM or embedding span started by the
+// instrumented wrappers below, and passes them on to the token counters.
+func recordTokenUsage(ctx context.Context, inputTokens, outputTokens int) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.Int("gen_ai.usage.input_tokens", inputTokens))
+	if outputTokens > 0 {
+		span.SetAttributes(attribute.Int("gen_ai.usage.output_tokens", outputTokens))
+	}
+	if usage, ok := ctx.Value(tokenUsageKey{}).(*tokenUsage); ok {
+		usage.input += inputTokens
+		usage.output += outputTokens
+	}
+}
+
+// instrumentedLLM wraps an LLMClient with a span and metrics per completion.
+type instrumentedLLM struct {
+	llm LLMClient
+}
+
+// instrumentLLM returns llm instrumented with tracing spans and metrics.
+func instrumentLLM(llm LLMClient) LLMClient {
+	return &instrumentedLLM{llm: llm}
+}
+
+func (i *instrumentedLLM) ChatCompletion(ctx context.Context, model string, messages []Message) (string, error) {
+	return i.observe(ctx, model, messages, false, func(ctx context.Context) (string, error) {
+		return i.llm.ChatCompletion(ctx, model, messages)
+	})
+}
+
+// ChatCompletionJSON forwards to the wrapped client's JSON mode when it has
+// one, so wrapping does not change how structured answers are generated.
+func (i *instrumentedLLM) ChatCompletionJSON(ctx context.Context, model string, messages []Message, schema map[string]any) (string, error) {
+	return i.observe(ctx, model, messages, true, func(ctx context.Context) (string, error) {
+		if client, ok := i.llm.(JSONChatClient); ok {
+			return client.ChatCompletionJSON(ctx, model, messages, schema)
+		}
+		return i.llm.ChatCompletion(ctx, model, messages)
+	})
+}
+
+// ChatCompletionStream forwards to the wrapped client's streaming mode when
+// it has one, and otherwise passes the whole completion to onDelta at once.
+func (i *instrumentedLLM) ChatCompletionStream(ctx context.Context, model string, messages []Message, onDelta func(string) error) (string, error) {
+	return i.observe(ctx, model, messages, false, func(ctx context.Context) (string, error) {
+		return streamCompletion(ctx, i.llm, model, messages, onDelta)
+	})
+}
+
+// DescribeImage forwards to the wrapped client when it can describe images.
+func (i *instrumentedLLM) DescribeImage(ctx context.Context, model string, image Image, prompt string) (string, error) {
+	return i.observe(ctx, model, []Message{{Role: "user", Content: prompt}}, false, func(ctx context.Context) (string, error) {
+		client, ok := i.llm.(VisionClient)
+		if !ok {
+			return "", fmt.Errorf("%T cannot describe images", i.llm)
+		}
+		return client.DescribeImage(ctx, model, image, prompt)
+	})
+}
+
+// ChatCompletionTools forwards to the wrapped client when it can call tools.
+func (i *instrumentedLLM) ChatCompletionTools(ctx context.Context, model string, messages []Message, tools []Tool) (Message, error) {
+	var reply Message
+	_, err := i.observe(ctx, model, messages, false, func(ctx context.Context) (string, error) {
+		client, ok := i.llm.(ToolCallingClient)
+		if !ok {
+			return "", fmt.Errorf("%T cannot call tools", i.llm)
+		}
+		var err error
+		reply, err = client.ChatCompletionTools(ctx, model, messages, tools)
+		return reply.Content, err
+	})
+	return reply, err
+}
+
+// observe runs one completion inside an llm.chat span and records its
+// latency, token usage, and failure in the metrics.
+func (i *instrumentedLLM) observe(ctx context.Context, model string, messages []Message, jsonMode bool, call func(context.Context) (string, error)) (string, error) {
+	ctx, span := tracer.Start(ctx, "llm.chat", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("gen_ai.operation.name", "chat"),
+		attribute.String("gen_ai.request.model", model),
+		attribute.Int("gen_ai.request.messages", len(messages)),
+		attribute.Bool("gen_ai.request.json", jsonMode),
+	))
+	var usage tokenUsage
+	start := time.Now()
+	response, err := call(withTokenUsage(ctx, &usage))
+	llmDuration.WithLabelValues(model).Observe(time.Since(start).Seconds())
+	llmTokens.WithLabelValues(model, "input").Add(float64(usage.input))
+	llmTokens.WithLabelValues(model, "output").Add(float64(usage.output))
+	if err != nil {
+		errorsTotal.WithLabelValues("llm").Inc()
+	} else {
+		trackUsage(ctx, "chat", model, usage.input, usage.output)
+	}
+	endSpan(span, err)
+	return response, err
+}
+
+// instrumentedEmbedder wraps an Embedder with a span and metrics per
+// embedding request.
+type instrumentedEmbedder struct {
+	embedder Embedder
+	model    string
+}
+
+// instrumentEmbedder returns embedder instrumented with tracing spans and
+// metrics. model names the embedding model in spans and usage records.
+func instrumentEmbedder(embedder Embedder, model string) Embedder {
+	return &instrumentedEmbedder{embedder: embedder, model: model}
+}
+
+func (i *instrumentedEmbedder) Dimension() int {
+	return i.embedder.Dimension()
+}
+
+func (i *instrumentedEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	ctx, span := tracer.Start(ctx, "embedding.embed", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("gen_ai.operation.name", "embeddings"),
+		attribute.String("gen_ai.request.model", i.model),
+		attribute.Int("embedding.texts", len(texts)),
+	))
+	var usage tokenUsage
+	start := time.Now()
+	embeddings, err := i.embedder.Embed(withTokenUsage(ctx, &usage), texts)
+	embeddingDuration.Observe(time.Since(start).Seconds())
+	embeddingRequests.Inc()
+	embeddingTexts.Add(float64(len(texts)))
+	embeddingTokens.Add(float64(usage.input))
+	if err != nil {
+		errorsTotal.WithLabelValues("embedding").Inc()
+	} else if usage.input > 0 {
+		// Cache hits and local embedders report no tokens and are not billed.
+		trackUsage(ctx, "embedding", i.model, usage.input, 0)
+	}
+	endSpan(span, err)
+	return embeddings, err
+}
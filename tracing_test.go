@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// usageOpenAI reports token usage the way the real clients do.
+type usageOpenAI struct{}
+
+func (u *usageOpenAI) ChatCompletion(ctx context.Context, model string, messages []Message) (string, error) {
+	recordTokenUsage(ctx, 12, 5)
+	return "Go is a language [1].", nil
+}
+
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := tracer
+	tracer = provider.Tracer("test")
+	t.Cleanup(func() { tracer = previous })
+	return recorder
+}
+
+func spanAttr(span sdktrace.ReadOnlySpan, key string) (attribute.Value, bool) {
+	for _, kv := range span.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestTracingSpans(t *testing.T) {
+	recorder := recordSpans(t)
+	ctx := context.Background()
+	store := NewMemoryStore(instrumentEmbedder(NewHashingEmbedder(64), "hashing"))
+	engine := NewRAGEngine(instrumentLLM(&usageOpenAI{}), store)
+
+	report, ok := ingestPages(ctx, engine, []Page{{Text: "Go is a programming language", Source: "go.md"}}, 1000, 0)
+	if !ok || report.Inserted != 1 {
+		t.Fatalf("ingest: ok=%t report=%+v", ok, report)
+	}
+	docs := engine.Retrieve(ctx, "what is go", 1)
+	if _, err := engine.GenerateResponse(ctx, "what is go", docs, "gpt-test"); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	for _, name := range []string{"rag.ingest", "vectorstore.insert", "embedding.embed", "rag.retrieve", "vectorstore.search", "rag.generate", "llm.chat"} {
+		if _, ok := spans[name]; !ok {
+			t.Errorf("missing span %q", name)
+		}
+	}
+
+	llm := spans["llm.chat"]
+	if llm == nil {
+		t.FailNow()
+	}
+	if llm.Parent().SpanID() != spans["rag.generate"].SpanContext().SpanID() {
+		t.Errorf("llm.chat is not a child of rag.generate")
+	}
+	if v, _ := spanAttr(llm, "gen_ai.usage.input_tokens"); v.AsInt64() != 12 {
+		t.Errorf("input tokens = %v, want 12", v.AsInt64())
+	}
+	if v, _ := spanAttr(llm, "gen_ai.usage.output_tokens"); v.AsInt64() != 5 {
+		t.Errorf("output tokens = %v, want 5", v.AsInt64())
+	}
+	if v, _ := spanAttr(spans["rag.ingest"], "rag.ingest.inserted"); v.AsInt64() != 1 {
+		t.Errorf("rag.ingest.inserted = %v, want 1", v.AsInt64())
+	}
+	if v, _ := spanAttr(spans["rag.generate"], "rag.citations"); v.AsInt64() != 1 {
+		t.Errorf("rag.citations = %v, want 1", v.AsInt64())
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sashabaranov/go-openai"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Audio is a recording to transcribe, such as a podcast episode or a meeting.
+type Audio struct {
+	Data []byte
+	Name string // the file name, whose extension gives the format
+}
+
+// audioExtensions are the audio file types the loaders accept, which Whisper
+// can transcribe.
+var audioExtensions = map[string]bool{
+	".mp3": true, ".m4a": true, ".wav": true, ".ogg": true, ".flac": true, ".webm": true,
+}
+
+const (
+	// maxAudioBytes bounds the size of an audio file loaded for transcription.
+	maxAudioBytes = 200 << 20
+	// Metadata fields of a transcript's chunks.
+	startTimeField = "start_time" // seconds into the recording
+	endTimeField   = "end_time"
+	timecodeField  = "timecode" // the chunk's time range, e.g. "12:05-13:40"
+)
+
+// TranscriptSegment is a stretch of speech with its time range in seconds.
+type TranscriptSegment struct {
+	Start, End float64
+	Text       string
+}
+
+// Transcriber turns speech into text segments with timestamps.
+type Transcriber interface {
+	Transcribe(ctx context.Context, audio Audio) ([]TranscriptSegment, error)
+}
+
+// WithTranscriber makes ingestPages ingest audio by transcribing it with
+// transcriber. Transcripts are chunked between segments and each chunk
+// records the time range it covers (see ChunkTranscript), which citations
+// carry as Timecode. Without it, audio is skipped.
+func WithTranscriber(transcriber Transcriber) EngineOption {
+	return func(r *RAGEngine) {
+		r.transcriber = transcriber
+	}
+}
+
+// transcribeAudio replaces every page holding audio with a page of its
+// transcript, leaving other pages as they are. Audio that cannot be
+// transcribed is left out, as is all audio without WithTranscriber.
+func (r *RAGEngine) transcribeAudio(ctx context.Context, pages []Page) []Page {
+	transcribed := make([]Page, 0, len(pages))
+	skipped := 0
+	for _, page := range pages {
+		if page.Audio == nil {
+			transcribed = append(transcribed, page)
+			continue
+		}
+		if r.transcriber == nil {
+			skipped++
+			continue
+		}
+		page, err := r.transcribePage(ctx, page)
+		if err != nil {
+			errorsTotal.WithLabelValues("transcribe").Inc()
+			slog.ErrorContext(ctx, "Transcribing audio failed", "source", page.Source, "error", err)
+			continue
+		}
+		transcribed = append(transcribed, page)
+	}
+	if skipped > 0 {
+		slog.WarnContext(ctx, "Skipping audio, no transcriber is configured", "files", skipped)
+	}
+	return transcribed
+}
+
+// transcribePage turns an audio page into a page of its timestamped
+// transcript.
+func (r *RAGEngine) transcribePage(ctx context.Context, page Page) (Page, error) {
+	ctx, span := tracer.Start(ctx, "rag.transcribe", trace.WithAttributes(
+		attribute.String("rag.source", page.Source),
+		attribute.Int("rag.audio_bytes", len(page.Audio.Data)),
+	))
+	defer span.End()
+
+	segments, err := r.transcriber.Transcribe(ctx, *page.Audio)
+	if err == nil && len(segments) == 0 {
+		err = errors.New("no speech found")
+	}
+	if err != nil {
+		endSpan(span, err)
+		return page, err
+	}
+	span.SetAttributes(attribute.Int("rag.segments", len(segments)))
+	page.Text = transcriptText(segments)
+	page.Format = "transcript"
+	page.Metadata = maps.Clone(page.Metadata)
+	if page.Metadata == nil {
+		page.Metadata = map[string]any{}
+	}
+	page.Metadata["duration"] = int(segments[len(segments)-1].End + 0.5)
+	page.Audio = nil
+	return page, nil
+}
+
+// transcriptText writes segments one per line after their time range, as in
+// "[01:05-01:12] Welcome to the show.", the form ChunkTranscript reads.
+func transcriptText(segments []TranscriptSegment) string {
+	var text strings.Builder
+	for _, segment := range segments {
+		line := strings.Join(strings.Fields(segment.Text), " ")
+		if line == "" {
+			continue
+		}
+		fmt.Fprintf(&text, "[%s-%s] %s\n", formatTimecode(segment.Start), formatTimecode(segment.End), line)
+	}
+	return text.String()
+}
+
+// formatTimecode writes seconds as m:ss, or h:mm:ss from an hour on, with
+// minutes padded to two digits.
+func formatTimecode(seconds float64) string {
+	total := int(seconds + 0.5)
+	if total >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", total/3600, total/60%60, total%60)
+	}
+	return fmt.Sprintf("%02d:%02d", total/60, total%60)
+}
+
+// parseTimecode reads a timecode written by formatTimecode.
+func parseTimecode(timecode string) int {
+	seconds := 0
+	for _, part := range strings.Split(timecode, ":") {
+		n, _ := strconv.Atoi(part)
+		seconds = seconds*60 + n
+	}
+	return seconds
+}
+
+// transcriptLine matches a line of transcriptText.
+var transcriptLine = regexp.MustCompile(`^\[((?:\d+:)?\d{2}:\d{2})-((?:\d+:)?\d{2}:\d{2})\] `)
+
+// ChunkTranscript splits a transcript written as "[m:ss-m:ss] text" lines
+// into chunks of whole lines, with lines adding up to at most overlap
+// characters repeated at the start of the next chunk. Each chunk's metadata
+// records the seconds it starts and ends at as start_time and end_time, and
+// its time range as timecode, e.g. "12:05-13:40". Text without timestamped
+// lines is chunked with ChunkTextWithOffsets.
+func ChunkTranscript(text string, chunkSize, overlap int) []Chunk {
+	type line struct {
+		start, end int
+		from, to   string // the line's time range, if it has one
+	}
+	var lines []line
+	timed := false
+	for offset := 0; offset < len(text); {
+		end := strings.IndexByte(text[offset:], '\n')
+		if end < 0 {
+			end = len(text)
+		} else {
+			end += offset + 1
+		}
+		l := line{start: offset, end: end}
+		if m := transcriptLine.FindStringSubmatch(text[offset:end]); m != nil {
+			l.from, l.to = m[1], m[2]
+			timed = true
+		}
+		if strings.TrimSpace(text[offset:end]) != "" {
+			lines = append(lines, l)
+		}
+		offset = end
+	}
+	if !timed {
+		return ChunkTextWithOffsets(text, chunkSize, overlap)
+	}
+
+	var chunks []Chunk
+	for i := 0; i < len(lines); {
+		j := i + 1
+		for j < len(lines) && utf8.RuneCountInString(text[lines[i].start:lines[j].end]) <= chunkSize {
+			j++
+		}
+		chunk, _ := trimmedChunk(text, lines[i].start, lines[j-1].end)
+		var from, to string
+		for _, l := range lines[i:j] {
+			if l.from != "" {
+				if from == "" {
+					from = l.from
+				}
+				to = l.to
+			}
+		}
+		if from != "" {
+			chunk.Metadata = map[string]any{
+				startTimeField: parseTimecode(from),
+				endTimeField:   parseTimecode(to),
+				timecodeField:  from + "-" + to,
+			}
+		}
+		chunks = append(chunks, chunk)
+		if j == len(lines) {
+			break
+		}
+		next := j
+		for next-1 > i && utf8.RuneCountInString(text[lines[next-1].start:lines[j-1].end]) <= overlap {
+			next--
+		}
+		i = next
+	}
+	return chunks
+}
+
+// LoadAudio loads an audio file as a page to be transcribed (see
+// WithTranscriber), with the file name as its source.
+func LoadAudio(path string) (Page, error) {
+	if !audioExtensions[strings.ToLower(filepath.Ext(path))] {
+		return Page{}, fmt.Errorf("%s: %w", path, ErrUnsupportedFile)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return Page{}, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxAudioBytes+1))
+	if err != nil {
+		return Page{}, err
+	}
+	if len(data) > maxAudioBytes {
+		return Page{}, fmt.Errorf("%s: audio larger than %d MB", path, maxAudioBytes>>20)
+	}
+	name := filepath.Base(path)
+	return Page{Source: name, Audio: &Audio{Data: data, Name: name}}, nil
+}
+
+// OpenAITranscriber transcribes with OpenAI's Whisper API, or any server
+// offering the same API, such as a local faster-whisper server.
+type OpenAITranscriber struct {
+	client *openai.Client
+	model  string
+}
+
+// NewOpenAITranscriber transcribes with model, e.g. "whisper-1".
+func NewOpenAITranscriber(client *openai.Client, model string) *OpenAITranscriber {
+	return &OpenAITranscriber{client: client, model: model}
+}
+
+func (o *OpenAITranscriber) Transcribe(ctx context.Context, audio Audio) ([]TranscriptSegment, error) {
+	resp, err := o.client.CreateTranscription(ctx, openai.AudioRequest{
+		Model:    o.model,
+		FilePath: audio.Name,
+		Reader:   strings.NewReader(string(audio.Data)),
+		Format:   openai.AudioResponseFormatVerboseJSON,
+	})
+	if err != nil {
+		return nil, err
+	}
+	segments := make([]TranscriptSegment, len(resp.Segments))
+	for i, segment := range resp.Segments {
+		segments[i] = TranscriptSegment{Start: segment.Start, End: segment.End, Text: segment.Text}
+	}
+	return segments, nil
+}
+
+// WhisperCommand transcribes locally with the openai-whisper command-line
+// tool, which writes a JSON transcript with segments.
+type WhisperCommand struct {
+	Path  string // the whisper executable, default "whisper"
+	Model string // e.g. "base" or "small", default "base"
+}
+
+func (w WhisperCommand) Transcribe(ctx context.Context, audio Audio) ([]TranscriptSegment, error) {
+	dir, err := os.MkdirTemp("", "rag-whisper-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	name := "audio" + strings.ToLower(filepath.Ext(audio.Name))
+	if err := os.WriteFile(filepath.Join(dir, name), audio.Data, 0o600); err != nil {
+		return nil, err
+	}
+
+	path, model := w.Path, w.Model
+	if path == "" {
+		path = "whisper"
+	}
+	if model == "" {
+		model = "base"
+	}
+	cmd := exec.CommandContext(ctx, path, name, "--model", model, "--output_format", "json", "--output_dir", dir)
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%s: %w: %s", path, err, lastLine(string(output)))
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "audio.json"))
+	if err != nil {
+		return nil, err
+	}
+	var transcript struct {
+		Segments []struct {
+			Start float64 `json:"start"`
+			End   float64 `json:"end"`
+			Text  string  `json:"text"`
+		} `json:"segments"`
+	}
+	if err := json.Unmarshal(data, &transcript); err != nil {
+		return nil, fmt.Errorf("reading the whisper transcript: %w", err)
+	}
+	segments := make([]TranscriptSegment, len(transcript.Segments))
+	for i, segment := range transcript.Segments {
+		segments[i] = TranscriptSegment{Start: segment.Start, End: segment.End, Text: segment.Text}
+	}
+	return segments, nil
+}
+
+// lastLine returns the last non-empty line of output, where command-line
+// tools put their error.
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return lines[len(lines)-1]
+}
+
+// transcriberFromEnv configures audio transcription from TRANSCRIBER:
+// "openai" for the Whisper API at WHISPER_URL (default OpenAI's) with
+// WHISPER_MODEL (default whisper-1), "local" for the whisper command at
+// WHISPER_COMMAND with WHISPER_MODEL (default base), or "off", the default.
+func transcriberFromEnv() (Transcriber, error) {
+	model := os.Getenv("WHISPER_MODEL")
+	switch provider := os.Getenv("TRANSCRIBER"); provider {
+	case "", "off":
+		return nil, nil
+	case "openai":
+		apiKey := os.Getenv("OPENAI_API_KEY")
+		config := openai.DefaultConfig(apiKey)
+		if baseURL := os.Getenv("WHISPER_URL"); baseURL != "" {
+			config.BaseURL = baseURL
+		} else if apiKey == "" {
+			return nil, fmt.Errorf("TRANSCRIBER=openai needs OPENAI_API_KEY or a WHISPER_URL")
+		}
+		client, err := newOpenAIClient("OpenAI", config)
+		if err != nil {
+			return nil, err
+		}
+		if model == "" {
+			model = "whisper-1"
+		}
+		return NewOpenAITranscriber(client, model), nil
+	case "local":
+		return WhisperCommand{Path: os.Getenv("WHISPER_COMMAND"), Model: model}, nil
+	default:
+		return nil, fmt.Errorf("invalid TRANSCRIBER %q (expected off, openai, or local)", provider)
+	}
+}
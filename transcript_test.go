@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+type stubTranscriber struct {
+	segments []TranscriptSegment
+	calls    int
+}
+
+func (s *stubTranscriber) Transcribe(ctx context.Context, audio Audio) ([]TranscriptSegment, error) {
+	s.calls++
+	return s.segments, nil
+}
+
+func TestChunkTranscriptKeepsTimecodes(t *testing.T) {
+	text := transcriptText([]TranscriptSegment{
+		{Start: 0, End: 4.2, Text: " Welcome to the show."},
+		{Start: 4.2, End: 9.8, Text: " Today we talk about   vector databases."},
+		{Start: 3601, End: 3605.4, Text: " Thanks for listening."},
+	})
+	if !strings.HasPrefix(text, "[00:00-00:04] Welcome to the show.\n[00:04-00:10] Today we talk about vector databases.\n[1:00:01-1:00:05]") {
+		t.Fatalf("unexpected transcript %q", text)
+	}
+	chunks := ChunkTranscript(text, 100, 60)
+	if len(chunks) != 2 {
+		t.Fatalf("expected two chunks, got %+v", chunks)
+	}
+	if chunks[0].Metadata[timecodeField] != "00:00-00:10" || chunks[0].Metadata[endTimeField] != 10 {
+		t.Fatalf("unexpected first chunk metadata %v", chunks[0].Metadata)
+	}
+	// The second segment fits the overlap and is repeated.
+	if !strings.HasPrefix(chunks[1].Text, "[00:04-00:10]") || chunks[1].Metadata[startTimeField] != 4 || chunks[1].Metadata[timecodeField] != "00:04-1:00:05" {
+		t.Fatalf("unexpected second chunk %q %v", chunks[1].Text, chunks[1].Metadata)
+	}
+	if got := text[chunks[1].Start:chunks[1].End]; got != chunks[1].Text {
+		t.Fatalf("expected the chunk's offsets, got %q", got)
+	}
+	if plain := ChunkTranscript("No timestamps here.", 80, 0); len(plain) != 1 || plain[0].Metadata != nil {
+		t.Fatalf("expected plain text chunking, got %+v", plain)
+	}
+}
+
+func TestEngineIngestsAudioTranscripts(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "episode-12.mp3")
+	os.WriteFile(path, []byte("ID3"), 0o644)
+	pages, err := LoadFile(path)
+	if err != nil || len(pages) != 1 || pages[0].Audio == nil {
+		t.Fatalf("expected an audio page, got %+v, %v", pages, err)
+	}
+	pages[0].Metadata = map[string]any{"url": "https://podcast.example/12.mp3"}
+
+	transcriber := &stubTranscriber{segments: []TranscriptSegment{
+		{Start: 725, End: 731, Text: "Milvus stores the vectors on object storage."},
+	}}
+	oa := &scriptedOpenAI{reply: "On object storage [1]."}
+	engine := NewRAGEngine(oa, NewMemoryStore(NewHashingEmbedder(256)), WithTranscriber(transcriber))
+	if report, ok := ingestPages(ctx, engine, pages, 1000, 0); !ok || report.Inserted != 1 || transcriber.calls != 1 {
+		t.Fatalf("expected the transcript to be inserted, got %+v", report)
+	}
+	docs := engine.Retrieve(ctx, "Where does Milvus store vectors?", 1)
+	answer, err := engine.GenerateResponse(ctx, "Where does Milvus store vectors?", docs, "gpt-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(answer.Citations) != 1 || answer.Citations[0].Timecode != "12:05-12:11" || answer.Citations[0].URL != "https://podcast.example/12.mp3#t=725" {
+		t.Fatalf("expected a citation with the timecode, got %+v", answer.Citations)
+	}
+
+	plain := NewRAGEngine(oa, NewMemoryStore(NewHashingEmbedder(256)))
+	if report, _ := ingestPages(ctx, plain, pages, 1000, 0); report.Stored() != 0 {
+		t.Fatalf("expected audio to be skipped without a transcriber, got %+v", report)
+	}
+}
+
+func TestOpenAITranscriberReadsSegments(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/audio/transcriptions" || r.FormValue("response_format") != "verbose_json" || r.FormValue("model") != "whisper-1" {
+			t.Errorf("unexpected request %s %v", r.URL.Path, r.Form)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"text":"Hi. Bye.","segments":[{"start":0,"end":1.5,"text":" Hi."},{"start":1.5,"end":2,"text":" Bye."}]}`))
+	}))
+	defer server.Close()
+
+	config := openai.DefaultConfig("test-key")
+	config.BaseURL = server.URL
+	transcriber := NewOpenAITranscriber(openai.NewClientWithConfig(config), "whisper-1")
+	segments, err := transcriber.Transcribe(context.Background(), Audio{Data: []byte("ID3"), Name: "a.mp3"})
+	if err != nil || len(segments) != 2 || segments[1].Start != 1.5 || segments[1].Text != " Bye." {
+		t.Fatalf("unexpected segments %+v, %v", segments, err)
+	}
+}
+
+func TestWhisperCommandReadsItsJSONOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script")
+	}
+	script := filepath.Join(t.TempDir(), "whisper")
+	// Stands in for whisper: writes <name>.json to --output_dir.
+	os.WriteFile(script, []byte(`#!/bin/sh
+[ "$1" = audio.wav ] && [ "$3" = tiny ] || { echo "bad arguments: $*"; exit 2; }
+echo '{"segments":[{"start":3,"end":5,"text":" Local."}]}' > "$7/audio.json"
+`), 0o755)
+
+	segments, err := WhisperCommand{Path: script, Model: "tiny"}.Transcribe(context.Background(), Audio{Data: []byte("RIFF"), Name: "Meeting.WAV"})
+	if err != nil || len(segments) != 1 || segments[0].End != 5 {
+		t.Fatalf("unexpected segments %+v, %v", segments, err)
+	}
+	if _, err := (WhisperCommand{Path: script}).Transcribe(context.Background(), Audio{Data: []byte("RIFF"), Name: "a.wav"}); err == nil || !strings.Contains(err.Error(), "bad arguments") {
+		t.Fatalf("expected the command's error, got %v", err)
+	}
+}
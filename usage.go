@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// modelPrices lists list prices in US dollars per million input and output
+// tokens. Like modelContextWindows, names are matched by prefix, so the more
+// specific entries come first. Models not listed, such as local Ollama
+// models, are counted without a cost.
+var modelPrices = []struct {
+	prefix        string
+	input, output float64
+}{
+	{"gpt-4o-mini", 0.15, 0.60},
+	{"gpt-4o", 2.50, 10.00},
+	{"gpt-4.1-nano", 0.10, 0.40},
+	{"gpt-4.1-mini", 0.40, 1.60},
+	{"gpt-4.1", 2.00, 8.00},
+	{"gpt-4-turbo", 10.00, 30.00},
+	{"gpt-4", 30.00, 60.00},
+	{"gpt-3.5-turbo", 0.50, 1.50},
+	{"o3-mini", 1.10, 4.40},
+	{"o1", 15.00, 60.00},
+	{"claude-3-5-haiku", 0.80, 4.00},
+	{"claude-3-haiku", 0.25, 1.25},
+	{"claude-3-5-sonnet", 3.00, 15.00},
+	{"claude-3-7-sonnet", 3.00, 15.00},
+	{"claude-sonnet-4", 3.00, 15.00},
+	{"claude-opus-4", 15.00, 75.00},
+	{"gemini-2.5-flash-lite", 0.10, 0.40},
+	{"gemini-2.5-flash", 0.30, 2.50},
+	{"gemini-2.5-pro", 1.25, 10.00},
+	{"gemini-2.0-flash-lite", 0.075, 0.30},
+	{"gemini-2.0-flash", 0.10, 0.40},
+	{"gemini-1.5-flash", 0.075, 0.30},
+	{"gemini-1.5-pro", 1.25, 5.00},
+	{"text-embedding-ada-002", 0.10, 0},
+	{"text-embedding-3-small", 0.02, 0},
+	{"text-embedding-3-large", 0.13, 0},
+	{"embed-v4.0", 0.12, 0},
+	{"embed-english-v3.0", 0.10, 0},
+	{"embed-multilingual-v3.0", 0.10, 0},
+	{"voyage-3.5-lite", 0.02, 0},
+	{"voyage-3.5", 0.06, 0},
+	{"voyage-3-large", 0.18, 0},
+	{"voyage-code-3", 0.18, 0},
+	{"gemini-embedding-001", 0.15, 0},
+	{"text-embedding-004", 0, 0},
+}
+
+// estimateCost returns the estimated cost in US dollars of a request to
+// model, and whether the model has a known price.
+func estimateCost(model string, inputTokens, outputTokens int64) (float64, bool) {
+	for _, price := range modelPrices {
+		if strings.HasPrefix(model, price.prefix) {
+			return (float64(inputTokens)*price.input + float64(outputTokens)*price.output) / 1e6, true
+		}
+	}
+	return 0, false
+}
+
+// ModelUsage is the cumulative usage of one model for one kind of request
+// ("chat" or "embedding") on behalf of one tenant.
+type ModelUsage struct {
+	Model        string  `json:"model"`
+	Kind         string  `json:"kind"`
+	Tenant       string  `json:"tenant,omitempty"` // empty for requests of no tenant
+	Requests     int64   `json:"requests"`
+	InputTokens  int64   `json:"input_tokens"`
+	OutputTokens int64   `json:"output_tokens"`
+	CostUSD      float64 `json:"cost_usd"`
+	Priced       bool    `json:"priced"` // false when the model has no known price
+}
+
+// UsageReport is the cumulative usage of every model.
+type UsageReport struct {
+	Since        time.Time    `json:"since"`
+	Models       []ModelUsage `json:"models"`
+	TotalCostUSD float64      `json:"total_cost_usd"`
+}
+
+// RequestUsage collects the tokens and estimated cost of one request, such
+// as an API query, across all of its chat and embedding calls.
+type RequestUsage struct {
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	EmbeddingTokens  int64   `json:"embedding_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+
+	mu sync.Mutex
+}
+
+type requestUsageKey struct{}
+
+// withRequestUsage returns a context in which chat and embedding calls add
+// their usage to usage.
+func withRequestUsage(ctx context.Context, usage *RequestUsage) context.Context {
+	return context.WithValue(ctx, requestUsageKey{}, usage)
+}
+
+// ForTenant returns the usage of tenant's requests only.
+func (r UsageReport) ForTenant(tenant string) UsageReport {
+	report := UsageReport{Since: r.Since, Models: []ModelUsage{}}
+	for _, entry := range r.Models {
+		if entry.Tenant == tenant {
+			report.Models = append(report.Models, entry)
+			report.TotalCostUSD += entry.CostUSD
+		}
+	}
+	return report
+}
+
+type usageTenantKey struct{}
+
+// withUsageTenant returns a context whose chat and embedding calls the
+// ledger records as tenant's.
+func withUsageTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, usageTenantKey{}, tenant)
+}
+
+// UsageLedger accumulates token usage and estimated cost by model and
+// tenant. With a
+// path, Flush adds the usage recorded since the last flush to that JSON file,
+// so `rag usage` can report totals across runs.
+type UsageLedger struct {
+	path string
+	// alertUSD, if positive, is the total cost at which a flush that crosses
+	// it sends a usage.threshold_exceeded webhook.
+	alertUSD float64
+
+	mu      sync.Mutex
+	since   time.Time
+	pending map[string]*ModelUsage // recorded since the last flush
+}
+
+// usageLedger records the usage of the instrumented LLM and embedding
+// clients. main points it at USAGE_FILE.
+var usageLedger = NewUsageLedger("")
+
+// NewUsageLedger creates a ledger persisted to path, or kept in memory only
+// if path is empty.
+func NewUsageLedger(path string) *UsageLedger {
+	return &UsageLedger{path: path, since: time.Now().UTC(), pending: make(map[string]*ModelUsage)}
+}
+
+// trackUsage records one chat or embedding call in the ledger and in the
+// request usage of ctx, if any.
+func trackUsage(ctx context.Context, kind, model string, inputTokens, outputTokens int) {
+	cost, priced := estimateCost(model, int64(inputTokens), int64(outputTokens))
+	tenant, _ := ctx.Value(usageTenantKey{}).(string)
+	usageLedger.record(ModelUsage{
+		Model:        model,
+		Kind:         kind,
+		Tenant:       tenant,
+		Requests:     1,
+		InputTokens:  int64(inputTokens),
+		OutputTokens: int64(outputTokens),
+		CostUSD:      cost,
+		Priced:       priced,
+	})
+	if usage, ok := ctx.Value(requestUsageKey{}).(*RequestUsage); ok {
+		usage.mu.Lock()
+		defer usage.mu.Unlock()
+		if kind == "embedding" {
+			usage.EmbeddingTokens += int64(inputTokens)
+		} else {
+			usage.PromptTokens += int64(inputTokens)
+			usage.CompletionTokens += int64(outputTokens)
+		}
+		usage.CostUSD += cost
+	}
+}
+
+func (l *UsageLedger) record(usage ModelUsage) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	addUsage(l.pending, usage)
+}
+
+func addUsage(into map[string]*ModelUsage, usage ModelUsage) {
+	key := usage.Kind + "\x00" + usage.Model + "\x00" + usage.Tenant
+	entry, ok := into[key]
+	if !ok {
+		entry = &ModelUsage{Model: usage.Model, Kind: usage.Kind, Tenant: usage.Tenant, Priced: usage.Priced}
+		into[key] = entry
+	}
+	entry.Requests += usage.Requests
+	entry.InputTokens += usage.InputTokens
+	entry.OutputTokens += usage.OutputTokens
+	entry.CostUSD += usage.CostUSD
+}
+
+// Report returns the cumulative usage, including earlier runs recorded in
+// the ledger's file, with the most expensive models first.
+func (l *UsageLedger) Report() (UsageReport, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	report, err := l.merged()
+	if err != nil {
+		return UsageReport{}, err
+	}
+	sort.SliceStable(report.Models, func(i, j int) bool {
+		return report.Models[i].CostUSD > report.Models[j].CostUSD
+	})
+	return report, nil
+}
+
+// merged adds the pending usage to the totals in the ledger's file.
+func (l *UsageLedger) merged() (UsageReport, error) {
+	var stored UsageReport
+	if l.path != "" {
+		var err error
+		if stored, err = readUsageFile(l.path); err != nil {
+			return UsageReport{}, err
+		}
+	}
+	totals := make(map[string]*ModelUsage)
+	for _, entry := range stored.Models {
+		addUsage(totals, entry)
+	}
+	for _, entry := range l.pending {
+		addUsage(totals, *entry)
+	}
+
+	report := UsageReport{Since: stored.Since, Models: []ModelUsage{}}
+	if report.Since.IsZero() {
+		report.Since = l.since
+	}
+	for _, entry := range totals {
+		report.Models = append(report.Models, *entry)
+		report.TotalCostUSD += entry.CostUSD
+	}
+	sort.Slice(report.Models, func(i, j int) bool {
+		a, b := report.Models[i], report.Models[j]
+		return a.Kind+"\x00"+a.Model+"\x00"+a.Tenant < b.Kind+"\x00"+b.Model+"\x00"+b.Tenant
+	})
+	return report, nil
+}
+
+// Flush adds the usage recorded since the last flush to the ledger's file.
+// The file is re-read first, so runs that flush one after another add up
+// rather than overwrite each other.
+func (l *UsageLedger) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.path == "" || len(l.pending) == 0 {
+		return nil
+	}
+	report, err := l.merged()
+	if err != nil {
+		return err
+	}
+	pendingCost := 0.0
+	for _, entry := range l.pending {
+		pendingCost += entry.CostUSD
+	}
+	if previous := report.TotalCostUSD - pendingCost; l.alertUSD > 0 && previous < l.alertUSD && report.TotalCostUSD >= l.alertUSD {
+		slog.Warn("Usage cost exceeded the alert threshold", "total_cost_usd", report.TotalCostUSD, "threshold_usd", l.alertUSD)
+		webhooks.Notify(EventUsageThresholdExceeded, "", usageThresholdEvent{ThresholdUSD: l.alertUSD, Report: report})
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		return err
+	}
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		return err
+	}
+	l.pending = make(map[string]*ModelUsage)
+	return nil
+}
+
+// usageThresholdEvent is the data of a usage.threshold_exceeded webhook.
+type usageThresholdEvent struct {
+	ThresholdUSD float64     `json:"threshold_usd"`
+	Report       UsageReport `json:"usage"`
+}
+
+// flushEvery flushes the ledger at the given interval until ctx is done, for
+// long-running commands such as `rag serve`.
+func (l *UsageLedger) flushEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := l.Flush(); err != nil {
+				slog.Warn("Saving token usage failed", "error", err)
+			}
+		}
+	}
+}
+
+func readUsageFile(path string) (UsageReport, error) {
+	var report UsageReport
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return report, nil
+	}
+	if err != nil {
+		return report, err
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		return report, fmt.Errorf("reading usage file %s: %w", path, err)
+	}
+	return report, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// swapUsageLedger points usage tracking at ledger for the rest of the test.
+func swapUsageLedger(t *testing.T, ledger *UsageLedger) {
+	t.Helper()
+	previous := usageLedger
+	usageLedger = ledger
+	t.Cleanup(func() { usageLedger = previous })
+}
+
+func approx(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestEstimateCost(t *testing.T) {
+	cost, priced := estimateCost("gpt-4o-mini-2024-07-18", 1_000_000, 500_000)
+	if !priced || !approx(cost, 0.15+0.30) {
+		t.Fatalf("expected gpt-4o-mini pricing, got %v (priced %t)", cost, priced)
+	}
+	if cost, _ := estimateCost("gpt-4o", 1_000_000, 0); !approx(cost, 2.50) {
+		t.Fatalf("expected gpt-4o pricing, got %v", cost)
+	}
+	if cost, priced := estimateCost("llama3.2", 1000, 1000); priced || cost != 0 {
+		t.Fatalf("expected local models to be unpriced, got %v (priced %t)", cost, priced)
+	}
+}
+
+func TestUsageLedgerAccumulatesAcrossRuns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	for range 2 {
+		ledger := NewUsageLedger(path)
+		swapUsageLedger(t, ledger)
+		trackUsage(context.Background(), "chat", "gpt-4o", 1000, 100)
+		trackUsage(context.Background(), "embedding", "text-embedding-3-small", 500, 0)
+		if err := ledger.Flush(); err != nil {
+			t.Fatalf("Flush returned error: %v", err)
+		}
+	}
+
+	ledger := NewUsageLedger(path)
+	swapUsageLedger(t, ledger)
+	trackUsage(context.Background(), "chat", "gpt-4o", 1000, 100) // not flushed yet
+	report, err := ledger.Report()
+	if err != nil {
+		t.Fatalf("Report returned error: %v", err)
+	}
+	if len(report.Models) != 2 {
+		t.Fatalf("expected 2 models, got %+v", report.Models)
+	}
+	chat := report.Models[0]
+	if chat.Model != "gpt-4o" || chat.Requests != 3 || chat.InputTokens != 3000 || chat.OutputTokens != 300 {
+		t.Fatalf("unexpected chat usage %+v", chat)
+	}
+	wantTotal := 3*(1000*2.50+100*10.00)/1e6 + 2*500*0.02/1e6
+	if !approx(report.TotalCostUSD, wantTotal) {
+		t.Fatalf("expected a total of %v, got %v", wantTotal, report.TotalCostUSD)
+	}
+}
+
+func TestServerReportsQueryUsage(t *testing.T) {
+	swapUsageLedger(t, NewUsageLedger(""))
+	store := NewMemoryStore(instrumentEmbedder(NewHashingEmbedder(64), "hashing"))
+	engine := NewRAGEngine(instrumentLLM(&usageOpenAI{}), store)
+	server := NewServer(engine, "gpt-4o")
+	store.InsertDocuments(context.Background(), []string{"Go is a language"}, []string{"go.md"}, nil)
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"question":"what is go"}`)))
+	var resp queryResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if resp.Usage == nil || resp.Usage.PromptTokens != 12 || resp.Usage.CompletionTokens != 5 {
+		t.Fatalf("unexpected query usage %+v", resp.Usage)
+	}
+	if !approx(resp.Usage.CostUSD, (12*2.50+5*10.00)/1e6) {
+		t.Fatalf("unexpected query cost %v", resp.Usage.CostUSD)
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/usage", nil))
+	var report UsageReport
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatalf("decoding usage: %v", err)
+	}
+	if len(report.Models) != 1 || report.Models[0].Model != "gpt-4o" || report.Models[0].Requests != 1 {
+		t.Fatalf("unexpected usage report %+v", report)
+	}
+}
+
+func TestServerReportsTenantUsage(t *testing.T) {
+	swapUsageLedger(t, NewUsageLedger(""))
+	store := NewMemoryStore(NewHashingEmbedder(64))
+	server := NewServer(NewRAGEngine(instrumentLLM(&usageOpenAI{}), store), "gpt-4o")
+	if err := server.RequireAPIKeys([]APIKey{{Key: "acme-key", Tenant: "acme"}, {Key: "globex-key", Tenant: "globex"}}); err != nil {
+		t.Fatal(err)
+	}
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec
+	}
+
+	do(http.MethodPost, "/documents", "acme-key", `{"documents":[{"text":"Go is a language","source":"go.md"}]}`)
+	do(http.MethodPost, "/query", "acme-key", `{"question":"what is go"}`)
+	for key, requests := range map[string]int64{"acme-key": 1, "globex-key": 0} {
+		var report UsageReport
+		json.NewDecoder(do(http.MethodGet, "/usage", key, "").Body).Decode(&report)
+		var total int64
+		for _, m := range report.Models {
+			total += m.Requests
+		}
+		if total != requests {
+			t.Errorf("%s: expected %d requests in its usage, got %+v", key, requests, report)
+		}
+	}
+	if report, _ := usageLedger.Report(); len(report.Models) != 1 || report.Models[0].Tenant != "acme" {
+		t.Fatalf("expected the ledger to record acme's usage, got %+v", report)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// VerificationMode is what happens to claims of an answer that the
+// retrieved context does not support.
+type VerificationMode string
+
+const (
+	VerifyOff VerificationMode = "off"
+	// VerifyReport only reports the verdicts in Answer.Verification.
+	VerifyReport VerificationMode = "report"
+	// VerifyAnnotate also marks unsupported claims in the answer text.
+	VerifyAnnotate VerificationMode = "annotate"
+	// VerifyRegenerate asks the model once to rewrite an answer with
+	// unsupported claims, checks the new answer, and annotates what is
+	// still unsupported.
+	VerifyRegenerate VerificationMode = "regenerate"
+)
+
+// unsupportedMark is appended to unsupported claims by VerifyAnnotate.
+const unsupportedMark = " [unsupported]"
+
+// ParseVerificationMode parses a VERIFY_ANSWERS value; empty means off.
+func ParseVerificationMode(value string) (VerificationMode, error) {
+	switch mode := VerificationMode(strings.ToLower(strings.TrimSpace(value))); mode {
+	case "":
+		return VerifyOff, nil
+	case VerifyOff, VerifyReport, VerifyAnnotate, VerifyRegenerate:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown verification mode %q (expected off, report, annotate, or regenerate)", value)
+}
+
+// WithVerification checks every generated answer against its context
+// after generation: model, ideally a capable one, judges for each sentence
+// of the answer whether the context entails it, and mode decides what
+// happens to the unsupported ones. The verdicts are returned in
+// Answer.Verification. Answers are not streamed live in the annotate and
+// regenerate modes, since their text may change. If the check fails, the
+// answer is returned unchecked.
+func WithVerification(mode VerificationMode, model string) EngineOption {
+	return func(r *RAGEngine) {
+		if mode == VerifyOff {
+			mode = ""
+		}
+		r.verifyMode = mode
+		r.verifyModel = model
+	}
+}
+
+// VerificationReport lists the claims of an answer with their verdicts.
+type VerificationReport struct {
+	Claims      []ClaimCheck
+	Unsupported int  // claims the context does not support
+	Regenerated bool // the answer was rewritten after the first check
+}
+
+// ClaimCheck is the verdict on one claim, a sentence of the answer.
+type ClaimCheck struct {
+	Text      string
+	Markers   []int // the citation markers the claim carries
+	Supported bool
+}
+
+// claim is a sentence of an answer, with its byte offsets.
+type claim struct {
+	text       string
+	start, end int
+}
+
+// splitClaims splits text, such as an answer, into its sentences, leaving
+// out those without a letter, such as list bullets.
+func splitClaims(text string) []claim {
+	runes := []rune(text)
+	var claims []claim
+	start, offset, startByte := 0, 0, 0
+	add := func(end int) {
+		sentence := string(runes[start:end])
+		trimmed := strings.TrimSpace(sentence)
+		if strings.IndexFunc(trimmed, unicode.IsLetter) >= 0 {
+			lead := strings.Index(sentence, trimmed)
+			claims = append(claims, claim{text: trimmed, start: startByte + lead, end: startByte + lead + len(trimmed)})
+		}
+	}
+	for p := range runes {
+		if p > start && sentenceBoundary(runes, p) {
+			add(p)
+			start, startByte = p, offset
+		}
+		offset += len(string(runes[p]))
+	}
+	add(len(runes))
+	return claims
+}
+
+// verdictPattern matches a line of the verifier's reply, e.g. "2: unsupported".
+var verdictPattern = regexp.MustCompile(`(?im)^\s*(\d+)\s*[:.)-]\s*(supported|unsupported)\b`)
+
+// checkClaims asks the verification model which claims the context
+// supports.
+func (r *RAGEngine) checkClaims(ctx context.Context, query string, claims []claim, docs []Document) ([]ClaimCheck, error) {
+	var list strings.Builder
+	for i, c := range claims {
+		fmt.Fprintf(&list, "%d. %s\n", i+1, c.text)
+	}
+	messages := []Message{
+		{Role: "system", Content: "You check whether statements are entailed by source documents."},
+		{Role: "user", Content: "For each numbered statement below, decide whether the context entails it. " +
+			"A statement is supported only if the context states it or it follows directly from the context; " +
+			"statements that add facts, numbers, or names the context lacks, or contradict it, are unsupported. " +
+			"Statements that only say the context lacks the answer are supported.\n" +
+			"Reply with one line per statement in the form \"<number>: supported\" or \"<number>: unsupported\" and nothing else.\n\n" +
+			"Context:\n" + formatContext(docs) + "\n\nQuestion: " + query + "\n\nStatements:\n" + list.String()},
+	}
+	reply, err := r.llm.ChatCompletion(ctx, r.verifyModel, messages)
+	if err != nil {
+		return nil, err
+	}
+	checks := make([]ClaimCheck, len(claims))
+	seen := 0
+	for _, match := range verdictPattern.FindAllStringSubmatch(reply, -1) {
+		n, _ := strconv.Atoi(match[1])
+		if n < 1 || n > len(claims) || checks[n-1].Text != "" {
+			continue
+		}
+		checks[n-1] = ClaimCheck{Text: claims[n-1].text, Markers: citationMarkers(claims[n-1].text), Supported: strings.EqualFold(match[2], "supported")}
+		seen++
+	}
+	if seen != len(claims) {
+		return nil, fmt.Errorf("verdicts for %d of %d claims in %q", seen, len(claims), truncateText(reply, 200))
+	}
+	return checks, nil
+}
+
+// citationMarkers returns the citation markers in text, in order.
+func citationMarkers(text string) []int {
+	var markers []int
+	for _, match := range citationPattern.FindAllStringSubmatch(text, -1) {
+		for _, part := range strings.Split(match[1], ",") {
+			if n, err := strconv.Atoi(strings.TrimSpace(part)); err == nil {
+				markers = append(markers, n)
+			}
+		}
+	}
+	return markers
+}
+
+// verifyAnswer checks response, generated from messages, against docs and
+// handles its unsupported claims according to the verification mode. It
+// returns the answer text to use and the report, which is nil if the check
+// failed.
+func (r *RAGEngine) verifyAnswer(ctx context.Context, query, model string, messages []Message, response string, docs []Document) (string, *VerificationReport) {
+	ctx, span := tracer.Start(ctx, "rag.verify")
+	defer span.End()
+
+	claims := splitClaims(response)
+	if len(claims) == 0 {
+		return response, &VerificationReport{}
+	}
+	checks, err := r.checkClaims(ctx, query, claims, docs)
+	if err != nil {
+		errorsTotal.WithLabelValues("verify").Inc()
+		slog.WarnContext(ctx, "Verifying the answer failed, returning it unchecked", "error", err)
+		return response, nil
+	}
+	report := newVerificationReport(checks)
+	if report.Unsupported > 0 && r.verifyMode == VerifyRegenerate {
+		var unsupported strings.Builder
+		for _, check := range checks {
+			if !check.Supported {
+				unsupported.WriteString("- " + check.Text + "\n")
+			}
+		}
+		retry := append(messages[:len(messages):len(messages)],
+			Message{Role: "assistant", Content: response},
+			Message{Role: "user", Content: "These statements of your answer are not supported by the context:\n" + unsupported.String() +
+				"\nRewrite the answer using only what the context supports, with citations. " +
+				"Leave out what the context does not cover, or say that it does not."},
+		)
+		rewritten, err := r.llm.ChatCompletion(ctx, model, retry)
+		if err != nil {
+			slog.WarnContext(ctx, "Regenerating the answer failed, annotating it instead", "error", err)
+		} else if rewrittenClaims := splitClaims(rewritten); len(rewrittenClaims) > 0 {
+			if rewrittenChecks, err := r.checkClaims(ctx, query, rewrittenClaims, docs); err != nil {
+				slog.WarnContext(ctx, "Verifying the regenerated answer failed, annotating the first one", "error", err)
+			} else {
+				slog.InfoContext(ctx, "Regenerated the answer", "unsupported_before", report.Unsupported)
+				response, claims, checks = rewritten, rewrittenClaims, rewrittenChecks
+				report = newVerificationReport(checks)
+				report.Regenerated = true
+			}
+		}
+	}
+	for _, check := range checks {
+		verifiedClaims.WithLabelValues(strconv.FormatBool(check.Supported)).Inc()
+	}
+	span.SetAttributes(attribute.Int("rag.claims", len(checks)), attribute.Int("rag.unsupported_claims", report.Unsupported))
+	slog.InfoContext(ctx, "Verified the answer", "claims", len(checks), "unsupported", report.Unsupported, "regenerated", report.Regenerated)
+	if r.verifyMode != VerifyReport && report.Unsupported > 0 {
+		response = annotateClaims(response, claims, checks)
+	}
+	return response, report
+}
+
+func newVerificationReport(checks []ClaimCheck) *VerificationReport {
+	report := &VerificationReport{Claims: checks}
+	for _, check := range checks {
+		if !check.Supported {
+			report.Unsupported++
+		}
+	}
+	return report
+}
+
+// annotateClaims adds unsupportedMark to the unsupported claims of text,
+// before their closing punctuation.
+func annotateClaims(text string, claims []claim, checks []ClaimCheck) string {
+	var b strings.Builder
+	last := 0
+	for i, c := range claims {
+		if checks[i].Supported {
+			continue
+		}
+		end := c.end
+		for end > c.start {
+			r, size := utf8.DecodeLastRuneInString(text[c.start:end])
+			if !strings.ContainsRune(".!?…。！？", r) {
+				break
+			}
+			end -= size
+		}
+		b.WriteString(text[last:end])
+		b.WriteString(unsupportedMark)
+		last = end
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
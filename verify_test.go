@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var verifyDocs = []Document{{Text: "Invoices are stored in Postgres. They are kept for ten years.", Source: "billing.md", Similarity: 0.9}}
+
+func TestSplitClaims(t *testing.T) {
+	text := "Invoices live in Postgres [1]. They are kept for 10 yrs., e.g. for audits!\n- \n  Backups run nightly [1][2]."
+	var got []string
+	for _, c := range splitClaims(text) {
+		if text[c.start:c.end] != c.text {
+			t.Errorf("offsets %d-%d do not match %q", c.start, c.end, c.text)
+		}
+		got = append(got, c.text)
+	}
+	want := []string{"Invoices live in Postgres [1].", "They are kept for 10 yrs., e.g. for audits!", "Backups run nightly [1][2]."}
+	if !slices.Equal(got, want) {
+		t.Fatalf("splitClaims = %q, want %q", got, want)
+	}
+}
+
+func TestVerificationAnnotatesUnsupportedClaims(t *testing.T) {
+	oa := &sequenceOpenAI{replies: []string{
+		"Invoices are stored in Postgres [1]. They are encrypted with AES [1].",
+		"1: supported\n2: unsupported",
+	}}
+	engine := NewRAGEngine(oa, NewMemoryStore(NewHashingEmbedder(256)), WithVerification(VerifyAnnotate, "gpt-judge"))
+	answer, err := engine.GenerateResponse(context.Background(), "Where are invoices stored?", verifyDocs, "gpt-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer.Text != "Invoices are stored in Postgres [1]. They are encrypted with AES [1] [unsupported]." {
+		t.Fatalf("unexpected annotated answer %q", answer.Text)
+	}
+	v := answer.Verification
+	if v == nil || v.Unsupported != 1 || len(v.Claims) != 2 || !v.Claims[0].Supported || !slices.Equal(v.Claims[1].Markers, []int{1}) {
+		t.Fatalf("unexpected report %+v", v)
+	}
+	if !strings.Contains(oa.calls[1][1].Content, "2. They are encrypted with AES [1].") {
+		t.Fatalf("expected the numbered claims in the check, got %q", oa.calls[1][1].Content)
+	}
+}
+
+func TestVerificationRegeneratesUnsupportedAnswers(t *testing.T) {
+	oa := &sequenceOpenAI{replies: []string{
+		"Invoices are stored in MySQL [1].",
+		"1: unsupported",
+		"Invoices are stored in Postgres [1].",
+		"1: supported",
+	}}
+	engine := NewRAGEngine(oa, NewMemoryStore(NewHashingEmbedder(256)), WithVerification(VerifyRegenerate, "gpt-judge"))
+	answer, err := engine.GenerateResponse(context.Background(), "Where are invoices stored?", verifyDocs, "gpt-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer.Text != "Invoices are stored in Postgres [1]." || !answer.Verification.Regenerated || answer.Verification.Unsupported != 0 {
+		t.Fatalf("expected the regenerated answer, got %q %+v", answer.Text, answer.Verification)
+	}
+	retry := oa.calls[2]
+	if retry[len(retry)-2].Content != "Invoices are stored in MySQL [1]." || !strings.Contains(retry[len(retry)-1].Content, "- Invoices are stored in MySQL [1].") {
+		t.Fatalf("expected the unsupported claims in the retry, got %+v", retry)
+	}
+}
+
+func TestVerificationFailureReturnsTheAnswerUnchecked(t *testing.T) {
+	oa := &sequenceOpenAI{replies: []string{"Invoices are stored in Postgres [1].", "Looks fine to me."}}
+	engine := NewRAGEngine(oa, NewMemoryStore(NewHashingEmbedder(256)), WithVerification(VerifyAnnotate, "gpt-judge"))
+	answer, err := engine.GenerateResponse(context.Background(), "Where are invoices stored?", verifyDocs, "gpt-test")
+	if err != nil || answer.Text != "Invoices are stored in Postgres [1]." || answer.Verification != nil {
+		t.Fatalf("expected the unchecked answer, got %+v, %v", answer, err)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Versioned collections let a rebuilt knowledge base be validated before it
+// serves queries. COLLECTION_NAME is then a Milvus alias, such as
+// rag_documents, pointing at one of the versions rag_documents_v1,
+// rag_documents_v2, and so on. Queries and ingestion through the alias reach
+// the live version; a new version is ingested under its own name and
+// promoted by repointing the alias, which Milvus does atomically.
+
+// CollectionVersion is one version of a versioned collection.
+type CollectionVersion struct {
+	Number   int
+	Name     string
+	Live     bool // the alias points at it
+	RowCount int64
+}
+
+// versionName returns the collection name of version n of alias.
+func versionName(alias string, n int) string {
+	return alias + "_v" + strconv.Itoa(n)
+}
+
+// parseVersion returns the version number of name if it is a version of
+// alias.
+func parseVersion(alias, name string) (int, bool) {
+	suffix, ok := strings.CutPrefix(name, alias+"_v")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(suffix)
+	return n, err == nil && n > 0 && strconv.Itoa(n) == suffix
+}
+
+// liveCollection returns the collection the store's collection name refers
+// to, and whether that name is an alias. It returns "" if neither a
+// collection nor an alias of that name exists.
+func (m *MilvusClientImpl) liveCollection(ctx context.Context) (string, bool, error) {
+	exists, err := m.client.HasCollection(ctx, m.collectionName)
+	if err != nil {
+		return "", false, fmt.Errorf("checking collection %s: %w", m.collectionName, err)
+	}
+	if !exists {
+		return "", false, nil
+	}
+	coll, err := m.client.DescribeCollection(ctx, m.collectionName)
+	if err != nil {
+		return "", false, fmt.Errorf("describing collection %s: %w", m.collectionName, err)
+	}
+	return coll.Name, coll.Name != m.collectionName, nil
+}
+
+// ListVersions returns the versions of the store's collection, oldest first.
+func (m *MilvusClientImpl) ListVersions(ctx context.Context) ([]CollectionVersion, error) {
+	names, err := m.ListCollections()
+	if err != nil {
+		return nil, err
+	}
+	live, _, err := m.liveCollection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var versions []CollectionVersion
+	for _, name := range names {
+		n, ok := parseVersion(m.collectionName, name)
+		if !ok {
+			continue
+		}
+		count, err := m.CountDocuments(name)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, CollectionVersion{Number: n, Name: name, Live: name == live, RowCount: count})
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Number < versions[j].Number })
+	return versions, nil
+}
+
+// NextVersion returns the name of the version after the newest one, for a
+// rebuild to be ingested into.
+func (m *MilvusClientImpl) NextVersion(ctx context.Context) (string, error) {
+	versions, err := m.ListVersions(ctx)
+	if err != nil {
+		return "", err
+	}
+	next := 1
+	if len(versions) > 0 {
+		next = versions[len(versions)-1].Number + 1
+	}
+	return versionName(m.collectionName, next), nil
+}
+
+// InitVersions turns the store's collection into version 1 of a versioned
+// collection of the same name: it renames the collection and creates the
+// alias in its place. Queries fail in the moment between the two.
+func (m *MilvusClientImpl) InitVersions(ctx context.Context) (string, error) {
+	live, aliased, err := m.liveCollection(ctx)
+	if err != nil {
+		return "", err
+	}
+	if aliased {
+		return "", fmt.Errorf("%s is already versioned; its live version is %s", m.collectionName, live)
+	}
+	first := versionName(m.collectionName, 1)
+	if live != "" {
+		if err := m.client.RenameCollection(ctx, m.collectionName, first); err != nil {
+			return "", fmt.Errorf("renaming %s to %s: %w", m.collectionName, first, err)
+		}
+		if err := m.client.CreateAlias(ctx, first, m.collectionName); err != nil {
+			return "", fmt.Errorf("creating alias %s for %s: %w", m.collectionName, first, err)
+		}
+	}
+	return first, nil
+}
+
+// PromoteVersion points the store's alias at the version named name. The
+// switch is atomic: every query sees either the old or the new version.
+func (m *MilvusClientImpl) PromoteVersion(ctx context.Context, name string) error {
+	if _, ok := parseVersion(m.collectionName, name); !ok {
+		return fmt.Errorf("%s is not a version of %s", name, m.collectionName)
+	}
+	exists, err := m.client.HasCollection(ctx, name)
+	if err != nil {
+		return fmt.Errorf("checking collection %s: %w", name, err)
+	}
+	if !exists {
+		return fmt.Errorf("version %s does not exist; ingest into it first", name)
+	}
+	live, aliased, err := m.liveCollection(ctx)
+	if err != nil {
+		return err
+	}
+	switch {
+	case live == "":
+		err = m.client.CreateAlias(ctx, name, m.collectionName)
+	case !aliased:
+		return fmt.Errorf("%s is a collection, not an alias; run `rag versions init` first", m.collectionName)
+	case live == name:
+		return nil
+	default:
+		err = m.client.AlterAlias(ctx, name, m.collectionName)
+	}
+	if err != nil {
+		return fmt.Errorf("pointing %s at %s: %w", m.collectionName, name, err)
+	}
+	slog.InfoContext(ctx, "Promoted collection version", "alias", m.collectionName, "version", name, "previous", live)
+	return nil
+}
+
+// RollbackVersion points the store's alias back at the newest version older
+// than the live one, and returns its name.
+func (m *MilvusClientImpl) RollbackVersion(ctx context.Context) (string, error) {
+	versions, err := m.ListVersions(ctx)
+	if err != nil {
+		return "", err
+	}
+	previous := ""
+	for _, version := range versions {
+		if version.Live {
+			if previous == "" {
+				return "", fmt.Errorf("version %s is the oldest; there is nothing to roll back to", version.Name)
+			}
+			return previous, m.PromoteVersion(ctx, previous)
+		}
+		previous = version.Name
+	}
+	return "", fmt.Errorf("%s does not point at any of its versions", m.collectionName)
+}
+
+// WithStore returns a copy of the engine that reads from and writes to
+// store, for comparing a candidate collection version against the live one.
+func (r *RAGEngine) WithStore(store VectorStore) *RAGEngine {
+	scoped := *r
+	scoped.store = store
+	return &scoped
+}
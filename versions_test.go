@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/milvus-io/milvus-sdk-go/v2/entity"
+)
+
+// aliasSDK adds Milvus aliases to fakeMilvusSDK: an alias resolves to the
+// collection it points at.
+type aliasSDK struct {
+	*fakeMilvusSDK
+	aliases map[string]string
+}
+
+func (a *aliasSDK) HasCollection(ctx context.Context, name string) (bool, error) {
+	if _, ok := a.aliases[name]; ok {
+		return true, nil
+	}
+	return a.fakeMilvusSDK.HasCollection(ctx, name)
+}
+
+func (a *aliasSDK) DescribeCollection(ctx context.Context, name string) (*entity.Collection, error) {
+	if target, ok := a.aliases[name]; ok {
+		name = target
+	}
+	return a.fakeMilvusSDK.DescribeCollection(ctx, name)
+}
+
+func (a *aliasSDK) RenameCollection(ctx context.Context, name, newName string) error {
+	for _, coll := range a.collections {
+		if coll.Name == name {
+			coll.Name = newName
+		}
+	}
+	return nil
+}
+
+func (a *aliasSDK) CreateAlias(ctx context.Context, name, alias string) error {
+	a.aliases[alias] = name
+	return nil
+}
+
+func (a *aliasSDK) AlterAlias(ctx context.Context, name, alias string) error {
+	a.aliases[alias] = name
+	return nil
+}
+
+func TestParseVersion(t *testing.T) {
+	cases := map[string]int{"docs_v1": 1, "docs_v12": 12, "docs_v0": 0, "docs_v01": 0, "docs_vx": 0, "docs": 0, "other_v1": 0}
+	for name, want := range cases {
+		n, ok := parseVersion("docs", name)
+		if ok != (want > 0) || ok && n != want {
+			t.Errorf("parseVersion(%q) = %d, %t", name, n, ok)
+		}
+	}
+}
+
+func TestCollectionVersionLifecycle(t *testing.T) {
+	ctx := context.Background()
+	sdk := &aliasSDK{
+		fakeMilvusSDK: &fakeMilvusSDK{collections: []*entity.Collection{{Name: "docs"}, {Name: "other"}}, stats: map[string]string{"row_count": "7"}},
+		aliases:       map[string]string{},
+	}
+	store := &MilvusClientImpl{client: sdk, collectionName: "docs"}
+
+	if err := store.PromoteVersion(ctx, "docs_v1"); err == nil {
+		t.Fatal("expected promotion onto an unversioned collection to fail")
+	}
+	first, err := store.InitVersions(ctx)
+	if err != nil || first != "docs_v1" || sdk.aliases["docs"] != "docs_v1" {
+		t.Fatalf("InitVersions = %s, %v; aliases %v", first, err, sdk.aliases)
+	}
+	if _, err := store.InitVersions(ctx); err == nil {
+		t.Fatal("expected a second init to fail")
+	}
+
+	next, err := store.NextVersion(ctx)
+	if err != nil || next != "docs_v2" {
+		t.Fatalf("NextVersion = %s, %v", next, err)
+	}
+	if err := store.PromoteVersion(ctx, next); err == nil {
+		t.Fatal("expected promoting a version that was never ingested to fail")
+	}
+	sdk.collections = append(sdk.collections, &entity.Collection{Name: next})
+	if err := store.PromoteVersion(ctx, next); err != nil || sdk.aliases["docs"] != "docs_v2" {
+		t.Fatalf("PromoteVersion returned %v; aliases %v", err, sdk.aliases)
+	}
+	if err := store.PromoteVersion(ctx, "other"); err == nil {
+		t.Fatal("expected a collection that is not a version to be rejected")
+	}
+
+	versions, err := store.ListVersions(ctx)
+	if err != nil {
+		t.Fatalf("ListVersions returned error: %v", err)
+	}
+	var live []string
+	for _, version := range versions {
+		if version.Live {
+			live = append(live, version.Name)
+		}
+	}
+	if len(versions) != 2 || versions[0].Number != 1 || versions[1].RowCount != 7 || !slices.Equal(live, []string{"docs_v2"}) {
+		t.Fatalf("unexpected versions %+v", versions)
+	}
+
+	previous, err := store.RollbackVersion(ctx)
+	if err != nil || previous != "docs_v1" || sdk.aliases["docs"] != "docs_v1" {
+		t.Fatalf("RollbackVersion = %s, %v; aliases %v", previous, err, sdk.aliases)
+	}
+	if _, err := store.RollbackVersion(ctx); err == nil {
+		t.Fatal("expected rolling back past the oldest version to fail")
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Image is an image to describe, such as a diagram or a figure of a PDF.
+type Image struct {
+	Data     []byte
+	MIMEType string // image/png, image/jpeg, image/gif, or image/webp
+}
+
+// imageTypes maps the image file extensions the loaders accept to their MIME
+// types, which every vision API supports.
+var imageTypes = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+}
+
+// imageExtension returns the file extension for an image MIME type, or "".
+func imageExtension(mimeType string) string {
+	switch mimeType {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png", "image/gif", "image/webp":
+		return "." + strings.TrimPrefix(mimeType, "image/")
+	}
+	return ""
+}
+
+const (
+	// maxImageBytes bounds the size of an image sent to a vision model.
+	maxImageBytes = 20 << 20
+	// Metadata fields of an image's chunks.
+	imageURLField = "image_url" // where the image can be fetched
+	imageIDField  = "image_id"  // the image's ID in the ImageStore
+)
+
+// VisionClient is implemented by LLM clients that can describe images.
+type VisionClient interface {
+	DescribeImage(ctx context.Context, model string, image Image, prompt string) (string, error)
+}
+
+// WithVision makes ingestPages ingest images, and PDF figures, by storing
+// them in images and indexing a description of each written by model. The
+// chunks of an image link to it in their image_url metadata, which citations
+// carry as ImageURL. Without it, images are skipped.
+func WithVision(client VisionClient, model string, images ImageStore) EngineOption {
+	return func(r *RAGEngine) {
+		r.vision = client
+		r.visionModel = model
+		r.images = images
+	}
+}
+
+// describeImages replaces every page holding an image with a page of the
+// image's description, leaving other pages as they are. Images that cannot be
+// described are left out, as are all images without WithVision.
+func (r *RAGEngine) describeImages(ctx context.Context, pages []Page) []Page {
+	described := make([]Page, 0, len(pages))
+	skipped := 0
+	for _, page := range pages {
+		if page.Image == nil {
+			described = append(described, page)
+			continue
+		}
+		if r.vision == nil {
+			skipped++
+			continue
+		}
+		page, err := r.describeImage(ctx, page)
+		if err != nil {
+			errorsTotal.WithLabelValues("vision").Inc()
+			slog.ErrorContext(ctx, "Describing image failed", "source", page.Source, "error", err)
+			continue
+		}
+		described = append(described, page)
+	}
+	if skipped > 0 {
+		slog.WarnContext(ctx, "Skipping images, no vision model is configured", "images", skipped)
+	}
+	return described
+}
+
+// describeImage stores the page's image and turns the page into its
+// description, which is reused when the same image is ingested again.
+func (r *RAGEngine) describeImage(ctx context.Context, page Page) (Page, error) {
+	ctx, span := tracer.Start(ctx, "rag.describe_image", trace.WithAttributes(
+		attribute.String("rag.source", page.Source),
+		attribute.Int("rag.image_bytes", len(page.Image.Data)),
+	))
+	defer span.End()
+
+	id := contentHash(string(page.Image.Data)) + imageExtension(page.Image.MIMEType)
+	description, err := r.images.ImageDescription(ctx, id)
+	if errors.Is(err, ErrImageNotFound) {
+		prompt := "Describe this image so that it can be found by a search and used to answer questions about it. " +
+			"Say what kind of image it is and what it shows. Transcribe any text, labels, and numbers in it. " +
+			"For charts, tables, and diagrams, give the data, axes, components, and relationships they show. " +
+			"Reply with the description only."
+		if page.Text != "" {
+			prompt += "\n\nThe image comes from: " + page.Text
+		}
+		if description, err = r.vision.DescribeImage(ctx, r.visionModel, *page.Image, prompt); err == nil {
+			description = strings.TrimSpace(description)
+			err = r.images.PutImage(ctx, id, *page.Image, description)
+		}
+	}
+	if err != nil {
+		endSpan(span, err)
+		return page, err
+	}
+
+	page.Text = strings.TrimSpace(page.Text + "\n\n" + description)
+	page.Metadata = maps.Clone(page.Metadata)
+	if page.Metadata == nil {
+		page.Metadata = map[string]any{}
+	}
+	page.Metadata[imageIDField] = id
+	if _, ok := page.Metadata[imageURLField]; !ok {
+		page.Metadata[imageURLField] = "/images/" + id
+	}
+	page.Image = nil
+	page.Format = ""
+	return page, nil
+}
+
+// ErrImageNotFound is returned by an ImageStore for an unknown image ID.
+var ErrImageNotFound = errors.New("image not found")
+
+// ImageStore keeps the images ingested with WithVision and their
+// descriptions, keyed by an ID made of the hash of the image and its file
+// extension.
+type ImageStore interface {
+	PutImage(ctx context.Context, id string, image Image, description string) error
+	GetImage(ctx context.Context, id string) (Image, error)
+	ImageDescription(ctx context.Context, id string) (string, error)
+}
+
+// imageIDPattern matches the IDs describeImage gives images.
+var imageIDPattern = regexp.MustCompile(`^[0-9a-f]{64}\.(png|jpg|gif|webp)$`)
+
+// FileImageStore keeps each image, and its description next to it in a text
+// file, under dir, so that `rag serve` can serve images ingested by
+// `rag ingest`.
+type FileImageStore struct {
+	dir string
+}
+
+// NewFileImageStore creates dir if needed and stores images in it.
+func NewFileImageStore(dir string) (*FileImageStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileImageStore{dir: dir}, nil
+}
+
+// path shards images into subdirectories by ID prefix.
+func (f *FileImageStore) path(id string) (string, error) {
+	if !imageIDPattern.MatchString(id) {
+		return "", ErrImageNotFound
+	}
+	return filepath.Join(f.dir, id[:2], id), nil
+}
+
+func (f *FileImageStore) PutImage(ctx context.Context, id string, image Image, description string) error {
+	path, err := f.path(id)
+	if err != nil {
+		return fmt.Errorf("invalid image ID %q", id)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// The description is written last, so an image whose description can
+	// be read is complete.
+	for _, file := range []struct {
+		path string
+		data []byte
+	}{{path, image.Data}, {path + ".txt", []byte(description)}} {
+		tmp := file.path + ".tmp"
+		if err := os.WriteFile(tmp, file.data, 0o644); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, file.path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FileImageStore) GetImage(ctx context.Context, id string) (Image, error) {
+	data, err := f.read(id, "")
+	if err != nil {
+		return Image{}, err
+	}
+	return Image{Data: data, MIMEType: imageTypes[filepath.Ext(id)]}, nil
+}
+
+func (f *FileImageStore) ImageDescription(ctx context.Context, id string) (string, error) {
+	data, err := f.read(id, ".txt")
+	return string(data), err
+}
+
+// read returns the contents of the image's file with the given suffix.
+func (f *FileImageStore) read(id, suffix string) ([]byte, error) {
+	path, err := f.path(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path + suffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrImageNotFound
+	}
+	return data, err
+}
+
+// LoadImage loads an image file as a page to be described by the vision
+// model (see WithVision), with the file name as its source.
+func LoadImage(path string) (Page, error) {
+	mimeType := imageTypes[strings.ToLower(filepath.Ext(path))]
+	if mimeType == "" {
+		return Page{}, fmt.Errorf("%s: %w", path, ErrUnsupportedFile)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return Page{}, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxImageBytes+1))
+	if err != nil {
+		return Page{}, err
+	}
+	if len(data) > maxImageBytes {
+		return Page{}, fmt.Errorf("%s: image larger than %d MB", path, maxImageBytes>>20)
+	}
+	name := filepath.Base(path)
+	return Page{Text: "Image " + name, Source: name, Image: &Image{Data: data, MIMEType: mimeType}}, nil
+}
+
+// decodeImage checks that data is an image of a supported type, returning
+// it with its MIME type, which is detected when mimeType is empty.
+func decodeImage(data []byte, mimeType string) (Image, error) {
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+	if imageExtension(mimeType) == "" {
+		return Image{}, fmt.Errorf("unsupported image type %q (expected PNG, JPEG, GIF, or WebP)", mimeType)
+	}
+	if len(data) > maxImageBytes {
+		return Image{}, fmt.Errorf("image larger than %d MB", maxImageBytes>>20)
+	}
+	return Image{Data: data, MIMEType: mimeType}, nil
+}
+
+// handleImage serves an image stored by WithVision.
+func (s *Server) handleImage(w http.ResponseWriter, r *http.Request) {
+	if s.engine.images == nil {
+		writeError(w, http.StatusNotFound, "image not found")
+		return
+	}
+	image, err := s.engine.images.GetImage(r.Context(), r.PathValue("id"))
+	if errors.Is(err, ErrImageNotFound) {
+		writeError(w, http.StatusNotFound, "image not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Reading image failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "reading image failed")
+		return
+	}
+	// Image IDs are content hashes, so an ID always names the same image.
+	w.Header().Set("Content-Type", image.MIMEType)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Write(image.Data)
+}
+
+const (
+	// minFigurePixels is the width and height below which images in PDFs,
+	// such as icons and rules, are not treated as figures.
+	minFigurePixels = 100
+	// maxPDFFigures caps the figures taken from one PDF.
+	maxPDFFigures = 50
+)
+
+// pdfFigures returns the figures drawn on a PDF page: JPEG images as they
+// are stored, and 8-bit RGB or grayscale images converted to PNG. Images
+// in other encodings are skipped. raw is the whole PDF file, which JPEG data
+// is read from, since the PDF library cannot decode it.
+func pdfFigures(raw []byte, page pdf.Page) []Image {
+	xobjects := page.Resources().Key("XObject")
+	var figures []Image
+	for _, name := range xobjects.Keys() {
+		x := xobjects.Key(name)
+		if x.Key("Subtype").Name() != "Image" || x.Key("Width").Int64() < minFigurePixels || x.Key("Height").Int64() < minFigurePixels {
+			continue
+		}
+		var figure []byte
+		mimeType := "image/png"
+		switch x.Key("Filter").Name() {
+		case "DCTDecode":
+			figure, mimeType = pdfJPEG(raw, x.Key("Length").Int64()), "image/jpeg"
+		case "FlateDecode":
+			figure = pdfRasterPNG(x)
+		}
+		if figure != nil {
+			figures = append(figures, Image{Data: figure, MIMEType: mimeType})
+		}
+	}
+	return figures
+}
+
+// pdfJPEG finds JPEG data of the given length in the raw PDF: a stream that
+// starts with a JPEG start-of-image marker and ends with an end-of-image
+// marker exactly length bytes later.
+func pdfJPEG(raw []byte, length int64) []byte {
+	for offset := 0; ; {
+		i := bytes.Index(raw[offset:], []byte("stream"))
+		if i < 0 || length < 4 {
+			return nil
+		}
+		start := offset + i + len("stream")
+		offset = start
+		if bytes.HasPrefix(raw[start:], []byte("\r\n")) {
+			start += 2
+		} else if bytes.HasPrefix(raw[start:], []byte("\n")) {
+			start++
+		} else {
+			continue
+		}
+		end := start + int(length)
+		if end <= len(raw) && bytes.HasPrefix(raw[start:], []byte{0xFF, 0xD8}) && bytes.HasSuffix(raw[start:end], []byte{0xFF, 0xD9}) {
+			return raw[start:end]
+		}
+	}
+}
+
+// pdfRasterPNG decodes an 8-bit RGB or grayscale image stream to PNG, or
+// returns nil.
+func pdfRasterPNG(x pdf.Value) (encoded []byte) {
+	defer func() {
+		// The PDF library panics on streams it cannot decode.
+		if recover() != nil {
+			encoded = nil
+		}
+	}()
+	width, height := int(x.Key("Width").Int64()), int(x.Key("Height").Int64())
+	if x.Key("BitsPerComponent").Int64() != 8 {
+		return nil
+	}
+	var img image.Image
+	var components int
+	switch x.Key("ColorSpace").Name() {
+	case "DeviceRGB":
+		components = 3
+	case "DeviceGray":
+		components = 1
+	default:
+		return nil
+	}
+	rd := x.Reader()
+	defer rd.Close()
+	pixels, err := io.ReadAll(io.LimitReader(rd, int64(width*height*components)))
+	if err != nil || len(pixels) != width*height*components {
+		return nil
+	}
+	if components == 1 {
+		gray := image.NewGray(image.Rect(0, 0, width, height))
+		copy(gray.Pix, pixels)
+		img = gray
+	} else {
+		rgba := image.NewRGBA(image.Rect(0, 0, width, height))
+		for i := 0; i < width*height; i++ {
+			copy(rgba.Pix[i*4:i*4+3], pixels[i*3:i*3+3])
+			rgba.Pix[i*4+3] = 0xFF
+		}
+		img = rgba
+	}
+	var buf bytes.Buffer
+	if png.Encode(&buf, img) != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
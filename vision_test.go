@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type stubVision struct {
+	description string
+	prompts     []string
+}
+
+func (s *stubVision) DescribeImage(ctx context.Context, model string, image Image, prompt string) (string, error) {
+	s.prompts = append(s.prompts, prompt)
+	return s.description, nil
+}
+
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func newTestVision(t *testing.T) (*stubVision, *FileImageStore) {
+	t.Helper()
+	images, err := NewFileImageStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &stubVision{description: "A bar chart of monthly active users, peaking at 12,000 in March."}, images
+}
+
+func TestEngineIngestsImagesThroughDescriptions(t *testing.T) {
+	ctx := context.Background()
+	vision, images := newTestVision(t)
+	oa := &scriptedOpenAI{reply: "Usage peaked in March [1]."}
+	engine := NewRAGEngine(oa, NewMemoryStore(NewHashingEmbedder(256)), WithVision(vision, "vision-test", images))
+	pages := []Page{
+		{Text: "Figure 1 on page 2 of report.pdf", Source: "report.pdf", Metadata: map[string]any{"page": 2, "figure": 1}, Image: &Image{Data: testPNG(t), MIMEType: "image/png"}},
+		{Text: "The cafeteria serves lunch at noon.", Source: "lunch.md"},
+	}
+	if report, ok := ingestPages(ctx, engine, pages, 1000, 0); !ok || report.Inserted != 2 {
+		t.Fatalf("expected the description and the text to be inserted, got %+v", report)
+	}
+	if len(vision.prompts) != 1 || !strings.Contains(vision.prompts[0], "Figure 1 on page 2 of report.pdf") {
+		t.Fatalf("expected one description request with the caption, got %q", vision.prompts)
+	}
+
+	docs := engine.Retrieve(ctx, "When did monthly active users peak?", 1)
+	answer, err := engine.GenerateResponse(ctx, "When did monthly active users peak?", docs, "gpt-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(answer.Citations) != 1 || !strings.HasPrefix(answer.Citations[0].ImageURL, "/images/") {
+		t.Fatalf("expected a citation linking the chart, got %+v", answer.Citations)
+	}
+	id := strings.TrimPrefix(answer.Citations[0].ImageURL, "/images/")
+	if stored, err := images.GetImage(ctx, id); err != nil || stored.MIMEType != "image/png" {
+		t.Fatalf("expected the image to be stored, got %v, %v", stored.MIMEType, err)
+	}
+
+	// Unchanged images reuse their description; without vision they are skipped.
+	ingestPages(ctx, engine, pages, 1000, 0)
+	if len(vision.prompts) != 1 {
+		t.Fatalf("expected the stored description to be reused, got %d requests", len(vision.prompts))
+	}
+	plain := NewRAGEngine(oa, NewMemoryStore(NewHashingEmbedder(256)))
+	if report, _ := ingestPages(ctx, plain, pages, 1000, 0); report.Inserted != 1 {
+		t.Fatalf("expected only the text to be inserted without vision, got %+v", report)
+	}
+}
+
+func TestServerIngestsAndServesImages(t *testing.T) {
+	vision, images := newTestVision(t)
+	engine := NewRAGEngine(&scriptedOpenAI{reply: "ok"}, NewMemoryStore(NewHashingEmbedder(256)), WithVision(vision, "vision-test", images))
+	server := NewServer(engine, "gpt-test")
+	data := testPNG(t)
+	body := `{"documents":[{"text":"Usage chart","source":"usage.png","image":"` + base64.StdEncoding.EncodeToString(data) + `"}]}`
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/documents", strings.NewReader(body)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	id := contentHash(string(data)) + ".png"
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/images/"+id, nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/png" || !bytes.Equal(rec.Body.Bytes(), data) {
+		t.Fatalf("expected the image, got %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	for _, path := range []string{"/images/" + strings.Repeat("0", 64) + ".png", "/images/..%2Fimages.txt"} {
+		rec = httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", path, rec.Code)
+		}
+	}
+
+	plain, _ := newTestServer()
+	for request, want := range map[string]string{
+		body: "vision model",
+		`{"documents":[{"source":"a.png","image":"bm90IGFuIGltYWdl"}]}`: "unsupported image type",
+		`{"documents":[{"source":"a.png","image":"%%%"}]}`:              "base64",
+	} {
+		rec = httptest.NewRecorder()
+		plain.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/documents", strings.NewReader(request)))
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("expected 400 mentioning %q, got %d %s", want, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestAnthropicClientDescribesImages(t *testing.T) {
+	var got struct {
+		Messages []struct {
+			Content []map[string]any `json:"content"`
+		} `json:"messages"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		w.Write([]byte(`{"content":[{"type":"text","text":"a chart"}]}`))
+	}))
+	defer server.Close()
+
+	client := NewAnthropicClient("test-key")
+	client.baseURL = server.URL
+	description, err := client.DescribeImage(context.Background(), "claude-test", Image{Data: []byte("png"), MIMEType: "image/png"}, "Describe it.")
+	if err != nil || description != "a chart" {
+		t.Fatalf("unexpected description %q, %v", description, err)
+	}
+	if len(got.Messages) != 1 || len(got.Messages[0].Content) != 2 {
+		t.Fatalf("expected an image block and a text block, got %+v", got.Messages)
+	}
+	source, _ := got.Messages[0].Content[0]["source"].(map[string]any)
+	if source["media_type"] != "image/png" || source["data"] != base64.StdEncoding.EncodeToString([]byte("png")) {
+		t.Fatalf("unexpected image block %v", got.Messages[0].Content[0])
+	}
+	if got.Messages[0].Content[1]["text"] != "Describe it." {
+		t.Fatalf("unexpected text block %v", got.Messages[0].Content[1])
+	}
+}
+
+func TestPDFJPEGFindsTheStreamOfTheImage(t *testing.T) {
+	jpeg := []byte{0xFF, 0xD8, 0x01, 0x02, 0xFF, 0xD9}
+	raw := append([]byte("1 0 obj <</Length 2>> stream\nab\nendstream\n2 0 obj <</Length 6>> stream\r\n"), jpeg...)
+	raw = append(raw, "\nendstream"...)
+	if got := pdfJPEG(raw, 6); !bytes.Equal(got, jpeg) {
+		t.Fatalf("got %x, want %x", got, jpeg)
+	}
+	if got := pdfJPEG(raw, 5); got != nil {
+		t.Fatalf("expected no JPEG of the wrong length, got %x", got)
+	}
+}
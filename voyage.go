@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// voyageBatchSize is the most texts sent to Voyage AI per request.
+const voyageBatchSize = 128
+
+// voyageEmbeddingDimensions lists the default dimensions of Voyage models.
+var voyageEmbeddingDimensions = map[string]int{
+	"voyage-3.5":      1024,
+	"voyage-3.5-lite": 1024,
+	"voyage-3-large":  1024,
+	"voyage-3":        1024,
+	"voyage-3-lite":   512,
+	"voyage-code-3":   1024,
+}
+
+// VoyageEmbedder implements Embedder with Voyage AI's embeddings API.
+// Documents and queries are embedded with the matching input_type.
+type VoyageEmbedder struct {
+	baseURL    string
+	apiKey     string
+	model      string
+	dimension  int
+	shorten    bool // request dimension explicitly
+	httpClient *http.Client
+}
+
+// NewVoyageEmbedder embeds with model. A dimension of 0 uses the model's
+// default; voyage-3.5, voyage-3-large, and voyage-code-3 also return 256,
+// 512, or 2048 dimensions.
+func NewVoyageEmbedder(apiKey, model string, dimension int) (*VoyageEmbedder, error) {
+	size, err := embeddingDimension("Voyage", model, voyageEmbeddingDimensions, dimension)
+	if err != nil {
+		return nil, err
+	}
+	return &VoyageEmbedder{
+		baseURL:    "https://api.voyageai.com",
+		apiKey:     apiKey,
+		model:      model,
+		dimension:  size,
+		shorten:    size != voyageEmbeddingDimensions[model],
+		httpClient: http.DefaultClient,
+	}, nil
+}
+
+func (v *VoyageEmbedder) Dimension() int {
+	return v.dimension
+}
+
+type voyageEmbedRequest struct {
+	Input           []string `json:"input"`
+	Model           string   `json:"model"`
+	InputType       string   `json:"input_type"`
+	OutputDimension int      `json:"output_dimension,omitempty"`
+}
+
+type voyageEmbedResponse struct {
+	Data []struct {
+		Embedding []float32 `json:"embedding"`
+		Index     int       `json:"index"`
+	} `json:"data"`
+	Usage struct {
+		TotalTokens int `json:"total_tokens"`
+	} `json:"usage"`
+}
+
+func (v *VoyageEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	req := voyageEmbedRequest{Model: v.model, InputType: "document"}
+	if isQueryEmbedding(ctx) {
+		req.InputType = "query"
+	}
+	if v.shorten {
+		req.OutputDimension = v.dimension
+	}
+
+	var tokens int
+	embeddings, err := embedInBatches(texts, voyageBatchSize, func(batch []string) ([][]float32, error) {
+		req.Input = batch
+		var resp voyageEmbedResponse
+		if err := postJSON(ctx, v.httpClient, "Voyage", strings.TrimRight(v.baseURL, "/")+"/v1/embeddings", v.apiKey, req, &resp); err != nil {
+			return nil, err
+		}
+		if len(resp.Data) != len(batch) {
+			return nil, fmt.Errorf("expected %d embeddings from Voyage, got %d", len(batch), len(resp.Data))
+		}
+		embeddings := make([][]float32, len(batch))
+		for _, item := range resp.Data {
+			if item.Index < 0 || item.Index >= len(batch) {
+				return nil, fmt.Errorf("Voyage returned an embedding for unknown input %d", item.Index)
+			}
+			embeddings[item.Index] = item.Embedding
+		}
+		tokens += resp.Usage.TotalTokens
+		return embeddings, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	recordTokenUsage(ctx, tokens, 0)
+	return embeddings, nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watcher keeps the knowledge base in sync with a directory: files that are
+// created or modified are re-chunked and upserted, and files that are deleted
+// or moved away have their chunks removed. Sources are the files' paths
+// relative to the directory, as with IngestDirectory.
+type Watcher struct {
+	engine   *RAGEngine
+	dir      string
+	opts     DirectoryOptions
+	debounce time.Duration
+
+	mu    sync.Mutex
+	known map[string]bool // sources ingested from the directory
+}
+
+// NewWatcher creates a watcher for the files of dir selected by opts.
+// Changes are applied once a file has been quiet for debounce, so an editor
+// saving in several writes triggers one re-ingestion.
+func NewWatcher(engine *RAGEngine, dir string, opts DirectoryOptions, debounce time.Duration) *Watcher {
+	return &Watcher{engine: engine, dir: dir, opts: opts, debounce: debounce, known: make(map[string]bool)}
+}
+
+// Run ingests the directory once, then applies file changes until ctx is
+// canceled. Unchanged chunks are skipped by the ingestion's deduplication,
+// so only the chunks of edited files are written.
+func (w *Watcher) Run(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("starting file watcher: %w", err)
+	}
+	defer watcher.Close()
+	if err := w.watchTree(watcher, w.dir); err != nil {
+		return err
+	}
+
+	report, err := IngestDirectory(ctx, w.engine, w.dir, w.opts)
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	for _, rel := range w.files() {
+		w.known[rel] = true
+	}
+	w.mu.Unlock()
+	slog.InfoContext(ctx, "Initial sync complete", "dir", w.dir, "files", report.Files, "failed", len(report.Errors), "chunks", report.Chunks.String())
+
+	pending := make(map[string]bool)
+	timer := time.NewTimer(w.debounce)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := w.watchTree(watcher, event.Name); err != nil {
+						slog.WarnContext(ctx, "Watching new directory failed", "dir", event.Name, "error", err)
+					}
+					// Files written before the directory was watched.
+					filepath.WalkDir(event.Name, func(p string, d fs.DirEntry, err error) error {
+						if err == nil && !d.IsDir() {
+							pending[w.rel(p)] = true
+						}
+						return nil
+					})
+				}
+			}
+			if event.Op != fsnotify.Chmod {
+				pending[w.rel(event.Name)] = true
+				timer.Reset(w.debounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			slog.WarnContext(ctx, "File watcher error", "error", err)
+		case <-timer.C:
+			paths := make([]string, 0, len(pending))
+			for rel := range pending {
+				paths = append(paths, rel)
+			}
+			clear(pending)
+			w.Sync(ctx, paths)
+		}
+	}
+}
+
+// Sync brings the given paths, relative to the directory, up to date: files
+// that exist and are selected are ingested, and removed files, or every known
+// file under a removed directory, are deleted from the store.
+func (w *Watcher) Sync(ctx context.Context, paths []string) {
+	sort.Strings(paths)
+	for _, rel := range paths {
+		full := filepath.Join(w.dir, filepath.FromSlash(rel))
+		info, err := os.Stat(full)
+		switch {
+		case err == nil && info.IsDir():
+			continue
+		case err == nil:
+			if hiddenPath(rel) || !supportedFile(rel) || !selected(rel, w.opts.Include, w.opts.Exclude) {
+				continue
+			}
+			report, err := ingestFile(ctx, w.engine, w.dir, rel, w.opts.ChunkSize, w.opts.Overlap)
+			if err != nil {
+				slog.ErrorContext(ctx, "Re-ingesting file failed", "file", rel, "error", err)
+				continue
+			}
+			w.mu.Lock()
+			w.known[rel] = true
+			w.mu.Unlock()
+			slog.InfoContext(ctx, "Synced file", "file", rel, "inserted", report.Inserted, "updated", report.Updated, "skipped", report.Skipped, "removed", report.Removed, "dropped", report.Dropped)
+		case errors.Is(err, fs.ErrNotExist):
+			for _, source := range w.forget(rel) {
+				if err := w.engine.DeleteBySource(ctx, source); err != nil {
+					slog.ErrorContext(ctx, "Removing deleted file failed", "file", source, "error", err)
+					w.mu.Lock()
+					w.known[source] = true
+					w.mu.Unlock()
+					continue
+				}
+				slog.InfoContext(ctx, "Removed deleted file", "file", source)
+			}
+		default:
+			slog.WarnContext(ctx, "Reading changed file failed", "file", rel, "error", err)
+		}
+	}
+}
+
+// forget removes rel, or every known source under the directory rel, from
+// the known sources and returns them.
+func (w *Watcher) forget(rel string) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var removed []string
+	for source := range w.known {
+		if source == rel || strings.HasPrefix(source, rel+"/") {
+			removed = append(removed, source)
+			delete(w.known, source)
+		}
+	}
+	sort.Strings(removed)
+	return removed
+}
+
+// watchTree adds root and its non-hidden subdirectories to the watcher,
+// which does not watch recursively by itself.
+func (w *Watcher) watchTree(watcher *fsnotify.Watcher, root string) error {
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if p != w.dir && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if err := watcher.Add(p); err != nil {
+			return fmt.Errorf("watching %s: %w", p, err)
+		}
+		return nil
+	})
+}
+
+// files lists the selected files currently in the directory.
+func (w *Watcher) files() []string {
+	var files []string
+	filepath.WalkDir(w.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		rel := w.rel(p)
+		if !hiddenPath(rel) && supportedFile(rel) && selected(rel, w.opts.Include, w.opts.Exclude) {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	return files
+}
+
+// rel returns the slash-separated path of p relative to the directory.
+func (w *Watcher) rel(p string) string {
+	rel, err := filepath.Rel(w.dir, p)
+	if err != nil {
+		return filepath.ToSlash(p)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// hiddenPath reports whether any element of the slash-separated path rel
+// starts with a dot, like the files IngestDirectory skips.
+func hiddenPath(rel string) bool {
+	for _, elem := range strings.Split(rel, "/") {
+		if strings.HasPrefix(elem, ".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func storedSources(store *MemoryStore) map[string]int {
+	sources := make(map[string]int)
+	for _, doc := range store.SearchSimilar(context.Background(), "anything", 100, nil) {
+		sources[doc.Source]++
+	}
+	return sources
+}
+
+func TestWatcherSyncUpsertsAndRemovesFiles(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"a.md":       "# A\n\nFirst version.",
+		"sub/b.txt":  "Nested file.",
+		"sub/c.txt":  "Another nested file.",
+		"ignored.md": "Excluded by pattern.",
+	})
+	store := NewMemoryStore(NewHashingEmbedder(64))
+	engine := NewRAGEngine(&dummyOpenAI{}, store)
+	w := NewWatcher(engine, dir, DirectoryOptions{Exclude: []string{"ignored.md"}, ChunkSize: 1000}, time.Millisecond)
+	ctx := context.Background()
+
+	w.Sync(ctx, []string{"a.md", "sub/b.txt", "sub/c.txt", "ignored.md"})
+	if got := storedSources(store); len(got) != 3 || got["ignored.md"] != 0 {
+		t.Fatalf("unexpected sources after initial sync: %v", got)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "a.md"), []byte("# A\n\nSecond version."), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w.Sync(ctx, []string{"a.md"})
+	docs := store.SearchSimilar(ctx, "version", 10, Filter{Eq("source", "a.md")})
+	if len(docs) != 1 || docs[0].Text != "# A\n\nSecond version." {
+		t.Fatalf("expected only the new version of a.md, got %+v", docs)
+	}
+
+	if err := os.RemoveAll(filepath.Join(dir, "sub")); err != nil {
+		t.Fatal(err)
+	}
+	w.Sync(ctx, []string{"sub"})
+	if got := storedSources(store); len(got) != 1 || got["a.md"] != 1 {
+		t.Fatalf("expected the removed directory's files to be deleted, got %v", got)
+	}
+}
+
+func TestWatcherRunPicksUpChanges(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{"a.md": "# A\n\nAlpha."})
+	store := NewMemoryStore(NewHashingEmbedder(64))
+	engine := NewRAGEngine(&dummyOpenAI{}, store)
+	w := NewWatcher(engine, dir, DirectoryOptions{ChunkSize: 1000}, 20*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- w.Run(ctx) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Run returned error: %v", err)
+		}
+	}()
+
+	waitFor := func(what string, cond func(map[string]int) bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if cond(storedSources(store)) {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("timed out waiting for %s, have %v", what, storedSources(store))
+	}
+	waitFor("initial sync", func(s map[string]int) bool { return s["a.md"] == 1 })
+
+	if err := os.WriteFile(filepath.Join(dir, "b.txt"), []byte("Beta."), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("new file", func(s map[string]int) bool { return s["b.txt"] == 1 })
+
+	if err := os.Remove(filepath.Join(dir, "a.md")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("deleted file", func(s map[string]int) bool { return s["a.md"] == 0 })
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Webhook event types.
+const (
+	EventIngestionCompleted     = "ingestion.completed"
+	EventIngestionFailed        = "ingestion.failed"
+	EventSyncFailed             = "sync.failed"
+	EventEvalFinished           = "eval.finished"
+	EventUsageThresholdExceeded = "usage.threshold_exceeded"
+)
+
+var webhookEventTypes = []string{EventIngestionCompleted, EventIngestionFailed, EventSyncFailed, EventEvalFinished, EventUsageThresholdExceeded}
+
+// WebhookEvent is the JSON body of a webhook delivery.
+type WebhookEvent struct {
+	ID      string    `json:"id"`
+	Type    string    `json:"type"`
+	Created time.Time `json:"created"`
+	Tenant  string    `json:"tenant,omitempty"`
+	Data    any       `json:"data"`
+}
+
+// WebhookNotifier posts pipeline events to webhook URLs in the background.
+// Each body is signed with HMAC-SHA256 in the X-RAG-Signature header, see
+// VerifyWebhookSignature. A delivery that fails with a network error, 429, or
+// a 5xx status is retried with exponential backoff.
+type WebhookNotifier struct {
+	urls     []string
+	secret   string
+	events   []string // the event types delivered; nil delivers all
+	client   *http.Client
+	attempts int
+	backoff  time.Duration // before the first retry, doubling after each
+
+	wg sync.WaitGroup
+}
+
+// webhooks delivers the events of this process. main configures it from
+// WEBHOOK_URLS; nil delivers nothing.
+var webhooks *WebhookNotifier
+
+// NewWebhookNotifier returns a notifier that signs events with secret and
+// posts them to urls, only those of the given types if any are.
+func NewWebhookNotifier(urls []string, secret string, events []string) *WebhookNotifier {
+	return &WebhookNotifier{
+		urls:     urls,
+		secret:   secret,
+		events:   events,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		attempts: 4,
+		backoff:  time.Second,
+	}
+}
+
+// webhooksFromEnv configures a notifier from WEBHOOK_URLS (comma-separated),
+// WEBHOOK_SECRET, and WEBHOOK_EVENTS (comma-separated event types, default
+// all). It returns nil if WEBHOOK_URLS is unset.
+func webhooksFromEnv() (*WebhookNotifier, error) {
+	urls := splitPatterns(os.Getenv("WEBHOOK_URLS"))
+	if len(urls) == 0 {
+		return nil, nil
+	}
+	for _, u := range urls {
+		if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+			return nil, fmt.Errorf("invalid WEBHOOK_URLS entry %q (expected an http or https URL)", u)
+		}
+	}
+	secret := os.Getenv("WEBHOOK_SECRET")
+	if secret == "" {
+		return nil, errors.New("WEBHOOK_URLS needs WEBHOOK_SECRET to sign the events")
+	}
+	events := splitPatterns(os.Getenv("WEBHOOK_EVENTS"))
+	for _, event := range events {
+		if !slices.Contains(webhookEventTypes, event) {
+			return nil, fmt.Errorf("invalid WEBHOOK_EVENTS entry %q (expected %s)", event, strings.Join(webhookEventTypes, ", "))
+		}
+	}
+	return NewWebhookNotifier(urls, secret, events), nil
+}
+
+// Notify delivers an event of the given type to every URL in the
+// background. It does nothing on a nil notifier or for an event type that is
+// not subscribed.
+func (n *WebhookNotifier) Notify(eventType, tenant string, data any) {
+	if n == nil || (n.events != nil && !slices.Contains(n.events, eventType)) {
+		return
+	}
+	event := WebhookEvent{ID: newQueryID(), Type: eventType, Created: time.Now().UTC(), Tenant: tenant, Data: data}
+	body, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("Encoding webhook event failed", "event", eventType, "error", err)
+		return
+	}
+	for _, url := range n.urls {
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			if err := n.deliver(url, event, body); err != nil {
+				slog.Warn("Delivering webhook failed", "event", eventType, "delivery", event.ID, "url", url, "error", err)
+			}
+		}()
+	}
+}
+
+// Wait waits up to timeout for the deliveries in flight, so a command can
+// exit without dropping them.
+func (n *WebhookNotifier) Wait(timeout time.Duration) {
+	if n == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		slog.Warn("Gave up waiting for webhook deliveries")
+	}
+}
+
+// deliver posts body to url until it succeeds, fails permanently, or runs
+// out of attempts.
+func (n *WebhookNotifier) deliver(url string, event WebhookEvent, body []byte) error {
+	backoff := n.backoff
+	var err error
+	for attempt := 1; attempt <= n.attempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		var retry bool
+		if retry, err = n.post(url, event, body); err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// post sends one delivery attempt and reports whether a failure is worth
+// retrying.
+func (n *WebhookNotifier) post(url string, event WebhookEvent, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "rag-webhooks")
+	req.Header.Set("X-RAG-Event", event.Type)
+	req.Header.Set("X-RAG-Delivery", event.ID)
+	req.Header.Set("X-RAG-Signature", signWebhook(n.secret, time.Now(), body))
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("webhook answered %s", resp.Status)
+}
+
+// signWebhook returns the X-RAG-Signature header of body sent at t:
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<unix seconds>.<body>">".
+func signWebhook(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + webhookMAC(secret, ts, body)
+}
+
+func webhookMAC(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature checks the X-RAG-Signature header of a webhook
+// body against secret, for receivers written in Go. It rejects signatures
+// older than tolerance, which guards against replayed deliveries; a zero
+// tolerance skips the check.
+func VerifyWebhookSignature(secret, header string, body []byte, tolerance time.Duration) error {
+	var ts, sig string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			ts = value
+		case "v1":
+			sig = value
+		}
+	}
+	seconds, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || sig == "" {
+		return errors.New("malformed webhook signature")
+	}
+	if tolerance > 0 && time.Since(time.Unix(seconds, 0)).Abs() > tolerance {
+		return errors.New("webhook signature expired")
+	}
+	if !hmac.Equal([]byte(sig), []byte(webhookMAC(secret, ts, body))) {
+		return errors.New("webhook signature mismatch")
+	}
+	return nil
+}
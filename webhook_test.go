@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver records the deliveries it accepts, failing the first
+// failures requests with 503.
+type webhookReceiver struct {
+	mu         sync.Mutex
+	failures   int
+	attempts   int
+	events     []WebhookEvent
+	signatures []string
+	bodies     [][]byte
+}
+
+func (rcv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	rcv.attempts++
+	if rcv.attempts <= rcv.failures {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var event WebhookEvent
+	json.Unmarshal(body, &event)
+	if r.Header.Get("X-RAG-Event") != event.Type || r.Header.Get("X-RAG-Delivery") != event.ID {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	rcv.events = append(rcv.events, event)
+	rcv.signatures = append(rcv.signatures, r.Header.Get("X-RAG-Signature"))
+	rcv.bodies = append(rcv.bodies, body)
+}
+
+func TestWebhooksAreSignedAndRetried(t *testing.T) {
+	receiver := &webhookReceiver{failures: 2}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	notifier := NewWebhookNotifier([]string{server.URL}, "s3cret", []string{EventSyncFailed})
+	notifier.backoff = time.Millisecond
+	notifier.Notify(EventEvalFinished, "", nil) // not subscribed
+	notifier.Notify(EventSyncFailed, "acme", SyncRun{Job: "wiki", Error: "boom"})
+	notifier.Wait(5 * time.Second)
+
+	if receiver.attempts != 3 || len(receiver.events) != 1 {
+		t.Fatalf("expected one delivery after 3 attempts, got %d attempts and %+v", receiver.attempts, receiver.events)
+	}
+	event := receiver.events[0]
+	data, _ := event.Data.(map[string]any)
+	if event.Type != EventSyncFailed || event.Tenant != "acme" || data["job"] != "wiki" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	if err := VerifyWebhookSignature("s3cret", receiver.signatures[0], receiver.bodies[0], time.Minute); err != nil {
+		t.Fatalf("signature did not verify: %v", err)
+	}
+	if err := VerifyWebhookSignature("other", receiver.signatures[0], receiver.bodies[0], time.Minute); err == nil {
+		t.Fatal("expected a signature mismatch with the wrong secret")
+	}
+	old := signWebhook("s3cret", time.Now().Add(-time.Hour), receiver.bodies[0])
+	if err := VerifyWebhookSignature("s3cret", old, receiver.bodies[0], 5*time.Minute); err == nil {
+		t.Fatal("expected an old signature to be rejected")
+	}
+}
+
+func TestWebhooksFromEnv(t *testing.T) {
+	t.Setenv("WEBHOOK_URLS", "")
+	if n, err := webhooksFromEnv(); n != nil || err != nil {
+		t.Fatalf("expected no notifier, got %v, %v", n, err)
+	}
+	t.Setenv("WEBHOOK_URLS", "https://hooks.example.com/rag, http://localhost:9000")
+	if _, err := webhooksFromEnv(); err == nil {
+		t.Fatal("expected an error without WEBHOOK_SECRET")
+	}
+	t.Setenv("WEBHOOK_SECRET", "s3cret")
+	t.Setenv("WEBHOOK_EVENTS", "sync.failed,eval.finished")
+	n, err := webhooksFromEnv()
+	if err != nil || len(n.urls) != 2 || len(n.events) != 2 {
+		t.Fatalf("webhooksFromEnv = %+v, %v", n, err)
+	}
+	t.Setenv("WEBHOOK_EVENTS", "sync.started")
+	if _, err := webhooksFromEnv(); err == nil || !strings.Contains(err.Error(), "sync.started") {
+		t.Fatalf("expected an error for an unknown event, got %v", err)
+	}
+}
+
+func TestUsageThresholdWebhook(t *testing.T) {
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	previous := webhooks
+	webhooks = NewWebhookNotifier([]string{server.URL}, "s3cret", nil)
+	t.Cleanup(func() { webhooks = previous })
+
+	ledger := NewUsageLedger(filepath.Join(t.TempDir(), "usage.json"))
+	ledger.alertUSD = 1
+	for range 3 {
+		ledger.record(ModelUsage{Model: "gpt-4o", Kind: "chat", Requests: 1, InputTokens: 200_000, CostUSD: 0.5, Priced: true})
+		if err := ledger.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	webhooks.Wait(5 * time.Second)
+	if len(receiver.events) != 1 || receiver.events[0].Type != EventUsageThresholdExceeded {
+		t.Fatalf("expected one threshold event when crossing $1, got %+v", receiver.events)
+	}
+}
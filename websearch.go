@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// webField is the metadata field that marks a passage found by a web search
+// rather than in the knowledge base.
+const webField = "web"
+
+// WebSearch finds passages on the web. The engine consults it when the
+// knowledge base has no relevant content (see WithWebSearch).
+type WebSearch interface {
+	// Search returns up to limit results for query as documents whose
+	// Source is the page URL and whose Text is its title and snippet.
+	Search(ctx context.Context, query string, limit int) ([]Document, error)
+}
+
+// WithWebSearch makes the engine search the web when the knowledge base has
+// nothing relevant: when retrieval finds no document that clears the
+// similarity threshold or, with corrective retrieval, for CorrectWebSearch.
+// Web passages are labelled as such in the prompt and in citations.
+func WithWebSearch(search WebSearch) EngineOption {
+	return func(r *RAGEngine) {
+		r.webSearch = search
+	}
+}
+
+// isWebDocument reports whether doc came from a web search.
+func isWebDocument(doc Document) bool {
+	web, _ := doc.Metadata[webField].(bool)
+	return web
+}
+
+// hasRelevant reports whether any of docs clears the similarity threshold.
+func (r *RAGEngine) hasRelevant(docs []Document) bool {
+	for _, doc := range docs {
+		if doc.Similarity >= r.minSimilarity {
+			return true
+		}
+	}
+	return false
+}
+
+// searchWeb searches the web for query, returning the results marked as web
+// passages, or nil if the search fails.
+func (r *RAGEngine) searchWeb(ctx context.Context, query string, limit int) []Document {
+	ctx, span := tracer.Start(ctx, "websearch.search")
+	docs, err := r.webSearch.Search(ctx, query, limit)
+	span.SetAttributes(attribute.Int("websearch.results", len(docs)))
+	endSpan(span, err)
+	if err != nil {
+		webSearches.WithLabelValues("error").Inc()
+		errorsTotal.WithLabelValues("websearch").Inc()
+		slog.WarnContext(ctx, "Web search failed", "error", err)
+		return nil
+	}
+	if len(docs) == 0 {
+		webSearches.WithLabelValues("empty").Inc()
+		return nil
+	}
+	webSearches.WithLabelValues("results").Inc()
+	if len(docs) > limit {
+		docs = docs[:limit]
+	}
+	marked := make([]Document, len(docs))
+	for i, doc := range docs {
+		doc.Metadata = maps.Clone(doc.Metadata)
+		if doc.Metadata == nil {
+			doc.Metadata = map[string]any{}
+		}
+		doc.Metadata[webField] = true
+		if _, ok := doc.Metadata["url"]; !ok {
+			doc.Metadata["url"] = doc.Source
+		}
+		marked[i] = doc
+	}
+	slog.InfoContext(ctx, "Searched the web", "query", query, "results", len(marked))
+	return marked
+}
+
+// webResult builds the document for one search result.
+func webResult(title, link, snippet string) Document {
+	return Document{
+		Text:     strings.TrimSpace(title + "\n\n" + snippet),
+		Source:   link,
+		Metadata: map[string]any{"title": title, "url": link},
+	}
+}
+
+// BingSearch searches with the Bing Web Search API.
+type BingSearch struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+}
+
+// NewBingSearch creates a Bing searcher authenticated with a subscription key.
+func NewBingSearch(apiKey string) *BingSearch {
+	return &BingSearch{baseURL: "https://api.bing.microsoft.com", apiKey: apiKey, httpClient: http.DefaultClient}
+}
+
+func (b *BingSearch) Search(ctx context.Context, query string, limit int) ([]Document, error) {
+	params := url.Values{"q": {query}, "count": {strconv.Itoa(limit)}, "responseFilter": {"Webpages"}}
+	var resp struct {
+		WebPages struct {
+			Value []struct {
+				Name    string `json:"name"`
+				URL     string `json:"url"`
+				Snippet string `json:"snippet"`
+			} `json:"value"`
+		} `json:"webPages"`
+	}
+	header := http.Header{"Ocp-Apim-Subscription-Key": {b.apiKey}}
+	if err := getWebJSON(ctx, b.httpClient, "Bing", b.baseURL+"/v7.0/search?"+params.Encode(), header, &resp); err != nil {
+		return nil, err
+	}
+	var docs []Document
+	for _, page := range resp.WebPages.Value {
+		docs = append(docs, webResult(page.Name, page.URL, page.Snippet))
+	}
+	return docs, nil
+}
+
+// BraveSearch searches with the Brave Search API.
+type BraveSearch struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+}
+
+// NewBraveSearch creates a Brave searcher authenticated with an API key.
+func NewBraveSearch(apiKey string) *BraveSearch {
+	return &BraveSearch{baseURL: "https://api.search.brave.com", apiKey: apiKey, httpClient: http.DefaultClient}
+}
+
+func (b *BraveSearch) Search(ctx context.Context, query string, limit int) ([]Document, error) {
+	params := url.Values{"q": {query}, "count": {strconv.Itoa(limit)}}
+	var resp struct {
+		Web struct {
+			Results []struct {
+				Title       string `json:"title"`
+				URL         string `json:"url"`
+				Description string `json:"description"`
+			} `json:"results"`
+		} `json:"web"`
+	}
+	header := http.Header{"X-Subscription-Token": {b.apiKey}}
+	if err := getWebJSON(ctx, b.httpClient, "Brave", b.baseURL+"/res/v1/web/search?"+params.Encode(), header, &resp); err != nil {
+		return nil, err
+	}
+	var docs []Document
+	for _, result := range resp.Web.Results {
+		docs = append(docs, webResult(result.Title, result.URL, result.Description))
+	}
+	return docs, nil
+}
+
+// SearxNGSearch searches a self-hosted SearxNG instance, which must have the
+// JSON output format enabled.
+type SearxNGSearch struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewSearxNGSearch creates a searcher for the SearxNG instance at baseURL.
+func NewSearxNGSearch(baseURL string) *SearxNGSearch {
+	return &SearxNGSearch{baseURL: strings.TrimRight(baseURL, "/"), httpClient: http.DefaultClient}
+}
+
+func (s *SearxNGSearch) Search(ctx context.Context, query string, limit int) ([]Document, error) {
+	params := url.Values{"q": {query}, "format": {"json"}}
+	var resp struct {
+		Results []struct {
+			Title   string `json:"title"`
+			URL     string `json:"url"`
+			Content string `json:"content"`
+		} `json:"results"`
+	}
+	if err := getWebJSON(ctx, s.httpClient, "SearxNG", s.baseURL+"/search?"+params.Encode(), nil, &resp); err != nil {
+		return nil, err
+	}
+	var docs []Document
+	for _, result := range resp.Results {
+		if len(docs) == limit {
+			break
+		}
+		docs = append(docs, webResult(result.Title, result.URL, result.Content))
+	}
+	return docs, nil
+}
+
+// getWebJSON sends a GET request with header to a search API and decodes the
+// JSON reply.
+func getWebJSON(ctx context.Context, client *http.Client, provider, link string, header http.Header, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s search API error (status %d)", provider, resp.StatusCode)
+	}
+	return json.Unmarshal(data, out)
+}
+
+// webSearchFromEnv configures web search from WEB_SEARCH: "off" (the
+// default), "bing" or "brave" with WEB_SEARCH_API_KEY, or "searxng" with
+// SEARXNG_URL.
+func webSearchFromEnv() (WebSearch, error) {
+	provider := os.Getenv("WEB_SEARCH")
+	apiKey := os.Getenv("WEB_SEARCH_API_KEY")
+	switch provider {
+	case "", "off":
+		return nil, nil
+	case "bing", "brave":
+		if apiKey == "" {
+			return nil, fmt.Errorf("WEB_SEARCH=%s needs a WEB_SEARCH_API_KEY", provider)
+		}
+		if provider == "bing" {
+			return NewBingSearch(apiKey), nil
+		}
+		return NewBraveSearch(apiKey), nil
+	case "searxng":
+		baseURL := os.Getenv("SEARXNG_URL")
+		if baseURL == "" {
+			return nil, errors.New("WEB_SEARCH=searxng needs a SEARXNG_URL")
+		}
+		return NewSearxNGSearch(baseURL), nil
+	default:
+		return nil, fmt.Errorf("invalid WEB_SEARCH %q (expected off, bing, brave, or searxng)", provider)
+	}
+}
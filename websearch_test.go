@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebSearchProviders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("q") != "go history" {
+			t.Errorf("unexpected query %q", r.URL.Query().Get("q"))
+		}
+		switch r.URL.Path {
+		case "/v7.0/search":
+			if r.Header.Get("Ocp-Apim-Subscription-Key") != "bing-key" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"webPages":{"value":[{"name":"Go (language)","url":"https://en.wikipedia.org/wiki/Go","snippet":"Designed at Google."}]}}`))
+		case "/res/v1/web/search":
+			if r.Header.Get("X-Subscription-Token") != "brave-key" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"web":{"results":[{"title":"Go (language)","url":"https://en.wikipedia.org/wiki/Go","description":"Designed at Google."}]}}`))
+		case "/searx/search":
+			if r.URL.Query().Get("format") != "json" {
+				t.Errorf("expected JSON output")
+			}
+			w.Write([]byte(`{"results":[{"title":"Go (language)","url":"https://en.wikipedia.org/wiki/Go","content":"Designed at Google."},{"title":"Extra","url":"https://example.com","content":"x"}]}`))
+		}
+	}))
+	defer server.Close()
+
+	bing := NewBingSearch("bing-key")
+	bing.baseURL = server.URL
+	brave := NewBraveSearch("brave-key")
+	brave.baseURL = server.URL
+	for name, search := range map[string]WebSearch{"bing": bing, "brave": brave, "searxng": NewSearxNGSearch(server.URL + "/searx/")} {
+		docs, err := search.Search(context.Background(), "go history", 1)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(docs) != 1 || docs[0].Source != "https://en.wikipedia.org/wiki/Go" || docs[0].Text != "Go (language)\n\nDesigned at Google." {
+			t.Errorf("%s: unexpected results %+v", name, docs)
+		}
+	}
+
+	bing.apiKey = "wrong"
+	if _, err := bing.Search(context.Background(), "go history", 1); err == nil || !strings.Contains(err.Error(), "status 401") {
+		t.Fatalf("expected an API error, got %v", err)
+	}
+}
+
+func TestEngineSearchesTheWebWithoutRelevantContent(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(NewHashingEmbedder(256))
+	web := &stubWebSearch{results: []Document{webResult("Go (language)", "https://go.dev/doc", "Go was designed at Google in 2007.")}}
+	oa := &scriptedOpenAI{reply: "Go was designed at Google [1]."}
+	engine := NewRAGEngine(oa, store, WithWebSearch(web), WithMinSimilarity(0.9))
+	ingestPages(ctx, engine, []Page{{Text: "Milvus stores vectors.", Source: "milvus.md"}}, 1000, 0)
+
+	docs := engine.Retrieve(ctx, "Where was Go designed?", 3)
+	if len(docs) != 1 || !isWebDocument(docs[0]) || len(web.queries) != 1 {
+		t.Fatalf("expected the web result, got %+v", docs)
+	}
+	answer, err := engine.GenerateResponse(ctx, "Where was Go designed?", docs, "gpt-test")
+	if err != nil || answer.NoContext || len(answer.Citations) != 1 {
+		t.Fatalf("unexpected answer %+v, %v", answer, err)
+	}
+	if c := answer.Citations[0]; !c.Web || c.URL != "https://go.dev/doc" {
+		t.Fatalf("expected a web citation, got %+v", c)
+	}
+
+	// Relevant knowledge base content is answered from without searching.
+	engine.minSimilarity = 0
+	if docs := engine.Retrieve(ctx, "What stores vectors?", 3); len(docs) != 1 || isWebDocument(docs[0]) || len(web.queries) != 1 {
+		t.Fatalf("expected the knowledge base document, got %+v", docs)
+	}
+}
+
+func TestWebPassagesAreLabelledInThePrompt(t *testing.T) {
+	entry := formatContextEntry(0, Document{Text: "Designed at Google.", Source: "https://go.dev", Metadata: map[string]any{webField: true}})
+	if !strings.Contains(entry, "[1] Source: https://go.dev (web search result, not from the knowledge base)") {
+		t.Fatalf("unexpected context entry %q", entry)
+	}
+}
+
+func TestWebSearchFromEnv(t *testing.T) {
+	t.Setenv("WEB_SEARCH", "brave")
+	t.Setenv("WEB_SEARCH_API_KEY", "")
+	if _, err := webSearchFromEnv(); err == nil {
+		t.Fatal("expected brave without an API key to fail")
+	}
+	t.Setenv("WEB_SEARCH_API_KEY", "key")
+	if search, err := webSearchFromEnv(); err != nil {
+		t.Fatal(err)
+	} else if _, ok := search.(*BraveSearch); !ok {
+		t.Fatalf("expected a Brave searcher, got %T", search)
+	}
+	t.Setenv("WEB_SEARCH", "searxng")
+	t.Setenv("SEARXNG_URL", "http://localhost:8888")
+	if search, err := webSearchFromEnv(); err != nil {
+		t.Fatal(err)
+	} else if _, ok := search.(*SearxNGSearch); !ok {
+		t.Fatalf("expected a SearxNG searcher, got %T", search)
+	}
+	t.Setenv("WEB_SEARCH", "google")
+	if _, err := webSearchFromEnv(); err == nil {
+		t.Fatal("expected an unknown provider to fail")
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/websocket"
+)
+
+// chatRequest is a message from a /chat client: a question, with the options
+// of a /query request, or {"type": "reset"} to start the conversation over.
+type chatRequest struct {
+	Type string `json:"type,omitempty"` // "message" (the default) or "reset"
+	queryRequest
+}
+
+// chatEventJSON is a message to a /chat client.
+type chatEventJSON struct {
+	Type      string `json:"type"` // "session", "token", "answer", "error", or "reset"
+	SessionID string `json:"session_id,omitempty"`
+	Text      string `json:"text,omitempty"` // a piece of the answer, in token events
+	*queryResponse
+	Error      string   `json:"error,omitempty"`
+	Stage      string   `json:"stage,omitempty"` // for moderation errors, "query" or "answer"; for timeouts, the stage that timed out
+	Categories []string `json:"categories,omitempty"`
+}
+
+// chatHandler serves multi-turn chat over WebSocket connections. Each
+// connection is a session with its own Conversation: follow-up questions are
+// condensed with the earlier turns and answered with them in the prompt, as
+// with RAGEngine.Chat. Every answer streams as token events, followed by an
+// answer event with the citations and the query ID under which the turn is
+// recorded in the history. Questions sent while an answer is streaming are
+// answered in turn.
+func (s *Server) chatHandler() http.Handler {
+	return websocket.Server{
+		Handshake: s.checkChatOrigin,
+		Handler:   s.handleChat,
+	}
+}
+
+// AllowChatOrigins lets pages of origins, such as "https://chat.example.com",
+// open /chat from a browser, besides those served from the server's own
+// host.
+func (s *Server) AllowChatOrigins(origins []string) {
+	s.chatOrigins = nil
+	for _, origin := range origins {
+		if origin = strings.TrimSuffix(strings.TrimSpace(origin), "/"); origin != "" {
+			s.chatOrigins = append(s.chatOrigins, origin)
+		}
+	}
+}
+
+// checkChatOrigin rejects WebSocket handshakes from pages of other origins
+// than the server's host and those of AllowChatOrigins. Browsers let any
+// page open a WebSocket to any server, and without API keys nothing else
+// stops a page a visitor opens from chatting with the knowledge base
+// through the visitor's network. Clients other than browsers need not send
+// an Origin.
+func (s *Server) checkChatOrigin(config *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return nil
+	}
+	if slices.ContainsFunc(s.chatOrigins, func(allowed string) bool { return strings.EqualFold(allowed, origin) }) {
+		return nil
+	}
+	slog.WarnContext(r.Context(), "Rejected chat connection from another origin", "origin", origin)
+	return fmt.Errorf("origin %s is not allowed", origin)
+}
+
+func (s *Server) handleChat(ws *websocket.Conn) {
+	ws.MaxPayloadBytes = maxRequestBytes
+	engine := s.requestEngine(ws.Request())
+	ctx, cancel := context.WithCancel(ws.Request().Context())
+	defer cancel()
+
+	sessionID := newQueryID()
+	chatSessions.Inc()
+	defer chatSessions.Dec()
+	slog.InfoContext(ctx, "Chat session started", "session_id", sessionID)
+
+	// Read in the background so that a client disconnecting cancels the
+	// answer being generated.
+	messages := make(chan []byte)
+	go func() {
+		defer cancel()
+		for {
+			var data []byte
+			if err := websocket.Message.Receive(ws, &data); err != nil {
+				return
+			}
+			select {
+			case messages <- data:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	send := func(event chatEventJSON) error {
+		return websocket.JSON.Send(ws, event)
+	}
+	if send(chatEventJSON{Type: "session", SessionID: sessionID}) != nil {
+		return
+	}
+	conv := engine.NewConversation()
+	turns := 0
+	defer func() {
+		slog.InfoContext(ctx, "Chat session ended", "session_id", sessionID, "turns", turns)
+	}()
+	for {
+		var data []byte
+		select {
+		case data = <-messages:
+		case <-ctx.Done():
+			return
+		}
+		var req chatRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			if send(chatEventJSON{Type: "error", Error: "invalid JSON message: " + err.Error()}) != nil {
+				return
+			}
+			continue
+		}
+		switch req.Type {
+		case "reset":
+			conv = engine.NewConversation()
+			if send(chatEventJSON{Type: "reset"}) != nil {
+				return
+			}
+			continue
+		case "", "message":
+		default:
+			if send(chatEventJSON{Type: "error", Error: "unknown message type " + req.Type}) != nil {
+				return
+			}
+			continue
+		}
+
+		model, opts, err := s.parseQueryOptions(&req.queryRequest)
+		if err == nil {
+			err = req.answerOnly()
+		}
+		if err != nil {
+			if send(chatEventJSON{Type: "error", Error: err.Error()}) != nil {
+				return
+			}
+			continue
+		}
+		usage := &RequestUsage{}
+		grading := &RelevanceReport{}
+		queryID := newQueryID()
+		timeouts := &TimeoutReport{}
+		turnCtx := withQueryID(withTimeoutReport(withRelevanceReport(withRequestUsage(ctx, usage), grading), timeouts), queryID)
+		turnCtx, cancelTurn := engine.withQueryTimeout(turnCtx)
+		answer, err := engine.ChatStream(turnCtx, conv, req.Question, req.Limit, model, func(delta string) error {
+			return send(chatEventJSON{Type: "token", Text: delta})
+		}, opts...)
+		err = stageTimedOut(turnCtx, stageQuery, err)
+		cancelTurn()
+
+		var event chatEventJSON
+		var policyErr *PolicyError
+		var timeoutErr *StageTimeoutError
+		switch {
+		case errors.As(err, &policyErr):
+			event = chatEventJSON{Type: "error", Error: policyErr.Error(), Stage: policyErr.Stage, Categories: policyErr.Categories}
+		case ctx.Err() != nil:
+			return
+		case errors.As(err, &timeoutErr):
+			event = chatEventJSON{Type: "error", Error: timeoutErr.Error(), Stage: timeoutErr.Stage}
+		case err != nil:
+			slog.ErrorContext(turnCtx, "Chat turn failed", "session_id", sessionID, "error", err)
+			event = chatEventJSON{Type: "error", Error: "generating answer failed"}
+		default:
+			turns++
+			resp := newQueryResponse(answer)
+			resp.QueryID = queryID
+			resp.Grading = newGradingJSON(grading)
+			resp.Usage = usage
+			resp.TimedOut = timeouts.Stages()
+			event = chatEventJSON{Type: "answer", queryResponse: &resp}
+		}
+		if send(event) != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+// chatEvent decodes chatEventJSON messages.
+type chatEvent struct {
+	Type      string `json:"type"`
+	SessionID string `json:"session_id"`
+	Text      string `json:"text"`
+	Error     string `json:"error"`
+	queryResponse
+}
+
+func dialChat(t *testing.T, server *httptest.Server, query string) *websocket.Conn {
+	t.Helper()
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/chat"+query, "", server.URL)
+	if err != nil {
+		t.Fatalf("dialing /chat: %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+// chatTurn sends a message and reads events up to and including the first
+// answer or error event.
+func chatTurn(t *testing.T, ws *websocket.Conn, message string) (tokens string, last chatEvent) {
+	t.Helper()
+	if err := websocket.Message.Send(ws, message); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		var event chatEvent
+		if err := websocket.JSON.Receive(ws, &event); err != nil {
+			t.Fatalf("reading chat event: %v", err)
+		}
+		switch event.Type {
+		case "token":
+			tokens += event.Text
+		case "answer", "error", "reset":
+			return tokens, event
+		default:
+			t.Fatalf("unexpected %q event", event.Type)
+		}
+	}
+}
+
+func TestServerChatsOverWebSocket(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(NewHashingEmbedder(256))
+	history := newTestHistory(t)
+	engine := NewRAGEngine(&streamingOpenAI{pieces: []string{"Go was made ", "at Google [1]."}}, store, WithHistory(history))
+	store.InsertDocuments(ctx, []string{"Go is a language from Google."}, []string{"Go Docs"}, nil)
+	server := httptest.NewServer(NewServer(engine, "gpt-test"))
+	defer server.Close()
+
+	ws := dialChat(t, server, "")
+	var session chatEvent
+	if err := websocket.JSON.Receive(ws, &session); err != nil || session.Type != "session" || session.SessionID == "" {
+		t.Fatalf("expected a session event, got %+v, %v", session, err)
+	}
+
+	tokens, first := chatTurn(t, ws, `{"question":"Who made Go?","limit":1}`)
+	if first.Type != "answer" || tokens != "Go was made at Google [1]." || first.Answer != tokens || len(first.Citations) != 1 {
+		t.Fatalf("unexpected first turn: tokens %q, event %+v", tokens, first)
+	}
+	_, second := chatTurn(t, ws, `{"question":"When?","limit":1}`)
+	if second.Type != "answer" || second.QueryID == first.QueryID {
+		t.Fatalf("expected a second answer with its own query ID, got %+v", second)
+	}
+	record, err := history.GetQuery(ctx, second.QueryID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var prompt strings.Builder
+	for _, m := range record.Prompt {
+		prompt.WriteString(m.Content)
+	}
+	if record.Question != "When?" || !strings.Contains(prompt.String(), "Who made Go?") {
+		t.Fatalf("expected the follow-up to be answered with the first turn, got %+v", record)
+	}
+
+	if _, event := chatTurn(t, ws, `{"type":"reset"}`); event.Type != "reset" {
+		t.Fatalf("expected a reset event, got %+v", event)
+	}
+	for message, want := range map[string]string{
+		`not json`:                 "invalid JSON message",
+		`{"question":""}`:          "question is required",
+		`{"type":"typing"}`:        "unknown message type",
+		`{"question":"x","mmr":2}`: "mmr must be between 0 and 1",
+	} {
+		if _, event := chatTurn(t, ws, message); event.Type != "error" || !strings.Contains(event.Error, want) {
+			t.Fatalf("%s: expected an error containing %q, got %+v", message, want, event)
+		}
+	}
+	// The session survives invalid messages.
+	if _, event := chatTurn(t, ws, `{"question":"Who made Go?"}`); event.Type != "answer" {
+		t.Fatalf("expected an answer after errors, got %+v", event)
+	}
+}
+
+func TestServerChatRequiresAPIKey(t *testing.T) {
+	s, _ := newTestServer()
+	if err := s.RequireAPIKeys([]APIKey{{Key: "sk-acme", Tenant: "acme"}}); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	if ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/chat", "", server.URL); err == nil {
+		ws.Close()
+		t.Fatal("expected the handshake to fail without an API key")
+	}
+	ws := dialChat(t, server, "?api_key=sk-acme")
+	var session chatEvent
+	websocket.JSON.Receive(ws, &session)
+	if _, event := chatTurn(t, ws, `{"question":"Who made Go?"}`); event.Type != "answer" || event.Answer != "Go was made at Google [1]." {
+		t.Fatalf("expected an answer, got %+v", event)
+	}
+}
+
+func TestServerChatChecksOrigin(t *testing.T) {
+	s, _ := newTestServer()
+	s.AllowChatOrigins([]string{" https://chat.example.com/ "})
+	server := httptest.NewServer(s)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/chat"
+
+	if ws, err := websocket.Dial(url, "", "https://evil.example.com"); err == nil {
+		ws.Close()
+		t.Fatal("expected the handshake from a foreign origin to fail")
+	}
+	for _, origin := range []string{server.URL, "https://chat.example.com"} {
+		ws, err := websocket.Dial(url, "", origin)
+		if err != nil {
+			t.Fatalf("expected origin %s to be allowed: %v", origin, err)
+		}
+		ws.Close()
+	}
+}
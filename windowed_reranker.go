@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WindowedReranker reranks long candidate lists, such as the top 100 of an
+// over-fetched search, that are too long to score in one call. The
+// candidates are split into windows that the scorer, ideally a cheap model,
+// rates concurrently; the scores are merged into one ranking; and the top
+// window of that ranking is rated once more side by side, since scores from
+// separate calls are only roughly comparable. A window that fails is scored
+// locally instead, scaled to the scorer's scores.
+type WindowedReranker struct {
+	Scorer PassageScorer
+	// Window is the number of candidates per call; 0 means 20.
+	Window int
+	// Concurrency caps the windows scored at once; 0 means 4.
+	Concurrency int
+}
+
+// Rerank scores the candidates in windows and orders them by the merged
+// scores.
+func (w *WindowedReranker) Rerank(ctx context.Context, query string, docs []Document) ([]Document, error) {
+	if len(docs) < 2 {
+		return docs, nil
+	}
+	size := w.Window
+	if size <= 0 {
+		size = 20
+	}
+	concurrency := w.Concurrency
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+
+	var windows [][]Document
+	for start := 0; start < len(docs); start += size {
+		windows = append(windows, docs[start:min(start+size, len(docs))])
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("rag.rerank_windows", len(windows)))
+
+	windowScores := make([][]float64, len(windows))
+	failed := make([]bool, len(windows))
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, concurrency)
+	for i, window := range windows {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			scores, err := w.Scorer.Score(ctx, query, window)
+			if err != nil {
+				slog.WarnContext(ctx, "Scoring a rerank window failed, scoring it locally", "window", i+1, "error", err)
+				failed[i] = true
+				return
+			}
+			windowScores[i] = scores
+		}()
+	}
+	wg.Wait()
+
+	// Local scores run from 0 to 1; stretch them to the highest score the
+	// scorer gave, so a failed window neither dominates nor sinks.
+	scale := 0.0
+	for i := range windows {
+		if !failed[i] {
+			scale = max(scale, slices.Max(windowScores[i]))
+		}
+	}
+	if scale <= 0 {
+		scale = 1
+	}
+	scores := make([]float64, 0, len(docs))
+	for i, window := range windows {
+		if failed[i] {
+			local, _ := (&KeywordReranker{}).Score(ctx, query, window)
+			for j := range local {
+				local[j] *= scale
+			}
+			windowScores[i] = local
+		}
+		scores = append(scores, windowScores[i]...)
+	}
+	ranked := sortByScores(docs, scores)
+	if len(windows) == 1 {
+		return ranked, nil
+	}
+
+	top := ranked[:size]
+	if topScores, err := w.Scorer.Score(ctx, query, top); err != nil {
+		slog.WarnContext(ctx, "Rescoring the top rerank window failed, keeping the merged order", "error", err)
+	} else {
+		ranked = append(sortByScores(top, topScores), ranked[size:]...)
+	}
+	slog.DebugContext(ctx, "Reranked candidates in windows", "candidates", len(docs), "windows", len(windows))
+	return ranked, nil
+}
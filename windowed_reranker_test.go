@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// sourceScorer scores documents by a table keyed on source, switching to
+// rescores after the first windows calls, and records the windows it was
+// asked about. Windows containing fail fail.
+type sourceScorer struct {
+	mu       sync.Mutex
+	scores   map[string]float64
+	rescores map[string]float64
+	windows  int
+	fail     string
+	asked    [][]string
+}
+
+func (s *sourceScorer) Score(ctx context.Context, query string, docs []Document) ([]float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var sources []string
+	table := s.scores
+	if s.rescores != nil && len(s.asked) >= s.windows {
+		table = s.rescores
+	}
+	scores := make([]float64, len(docs))
+	for i, doc := range docs {
+		sources = append(sources, doc.Source)
+		scores[i] = table[doc.Source]
+	}
+	s.asked = append(s.asked, sources)
+	if slices.Contains(sources, s.fail) {
+		return nil, errors.New("rate limited")
+	}
+	return scores, nil
+}
+
+func TestWindowedRerankerMergesWindowsAndRescoresTheTop(t *testing.T) {
+	var docs []Document
+	// Seen side by side, d4 beats d5 and d6.
+	scorer := &sourceScorer{scores: map[string]float64{}, rescores: map[string]float64{"d4": 9, "d5": 8, "d6": 7}, windows: 3}
+	for i := range 7 {
+		source := fmt.Sprintf("d%d", i)
+		docs = append(docs, Document{Source: source, Text: "unrelated text", Similarity: 0.5})
+		scorer.scores[source] = float64(i)
+	}
+
+	reranked, err := (&WindowedReranker{Scorer: scorer, Window: 3}).Rerank(context.Background(), "q", docs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, doc := range reranked {
+		got = append(got, doc.Source)
+	}
+	if want := []string{"d4", "d5", "d6", "d3", "d2", "d1", "d0"}; !slices.Equal(got, want) {
+		t.Fatalf("got order %v, want %v", got, want)
+	}
+	if len(scorer.asked) != 4 || !slices.Equal(scorer.asked[3], []string{"d6", "d5", "d4"}) {
+		t.Fatalf("expected three windows and the top rescored, got %v", scorer.asked)
+	}
+}
+
+func TestWindowedRerankerScoresFailedWindowsLocally(t *testing.T) {
+	docs := []Document{
+		{Source: "a", Text: "billing runs monthly", Similarity: 0.5},
+		{Source: "b", Text: "unrelated", Similarity: 0.5},
+		{Source: "c", Text: "invoices are stored in postgres", Similarity: 0.9},
+		{Source: "d", Text: "unrelated", Similarity: 0.1},
+	}
+	scorer := &sourceScorer{scores: map[string]float64{"a": 4, "b": 1}, fail: "c"}
+	reranked, err := (&WindowedReranker{Scorer: scorer, Window: 2}).Rerank(context.Background(), "where are invoices stored", docs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Locally c scores 0.95, scaled by a's 4 to 3.8; the rescoring of the
+	// top window fails because it holds c, so the merged order stays.
+	var got []string
+	for _, doc := range reranked {
+		got = append(got, doc.Source)
+	}
+	if strings.Join(got, "") != "acbd" {
+		t.Fatalf("got order %v", got)
+	}
+}
+
+func TestRerankDepthOverfetchesCandidates(t *testing.T) {
+	mv := &dummyMilvus{}
+	engine := NewRAGEngine(&dummyOpenAI{}, mv, WithReranker(&KeywordReranker{}), WithRerankDepth(100))
+	engine.Retrieve(context.Background(), "q", 5)
+	if mv.lastLimit != 100 {
+		t.Fatalf("expected 100 candidates, got %d", mv.lastLimit)
+	}
+	engine = NewRAGEngine(&dummyOpenAI{}, mv, WithReranker(&KeywordReranker{}), WithRerankDepth(4))
+	engine.Retrieve(context.Background(), "q", 5)
+	if mv.lastLimit != 15 {
+		t.Fatalf("expected the default overfetch of 15, got %d", mv.lastLimit)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxWordPieceRunes is the longest word split into word pieces; longer words
+// become [UNK], as in BERT's reference tokenizer.
+const maxWordPieceRunes = 100
+
+// WordPieceTokenizer implements the uncased BERT tokenizer used by
+// sentence-transformers models such as all-MiniLM-L6-v2 and bge-small-en:
+// text is lowercased, stripped of accents, and split on whitespace,
+// punctuation, and CJK characters, and each word is split greedily into the
+// longest pieces found in the vocabulary.
+type WordPieceTokenizer struct {
+	vocab map[string]int64
+	cls   int64
+	sep   int64
+	unk   int64
+}
+
+// LoadWordPieceTokenizer reads a vocab.txt file with one token per line, the
+// line number being the token ID.
+func LoadWordPieceTokenizer(path string) (*WordPieceTokenizer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var tokens []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		tokens = append(tokens, strings.TrimRight(scanner.Text(), "\r"))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading vocabulary %s: %w", path, err)
+	}
+	return NewWordPieceTokenizer(tokens)
+}
+
+// NewWordPieceTokenizer builds a tokenizer from the vocabulary in ID order.
+// It must contain [CLS], [SEP], and [UNK].
+func NewWordPieceTokenizer(tokens []string) (*WordPieceTokenizer, error) {
+	t := &WordPieceTokenizer{vocab: make(map[string]int64, len(tokens))}
+	for id, token := range tokens {
+		t.vocab[token] = int64(id)
+	}
+	for _, special := range []struct {
+		token string
+		id    *int64
+	}{{"[CLS]", &t.cls}, {"[SEP]", &t.sep}, {"[UNK]", &t.unk}} {
+		id, ok := t.vocab[special.token]
+		if !ok {
+			return nil, fmt.Errorf("vocabulary has no %s token", special.token)
+		}
+		*special.id = id
+	}
+	return t, nil
+}
+
+// Encode returns the token IDs of text wrapped in [CLS] and [SEP], truncated
+// to at most maxLen IDs.
+func (t *WordPieceTokenizer) Encode(text string, maxLen int) []int64 {
+	ids := []int64{t.cls}
+	for _, word := range basicTokens(text) {
+		ids = append(ids, t.wordPieces(word)...)
+		if len(ids) >= maxLen-1 {
+			ids = ids[:maxLen-1]
+			break
+		}
+	}
+	return append(ids, t.sep)
+}
+
+// wordPieces splits word greedily into the longest vocabulary entries, with
+// continuation pieces prefixed by "##".
+func (t *WordPieceTokenizer) wordPieces(word string) []int64 {
+	runes := []rune(word)
+	if len(runes) > maxWordPieceRunes {
+		return []int64{t.unk}
+	}
+	var ids []int64
+	for start := 0; start < len(runes); {
+		end := len(runes)
+		found := false
+		for ; end > start; end-- {
+			piece := string(runes[start:end])
+			if start > 0 {
+				piece = "##" + piece
+			}
+			if id, ok := t.vocab[piece]; ok {
+				ids = append(ids, id)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return []int64{t.unk}
+		}
+		start = end
+	}
+	return ids
+}
+
+// basicTokens lowercases text, strips accents, and splits it into words,
+// with every punctuation mark and CJK character a word of its own.
+func basicTokens(text string) []string {
+	var words []string
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			words = append(words, word.String())
+			word.Reset()
+		}
+	}
+	for _, r := range norm.NFD.String(strings.ToLower(text)) {
+		switch {
+		case unicode.Is(unicode.Mn, r), r == 0, r == unicode.ReplacementChar, unicode.IsControl(r) && !unicode.IsSpace(r):
+			// accents and control characters are dropped
+		case unicode.IsSpace(r):
+			flush()
+		case isBERTPunctuation(r), isCJK(r):
+			flush()
+			words = append(words, string(r))
+		default:
+			word.WriteRune(r)
+		}
+	}
+	flush()
+	return words
+}
+
+// isBERTPunctuation counts all non-alphanumeric ASCII as punctuation, as BERT
+// does, along with Unicode punctuation.
+func isBERTPunctuation(r rune) bool {
+	if (r >= 33 && r <= 47) || (r >= 58 && r <= 64) || (r >= 91 && r <= 96) || (r >= 123 && r <= 126) {
+		return true
+	}
+	return unicode.IsPunct(r)
+}
+
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r)
+}
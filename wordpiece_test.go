@@ -0,0 +1,29 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWordPieceTokenizerEncode(t *testing.T) {
+	tokenizer, err := NewWordPieceTokenizer([]string{"[PAD]", "[UNK]", "[CLS]", "[SEP]", "the", "cafe", "emb", "##edd", "##ing", "##s", ",", "!", "中"})
+	if err != nil {
+		t.Fatalf("NewWordPieceTokenizer returned error: %v", err)
+	}
+
+	got := tokenizer.Encode("The Café, embeddings! 中 xyz", 32)
+	want := []int64{2, 4, 5, 10, 6, 7, 8, 9, 11, 12, 1, 3}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	if got := tokenizer.Encode("the the the the", 4); !reflect.DeepEqual(got, []int64{2, 4, 4, 3}) {
+		t.Fatalf("expected truncation to 4 IDs, got %v", got)
+	}
+}
+
+func TestWordPieceTokenizerRequiresSpecialTokens(t *testing.T) {
+	if _, err := NewWordPieceTokenizer([]string{"[CLS]", "[SEP]"}); err == nil {
+		t.Fatalf("expected an error for a vocabulary without [UNK]")
+	}
+}